@@ -0,0 +1,167 @@
+package benchmark
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tevino/abool"
+)
+
+// Workload Names
+const (
+	WorkloadDNS         = "dns"
+	WorkloadResolver    = "resolver"
+	WorkloadConnections = "connections"
+	WorkloadDecisions   = "decisions"
+	WorkloadFilterLists = "filterlists"
+)
+
+// ErrAlreadyRunning is returned when a benchmark is started while another one
+// is still running.
+var ErrAlreadyRunning = errors.New("a benchmark is already running")
+
+// Report holds the results of a benchmark run.
+type Report struct {
+	Started  time.Time
+	Duration time.Duration
+	Results  []*Result
+}
+
+// Result holds the measurements of a single workload.
+type Result struct {
+	Workload    string
+	Description string
+
+	Operations int
+	Errors     int
+	LastError  string `json:",omitempty"`
+
+	Duration   time.Duration
+	Throughput float64 // operations per second
+
+	AvgLatency time.Duration
+	P50Latency time.Duration
+	P95Latency time.Duration
+	MaxLatency time.Duration
+
+	// AddedLatency is the latency added in comparison to the baseline
+	// workload, if there is one.
+	AddedLatency time.Duration `json:",omitempty"`
+}
+
+// Options configures a benchmark run.
+type Options struct {
+	// Domain is the domain used for DNS queries and connections.
+	Domain string
+	// Operations is the amount of operations per workload.
+	Operations int
+	// Concurrency is the amount of parallel workers per workload.
+	Concurrency int
+}
+
+var running = abool.New()
+
+// Run runs all workloads against the live configuration and returns the
+// report.
+func Run(ctx context.Context, opts Options) (*Report, error) {
+	if !running.SetToIf(false, true) {
+		return nil, ErrAlreadyRunning
+	}
+	defer running.UnSet()
+
+	report := &Report{
+		Started: time.Now(),
+	}
+
+	// Resolve the domain first, so that the DNS workloads are answered from
+	// the cache and the connections get a realistic destination.
+	ips, err := warmup(ctx, opts.Domain)
+	if err != nil {
+		return nil, err
+	}
+
+	resolverResult := benchmarkResolver(ctx, opts)
+	dnsResult := benchmarkDNS(ctx, opts)
+	dnsResult.AddedLatency = dnsResult.AvgLatency - resolverResult.AvgLatency
+	report.Results = append(report.Results,
+		dnsResult,
+		resolverResult,
+		benchmarkConnections(ctx, opts),
+		benchmarkDecisions(ctx, opts, ips),
+		benchmarkFilterLists(ctx, opts, ips),
+	)
+
+	report.Duration = time.Since(report.Started)
+	return report, nil
+}
+
+// measure runs op the configured amount of times with the configured
+// concurrency and returns the measurements.
+func measure(ctx context.Context, workload, description string, opts Options, op func(ctx context.Context, i int) error) *Result {
+	result := &Result{
+		Workload:    workload,
+		Description: description,
+	}
+
+	latencies := make([]time.Duration, opts.Operations)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+
+	start := time.Now()
+	for w := 0; w < opts.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				opStart := time.Now()
+				err := op(ctx, i)
+				latencies[i] = time.Since(opStart)
+
+				if err != nil {
+					lock.Lock()
+					result.Errors++
+					result.LastError = err.Error()
+					lock.Unlock()
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := 0; i < opts.Operations; i++ {
+		select {
+		case next <- i:
+			result.Operations++
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+	result.Duration = time.Since(start)
+
+	latencies = latencies[:result.Operations]
+	if len(latencies) == 0 {
+		return result
+	}
+	sort.Slice(latencies, func(i, j int) bool {
+		return latencies[i] < latencies[j]
+	})
+	var total time.Duration
+	for _, latency := range latencies {
+		total += latency
+	}
+	result.AvgLatency = total / time.Duration(len(latencies))
+	result.P50Latency = latencies[len(latencies)*50/100]
+	result.P95Latency = latencies[len(latencies)*95/100]
+	result.MaxLatency = latencies[len(latencies)-1]
+	if result.Duration > 0 {
+		result.Throughput = float64(result.Operations) / result.Duration.Seconds()
+	}
+
+	return result
+}
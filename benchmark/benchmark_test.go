@@ -0,0 +1,66 @@
+package benchmark
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMeasure(t *testing.T) {
+	opts := Options{
+		Operations:  100,
+		Concurrency: 4,
+	}
+	result := measure(context.Background(), "test", "", opts, func(ctx context.Context, i int) error {
+		time.Sleep(time.Duration(i) * time.Microsecond)
+		if i%10 == 0 {
+			return errors.New("test error")
+		}
+		return nil
+	})
+
+	if result.Operations != 100 {
+		t.Errorf("expected 100 operations, got %d", result.Operations)
+	}
+	if result.Errors != 10 {
+		t.Errorf("expected 10 errors, got %d", result.Errors)
+	}
+	if result.P50Latency > result.P95Latency || result.P95Latency > result.MaxLatency {
+		t.Errorf("percentiles are not ordered: %+v", result)
+	}
+	if result.Throughput <= 0 {
+		t.Errorf("expected positive throughput, got %f", result.Throughput)
+	}
+
+	// Canceled runs stop feeding operations.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result = measure(ctx, "test", "", opts, func(ctx context.Context, i int) error {
+		return nil
+	})
+	if result.Operations == opts.Operations {
+		t.Error("canceled run executed all operations")
+	}
+}
+
+func TestMeasurePath(t *testing.T) {
+	opts := SelfTestOptions{
+		Connections:   10,
+		TransferBytes: 1024*1024 + 123,
+	}
+	result := measurePath(context.Background(), PathLoopbackTCP, "", "tcp", "127.0.0.1:0", opts)
+
+	if result.Error != "" {
+		t.Fatalf("self-test failed: %s", result.Error)
+	}
+	if result.Connect.Operations != 10 || result.Connect.Errors != 0 {
+		t.Errorf("expected 10 successful connections, got %+v", result.Connect)
+	}
+	if result.Bytes != opts.TransferBytes {
+		t.Errorf("expected %d bytes, got %d", opts.TransferBytes, result.Bytes)
+	}
+	if result.Throughput <= 0 {
+		t.Errorf("expected positive throughput, got %f", result.Throughput)
+	}
+}
@@ -0,0 +1,168 @@
+package benchmark
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/portmaster/network/netutils"
+)
+
+const (
+	defaultDomain      = "example.com"
+	defaultOperations  = 200
+	maxOperations      = 10000
+	defaultConcurrency = 8
+	maxConcurrency     = 64
+
+	defaultSelfTestConnections = 100
+	maxSelfTestConnections     = 1000
+	defaultSelfTestMegabytes   = 64
+	maxSelfTestMegabytes       = 1024
+)
+
+var module *modules.Module
+
+func init() {
+	module = modules.Register("benchmark", prep, nil, nil, "nameserver", "filter", "filterlists")
+}
+
+func prep() error {
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "debug/benchmark",
+		Write:     api.PermitAdmin,
+		BelongsTo: module,
+		StructFunc: func(ar *api.Request) (i interface{}, err error) {
+			opts, err := parseOptions(ar)
+			if err != nil {
+				return nil, err
+			}
+			return Run(ar.Context(), opts)
+		},
+		Name:        "Run Benchmark",
+		Description: "Runs synthetic workloads against the live configuration and reports latency and throughput of DNS queries, new connections, verdicts and filter list lookups.",
+		Parameters: []api.Parameter{
+			{
+				Method:      http.MethodPost,
+				Field:       "domain",
+				Value:       defaultDomain,
+				Description: "Specify the domain to query and connect to.",
+			},
+			{
+				Method:      http.MethodPost,
+				Field:       "operations",
+				Value:       strconv.Itoa(defaultOperations),
+				Description: "Specify the amount of operations per workload.",
+			},
+			{
+				Method:      http.MethodPost,
+				Field:       "concurrency",
+				Value:       strconv.Itoa(defaultConcurrency),
+				Description: "Specify the amount of parallel workers per workload.",
+			},
+		},
+	}); err != nil {
+		return err
+	}
+
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:      "debug/selftest",
+		Write:     api.PermitAdmin,
+		BelongsTo: module,
+		StructFunc: func(ar *api.Request) (i interface{}, err error) {
+			opts, err := parseSelfTestOptions(ar)
+			if err != nil {
+				return nil, err
+			}
+			return RunSelfTest(ar.Context(), opts)
+		},
+		Name:        "Run Packet Path Self-Test",
+		Description: "Measures connection latency and throughput on localhost with and without interception and reports the overhead the Portmaster adds. Optionally downloads a reference URL for comparison with a real endpoint.",
+		Parameters: []api.Parameter{
+			{
+				Method:      http.MethodPost,
+				Field:       "connections",
+				Value:       strconv.Itoa(defaultSelfTestConnections),
+				Description: "Specify the amount of connections to open per path.",
+			},
+			{
+				Method:      http.MethodPost,
+				Field:       "size",
+				Value:       strconv.Itoa(defaultSelfTestMegabytes),
+				Description: "Specify the amount of megabytes to transfer per path.",
+			},
+			{
+				Method:      http.MethodPost,
+				Field:       "reference",
+				Value:       "https://example.com/",
+				Description: "Specify a URL to download as a reference. The download is limited to the transfer size.",
+			},
+		},
+	})
+}
+
+func parseOptions(ar *api.Request) (opts Options, err error) {
+	query := ar.Request.URL.Query()
+	opts = Options{
+		Domain:      defaultDomain,
+		Operations:  defaultOperations,
+		Concurrency: defaultConcurrency,
+	}
+
+	if domain := query.Get("domain"); domain != "" {
+		opts.Domain = domain
+	}
+	opts.Domain = dns.Fqdn(opts.Domain)
+	if !netutils.IsValidFqdn(opts.Domain) {
+		return opts, fmt.Errorf("invalid domain %q", opts.Domain)
+	}
+
+	if opts.Operations, err = parseIntParam(query.Get("operations"), defaultOperations, maxOperations); err != nil {
+		return opts, fmt.Errorf("invalid operations: %w", err)
+	}
+	if opts.Concurrency, err = parseIntParam(query.Get("concurrency"), defaultConcurrency, maxConcurrency); err != nil {
+		return opts, fmt.Errorf("invalid concurrency: %w", err)
+	}
+	return opts, nil
+}
+
+func parseSelfTestOptions(ar *api.Request) (opts SelfTestOptions, err error) {
+	query := ar.Request.URL.Query()
+
+	if opts.Connections, err = parseIntParam(query.Get("connections"), defaultSelfTestConnections, maxSelfTestConnections); err != nil {
+		return opts, fmt.Errorf("invalid connections: %w", err)
+	}
+	megabytes, err := parseIntParam(query.Get("size"), defaultSelfTestMegabytes, maxSelfTestMegabytes)
+	if err != nil {
+		return opts, fmt.Errorf("invalid size: %w", err)
+	}
+	opts.TransferBytes = int64(megabytes) * 1024 * 1024
+
+	if reference := query.Get("reference"); reference != "" {
+		u, err := url.Parse(reference)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return opts, fmt.Errorf("invalid reference URL %q", reference)
+		}
+		opts.ReferenceURL = u.String()
+	}
+	return opts, nil
+}
+
+func parseIntParam(value string, defaultValue, maxValue int) (int, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(value)
+	switch {
+	case err != nil:
+		return 0, err
+	case n < 1 || n > maxValue:
+		return 0, fmt.Errorf("must be between 1 and %d", maxValue)
+	}
+	return n, nil
+}
@@ -0,0 +1,261 @@
+package benchmark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The self-test measures the overhead the Portmaster adds to the packet
+// path. TCP connections on the loopback interface are intercepted like any
+// other connection, while Unix sockets never pass the packet filter. The
+// difference between the two is the overhead of the interception.
+
+// Self-Test Paths
+const (
+	PathUnixSocket  = "unix-socket"
+	PathLoopbackTCP = "loopback-tcp"
+	PathReference   = "reference"
+)
+
+const (
+	selfTestCommandClose    = 'c'
+	selfTestCommandDownload = 'd'
+
+	selfTestChunkSize = 32 * 1024
+	referenceTimeout  = 30 * time.Second
+)
+
+// SelfTestOptions configures a self-test run.
+type SelfTestOptions struct {
+	// Connections is the amount of connections to open per path.
+	Connections int
+	// TransferBytes is the amount of bytes to transfer per path.
+	TransferBytes int64
+	// ReferenceURL is an optional URL to download as a real world reference.
+	ReferenceURL string
+}
+
+// SelfTestReport holds the results of a self-test.
+type SelfTestReport struct {
+	Started  time.Time
+	Duration time.Duration
+
+	// Baseline is the path without interception.
+	Baseline *PathResult
+	// Intercepted is the path with interception.
+	Intercepted *PathResult
+	// Reference is the download of the reference URL, if given.
+	Reference *PathResult `json:",omitempty"`
+
+	// ConnectOverhead is the latency the interception adds to establishing
+	// a connection.
+	ConnectOverhead time.Duration
+	// ThroughputOverhead is the throughput lost by the interception in
+	// percent.
+	ThroughputOverhead float64
+}
+
+// PathResult holds the measurements of a network path.
+type PathResult struct {
+	Path        string
+	Description string
+
+	// Connect holds the latency measurements of establishing connections.
+	Connect *Result `json:",omitempty"`
+
+	// Bytes is the amount of bytes transferred.
+	Bytes int64
+	// TransferDuration is the time the transfer took.
+	TransferDuration time.Duration
+	// Throughput is the transfer rate in bytes per second.
+	Throughput float64
+
+	Error string `json:",omitempty"`
+}
+
+// RunSelfTest measures the throughput and latency of the packet path with
+// and without interception, and of the reference URL, if given.
+func RunSelfTest(ctx context.Context, opts SelfTestOptions) (*SelfTestReport, error) {
+	if !running.SetToIf(false, true) {
+		return nil, ErrAlreadyRunning
+	}
+	defer running.UnSet()
+
+	report := &SelfTestReport{
+		Started: time.Now(),
+	}
+
+	// Measure the baseline without interception via a Unix socket.
+	socketPath := filepath.Join(os.TempDir(), fmt.Sprintf("portmaster-selftest-%d.sock", time.Now().UnixNano()))
+	report.Baseline = measurePath(ctx, PathUnixSocket, "Unix socket, which is not intercepted.", "unix", socketPath, opts)
+	_ = os.Remove(socketPath)
+
+	// Measure the same over loopback TCP, which is intercepted.
+	report.Intercepted = measurePath(ctx, PathLoopbackTCP, "TCP on the loopback interface, which is intercepted.", "tcp", "127.0.0.1:0", opts)
+
+	if report.Baseline.Error == "" && report.Intercepted.Error == "" {
+		report.ConnectOverhead = report.Intercepted.Connect.AvgLatency - report.Baseline.Connect.AvgLatency
+		if report.Baseline.Throughput > 0 {
+			report.ThroughputOverhead = (1 - report.Intercepted.Throughput/report.Baseline.Throughput) * 100
+		}
+	}
+
+	if opts.ReferenceURL != "" {
+		report.Reference = measureReference(ctx, opts)
+	}
+
+	report.Duration = time.Since(report.Started)
+	return report, nil
+}
+
+// measurePath measures connecting to and downloading from a local listener.
+func measurePath(ctx context.Context, path, description, network, address string, opts SelfTestOptions) *PathResult {
+	result := &PathResult{
+		Path:        path,
+		Description: description,
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+	go serveSelfTest(listener, opts.TransferBytes)
+
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	result.Connect = measure(ctx, path, description, Options{Operations: opts.Connections, Concurrency: 1},
+		func(ctx context.Context, _ int) error {
+			conn, err := dialer.DialContext(ctx, network, listener.Addr().String())
+			if err != nil {
+				return err
+			}
+			_, _ = conn.Write([]byte{selfTestCommandClose})
+			return conn.Close()
+		},
+	)
+
+	conn, err := dialer.DialContext(ctx, network, listener.Addr().String())
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	if _, err := conn.Write([]byte{selfTestCommandDownload}); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	start := time.Now()
+	result.Bytes, err = io.Copy(ioutil.Discard, conn)
+	result.TransferDuration = time.Since(start)
+	switch {
+	case err != nil:
+		result.Error = err.Error()
+	case result.Bytes != opts.TransferBytes:
+		result.Error = fmt.Sprintf("transfer ended after %d of %d bytes", result.Bytes, opts.TransferBytes)
+	}
+	if result.TransferDuration > 0 {
+		result.Throughput = float64(result.Bytes) / result.TransferDuration.Seconds()
+	}
+	return result
+}
+
+// serveSelfTest serves the connections of a self-test until the listener is
+// closed.
+func serveSelfTest(listener net.Listener, transferBytes int64) {
+	chunk := make([]byte, selfTestChunkSize)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer func() {
+				_ = conn.Close()
+			}()
+
+			command := make([]byte, 1)
+			if _, err := io.ReadFull(conn, command); err != nil || command[0] != selfTestCommandDownload {
+				return
+			}
+			for remaining := transferBytes; remaining > 0; {
+				n := int64(len(chunk))
+				if remaining < n {
+					n = remaining
+				}
+				if _, err := conn.Write(chunk[:n]); err != nil {
+					return
+				}
+				remaining -= n
+			}
+		}()
+	}
+}
+
+// measureReference measures downloading the reference URL.
+func measureReference(ctx context.Context, opts SelfTestOptions) *PathResult {
+	result := &PathResult{
+		Path:        PathReference,
+		Description: "Download of " + opts.ReferenceURL + ".",
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, referenceTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, opts.ReferenceURL, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	// The time to the response headers includes connecting.
+	firstByte := time.Since(start)
+	result.Connect = &Result{
+		Workload:   PathReference,
+		Operations: 1,
+		Throughput: 1 / firstByte.Seconds(),
+		Duration:   firstByte,
+		AvgLatency: firstByte,
+		P50Latency: firstByte,
+		P95Latency: firstByte,
+		MaxLatency: firstByte,
+	}
+	if resp.StatusCode != http.StatusOK {
+		result.Error = "unexpected status " + resp.Status
+		return result
+	}
+
+	start = time.Now()
+	result.Bytes, err = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, opts.TransferBytes))
+	result.TransferDuration = time.Since(start)
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		result.Error = err.Error()
+	}
+	if result.TransferDuration > 0 {
+		result.Throughput = float64(result.Bytes) / result.TransferDuration.Seconds()
+	}
+	return result
+}
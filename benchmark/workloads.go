@@ -0,0 +1,189 @@
+package benchmark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portmaster/firewall"
+	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/nameserver"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/process"
+	"github.com/safing/portmaster/resolver"
+)
+
+const (
+	dnsTimeout  = 2 * time.Second
+	dialTimeout = 5 * time.Second
+
+	benchmarkPort = 443
+)
+
+func warmup(ctx context.Context, fqdn string) ([]net.IP, error) {
+	rrCache, err := resolver.Resolve(ctx, &resolver.Query{
+		FQDN:  fqdn,
+		QType: dns.Type(dns.TypeA),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", fqdn, err)
+	}
+	ips := rrCache.ExportAllARecords()
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("%s has no A records", fqdn)
+	}
+	return ips, nil
+}
+
+// benchmarkResolver measures resolving from the cache. It is the baseline of
+// the DNS workload.
+func benchmarkResolver(ctx context.Context, opts Options) *Result {
+	return measure(ctx, WorkloadResolver, "Cached queries to the resolver, without the nameserver and filtering.", opts,
+		func(ctx context.Context, _ int) error {
+			_, err := resolver.Resolve(ctx, &resolver.Query{
+				FQDN:  opts.Domain,
+				QType: dns.Type(dns.TypeA),
+			})
+			return err
+		},
+	)
+}
+
+// benchmarkDNS measures DNS queries to the nameserver, including process
+// attribution. Queries by the Portmaster itself skip most of the filtering,
+// which is measured by the decisions workload.
+func benchmarkDNS(ctx context.Context, opts Options) *Result {
+	address := nameserverAddress()
+	client := &dns.Client{
+		Net:     "udp",
+		Timeout: dnsTimeout,
+	}
+
+	return measure(ctx, WorkloadDNS, "Queries to the nameserver at "+address+".", opts,
+		func(ctx context.Context, _ int) error {
+			msg := new(dns.Msg)
+			msg.SetQuestion(opts.Domain, dns.TypeA)
+			reply, _, err := client.ExchangeContext(ctx, msg, address)
+			if err != nil {
+				return err
+			}
+			if reply.Rcode != dns.RcodeSuccess {
+				return fmt.Errorf("nameserver replied with %s", dns.RcodeToString[reply.Rcode])
+			}
+			return nil
+		},
+	)
+}
+
+// nameserverAddress returns the address to query the nameserver at.
+func nameserverAddress() string {
+	address := config.Concurrent.GetAsString(nameserver.CfgDefaultNameserverAddressKey, "localhost:53")()
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+
+	// Replace wildcard addresses with localhost.
+	switch host {
+	case "", "0.0.0.0":
+		host = "127.0.0.1"
+	case "::":
+		host = "::1"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// benchmarkConnections measures opening new connections to a local listener,
+// which includes interception, process attribution and the verdict.
+func benchmarkConnections(ctx context.Context, opts Options) *Result {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return &Result{
+			Workload:  WorkloadConnections,
+			Errors:    1,
+			LastError: err.Error(),
+		}
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	return measure(ctx, WorkloadConnections, "New TCP connections to a local listener.", opts,
+		func(ctx context.Context, _ int) error {
+			conn, err := dialer.DialContext(ctx, "tcp", listener.Addr().String())
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		},
+	)
+}
+
+// benchmarkDecisions measures the decision process of new connections of an
+// unidentified process with the live configuration, without prompting.
+func benchmarkDecisions(ctx context.Context, opts Options, ips []net.IP) *Result {
+	proc := process.GetUnidentifiedProcess(ctx)
+	if proc.Profile() == nil {
+		return &Result{
+			Workload:  WorkloadDecisions,
+			Errors:    1,
+			LastError: "unidentified process has no profile",
+		}
+	}
+
+	return measure(ctx, WorkloadDecisions, "Verdicts on connections of an unidentified process.", opts,
+		func(ctx context.Context, i int) error {
+			conn := network.NewSyntheticConnection(
+				ctx,
+				fmt.Sprintf("benchmark-%d", i),
+				proc,
+				newEntity(opts.Domain, ips[i%len(ips)]),
+			)
+			conn.Lock()
+			defer conn.Unlock()
+
+			firewall.DecideOnConnectionWithoutPrompt(ctx, conn)
+			if conn.Verdict == network.VerdictFailed {
+				return errors.New(conn.Reason.Msg)
+			}
+			return nil
+		},
+	)
+}
+
+// benchmarkFilterLists measures filter list lookups of the domain and its IPs.
+func benchmarkFilterLists(ctx context.Context, opts Options, ips []net.IP) *Result {
+	return measure(ctx, WorkloadFilterLists, "Filter list lookups of domain, IP, ASN and country.", opts,
+		func(ctx context.Context, i int) error {
+			newEntity(opts.Domain, ips[i%len(ips)]).LoadLists(ctx)
+			return nil
+		},
+	)
+}
+
+func newEntity(fqdn string, ip net.IP) *intel.Entity {
+	entity := &intel.Entity{
+		Domain:   fqdn,
+		Protocol: uint8(packet.TCP),
+		Port:     benchmarkPort,
+	}
+	entity.SetIP(ip)
+	entity.SetDstPort(benchmarkPort)
+	return entity
+}
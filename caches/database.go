@@ -0,0 +1,103 @@
+package caches
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/database"
+)
+
+// replacedWriterTimeout defines how long the delayed cache writer of a
+// replaced database interface keeps running, so that writes of users that
+// still hold the previous interface are written too.
+const replacedWriterTimeout = 1 * time.Minute
+
+// DatabaseCache wraps a database interface whose cache size is managed by the
+// cache manager. As the cache size of a database interface cannot be changed,
+// resizing replaces the interface.
+type DatabaseCache struct {
+	lock       sync.RWMutex
+	name       string
+	opts       database.Options
+	db         *database.Interface
+	stopWriter context.CancelFunc
+}
+
+// NewDatabaseCache returns a new database interface with the given options
+// and registers its cache with the cache manager. The cache size of the
+// options is used until the cache manager sizes the cache.
+func NewDatabaseCache(name string, share, entrySize int, opts *database.Options) *DatabaseCache {
+	dc := &DatabaseCache{
+		name: name,
+		opts: *opts,
+	}
+	dc.db = dc.newInterface()
+	Register(name, share, entrySize, dc)
+	return dc
+}
+
+func (dc *DatabaseCache) newInterface() *database.Interface {
+	opts := dc.opts
+	return database.NewInterface(&opts)
+}
+
+// DB returns the current database interface. It must not be kept, as it is
+// replaced when the cache is resized.
+func (dc *DatabaseCache) DB() *database.Interface {
+	dc.lock.RLock()
+	defer dc.lock.RUnlock()
+
+	return dc.db
+}
+
+// Resize replaces the database interface with one of the given cache size.
+func (dc *DatabaseCache) Resize(entries int) {
+	dc.lock.Lock()
+	defer dc.lock.Unlock()
+
+	if entries == dc.opts.CacheSize {
+		return
+	}
+	dc.opts.CacheSize = entries
+	dc.db = dc.newInterface()
+
+	// Stop the delayed cache writer of the previous interface, so that it
+	// flushes its write cache and continues with the new interface.
+	if dc.stopWriter != nil {
+		dc.stopWriter()
+		dc.stopWriter = nil
+	}
+}
+
+// Evict clears the read cache. Pending writes are not affected.
+func (dc *DatabaseCache) Evict() {
+	dc.DB().ClearCache()
+}
+
+// DelayedCacheWriter must be run by the user of a database cache that uses
+// delayed cache writing. It follows the database interface across resizes.
+func (dc *DatabaseCache) DelayedCacheWriter(ctx context.Context) error {
+	for {
+		dc.lock.Lock()
+		db := dc.db
+		writerCtx, cancel := context.WithCancel(ctx)
+		dc.stopWriter = cancel
+		dc.lock.Unlock()
+
+		// The writer of the interface flushes its write cache when its context
+		// is canceled, which happens when the interface is replaced.
+		err := db.DelayedCacheWriter(writerCtx)
+		cancel()
+		if err != nil || ctx.Err() != nil {
+			return err
+		}
+
+		// Users may still write to the replaced interface for a short while.
+		module.StartWorker("write replaced "+dc.name+" cache", func(workerCtx context.Context) error {
+			replacedCtx, cancel := context.WithTimeout(workerCtx, replacedWriterTimeout)
+			defer cancel()
+			return db.DelayedCacheWriter(replacedCtx)
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package caches
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/safing/portbase/log"
+)
+
+const (
+	// minEntries is the lowest amount of entries a cache is sized to, even if
+	// the budget would only allow for less.
+	minEntries = 64
+
+	// pressureDivisor is the divisor applied to the cache sizes while the
+	// Portmaster is under memory pressure.
+	pressureDivisor = 4
+)
+
+// Cache is a cache whose size is managed by the cache manager.
+type Cache interface {
+	// Resize sets the maximum amount of entries of the cache.
+	Resize(entries int)
+
+	// Evict removes all entries from the cache that can be recovered from
+	// another source.
+	Evict()
+}
+
+type managedCache struct {
+	name      string
+	share     int
+	entrySize int
+	cache     Cache
+
+	entries int
+}
+
+var (
+	managedCaches     []*managedCache
+	managedCachesLock sync.Mutex
+
+	currentBudget   int64
+	currentPressure bool
+)
+
+// Register registers a cache with the cache manager. The share defines the
+// part of the memory budget the cache gets in relation to the other caches.
+// The entry size is an estimate of the memory one entry uses in bytes. The
+// cache is sized immediately if a budget is already set.
+func Register(name string, share, entrySize int, cache Cache) {
+	managedCachesLock.Lock()
+	defer managedCachesLock.Unlock()
+
+	managedCaches = append(managedCaches, &managedCache{
+		name:      name,
+		share:     share,
+		entrySize: entrySize,
+		cache:     cache,
+	})
+	if currentBudget > 0 {
+		resizeCaches()
+	}
+}
+
+// applyBudget sizes all caches according to the given budget in bytes. Under
+// pressure, the caches are shrunk and evicted.
+func applyBudget(budget int64, pressure bool) {
+	managedCachesLock.Lock()
+	defer managedCachesLock.Unlock()
+
+	evict := pressure && !currentPressure
+	currentBudget = budget
+	currentPressure = pressure
+	resizeCaches()
+
+	if evict {
+		for _, mc := range managedCaches {
+			mc.cache.Evict()
+		}
+	}
+}
+
+// resizeCaches resizes all caches according to the current budget.
+// managedCachesLock must be held.
+func resizeCaches() {
+	var totalShares int64
+	for _, mc := range managedCaches {
+		totalShares += int64(mc.share)
+	}
+	if totalShares == 0 {
+		return
+	}
+
+	for _, mc := range managedCaches {
+		entries := int(currentBudget * int64(mc.share) / totalShares / int64(mc.entrySize))
+		if currentPressure {
+			entries /= pressureDivisor
+		}
+		if entries < minEntries {
+			entries = minEntries
+		}
+
+		if entries != mc.entries {
+			mc.entries = entries
+			mc.cache.Resize(entries)
+			log.Debugf("caches: resized %s cache to %d entries", mc.name, entries)
+		}
+	}
+}
+
+// CacheStatus holds the status of a managed cache.
+type CacheStatus struct {
+	Name    string
+	Entries int
+	Budget  int64
+}
+
+// Status returns the status of all managed caches, sorted by name.
+func Status() []CacheStatus {
+	managedCachesLock.Lock()
+	defer managedCachesLock.Unlock()
+
+	status := make([]CacheStatus, 0, len(managedCaches))
+	for _, mc := range managedCaches {
+		status = append(status, CacheStatus{
+			Name:    mc.name,
+			Entries: mc.entries,
+			Budget:  int64(mc.entries) * int64(mc.entrySize),
+		})
+	}
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Name < status[j].Name
+	})
+	return status
+}
@@ -0,0 +1,55 @@
+package caches
+
+import (
+	"testing"
+)
+
+type testCache struct {
+	entries int
+	evicted int
+}
+
+func (tc *testCache) Resize(entries int) {
+	tc.entries = entries
+}
+
+func (tc *testCache) Evict() {
+	tc.evicted++
+}
+
+func TestBudget(t *testing.T) {
+	big := &testCache{}
+	small := &testCache{}
+	Register("big", 3, 100, big)
+	Register("small", 1, 100, small)
+
+	// Caches are not sized before a budget is set.
+	if big.entries != 0 || small.entries != 0 {
+		t.Fatal("caches sized without a budget")
+	}
+
+	applyBudget(400000, false)
+	if big.entries != 3000 || small.entries != 1000 {
+		t.Errorf("unexpected sizes: big=%d small=%d", big.entries, small.entries)
+	}
+
+	// Caches registered later are sized immediately.
+	late := &testCache{}
+	Register("late", 4, 100, late)
+	if late.entries != 2000 || big.entries != 1500 {
+		t.Errorf("unexpected sizes after late registration: late=%d big=%d", late.entries, big.entries)
+	}
+
+	// Pressure shrinks and evicts the caches once.
+	applyBudget(400000, true)
+	applyBudget(400000, true)
+	if big.entries != 1500/pressureDivisor || big.evicted != 1 {
+		t.Errorf("unexpected state under pressure: entries=%d evicted=%d", big.entries, big.evicted)
+	}
+
+	// Caches are not sized below the minimum.
+	applyBudget(1000, false)
+	if small.entries != minEntries {
+		t.Errorf("expected minimum size, got %d", small.entries)
+	}
+}
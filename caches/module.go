@@ -0,0 +1,113 @@
+package caches
+
+import (
+	"context"
+	"runtime"
+	"time"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+)
+
+const (
+	// pressureFactor defines at which multiple of the cache memory budget the
+	// heap of the Portmaster is considered to be under memory pressure.
+	pressureFactor = 4
+
+	pressureCheckInterval = 30 * time.Second
+)
+
+var (
+	module *modules.Module
+
+	// CfgMemoryBudgetKey is the config key for the memory budget of all caches.
+	CfgMemoryBudgetKey = "core/cacheMemoryBudget"
+	cfgMemoryBudget    config.IntOption
+)
+
+func init() {
+	module = modules.Register("caches", prep, start, nil, "base")
+}
+
+func prep() error {
+	if err := config.Register(&config.Option{
+		Name:           "Cache Memory Budget",
+		Key:            CfgMemoryBudgetKey,
+		Description:    "Memory budget in MB that is shared between the DNS cache, the IP info cache, the filter list cache and the verdict cache of verdict plugins. The caches are shrunk and cleared when the memory used by the Portmaster exceeds four times this budget.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   16,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 103,
+			config.UnitAnnotation:         "MB",
+			config.CategoryAnnotation:     "Advanced",
+		},
+		ValidationRegex: `^[1-9][0-9]{0,3}$`,
+	}); err != nil {
+		return err
+	}
+	cfgMemoryBudget = config.Concurrent.GetAsInt(CfgMemoryBudgetKey, 16)
+
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:      "debug/caches",
+		Read:      api.PermitUser,
+		BelongsTo: module,
+		StructFunc: func(_ *api.Request) (i interface{}, err error) {
+			return Status(), nil
+		},
+		Name:        "Get Cache Status",
+		Description: "Returns the size and memory budget of all managed caches.",
+	})
+}
+
+func start() error {
+	if err := module.RegisterEventHook(
+		"config",
+		"config change",
+		"apply cache memory budget",
+		func(_ context.Context, _ interface{}) error {
+			checkMemoryPressure()
+			return nil
+		},
+	); err != nil {
+		return err
+	}
+	checkMemoryPressure()
+
+	module.NewTask("check memory pressure", func(_ context.Context, _ *modules.Task) error {
+		checkMemoryPressure()
+		return nil
+	}).Repeat(pressureCheckInterval)
+
+	return nil
+}
+
+// checkMemoryPressure applies the configured budget, taking the current heap
+// size into account. Pressure is only considered resolved when the heap is
+// well below the threshold, so that the caches do not flap between sizes.
+func checkMemoryPressure() {
+	budget := cfgMemoryBudget() * 1024 * 1024
+	threshold := budget * pressureFactor
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	heap := int64(stats.HeapInuse)
+
+	managedCachesLock.Lock()
+	pressure := currentPressure
+	managedCachesLock.Unlock()
+
+	switch {
+	case !pressure && heap > threshold:
+		pressure = true
+		log.Warningf("caches: memory pressure detected (heap at %d MB), shrinking caches", heap/1024/1024)
+	case pressure && heap < threshold/2:
+		pressure = false
+		log.Info("caches: memory pressure resolved, restoring cache sizes")
+	}
+
+	applyBudget(budget, pressure)
+}
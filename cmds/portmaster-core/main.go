@@ -10,9 +10,15 @@
 
 	// include packages here
 	_ "github.com/safing/portbase/modules/subsystems"
+	_ "github.com/safing/portmaster/benchmark"
 	_ "github.com/safing/portmaster/core"
 	_ "github.com/safing/portmaster/firewall"
+	_ "github.com/safing/portmaster/guest"
 	_ "github.com/safing/portmaster/nameserver"
+	_ "github.com/safing/portmaster/notifyrelay"
+	_ "github.com/safing/portmaster/opensnitch"
+	_ "github.com/safing/portmaster/peersync"
+	_ "github.com/safing/portmaster/spntest"
 	_ "github.com/safing/portmaster/ui"
 	_ "github.com/safing/spn/captain"
 )
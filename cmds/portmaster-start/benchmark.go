@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	benchmarkAPIAddress  string
+	benchmarkDomain      string
+	benchmarkOperations  int
+	benchmarkConcurrency int
+
+	benchmarkCmd = &cobra.Command{
+		Use:   "benchmark",
+		Short: "Measure the latency and throughput of the running Portmaster",
+		Args:  cobra.NoArgs,
+		RunE:  runBenchmark,
+	}
+)
+
+func init() {
+	flags := benchmarkCmd.Flags()
+	flags.StringVar(&benchmarkAPIAddress, "api", "127.0.0.1:817", "Address of the Portmaster API")
+	flags.StringVar(&benchmarkDomain, "domain", "example.com", "Domain to query and connect to")
+	flags.IntVar(&benchmarkOperations, "operations", 200, "Amount of operations per workload")
+	flags.IntVar(&benchmarkConcurrency, "concurrency", 8, "Amount of parallel workers per workload")
+
+	rootCmd.AddCommand(benchmarkCmd)
+}
+
+// benchmarkReport mirrors the report returned by the benchmark API.
+type benchmarkReport struct {
+	Duration time.Duration
+	Results  []struct {
+		Workload     string
+		Description  string
+		Operations   int
+		Errors       int
+		LastError    string
+		Throughput   float64
+		AvgLatency   time.Duration
+		P50Latency   time.Duration
+		P95Latency   time.Duration
+		MaxLatency   time.Duration
+		AddedLatency time.Duration
+	}
+}
+
+func runBenchmark(*cobra.Command, []string) error {
+	query := url.Values{}
+	query.Set("domain", benchmarkDomain)
+	query.Set("operations", strconv.Itoa(benchmarkOperations))
+	query.Set("concurrency", strconv.Itoa(benchmarkConcurrency))
+	apiURL := "http://" + benchmarkAPIAddress + "/api/v1/debug/benchmark?" + query.Encode()
+
+	fmt.Println("running benchmark, this may take a while...")
+	resp, err := http.Post(apiURL, "application/json", nil) //nolint:gosec // URL is built from flags
+	if err != nil {
+		return fmt.Errorf("failed to reach Portmaster API: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("benchmark failed: %s: %s", resp.Status, data)
+	}
+
+	report := &benchmarkReport{}
+	if err := json.Unmarshal(data, report); err != nil {
+		return fmt.Errorf("failed to parse report: %w", err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "WORKLOAD\tOPS\tERRORS\tOPS/S\tAVG\tP50\tP95\tMAX\tADDED")
+	for _, result := range report.Results {
+		added := "-"
+		if result.AddedLatency != 0 {
+			added = result.AddedLatency.String()
+		}
+		fmt.Fprintf(
+			tw,
+			"%s\t%d\t%d\t%.1f\t%s\t%s\t%s\t%s\t%s\n",
+			result.Workload,
+			result.Operations,
+			result.Errors,
+			result.Throughput,
+			result.AvgLatency,
+			result.P50Latency,
+			result.P95Latency,
+			result.MaxLatency,
+			added,
+		)
+	}
+	_ = tw.Flush()
+
+	fmt.Println()
+	for _, result := range report.Results {
+		fmt.Printf("%s: %s\n", result.Workload, result.Description)
+		if result.LastError != "" {
+			fmt.Printf("  last error: %s\n", result.LastError)
+		}
+	}
+	fmt.Printf("\nfinished in %s\n", report.Duration)
+	return nil
+}
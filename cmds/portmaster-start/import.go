@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	importAPIAddress string
+
+	importUpdatesCmd = &cobra.Command{
+		Use:   "import-updates <bundle>",
+		Short: "Import an offline update bundle into the running Portmaster",
+		Long:  "Import a signed offline update bundle, such as from a USB drive, into the running Portmaster. The bundle is validated and the contained updates are applied like an online update.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runImportUpdates,
+	}
+)
+
+func init() {
+	importUpdatesCmd.Flags().StringVar(&importAPIAddress, "api", "127.0.0.1:817", "Address of the Portmaster API")
+
+	rootCmd.AddCommand(importUpdatesCmd)
+}
+
+func runImportUpdates(_ *cobra.Command, args []string) error {
+	// The bundle is read by the Portmaster, which may run in another
+	// working directory.
+	bundlePath, err := filepath.Abs(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path of bundle: %w", err)
+	}
+
+	fmt.Printf("importing %s, this may take a while...\n", bundlePath)
+	resp, err := http.Post( //nolint:gosec // URL is built from flags
+		"http://"+importAPIAddress+"/api/v1/updates/import?path="+url.QueryEscape(bundlePath),
+		"application/json",
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to reach Portmaster API: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("import failed: %s: %s", resp.Status, data)
+	}
+
+	result := &struct {
+		Indexes   []string
+		Resources []string
+	}{}
+	if err := json.Unmarshal(data, result); err != nil {
+		return fmt.Errorf("failed to parse result: %w", err)
+	}
+
+	for _, index := range result.Indexes {
+		fmt.Printf("index:    %s\n", index)
+	}
+	for _, resource := range result.Resources {
+		fmt.Printf("resource: %s\n", resource)
+	}
+	fmt.Printf("\nimported %d indexes and %d resources\n", len(result.Indexes), len(result.Resources))
+	return nil
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"log"
+
+	"github.com/safing/portmaster/firewall/interception"
+)
+
+// reapplyKillSwitch installs the kill switch as configured in the Portmaster
+// Core. The Portmaster Core installs it itself only when it shuts down
+// cleanly, so it must be installed again after a reboot or a crash.
+func reapplyKillSwitch() {
+	if err := interception.ReapplyKillSwitch(dataRoot.Path); err != nil {
+		log.Printf("failed to install kill switch: %s\n", err)
+	}
+}
@@ -209,6 +209,13 @@ func updateRegistryIndex(mustLoadIndex bool) error {
 		log.Printf("WARNING: error during storage scan: %s\n", err)
 	}
 
+	// Apply versions pinned by rollbacks.
+	pins, err := helper.LoadPinnedVersions(registry)
+	if err != nil {
+		log.Printf("WARNING: failed to load pinned versions: %s\n", err)
+	}
+	helper.ApplyPinnedVersions(registry, pins)
+
 	registry.SelectVersions()
 	return nil
 }
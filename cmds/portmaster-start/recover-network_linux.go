@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/spf13/cobra"
+
+	"github.com/safing/portmaster/firewall/interception"
+	"github.com/safing/portmaster/nameserver/systemdns"
+)
+
+const (
+	// recoverDNSTestDomain and recoverConnectivityTestPort are used to check
+	// connectivity after recovering. They match the online status checks of
+	// the Portmaster.
+	recoverDNSTestDomain        = "one.one.one.one"
+	recoverConnectivityTestPort = "443"
+
+	recoverCheckTimeout = 5 * time.Second
+)
+
+// redirectMarks holds the connection marks of connections that are redirected
+// to the Portmaster by the interception rules. These connections keep being
+// redirected until their conntrack entries are removed, even when the rules
+// are gone.
+var redirectMarks = []string{
+	"1799", // DNS requests
+	"1717", // SPN
+	"1727", // SPN
+}
+
+var recoverNetworkCmd = &cobra.Command{
+	Use:   "recover-network",
+	Short: "Removes all Portmaster network interception and checks connectivity",
+	Long: `Removes all Portmaster network interception and checks connectivity.
+
+Use this to recover network access if the Portmaster Core is stuck or was not
+shut down cleanly. It does not need the Portmaster Core to respond.
+The system DNS configuration is restored, if it was changed by the Portmaster.
+The interception rules will be installed again when the Portmaster Core starts.`,
+	RunE: func(*cobra.Command, []string) error {
+		fmt.Println("removing interception rules...")
+		if err := recoverIPTables(); err != nil {
+			return err
+		}
+
+		fmt.Println("removing kill switch...")
+		if err := removeKillSwitch(); err != nil {
+			return err
+		}
+
+		fmt.Println("restoring system DNS configuration...")
+		if err := restoreSystemDNS(); err != nil {
+			return err
+		}
+
+		fmt.Println("resetting redirected connections...")
+		if err := resetRedirectedConnections(); err != nil {
+			return err
+		}
+
+		fmt.Println("checking connectivity...")
+		if err := checkConnectivity(); err != nil {
+			return fmt.Errorf("network is still not reachable: %w", err)
+		}
+
+		fmt.Println("network recovered")
+		return nil
+	},
+	SilenceUsage: true,
+}
+
+func init() {
+	rootCmd.AddCommand(recoverNetworkCmd)
+}
+
+// removeKillSwitch removes the kill switch, which blocks all traffic except
+// localhost while the Portmaster Core is not running.
+func removeKillSwitch() error {
+	// See recoverIPTables.
+	currentLocale := os.Getenv("LC_ALL")
+	os.Setenv("LC_ALL", "C")
+	defer os.Setenv("LC_ALL", currentLocale)
+
+	if err := interception.RemoveKillSwitch(); err != nil {
+		if strings.Contains(err.Error(), "Permission denied") {
+			return fmt.Errorf("failed to remove kill switch: %w", os.ErrPermission)
+		}
+		return fmt.Errorf("failed to remove kill switch: %w", err)
+	}
+	return nil
+}
+
+// restoreSystemDNS restores the original system DNS configuration, if it was
+// changed by the system DNS integration of the Portmaster Core and not
+// restored.
+func restoreSystemDNS() error {
+	state, err := systemdns.LoadState(dataRoot.Path)
+	if err != nil {
+		return fmt.Errorf("failed to load original system DNS configuration: %w", err)
+	}
+	if state.IsEmpty() {
+		fmt.Println("system DNS configuration was not changed")
+		return nil
+	}
+
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return fmt.Errorf("failed to connect to system bus: %w", err)
+	}
+	if err := systemdns.Restore(conn, state); err != nil {
+		return err
+	}
+	return systemdns.RemoveState(dataRoot.Path)
+}
+
+// resetRedirectedConnections deletes the conntrack entries of connections
+// that are redirected to the Portmaster, so that for example DNS requests are
+// sent to the system nameservers again. This requires the conntrack tool.
+func resetRedirectedConnections() error {
+	conntrackPath, err := exec.LookPath("conntrack")
+	if err != nil {
+		fmt.Println("conntrack tool not found, redirected connections will time out by themselves")
+		return nil
+	}
+
+	for _, mark := range redirectMarks {
+		// Make sure output is always english, see recoverIPTables.
+		cmd := exec.Command(conntrackPath, "-D", "--mark", mark) //nolint:gosec // Mark is constant.
+		cmd.Env = []string{"LC_ALL=C"}
+		output, err := cmd.CombinedOutput()
+		if err == nil {
+			continue
+		}
+
+		// conntrack fails if there are no matching entries.
+		switch {
+		case strings.Contains(string(output), "0 flow entries"):
+		case strings.Contains(string(output), "Operation not permitted"):
+			return fmt.Errorf("failed to reset redirected connections: %w", os.ErrPermission)
+		default:
+			return fmt.Errorf("failed to reset connections with mark %s: %w: %s", mark, err, strings.TrimSpace(string(output)))
+		}
+	}
+
+	return nil
+}
+
+// checkConnectivity checks if DNS requests are answered using the system
+// nameservers and if a connection to the resolved address can be established.
+func checkConnectivity() error {
+	ctx, cancel := context.WithTimeout(context.Background(), recoverCheckTimeout)
+	defer cancel()
+
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, recoverDNSTestDomain)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", recoverDNSTestDomain, err)
+	}
+	if len(ips) == 0 {
+		return fmt.Errorf("failed to resolve %s: no addresses returned", recoverDNSTestDomain)
+	}
+	fmt.Printf("resolved %s to %s\n", recoverDNSTestDomain, ips[0].IP)
+
+	var dialer net.Dialer
+	var lastErr error
+	for _, ip := range ips {
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), recoverConnectivityTestPort))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		_ = conn.Close()
+		fmt.Printf("connected to %s\n", conn.RemoteAddr())
+		return nil
+	}
+
+	return fmt.Errorf("failed to connect to %s: %w", recoverDNSTestDomain, lastErr)
+}
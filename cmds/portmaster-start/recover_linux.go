@@ -14,50 +14,54 @@
 	Use:   "recover-iptables",
 	Short: "Removes obsolete IP tables rules in case of an unclean shutdown",
 	RunE: func(*cobra.Command, []string) error {
-		// interception.DeactiveNfqueueFirewall uses coreos/go-iptables
-		// which shells out to the /sbin/iptables binary. As a result,
-		// we don't get the errno of the actual error and need to parse the
-		// output instead. Make sure it's always english by setting LC_ALL=C
-		currentLocale := os.Getenv("LC_ALL")
-		os.Setenv("LC_ALL", "C")
-		defer os.Setenv("LC_ALL", currentLocale)
+		return recoverIPTables()
+	},
+	SilenceUsage: true,
+}
 
-		err := interception.DeactivateNfqueueFirewall()
-		if err == nil {
-			return nil
-		}
+func init() {
+	rootCmd.AddCommand(recoverIPTablesCmd)
+}
 
-		// we don't want to show ErrNotExists to the user
-		// as that only means portmaster did the cleanup itself.
-		mr, ok := err.(*multierror.Error)
-		if !ok {
-			return err
-		}
+func recoverIPTables() error {
+	// interception.DeactiveNfqueueFirewall uses coreos/go-iptables
+	// which shells out to the /sbin/iptables binary. As a result,
+	// we don't get the errno of the actual error and need to parse the
+	// output instead. Make sure it's always english by setting LC_ALL=C
+	currentLocale := os.Getenv("LC_ALL")
+	os.Setenv("LC_ALL", "C")
+	defer os.Setenv("LC_ALL", currentLocale)
+
+	err := interception.DeactivateNfqueueFirewall()
+	if err == nil {
+		return nil
+	}
 
-		var filteredErrors *multierror.Error
-		for _, err := range mr.Errors {
-			// if we have a permission denied error, all errors will be the same
-			if strings.Contains(err.Error(), "Permission denied") {
-				return fmt.Errorf("failed to cleanup iptables: %w", os.ErrPermission)
-			}
+	// we don't want to show ErrNotExists to the user
+	// as that only means portmaster did the cleanup itself.
+	mr, ok := err.(*multierror.Error)
+	if !ok {
+		return err
+	}
 
-			if !strings.Contains(err.Error(), "No such file or directory") {
-				filteredErrors = multierror.Append(filteredErrors, err)
-			}
+	var filteredErrors *multierror.Error
+	for _, err := range mr.Errors {
+		// if we have a permission denied error, all errors will be the same
+		if strings.Contains(err.Error(), "Permission denied") {
+			return fmt.Errorf("failed to cleanup iptables: %w", os.ErrPermission)
 		}
 
-		if filteredErrors != nil {
-			filteredErrors.ErrorFormat = formatNfqErrors
-			return filteredErrors.ErrorOrNil()
+		if !strings.Contains(err.Error(), "No such file or directory") {
+			filteredErrors = multierror.Append(filteredErrors, err)
 		}
+	}
 
-		return nil
-	},
-	SilenceUsage: true,
-}
+	if filteredErrors != nil {
+		filteredErrors.ErrorFormat = formatNfqErrors
+		return filteredErrors.ErrorOrNil()
+	}
 
-func init() {
-	rootCmd.AddCommand(recoverIPTablesCmd)
+	return nil
 }
 
 func formatNfqErrors(es []error) string {
@@ -44,6 +44,7 @@ type Options struct {
 	AllowDownload     bool // allow download of component if it is not yet available
 	AllowHidingWindow bool // allow hiding the window of the subprocess
 	NoOutput          bool // do not use stdout/err if logging to file is available (did not fail to open log file)
+	KillSwitch        bool // install the kill switch while the component is not running
 }
 
 func init() {
@@ -54,6 +55,7 @@ func init() {
 			AllowDownload:     true,
 			AllowHidingWindow: true,
 			PIDFile:           true,
+			KillSwitch:        true,
 		},
 		{
 			Name:              "Portmaster App",
@@ -290,6 +292,9 @@ func execute(opts *Options, args []string) (cont bool, err error) {
 	if err != nil {
 		return true, fmt.Errorf("could not get component: %w", err)
 	}
+	if err := verifyFile(file); err != nil {
+		return true, fmt.Errorf("refusing to start unverified component: %w", err)
+	}
 	binPath := file.Path()
 
 	// Adapt path for packaged software.
@@ -310,6 +315,11 @@ func execute(opts *Options, args []string) (cont bool, err error) {
 		return true, err
 	}
 
+	// Block traffic until the component has started, as configured.
+	if opts.KillSwitch {
+		reapplyKillSwitch()
+	}
+
 	log.Printf("starting %s %s\n", binPath, strings.Join(args, " "))
 
 	// create command
@@ -374,7 +384,13 @@ func execute(opts *Options, args []string) (cont bool, err error) {
 		return false, nil
 
 	case err := <-finished:
-		return parseExitError(err)
+		restart, err := parseExitError(err)
+		if err != nil && opts.KillSwitch {
+			// The component did not shut down cleanly and could not install
+			// the kill switch itself.
+			reapplyKillSwitch()
+		}
+		return restart, err
 	}
 }
 
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	spnAPIAddress string
+
+	spnCmd = &cobra.Command{
+		Use:   "spn",
+		Short: "Manage the SPN of the running Portmaster",
+	}
+
+	spnTestCmd = &cobra.Command{
+		Use:   "test",
+		Short: "Check the SPN connectivity of the running Portmaster",
+		Args:  cobra.NoArgs,
+		RunE:  runSPNTest,
+	}
+)
+
+func init() {
+	spnCmd.PersistentFlags().StringVar(&spnAPIAddress, "api", "127.0.0.1:817", "Address of the Portmaster API")
+	spnCmd.AddCommand(spnTestCmd)
+
+	rootCmd.AddCommand(spnCmd)
+}
+
+// spnTestReport mirrors the report returned by the SPN test API.
+type spnTestReport struct {
+	Duration    time.Duration
+	Enabled     bool
+	ClientReady bool
+	Map         struct {
+		Hubs         int
+		HubsWithInfo int
+		Error        string
+	}
+	Account struct {
+		HasAccessCode bool
+		Zone          string
+		Error         string
+	}
+	EntryNodes []struct {
+		HubID      string
+		Name       string
+		Proximity  int
+		Transports []struct {
+			Transport string
+			IP        string
+			Reachable bool
+			Latency   time.Duration
+			Error     string
+		}
+	}
+	Problems []string
+}
+
+func runSPNTest(*cobra.Command, []string) error {
+	fmt.Println("testing SPN connectivity, this may take a while...")
+	resp, err := http.Post("http://"+spnAPIAddress+"/api/v1/spn/test", "application/json", nil) //nolint:gosec // URL is built from flags
+	if err != nil {
+		return fmt.Errorf("failed to reach Portmaster API: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("test failed: %s: %s", resp.Status, data)
+	}
+
+	report := &spnTestReport{}
+	if err := json.Unmarshal(data, report); err != nil {
+		return fmt.Errorf("failed to parse report: %w", err)
+	}
+
+	fmt.Printf("enabled:      %v\n", report.Enabled)
+	fmt.Printf("connected:    %v\n", report.ClientReady)
+	switch {
+	case report.Account.HasAccessCode:
+		fmt.Printf("access code:  available (%s)\n", report.Account.Zone)
+	default:
+		fmt.Printf("access code:  missing (%s)\n", report.Account.Error)
+	}
+	switch {
+	case report.Map.Error != "":
+		fmt.Printf("map:          failed (%s)\n", report.Map.Error)
+	default:
+		fmt.Printf("map:          %d hubs, %d usable\n", report.Map.Hubs, report.Map.HubsWithInfo)
+	}
+
+	fmt.Println()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ENTRY NODE\tTRANSPORT\tIP\tRESULT")
+	for _, entryNode := range report.EntryNodes {
+		name := entryNode.Name
+		if name == "" {
+			name = entryNode.HubID
+		}
+		if len(entryNode.Transports) == 0 {
+			fmt.Fprintf(tw, "%s\t-\t-\tno transports\n", name)
+		}
+		for _, transport := range entryNode.Transports {
+			result := transport.Latency.Round(time.Millisecond).String()
+			if !transport.Reachable {
+				result = "unreachable: " + transport.Error
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", name, transport.Transport, transport.IP, result)
+		}
+	}
+	_ = tw.Flush()
+
+	fmt.Println()
+	if len(report.Problems) == 0 {
+		fmt.Println("no problems detected")
+	}
+	for _, problem := range report.Problems {
+		fmt.Printf("problem: %s\n", problem)
+	}
+	fmt.Printf("\nfinished in %s\n", report.Duration)
+	return nil
+}
@@ -89,8 +89,25 @@ func downloadUpdates() error {
 		return err
 	}
 
+	// Apply versions pinned by rollbacks.
+	pins, err := helper.LoadPinnedVersions(registry)
+	if err != nil {
+		log.Warningf("failed to load pinned versions: %s", err)
+	}
+	helper.ApplyPinnedVersions(registry, pins)
+
+	// Reject files without a valid signature before selecting versions.
+	err = verifyUpdates(context.TODO())
+	if err != nil {
+		return err
+	}
+
 	// Select versions and unpack the selected.
 	registry.SelectVersions()
+	err = checkSelectedVersions()
+	if err != nil {
+		return err
+	}
 	err = registry.UnpackResources()
 	if err != nil {
 		return fmt.Errorf("failed to unpack resources: %w", err)
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/safing/portbase/updater"
+	"github.com/safing/portmaster/updates/helper"
+)
+
+// newSignatureVerifier returns a verifier for the update files of the
+// registry. The signatures are shared with the Portmaster Core. Builds without
+// an update signing key do not use any update files.
+func newSignatureVerifier() (*helper.SignatureVerifier, error) {
+	if helper.SigningKeyData() == "" {
+		return nil, errors.New("no update signing key built in, please use an official release or build portmaster-start with the build script")
+	}
+	key, err := helper.DecodeSigningKey(helper.SigningKeyData())
+	if err != nil {
+		return nil, err
+	}
+
+	signatureDir := dataRoot.ChildDir(helper.SignatureDirName, 0755)
+	if err := signatureDir.Ensure(); err != nil {
+		return nil, fmt.Errorf("failed to create signature directory: %w", err)
+	}
+
+	return &helper.SignatureVerifier{
+		Registry:     registry,
+		Key:          key,
+		SignatureDir: signatureDir,
+	}, nil
+}
+
+// verifyUpdates verifies all downloaded update files and blacklists the ones
+// with a missing or invalid signature, so that they are not selected.
+func verifyUpdates(ctx context.Context) error {
+	verifier, err := newSignatureVerifier()
+	if err != nil {
+		return err
+	}
+
+	verifier.VerifyAvailable(ctx, true)
+	return nil
+}
+
+// checkSelectedVersions returns an error if the selected version of any
+// resource was rejected. The registry selects a blacklisted version if there
+// is no other version available.
+func checkSelectedVersions() error {
+	var unverified []string
+	for identifier, res := range registry.Export() {
+		res.Lock()
+		if res.SelectedVersion != nil && res.SelectedVersion.Blacklisted {
+			unverified = append(unverified, updater.GetVersionedPath(identifier, res.SelectedVersion.VersionNumber))
+		}
+		res.Unlock()
+	}
+
+	if len(unverified) > 0 {
+		return fmt.Errorf("no verified version available for: %s", strings.Join(unverified, ", "))
+	}
+	return nil
+}
+
+// verifyFile verifies the update file before it is executed.
+func verifyFile(file *updater.File) error {
+	verifier, err := newSignatureVerifier()
+	if err != nil {
+		return err
+	}
+
+	versionedPath := updater.GetVersionedPath(file.Identifier(), file.Version())
+	return verifier.VerifyFile(context.TODO(), versionedPath, registry.Online)
+}
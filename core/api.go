@@ -63,7 +63,11 @@ func registerAPIEndpoints() error {
 		return err
 	}
 
-	return nil
+	if err := registerAPIVersionEndpoint(); err != nil {
+		return err
+	}
+
+	return registerResourceUsageAPI()
 }
 
 // shutdown shuts the Portmaster down.
@@ -0,0 +1,193 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/info"
+)
+
+// The API is versioned as a whole. Clients, such as older versions of the
+// UI or third-party integrations, negotiate the API version they speak. The
+// fields of the records of a supported API version are kept stable: fields
+// are only added, and deprecated fields are only removed with the next API
+// version, after the previous one stopped being supported.
+
+const (
+	// APIVersion is the latest version of the API.
+	APIVersion = 1
+
+	// apiVersionHeader may hold the comma separated API versions the client
+	// supports.
+	apiVersionHeader = "X-Portmaster-API-Version"
+)
+
+// supportedAPIVersions holds all API versions that are currently supported.
+var supportedAPIVersions = []int{1}
+
+// APIVersionInfo holds the result of the API version negotiation.
+type APIVersionInfo struct {
+	// Version is the negotiated API version.
+	Version int
+	// Supported holds all supported API versions.
+	Supported []int
+	// CoreVersion is the version of the Portmaster Core.
+	CoreVersion string
+	// Schemas holds the stable schemas of the records of the negotiated API
+	// version.
+	Schemas []*RecordSchema
+}
+
+// RecordSchema describes the stable fields of a record type.
+type RecordSchema struct {
+	// Name is the name of the record type.
+	Name string
+	// Keys is the database key prefix of the records.
+	Keys string
+	// Fields holds the JSON field names that are guaranteed to be present.
+	Fields []string
+	// Deprecations holds the deprecated fields of the record.
+	Deprecations []*Deprecation `json:",omitempty"`
+}
+
+// Deprecation describes a deprecated field and its replacement.
+type Deprecation struct {
+	Field string
+	// Replacement describes what to use instead.
+	Replacement string
+	// RemovedIn is the API version in which the field is removed.
+	RemovedIn int
+}
+
+// apiSchemas holds the stable record schemas per API version.
+var apiSchemas = map[int][]*RecordSchema{
+	1: {
+		{
+			Name: "Profile",
+			Keys: "core:profiles/",
+			Fields: []string{
+				"ID", "Source", "Name", "Description", "Homepage", "Icon", "IconType",
+				"PackageInfo", "Tags", "Notes", "DisableSync", "LinkedPath", "Fingerprint", "LinkedProfiles", "NetworkOverrides", "SecurityLevel", "Config",
+				"ApproxLastUsed", "LastEdited", "Created", "Internal",
+			},
+		},
+		{
+			Name: "Connection",
+			Keys: "network:tree/",
+			Fields: []string{
+				"ID", "Type", "External", "Scope", "IPVersion", "Inbound", "IPProtocol",
+				"LocalIP", "LocalIPScope", "LocalPort", "Entity", "Resolver", "Verdict",
+				"Reason", "Tags", "Started", "Ended", "VerdictPermanent", "Inspecting",
+				"Tunneled", "Encrypted", "ProcessContext", "Internal",
+			},
+			Deprecations: []*Deprecation{{
+				Field:       "Scope",
+				Replacement: "Type, Inbound and Entity.Domain",
+				RemovedIn:   2,
+			}},
+		},
+		{
+			Name: "Notification",
+			Keys: "notifications:all/",
+			Fields: []string{
+				"EventID", "GUID", "Type", "Title", "Category", "Message", "ShowOnSystem",
+				"EventData", "Expires", "State", "AvailableActions", "SelectedActionID",
+			},
+		},
+		{
+			Name: "Versions",
+			Keys: "core:status/versions",
+			Fields: []string{
+				"Core", "Resources", "Channel", "Beta", "Staging",
+			},
+			Deprecations: []*Deprecation{
+				{
+					Field:       "Beta",
+					Replacement: "Channel",
+					RemovedIn:   2,
+				},
+				{
+					Field:       "Staging",
+					Replacement: "Channel",
+					RemovedIn:   2,
+				},
+			},
+		},
+	},
+}
+
+func registerAPIVersionEndpoint() error {
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:      "core/api-version",
+		Read:      api.PermitAnyone,
+		BelongsTo: module,
+		StructFunc: func(ar *api.Request) (i interface{}, err error) {
+			accepted := ar.Request.URL.Query().Get("accept")
+			if accepted == "" {
+				accepted = ar.Request.Header.Get(apiVersionHeader)
+			}
+			return NegotiateAPIVersion(accepted)
+		},
+		Name:        "Negotiate API Version",
+		Description: "Returns the highest API version supported by both the client and the Portmaster, together with the stable record schemas and deprecations of that version. The versions the client supports are given comma separated, either in the accept parameter or in the " + apiVersionHeader + " header. Without versions, the latest version is returned.",
+		Parameters: []api.Parameter{{
+			Method:      http.MethodGet,
+			Field:       "accept",
+			Value:       "1,2",
+			Description: "Specify the API versions the client supports.",
+		}},
+	})
+}
+
+// NegotiateAPIVersion returns the highest API version of the given comma
+// separated versions that is supported. If none are given, the latest
+// version is used.
+func NegotiateAPIVersion(accepted string) (*APIVersionInfo, error) {
+	version := APIVersion
+	if accepted != "" {
+		version = 0
+		for _, field := range strings.Split(accepted, ",") {
+			v, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(field), "v"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid API version %q", field)
+			}
+			if v > version && isSupportedAPIVersion(v) {
+				version = v
+			}
+		}
+		if version == 0 {
+			return nil, fmt.Errorf("no compatible API version, supported versions are %s", formatAPIVersions(supportedAPIVersions))
+		}
+	}
+
+	return &APIVersionInfo{
+		Version:     version,
+		Supported:   supportedAPIVersions,
+		CoreVersion: info.Version(),
+		Schemas:     apiSchemas[version],
+	}, nil
+}
+
+func isSupportedAPIVersion(version int) bool {
+	for _, v := range supportedAPIVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+func formatAPIVersions(versions []int) string {
+	sorted := append([]int(nil), versions...)
+	sort.Ints(sorted)
+
+	formatted := make([]string, 0, len(sorted))
+	for _, v := range sorted {
+		formatted = append(formatted, strconv.Itoa(v))
+	}
+	return strings.Join(formatted, ", ")
+}
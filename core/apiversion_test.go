@@ -0,0 +1,76 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/safing/portbase/notifications"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/profile"
+)
+
+func TestStableRecordSchemas(t *testing.T) {
+	t.Parallel()
+
+	records := map[string]interface{}{
+		"Profile":      profile.Profile{},
+		"Connection":   network.Connection{},
+		"Notification": notifications.Notification{},
+	}
+
+	for _, version := range supportedAPIVersions {
+		for _, schema := range apiSchemas[version] {
+			record, ok := records[schema.Name]
+			if !ok {
+				continue
+			}
+			fields := jsonFields(reflect.TypeOf(record))
+			for _, field := range schema.Fields {
+				if _, ok := fields[field]; !ok {
+					t.Errorf("field %s of %s was removed, but is part of API version %d", field, schema.Name, version)
+				}
+			}
+		}
+	}
+}
+
+func jsonFields(typ reflect.Type) map[string]struct{} {
+	fields := make(map[string]struct{})
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" || field.Anonymous {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = field.Name
+		}
+		fields[name] = struct{}{}
+	}
+	return fields
+}
+
+func TestNegotiateAPIVersion(t *testing.T) {
+	t.Parallel()
+
+	v, err := NegotiateAPIVersion("")
+	if err != nil || v.Version != APIVersion {
+		t.Errorf("expected latest version without accepted versions, got %+v, %v", v, err)
+	}
+
+	v, err = NegotiateAPIVersion("v1, 99")
+	if err != nil || v.Version != 1 {
+		t.Errorf("expected version 1, got %+v, %v", v, err)
+	}
+
+	if _, err := NegotiateAPIVersion("99"); err == nil {
+		t.Error("unsupported versions should fail")
+	}
+	if _, err := NegotiateAPIVersion("one"); err == nil {
+		t.Error("invalid versions should fail")
+	}
+}
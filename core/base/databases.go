@@ -34,14 +34,14 @@ func registerDatabases() error {
 		return err
 	}
 
-	// _, err = database.Register(&database.Database{
-	//   Name:        "history",
-	//   Description: "Historic event data",
-	//   StorageType: DefaultDatabaseStorageType,
-	// })
-	// if err != nil {
-	//   return err
-	// }
+	_, err = database.Register(&database.Database{
+		Name:        "history",
+		Description: "Historic event data, if the history is kept in the Portmaster database",
+		StorageType: DefaultDatabaseStorageType,
+	})
+	if err != nil {
+		return err
+	}
 
 	return nil
 }
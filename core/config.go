@@ -13,6 +13,9 @@
 
 	CfgNetworkServiceKey      = "core/networkService"
 	defaultNetworkServiceMode bool
+
+	CfgLogFormatKey  = "core/log/format"
+	defaultLogFormat string
 )
 
 func init() {
@@ -22,6 +25,12 @@ func init() {
 		false,
 		"set default network service mode; configuration is stronger",
 	)
+	flag.StringVar(
+		&defaultLogFormat,
+		"log-format",
+		logFormatText,
+		"set default log output format [text|json]; configuration is stronger",
+	)
 }
 
 func registerConfig() error {
@@ -41,5 +50,35 @@ func registerConfig() error {
 		return err
 	}
 
+	if err := config.Register(&config.Option{
+		Name:           "Log Format",
+		Key:            CfgLogFormatKey,
+		Description:    "Configure the format of the log output. JSON outputs one object per line, which can be ingested by log collectors without parsing the text format.",
+		OptType:        config.OptTypeString,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   defaultLogFormat,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 517,
+			config.DisplayHintAnnotation:  config.DisplayHintOneOf,
+			config.CategoryAnnotation:     "Development",
+		},
+		PossibleValues: []config.PossibleValue{
+			{
+				Name:        "Text",
+				Value:       logFormatText,
+				Description: "Human readable text, including traces.",
+			},
+			{
+				Name:        "JSON",
+				Value:       logFormatJSON,
+				Description: "One JSON object per line. Traces include their steps in text form, their trace ID and the attributes of their spans as fields.",
+			},
+		},
+	}); err != nil {
+		return err
+	}
+	cfgLogFormat = config.Concurrent.GetAsString(CfgLogFormatKey, defaultLogFormat)
+
 	return nil
 }
@@ -7,8 +7,12 @@
 	"github.com/safing/portbase/modules/subsystems"
 
 	// module dependencies
+	_ "github.com/safing/portmaster/features"
+	_ "github.com/safing/portmaster/flightrecorder"
+	_ "github.com/safing/portmaster/history"
 	_ "github.com/safing/portmaster/netenv"
 	_ "github.com/safing/portmaster/status"
+	_ "github.com/safing/portmaster/tracing"
 	_ "github.com/safing/portmaster/ui"
 	_ "github.com/safing/portmaster/updates"
 )
@@ -52,5 +56,13 @@ func start() error {
 
 	registerLogCleaner()
 
-	return nil
+	if err := module.RegisterEventHook(
+		"config",
+		"config change",
+		"apply log format",
+		applyLogFormat,
+	); err != nil {
+		return err
+	}
+	return applyLogFormat(module.Ctx, nil)
 }
@@ -0,0 +1,173 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tevino/abool"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/flightrecorder"
+	"github.com/safing/portmaster/tracing"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+var (
+	cfgLogFormat config.StringOption
+
+	jsonLogs     = abool.New()
+	jsonLogsInit sync.Once
+)
+
+func init() {
+	log.SetAdapter(log.AdapterFunc(writeLog))
+}
+
+// jsonLogLine is a log line in the JSON log format.
+type jsonLogLine struct {
+	Time       string `json:"time,omitempty"`
+	Level      string `json:"level"`
+	Module     string `json:"module,omitempty"`
+	File       string `json:"file,omitempty"`
+	Line       int    `json:"line,omitempty"`
+	Msg        string `json:"msg"`
+	Duplicates uint64 `json:"duplicates,omitempty"`
+	// TraceID is the ID of the trace the log line belongs to, as exported by
+	// the tracing module.
+	TraceID string `json:"trace_id,omitempty"`
+	// Fields holds the attributes of the spans of the trace.
+	Fields map[string]string `json:"fields,omitempty"`
+	// Trace holds the steps of the trace in the text format.
+	Trace []string `json:"trace,omitempty"`
+}
+
+func writeLog(msg log.Message, duplicates uint64) {
+	if !flightrecorder.RecordLog(msg, duplicates) {
+		return
+	}
+
+	if useJSONLogs() {
+		writeJSONLog(msg, duplicates)
+	} else {
+		log.StdoutAdapter.Write(msg, duplicates)
+	}
+}
+
+func useJSONLogs() bool {
+	// The flag is parsed before the first log line is written.
+	jsonLogsInit.Do(func() {
+		jsonLogs.SetTo(defaultLogFormat == logFormatJSON)
+	})
+	return jsonLogs.IsSet()
+}
+
+func applyLogFormat(_ context.Context, _ interface{}) error {
+	useJSONLogs()
+	jsonLogs.SetTo(cfgLogFormat() == logFormatJSON)
+	return nil
+}
+
+func writeJSONLog(msg log.Message, duplicates uint64) {
+	main := &jsonLogLine{
+		Time:       msg.Time().Format(time.RFC3339Nano),
+		Level:      msg.Severity().Name(),
+		Msg:        msg.Text(),
+		Duplicates: duplicates,
+	}
+	main.Module, main.File = splitLogFile(msg.File())
+	main.Line = msg.LineNumber()
+
+	// Log lines only have a trace if tracers are enabled, which is only the
+	// case when logging on the trace level or when the flight recorder is
+	// recording.
+	if log.GetLogLevel() == log.TraceLevel || flightrecorder.Recording() {
+		applyTrace(main, getTraceSteps(msg))
+	}
+
+	data, err := json.Marshal(main)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to format log line as json: %s\n", err)
+		return
+	}
+	fmt.Fprintln(os.Stdout, string(data))
+}
+
+// splitLogFile returns the module and the shortened file path of the given
+// source file path, as recorded by the log package.
+func splitLogFile(path string) (module, file string) {
+	segments := strings.Split(path, "/")
+	if len(segments) < 2 {
+		return "", path
+	}
+	module = segments[len(segments)-2]
+	return module, module + "/" + segments[len(segments)-1]
+}
+
+// getTraceSteps returns the steps of the trace attached to the log line. The
+// log package only exposes them through its text formatter, which outputs
+// every step on a separate line after the main line.
+func getTraceSteps(msg log.Message) []string {
+	lines := strings.Split(log.StdoutAdapter.Format(msg, 0), "\n")
+	if len(lines) < 2 {
+		return nil
+	}
+
+	steps := make([]string, 0, len(lines)-1)
+	for _, line := range lines[1:] {
+		steps = append(steps, strings.TrimSpace(stripColors(line)))
+	}
+	return steps
+}
+
+// applyTrace adds the given trace steps to the log line. Steps that hold the
+// trace ID or attributes of spans are moved to their respective fields.
+func applyTrace(line *jsonLogLine, steps []string) {
+	for _, step := range steps {
+		if i := strings.Index(step, tracing.LogTraceIDPrefix); i >= 0 {
+			if line.TraceID == "" {
+				line.TraceID = step[i+len(tracing.LogTraceIDPrefix):]
+			}
+			continue
+		}
+		if i := strings.Index(step, tracing.LogAttributePrefix); i >= 0 {
+			attribute := strings.SplitN(step[i+len(tracing.LogAttributePrefix):], "=", 2)
+			if len(attribute) == 2 {
+				if line.Fields == nil {
+					line.Fields = make(map[string]string)
+				}
+				line.Fields[attribute[0]] = attribute[1]
+			}
+			continue
+		}
+		line.Trace = append(line.Trace, step)
+	}
+}
+
+// stripColors removes the terminal color codes of the text formatter.
+func stripColors(s string) string {
+	var stripped strings.Builder
+	for {
+		start := strings.Index(s, "\033[")
+		if start < 0 {
+			stripped.WriteString(s)
+			return stripped.String()
+		}
+		end := strings.IndexByte(s[start:], 'm')
+		if end < 0 {
+			stripped.WriteString(s)
+			return stripped.String()
+		}
+		stripped.WriteString(s[:start])
+		s = s[start+end+1:]
+	}
+}
@@ -0,0 +1,43 @@
+package core
+
+import "testing"
+
+func TestStripColors(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		in  string
+		out string
+	}{
+		{"", ""},
+		{"plain", "plain"},
+		{"\033[34m1.2ms firewall:042 ▶ INFO\033[0m     msg", "1.2ms firewall:042 ▶ INFO     msg"},
+		{"broken \033[34", "broken \033[34"},
+	} {
+		if got := stripColors(test.in); got != test.out {
+			t.Errorf("stripColors(%q) = %q, expected %q", test.in, got, test.out)
+		}
+	}
+}
+
+func TestApplyTrace(t *testing.T) {
+	t.Parallel()
+
+	line := &jsonLogLine{}
+	applyTrace(line, []string{
+		"12µs nameserver:074 ▶ TRAC     nameserver: handling new request for example.com.A",
+		"3µs span:069 ▶ TRAC     tracing: trace 0123456789abcdef0123456789abcdef",
+		"1µs span:098 ▶ TRAC     tracing: attribute dns.question=example.com.A",
+		"1µs span:098 ▶ TRAC     tracing: attribute reason=a=b",
+	})
+
+	if line.TraceID != "0123456789abcdef0123456789abcdef" {
+		t.Errorf("unexpected trace ID %q", line.TraceID)
+	}
+	if len(line.Fields) != 2 || line.Fields["dns.question"] != "example.com.A" || line.Fields["reason"] != "a=b" {
+		t.Errorf("unexpected fields %v", line.Fields)
+	}
+	if len(line.Trace) != 1 {
+		t.Errorf("unexpected trace steps %v", line.Trace)
+	}
+}
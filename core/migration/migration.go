@@ -0,0 +1,165 @@
+package migration
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/log"
+)
+
+// Migration describes how a deprecated config option or value is carried
+// over to its replacement, so that refactoring settings does not silently
+// drop the configuration of users.
+type Migration struct {
+	// ID uniquely identifies the migration. Applied migrations are recorded
+	// by their ID for the global config and for every profile, so that they
+	// are only applied once.
+	ID string
+	// OldKey is the key of the deprecated option.
+	OldKey string
+	// NewKey is the key of the option that replaces the deprecated option.
+	// If NewKey equals OldKey, only the value is migrated. If NewKey is empty,
+	// the option was removed on purpose and the old value is dropped.
+	NewKey string
+	// Convert optionally converts the old value to a value valid for the new
+	// option. It must return false if the value does not need to be changed.
+	Convert func(oldValue interface{}) (newValue interface{}, changed bool)
+}
+
+var (
+	migrations     []*Migration
+	migrationsLock sync.RWMutex
+
+	warnedKeys     = make(map[string]struct{})
+	warnedKeysLock sync.Mutex
+)
+
+// Register registers a new config migration. Migrations are applied in the
+// order they were registered.
+func Register(m *Migration) error {
+	switch {
+	case m.ID == "":
+		return errors.New("migration is missing an ID")
+	case m.OldKey == "":
+		return fmt.Errorf("migration %s is missing the old key", m.ID)
+	case m.OldKey == m.NewKey && m.Convert == nil:
+		return fmt.Errorf("migration %s migrates value, but has no convert function", m.ID)
+	}
+
+	migrationsLock.Lock()
+	defer migrationsLock.Unlock()
+
+	for _, existing := range migrations {
+		if existing.ID == m.ID {
+			return fmt.Errorf("migration %s already registered", m.ID)
+		}
+	}
+	migrations = append(migrations, m)
+	return nil
+}
+
+// getMigrations returns the migrations that apply to the given key.
+func getMigrations(key string) []*Migration {
+	migrationsLock.RLock()
+	defer migrationsLock.RUnlock()
+
+	var applicable []*Migration
+	for _, m := range migrations {
+		if m.OldKey == key {
+			applicable = append(applicable, m)
+		}
+	}
+	return applicable
+}
+
+// apply applies the migration to the given flattened config and returns
+// whether the config was changed.
+func (m *Migration) apply(flattened map[string]interface{}) (changed bool) {
+	oldValue, ok := flattened[m.OldKey]
+	if !ok {
+		return false
+	}
+
+	newValue := oldValue
+	valueChanged := false
+	if m.Convert != nil {
+		newValue, valueChanged = m.Convert(oldValue)
+	}
+
+	switch m.NewKey {
+	case "":
+		delete(flattened, m.OldKey)
+		return true
+	case m.OldKey:
+		if valueChanged {
+			flattened[m.OldKey] = newValue
+		}
+		return valueChanged
+	default:
+		delete(flattened, m.OldKey)
+		// Do not overwrite a value that was already set for the new option.
+		if _, ok := flattened[m.NewKey]; !ok {
+			flattened[m.NewKey] = newValue
+		}
+		return true
+	}
+}
+
+// MigrateConfig applies the registered migrations to the given flattened
+// config in the order they were registered, so that a migration can build on
+// the result of an earlier one. Migrations for which isApplied returns true
+// are skipped; isApplied may be nil. It returns the IDs of the applied
+// migrations.
+func MigrateConfig(flattened map[string]interface{}, isApplied func(id string) bool) (applied []string) {
+	migrationsLock.RLock()
+	registered := append([]*Migration(nil), migrations...)
+	migrationsLock.RUnlock()
+
+	for _, m := range registered {
+		if isApplied != nil && isApplied(m.ID) {
+			continue
+		}
+		if m.apply(flattened) {
+			applied = append(applied, m.ID)
+		}
+	}
+
+	return applied
+}
+
+// MigrateHierarchicalConfig applies the registered migrations to the given
+// hierarchical config, as used by profiles, like MigrateConfig. It returns the
+// migrated config and the IDs of the applied migrations.
+func MigrateHierarchicalConfig(hierarchical map[string]interface{}, isApplied func(id string) bool) (migrated map[string]interface{}, applied []string) {
+	flattened := config.Flatten(hierarchical)
+	applied = MigrateConfig(flattened, isApplied)
+	if len(applied) == 0 {
+		return hierarchical, nil
+	}
+	return config.Expand(flattened), applied
+}
+
+// WarnAbandonedKeys logs a warning for every key of the given flattened
+// config that is neither a registered option nor handled by a migration.
+// Every key is only reported once.
+func WarnAbandonedKeys(flattened map[string]interface{}, context string) {
+	for key := range flattened {
+		if _, err := config.GetOption(key); err == nil {
+			continue
+		}
+		if len(getMigrations(key)) > 0 {
+			continue
+		}
+
+		warnedKeysLock.Lock()
+		_, warned := warnedKeys[key]
+		warnedKeys[key] = struct{}{}
+		warnedKeysLock.Unlock()
+
+		if !warned {
+			log.Warningf("config: %s contains unknown option %s, its value will be ignored", context, key)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package migration
+
+import (
+	"testing"
+)
+
+func TestMigrateConfig(t *testing.T) {
+	// Not parallel, as migrations are registered globally.
+
+	for _, m := range []*Migration{
+		{
+			ID:     "test-rename",
+			OldKey: "test/old",
+			NewKey: "test/new",
+		},
+		{
+			ID:     "test-value",
+			OldKey: "test/value",
+			NewKey: "test/value",
+			Convert: func(oldValue interface{}) (interface{}, bool) {
+				if oldValue == "legacy" {
+					return "current", true
+				}
+				return oldValue, false
+			},
+		},
+		{
+			ID:     "test-remove",
+			OldKey: "test/removed",
+		},
+	} {
+		if err := Register(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	flattened := map[string]interface{}{
+		"test/old":     true,
+		"test/value":   "legacy",
+		"test/removed": 1,
+		"test/other":   "keep",
+	}
+	applied := MigrateConfig(flattened, nil)
+	if len(applied) != 3 {
+		t.Errorf("expected 3 applied migrations, got %v", applied)
+	}
+	if flattened["test/new"] != true {
+		t.Error("renamed option was not migrated")
+	}
+	if flattened["test/value"] != "current" {
+		t.Error("value was not migrated")
+	}
+	for _, key := range []string{"test/old", "test/removed"} {
+		if _, ok := flattened[key]; ok {
+			t.Errorf("deprecated option %s was not removed", key)
+		}
+	}
+	if flattened["test/other"] != "keep" {
+		t.Error("unrelated option was changed")
+	}
+
+	// Migrations must not be applied twice.
+	if applied := MigrateConfig(flattened, nil); len(applied) != 0 {
+		t.Errorf("expected no applied migrations, got %v", applied)
+	}
+}
+
+func TestChainedMigrations(t *testing.T) {
+	// Not parallel, as migrations are registered globally.
+
+	for _, m := range []*Migration{
+		{
+			ID:     "test-chain-1",
+			OldKey: "test/chain/a",
+			NewKey: "test/chain/b",
+		},
+		{
+			ID:     "test-chain-2",
+			OldKey: "test/chain/b",
+			NewKey: "test/chain/c",
+		},
+	} {
+		if err := Register(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Migrations are applied in order, so that they build on each other.
+	flattened := map[string]interface{}{
+		"test/chain/a": "value",
+	}
+	applied := MigrateConfig(flattened, nil)
+	if len(applied) != 2 || applied[0] != "test-chain-1" || applied[1] != "test-chain-2" {
+		t.Errorf("expected both chained migrations in order, got %v", applied)
+	}
+	if flattened["test/chain/c"] != "value" || len(flattened) != 1 {
+		t.Errorf("chained migrations were not applied: %v", flattened)
+	}
+
+	// Migrations that were already applied are skipped.
+	flattened = map[string]interface{}{
+		"test/chain/a": "value",
+	}
+	applied = MigrateConfig(flattened, func(id string) bool {
+		return id == "test-chain-1"
+	})
+	if len(applied) != 0 || flattened["test/chain/a"] != "value" {
+		t.Errorf("applied migration was applied again: %v, %v", applied, flattened)
+	}
+}
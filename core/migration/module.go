@@ -0,0 +1,134 @@
+package migration
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/dataroot"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+
+	// module dependencies
+	_ "github.com/safing/portmaster/core/base"
+)
+
+const appliedMigrationsKey = "core:migrations/config"
+
+var (
+	module *modules.Module
+
+	db = database.NewInterface(&database.Options{
+		Local:    true,
+		Internal: true,
+	})
+)
+
+func init() {
+	module = modules.Register("config-migration", nil, start, nil, "base")
+}
+
+// appliedMigrations records which migrations were applied to the global
+// config and when.
+type appliedMigrations struct {
+	record.Base
+	sync.Mutex
+
+	Applied map[string]time.Time
+}
+
+func start() error {
+	return migrateGlobalConfig()
+}
+
+// migrateGlobalConfig applies migrations to the global config. Options that
+// are not registered are dropped by the config module, so the values of
+// deprecated options are read from the config file directly.
+func migrateGlobalConfig() error {
+	data, err := ioutil.ReadFile(filepath.Join(dataroot.Root().Path, "config.json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	flattened, err := config.JSONToMap(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse config file: %w", err)
+	}
+	original := make(map[string]interface{}, len(flattened))
+	for key, value := range flattened {
+		original[key] = value
+	}
+
+	am, err := getAppliedMigrations()
+	if err != nil {
+		return err
+	}
+	applied := MigrateConfig(flattened, func(id string) bool {
+		_, ok := am.Applied[id]
+		return ok
+	})
+	WarnAbandonedKeys(flattened, "global config")
+	if len(applied) == 0 {
+		return nil
+	}
+
+	// Apply changed values to the config. This also removes the deprecated
+	// options from the config file, as only registered options are saved.
+	for key, value := range flattened {
+		if previous, ok := original[key]; ok && fmt.Sprint(previous) == fmt.Sprint(value) {
+			continue
+		}
+		if err := config.SetConfigOption(key, value); err != nil {
+			log.Warningf("config: failed to set migrated value of %s: %s", key, err)
+		}
+	}
+	log.Infof("config: applied migrations to global config: %v", applied)
+
+	// Record applied migrations.
+	for _, id := range applied {
+		if _, ok := am.Applied[id]; !ok {
+			am.Applied[id] = time.Now()
+		}
+	}
+	return db.Put(am)
+}
+
+func getAppliedMigrations() (*appliedMigrations, error) {
+	r, err := db.Get(appliedMigrationsKey)
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		am := &appliedMigrations{
+			Applied: make(map[string]time.Time),
+		}
+		am.SetKey(appliedMigrationsKey)
+		return am, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to get applied migrations: %w", err)
+	}
+
+	am := &appliedMigrations{}
+	if r.IsWrapped() {
+		if err := record.Unwrap(r, am); err != nil {
+			return nil, err
+		}
+	} else {
+		var ok bool
+		am, ok = r.(*appliedMigrations)
+		if !ok {
+			return nil, fmt.Errorf("invalid type, expected appliedMigrations but got %T", r)
+		}
+	}
+	if am.Applied == nil {
+		am.Applied = make(map[string]time.Time)
+	}
+	return am, nil
+}
@@ -0,0 +1,321 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/pprof/profile"
+	processInfo "github.com/shirou/gopsutil/process"
+
+	"github.com/safing/portbase/api"
+)
+
+const (
+	defaultResourceSampleDuration = 2 * time.Second
+	maxResourceSampleDuration     = 30 * time.Second
+
+	// otherSubsystem is used for stacks without any code of the Portmaster.
+	otherSubsystem = "go runtime"
+
+	// ModuleLabel and TaskLabel are the profiler labels by which CPU usage
+	// and goroutines are attributed. Set them with pprof.Do on long running
+	// workers; goroutines started by them inherit the labels.
+	ModuleLabel = "module"
+	TaskLabel   = "task"
+)
+
+// ResourceUsage describes the CPU and memory usage of the Portmaster and how
+// it is distributed over its subsystems.
+type ResourceUsage struct {
+	// SampleSeconds is the duration of the measurement.
+	SampleSeconds float64
+	// CPUPercent is the CPU usage of the process, where 100 is one core.
+	CPUPercent float64
+	// HeapInUse is the amount of heap memory in use in bytes.
+	HeapInUse uint64
+	// Goroutines is the current amount of goroutines.
+	Goroutines int
+	// Subsystems holds the usage per subsystem, sorted by CPU usage.
+	Subsystems []*SubsystemUsage
+}
+
+// SubsystemUsage describes the CPU and memory usage of a subsystem. This is a
+// module and task, if the code was labeled with them, or else a package of
+// the Portmaster, the SPN or the Portbase.
+type SubsystemUsage struct {
+	Subsystem string
+	// Task is the labeled task within the module, if any.
+	Task string `json:",omitempty"`
+	// CPUPercent is the estimated share of the process CPU usage.
+	CPUPercent float64
+	// HeapInUse is the estimated amount of heap memory allocated by the
+	// subsystem that is still in use, in bytes. Heap profiles have no
+	// labels, so the heap is always attributed by package.
+	HeapInUse uint64
+	// Goroutines is the amount of goroutines currently running code of the
+	// subsystem.
+	Goroutines int
+}
+
+type subsystemKey struct {
+	subsystem string
+	task      string
+}
+
+var resourceSamplingLock sync.Mutex
+
+func registerResourceUsageAPI() error {
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:        "debug/resources",
+		Read:        api.PermitUser,
+		BelongsTo:   module,
+		StructFunc:  handleResourceUsage,
+		Name:        "Get Resource Usage",
+		Description: "Measures the CPU and memory usage of the Portmaster and attributes it to its subsystems. CPU usage is attributed by a CPU profile, using the module and task labels of the profiled code, memory by the heap profile.",
+		Parameters: []api.Parameter{{
+			Method:      http.MethodGet,
+			Field:       "duration",
+			Value:       "2s",
+			Description: "Specify how long to measure CPU usage. The maximum is 30s.",
+		}},
+	})
+}
+
+func handleResourceUsage(ar *api.Request) (i interface{}, err error) {
+	duration := defaultResourceSampleDuration
+	if durationParam := ar.Request.URL.Query().Get("duration"); durationParam != "" {
+		duration, err = time.ParseDuration(durationParam)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration: %w", err)
+		}
+	}
+	if duration <= 0 || duration > maxResourceSampleDuration {
+		return nil, fmt.Errorf("duration must be between 0s and %s", maxResourceSampleDuration)
+	}
+
+	return GetResourceUsage(ar.Context().Done(), duration)
+}
+
+// GetResourceUsage measures the resource usage of the Portmaster over the
+// given duration. Only one measurement runs at a time, and none while a CPU
+// profile is written with the -cpuprofile flag.
+func GetResourceUsage(cancel <-chan struct{}, duration time.Duration) (*ResourceUsage, error) {
+	resourceSamplingLock.Lock()
+	defer resourceSamplingLock.Unlock()
+
+	proc, err := processInfo.NewProcess(int32(os.Getpid()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get own process: %w", err)
+	}
+	startTimes, err := proc.Times()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cpu times: %w", err)
+	}
+	started := time.Now()
+
+	// Profile the CPU usage.
+	cpuProfile := &bytes.Buffer{}
+	if err := pprof.StartCPUProfile(cpuProfile); err != nil {
+		return nil, fmt.Errorf("failed to start cpu profile: %w", err)
+	}
+	select {
+	case <-cancel:
+		pprof.StopCPUProfile()
+		return nil, errors.New("canceled")
+	case <-time.After(duration):
+	}
+	pprof.StopCPUProfile()
+
+	endTimes, err := proc.Times()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cpu times: %w", err)
+	}
+	elapsed := time.Since(started).Seconds()
+	usage := &ResourceUsage{
+		SampleSeconds: elapsed,
+		CPUPercent:    100 * (endTimes.Total() - startTimes.Total()) / elapsed,
+		Goroutines:    runtime.NumGoroutine(),
+	}
+
+	subsystems := make(map[subsystemKey]*SubsystemUsage)
+	getSubsystem := func(key subsystemKey) *SubsystemUsage {
+		s, ok := subsystems[key]
+		if !ok {
+			s = &SubsystemUsage{Subsystem: key.subsystem, Task: key.task}
+			subsystems[key] = s
+		}
+		return s
+	}
+
+	// Distribute the CPU usage.
+	cpuSamples, totalSamples, err := samplesBySubsystem(cpuProfile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse cpu profile: %w", err)
+	}
+	for key, samples := range cpuSamples {
+		getSubsystem(key).CPUPercent = usage.CPUPercent * float64(samples) / float64(totalSamples)
+	}
+
+	// Count goroutines.
+	goroutineProfile := &bytes.Buffer{}
+	if err := pprof.Lookup("goroutine").WriteTo(goroutineProfile, 0); err != nil {
+		return nil, fmt.Errorf("failed to get goroutine profile: %w", err)
+	}
+	goroutines, _, err := samplesBySubsystem(goroutineProfile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse goroutine profile: %w", err)
+	}
+	for key, count := range goroutines {
+		getSubsystem(key).Goroutines = int(count)
+	}
+
+	// Distribute the heap in use.
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	usage.HeapInUse = memStats.HeapInuse
+	heapSamples, totalHeapSamples := heapBySubsystem()
+	for name, inUse := range heapSamples {
+		if totalHeapSamples == 0 {
+			break
+		}
+		getSubsystem(subsystemKey{subsystem: name}).HeapInUse = uint64(float64(memStats.HeapInuse) * float64(inUse) / float64(totalHeapSamples))
+	}
+
+	usage.Subsystems = make([]*SubsystemUsage, 0, len(subsystems))
+	for _, s := range subsystems {
+		usage.Subsystems = append(usage.Subsystems, s)
+	}
+	sort.Slice(usage.Subsystems, func(i, j int) bool {
+		a, b := usage.Subsystems[i], usage.Subsystems[j]
+		if a.CPUPercent != b.CPUPercent {
+			return a.CPUPercent > b.CPUPercent
+		}
+		if a.HeapInUse != b.HeapInUse {
+			return a.HeapInUse > b.HeapInUse
+		}
+		if a.Subsystem != b.Subsystem {
+			return a.Subsystem < b.Subsystem
+		}
+		return a.Task < b.Task
+	})
+
+	return usage, nil
+}
+
+// samplesBySubsystem returns the sample counts of the given profile per
+// subsystem. Samples are attributed by their module and task labels, or by
+// their stack if they have no module label.
+func samplesBySubsystem(data io.Reader) (samples map[subsystemKey]int64, total int64, err error) {
+	prof, err := profile.Parse(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	samples = make(map[subsystemKey]int64)
+	for _, sample := range prof.Sample {
+		if len(sample.Value) == 0 {
+			continue
+		}
+		count := sample.Value[0]
+
+		var key subsystemKey
+		if modules := sample.Label[ModuleLabel]; len(modules) > 0 {
+			key.subsystem = modules[0]
+			if tasks := sample.Label[TaskLabel]; len(tasks) > 0 {
+				key.task = tasks[0]
+			}
+		} else {
+			var funcs []string
+			for _, location := range sample.Location {
+				for _, line := range location.Line {
+					if line.Function != nil {
+						funcs = append(funcs, line.Function.Name)
+					}
+				}
+			}
+			key.subsystem = subsystemOf(funcs)
+		}
+
+		samples[key] += count
+		total += count
+	}
+	return samples, total, nil
+}
+
+// heapBySubsystem returns the sampled heap memory in use per subsystem.
+func heapBySubsystem() (inUse map[string]int64, total int64) {
+	var records []runtime.MemProfileRecord
+	n, _ := runtime.MemProfile(nil, true)
+	for {
+		records = make([]runtime.MemProfileRecord, n+50)
+		var ok bool
+		n, ok = runtime.MemProfile(records, true)
+		if ok {
+			records = records[:n]
+			break
+		}
+	}
+
+	inUse = make(map[string]int64)
+	for _, record := range records {
+		bytesInUse := record.InUseBytes()
+		if bytesInUse <= 0 {
+			continue
+		}
+
+		var funcs []string
+		frames := runtime.CallersFrames(record.Stack())
+		for {
+			frame, more := frames.Next()
+			funcs = append(funcs, frame.Function)
+			if !more {
+				break
+			}
+		}
+
+		inUse[subsystemOf(funcs)] += bytesInUse
+		total += bytesInUse
+	}
+	return inUse, total
+}
+
+// subsystemOf returns the subsystem responsible for the given stack of
+// function names, innermost first. This is the innermost package of the
+// Portmaster or the SPN. Portbase packages are only used if there are none,
+// as they are mostly called on behalf of others.
+func subsystemOf(funcs []string) string {
+	var portbase string
+	for _, fn := range funcs {
+		pkg := packageOf(fn)
+		switch {
+		case strings.HasPrefix(pkg, "github.com/safing/portmaster/"),
+			strings.HasPrefix(pkg, "github.com/safing/spn/"):
+			return strings.TrimPrefix(pkg, "github.com/safing/")
+		case portbase == "" && strings.HasPrefix(pkg, "github.com/safing/portbase/"):
+			portbase = strings.TrimPrefix(pkg, "github.com/safing/")
+		}
+	}
+	if portbase != "" {
+		return portbase
+	}
+	return otherSubsystem
+}
+
+// packageOf returns the package path of the given function name.
+func packageOf(fn string) string {
+	lastSlash := strings.LastIndex(fn, "/")
+	if dot := strings.Index(fn[lastSlash+1:], "."); dot >= 0 {
+		return fn[:lastSlash+1+dot]
+	}
+	return fn
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestSamplesBySubsystem(t *testing.T) {
+	t.Parallel()
+
+	filterlists := &profile.Function{ID: 1, Name: "github.com/safing/portmaster/intel/filterlists.LookupDomain"}
+	database := &profile.Function{ID: 2, Name: "github.com/safing/portbase/database.(*Interface).Get"}
+	gopark := &profile.Function{ID: 3, Name: "runtime.gopark"}
+	location := func(id uint64, fn *profile.Function) *profile.Location {
+		return &profile.Location{ID: id, Line: []profile.Line{{Function: fn}}}
+	}
+	filterlistsLoc := location(1, filterlists)
+	databaseLoc := location(2, database)
+	goparkLoc := location(3, gopark)
+
+	prof := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		Function:   []*profile.Function{filterlists, database, gopark},
+		Location:   []*profile.Location{filterlistsLoc, databaseLoc, goparkLoc},
+		Sample: []*profile.Sample{
+			{
+				// Labeled samples are attributed by their labels.
+				Location: []*profile.Location{databaseLoc, filterlistsLoc},
+				Value:    []int64{3},
+				Label:    map[string][]string{ModuleLabel: {"interception"}, TaskLabel: {"packet handler"}},
+			},
+			{
+				Location: []*profile.Location{databaseLoc},
+				Value:    []int64{1},
+				Label:    map[string][]string{ModuleLabel: {"nameserver"}},
+			},
+			{
+				// Unlabeled samples are attributed by their stack.
+				Location: []*profile.Location{databaseLoc, filterlistsLoc},
+				Value:    []int64{2},
+			},
+			{
+				Location: []*profile.Location{databaseLoc},
+				Value:    []int64{4},
+			},
+			{
+				Location: []*profile.Location{goparkLoc},
+				Value:    []int64{5},
+			},
+		},
+	}
+	data := &bytes.Buffer{}
+	if err := prof.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	samples, total, err := samplesBySubsystem(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[subsystemKey]int64{
+		{subsystem: "interception", task: "packet handler"}: 3,
+		{subsystem: "nameserver"}:                           1,
+		{subsystem: "portmaster/intel/filterlists"}:         2,
+		{subsystem: "portbase/database"}:                    4,
+		{subsystem: otherSubsystem}:                         5,
+	}
+	if total != 15 {
+		t.Errorf("expected 15 samples, got %d", total)
+	}
+	if len(samples) != len(expected) {
+		t.Errorf("expected %d subsystems, got %d: %+v", len(expected), len(samples), samples)
+	}
+	for key, count := range expected {
+		if samples[key] != count {
+			t.Errorf("%+v: expected %d samples, got %d", key, count, samples[key])
+		}
+	}
+}
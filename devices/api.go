@@ -0,0 +1,69 @@
+package devices
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/safing/portbase/api"
+)
+
+func registerAPIEndpoints() error {
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "devices",
+		Read:      api.PermitUser,
+		BelongsTo: module,
+		StructFunc: func(_ *api.Request) (i interface{}, err error) {
+			return ListDevices(), nil
+		},
+		Name:        "List Devices",
+		Description: "Returns the devices on the local network that were discovered while the Portmaster is used as a network service.",
+	}); err != nil {
+		return err
+	}
+
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:        "devices/{mac:[0-9a-fA-F:]+}",
+		Read:        api.PermitUser,
+		Write:       api.PermitUser,
+		BelongsTo:   module,
+		StructFunc:  handleDevice,
+		Name:        "Get or Update Device",
+		Description: "Returns a device, or sets its name and policy if sent via POST. Isolated devices may only reach the local network and the allowed domains, blocked devices may not reach anything through the Portmaster.",
+		Parameters: []api.Parameter{{
+			Method:      http.MethodPost,
+			Field:       "body",
+			Value:       `{"Name":"Thermostat","Policy":"isolate","AllowedDomains":["vendor-cloud.example"]}`,
+			Description: "Set the name, policy and allowed domains of the device.",
+		}},
+	})
+}
+
+type deviceUpdate struct {
+	Name           string
+	Policy         string
+	AllowedDomains []string
+}
+
+func handleDevice(ar *api.Request) (i interface{}, err error) {
+	mac := ar.URLVars["mac"]
+
+	switch ar.Method {
+	case http.MethodPost, http.MethodPut:
+		if len(ar.InputData) == 0 {
+			return nil, errors.New("missing device update in request body")
+		}
+		update := &deviceUpdate{}
+		if err := json.Unmarshal(ar.InputData, update); err != nil {
+			return nil, fmt.Errorf("failed to parse device update: %w", err)
+		}
+		return UpdateDevice(mac, update.Name, update.Policy, update.AllowedDomains)
+	}
+
+	device, ok := GetDevice(mac)
+	if !ok {
+		return nil, fmt.Errorf("unknown device %s", mac)
+	}
+	return device, nil
+}
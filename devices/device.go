@@ -0,0 +1,257 @@
+package devices
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/log"
+)
+
+const devicesDBPath = "core:devices/"
+
+// Device Policies
+const (
+	// PolicyDefault filters the device with its network profile only.
+	PolicyDefault = ""
+	// PolicyIsolate blocks the device from the Internet, except for the
+	// allowed domains.
+	PolicyIsolate = "isolate"
+	// PolicyBlock blocks all forwarded traffic of the device.
+	PolicyBlock = "block"
+)
+
+// Device is a device on the local network that uses the Portmaster as its
+// gateway. Devices are identified by their hardware address, as their IP
+// addresses may change.
+type Device struct {
+	record.Base
+	sync.Mutex
+
+	// MAC is the hardware address of the device.
+	MAC string
+	// Name is a user defined name of the device.
+	Name string
+	// IPs holds the IP addresses the device was last seen with.
+	IPs []string
+	// FirstSeen holds the UTC timestamp in seconds when the device was first
+	// discovered.
+	FirstSeen int64
+	// LastSeen holds the approximate UTC timestamp in seconds when the device
+	// was last seen in the neighbor tables.
+	LastSeen int64
+
+	// Policy is the policy that is enforced for the device.
+	Policy string
+	// AllowedDomains holds the domains, including their subdomains, an
+	// isolated device may still query and connect to.
+	AllowedDomains []string
+}
+
+var (
+	db = database.NewInterface(&database.Options{
+		Local:    true,
+		Internal: true,
+	})
+
+	devices     = make(map[string]*Device) // key: MAC
+	devicesByIP = make(map[string]*Device) // key: IP
+	devicesLock sync.RWMutex
+)
+
+// loadDevices loads all devices from the database.
+func loadDevices() error {
+	it, err := db.Query(query.New(devicesDBPath))
+	if err != nil {
+		return err
+	}
+
+	devicesLock.Lock()
+	defer devicesLock.Unlock()
+
+	for r := range it.Next {
+		device := &Device{}
+		if err := record.Unwrap(r, device); err != nil {
+			log.Warningf("devices: failed to parse device %s: %s", r.Key(), err)
+			continue
+		}
+		devices[device.MAC] = device
+		for _, ip := range device.IPs {
+			devicesByIP[ip] = device
+		}
+	}
+	return it.Err()
+}
+
+// GetDevice returns a copy of the device with the given hardware address.
+func GetDevice(mac string) (*Device, bool) {
+	devicesLock.RLock()
+	defer devicesLock.RUnlock()
+
+	device, ok := devices[strings.ToLower(mac)]
+	if !ok {
+		return nil, false
+	}
+	return device.copy(), true
+}
+
+// ListDevices returns copies of all known devices, sorted by hardware address.
+func ListDevices() []*Device {
+	devicesLock.RLock()
+	defer devicesLock.RUnlock()
+
+	list := make([]*Device, 0, len(devices))
+	for _, device := range devices {
+		list = append(list, device.copy())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].MAC < list[j].MAC
+	})
+	return list
+}
+
+// UpdateDevice sets the user defined attributes of the device with the given
+// hardware address and enforces the new policy.
+func UpdateDevice(mac, name, policy string, allowedDomains []string) (*Device, error) {
+	switch policy {
+	case PolicyDefault, PolicyIsolate, PolicyBlock:
+	default:
+		return nil, fmt.Errorf("unknown policy %q", policy)
+	}
+
+	normalized := make([]string, 0, len(allowedDomains))
+	for _, domain := range allowedDomains {
+		domain = strings.Trim(strings.ToLower(strings.TrimSpace(domain)), ".")
+		if domain != "" {
+			normalized = append(normalized, domain)
+		}
+	}
+
+	devicesLock.RLock()
+	device, ok := devices[strings.ToLower(mac)]
+	devicesLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown device %s", mac)
+	}
+
+	device.Lock()
+	device.Name = name
+	device.Policy = policy
+	device.AllowedDomains = normalized
+	device.Unlock()
+
+	if err := db.Put(device); err != nil {
+		return nil, err
+	}
+	scheduleIsolationUpdate()
+
+	return device.copy(), nil
+}
+
+// updateFromNeighbors adds new devices and updates the IPs of known devices.
+func updateFromNeighbors(neighbors map[string][]net.IP) {
+	now := time.Now().Unix()
+
+	devicesLock.Lock()
+	defer devicesLock.Unlock()
+
+	for mac, neighborIPs := range neighbors {
+		ips := make([]string, 0, len(neighborIPs))
+		for _, ip := range neighborIPs {
+			ips = append(ips, ip.String())
+		}
+		sort.Strings(ips)
+
+		device, ok := devices[mac]
+		if !ok {
+			device = &Device{
+				MAC:       mac,
+				FirstSeen: now,
+			}
+			device.SetKey(devicesDBPath + mac)
+			devices[mac] = device
+			log.Infof("devices: discovered new device %s at %s", mac, strings.Join(ips, ", "))
+		}
+
+		device.Lock()
+		changed := !ok || strings.Join(device.IPs, ",") != strings.Join(ips, ",") ||
+			now-device.LastSeen > int64(lastSeenUpdateThreshold/time.Second)
+		for _, ip := range device.IPs {
+			delete(devicesByIP, ip)
+		}
+		device.IPs = ips
+		device.LastSeen = now
+		for _, ip := range ips {
+			devicesByIP[ip] = device
+		}
+		device.Unlock()
+
+		if changed {
+			if err := db.Put(device); err != nil {
+				log.Warningf("devices: failed to save device %s: %s", mac, err)
+			}
+		}
+	}
+}
+
+// GetDeviceByIP returns a copy of the device that was last seen with the
+// given IP.
+func GetDeviceByIP(ip net.IP) (*Device, bool) {
+	device := getDeviceByIP(ip)
+	if device == nil {
+		return nil, false
+	}
+	return device.copy(), true
+}
+
+// identifyDevice returns the hardware address and name of the device with the
+// given IP.
+func identifyDevice(ip net.IP) (mac, name string, ok bool) {
+	device, ok := GetDeviceByIP(ip)
+	if !ok {
+		return "", "", false
+	}
+	return device.MAC, device.DisplayName(), true
+}
+
+// DisplayName returns the name of the device, or its hardware address if it
+// has no name.
+func (device *Device) DisplayName() string {
+	if device.Name != "" {
+		return device.Name
+	}
+	return device.MAC
+}
+
+// getDeviceByIP returns the device with the given IP. The device must be
+// locked before use.
+func getDeviceByIP(ip net.IP) *Device {
+	devicesLock.RLock()
+	defer devicesLock.RUnlock()
+
+	return devicesByIP[ip.String()]
+}
+
+// copy returns a copy of the device for use outside of this package.
+func (device *Device) copy() *Device {
+	device.Lock()
+	defer device.Unlock()
+
+	cp := &Device{
+		MAC:            device.MAC,
+		Name:           device.Name,
+		IPs:            append([]string(nil), device.IPs...),
+		FirstSeen:      device.FirstSeen,
+		LastSeen:       device.LastSeen,
+		Policy:         device.Policy,
+		AllowedDomains: append([]string(nil), device.AllowedDomains...),
+	}
+	cp.SetKey(device.Key())
+	return cp
+}
@@ -0,0 +1,95 @@
+package devices
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/portmaster/core"
+	"github.com/safing/portmaster/firewall/interception"
+	"github.com/safing/portmaster/netenv"
+	"github.com/safing/portmaster/process"
+)
+
+const (
+	discoveryInterval = 1 * time.Minute
+
+	// lastSeenUpdateThreshold defines how often the last seen timestamp of a
+	// device is persisted.
+	lastSeenUpdateThreshold = 1 * time.Hour
+)
+
+var (
+	module *modules.Module
+
+	cfgNetworkService config.BoolOption
+)
+
+func init() {
+	module = modules.Register("devices", prep, start, stop, "base", "netenv")
+}
+
+func prep() error {
+	// Device policies are only enforced when the Portmaster is the gateway of
+	// the devices.
+	cfgNetworkService = config.Concurrent.GetAsBool(core.CfgNetworkServiceKey, false)
+
+	// Attribute requests of network hosts to their device.
+	process.SetDeviceIdentifier(identifyDevice)
+
+	return registerAPIEndpoints()
+}
+
+func start() error {
+	if err := loadDevices(); err != nil {
+		log.Warningf("devices: failed to load devices: %s", err)
+	}
+
+	isolationTask = module.NewTask("apply device isolation", applyIsolation)
+	module.NewTask("discover devices", discoverDevices).
+		Repeat(discoveryInterval).
+		Schedule(time.Now().Add(10 * time.Second))
+
+	// Remove expired IPs from the isolation rules.
+	module.NewTask("expire allowed device IPs", applyIsolation).
+		Repeat(1 * time.Hour)
+
+	networkService := cfgNetworkService()
+	if networkService {
+		scheduleIsolationUpdate()
+	}
+	return module.RegisterEventHook(
+		"config",
+		"config change",
+		"apply device isolation",
+		func(_ context.Context, _ interface{}) error {
+			if cfgNetworkService() != networkService {
+				networkService = cfgNetworkService()
+				scheduleIsolationUpdate()
+			}
+			return nil
+		},
+	)
+}
+
+func stop() error {
+	return interception.SetDeviceIsolation(nil)
+}
+
+// discoverDevices adds the devices found in the neighbor tables to the device
+// list.
+func discoverDevices(_ context.Context, _ *modules.Task) error {
+	if !cfgNetworkService() {
+		return nil
+	}
+
+	neighbors := make(map[string][]net.IP)
+	for _, neighbor := range netenv.Neighbors() {
+		neighbors[neighbor.MAC] = append(neighbors[neighbor.MAC], neighbor.IP)
+	}
+	updateFromNeighbors(neighbors)
+	return nil
+}
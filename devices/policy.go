@@ -0,0 +1,163 @@
+package devices
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/portmaster/firewall/interception"
+	"github.com/safing/portmaster/network/netutils"
+)
+
+// minAllowedIPTTL defines how long resolved IPs of allowed domains stay
+// reachable for isolated devices at least, as devices often use IPs for much
+// longer than their DNS TTL.
+const minAllowedIPTTL = 24 * time.Hour
+
+var (
+	allowedIPs     = make(map[string]map[string]time.Time) // key: MAC, IP
+	allowedIPsLock sync.Mutex
+
+	isolationTask *modules.Task
+)
+
+// CheckDNSRequest checks a DNS request of the device with the given IP
+// against the policy of the device. It returns whether the device has a policy
+// that decided on the request, and if so, whether the request is allowed.
+func CheckDNSRequest(ip net.IP, fqdn string) (decided, allowed bool) {
+	device := getDeviceByIP(ip)
+	if device == nil {
+		return false, false
+	}
+
+	device.Lock()
+	defer device.Unlock()
+
+	switch device.Policy {
+	case PolicyIsolate:
+		return true, matchesDomain(device.AllowedDomains, fqdn)
+	case PolicyBlock:
+		return true, false
+	default:
+		return false, false
+	}
+}
+
+// AddResolvedIPs allows an isolated device to reach the given IPs, if they
+// were resolved for one of its allowed domains.
+func AddResolvedIPs(ip net.IP, fqdn string, ips []net.IP) {
+	device := getDeviceByIP(ip)
+	if device == nil {
+		return
+	}
+
+	device.Lock()
+	mac := device.MAC
+	allowed := device.Policy == PolicyIsolate && matchesDomain(device.AllowedDomains, fqdn)
+	device.Unlock()
+	if !allowed {
+		return
+	}
+
+	allowedIPsLock.Lock()
+	defer allowedIPsLock.Unlock()
+
+	deviceIPs, ok := allowedIPs[mac]
+	if !ok {
+		deviceIPs = make(map[string]time.Time)
+		allowedIPs[mac] = deviceIPs
+	}
+	var added bool
+	expires := time.Now().Add(minAllowedIPTTL)
+	for _, resolvedIP := range ips {
+		// Local networks are always reachable.
+		if netutils.ClassifyIP(resolvedIP) != netutils.Global {
+			continue
+		}
+		if _, ok := deviceIPs[resolvedIP.String()]; !ok {
+			added = true
+		}
+		deviceIPs[resolvedIP.String()] = expires
+	}
+
+	if added {
+		scheduleIsolationUpdate()
+	}
+}
+
+// matchesDomain returns whether the given FQDN is one of the given domains or
+// a subdomain of them.
+func matchesDomain(domains []string, fqdn string) bool {
+	fqdn = strings.TrimSuffix(strings.ToLower(fqdn), ".")
+	for _, domain := range domains {
+		if fqdn == domain || strings.HasSuffix(fqdn, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// scheduleIsolationUpdate schedules applying the device isolation, so that
+// multiple changes are applied together.
+func scheduleIsolationUpdate() {
+	if isolationTask != nil {
+		isolationTask.Schedule(time.Now().Add(time.Second))
+	}
+}
+
+// applyIsolation installs the isolation rules of all devices with a policy.
+func applyIsolation(_ context.Context, _ *modules.Task) error {
+	if !cfgNetworkService() {
+		return interception.SetDeviceIsolation(nil)
+	}
+
+	now := time.Now()
+	var isolated []*interception.DeviceIsolation
+	for _, device := range ListDevices() {
+		var isolation *interception.DeviceIsolation
+		switch device.Policy {
+		case PolicyIsolate:
+			isolation = &interception.DeviceIsolation{
+				MAC:        device.MAC,
+				AllowedIPs: getAllowedIPs(device.MAC, now),
+			}
+		case PolicyBlock:
+			isolation = &interception.DeviceIsolation{
+				MAC:      device.MAC,
+				BlockAll: true,
+			}
+		default:
+			continue
+		}
+		isolated = append(isolated, isolation)
+	}
+
+	if err := interception.SetDeviceIsolation(isolated); err != nil {
+		log.Errorf("devices: failed to apply device isolation: %s", err)
+		return err
+	}
+	log.Debugf("devices: applied isolation for %d devices", len(isolated))
+	return nil
+}
+
+// getAllowedIPs returns the allowed IPs of the device with the given hardware
+// address and removes expired ones.
+func getAllowedIPs(mac string, now time.Time) []net.IP {
+	allowedIPsLock.Lock()
+	defer allowedIPsLock.Unlock()
+
+	deviceIPs := allowedIPs[mac]
+	ips := make([]net.IP, 0, len(deviceIPs))
+	for ip, expires := range deviceIPs {
+		if now.After(expires) {
+			delete(deviceIPs, ip)
+			continue
+		}
+		ips = append(ips, net.ParseIP(ip))
+	}
+	return ips
+}
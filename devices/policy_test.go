@@ -0,0 +1,55 @@
+package devices
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDevicePolicy(t *testing.T) {
+	deviceIP := net.ParseIP("192.168.1.50")
+	updateFromNeighbors(map[string][]net.IP{
+		"aa:bb:cc:dd:ee:ff": {deviceIP},
+	})
+
+	// Devices without a policy are not handled.
+	if decided, _ := CheckDNSRequest(deviceIP, "example.com."); decided {
+		t.Fatal("device without policy decided on request")
+	}
+
+	device := devices["aa:bb:cc:dd:ee:ff"]
+	device.Policy = PolicyIsolate
+	device.AllowedDomains = []string{"vendor.example"}
+
+	for fqdn, expected := range map[string]bool{
+		"vendor.example.":     true,
+		"api.vendor.example.": true,
+		"myvendor.example.":   false,
+		"example.com.":        false,
+	} {
+		decided, allowed := CheckDNSRequest(deviceIP, fqdn)
+		if !decided || allowed != expected {
+			t.Errorf("unexpected decision for %s: decided=%v allowed=%v", fqdn, decided, allowed)
+		}
+	}
+
+	// Only global IPs of allowed domains are added.
+	AddResolvedIPs(deviceIP, "api.vendor.example.", []net.IP{net.ParseIP("1.2.3.4"), net.ParseIP("10.0.0.1")})
+	AddResolvedIPs(deviceIP, "example.com.", []net.IP{net.ParseIP("5.6.7.8")})
+	ips := getAllowedIPs(device.MAC, time.Now())
+	if len(ips) != 1 || !ips[0].Equal(net.ParseIP("1.2.3.4")) {
+		t.Errorf("unexpected allowed IPs: %v", ips)
+	}
+	if ips := getAllowedIPs(device.MAC, time.Now().Add(2*minAllowedIPTTL)); len(ips) != 0 {
+		t.Errorf("allowed IPs did not expire: %v", ips)
+	}
+
+	// IP changes move the device.
+	newIP := net.ParseIP("192.168.1.51")
+	updateFromNeighbors(map[string][]net.IP{
+		"aa:bb:cc:dd:ee:ff": {newIP},
+	})
+	if getDeviceByIP(deviceIP) != nil || getDeviceByIP(newIP) != device {
+		t.Error("device was not moved to its new IP")
+	}
+}
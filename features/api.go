@@ -0,0 +1,18 @@
+package features
+
+import (
+	"github.com/safing/portbase/api"
+)
+
+func registerAPIEndpoints() error {
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:      "features",
+		Read:      api.PermitUser,
+		BelongsTo: module,
+		StructFunc: func(ar *api.Request) (i interface{}, err error) {
+			return GetFlags(), nil
+		},
+		Name:        "Get Feature Flags",
+		Description: "Returns all known feature flags and whether they are enabled for this installation.",
+	})
+}
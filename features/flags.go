@@ -0,0 +1,229 @@
+package features
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/rng"
+	"github.com/safing/portbase/updater"
+	"github.com/safing/portbase/utils"
+	"github.com/safing/portmaster/updates"
+)
+
+// Feature flags are delivered via the updates registry, so that experimental
+// subsystems can be shipped disabled and be enabled for a growing share of
+// users without a new build.
+
+const (
+	flagsIdentifier = "features/flags.json"
+	cohortKey       = "core:features/cohort"
+)
+
+var (
+	flagsFile *updater.File
+	flags     = make(map[string]*Flag)
+	flagsLock sync.RWMutex
+
+	cohort uint64
+
+	db = database.NewInterface(&database.Options{
+		Local:    true,
+		Internal: true,
+	})
+)
+
+// Flag describes a feature and its staged enablement.
+type Flag struct {
+	// Name is the identifier of the feature.
+	Name string
+	// Description describes the feature.
+	Description string
+	// Rollout is the percentage of installations the feature is enabled for.
+	Rollout uint64
+	// Channels optionally restricts the feature to the given release channels.
+	Channels []string `json:",omitempty"`
+	// Platforms optionally restricts the feature to the given operating systems.
+	Platforms []string `json:",omitempty"`
+}
+
+// FlagState describes the state of a feature flag for this installation.
+type FlagState struct {
+	Flag
+	Enabled    bool
+	Overridden bool
+}
+
+type cohortRecord struct {
+	record.Base
+	sync.Mutex
+
+	Cohort uint64
+}
+
+// Register registers a feature flag, so that it is known even if it is not
+// defined by the flags delivered via updates. Registered flags are disabled
+// until enabled by the delivered flags or a local override.
+func Register(name, description string) {
+	flagsLock.Lock()
+	defer flagsLock.Unlock()
+
+	if _, ok := flags[name]; !ok {
+		flags[name] = &Flag{
+			Name:        name,
+			Description: description,
+		}
+	}
+}
+
+// Enabled returns whether the feature with the given name is enabled.
+func Enabled(name string) bool {
+	if enabled, ok := getOverride(name); ok {
+		return enabled
+	}
+
+	flagsLock.RLock()
+	defer flagsLock.RUnlock()
+
+	flag, ok := flags[name]
+	if !ok {
+		return false
+	}
+	return flag.enabled()
+}
+
+func (flag *Flag) enabled() bool {
+	if len(flag.Channels) > 0 && !utils.StringInSlice(flag.Channels, cfgReleaseChannel()) {
+		return false
+	}
+	if len(flag.Platforms) > 0 && !utils.StringInSlice(flag.Platforms, runtime.GOOS) {
+		return false
+	}
+	return cohort < flag.Rollout
+}
+
+// getOverride returns the local override for the given feature, if set.
+func getOverride(name string) (enabled, ok bool) {
+	for _, entry := range cfgFeatureFlags() {
+		if len(entry) < 2 {
+			continue
+		}
+		if strings.TrimSpace(entry[1:]) != name {
+			continue
+		}
+		switch entry[0] {
+		case '+':
+			return true, true
+		case '-':
+			return false, true
+		}
+	}
+	return false, false
+}
+
+// GetFlags returns the state of all known feature flags.
+func GetFlags() []*FlagState {
+	flagsLock.RLock()
+	defer flagsLock.RUnlock()
+
+	states := make([]*FlagState, 0, len(flags))
+	for _, flag := range flags {
+		state := &FlagState{
+			Flag:    *flag,
+			Enabled: flag.enabled(),
+		}
+		if enabled, ok := getOverride(flag.Name); ok {
+			state.Enabled = enabled
+			state.Overridden = true
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Name < states[j].Name
+	})
+	return states
+}
+
+func loadFlags(onlyIfUpgraded bool) {
+	flagsLock.Lock()
+	if onlyIfUpgraded && (flagsFile == nil || !flagsFile.UpgradeAvailable()) {
+		flagsLock.Unlock()
+		return
+	}
+	flagsLock.Unlock()
+
+	file, err := updates.GetFile(flagsIdentifier)
+	if err != nil {
+		log.Debugf("features: failed to get feature flags: %s", err)
+		return
+	}
+	data, err := ioutil.ReadFile(file.Path())
+	if err != nil {
+		log.Warningf("features: failed to read feature flags: %s", err)
+		return
+	}
+	var loaded []*Flag
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		log.Warningf("features: failed to parse feature flags: %s", err)
+		return
+	}
+
+	flagsLock.Lock()
+	flagsFile = file
+	for _, flag := range loaded {
+		if flag.Name == "" {
+			continue
+		}
+		if existing, ok := flags[flag.Name]; ok && flag.Description == "" {
+			flag.Description = existing.Description
+		}
+		flags[flag.Name] = flag
+	}
+	flagsLock.Unlock()
+
+	log.Infof("features: loaded %d feature flags", len(loaded))
+	module.TriggerEvent(FlagsChangedEvent, nil)
+}
+
+// loadCohort loads the cohort of this installation, which decides whether a
+// feature is enabled during a staged rollout. It is randomly chosen once and
+// then stays the same, so that features do not flip on and off.
+func loadCohort() error {
+	r, err := db.Get(cohortKey)
+	if err == nil {
+		cr := &cohortRecord{}
+		if r.IsWrapped() {
+			err = record.Unwrap(r, cr)
+		} else {
+			var ok bool
+			cr, ok = r.(*cohortRecord)
+			if !ok {
+				err = fmt.Errorf("invalid type, expected cohortRecord but got %T", r)
+			}
+		}
+		if err == nil {
+			cohort = cr.Cohort
+			return nil
+		}
+	}
+	if !errors.Is(err, database.ErrNotFound) {
+		log.Warningf("features: failed to load cohort, choosing a new one: %s", err)
+	}
+
+	n, err := rng.Number(99)
+	if err != nil {
+		return fmt.Errorf("failed to choose cohort: %w", err)
+	}
+	cohort = n
+	cr := &cohortRecord{Cohort: n}
+	cr.SetKey(cohortKey)
+	return db.Put(cr)
+}
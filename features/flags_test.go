@@ -0,0 +1,29 @@
+package features
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestFlagEnabled(t *testing.T) {
+	cfgReleaseChannel = func() string { return "beta" }
+	cohort = 42
+
+	testCases := []struct {
+		flag    *Flag
+		enabled bool
+	}{
+		{&Flag{Rollout: 0}, false},
+		{&Flag{Rollout: 42}, false},
+		{&Flag{Rollout: 43}, true},
+		{&Flag{Rollout: 100, Channels: []string{"stable"}}, false},
+		{&Flag{Rollout: 100, Channels: []string{"beta"}}, true},
+		{&Flag{Rollout: 100, Platforms: []string{"plan9-only"}}, false},
+		{&Flag{Rollout: 100, Platforms: []string{runtime.GOOS}}, true},
+	}
+	for i, tc := range testCases {
+		if tc.flag.enabled() != tc.enabled {
+			t.Errorf("test case %d: expected enabled=%v", i, tc.enabled)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package features
+
+import (
+	"context"
+	"strings"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/portmaster/updates"
+	"github.com/safing/portmaster/updates/helper"
+)
+
+const (
+	// FlagsChangedEvent is emitted when the state of feature flags might have
+	// changed, either because of new flag definitions or local overrides.
+	FlagsChangedEvent = "flags changed"
+)
+
+var (
+	module *modules.Module
+
+	// CfgFeatureFlagsKey is the config key for local feature flag overrides.
+	CfgFeatureFlagsKey = "core/featureFlags"
+	cfgFeatureFlags    config.StringArrayOption
+
+	cfgReleaseChannel config.StringOption
+)
+
+func init() {
+	module = modules.Register("features", prep, start, nil, "base", "updates")
+	module.RegisterEvent(FlagsChangedEvent, true)
+}
+
+func prep() error {
+	if err := config.Register(&config.Option{
+		Name:            "Feature Flags",
+		Key:             CfgFeatureFlagsKey,
+		Description:     "Enable or disable experimental features regardless of their staged rollout. Prefix the feature name with \"+\" to enable it, or with \"-\" to disable it.",
+		OptType:         config.OptTypeStringArray,
+		ExpertiseLevel:  config.ExpertiseLevelDeveloper,
+		ReleaseLevel:    config.ReleaseLevelExperimental,
+		DefaultValue:    []string{},
+		ValidationRegex: `^[+-] ?[a-z0-9-]+$`,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 514,
+			config.CategoryAnnotation:     "Development",
+		},
+	}); err != nil {
+		return err
+	}
+	cfgFeatureFlags = config.Concurrent.GetAsStringArray(CfgFeatureFlagsKey, []string{})
+	cfgReleaseChannel = config.Concurrent.GetAsString(helper.ReleaseChannelKey, helper.ReleaseChannelStable)
+
+	return nil
+}
+
+func start() error {
+	if err := loadCohort(); err != nil {
+		return err
+	}
+
+	prevOverrides := strings.Join(cfgFeatureFlags(), " ")
+	if err := module.RegisterEventHook(
+		"config",
+		"config change",
+		"apply feature flag overrides",
+		func(_ context.Context, _ interface{}) error {
+			newOverrides := strings.Join(cfgFeatureFlags(), " ")
+			if newOverrides != prevOverrides {
+				prevOverrides = newOverrides
+				module.TriggerEvent(FlagsChangedEvent, nil)
+			}
+			return nil
+		},
+	); err != nil {
+		return err
+	}
+
+	if err := module.RegisterEventHook(
+		updates.ModuleName,
+		updates.ResourceUpdateEvent,
+		"check for feature flag updates",
+		func(_ context.Context, _ interface{}) error {
+			loadFlags(true)
+			return nil
+		},
+	); err != nil {
+		return err
+	}
+
+	if err := registerAPIEndpoints(); err != nil {
+		return err
+	}
+
+	loadFlags(false)
+	return nil
+}
@@ -0,0 +1,171 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/process"
+	"github.com/safing/portmaster/profile"
+)
+
+// The core cannot see the desktop session, so UIs running in the desktop
+// session, such as the Notifier, report which process owns the foreground
+// window and how long the user has been idle. Apps without recent user-facing
+// activity are considered to run in the background.
+
+const (
+	// activityReportTTL defines how long activity reports are trusted. If no
+	// UI reported activity within this time, background traffic is not
+	// blocked, as it cannot be distinguished.
+	activityReportTTL = 2 * time.Minute
+
+	// foregroundGracePeriod defines how long an app is considered active
+	// after it was last in the foreground while the user was active.
+	foregroundGracePeriod = 5 * time.Minute
+)
+
+var (
+	// foregroundProfiles holds the scoped IDs of the profiles that were in
+	// the foreground, mapped to when they were last seen there.
+	foregroundProfiles     = make(map[string]time.Time)
+	lastActivityReport     time.Time
+	lastUserInput          time.Time
+	foregroundProfilesLock sync.Mutex
+)
+
+func registerActivityAPI() error {
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:        "ui/activity",
+		Write:       api.PermitUser,
+		BelongsTo:   interceptionModule,
+		ActionFunc:  handleActivityReport,
+		Name:        "Report User Activity",
+		Description: "Reports the process that owns the foreground window and how long the user has been idle. UIs should report activity every 30 seconds and whenever the foreground window changes. Used to block traffic of apps that run in the background.",
+		Parameters: []api.Parameter{
+			{
+				Method:      http.MethodPost,
+				Field:       "pid",
+				Value:       "1234",
+				Description: "Specify the process ID of the foreground window.",
+			},
+			{
+				Method:      http.MethodPost,
+				Field:       "idle",
+				Value:       "0",
+				Description: "Specify the seconds since the last user input.",
+			},
+		},
+	})
+}
+
+func handleActivityReport(ar *api.Request) (msg string, err error) {
+	query := ar.Request.URL.Query()
+	pid, err := strconv.Atoi(query.Get("pid"))
+	if err != nil || pid <= 0 {
+		return "", fmt.Errorf("invalid pid %q", query.Get("pid"))
+	}
+	var idle time.Duration
+	if s := query.Get("idle"); s != "" {
+		seconds, err := strconv.Atoi(s)
+		if err != nil || seconds < 0 {
+			return "", fmt.Errorf("invalid idle time %q", s)
+		}
+		idle = time.Duration(seconds) * time.Second
+	}
+
+	foregroundProfilesLock.Lock()
+	lastActivityReport = time.Now()
+	lastUserInput = time.Now().Add(-idle)
+	foregroundProfilesLock.Unlock()
+
+	// An idle user does not make the foreground app active.
+	if idle >= foregroundGracePeriod {
+		return "user is idle", nil
+	}
+
+	proc, err := process.GetOrFindProcess(ar.Context(), pid)
+	if err != nil {
+		return "", fmt.Errorf("failed to find process %d: %w", pid, err)
+	}
+	layeredProfile := proc.Profile()
+	if layeredProfile == nil {
+		return "", fmt.Errorf("process %d has no profile", pid)
+	}
+	localProfile := layeredProfile.LocalProfile()
+
+	foregroundProfilesLock.Lock()
+	_, known := foregroundProfiles[localProfile.ScopedID()]
+	foregroundProfiles[localProfile.ScopedID()] = time.Now().Add(-idle)
+	foregroundProfilesLock.Unlock()
+
+	if !known {
+		log.Debugf("filter: %s is in the foreground", localProfile.Name)
+	}
+	return fmt.Sprintf("%s is in the foreground", localProfile.Name), nil
+}
+
+// inBackground returns whether the app of the given profile has no recent
+// user-facing activity. It returns false if activity is not being reported.
+func inBackground(scopedID string) bool {
+	foregroundProfilesLock.Lock()
+	defer foregroundProfilesLock.Unlock()
+
+	if time.Since(lastActivityReport) > activityReportTTL {
+		return false
+	}
+
+	lastSeen, ok := foregroundProfiles[scopedID]
+	if ok && time.Since(lastSeen) > foregroundGracePeriod {
+		delete(foregroundProfiles, scopedID)
+		ok = false
+	}
+	return !ok
+}
+
+// userActive returns whether the user was recently active and whether user
+// activity is being reported at all.
+func userActive() (active, known bool) {
+	foregroundProfilesLock.Lock()
+	defer foregroundProfilesLock.Unlock()
+
+	if time.Since(lastActivityReport) > activityReportTTL {
+		return false, false
+	}
+	return time.Since(lastUserInput) < foregroundGracePeriod, true
+}
+
+func checkBackgroundTraffic(ctx context.Context, conn *network.Connection, p *profile.LayeredProfile, _ packet.Packet) bool {
+	switch {
+	case !p.BlockBackground():
+		return false
+	case conn.Inbound:
+		// Only outgoing connections are chatter.
+		return false
+	case conn.Type == network.IPConnection && !conn.Entity.IPScope.IsGlobal():
+		// Only block traffic to the Internet.
+		return false
+	}
+
+	// Special profiles, such as the operating system, have no foreground
+	// window.
+	localProfile := p.LocalProfile()
+	if strings.HasPrefix(localProfile.ID, "_") {
+		return false
+	}
+
+	if inBackground(localProfile.ScopedID()) {
+		log.Tracer(ctx).Infof("filter: %s has no user-facing activity", localProfile.Name)
+		conn.Block("app is running in the background", profile.CfgOptionBlockBackgroundTrafficKey)
+		return true
+	}
+	return false
+}
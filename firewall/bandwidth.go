@@ -0,0 +1,141 @@
+package firewall
+
+import (
+	"sync"
+	"time"
+
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+)
+
+// Bandwidth limits are enforced per app profile in the packet path: Packets
+// that exceed the limit are dropped, which makes TCP back off to the
+// available bandwidth. In order to see all packets, connections of limited
+// apps are not handed over to the system with a permanent verdict.
+
+const (
+	// bandwidthBurst defines how much traffic may be sent at once, relative
+	// to the traffic allowed per second.
+	bandwidthBurst = time.Second
+
+	bandwidthLimiterTTL     = 10 * time.Minute
+	bandwidthLimiterCleanup = time.Minute
+)
+
+var (
+	bandwidthLimiters            = make(map[string]*bandwidthLimiter)
+	bandwidthLimitersLock        sync.Mutex
+	bandwidthLimitersLastCleaned time.Time
+)
+
+// bandwidthLimiter is a token bucket per direction for an app profile.
+type bandwidthLimiter struct {
+	sync.Mutex
+
+	upTokens   float64
+	downTokens float64
+	lastRefill time.Time
+}
+
+// allow refills the bucket of the direction with the given limit in bytes per
+// second and takes size bytes from it, if available.
+func (bl *bandwidthLimiter) allow(now time.Time, inbound bool, size int, limit float64) bool {
+	bl.Lock()
+	defer bl.Unlock()
+
+	burst := limit * bandwidthBurst.Seconds()
+	elapsed := now.Sub(bl.lastRefill).Seconds()
+	bl.lastRefill = now
+	bl.upTokens = refillTokens(bl.upTokens, elapsed*limit, burst)
+	bl.downTokens = refillTokens(bl.downTokens, elapsed*limit, burst)
+
+	tokens := &bl.upTokens
+	if inbound {
+		tokens = &bl.downTokens
+	}
+	if *tokens < float64(size) {
+		return false
+	}
+	*tokens -= float64(size)
+	return true
+}
+
+func refillTokens(tokens, add, burst float64) float64 {
+	tokens += add
+	if tokens > burst {
+		return burst
+	}
+	return tokens
+}
+
+// bandwidthLimit returns the bandwidth limit of the connection's app in bytes
+// per second and the ID of its profile. It returns zero if no limit applies.
+func bandwidthLimit(conn *network.Connection) (limit float64, profileID string) {
+	layeredProfile := conn.Process().Profile()
+	if layeredProfile == nil || layeredProfile.LocalProfile() == nil {
+		return 0, ""
+	}
+	kbps := layeredProfile.BandwidthLimit()
+	if kbps <= 0 {
+		return 0, ""
+	}
+	return float64(kbps) * 1000, layeredProfile.LocalProfile().ScopedID()
+}
+
+// getBandwidthLimiter returns the bandwidth limiter of the given profile.
+func getBandwidthLimiter(now time.Time, profileID string) *bandwidthLimiter {
+	bandwidthLimitersLock.Lock()
+	defer bandwidthLimitersLock.Unlock()
+
+	// Remove limiters of apps that did not send any traffic for some time.
+	if now.Sub(bandwidthLimitersLastCleaned) > bandwidthLimiterCleanup {
+		bandwidthLimitersLastCleaned = now
+		for id, bl := range bandwidthLimiters {
+			bl.Lock()
+			idle := now.Sub(bl.lastRefill) > bandwidthLimiterTTL
+			bl.Unlock()
+			if idle {
+				delete(bandwidthLimiters, id)
+			}
+		}
+	}
+
+	bl, ok := bandwidthLimiters[profileID]
+	if !ok {
+		// Start with a full bucket.
+		bl = &bandwidthLimiter{
+			lastRefill: now.Add(-bandwidthBurst),
+		}
+		bandwidthLimiters[profileID] = bl
+	}
+	return bl
+}
+
+// enforceBandwidthLimit counts the accepted packet on the connection and
+// returns whether it is within the bandwidth limit of the connection's app.
+// The connection must be locked.
+func enforceBandwidthLimit(conn *network.Connection, pkt packet.Packet) (allowed bool) {
+	size := len(pkt.Raw())
+	if size == 0 && pkt.LoadPacketData() == nil {
+		size = len(pkt.Raw())
+	}
+
+	limit, profileID := bandwidthLimit(conn)
+	if limit > 0 {
+		now := time.Now()
+		if !getBandwidthLimiter(now, profileID).allow(now, pkt.IsInbound(), size, limit) {
+			conn.CountPacket(pkt, size, true)
+			return false
+		}
+	}
+
+	conn.CountPacket(pkt, size, false)
+	return true
+}
+
+// bandwidthLimited returns whether a bandwidth limit applies to the
+// connection. It is a variable, so that tests can replace it.
+var bandwidthLimited = func(conn *network.Connection) bool {
+	limit, _ := bandwidthLimit(conn)
+	return limit > 0
+}
@@ -0,0 +1,119 @@
+package firewall
+
+import (
+	"testing"
+	"time"
+
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+)
+
+func TestBandwidthLimiter(t *testing.T) {
+	t.Parallel()
+
+	const limit = 1000
+	now := time.Now()
+	bl := &bandwidthLimiter{
+		lastRefill: now.Add(-bandwidthBurst),
+	}
+
+	// The bucket starts full, so a burst of the limit is allowed at once.
+	if !bl.allow(now, false, limit, limit) {
+		t.Fatal("burst within the limit was not allowed")
+	}
+	if bl.allow(now, false, 1, limit) {
+		t.Error("traffic exceeding the burst was allowed")
+	}
+
+	// The directions have separate buckets.
+	if !bl.allow(now, true, limit, limit) {
+		t.Error("inbound traffic was limited by outbound traffic")
+	}
+	if bl.allow(now, true, 1, limit) {
+		t.Error("inbound traffic exceeding the burst was allowed")
+	}
+
+	// The buckets are refilled according to the limit.
+	now = now.Add(100 * time.Millisecond)
+	if !bl.allow(now, false, 100, limit) {
+		t.Error("refilled traffic was not allowed")
+	}
+	if bl.allow(now, false, 1, limit) {
+		t.Error("traffic exceeding the refill was allowed")
+	}
+	if !bl.allow(now, true, 100, limit) {
+		t.Error("refilled inbound traffic was not allowed")
+	}
+
+	// Refilling is capped at the burst.
+	now = now.Add(time.Hour)
+	if bl.allow(now, false, limit+1, limit) {
+		t.Error("traffic exceeding the burst was allowed after being idle")
+	}
+	if !bl.allow(now, false, limit, limit) {
+		t.Error("burst was not allowed after being idle")
+	}
+}
+
+// verdictPacket records the verdicts that are applied to it.
+type verdictPacket struct {
+	packet.Base
+
+	verdicts []string
+}
+
+func (pkt *verdictPacket) record(verdict string) error {
+	pkt.verdicts = append(pkt.verdicts, verdict)
+	return nil
+}
+
+func (pkt *verdictPacket) Raw() []byte                { return make([]byte, 100) }
+func (pkt *verdictPacket) Accept() error              { return pkt.record("accept") }
+func (pkt *verdictPacket) Block() error               { return pkt.record("block") }
+func (pkt *verdictPacket) Drop() error                { return pkt.record("drop") }
+func (pkt *verdictPacket) PermanentAccept() error     { return pkt.record("permanent accept") }
+func (pkt *verdictPacket) PermanentBlock() error      { return pkt.record("permanent block") }
+func (pkt *verdictPacket) PermanentDrop() error       { return pkt.record("permanent drop") }
+func (pkt *verdictPacket) RerouteToNameserver() error { return pkt.record("reroute") }
+func (pkt *verdictPacket) RerouteToTunnel() error     { return pkt.record("reroute") }
+func (pkt *verdictPacket) RerouteToProxy() error      { return pkt.record("reroute") }
+
+func TestIssueVerdictBandwidthLimited(t *testing.T) {
+	previousBandwidthLimited := bandwidthLimited
+	previousPermanentVerdicts := permanentVerdicts
+	defer func() {
+		bandwidthLimited = previousBandwidthLimited
+		permanentVerdicts = previousPermanentVerdicts
+	}()
+	permanentVerdicts = func() bool { return true }
+
+	for _, test := range []struct {
+		limited  bool
+		verdict  network.Verdict
+		expected string
+	}{
+		{false, network.VerdictAccept, "permanent accept"},
+		{false, network.VerdictBlock, "permanent block"},
+		{false, network.VerdictDrop, "permanent drop"},
+		{true, network.VerdictAccept, "accept"},
+		{true, network.VerdictBlock, "block"},
+		{true, network.VerdictDrop, "drop"},
+	} {
+		limited := test.limited
+		bandwidthLimited = func(_ *network.Connection) bool { return limited }
+
+		conn := &network.Connection{Verdict: test.verdict}
+		pkt := &verdictPacket{}
+		issueVerdict(conn, pkt, test.verdict, true)
+		issueVerdict(conn, pkt, test.verdict, true)
+
+		if conn.VerdictPermanent == test.limited {
+			t.Errorf("limited=%v %s: unexpected permanent verdict %v", test.limited, test.verdict, conn.VerdictPermanent)
+		}
+		for _, applied := range pkt.verdicts {
+			if applied != test.expected {
+				t.Errorf("limited=%v %s: expected %s, got %s", test.limited, test.verdict, test.expected, applied)
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package firewall
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/safing/portbase/notifications"
+	"github.com/safing/portmaster/l10n"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/profile"
+)
+
+const (
+	blockNotificationIDPrefix = "filter:blocked"
+
+	// blockNotificationTTL defines how long a block notification is shown.
+	// No further notifications are shown for the same app and destination in
+	// the meantime.
+	blockNotificationTTL = 10 * time.Minute
+)
+
+// blockSeverities holds the severity of blocks by the setting with the given
+// key. Blocks by other settings have a low severity.
+var blockSeverities = map[string]int64{
+	profile.CfgOptionFilterListsKey:      profile.BlockSeverityHigh,
+	profile.CfgOptionPreventBypassingKey: profile.BlockSeverityHigh,
+	profile.CfgOptionDomainHeuristicsKey: profile.BlockSeverityHigh,
+
+	profile.CfgOptionEndpointsKey:          profile.BlockSeverityMedium,
+	profile.CfgOptionServiceEndpointsKey:   profile.BlockSeverityMedium,
+	profile.CfgOptionBlockScopeInternetKey: profile.BlockSeverityMedium,
+	profile.CfgOptionBlockScopeLANKey:      profile.BlockSeverityMedium,
+	profile.CfgOptionBlockScopeLocalKey:    profile.BlockSeverityMedium,
+	profile.CfgOptionLocalhostHandlingKey:  profile.BlockSeverityMedium,
+	profile.CfgOptionBlockScopeOverlayKey:  profile.BlockSeverityMedium,
+	profile.CfgOptionBlockP2PKey:           profile.BlockSeverityMedium,
+	profile.CfgOptionBlockInboundKey:       profile.BlockSeverityMedium,
+	profile.CfgOptionAllowedInterfacesKey:  profile.BlockSeverityMedium,
+	profile.CfgOptionBlockProxiesKey:       profile.BlockSeverityMedium,
+	profile.CfgOptionBlockTelemetryKey:     profile.BlockSeverityMedium,
+	profile.CfgOptionPortLearningKey:       profile.BlockSeverityMedium,
+}
+
+func blockSeverity(optionKey string) int64 {
+	severity, ok := blockSeverities[optionKey]
+	if !ok {
+		return profile.BlockSeverityLow
+	}
+	return severity
+}
+
+// notifyAboutBlock notifies the user about the blocked connection, if the
+// profile of the connection is configured to notify about blocks of this
+// severity. The caller must hold the connection lock.
+func notifyAboutBlock(conn *network.Connection) {
+	if conn.Internal || conn.Entity == nil {
+		return
+	}
+	switch conn.Verdict {
+	case network.VerdictBlock, network.VerdictDrop:
+	default:
+		return
+	}
+
+	layeredProfile := conn.Process().Profile()
+	if layeredProfile == nil {
+		return
+	}
+	layeredProfile.LockForUsage()
+	minSeverity := layeredProfile.BlockNotifications()
+	layeredProfile.UnlockForUsage()
+
+	severity := blockSeverity(conn.Reason.OptionKey)
+	if minSeverity == profile.BlockSeverityNone || severity < minSeverity {
+		return
+	}
+
+	destination := strings.TrimSuffix(conn.Entity.Domain, ".")
+	if destination == "" && conn.Entity.IP != nil {
+		destination = conn.Entity.IP.String()
+	}
+	nID := fmt.Sprintf(
+		"%s-%s-%s",
+		blockNotificationIDPrefix,
+		conn.ProcessContext.Profile,
+		destination,
+	)
+	if notifications.Get(nID) != nil {
+		return
+	}
+
+	message := l10n.Sprintf(
+		"%s was blocked from connecting to %s: %s",
+		conn.ProcessContext.ProfileName,
+		destination,
+		conn.Reason.LocalizedText(),
+	)
+	if conn.Inbound {
+		message = l10n.Sprintf(
+			"A connection from %s to %s was blocked: %s",
+			destination,
+			conn.ProcessContext.ProfileName,
+			conn.Reason.LocalizedText(),
+		)
+	}
+
+	notifications.Notify(&notifications.Notification{
+		EventID:      nID,
+		Type:         notifications.Info,
+		Title:        l10n.T("Connection Blocked"),
+		Category:     l10n.T("Privacy Filter"),
+		Message:      message,
+		ShowOnSystem: severity >= profile.BlockSeverityHigh,
+		Expires:      time.Now().Add(blockNotificationTTL).Unix(),
+	})
+}
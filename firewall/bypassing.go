@@ -1,13 +1,19 @@
 package firewall
 
 import (
+	"net"
+	"strconv"
 	"strings"
 
 	"github.com/safing/portmaster/nameserver/nsutil"
 	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
 	"github.com/safing/portmaster/profile/endpoints"
+	"github.com/safing/portmaster/resolver"
 )
 
+const dnsOverTLSPort = 853
+
 var (
 	resolverFilterLists = []string{"17-DNS"}
 )
@@ -30,3 +36,51 @@ func PreventBypassing(conn *network.Connection) (endpoints.EPResult, string, nsu
 
 	return endpoints.NoMatch, "", nil
 }
+
+// detectBypassAttempts reports attempts to bypass the filtering to the
+// per-profile bypass attempt stats.
+func detectBypassAttempts(conn *network.Connection) {
+	if conn.Inbound || conn.Process().IsSystemResolver() {
+		return
+	}
+
+	switch {
+	case conn.Entity.MatchLists(resolverFilterLists):
+		conn.ReportBypassAttempt(network.BypassEncryptedDNS, bypassDestination(conn))
+
+	case conn.Type == network.IPConnection &&
+		conn.Entity.Protocol == uint8(packet.TCP) &&
+		conn.Entity.Port == dnsOverTLSPort:
+		conn.ReportBypassAttempt(network.BypassEncryptedDNS, bypassDestination(conn))
+
+	case conn.Type == network.IPConnection && conn.Entity.Domain == "" && conn.Entity.IP != nil:
+		// Check if the app connects to the IP of a domain that it was not
+		// allowed to resolve.
+		info, err := resolver.GetIPInfo(resolver.IPInfoProfileScopeGlobal, conn.Entity.IP.String())
+		if err != nil {
+			return
+		}
+		info.Lock()
+		domains := make([]string, 0, len(info.ResolvedDomains))
+		for _, resolved := range info.ResolvedDomains {
+			domains = append(domains, resolved.Domain)
+		}
+		info.Unlock()
+
+		if domain, ok := conn.RecentlyBlockedDomain(domains...); ok {
+			conn.ReportBypassAttempt(network.BypassIPAfterBlockedDNS, domain+" ("+conn.Entity.IP.String()+")")
+		}
+	}
+}
+
+// bypassDestination returns a description of the destination of the
+// connection for bypass attempt reports.
+func bypassDestination(conn *network.Connection) string {
+	if conn.Entity.Domain != "" {
+		return strings.TrimSuffix(conn.Entity.Domain, ".")
+	}
+	if conn.Entity.IP != nil {
+		return net.JoinHostPort(conn.Entity.IP.String(), strconv.Itoa(int(conn.Entity.Port)))
+	}
+	return ""
+}
@@ -5,6 +5,7 @@
 	"github.com/safing/portbase/config"
 	"github.com/safing/portbase/notifications"
 	"github.com/safing/portmaster/core"
+	"github.com/safing/portmaster/firewall/interception"
 )
 
 // Configuration Keys.
@@ -19,10 +20,54 @@
 	cfgOptionAskTimeoutOrder = 3
 	askTimeout               config.IntOption
 
+	CfgOptionPromptFallbackActionKey   = "filter/promptFallbackAction"
+	cfgOptionPromptFallbackActionOrder = 4
+	promptFallbackAction               config.StringOption
+
 	CfgOptionPermanentVerdictsKey   = "filter/permanentVerdicts"
 	cfgOptionPermanentVerdictsOrder = 96
 	permanentVerdicts               config.BoolOption
 
+	CfgOptionBlockDuringStartupKey   = "filter/blockDuringStartup"
+	cfgOptionBlockDuringStartupOrder = 98
+	blockDuringStartup               config.BoolOption
+
+	CfgOptionKillSwitchKey   = "filter/killSwitch"
+	cfgOptionKillSwitchOrder = 97
+	killSwitchMode           config.StringOption
+
+	CfgOptionPolicyScriptsKey   = "filter/policyScripts"
+	cfgOptionPolicyScriptsOrder = 99
+	enablePolicyScripts         config.BoolOption
+
+	CfgOptionVerdictPluginsKey   = "filter/verdictPlugins"
+	cfgOptionVerdictPluginsOrder = 100
+	verdictPlugins               config.StringArrayOption
+
+	CfgOptionVerdictPluginTimeoutKey   = "filter/verdictPluginTimeout"
+	cfgOptionVerdictPluginTimeoutOrder = 101
+	verdictPluginTimeout               config.IntOption
+
+	CfgOptionVerdictPluginFailClosedKey   = "filter/verdictPluginFailClosed"
+	cfgOptionVerdictPluginFailClosedOrder = 102
+	verdictPluginFailClosed               config.BoolOption
+
+	CfgOptionOverloadMitigationKey   = "filter/overloadMitigation"
+	cfgOptionOverloadMitigationOrder = 103
+	overloadMitigation               config.StringOption
+
+	CfgOptionRetroHuntKey   = "filter/retroHunt"
+	cfgOptionRetroHuntOrder = 104
+	retroHunt               config.BoolOption
+
+	CfgOptionChainRecordingsKey   = "filter/chainRecordings"
+	cfgOptionChainRecordingsOrder = 105
+	chainRecordings               config.BoolOption
+
+	CfgOptionConnectionSamplingKey   = "filter/connectionSampling"
+	cfgOptionConnectionSamplingOrder = 106
+	connectionSampling               config.IntOption
+
 	devMode          config.BoolOption
 	apiListenAddress config.StringOption
 )
@@ -46,6 +91,119 @@ func registerConfig() error {
 	}
 	permanentVerdicts = config.Concurrent.GetAsBool(CfgOptionPermanentVerdictsKey, true)
 
+	err = config.Register(&config.Option{
+		Name:           "Kill Switch",
+		Key:            CfgOptionKillSwitchKey,
+		Description:    "Keep blocking network traffic with the OS firewall while the Portmaster is not running. When set to apps, all apps that have Internet access blocked in their app settings stay blocked. Blocking apps is not supported on Linux. The kill switch is also installed again after a crash or a reboot, and removed when the Portmaster starts again.",
+		OptType:        config.OptTypeString,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   killSwitchOff,
+		Annotations: config.Annotations{
+			config.DisplayHintAnnotation:  config.DisplayHintOneOf,
+			config.DisplayOrderAnnotation: cfgOptionKillSwitchOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+		PossibleValues: killSwitchPossibleValues(interception.KillSwitchAllSupported, interception.KillSwitchAppsSupported),
+	})
+	if err != nil {
+		return err
+	}
+	killSwitchMode = config.Concurrent.GetAsString(CfgOptionKillSwitchKey, killSwitchOff)
+
+	err = config.Register(&config.Option{
+		Name:           "Block During Startup",
+		Key:            CfgOptionBlockDuringStartupKey,
+		Description:    "Block all new outgoing connections until the Portmaster has fully loaded the firewall, app settings and filter lists. Connections that were blocked during startup are listed in the startup report.",
+		OptType:        config.OptTypeBool,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   false,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionBlockDuringStartupOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	blockDuringStartup = config.Concurrent.GetAsBool(CfgOptionBlockDuringStartupKey, false)
+
+	err = config.Register(&config.Option{
+		Name:           "Policy Scripts",
+		Key:            CfgOptionPolicyScriptsKey,
+		Description:    "Run the policy scripts in the \"scripts\" directory of the data directory on every new connection. Policy scripts can allow, block or annotate connections with custom logic and are evaluated with strict CPU and time limits.",
+		OptType:        config.OptTypeBool,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   false,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionPolicyScriptsOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	enablePolicyScripts = config.Concurrent.GetAsBool(CfgOptionPolicyScriptsKey, false)
+
+	err = config.Register(&config.Option{
+		Name:           "Verdict Plugins",
+		Key:            CfgOptionVerdictPluginsKey,
+		Description:    "gRPC services that are consulted for a verdict on every new connection, in the given order. Use grpc://host:port for plaintext or grpcs://host:port for TLS connections. The first plugin that returns a verdict decides. Plugins may also annotate connections. Responses are cached per app and destination.",
+		OptType:        config.OptTypeStringArray,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   []string{},
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionVerdictPluginsOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+		ValidationRegex: `^grpcs?://[^\s/]+$`,
+	})
+	if err != nil {
+		return err
+	}
+	verdictPlugins = config.Concurrent.GetAsStringArray(CfgOptionVerdictPluginsKey, []string{})
+
+	err = config.Register(&config.Option{
+		Name:           "Verdict Plugin Timeout",
+		Key:            CfgOptionVerdictPluginTimeoutKey,
+		Description:    "How long to wait for a verdict plugin to respond. New connections are held while waiting, so keep this as low as possible.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   100,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionVerdictPluginTimeoutOrder,
+			config.UnitAnnotation:         "milliseconds",
+			config.CategoryAnnotation:     "Advanced",
+		},
+		ValidationRegex: `^[1-9][0-9]{0,4}$`,
+	})
+	if err != nil {
+		return err
+	}
+	verdictPluginTimeout = config.Concurrent.GetAsInt(CfgOptionVerdictPluginTimeoutKey, 100)
+
+	err = config.Register(&config.Option{
+		Name:           "Block When Verdict Plugin Fails",
+		Key:            CfgOptionVerdictPluginFailClosedKey,
+		Description:    "Block connections if a verdict plugin fails or does not respond in time. If disabled, failing plugins are skipped and the connection is handled regularly.",
+		OptType:        config.OptTypeBool,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   false,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionVerdictPluginFailClosedOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	verdictPluginFailClosed = config.Concurrent.GetAsBool(CfgOptionVerdictPluginFailClosedKey, false)
+
 	err = config.Register(&config.Option{
 		Name:           "Prompt Desktop Notifications",
 		Key:            CfgOptionAskWithSystemNotificationsKey,
@@ -86,6 +244,133 @@ func registerConfig() error {
 	}
 	askTimeout = config.Concurrent.GetAsInt(CfgOptionAskTimeoutKey, 60)
 
+	err = config.Register(&config.Option{
+		Name:           "Prompt Fallback for Users without App",
+		Key:            CfgOptionPromptFallbackActionKey,
+		Description:    "If the Portmaster App or Notifier is running for multiple users, prompts are only shown to, and can only be answered by, the user running the app that is prompted for. Select what to do if that user has no Portmaster App or Notifier running, as is the case for system services.",
+		OptType:        config.OptTypeString,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelBeta,
+		DefaultValue:   promptFallbackBroadcast,
+		Annotations: config.Annotations{
+			config.DisplayHintAnnotation:  config.DisplayHintOneOf,
+			config.DisplayOrderAnnotation: cfgOptionPromptFallbackActionOrder,
+			config.CategoryAnnotation:     "General",
+		},
+		PossibleValues: []config.PossibleValue{
+			{
+				Name:        "Block",
+				Value:       promptFallbackBlock,
+				Description: "Block the connection",
+			},
+			{
+				Name:        "Allow",
+				Value:       promptFallbackPermit,
+				Description: "Allow the connection",
+			},
+			{
+				Name:        "Prompt All Users",
+				Value:       promptFallbackBroadcast,
+				Description: "Show the prompt to all users",
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	promptFallbackAction = config.Concurrent.GetAsString(CfgOptionPromptFallbackActionKey, promptFallbackBroadcast)
+
+	err = config.Register(&config.Option{
+		Name:           "Overload Mitigation",
+		Key:            CfgOptionOverloadMitigationKey,
+		Description:    "Choose how the Portmaster degrades when it is overloaded, ie. when the packet queue fills up or decisions on new connections exceed the decision latency SLO. You are always notified about overloads.",
+		OptType:        config.OptTypeString,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   overloadReduce,
+		Annotations: config.Annotations{
+			config.DisplayHintAnnotation:  config.DisplayHintOneOf,
+			config.DisplayOrderAnnotation: cfgOptionOverloadMitigationOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+		PossibleValues: []config.PossibleValue{
+			{
+				Name:        "Notify Only",
+				Value:       overloadNotify,
+				Description: "Keep checking every connection in full",
+			},
+			{
+				Name:        "Reduce Load",
+				Value:       overloadReduce,
+				Description: "Skip domain heuristics and serve DNS answers from the cache when possible",
+			},
+			{
+				Name:        "Fail Open",
+				Value:       overloadFailOpen,
+				Description: "Additionally allow connections to destinations that were allowed recently for the same app without further checks",
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	overloadMitigation = config.Concurrent.GetAsString(CfgOptionOverloadMitigationKey, overloadReduce)
+
+	err = config.Register(&config.Option{
+		Name:           "Re-Check Connections After Filter List Updates",
+		Key:            CfgOptionRetroHuntKey,
+		Description:    "After the filter lists were updated, check recently allowed connections against the new filter lists and notify about apps that connected to destinations that are now blocked.",
+		OptType:        config.OptTypeBool,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelBeta,
+		DefaultValue:   false,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionRetroHuntOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	retroHunt = config.Concurrent.GetAsBool(CfgOptionRetroHuntKey, false)
+
+	err = config.Register(&config.Option{
+		Name:           "Tamper-Evident Decision Recordings",
+		Key:            CfgOptionChainRecordingsKey,
+		Description:    "Chain the records of decision recordings with hashes, so that exported recordings can be verified as untampered. The hash of the last record is written to the log when a recording is stopped, so that it can be kept separately.",
+		OptType:        config.OptTypeBool,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelBeta,
+		DefaultValue:   false,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionChainRecordingsOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	chainRecordings = config.Concurrent.GetAsBool(CfgOptionChainRecordingsKey, false)
+
+	err = config.Register(&config.Option{
+		Name:            "Connection Sampling",
+		Key:             CfgOptionConnectionSamplingKey,
+		Description:     "Only fully record one in the given amount of connections, in order to keep the overhead low on hosts with a very high amount of connections. Connections that are not sampled are still checked and allowed or blocked as usual and are counted in the metrics, but they are not shown in the network activity, tagged or added to statistics. Set to 1 to record all connections.",
+		OptType:         config.OptTypeInt,
+		ExpertiseLevel:  config.ExpertiseLevelDeveloper,
+		ReleaseLevel:    config.ReleaseLevelExperimental,
+		DefaultValue:    1,
+		ValidationRegex: `^[1-9][0-9]{0,3}$`,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionConnectionSamplingOrder,
+			config.CategoryAnnotation:     "Advanced",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	connectionSampling = config.Concurrent.GetAsInt(CfgOptionConnectionSamplingKey, 1)
+
 	devMode = config.Concurrent.GetAsBool(core.CfgDevModeKey, false)
 	apiListenAddress = config.GetAsString(api.CfgDefaultListenAddressKey, "")
 
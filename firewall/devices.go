@@ -0,0 +1,40 @@
+package firewall
+
+import (
+	"context"
+	"net"
+
+	"github.com/safing/portmaster/devices"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/profile"
+)
+
+// checkDevicePolicy enforces the device policy on DNS requests of devices on
+// the local network that use the Portmaster as a network service. The
+// forwarded traffic of the device is restricted by the device isolation rules.
+func checkDevicePolicy(_ context.Context, conn *network.Connection, _ *profile.LayeredProfile, _ packet.Packet) bool {
+	deviceIP := getDeviceIP(conn)
+	if deviceIP == nil || conn.Type != network.DNSRequest {
+		return false
+	}
+
+	decided, allowed := devices.CheckDNSRequest(deviceIP, conn.Entity.Domain)
+	switch {
+	case !decided:
+		return false
+	case allowed:
+		conn.Accept("allowed by device policy", noReasonOptionKey)
+	default:
+		conn.Block("blocked by device policy", noReasonOptionKey)
+	}
+	return true
+}
+
+// getDeviceIP returns the IP of the network host of an external connection.
+func getDeviceIP(conn *network.Connection) net.IP {
+	if !conn.External {
+		return nil
+	}
+	return conn.Process().NetworkHostIP()
+}
@@ -8,6 +8,7 @@
 	"github.com/miekg/dns"
 	"github.com/safing/portbase/database"
 	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/devices"
 	"github.com/safing/portmaster/network"
 	"github.com/safing/portmaster/network/netutils"
 	"github.com/safing/portmaster/profile"
@@ -254,6 +255,11 @@ func UpdateIPsAndCNAMEs(q *resolver.Query, rrCache *resolver.RRCache, conn *netw
 		}
 	}
 
+	// Allow isolated devices to reach the resolved IPs of their allowed domains.
+	if deviceIP := getDeviceIP(conn); deviceIP != nil {
+		devices.AddResolvedIPs(deviceIP, q.FQDN, ips)
+	}
+
 	// Package IPs and CNAMEs into IPInfo structs.
 	for _, ip := range ips {
 		// Never save domain attributions for localhost IPs.
@@ -300,6 +306,7 @@ func UpdateIPsAndCNAMEs(q *resolver.Query, rrCache *resolver.RRCache, conn *netw
 
 		// Add the new record to the resolved domains for this IP and scope.
 		info.AddDomain(record)
+		resolver.AddRecentAnswer(ip, record, profileID, conn.Process().Pid)
 
 		// Save if the record is new or has been updated.
 		if err := info.Save(); err != nil {
@@ -0,0 +1,129 @@
+package firewall
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/netutils"
+	"github.com/safing/portmaster/process"
+	"github.com/safing/portmaster/profile"
+)
+
+// maxTestDomains limits the amount of domains that can be tested at once.
+const maxTestDomains = 1000
+
+// DomainTestResult reports the decision on a DNS request for a domain.
+type DomainTestResult struct {
+	Domain  string
+	Blocked bool
+	// Verdict is the verdict of the DNS request. It is "Undecided" if the
+	// user would be prompted.
+	Verdict string
+	// Reason describes why the verdict was made.
+	Reason string
+	// OptionKey is the key of the setting responsible for the verdict.
+	OptionKey string `json:",omitempty"`
+	// Profile is the key of the profile that held the setting.
+	Profile string `json:",omitempty"`
+	// FilterLists holds the IDs of the filter lists that blocked the domain.
+	FilterLists []string `json:",omitempty"`
+}
+
+func registerDomainTestAPI() error {
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:        "filter/test-domains/{source:[a-z]+}/{id:[^/]+}",
+		Write:       api.PermitUser,
+		BelongsTo:   interceptionModule,
+		StructFunc:  handleTestDomains,
+		Name:        "Test Domains",
+		Description: "Reports which of the given domains are blocked for DNS requests of a profile, and by which setting, rule or filter lists. The domains are not resolved and no prompts are shown.",
+		Parameters: []api.Parameter{{
+			Method:      http.MethodPost,
+			Field:       "body",
+			Value:       `["example.com","ads.example.net"]`,
+			Description: "Supply the domains to test as a JSON list or one domain per line.",
+		}},
+	})
+}
+
+func handleTestDomains(ar *api.Request) (i interface{}, err error) {
+	if ar.URLVars["source"] != string(profile.SourceLocal) {
+		return nil, errors.New("domains can only be tested for local profiles")
+	}
+	p, err := profile.GetProfile(profile.SourceLocal, ar.URLVars["id"], "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get profile: %w", err)
+	}
+
+	domains, err := parseTestDomains(ar.InputData)
+	if err != nil {
+		return nil, err
+	}
+	return TestDomains(ar.Context(), p, domains), nil
+}
+
+func parseTestDomains(data []byte) ([]string, error) {
+	var domains []string
+	if err := json.Unmarshal(data, &domains); err != nil {
+		domains = strings.Fields(string(data))
+	}
+
+	switch {
+	case len(domains) == 0:
+		return nil, errors.New("no domains given")
+	case len(domains) > maxTestDomains:
+		return nil, fmt.Errorf("too many domains, at most %d can be tested at once", maxTestDomains)
+	}
+
+	for i, domain := range domains {
+		domains[i] = dns.Fqdn(strings.ToLower(strings.TrimSpace(domain)))
+		if !netutils.IsValidFqdn(domains[i]) {
+			return nil, fmt.Errorf("invalid domain %q", domain)
+		}
+	}
+	return domains, nil
+}
+
+// TestDomains reports the decisions on DNS requests for the given domains by
+// an application that uses the given local profile.
+func TestDomains(ctx context.Context, localProfile *profile.Profile, domains []string) []*DomainTestResult {
+	proc := process.NewSyntheticProcess(localProfile)
+
+	results := make([]*DomainTestResult, 0, len(domains))
+	for i, domain := range domains {
+		conn := network.NewSyntheticDNSRequest(ctx, fmt.Sprintf("domain-test-%d", i), proc, domain)
+		conn.Lock()
+		DecideOnConnectionWithoutPrompt(ctx, conn)
+
+		result := &DomainTestResult{
+			Domain:    strings.TrimSuffix(domain, "."),
+			Blocked:   conn.Verdict == network.VerdictBlock || conn.Verdict == network.VerdictDrop,
+			Verdict:   conn.Verdict.String(),
+			Reason:    conn.Reason.Msg,
+			OptionKey: conn.Reason.OptionKey,
+			Profile:   conn.Reason.Profile,
+		}
+		if conn.Verdict == network.VerdictUndecided {
+			result.Reason = "the user would be prompted"
+		}
+		if lbr, ok := conn.Reason.Context.(intel.ListBlockReason); ok {
+			for _, lm := range lbr {
+				result.FilterLists = append(result.FilterLists, lm.ActiveLists...)
+			}
+		}
+		conn.Unlock()
+
+		results = append(results, result)
+	}
+
+	return results
+}
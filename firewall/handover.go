@@ -0,0 +1,191 @@
+package firewall
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/dataroot"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+)
+
+// The handover state holds the verdicts of active connections when the
+// Portmaster is stopped (eg. for an update), so that the next instance can
+// re-adopt them instead of re-evaluating or prompting for every connection.
+// The state is also saved periodically, so that it is available after a crash.
+
+const (
+	handoverFileName = "firewall-handover.json"
+
+	// handoverSaveInterval defines how often the handover state is saved while
+	// running, in case the Portmaster is not stopped cleanly.
+	handoverSaveInterval = 1 * time.Minute
+
+	// handoverMaxAge is the maximum age of handover state to be loaded. It must
+	// be greater than handoverSaveInterval.
+	handoverMaxAge = 3 * time.Minute
+
+	// handoverTTL defines how long loaded handover verdicts may be re-adopted
+	// after the start.
+	handoverTTL = 5 * time.Minute
+)
+
+var (
+	handoverVerdicts     map[string]*handoverVerdict
+	handoverVerdictsLock sync.Mutex
+	handoverValidUntil   time.Time
+)
+
+type handoverState struct {
+	Created  time.Time
+	Verdicts []*handoverVerdict
+}
+
+type handoverVerdict struct {
+	ID          string
+	ProcessPath string
+	Verdict     network.Verdict
+	Reason      string
+	OptionKey   string
+}
+
+func handoverFilePath() string {
+	return filepath.Join(dataroot.Root().Path, handoverFileName)
+}
+
+func startHandoverSaving() {
+	interceptionModule.NewTask("save handover state", func(_ context.Context, _ *modules.Task) error {
+		saveHandoverState()
+		return nil
+	}).Repeat(handoverSaveInterval)
+}
+
+// saveHandoverState writes the verdicts of all active connections to disk.
+func saveHandoverState() {
+	state := &handoverState{
+		Created: time.Now(),
+	}
+
+	for _, conn := range network.GetAllConnections() {
+		conn.Lock()
+		if conn.Type == network.IPConnection && conn.Ended == 0 {
+			switch conn.Verdict {
+			case network.VerdictAccept, network.VerdictBlock, network.VerdictDrop:
+				state.Verdicts = append(state.Verdicts, &handoverVerdict{
+					ID:          conn.ID,
+					ProcessPath: conn.ProcessContext.BinaryPath,
+					Verdict:     conn.Verdict,
+					Reason:      conn.Reason.Msg,
+					OptionKey:   conn.Reason.OptionKey,
+				})
+			}
+		}
+		conn.Unlock()
+	}
+
+	if len(state.Verdicts) == 0 {
+		// Remove any previous state, as it is outdated.
+		if err := os.Remove(handoverFilePath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Warningf("filter: failed to remove handover state: %s", err)
+		}
+		return
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		log.Warningf("filter: failed to serialize handover state: %s", err)
+		return
+	}
+	// Write to a temporary file first, so that a crash while writing does not
+	// leave a corrupted state behind.
+	tmpPath := handoverFilePath() + ".tmp"
+	err = ioutil.WriteFile(tmpPath, data, 0600)
+	if err == nil {
+		err = os.Rename(tmpPath, handoverFilePath())
+	}
+	if err != nil {
+		log.Warningf("filter: failed to save handover state: %s", err)
+		return
+	}
+	log.Debugf("filter: saved %d connection verdicts for handover", len(state.Verdicts))
+}
+
+// loadHandoverState loads the handover state of the previous instance, if it
+// exists and is recent enough. The state file is removed afterwards.
+func loadHandoverState() {
+	data, err := ioutil.ReadFile(handoverFilePath())
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Warningf("filter: failed to read handover state: %s", err)
+		}
+		return
+	}
+	// Always remove the handover state, it must only be used once.
+	if err := os.Remove(handoverFilePath()); err != nil {
+		log.Warningf("filter: failed to remove handover state: %s", err)
+	}
+
+	state := &handoverState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		log.Warningf("filter: failed to parse handover state: %s", err)
+		return
+	}
+	if time.Since(state.Created) > handoverMaxAge || state.Created.After(time.Now()) {
+		log.Infof("filter: ignoring outdated handover state from %s", state.Created)
+		return
+	}
+
+	handoverVerdictsLock.Lock()
+	defer handoverVerdictsLock.Unlock()
+
+	handoverVerdicts = make(map[string]*handoverVerdict, len(state.Verdicts))
+	for _, hv := range state.Verdicts {
+		handoverVerdicts[hv.ID] = hv
+	}
+	handoverValidUntil = time.Now().Add(handoverTTL)
+	log.Infof("filter: loaded %d connection verdicts from previous instance", len(handoverVerdicts))
+}
+
+// readoptVerdict applies the verdict of the previous instance to the given
+// connection, if one is available. It returns whether the connection was
+// handled.
+func readoptVerdict(conn *network.Connection, pkt packet.Packet) bool {
+	handoverVerdictsLock.Lock()
+	if len(handoverVerdicts) == 0 {
+		handoverVerdictsLock.Unlock()
+		return false
+	}
+	if time.Now().After(handoverValidUntil) {
+		handoverVerdicts = nil
+		handoverVerdictsLock.Unlock()
+		return false
+	}
+	hv, ok := handoverVerdicts[conn.ID]
+	if ok {
+		delete(handoverVerdicts, conn.ID)
+	}
+	handoverVerdictsLock.Unlock()
+
+	// Only re-adopt the verdict if the connection still belongs to the same
+	// program, as the connection ID might have been reused.
+	if !ok || hv.ProcessPath != conn.Process().Path {
+		return false
+	}
+
+	if !conn.SetVerdict(hv.Verdict, hv.Reason+" (from previous run)", hv.OptionKey, nil) {
+		return false
+	}
+	log.Tracer(pkt.Ctx()).Infof("filter: re-adopted verdict %s from previous run", hv.Verdict)
+
+	conn.StopFirewallHandler()
+	issueVerdict(conn, pkt, 0, true)
+	return true
+}
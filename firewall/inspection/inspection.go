@@ -85,11 +85,16 @@ func RunInspectors(conn *network.Connection, pkt packet.Packet) (network.Verdict
 			verdict = network.VerdictDrop
 			continueInspection = true
 		case BLOCK_CONN:
-			conn.SetVerdict(network.VerdictBlock, "", "", nil)
+			// Inspectors may have set the verdict with a reason already.
+			if conn.Verdict < network.VerdictBlock {
+				conn.SetVerdict(network.VerdictBlock, "", "", nil)
+			}
 			verdict = conn.Verdict
 			activeInspectors[key] = true
 		case DROP_CONN:
-			conn.SetVerdict(network.VerdictDrop, "", "", nil)
+			if conn.Verdict < network.VerdictDrop {
+				conn.SetVerdict(network.VerdictDrop, "", "", nil)
+			}
 			verdict = conn.Verdict
 			activeInspectors[key] = true
 		case STOP_INSPECTING:
@@ -0,0 +1,216 @@
+package inspection
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portmaster/network/netutils"
+)
+
+// Proxy Protocols
+const (
+	ProxyProtocolHTTP   = "http"
+	ProxyProtocolSOCKS4 = "socks4"
+	ProxyProtocolSOCKS5 = "socks5"
+)
+
+// maxHTTPRequestLine limits how much of a payload is searched for the HTTP
+// request line.
+const maxHTTPRequestLine = 2048
+
+// ProxyRequest describes the real destination a client requested from a
+// proxy.
+type ProxyRequest struct {
+	Protocol string
+	// Domain is the requested domain as FQDN, if the client requested a domain.
+	Domain string
+	// IP is the requested IP, if the client requested an IP.
+	IP   net.IP
+	Port uint16
+}
+
+// ProxyDetector detects SOCKS and HTTP proxy handshakes in the outgoing data
+// of a connection. It must be fed the payloads of outgoing packets in order.
+type ProxyDetector struct {
+	socks5Greeting bool
+}
+
+// Inspect inspects the next outgoing payload of the connection. It returns the
+// requested destination, if a proxy handshake was detected, and whether the
+// detection is complete.
+func (pd *ProxyDetector) Inspect(payload []byte) (request *ProxyRequest, done bool) {
+	// Wait for data.
+	if len(payload) == 0 {
+		return nil, false
+	}
+
+	// The SOCKS5 connect request follows the greeting.
+	if pd.socks5Greeting {
+		return parseSOCKS5Request(payload), true
+	}
+
+	switch payload[0] {
+	case 0x05:
+		// SOCKS5 greeting: version, number of methods, methods.
+		if len(payload) >= 3 && len(payload) == 2+int(payload[1]) {
+			pd.socks5Greeting = true
+			return nil, false
+		}
+		return nil, true
+	case 0x04:
+		return parseSOCKS4Request(payload), true
+	default:
+		return parseHTTPProxyRequest(payload), true
+	}
+}
+
+// parseSOCKS4Request parses a SOCKS4 or SOCKS4a connect request.
+func parseSOCKS4Request(payload []byte) *ProxyRequest {
+	// Version, command, port, IPv4, null-terminated user ID.
+	if len(payload) < 9 || payload[1] != 0x01 {
+		return nil
+	}
+	userIDEnd := bytes.IndexByte(payload[8:], 0)
+	if userIDEnd < 0 {
+		return nil
+	}
+
+	request := &ProxyRequest{
+		Protocol: ProxyProtocolSOCKS4,
+		Port:     binary.BigEndian.Uint16(payload[2:4]),
+	}
+	ip := net.IP(payload[4:8])
+
+	// SOCKS4a signals a domain with the IP 0.0.0.x, where x is non-zero. The
+	// null-terminated domain follows the user ID.
+	if ip[0] == 0 && ip[1] == 0 && ip[2] == 0 && ip[3] != 0 {
+		domainData := payload[8+userIDEnd+1:]
+		domainEnd := bytes.IndexByte(domainData, 0)
+		if domainEnd < 0 {
+			return nil
+		}
+		request.Domain = toFqdn(string(domainData[:domainEnd]))
+		if request.Domain == "" {
+			return nil
+		}
+		return request
+	}
+
+	request.IP = append(net.IP(nil), ip...)
+	return request
+}
+
+// parseSOCKS5Request parses a SOCKS5 connect request.
+func parseSOCKS5Request(payload []byte) *ProxyRequest {
+	// Version, command, reserved, address type, address, port.
+	if len(payload) < 7 || payload[0] != 0x05 || payload[1] != 0x01 {
+		return nil
+	}
+
+	request := &ProxyRequest{
+		Protocol: ProxyProtocolSOCKS5,
+	}
+	var addrEnd int
+	switch payload[3] {
+	case 0x01: // IPv4
+		addrEnd = 4 + net.IPv4len
+		if len(payload) != addrEnd+2 {
+			return nil
+		}
+		request.IP = append(net.IP(nil), payload[4:addrEnd]...)
+	case 0x03: // Domain
+		addrEnd = 5 + int(payload[4])
+		if len(payload) != addrEnd+2 {
+			return nil
+		}
+		request.Domain = toFqdn(string(payload[5:addrEnd]))
+		if request.Domain == "" {
+			return nil
+		}
+	case 0x04: // IPv6
+		addrEnd = 4 + net.IPv6len
+		if len(payload) != addrEnd+2 {
+			return nil
+		}
+		request.IP = append(net.IP(nil), payload[4:addrEnd]...)
+	default:
+		return nil
+	}
+	request.Port = binary.BigEndian.Uint16(payload[addrEnd : addrEnd+2])
+
+	return request
+}
+
+// parseHTTPProxyRequest parses the request line of an HTTP request and
+// returns the destination if it is a CONNECT request or a request in absolute
+// form, as sent to HTTP proxies.
+func parseHTTPProxyRequest(payload []byte) *ProxyRequest {
+	if len(payload) > maxHTTPRequestLine {
+		payload = payload[:maxHTTPRequestLine]
+	}
+	lineEnd := bytes.Index(payload, []byte("\r\n"))
+	if lineEnd < 0 {
+		return nil
+	}
+	parts := strings.Split(string(payload[:lineEnd]), " ")
+	if len(parts) != 3 || !strings.HasPrefix(parts[2], "HTTP/1.") {
+		return nil
+	}
+	method, target := parts[0], parts[1]
+
+	var host, port string
+	switch {
+	case method == "CONNECT":
+		var err error
+		host, port, err = net.SplitHostPort(target)
+		if err != nil {
+			return nil
+		}
+	case strings.HasPrefix(target, "http://"):
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil
+		}
+		host, port = u.Hostname(), u.Port()
+		if port == "" {
+			port = "80"
+		}
+	default:
+		// Regular request to a web server.
+		return nil
+	}
+
+	portNum, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || portNum == 0 {
+		return nil
+	}
+	request := &ProxyRequest{
+		Protocol: ProxyProtocolHTTP,
+		Port:     uint16(portNum),
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		request.IP = ip
+		return request
+	}
+	request.Domain = toFqdn(host)
+	if request.Domain == "" {
+		return nil
+	}
+	return request
+}
+
+// toFqdn returns the given domain as a lowercase FQDN, or an empty string if
+// the domain is invalid.
+func toFqdn(domain string) string {
+	fqdn := dns.Fqdn(strings.ToLower(domain))
+	if !netutils.IsValidFqdn(fqdn) {
+		return ""
+	}
+	return fqdn
+}
@@ -0,0 +1,81 @@
+package inspection
+
+import (
+	"net"
+	"testing"
+)
+
+func testProxyDetection(t *testing.T, payloads [][]byte, expected *ProxyRequest) {
+	t.Helper()
+
+	pd := &ProxyDetector{}
+	for i, payload := range payloads {
+		request, done := pd.Inspect(payload)
+		if !done {
+			if i == len(payloads)-1 {
+				t.Fatalf("detection not done after %d payloads", len(payloads))
+			}
+			continue
+		}
+
+		switch {
+		case expected == nil && request == nil:
+		case expected == nil:
+			t.Errorf("unexpected detection of %+v", request)
+		case request == nil:
+			t.Errorf("expected detection of %+v", expected)
+		case request.Protocol != expected.Protocol ||
+			request.Domain != expected.Domain ||
+			!request.IP.Equal(expected.IP) ||
+			request.Port != expected.Port:
+			t.Errorf("expected %+v, got %+v", expected, request)
+		}
+		return
+	}
+}
+
+func TestProxyDetection(t *testing.T) {
+	t.Parallel()
+
+	// HTTP
+	testProxyDetection(t, [][]byte{
+		[]byte("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n"),
+	}, &ProxyRequest{Protocol: ProxyProtocolHTTP, Domain: "example.com.", Port: 443})
+	testProxyDetection(t, [][]byte{
+		[]byte("CONNECT [2001:db8::1]:8443 HTTP/1.1\r\n\r\n"),
+	}, &ProxyRequest{Protocol: ProxyProtocolHTTP, IP: net.ParseIP("2001:db8::1"), Port: 8443})
+	testProxyDetection(t, [][]byte{
+		[]byte("GET http://Example.com/index.html HTTP/1.1\r\nHost: example.com\r\n\r\n"),
+	}, &ProxyRequest{Protocol: ProxyProtocolHTTP, Domain: "example.com.", Port: 80})
+	testProxyDetection(t, [][]byte{
+		[]byte("GET /index.html HTTP/1.1\r\nHost: example.com\r\n\r\n"),
+	}, nil)
+
+	// SOCKS4 and SOCKS4a
+	testProxyDetection(t, [][]byte{
+		{0x04, 0x01, 0x00, 0x50, 192, 0, 2, 1, 'u', 0x00},
+	}, &ProxyRequest{Protocol: ProxyProtocolSOCKS4, IP: net.IPv4(192, 0, 2, 1), Port: 80})
+	testProxyDetection(t, [][]byte{
+		append([]byte{0x04, 0x01, 0x01, 0xBB, 0, 0, 0, 1, 0x00}, []byte("example.com\x00")...),
+	}, &ProxyRequest{Protocol: ProxyProtocolSOCKS4, Domain: "example.com.", Port: 443})
+
+	// SOCKS5
+	testProxyDetection(t, [][]byte{
+		{0x05, 0x01, 0x00},
+		nil,
+		append(append([]byte{0x05, 0x01, 0x00, 0x03, 11}, []byte("example.com")...), 0x01, 0xBB),
+	}, &ProxyRequest{Protocol: ProxyProtocolSOCKS5, Domain: "example.com.", Port: 443})
+	testProxyDetection(t, [][]byte{
+		{0x05, 0x02, 0x00, 0x02},
+		{0x05, 0x01, 0x00, 0x01, 192, 0, 2, 1, 0x00, 0x16},
+	}, &ProxyRequest{Protocol: ProxyProtocolSOCKS5, IP: net.IPv4(192, 0, 2, 1), Port: 22})
+	testProxyDetection(t, [][]byte{
+		{0x05, 0x01, 0x00},
+		{0x05, 0x03, 0x00, 0x01, 192, 0, 2, 1, 0x00, 0x35},
+	}, nil)
+
+	// Other protocols
+	testProxyDetection(t, [][]byte{
+		{0x16, 0x03, 0x01, 0x02, 0x00},
+	}, nil)
+}
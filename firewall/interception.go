@@ -6,6 +6,7 @@
 	"fmt"
 	"net"
 	"os"
+	"runtime/pprof"
 	"sync/atomic"
 	"time"
 
@@ -17,11 +18,15 @@
 
 	"github.com/safing/portbase/log"
 	"github.com/safing/portbase/modules"
+	"github.com/safing/portmaster/core"
 	"github.com/safing/portmaster/firewall/inspection"
 	"github.com/safing/portmaster/firewall/interception"
+	"github.com/safing/portmaster/flightrecorder"
 	"github.com/safing/portmaster/network"
 	"github.com/safing/portmaster/network/netutils"
 	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/tracing"
+	"github.com/safing/portmaster/updates"
 	"github.com/safing/spn/captain"
 	"github.com/safing/spn/sluice"
 
@@ -48,7 +53,7 @@
 )
 
 func init() {
-	interceptionModule = modules.Register("interception", interceptionPrep, interceptionStart, interceptionStop, "base", "updates", "network")
+	interceptionModule = modules.Register("interception", interceptionPrep, interceptionStart, interceptionStop, "base", "updates", "network", "history")
 
 	network.SetDefaultFirewallHandler(defaultHandler)
 }
@@ -64,6 +69,80 @@ func interceptionStart() error {
 
 	startAPIAuth()
 
+	if err := startStartupHold(); err != nil {
+		return err
+	}
+
+	if err := registerSuggestionsAPI(); err != nil {
+		return err
+	}
+
+	if err := registerDomainTestAPI(); err != nil {
+		return err
+	}
+
+	if err := registerRuleTestAPI(); err != nil {
+		return err
+	}
+
+	if err := registerUISessionAPI(); err != nil {
+		return err
+	}
+
+	if err := registerActivityAPI(); err != nil {
+		return err
+	}
+	updates.SetUserActivityCheck(userActive)
+
+	if err := registerRecordingAPI(); err != nil {
+		return err
+	}
+
+	if err := registerRecordingVerifyAPI(); err != nil {
+		return err
+	}
+
+	if err := registerReplayAPI(); err != nil {
+		return err
+	}
+
+	if err := registerTransactionAPI(); err != nil {
+		return err
+	}
+	if err := revertUnconfirmedTransaction(); err != nil {
+		log.Warningf("filter: failed to revert unconfirmed config transaction: %s", err)
+	}
+
+	if err := startPolicyScripts(); err != nil {
+		return err
+	}
+
+	if err := registerInterfaceBindingHook(); err != nil {
+		return err
+	}
+
+	if err := registerRetroHuntHook(); err != nil {
+		return err
+	}
+
+	if err := registerConnectionSamplingHook(); err != nil {
+		return err
+	}
+
+	if err := registerKillSwitchHook(); err != nil {
+		return err
+	}
+
+	if err := registerVerdictPluginHook(); err != nil {
+		return err
+	}
+
+	startOverloadMitigation()
+	loadHandoverState()
+	startHandoverSaving()
+
+	startProxyRedirect()
+
 	interceptionModule.StartWorker("stat logger", statLogger)
 	interceptionModule.StartWorker("packet handler", packetHandler)
 
@@ -71,6 +150,8 @@ func interceptionStart() error {
 }
 
 func interceptionStop() error {
+	saveHandoverState()
+	prepKillSwitch()
 	return interception.Stop()
 }
 
@@ -320,6 +401,8 @@ func fastTrackedPermit(pkt packet.Packet) (handled bool) {
 
 func initialHandler(conn *network.Connection, pkt packet.Packet) {
 	log.Tracer(pkt.Ctx()).Trace("filter: handing over to connection-based handler")
+	// End the connection span started in network.NewConnectionFromFirstPacket.
+	defer tracing.SpanFromContext(pkt.Ctx()).End()
 
 	// Check for pre-authenticated port.
 	if !conn.Inbound && localPortIsPreAuthenticated(conn.Entity.Protocol, conn.LocalPort) {
@@ -338,6 +421,9 @@ func initialHandler(conn *network.Connection, pkt packet.Packet) {
 		conn.Process().Pid != ownPID &&
 		nameserverIPMatcherReady.IsSet() &&
 		!nameserverIPMatcher(pkt.Info().Dst) {
+		if !conn.Process().IsSystemResolver() {
+			conn.ReportBypassAttempt(network.BypassHardcodedDNS, bypassDestination(conn))
+		}
 		conn.Verdict = network.VerdictRerouteToNameserver
 		conn.Reason.Msg = "redirecting rogue dns query"
 		conn.Internal = true
@@ -346,6 +432,22 @@ func initialHandler(conn *network.Connection, pkt packet.Packet) {
 		return
 	}
 
+	// Re-adopt verdicts of connections that were active before a restart.
+	if readoptVerdict(conn, pkt) {
+		return
+	}
+
+	// Handle additional subflows of multipath TCP connections like their first
+	// subflow.
+	if adoptMPTCPVerdict(conn, pkt) {
+		return
+	}
+
+	// Hold back new connections until the Portmaster is fully started.
+	if holdDuringStartup(conn, pkt) {
+		return
+	}
+
 	// check if filtering is enabled
 	if !filterEnabled() {
 		conn.Inspecting = false
@@ -355,16 +457,41 @@ func initialHandler(conn *network.Connection, pkt packet.Packet) {
 		return
 	}
 
+	// Quickly allow recently allowed destinations during overload.
+	if failOpenOnOverload(conn) {
+		conn.StopFirewallHandler()
+		issueVerdict(conn, pkt, 0, true)
+		return
+	}
+
 	log.Tracer(pkt.Ctx()).Trace("filter: starting decision process")
 	DecideOnConnection(pkt.Ctx(), conn, pkt)
-	conn.Inspecting = false // TODO: enable inspecting again
+	recordAllowedDestination(conn)
+	if conn.Verdict == network.VerdictBlock || conn.Verdict == network.VerdictDrop {
+		flightrecorder.TriggerOnBlock(conn.Entity.Domain, conn.Entity.IP)
+	}
+
+	// Inspect accepted outgoing TCP connections in order to filter the real
+	// destination of proxy use, if required, and accepted multipath TCP
+	// connections in order to learn their keys.
+	conn.Inspecting = !conn.Internal &&
+		conn.Entity.Protocol == uint8(packet.TCP) &&
+		conn.Verdict == network.VerdictAccept &&
+		(isMPTCPCapable(pkt) || (!conn.Inbound && proxyInspectionRequired(conn)))
+
+	// Redirect to the proxy configured for the app. Otherwise, try tunneling.
+	proxied := redirectToProxy(pkt.Ctx(), conn, pkt)
 
 	// tunneling
 	// TODO: add implementation for forced tunneling
-	if pkt.IsOutbound() &&
+	// Own connections are only tunneled if they are update requests, as the
+	// SPN itself connects from the Portmaster.
+	if !proxied &&
+		pkt.IsOutbound() &&
 		captain.ClientReady() &&
 		conn.Entity.IPScope.IsGlobal() &&
-		conn.Verdict == network.VerdictAccept {
+		conn.Verdict == network.VerdictAccept &&
+		(conn.Process().Pid != ownPID || updates.TunnelUpdateConnection(conn.Entity.IP, conn.Entity.Port)) {
 		// try to tunnel
 		err := sluice.AwaitRequest(pkt.Info(), conn.Entity.Domain)
 		if err != nil {
@@ -407,7 +534,8 @@ func inspectThenVerdict(conn *network.Connection, pkt packet.Packet) {
 func issueVerdict(conn *network.Connection, pkt packet.Packet, verdict network.Verdict, allowPermanent bool) {
 	// enable permanent verdict
 	if allowPermanent && !conn.VerdictPermanent {
-		conn.VerdictPermanent = permanentVerdicts()
+		// Connections with a bandwidth limit must stay in the packet path.
+		conn.VerdictPermanent = permanentVerdicts() && !bandwidthLimited(conn)
 		if conn.VerdictPermanent {
 			conn.SaveWhenFinished()
 		}
@@ -417,10 +545,16 @@ func issueVerdict(conn *network.Connection, pkt packet.Packet, verdict network.V
 	if verdict < conn.Verdict {
 		verdict = conn.Verdict
 	}
+	flightrecorder.RecordPacket(pkt, verdict.String())
 
 	var err error
 	switch verdict {
 	case network.VerdictAccept:
+		if !conn.VerdictPermanent && !enforceBandwidthLimit(conn, pkt) {
+			atomic.AddUint64(packetsDropped, 1)
+			err = pkt.Drop()
+			break
+		}
 		atomic.AddUint64(packetsAccepted, 1)
 		if conn.VerdictPermanent {
 			err = pkt.PermanentAccept()
@@ -445,6 +579,8 @@ func issueVerdict(conn *network.Connection, pkt packet.Packet, verdict network.V
 		err = pkt.RerouteToNameserver()
 	case network.VerdictRerouteToTunnel:
 		err = pkt.RerouteToTunnel()
+	case network.VerdictRerouteToProxy:
+		err = pkt.RerouteToProxy()
 	case network.VerdictFailed:
 		atomic.AddUint64(packetsFailed, 1)
 		err = pkt.Drop()
@@ -472,17 +608,23 @@ func issueVerdict(conn *network.Connection, pkt packet.Packet, verdict network.V
 // }
 
 func packetHandler(ctx context.Context) error {
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		case pkt := <-interception.Packets:
-			interceptionModule.StartWorker("initial packet handler", func(workerCtx context.Context) error {
-				handlePacket(workerCtx, pkt)
-				return nil
-			})
+	// The packet handler workers inherit the profiler labels, which attribute
+	// their resource usage.
+	labels := pprof.Labels(core.ModuleLabel, interceptionModule.Name, core.TaskLabel, "packet handler")
+	pprof.Do(ctx, labels, func(ctx context.Context) {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case pkt := <-interception.Packets:
+				interceptionModule.StartWorker("initial packet handler", func(workerCtx context.Context) error {
+					handlePacket(workerCtx, pkt)
+					return nil
+				})
+			}
 		}
-	}
+	})
+	return nil
 }
 
 func statLogger(ctx context.Context) error {
@@ -0,0 +1,24 @@
+package interception
+
+import "net"
+
+// DeviceIsolation describes how the forwarded traffic of a device on the
+// local network is restricted when the Portmaster acts as its gateway.
+type DeviceIsolation struct {
+	// MAC is the hardware address of the device.
+	MAC string
+	// BlockAll blocks all forwarded traffic of the device. Otherwise, the
+	// device may still reach the local network and the allowed IPs.
+	BlockAll bool
+	// AllowedIPs holds the Internet IPs the device may reach.
+	AllowedIPs []net.IP
+}
+
+// SetDeviceIsolation installs the given device isolation rules in the OS
+// firewall, replacing any previous ones. An empty list removes all rules.
+func SetDeviceIsolation(devices []*DeviceIsolation) error {
+	if len(devices) == 0 {
+		return removeDeviceIsolation()
+	}
+	return installDeviceIsolation(devices)
+}
@@ -0,0 +1,13 @@
+//+build !linux
+
+package interception
+
+import "errors"
+
+func installDeviceIsolation(_ []*DeviceIsolation) error {
+	return errors.New("device isolation is not supported on this platform")
+}
+
+func removeDeviceIsolation() error {
+	return nil
+}
@@ -0,0 +1,90 @@
+package interception
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/hashicorp/go-multierror"
+)
+
+var (
+	deviceIsolationChains = []string{
+		"filter C17DEV",
+	}
+
+	deviceIsolationOnce = []string{
+		"filter FORWARD -j C17DEV",
+	}
+
+	// localNetworks are reachable by isolated devices, as isolation only
+	// concerns the Internet.
+	localNetworksIPv4 = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "169.254.0.0/16"}
+	localNetworksIPv6 = []string{"fc00::/7", "fe80::/10"}
+)
+
+func installDeviceIsolation(devices []*DeviceIsolation) error {
+	if err := activateIPTables(
+		iptables.ProtocolIPv4,
+		deviceIsolationRules(devices, false),
+		deviceIsolationOnce,
+		deviceIsolationChains,
+	); err != nil {
+		return err
+	}
+	return activateIPTables(
+		iptables.ProtocolIPv6,
+		deviceIsolationRules(devices, true),
+		deviceIsolationOnce,
+		deviceIsolationChains,
+	)
+}
+
+// deviceIsolationRules returns the rules for the given devices. Matching
+// packets return to the FORWARD chain, all other forwarded packets of
+// isolated devices are dropped.
+func deviceIsolationRules(devices []*DeviceIsolation, ipv6 bool) []string {
+	localNetworks := localNetworksIPv4
+	if ipv6 {
+		localNetworks = localNetworksIPv6
+	}
+
+	var rules []string
+	for _, device := range devices {
+		match := "filter C17DEV -m mac --mac-source " + device.MAC
+		if !device.BlockAll {
+			for _, network := range localNetworks {
+				rules = append(rules, fmt.Sprintf("%s -d %s -j RETURN", match, network))
+			}
+			for _, ip := range device.AllowedIPs {
+				if (ip.To4() == nil) == ipv6 {
+					rules = append(rules, fmt.Sprintf("%s -d %s -j RETURN", match, ip))
+				}
+			}
+		}
+		rules = append(rules, match+" -j DROP")
+	}
+	return rules
+}
+
+func removeDeviceIsolation() error {
+	var result *multierror.Error
+	for _, protocol := range []iptables.Protocol{iptables.ProtocolIPv4, iptables.ProtocolIPv6} {
+		// Only remove the rules if they exist, in order to not spam errors.
+		tbls, err := iptables.NewWithProtocol(protocol)
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		splittedChain := strings.Split(deviceIsolationChains[0], " ")
+		exists, err := tbls.ChainExists(splittedChain[0], splittedChain[1])
+		if err != nil || !exists {
+			continue
+		}
+
+		if err := deactivateIPTables(protocol, deviceIsolationOnce, deviceIsolationChains); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	return result.ErrorOrNil()
+}
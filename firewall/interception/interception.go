@@ -25,6 +25,8 @@ func Start() error {
 		return nil
 	}
 
+	deactivateKillSwitch()
+
 	var inputPackets = Packets
 	if packetMetricsDestination != "" {
 		go metrics.writeMetrics()
@@ -47,5 +49,7 @@ func Stop() error {
 
 	close(metrics.done)
 
-	return stop()
+	err := stop()
+	activateKillSwitch()
+	return err
 }
@@ -0,0 +1,152 @@
+package interception
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/safing/portbase/dataroot"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/utils/renameio"
+)
+
+// KillSwitchStateFileName is the name of the file in the data directory that
+// holds the kill switch configuration. It is persisted, so that the kill
+// switch can be installed again by portmaster-start after a crash or a reboot,
+// when the Portmaster Core cannot install it itself.
+const KillSwitchStateFileName = "kill-switch.json"
+
+// KillSwitchState holds the kill switch configuration.
+type KillSwitchState struct {
+	BlockAll bool     `json:",omitempty"`
+	Binaries []string `json:",omitempty"`
+}
+
+// IsEmpty returns whether the kill switch does not block anything.
+func (s *KillSwitchState) IsEmpty() bool {
+	return s == nil || (!s.BlockAll && len(s.Binaries) == 0)
+}
+
+var (
+	killSwitchLock     sync.Mutex
+	killSwitchBlockAll bool
+	killSwitchBinaries []string
+)
+
+// SetKillSwitch configures which traffic should stay blocked by the OS
+// firewall while the Portmaster is not running. If blockAll is set, all
+// traffic, except localhost, is blocked. Otherwise, only the given binaries
+// are blocked. The kill switch is installed when the interception is stopped
+// and removed again when it is started. The configuration is persisted in
+// the data directory for ReapplyKillSwitch.
+func SetKillSwitch(blockAll bool, binaries []string) {
+	killSwitchLock.Lock()
+	defer killSwitchLock.Unlock()
+
+	killSwitchBlockAll = blockAll
+	killSwitchBinaries = binaries
+
+	if root := dataroot.Root(); root != nil {
+		state := &KillSwitchState{
+			BlockAll: blockAll,
+			Binaries: binaries,
+		}
+		if err := saveKillSwitchState(root.Path, state); err != nil {
+			log.Warningf("interception: failed to save kill switch configuration: %s", err)
+		}
+	}
+}
+
+// ReapplyKillSwitch installs the kill switch as persisted in the given data
+// directory. It is used by portmaster-start while the Portmaster Core is not
+// running: when the system starts and after the Portmaster Core crashed. The
+// Portmaster Core removes the kill switch again when it starts intercepting.
+func ReapplyKillSwitch(dataDir string) error {
+	state, err := LoadKillSwitchState(dataDir)
+	if err != nil {
+		return err
+	}
+	if state.IsEmpty() {
+		return nil
+	}
+
+	killSwitchLock.Lock()
+	defer killSwitchLock.Unlock()
+
+	return installKillSwitch(state.BlockAll, state.Binaries)
+}
+
+// LoadKillSwitchState loads the persisted kill switch configuration from the
+// given data directory. It returns nil if there is none.
+func LoadKillSwitchState(dataDir string) (*KillSwitchState, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dataDir, KillSwitchStateFileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	state := &KillSwitchState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// saveKillSwitchState persists the kill switch configuration in the given
+// data directory. If the kill switch is off, the persisted configuration is
+// removed.
+func saveKillSwitchState(dataDir string, state *KillSwitchState) error {
+	if state.IsEmpty() {
+		err := os.Remove(filepath.Join(dataDir, KillSwitchStateFileName))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return renameio.WriteFile(filepath.Join(dataDir, KillSwitchStateFileName), data, 0600)
+}
+
+func activateKillSwitch() {
+	killSwitchLock.Lock()
+	defer killSwitchLock.Unlock()
+
+	if !killSwitchBlockAll && len(killSwitchBinaries) == 0 {
+		return
+	}
+
+	if err := installKillSwitch(killSwitchBlockAll, killSwitchBinaries); err != nil {
+		log.Errorf("interception: failed to install kill switch: %s", err)
+		return
+	}
+	if killSwitchBlockAll {
+		log.Warning("interception: kill switch installed, all network traffic is blocked until the Portmaster is started again")
+	} else {
+		log.Warningf("interception: kill switch installed, %d apps are blocked until the Portmaster is started again", len(killSwitchBinaries))
+	}
+}
+
+// RemoveKillSwitch removes the kill switch from the OS firewall. It does not
+// need the Portmaster to be running, so that network access can be recovered
+// if the Portmaster is stuck.
+func RemoveKillSwitch() error {
+	killSwitchLock.Lock()
+	defer killSwitchLock.Unlock()
+
+	return removeKillSwitch()
+}
+
+func deactivateKillSwitch() {
+	if err := removeKillSwitch(); err != nil {
+		log.Warningf("interception: failed to remove kill switch: %s", err)
+	}
+}
@@ -0,0 +1,19 @@
+//+build !windows,!linux
+
+package interception
+
+import "errors"
+
+// Kill switch support on this platform.
+const (
+	KillSwitchAllSupported  = false
+	KillSwitchAppsSupported = false
+)
+
+func installKillSwitch(_ bool, _ []string) error {
+	return errors.New("kill switch is not supported on this platform")
+}
+
+func removeKillSwitch() error {
+	return nil
+}
@@ -0,0 +1,72 @@
+package interception
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/hashicorp/go-multierror"
+)
+
+// Note: iptables rules do not survive a reboot, and the rules of the Portmaster
+// Core are not updated when it crashes. In both cases, portmaster-start
+// installs the kill switch again with ReapplyKillSwitch, until the Portmaster
+// Core is running again.
+
+var (
+	killSwitchChains = []string{
+		"filter C17KS",
+	}
+
+	killSwitchRules = []string{
+		"filter C17KS -o lo -j RETURN",
+		"filter C17KS -i lo -j RETURN",
+		"filter C17KS -j DROP",
+	}
+
+	killSwitchOnce = []string{
+		"filter OUTPUT -j C17KS",
+		"filter INPUT -j C17KS",
+	}
+)
+
+// Kill switch support on this platform. Neither iptables nor nftables can
+// match packets by the binary that sent them, so individual apps cannot be
+// blocked.
+const (
+	KillSwitchAllSupported  = true
+	KillSwitchAppsSupported = false
+)
+
+func installKillSwitch(blockAll bool, _ []string) error {
+	if !blockAll {
+		return errors.New("blocking individual apps is not supported on Linux")
+	}
+
+	if err := activateIPTables(iptables.ProtocolIPv4, killSwitchRules, killSwitchOnce, killSwitchChains); err != nil {
+		return err
+	}
+	return activateIPTables(iptables.ProtocolIPv6, killSwitchRules, killSwitchOnce, killSwitchChains)
+}
+
+func removeKillSwitch() error {
+	var result *multierror.Error
+	for _, protocol := range []iptables.Protocol{iptables.ProtocolIPv4, iptables.ProtocolIPv6} {
+		// Only remove the kill switch if it exists, in order to not spam errors.
+		tbls, err := iptables.NewWithProtocol(protocol)
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		splittedChain := strings.Split(killSwitchChains[0], " ")
+		exists, err := tbls.ChainExists(splittedChain[0], splittedChain[1])
+		if err != nil || !exists {
+			continue
+		}
+
+		if err := deactivateIPTables(protocol, killSwitchOnce, killSwitchChains); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	return result.ErrorOrNil()
+}
@@ -0,0 +1,152 @@
+package interception
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coreos/go-iptables/iptables"
+)
+
+var privileged bool
+
+func init() {
+	flag.BoolVar(&privileged, "privileged", false, "run tests that require root/admin privileges")
+}
+
+func TestKillSwitchRules(t *testing.T) {
+	// Loopback traffic must be allowed before everything else is dropped.
+	last := killSwitchRules[len(killSwitchRules)-1]
+	if last != "filter C17KS -j DROP" {
+		t.Errorf("expected kill switch to end with dropping, got %q", last)
+	}
+	for _, iface := range []string{"-o lo", "-i lo"} {
+		found := false
+		for _, rule := range killSwitchRules[:len(killSwitchRules)-1] {
+			if strings.Contains(rule, iface) && strings.HasSuffix(rule, "-j RETURN") {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("kill switch does not allow loopback traffic with %s", iface)
+		}
+	}
+
+	// The kill switch must be hooked into both directions.
+	for _, chain := range []string{"filter OUTPUT -j C17KS", "filter INPUT -j C17KS"} {
+		found := false
+		for _, rule := range killSwitchOnce {
+			if rule == chain {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("kill switch is not hooked into %s", chain)
+		}
+	}
+}
+
+func TestKillSwitchRejectsApps(t *testing.T) {
+	if KillSwitchAppsSupported {
+		t.Fatal("blocking apps must not be reported as supported on Linux")
+	}
+	if err := installKillSwitch(false, []string{"/usr/bin/example"}); err == nil {
+		t.Error("installing the kill switch for apps did not fail")
+	}
+}
+
+func TestReapplyKillSwitch(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "killswitch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir) //nolint:errcheck
+
+	// Nothing is installed if the kill switch was never configured.
+	if err := ReapplyKillSwitch(dataDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// The persisted configuration is installed. Blocking apps fails on Linux
+	// before any rules are touched, which shows that the persisted
+	// configuration reached the installation.
+	state := &KillSwitchState{Binaries: []string{"/usr/bin/example"}}
+	if err := saveKillSwitchState(dataDir, state); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadKillSwitchState(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(state, loaded) {
+		t.Errorf("loaded state %+v does not match saved state %+v", loaded, state)
+	}
+	if err := ReapplyKillSwitch(dataDir); err == nil {
+		t.Error("expected the persisted kill switch for apps to be installed and fail")
+	}
+
+	// Turning the kill switch off removes the persisted configuration.
+	if err := saveKillSwitchState(dataDir, &KillSwitchState{}); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err = LoadKillSwitchState(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != nil {
+		t.Errorf("expected no persisted state after turning the kill switch off, got %+v", loaded)
+	}
+	if err := ReapplyKillSwitch(dataDir); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReapplyKillSwitchRules(t *testing.T) {
+	if !privileged {
+		t.Skip("skipping privileged test, active with -privileged argument")
+	}
+
+	dataDir, err := ioutil.TempDir("", "killswitch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir) //nolint:errcheck
+
+	// Reapply the persisted kill switch, as portmaster-start does after a
+	// reboot, and check that the rules are really in place.
+	if err := saveKillSwitchState(dataDir, &KillSwitchState{BlockAll: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReapplyKillSwitch(dataDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := RemoveKillSwitch(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	for _, protocol := range []iptables.Protocol{iptables.ProtocolIPv4, iptables.ProtocolIPv6} {
+		tbls, err := iptables.NewWithProtocol(protocol)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exists, err := tbls.Exists("filter", "OUTPUT", "-j", "C17KS")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Errorf("kill switch is not hooked into OUTPUT for protocol %v", protocol)
+		}
+		exists, err = tbls.Exists("filter", "C17KS", "-j", "DROP")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Errorf("kill switch does not drop traffic for protocol %v", protocol)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package interception
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// Windows Firewall rules are persistent and therefore also stay in place if
+// the Portmaster crashes after the kill switch was installed once.
+
+const killSwitchRuleName = "Portmaster Kill Switch"
+
+// Kill switch support on this platform.
+const (
+	KillSwitchAllSupported  = true
+	KillSwitchAppsSupported = true
+)
+
+func installKillSwitch(blockAll bool, binaries []string) error {
+	// Remove any leftover rules first, so they do not accumulate.
+	_ = removeKillSwitch()
+
+	if blockAll {
+		var result *multierror.Error
+		for _, dir := range []string{"out", "in"} {
+			if err := netsh("add", "rule", "name="+killSwitchRuleName, "dir="+dir, "action=block", "enable=yes"); err != nil {
+				result = multierror.Append(result, err)
+			}
+		}
+		return result.ErrorOrNil()
+	}
+
+	var result *multierror.Error
+	for _, binary := range binaries {
+		if err := netsh("add", "rule", "name="+killSwitchRuleName, "dir=out", "action=block", "enable=yes", "program="+binary); err != nil {
+			result = multierror.Append(result, fmt.Errorf("failed to block %s: %w", binary, err))
+		}
+	}
+	return result.ErrorOrNil()
+}
+
+func removeKillSwitch() error {
+	err := netsh("delete", "rule", "name="+killSwitchRuleName)
+	if err != nil && strings.Contains(err.Error(), "No rules match") {
+		return nil
+	}
+	return err
+}
+
+func netsh(args ...string) error {
+	cmd := exec.Command("netsh", append([]string{"advfirewall", "firewall"}, args...)...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("netsh failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -27,6 +27,7 @@
 	MarkDropAlways   = 1712
 	MarkRerouteNS    = 1799
 	MarkRerouteSPN   = 1717
+	MarkRerouteProxy = 1727
 )
 
 func markToString(mark int) string {
@@ -47,6 +48,8 @@ func markToString(mark int) string {
 		return "RerouteNS"
 	case MarkRerouteSPN:
 		return "RerouteSPN"
+	case MarkRerouteProxy:
+		return "RerouteProxy"
 	}
 	return "unknown"
 }
@@ -165,3 +168,7 @@ func (pkt *packet) RerouteToNameserver() error {
 func (pkt *packet) RerouteToTunnel() error {
 	return pkt.mark(MarkRerouteSPN)
 }
+
+func (pkt *packet) RerouteToProxy() error {
+	return pkt.mark(MarkRerouteProxy)
+}
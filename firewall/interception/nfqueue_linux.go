@@ -75,6 +75,7 @@ func init() {
 		"filter C17 -m mark --mark 1711 -j REJECT --reject-with icmp-host-prohibited",
 		"filter C17 -m mark --mark 1712 -j DROP",
 		"filter C17 -m mark --mark 1717 -j RETURN",
+		"filter C17 -m mark --mark 1727 -j RETURN",
 	}
 
 	v4once = []string{
@@ -85,6 +86,7 @@ func init() {
 		"nat OUTPUT -m mark --mark 1799 -p udp -j DNAT --to 127.0.0.17:53",
 		"nat OUTPUT -m mark --mark 1717 -p tcp -j DNAT --to 127.0.0.17:717",
 		"nat OUTPUT -m mark --mark 1717 -p udp -j DNAT --to 127.0.0.17:717",
+		"nat OUTPUT -m mark --mark 1727 -p tcp -j DNAT --to 127.0.0.17:727",
 		// "nat OUTPUT -m mark --mark 1717 ! -p tcp ! -p udp -j DNAT --to 127.0.0.17",
 	}
 
@@ -112,6 +114,7 @@ func init() {
 		"filter C17 -m mark --mark 1711 -j REJECT --reject-with icmp6-adm-prohibited",
 		"filter C17 -m mark --mark 1712 -j DROP",
 		"filter C17 -m mark --mark 1717 -j RETURN",
+		"filter C17 -m mark --mark 1727 -j RETURN",
 	}
 
 	v6once = []string{
@@ -122,6 +125,7 @@ func init() {
 		"nat OUTPUT -m mark --mark 1799 -p udp -j DNAT --to [::1]:53",
 		"nat OUTPUT -m mark --mark 1717 -p tcp -j DNAT --to [::1]:717",
 		"nat OUTPUT -m mark --mark 1717 -p udp -j DNAT --to [::1]:717",
+		"nat OUTPUT -m mark --mark 1727 -p tcp -j DNAT --to [::1]:727",
 		// "nat OUTPUT -m mark --mark 1717 ! -p tcp ! -p udp -j DNAT --to [::1]",
 	}
 
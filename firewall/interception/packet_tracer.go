@@ -65,3 +65,8 @@ func (p *tracedPacket) RerouteToTunnel() error {
 	defer p.markServed("reroute-tunnel")
 	return p.Packet.RerouteToTunnel()
 }
+
+func (p *tracedPacket) RerouteToProxy() error {
+	defer p.markServed("reroute-proxy")
+	return p.Packet.RerouteToProxy()
+}
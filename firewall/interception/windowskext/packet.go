@@ -3,6 +3,7 @@
 package windowskext
 
 import (
+	"errors"
 	"sync"
 
 	"github.com/tevino/abool"
@@ -123,3 +124,14 @@ func (pkt *Packet) RerouteToTunnel() error {
 	}
 	return nil
 }
+
+// RerouteToProxy is not supported by the kernel extension. The connection
+// (and the current packet) is blocked instead.
+func (pkt *Packet) RerouteToProxy() error {
+	if pkt.verdictSet.SetToIf(false, true) {
+		if err := SetVerdict(pkt, network.VerdictBlock); err != nil {
+			return err
+		}
+	}
+	return errors.New("rerouting to proxy is not supported by the kernel extension")
+}
@@ -0,0 +1,44 @@
+package firewall
+
+import (
+	"context"
+
+	"github.com/safing/portmaster/netenv"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/profile"
+)
+
+// checkInterfaceBinding blocks outgoing connections that use a local
+// interface that the profile does not allow.
+func checkInterfaceBinding(_ context.Context, conn *network.Connection, p *profile.LayeredProfile, _ packet.Packet) bool {
+	if conn.Inbound ||
+		conn.Type != network.IPConnection ||
+		conn.LocalIP == nil ||
+		conn.Entity.IPScope.IsLocalhost() {
+		return false
+	}
+
+	ifaceName, _ := netenv.GetInterfaceByIP(conn.LocalIP)
+	if allowed, _ := p.InterfaceAllowed(ifaceName, conn.LocalIP); allowed {
+		return false
+	}
+
+	if ifaceName == "" {
+		ifaceName = conn.LocalIP.String()
+	}
+	conn.Block("interface "+ifaceName+" is not allowed", profile.CfgOptionAllowedInterfacesKey)
+	return true
+}
+
+func registerInterfaceBindingHook() error {
+	return interceptionModule.RegisterEventHook(
+		"netenv",
+		netenv.NetworkChangedEvent,
+		"re-evaluate interface bindings",
+		func(_ context.Context, _ interface{}) error {
+			profile.ReevaluateInterfaceBindings()
+			return nil
+		},
+	)
+}
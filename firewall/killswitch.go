@@ -0,0 +1,93 @@
+package firewall
+
+import (
+	"context"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/firewall/interception"
+	"github.com/safing/portmaster/profile"
+)
+
+// Kill switch modes.
+const (
+	killSwitchOff  = "off"
+	killSwitchApps = "apps"
+	killSwitchAll  = "all"
+)
+
+// killSwitchPossibleValues returns the kill switch modes that are supported
+// by the platform. Unsupported modes are rejected by the configuration.
+func killSwitchPossibleValues(allSupported, appsSupported bool) []config.PossibleValue {
+	possibleValues := []config.PossibleValue{
+		{
+			Name:        "Off",
+			Value:       killSwitchOff,
+			Description: "Do not block anything when the Portmaster is not running",
+		},
+	}
+	if appsSupported {
+		possibleValues = append(possibleValues, config.PossibleValue{
+			Name:        "Blocked Apps",
+			Value:       killSwitchApps,
+			Description: "Block apps that have Internet access blocked",
+		})
+	}
+	if allSupported {
+		possibleValues = append(possibleValues, config.PossibleValue{
+			Name:        "Everything",
+			Value:       killSwitchAll,
+			Description: "Block all network traffic",
+		})
+	}
+	return possibleValues
+}
+
+// registerKillSwitchHook configures the kill switch now and whenever the
+// configuration changes, so that the persisted kill switch configuration is
+// up to date if the Portmaster crashes.
+func registerKillSwitchHook() error {
+	prepKillSwitch()
+
+	return interceptionModule.RegisterEventHook(
+		"config",
+		"config change",
+		"update kill switch",
+		func(_ context.Context, _ interface{}) error {
+			prepKillSwitch()
+			return nil
+		},
+	)
+}
+
+// prepKillSwitch configures the kill switch of the interception according to
+// the current configuration. It must be called before the interception is
+// stopped.
+func prepKillSwitch() {
+	switch killSwitchMode() {
+	case killSwitchAll:
+		interception.SetKillSwitch(true, nil)
+
+	case killSwitchApps:
+		profiles, err := profile.GetLocalProfiles()
+		if err != nil {
+			log.Warningf("filter: failed to get profiles for kill switch: %s", err)
+			interception.SetKillSwitch(false, nil)
+			return
+		}
+
+		var binaries []string
+		for _, p := range profiles {
+			// Store apps cannot be blocked by their package, and separate
+			// profiles of changed binaries have no binary path of their own.
+			if p.LinkedPath != "" && !profile.IsPackageLinkedPath(p.LinkedPath) &&
+				!profile.IsFingerprintLinkedPath(p.LinkedPath) && p.BlocksInternet() {
+				binaries = append(binaries, p.LinkedPath)
+			}
+		}
+		interception.SetKillSwitch(false, binaries)
+
+	default:
+		interception.SetKillSwitch(false, nil)
+	}
+}
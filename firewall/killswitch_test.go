@@ -0,0 +1,51 @@
+package firewall
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/safing/portbase/config"
+)
+
+func TestKillSwitchPossibleValues(t *testing.T) {
+	for _, test := range []struct {
+		allSupported  bool
+		appsSupported bool
+		expected      []string
+	}{
+		{true, true, []string{killSwitchOff, killSwitchApps, killSwitchAll}},
+		{true, false, []string{killSwitchOff, killSwitchAll}},
+		{false, false, []string{killSwitchOff}},
+	} {
+		var values []string
+		for _, possibleValue := range killSwitchPossibleValues(test.allSupported, test.appsSupported) {
+			values = append(values, possibleValue.Value.(string))
+		}
+		if !reflect.DeepEqual(values, test.expected) {
+			t.Errorf("all=%v apps=%v: expected %v, got %v", test.allSupported, test.appsSupported, test.expected, values)
+		}
+	}
+}
+
+func TestKillSwitchRejectsUnsupportedMode(t *testing.T) {
+	// Register a copy of the option with the possible values of a platform
+	// that cannot block individual apps.
+	err := config.Register(&config.Option{
+		Name:           "Kill Switch Test",
+		Key:            "test/killSwitch",
+		Description:    "Test",
+		OptType:        config.OptTypeString,
+		DefaultValue:   killSwitchOff,
+		PossibleValues: killSwitchPossibleValues(true, false),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := config.SetConfigOption("test/killSwitch", killSwitchAll); err != nil {
+		t.Errorf("supported mode was rejected: %s", err)
+	}
+	if err := config.SetConfigOption("test/killSwitch", killSwitchApps); err == nil {
+		t.Error("unsupported mode was accepted")
+	}
+}
@@ -5,8 +5,10 @@
 	"fmt"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/safing/portmaster/detection/dga"
+	"github.com/safing/portmaster/intel/classification"
 	"github.com/safing/portmaster/netenv"
 	"golang.org/x/net/publicsuffix"
 
@@ -18,6 +20,8 @@
 	"github.com/safing/portmaster/process"
 	"github.com/safing/portmaster/profile"
 	"github.com/safing/portmaster/profile/endpoints"
+	"github.com/safing/portmaster/tracing"
+	"github.com/safing/portmaster/updates"
 
 	"github.com/agext/levenshtein"
 )
@@ -42,13 +46,21 @@
 var defaultDeciders = []deciderFn{
 	checkPortmasterConnection,
 	checkSelfCommunication,
+	checkPolicyScripts,
+	checkVerdictPlugins,
+	checkDevicePolicy,
 	checkConnectionType,
 	checkConnectionScope,
+	checkInterfaceBinding,
 	checkEndpointLists,
+	checkPortLearning,
 	checkResolverScope,
 	checkConnectivityDomain,
 	checkBypassPrevention,
 	checkFilterLists,
+	checkTelemetry,
+	checkBackgroundTraffic,
+	checkLocalhostHandling,
 	dropInbound,
 	checkDomainHeuristics,
 	checkAutoPermitRelated,
@@ -64,6 +76,43 @@
 // DecideOnConnection makes a decision about a connection.
 // When called, the connection and profile is already locked.
 func DecideOnConnection(ctx context.Context, conn *network.Connection, pkt packet.Packet) {
+	decideOnConnection(ctx, conn, pkt, true)
+}
+
+// DecideOnConnectionWithoutPrompt is like DecideOnConnection, but leaves the
+// connection undecided instead of prompting the user. It is used to measure
+// the decision process with the live configuration.
+func DecideOnConnectionWithoutPrompt(ctx context.Context, conn *network.Connection) {
+	decideOnConnection(ctx, conn, nil, false)
+}
+
+func decideOnConnection(ctx context.Context, conn *network.Connection, pkt packet.Packet, allowPrompt bool) {
+	ctx, span := tracing.StartSpan(ctx, "verdict")
+	var prompted bool
+	defer func() {
+		span.SetAttribute("verdict", conn.Verdict.String())
+		span.SetAttribute("reason", conn.Reason.Msg)
+		span.End()
+
+		// Record the latency added to new connections, unless the user was
+		// prompted.
+		if pkt != nil && !prompted {
+			tracing.ObserveStage(tracing.StageConnection, conn.Created())
+		}
+
+		// Record the inputs of real decisions for replaying, and watch the
+		// decisions after config changes.
+		if allowPrompt {
+			recordDecision(conn)
+			observeAppConnection(conn)
+		}
+
+		// Notify about blocks, unless the user is being prompted anyway.
+		if allowPrompt && !prompted {
+			notifyAboutBlock(conn)
+		}
+	}()
+
 	// Check if we have a process and profile.
 	layeredProfile := conn.Process().Profile()
 	if layeredProfile == nil {
@@ -118,7 +167,10 @@ func DecideOnConnection(ctx context.Context, conn *network.Connection, pkt packe
 	case profile.DefaultActionPermit:
 		conn.Accept("allowed by default action", profile.CfgOptionDefaultActionKey)
 	case profile.DefaultActionAsk:
-		prompt(ctx, conn, pkt)
+		if allowPrompt {
+			prompted = true
+			prompt(ctx, conn, pkt)
+		}
 	default:
 		conn.Deny("blocked by default action", profile.CfgOptionDefaultActionKey)
 	}
@@ -126,7 +178,9 @@ func DecideOnConnection(ctx context.Context, conn *network.Connection, pkt packe
 
 func runDeciders(ctx context.Context, selectedDeciders []deciderFn, conn *network.Connection, layeredProfile *profile.LayeredProfile, pkt packet.Packet) (done bool, defaultAction uint8) {
 	// Read-lock all the profiles.
+	lockStarted := time.Now()
 	layeredProfile.LockForUsage()
+	tracing.ObserveStage(tracing.StageProfileLock, lockStarted)
 	defer layeredProfile.UnlockForUsage()
 
 	// Go though all deciders, return if one sets an action.
@@ -136,6 +190,12 @@ func runDeciders(ctx context.Context, selectedDeciders []deciderFn, conn *networ
 		}
 	}
 
+	// Prompt for connections to localhost, if configured.
+	if isLocalhostConnection(conn) &&
+		layeredProfile.LocalhostHandling() == profile.LocalhostHandlingAsk {
+		return false, profile.DefaultActionAsk
+	}
+
 	// Return the default action.
 	return false, layeredProfile.DefaultAction()
 }
@@ -152,6 +212,21 @@ func checkPortmasterConnection(ctx context.Context, conn *network.Connection, _
 		return true
 	}
 
+	// Grant incoming connections from LAN peers to the update sharing.
+	if conn.Process().Pid == ownPID &&
+		conn.Inbound &&
+		conn.Entity.IPScope.IsLAN() &&
+		updates.LANSharingActive() {
+		switch {
+		case conn.Entity.Protocol == uint8(packet.TCP) && conn.LocalPort == updates.LANSharingPort,
+			conn.Entity.Protocol == uint8(packet.UDP) && conn.LocalPort == 5353:
+			log.Tracer(ctx).Infof("filter: granting LAN update sharing connection %s", conn)
+			conn.Accept("LAN update sharing by Portmaster", noReasonOptionKey)
+			conn.Internal = true
+			return true
+		}
+	}
+
 	return false
 }
 
@@ -256,9 +331,10 @@ func checkConnectionType(ctx context.Context, conn *network.Connection, p *profi
 
 	case conn.Entity.IPScope.IsGlobal() &&
 		conn.Entity.Domain == "" &&
-		p.BlockP2P():
+		p.BlockP2P() &&
+		!isOverlayConnection(conn):
 
-		// BlockP2P only applies to the Global scope.
+		// BlockP2P only applies to the Global scope, excluding overlay networks.
 		conn.Block("direct connections (P2P) blocked", profile.CfgOptionBlockP2PKey)
 		return true
 
@@ -310,6 +386,16 @@ func checkConnectionScope(_ context.Context, conn *network.Connection, p *profil
 		return false
 	}
 
+	// Overlay networks are handled as their own zone, as they would otherwise
+	// look like the Internet.
+	if isOverlayConnection(conn) {
+		if p.BlockScopeOverlay() {
+			conn.Block("overlay network access blocked", profile.CfgOptionBlockScopeOverlayKey) // Block Outbound / Drop Inbound
+			return true
+		}
+		return false
+	}
+
 	// Check if the network scope is permitted.
 	switch conn.Entity.IPScope {
 	case netutils.Global, netutils.GlobalMulticast:
@@ -335,7 +421,21 @@ func checkConnectionScope(_ context.Context, conn *network.Connection, p *profil
 	return false
 }
 
+// isOverlayConnection returns whether the remote end of the connection is in
+// an overlay network, such as Tailscale or ZeroTier.
+func isOverlayConnection(conn *network.Connection) bool {
+	if conn.Entity.IP == nil {
+		return false
+	}
+	_, ok := netenv.IsOverlayIP(conn.Entity.IP)
+	return ok
+}
+
 func checkBypassPrevention(_ context.Context, conn *network.Connection, p *profile.LayeredProfile, _ packet.Packet) bool {
+	// Bypass attempts are detected and reported regardless of whether they
+	// are prevented.
+	detectBypassAttempts(conn)
+
 	if p.PreventBypassing() {
 		// check for bypass protection
 		result, reason, reasonCtx := PreventBypassing(conn)
@@ -367,6 +467,19 @@ func checkFilterLists(ctx context.Context, conn *network.Connection, p *profile.
 	return false
 }
 
+func checkTelemetry(ctx context.Context, conn *network.Connection, p *profile.LayeredProfile, _ packet.Packet) bool {
+	if conn.Inbound || conn.Entity.Domain == "" {
+		return false
+	}
+
+	label := conn.Entity.Classify(classification.AppIdentifier(conn.ProcessContext.BinaryPath))
+	if classification.IsTracking(label) && p.BlockTelemetry() {
+		conn.Block("domain is classified as "+label, profile.CfgOptionBlockTelemetryKey)
+		return true
+	}
+	return false
+}
+
 func checkResolverScope(_ context.Context, conn *network.Connection, p *profile.LayeredProfile, _ packet.Packet) bool {
 	// If the IP address was resolved, check the scope of the resolver.
 	switch {
@@ -392,7 +505,7 @@ func checkResolverScope(_ context.Context, conn *network.Connection, p *profile.
 }
 
 func checkDomainHeuristics(ctx context.Context, conn *network.Connection, p *profile.LayeredProfile, _ packet.Packet) bool {
-	if !p.DomainHeuristics() {
+	if !p.DomainHeuristics() || skipOptionalChecks() {
 		return false
 	}
 
@@ -449,6 +562,36 @@ func checkDomainHeuristics(ctx context.Context, conn *network.Connection, p *pro
 	return false
 }
 
+// checkLocalhostHandling applies the localhost handling to outgoing
+// connections to localhost that were not decided by the rules. Prompting is
+// handled together with the default action.
+func checkLocalhostHandling(ctx context.Context, conn *network.Connection, p *profile.LayeredProfile, _ packet.Packet) bool {
+	if !isLocalhostConnection(conn) {
+		return false
+	}
+
+	switch p.LocalhostHandling() {
+	case profile.LocalhostHandlingMonitor:
+		log.Tracer(ctx).Infof("filter: monitored connection to localhost service: %s", conn)
+		conn.Accept("localhost access monitored", profile.CfgOptionLocalhostHandlingKey)
+		return true
+	case profile.LocalhostHandlingBlock:
+		conn.Block("localhost access blocked", profile.CfgOptionLocalhostHandlingKey)
+		return true
+	default:
+		return false
+	}
+}
+
+// isLocalhostConnection returns whether the connection is an outgoing
+// connection to a service on the own device.
+func isLocalhostConnection(conn *network.Connection) bool {
+	return conn.Type == network.IPConnection &&
+		!conn.Inbound &&
+		conn.Entity != nil &&
+		conn.Entity.IPScope.IsLocalhost()
+}
+
 func dropInbound(_ context.Context, conn *network.Connection, _ *profile.LayeredProfile, _ packet.Packet) bool {
 	// implicit default=block for inbound
 	if conn.Inbound {
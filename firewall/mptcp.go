@@ -0,0 +1,97 @@
+package firewall
+
+import (
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/firewall/inspection"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+)
+
+// maxMPTCPInspectionPackets limits the amount of packets inspected per
+// connection, as the keys are exchanged in the TCP handshake.
+const maxMPTCPInspectionPackets = 8
+
+// mptcpInspectorIndex is the key of the multipath TCP inspector state in the
+// inspector data of a connection.
+var mptcpInspectorIndex uint8
+
+type mptcpInspectorState struct {
+	packets int
+}
+
+func init() {
+	mptcpInspectorIndex = uint8(inspection.RegisterInspector(
+		"Multipath TCP",
+		inspectMPTCP,
+		network.VerdictAccept,
+	))
+}
+
+// isMPTCPCapable returns whether the packet opens a multipath TCP connection.
+func isMPTCPCapable(pkt packet.Packet) bool {
+	tcpInfo := pkt.TCPInfo()
+	return tcpInfo != nil && tcpInfo.MPTCPCapable
+}
+
+// inspectMPTCP learns the keys of multipath TCP connections from the TCP
+// handshake, so that additional subflows can be attributed to the connection.
+func inspectMPTCP(conn *network.Connection, pkt packet.Packet) uint8 {
+	state, ok := conn.GetInspectorData()[mptcpInspectorIndex].(*mptcpInspectorState)
+	if !ok {
+		state = &mptcpInspectorState{}
+		conn.GetInspectorData()[mptcpInspectorIndex] = state
+	}
+	state.packets++
+
+	tcpInfo := pkt.TCPInfo()
+	switch {
+	case state.packets == 1 && (tcpInfo == nil || !tcpInfo.MPTCPCapable):
+		// Not a multipath TCP connection.
+		return inspection.STOP_INSPECTING
+	case conn.LearnMPTCPKeys(tcpInfo):
+		log.Tracer(pkt.Ctx()).Trace("filter: learned multipath tcp keys")
+		return inspection.STOP_INSPECTING
+	case state.packets >= maxMPTCPInspectionPackets:
+		return inspection.STOP_INSPECTING
+	default:
+		return inspection.DO_NOTHING
+	}
+}
+
+// adoptMPTCPVerdict applies the verdict of the first subflow of a multipath
+// TCP connection to an additional subflow, as they form one logical
+// connection.
+func adoptMPTCPVerdict(conn *network.Connection, pkt packet.Packet) bool {
+	if conn.MPTCPParentID == "" {
+		return false
+	}
+	parent, ok := network.GetConnection(conn.MPTCPParentID)
+	if !ok {
+		return false
+	}
+
+	parent.Lock()
+	verdict := parent.Verdict
+	reason := parent.Reason
+	parent.Unlock()
+
+	switch {
+	case verdict != network.VerdictAccept && verdict != network.VerdictBlock && verdict != network.VerdictDrop:
+		// Decide on the subflow itself if the first subflow is not decided or
+		// was rerouted.
+		return false
+	case reason.OptionKey == CfgOptionBlockDuringStartupKey:
+		// The first subflow was only held back during startup.
+		return false
+	}
+
+	if !conn.SetVerdict(verdict, reason.Msg, reason.OptionKey, reason.Context) {
+		return false
+	}
+	conn.Reason.Profile = reason.Profile
+	log.Tracer(pkt.Ctx()).Infof("filter: adopted verdict %s of multipath tcp connection %s", verdict, parent.ID)
+
+	conn.StopFirewallHandler()
+	issueVerdict(conn, pkt, 0, true)
+	return true
+}
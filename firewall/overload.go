@@ -0,0 +1,196 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tevino/abool"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/portmaster/firewall/interception"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/resolver"
+	"github.com/safing/portmaster/tracing"
+)
+
+// Overload mitigation policies.
+const (
+	overloadNotify   = "notify"
+	overloadReduce   = "reduce"
+	overloadFailOpen = "fail-open"
+)
+
+const (
+	overloadCheckInterval = 5 * time.Second
+
+	// overloadBacklogThreshold is the fill level of the packet queue at which
+	// the Portmaster is considered to be overloaded.
+	overloadBacklogThreshold = 0.5
+
+	// overloadRecoveryChecks is the amount of consecutive checks without
+	// overload that are required to end the mitigation.
+	overloadRecoveryChecks = 3
+
+	// allowedDestinationTTL defines how long accepted destinations are
+	// remembered for failing open.
+	allowedDestinationTTL = 10 * time.Minute
+
+	overloadFailureID = "filter:overload"
+)
+
+var (
+	overloaded      = abool.New()
+	calmChecks      int
+	overloadStarted time.Time
+
+	allowedDestinations     = make(map[string]*allowedDestination)
+	allowedDestinationsLock sync.Mutex
+)
+
+type allowedDestination struct {
+	revisionCnt uint64
+	expires     time.Time
+}
+
+func startOverloadMitigation() {
+	interceptionModule.NewTask("check for overload", checkOverload).Repeat(overloadCheckInterval)
+}
+
+func checkOverload(_ context.Context, _ *modules.Task) error {
+	cleanAllowedDestinations()
+
+	backlog := float64(len(interception.Packets)) / float64(cap(interception.Packets))
+	slowDecisions := tracing.LatencySLOExceeded()
+
+	// Enter or stay in overload mode.
+	if backlog >= overloadBacklogThreshold || slowDecisions {
+		calmChecks = 0
+		if overloaded.SetToIf(false, true) {
+			overloadStarted = time.Now()
+		}
+		applyOverloadMitigation(backlog, slowDecisions)
+		return nil
+	}
+
+	// Leave overload mode after some time.
+	if !overloaded.IsSet() {
+		return nil
+	}
+	calmChecks++
+	if calmChecks >= overloadRecoveryChecks {
+		overloaded.UnSet()
+		resolver.SetPreferCache(false)
+		interceptionModule.Resolve(overloadFailureID)
+		log.Infof("filter: overload ended after %s, mitigations were lifted", time.Since(overloadStarted).Round(time.Second))
+	}
+	return nil
+}
+
+func applyOverloadMitigation(backlog float64, slowDecisions bool) {
+	policy := overloadMitigation()
+	resolver.SetPreferCache(policy == overloadReduce || policy == overloadFailOpen)
+
+	var causes []string
+	if backlog >= overloadBacklogThreshold {
+		causes = append(causes, fmt.Sprintf("%.0f%% of the packet queue is in use", backlog*100))
+	}
+	if slowDecisions {
+		causes = append(causes, "decisions on new connections are slower than the configured latency SLO")
+	}
+
+	var mitigation string
+	switch policy {
+	case overloadReduce:
+		mitigation = "To reduce load, domain heuristics are skipped and DNS answers are served from the cache when possible."
+	case overloadFailOpen:
+		mitigation = "To reduce load, domain heuristics are skipped, DNS answers are served from the cache when possible, and connections to destinations that were allowed recently for the same app are allowed without further checks."
+	default:
+		mitigation = "No mitigations are active, as configured."
+	}
+
+	msg := fmt.Sprintf("The Portmaster is overloaded: %s. %s", strings.Join(causes, " and "), mitigation)
+	if _, failureID, _ := interceptionModule.FailureStatus(); failureID != overloadFailureID {
+		log.Warningf("filter: %s", msg)
+	}
+	interceptionModule.Warning(overloadFailureID, "Portmaster Overloaded", msg)
+}
+
+// skipOptionalChecks returns whether optional checks should be skipped in
+// order to reduce load.
+func skipOptionalChecks() bool {
+	if !overloaded.IsSet() {
+		return false
+	}
+	policy := overloadMitigation()
+	return policy == overloadReduce || policy == overloadFailOpen
+}
+
+func allowedDestinationKey(conn *network.Connection) string {
+	destination := conn.Entity.Domain
+	if destination == "" {
+		destination = conn.Entity.IP.String()
+	}
+	return fmt.Sprintf(
+		"%s/%s|%d|%s|%d",
+		conn.ProcessContext.Source,
+		conn.ProcessContext.Profile,
+		conn.Entity.Protocol,
+		destination,
+		conn.Entity.Port,
+	)
+}
+
+// recordAllowedDestination remembers the destination of an accepted outgoing
+// connection, so that it may be allowed quickly during overload.
+func recordAllowedDestination(conn *network.Connection) {
+	if conn.Inbound || conn.Verdict != network.VerdictAccept || conn.ProcessContext.Profile == "" {
+		return
+	}
+
+	allowedDestinationsLock.Lock()
+	defer allowedDestinationsLock.Unlock()
+
+	allowedDestinations[allowedDestinationKey(conn)] = &allowedDestination{
+		revisionCnt: conn.ProfileRevisionCounter,
+		expires:     time.Now().Add(allowedDestinationTTL),
+	}
+}
+
+// failOpenOnOverload accepts the connection without further checks if the
+// Portmaster is overloaded, the policy permits failing open and the same
+// destination was recently allowed for the same profile.
+func failOpenOnOverload(conn *network.Connection) bool {
+	if !overloaded.IsSet() || overloadMitigation() != overloadFailOpen || conn.Inbound {
+		return false
+	}
+
+	allowedDestinationsLock.Lock()
+	allowed, ok := allowedDestinations[allowedDestinationKey(conn)]
+	allowedDestinationsLock.Unlock()
+
+	// Only fail open if the profile did not change in the meantime.
+	if !ok ||
+		time.Now().After(allowed.expires) ||
+		allowed.revisionCnt != conn.Process().Profile().RevisionCnt() {
+		return false
+	}
+
+	conn.Accept("allowed during overload, as the destination was allowed recently", CfgOptionOverloadMitigationKey)
+	return true
+}
+
+func cleanAllowedDestinations() {
+	allowedDestinationsLock.Lock()
+	defer allowedDestinationsLock.Unlock()
+
+	now := time.Now()
+	for key, allowed := range allowedDestinations {
+		if now.After(allowed.expires) {
+			delete(allowedDestinations, key)
+		}
+	}
+}
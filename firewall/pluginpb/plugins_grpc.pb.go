@@ -0,0 +1,103 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package pluginpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// VerdictPluginClient is the client API for VerdictPlugin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type VerdictPluginClient interface {
+	// Decide returns the verdict of the plugin on a new connection.
+	Decide(ctx context.Context, in *PluginRequest, opts ...grpc.CallOption) (*PluginResponse, error)
+}
+
+type verdictPluginClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewVerdictPluginClient(cc grpc.ClientConnInterface) VerdictPluginClient {
+	return &verdictPluginClient{cc}
+}
+
+func (c *verdictPluginClient) Decide(ctx context.Context, in *PluginRequest, opts ...grpc.CallOption) (*PluginResponse, error) {
+	out := new(PluginResponse)
+	err := c.cc.Invoke(ctx, "/portmaster.verdict.v1.VerdictPlugin/Decide", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// VerdictPluginServer is the server API for VerdictPlugin service.
+// All implementations must embed UnimplementedVerdictPluginServer
+// for forward compatibility
+type VerdictPluginServer interface {
+	// Decide returns the verdict of the plugin on a new connection.
+	Decide(context.Context, *PluginRequest) (*PluginResponse, error)
+	mustEmbedUnimplementedVerdictPluginServer()
+}
+
+// UnimplementedVerdictPluginServer must be embedded to have forward compatible implementations.
+type UnimplementedVerdictPluginServer struct {
+}
+
+func (UnimplementedVerdictPluginServer) Decide(context.Context, *PluginRequest) (*PluginResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Decide not implemented")
+}
+func (UnimplementedVerdictPluginServer) mustEmbedUnimplementedVerdictPluginServer() {}
+
+// UnsafeVerdictPluginServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to VerdictPluginServer will
+// result in compilation errors.
+type UnsafeVerdictPluginServer interface {
+	mustEmbedUnimplementedVerdictPluginServer()
+}
+
+func RegisterVerdictPluginServer(s grpc.ServiceRegistrar, srv VerdictPluginServer) {
+	s.RegisterService(&VerdictPlugin_ServiceDesc, srv)
+}
+
+func _VerdictPlugin_Decide_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PluginRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VerdictPluginServer).Decide(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/portmaster.verdict.v1.VerdictPlugin/Decide",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VerdictPluginServer).Decide(ctx, req.(*PluginRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// VerdictPlugin_ServiceDesc is the grpc.ServiceDesc for VerdictPlugin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var VerdictPlugin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "portmaster.verdict.v1.VerdictPlugin",
+	HandlerType: (*VerdictPluginServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Decide",
+			Handler:    _VerdictPlugin_Decide_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "plugins.proto",
+}
@@ -0,0 +1,285 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/caches"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/profile"
+)
+
+// Verdict plugins are external gRPC services that are consulted for a verdict
+// on new connections. The service is defined in plugins.proto.
+//
+// Plugins are queried while the connection is held, so their responses are
+// cached per app and destination and identical queries are only sent once.
+// Failures are handled per request: a failed or timed out query only affects
+// the connections waiting for it.
+
+const (
+	// maxPluginResponseSize limits the size of plugin responses.
+	maxPluginResponseSize = 64 * 1024
+
+	// defaultPluginCacheTTL is used if the plugin does not define how long its
+	// response may be cached.
+	defaultPluginCacheTTL = 1 * time.Minute
+	// maxPluginCacheTTL limits how long plugin responses are cached.
+	maxPluginCacheTTL = 24 * time.Hour
+	// defaultPluginCacheEntries limits the amount of cached plugin responses
+	// until the cache manager sizes the cache.
+	defaultPluginCacheEntries = 10000
+)
+
+var (
+	pluginCache        = make(map[string]*pluginCacheEntry)
+	pluginCacheEntries = defaultPluginCacheEntries
+	pluginCacheLock    sync.Mutex
+	pluginQueryGroup   singleflight.Group
+)
+
+func init() {
+	caches.Register("verdict", 1, 256, verdictCache{})
+}
+
+// verdictCache makes the cache of plugin responses manageable by the cache
+// manager.
+type verdictCache struct{}
+
+// Resize sets the maximum amount of cached plugin responses.
+func (verdictCache) Resize(entries int) {
+	pluginCacheLock.Lock()
+	defer pluginCacheLock.Unlock()
+
+	pluginCacheEntries = entries
+	if len(pluginCache) > entries {
+		pluginCache = make(map[string]*pluginCacheEntry)
+	}
+}
+
+// Evict removes all cached plugin responses, as plugins can be queried again.
+func (verdictCache) Evict() {
+	resetVerdictPluginCache()
+}
+
+// PluginRequest describes a new connection to a verdict plugin.
+type PluginRequest struct {
+	ID          string
+	Inbound     bool
+	Domain      string
+	IP          string
+	Protocol    uint8
+	Port        uint16
+	Country     string
+	ASN         uint
+	ProfileID   string
+	ProfileName string
+	ProcessPath string
+	PID         int
+}
+
+// PluginResponse is the answer of a verdict plugin.
+type PluginResponse struct {
+	// Verdict is one of "allow", "block" or "drop". If empty, the plugin does
+	// not decide on the connection.
+	Verdict string
+	// Reason is shown to the user as the reason of the verdict.
+	Reason string
+	// Annotations are added to the connection.
+	Annotations []string
+	// CacheSeconds defines how long the response may be used for connections
+	// of the same app to the same destination.
+	CacheSeconds uint32
+}
+
+type pluginCacheEntry struct {
+	response *PluginResponse
+	expires  time.Time
+}
+
+// cacheKey returns the key for caching responses to the request. It does not
+// include connection specific data, such as the ID or the PID.
+func (request *PluginRequest) cacheKey(pluginURL string) string {
+	return fmt.Sprintf(
+		"%s|%s|%s|%v|%d|%s|%s|%d",
+		pluginURL,
+		request.ProfileID,
+		request.ProcessPath,
+		request.Inbound,
+		request.Protocol,
+		request.Domain,
+		request.IP,
+		request.Port,
+	)
+}
+
+// checkVerdictPlugins consults the configured verdict plugins about the
+// connection.
+func checkVerdictPlugins(ctx context.Context, conn *network.Connection, _ *profile.LayeredProfile, _ packet.Packet) bool {
+	plugins := verdictPlugins()
+	if len(plugins) == 0 {
+		return false
+	}
+
+	request := &PluginRequest{
+		ID:          conn.ID,
+		Inbound:     conn.Inbound,
+		Domain:      strings.TrimSuffix(conn.Entity.Domain, "."),
+		Protocol:    conn.Entity.Protocol,
+		Port:        conn.Entity.Port,
+		ProfileID:   conn.ProcessContext.Source + "/" + conn.ProcessContext.Profile,
+		ProfileName: conn.ProcessContext.ProfileName,
+		ProcessPath: conn.ProcessContext.BinaryPath,
+		PID:         conn.ProcessContext.PID,
+	}
+	if conn.Entity.IP != nil {
+		request.IP = conn.Entity.IP.String()
+		request.Country, _ = conn.Entity.GetCountry(ctx)
+		request.ASN, _ = conn.Entity.GetASN(ctx)
+	}
+
+	for _, pluginURL := range plugins {
+		response, err := queryVerdictPlugin(ctx, pluginURL, request)
+		if err != nil {
+			log.Tracer(ctx).Warningf("filter: verdict plugin %s failed: %s", pluginURL, err)
+			if verdictPluginFailClosed() {
+				conn.Block("verdict plugin failed", CfgOptionVerdictPluginFailClosedKey)
+				return true
+			}
+			continue
+		}
+
+		for _, annotation := range response.Annotations {
+			conn.AddAnnotation(annotation)
+		}
+
+		reason := response.Reason
+		if reason == "" {
+			reason = "decided by verdict plugin"
+		}
+		switch strings.ToLower(response.Verdict) {
+		case "":
+			continue
+		case "allow":
+			conn.Accept(reason, CfgOptionVerdictPluginsKey)
+		case "block":
+			conn.Block(reason, CfgOptionVerdictPluginsKey)
+		case "drop":
+			conn.Drop(reason, CfgOptionVerdictPluginsKey)
+		default:
+			log.Tracer(ctx).Warningf("filter: verdict plugin %s returned unknown verdict %q", pluginURL, response.Verdict)
+			if verdictPluginFailClosed() {
+				conn.Block("verdict plugin failed", CfgOptionVerdictPluginFailClosedKey)
+				return true
+			}
+			continue
+		}
+		return true
+	}
+
+	return false
+}
+
+// queryVerdictPlugin returns the response of the plugin to the request, from
+// the cache if possible.
+func queryVerdictPlugin(ctx context.Context, pluginURL string, request *PluginRequest) (*PluginResponse, error) {
+	key := request.cacheKey(pluginURL)
+	now := time.Now()
+
+	pluginCacheLock.Lock()
+	if entry, ok := pluginCache[key]; ok && now.Before(entry.expires) {
+		pluginCacheLock.Unlock()
+		return entry.response, nil
+	}
+	pluginCacheLock.Unlock()
+
+	// Only send one query for identical requests at the same time. The query
+	// must not depend on the context of the first caller, as other callers may
+	// be waiting for it.
+	resultCh := pluginQueryGroup.DoChan(key, func() (interface{}, error) {
+		queryCtx, cancel := context.WithTimeout(interceptionModule.Ctx, time.Duration(verdictPluginTimeout())*time.Millisecond)
+		defer cancel()
+
+		response, err := callPlugin(queryCtx, pluginURL, request)
+		if err != nil {
+			return nil, err
+		}
+
+		pluginCacheLock.Lock()
+		defer pluginCacheLock.Unlock()
+		cachePluginResponse(key, response)
+		return response, nil
+	})
+
+	select {
+	case result := <-resultCh:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.(*PluginResponse), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// cachePluginResponse adds the response to the cache. The cache lock must be
+// held.
+func cachePluginResponse(key string, response *PluginResponse) {
+	ttl := defaultPluginCacheTTL
+	if response.CacheSeconds > 0 {
+		ttl = time.Duration(response.CacheSeconds) * time.Second
+	}
+	if ttl > maxPluginCacheTTL {
+		ttl = maxPluginCacheTTL
+	}
+	now := time.Now()
+
+	// Remove expired entries when the cache is full, and start over if that
+	// was not enough.
+	if len(pluginCache) >= pluginCacheEntries {
+		for cachedKey, entry := range pluginCache {
+			if now.After(entry.expires) {
+				delete(pluginCache, cachedKey)
+			}
+		}
+		if len(pluginCache) >= pluginCacheEntries {
+			pluginCache = make(map[string]*pluginCacheEntry)
+		}
+	}
+
+	pluginCache[key] = &pluginCacheEntry{
+		response: response,
+		expires:  now.Add(ttl),
+	}
+}
+
+// registerVerdictPluginHook resets the verdict plugin cache and closes the
+// connections to plugins that are no longer configured whenever the
+// configuration changes.
+func registerVerdictPluginHook() error {
+	return interceptionModule.RegisterEventHook(
+		"config",
+		"config change",
+		"reset verdict plugin cache",
+		func(_ context.Context, _ interface{}) error {
+			resetVerdictPluginCache()
+			closeUnusedPluginConns(verdictPlugins())
+			return nil
+		},
+	)
+}
+
+// resetVerdictPluginCache removes all cached plugin responses.
+func resetVerdictPluginCache() {
+	pluginCacheLock.Lock()
+	defer pluginCacheLock.Unlock()
+
+	pluginCache = make(map[string]*pluginCacheEntry)
+}
@@ -0,0 +1,122 @@
+package firewall
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/safing/portbase/utils"
+	"github.com/safing/portmaster/firewall/pluginpb"
+)
+
+// Verdict plugins are called with unary gRPC requests, as defined in
+// plugins.proto. The client stubs in the pluginpb package are generated from
+// it with protoc-gen-go and protoc-gen-go-grpc.
+
+//go:generate protoc --go_out=. --go_opt=module=github.com/safing/portmaster/firewall --go-grpc_out=. --go-grpc_opt=module=github.com/safing/portmaster/firewall plugins.proto
+
+var (
+	pluginConns     = make(map[string]*grpc.ClientConn)
+	pluginConnsLock sync.Mutex
+)
+
+// callPlugin calls the Decide method of the plugin at the given URL.
+func callPlugin(ctx context.Context, pluginURL string, request *PluginRequest) (*PluginResponse, error) {
+	conn, err := getPluginConn(pluginURL)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := pluginpb.NewVerdictPluginClient(conn).Decide(ctx, &pluginpb.PluginRequest{
+		Id:          request.ID,
+		Inbound:     request.Inbound,
+		Domain:      request.Domain,
+		Ip:          request.IP,
+		Protocol:    uint32(request.Protocol),
+		Port:        uint32(request.Port),
+		Country:     request.Country,
+		Asn:         uint32(request.ASN),
+		ProfileId:   request.ProfileID,
+		ProfileName: request.ProfileName,
+		ProcessPath: request.ProcessPath,
+		Pid:         int64(request.PID),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &PluginResponse{
+		Verdict:      response.GetVerdict(),
+		Reason:       response.GetReason(),
+		Annotations:  response.GetAnnotations(),
+		CacheSeconds: response.GetCacheSeconds(),
+	}, nil
+}
+
+// getPluginConn returns the client connection to the plugin at the given URL.
+// Connections are established in the background and re-established after
+// failures by gRPC.
+func getPluginConn(pluginURL string) (*grpc.ClientConn, error) {
+	pluginConnsLock.Lock()
+	defer pluginConnsLock.Unlock()
+
+	if conn, ok := pluginConns[pluginURL]; ok {
+		return conn, nil
+	}
+
+	target, transportOption, err := pluginTarget(pluginURL)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := grpc.Dial(
+		target,
+		transportOption,
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxPluginResponseSize)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to plugin: %w", err)
+	}
+
+	pluginConns[pluginURL] = conn
+	return conn, nil
+}
+
+// pluginTarget returns the gRPC target and the transport option for the given
+// plugin URL.
+func pluginTarget(pluginURL string) (string, grpc.DialOption, error) {
+	parsed, err := url.Parse(pluginURL)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid plugin url: %w", err)
+	}
+
+	switch parsed.Scheme {
+	case "grpc":
+		return parsed.Host, grpc.WithInsecure(), nil
+	case "grpcs":
+		return parsed.Host, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+			MinVersion: tls.VersionTLS12,
+		})), nil
+	default:
+		return "", nil, fmt.Errorf("unsupported plugin url scheme %q", parsed.Scheme)
+	}
+}
+
+// closeUnusedPluginConns closes the connections to all plugins that are not
+// in the given list.
+func closeUnusedPluginConns(configured []string) {
+	pluginConnsLock.Lock()
+	defer pluginConnsLock.Unlock()
+
+	for pluginURL, conn := range pluginConns {
+		if utils.StringInSlice(configured, pluginURL) {
+			continue
+		}
+		_ = conn.Close()
+		delete(pluginConns, pluginURL)
+	}
+}
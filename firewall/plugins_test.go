@@ -0,0 +1,225 @@
+package firewall
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/safing/portmaster/firewall/pluginpb"
+)
+
+// testPluginServer is a gRPC verdict plugin that answers with the result of
+// the decide function.
+type testPluginServer struct {
+	pluginpb.UnimplementedVerdictPluginServer
+
+	decide func(request *pluginpb.PluginRequest) (*pluginpb.PluginResponse, error)
+	calls  int32
+}
+
+func (srv *testPluginServer) Decide(ctx context.Context, request *pluginpb.PluginRequest) (*pluginpb.PluginResponse, error) {
+	atomic.AddInt32(&srv.calls, 1)
+	return srv.decide(request)
+}
+
+func startTestPlugin(t *testing.T, srv *testPluginServer) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	pluginpb.RegisterVerdictPluginServer(server, srv)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	pluginURL := "grpc://" + listener.Addr().String()
+	t.Cleanup(func() {
+		closeUnusedPluginConns(nil)
+	})
+	return pluginURL
+}
+
+func setTestPluginTimeout(t *testing.T, timeout time.Duration) {
+	t.Helper()
+
+	previousVerdictPluginTimeout := verdictPluginTimeout
+	verdictPluginTimeout = func() int64 { return timeout.Milliseconds() }
+	t.Cleanup(func() {
+		verdictPluginTimeout = previousVerdictPluginTimeout
+		resetVerdictPluginCache()
+	})
+	resetVerdictPluginCache()
+}
+
+func TestPluginTarget(t *testing.T) {
+	t.Parallel()
+
+	for pluginURL, expected := range map[string]string{
+		"grpc://127.0.0.1:8080":   "127.0.0.1:8080",
+		"grpcs://example.com:443": "example.com:443",
+		"http://127.0.0.1:8080":   "",
+	} {
+		target, _, err := pluginTarget(pluginURL)
+		switch {
+		case expected == "" && err == nil:
+			t.Errorf("%s: unsupported scheme was accepted", pluginURL)
+		case expected != "" && target != expected:
+			t.Errorf("%s: expected %s, got %s (%v)", pluginURL, expected, target, err)
+		}
+	}
+}
+
+func TestQueryVerdictPlugin(t *testing.T) {
+	setTestPluginTimeout(t, time.Second)
+
+	srv := &testPluginServer{
+		decide: func(request *pluginpb.PluginRequest) (*pluginpb.PluginResponse, error) {
+			// Check that the request was transmitted.
+			if request.GetDomain() != "example.com" || request.GetPort() != 443 || request.GetPid() != 1000 {
+				return nil, status.Error(codes.InvalidArgument, "test failure")
+			}
+			return &pluginpb.PluginResponse{
+				Verdict:      "block",
+				Reason:       "test",
+				Annotations:  []string{"a", "b"},
+				CacheSeconds: 30,
+			}, nil
+		},
+	}
+	pluginURL := startTestPlugin(t, srv)
+	request := &PluginRequest{ID: "1", Domain: "example.com", Protocol: 6, Port: 443, PID: 1000}
+
+	response, err := queryVerdictPlugin(context.Background(), pluginURL, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Verdict != "block" || response.Reason != "test" ||
+		len(response.Annotations) != 2 || response.CacheSeconds != 30 {
+		t.Errorf("unexpected response: %+v", response)
+	}
+
+	// The same destination of the same app must be answered from the cache,
+	// even for a different connection.
+	request2 := *request
+	request2.ID = "2"
+	request2.PID = 1001
+	if _, err := queryVerdictPlugin(context.Background(), pluginURL, &request2); err != nil {
+		t.Fatal(err)
+	}
+	if calls := atomic.LoadInt32(&srv.calls); calls != 1 {
+		t.Errorf("expected 1 call to the plugin, got %d", calls)
+	}
+
+	// Errors reported by the plugin must be returned.
+	request3 := *request
+	request3.Domain = "example.org"
+	_, err = queryVerdictPlugin(context.Background(), pluginURL, &request3)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected grpc status error, got %v", err)
+	}
+	if calls := atomic.LoadInt32(&srv.calls); calls != 2 {
+		t.Errorf("expected 2 calls to the plugin, got %d", calls)
+	}
+}
+
+func TestVerdictPluginFailures(t *testing.T) {
+	setTestPluginTimeout(t, 50*time.Millisecond)
+
+	var slow int32 = 1
+	block := make(chan struct{})
+	defer close(block)
+	srv := &testPluginServer{
+		decide: func(_ *pluginpb.PluginRequest) (*pluginpb.PluginResponse, error) {
+			if atomic.LoadInt32(&slow) == 1 {
+				<-block
+			}
+			return &pluginpb.PluginResponse{Verdict: "allow"}, nil
+		},
+	}
+	pluginURL := startTestPlugin(t, srv)
+	request := &PluginRequest{ID: "1", Domain: "example.com"}
+
+	// A slow plugin must time out.
+	started := time.Now()
+	if _, err := queryVerdictPlugin(context.Background(), pluginURL, request); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("expected slow plugin to time out, got %v", err)
+	}
+	if time.Since(started) > time.Second {
+		t.Errorf("timeout took %s", time.Since(started))
+	}
+
+	// A failure must only affect the failed request: the next request is
+	// sent to the plugin again.
+	atomic.StoreInt32(&slow, 0)
+	request2 := *request
+	request2.Domain = "example.org"
+	response, err := queryVerdictPlugin(context.Background(), pluginURL, &request2)
+	if err != nil {
+		t.Fatalf("expected plugin to be queried after failure, got %v", err)
+	}
+	if response.Verdict != "allow" {
+		t.Errorf("unexpected response: %+v", response)
+	}
+	if calls := atomic.LoadInt32(&srv.calls); calls != 2 {
+		t.Errorf("expected 2 calls to the plugin, got %d", calls)
+	}
+
+	// Failed responses must not be cached.
+	if _, err := queryVerdictPlugin(context.Background(), pluginURL, request); err != nil {
+		t.Errorf("expected plugin to be queried again, got %v", err)
+	}
+}
+
+func TestPluginCacheTTL(t *testing.T) {
+	t.Parallel()
+
+	pluginCacheLock.Lock()
+	defer pluginCacheLock.Unlock()
+
+	for cacheSeconds, expected := range map[uint32]time.Duration{
+		0:      defaultPluginCacheTTL,
+		5:      5 * time.Second,
+		864000: maxPluginCacheTTL,
+	} {
+		key := "ttl-test"
+		cachePluginResponse(key, &PluginResponse{CacheSeconds: cacheSeconds})
+		ttl := time.Until(pluginCache[key].expires)
+		if ttl > expected || ttl < expected-time.Second {
+			t.Errorf("cache seconds %d: expected ttl of %s, got %s", cacheSeconds, expected, ttl)
+		}
+		delete(pluginCache, key)
+	}
+}
+
+func TestVerdictCacheResize(t *testing.T) {
+	defer verdictCache{}.Resize(defaultPluginCacheEntries)
+
+	verdictCache{}.Resize(2)
+	pluginCacheLock.Lock()
+	for _, key := range []string{"a", "b", "c"} {
+		cachePluginResponse(key, &PluginResponse{})
+	}
+	entries := len(pluginCache)
+	pluginCacheLock.Unlock()
+	if entries > 2 {
+		t.Errorf("cache holds %d entries, expected at most 2", entries)
+	}
+
+	verdictCache{}.Evict()
+	pluginCacheLock.Lock()
+	entries = len(pluginCache)
+	pluginCacheLock.Unlock()
+	if entries != 0 {
+		t.Errorf("cache holds %d entries after eviction", entries)
+	}
+}
@@ -0,0 +1,24 @@
+/*
+Package policyscript implements a small, sandboxed rule language for custom
+verdict logic. Scripts cannot access anything but the connection attributes
+they are given, have no loops and are evaluated with step and time limits.
+
+Every line of a script is a rule with an action, an optional text and a
+condition:
+
+	# Block trackers, except for the browser.
+	block "tracker" if domain endswith ".tracker.example" and not profile == "Firefox"
+	annotate "high port" if port > 10000
+	allow if scope == "lan" and (port == 22 or port == 443)
+
+The actions "allow", "block" and "drop" issue a verdict with the text as
+reason, "annotate" adds the text as an annotation to the connection.
+Evaluation stops at the first matching verdict rule.
+
+Available fields are: domain, ip, port, protocol, inbound, scope, country,
+asn, profile, profile_id, process and securitylevel. Strings are compared
+case-insensitively with ==, !=, contains, startswith and endswith. Numbers
+are compared with ==, !=, <, <=, > and >=. Boolean fields may be used
+directly or compared with == and !=.
+*/
+package policyscript
@@ -0,0 +1,187 @@
+package policyscript
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrStepLimit is returned if an evaluation exceeded the step limit.
+	ErrStepLimit = errors.New("script exceeded step limit")
+	// ErrTimeLimit is returned if an evaluation exceeded its deadline.
+	ErrTimeLimit = errors.New("script exceeded time limit")
+)
+
+// Input holds the connection attributes available to scripts. Values must be
+// of type string, float64 or bool, according to the field.
+type Input map[string]interface{}
+
+// Result is the result of a script evaluation.
+type Result struct {
+	// Action is the verdict action of the first matching rule, if any.
+	Action Action
+	// Reason is the reason text of the matching verdict rule.
+	Reason string
+	// Line is the line number of the matching verdict rule.
+	Line int
+	// Annotations holds the annotations of all matching annotate rules.
+	Annotations []string
+}
+
+// Limits defines the resources a single evaluation may use.
+type Limits struct {
+	// MaxSteps is the maximum amount of evaluated conditions.
+	MaxSteps int
+	// MaxDuration is the maximum duration of an evaluation.
+	MaxDuration time.Duration
+}
+
+type evaluator struct {
+	input    Input
+	steps    int
+	maxSteps int
+	deadline time.Time
+}
+
+// step accounts for a single evaluation step and enforces the limits. The
+// clock is only checked every few steps in order to keep evaluation cheap.
+func (e *evaluator) step() error {
+	e.steps++
+	if e.steps > e.maxSteps {
+		return ErrStepLimit
+	}
+	if e.steps%32 == 0 && time.Now().After(e.deadline) {
+		return ErrTimeLimit
+	}
+	return nil
+}
+
+// Evaluate runs the script on the given input. Rules are evaluated in order:
+// annotations of all matching annotate rules are collected and evaluation
+// stops at the first matching verdict rule.
+func (s *Script) Evaluate(input Input, limits Limits) (*Result, error) {
+	e := &evaluator{
+		input:    input,
+		maxSteps: limits.MaxSteps,
+		deadline: time.Now().Add(limits.MaxDuration),
+	}
+	result := &Result{}
+
+	for _, rule := range s.Rules {
+		matched, err := rule.condition.eval(e)
+		if err != nil {
+			return result, err
+		}
+		if !matched {
+			continue
+		}
+
+		if rule.Action == ActionAnnotate {
+			result.Annotations = append(result.Annotations, rule.Text)
+			continue
+		}
+		result.Action = rule.Action
+		result.Reason = rule.Text
+		result.Line = rule.Line
+		return result, nil
+	}
+
+	return result, nil
+}
+
+type node interface {
+	eval(e *evaluator) (bool, error)
+}
+
+type andNode struct {
+	left, right node
+}
+
+func (n *andNode) eval(e *evaluator) (bool, error) {
+	left, err := n.left.eval(e)
+	if err != nil || !left {
+		return false, err
+	}
+	return n.right.eval(e)
+}
+
+type orNode struct {
+	left, right node
+}
+
+func (n *orNode) eval(e *evaluator) (bool, error) {
+	left, err := n.left.eval(e)
+	if err != nil || left {
+		return left, err
+	}
+	return n.right.eval(e)
+}
+
+type notNode struct {
+	n node
+}
+
+func (n *notNode) eval(e *evaluator) (bool, error) {
+	matched, err := n.n.eval(e)
+	return !matched, err
+}
+
+type cmpNode struct {
+	field string
+	op    string
+	value interface{}
+}
+
+func (n *cmpNode) eval(e *evaluator) (bool, error) {
+	if err := e.step(); err != nil {
+		return false, err
+	}
+
+	switch expected := n.value.(type) {
+	case string:
+		actual, _ := e.input[n.field].(string)
+		switch n.op {
+		case "==":
+			return strings.EqualFold(actual, expected), nil
+		case "!=":
+			return !strings.EqualFold(actual, expected), nil
+		case "contains":
+			return strings.Contains(strings.ToLower(actual), strings.ToLower(expected)), nil
+		case "startswith":
+			return strings.HasPrefix(strings.ToLower(actual), strings.ToLower(expected)), nil
+		case "endswith":
+			return strings.HasSuffix(strings.ToLower(actual), strings.ToLower(expected)), nil
+		}
+
+	case float64:
+		actual, ok := e.input[n.field].(float64)
+		if !ok {
+			// Unknown values never match.
+			return false, nil
+		}
+		switch n.op {
+		case "==":
+			return actual == expected, nil
+		case "!=":
+			return actual != expected, nil
+		case "<":
+			return actual < expected, nil
+		case "<=":
+			return actual <= expected, nil
+		case ">":
+			return actual > expected, nil
+		case ">=":
+			return actual >= expected, nil
+		}
+
+	case bool:
+		actual, _ := e.input[n.field].(bool)
+		if n.op == "!=" {
+			return actual != expected, nil
+		}
+		return actual == expected, nil
+	}
+
+	return false, nil
+}
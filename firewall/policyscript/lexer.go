@@ -0,0 +1,110 @@
+package policyscript
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenType uint8
+
+const (
+	tokenIdent tokenType = iota + 1
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenOpenParen
+	tokenCloseParen
+)
+
+type token struct {
+	typ   tokenType
+	value string
+}
+
+func (t token) String() string {
+	if t.typ == tokenString {
+		return strconv.Quote(t.value)
+	}
+	return t.value
+}
+
+// tokenize splits a single line of a script into tokens.
+func tokenize(line string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(line); {
+		c := rune(line[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '#':
+			// Comment until the end of the line.
+			return tokens, nil
+
+		case c == '(':
+			tokens = append(tokens, token{typ: tokenOpenParen, value: "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{typ: tokenCloseParen, value: ")"})
+			i++
+
+		case c == '"':
+			// Find closing quote, respecting escapes.
+			end := i + 1
+			for ; end < len(line); end++ {
+				if line[end] == '\\' {
+					end++
+					continue
+				}
+				if line[end] == '"' {
+					break
+				}
+			}
+			if end >= len(line) {
+				return nil, fmt.Errorf("unterminated string at position %d", i+1)
+			}
+			value, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at position %d: %w", i+1, err)
+			}
+			tokens = append(tokens, token{typ: tokenString, value: value})
+			i = end + 1
+
+		case strings.ContainsRune("=!<>", c):
+			end := i + 1
+			if end < len(line) && line[end] == '=' {
+				end++
+			}
+			op := line[i:end]
+			if op == "=" || op == "!" {
+				return nil, fmt.Errorf("invalid operator %q at position %d", op, i+1)
+			}
+			tokens = append(tokens, token{typ: tokenOperator, value: op})
+			i = end
+
+		case unicode.IsDigit(c) || c == '-':
+			end := i + 1
+			for end < len(line) && (unicode.IsDigit(rune(line[end])) || line[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, token{typ: tokenNumber, value: line[i:end]})
+			i = end
+
+		case unicode.IsLetter(c) || c == '_':
+			end := i + 1
+			for end < len(line) && (unicode.IsLetter(rune(line[end])) || unicode.IsDigit(rune(line[end])) || line[end] == '_') {
+				end++
+			}
+			tokens = append(tokens, token{typ: tokenIdent, value: strings.ToLower(line[i:end])})
+			i = end
+
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", c, i+1)
+		}
+	}
+
+	return tokens, nil
+}
@@ -0,0 +1,304 @@
+package policyscript
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxRules limits the amount of rules a single script may have.
+const maxRules = 1000
+
+// Action is the action of a script rule.
+type Action uint8
+
+// Script Rule Actions
+const (
+	ActionNone Action = iota
+	ActionAllow
+	ActionBlock
+	ActionDrop
+	ActionAnnotate
+)
+
+var actionNames = map[string]Action{
+	"allow":    ActionAllow,
+	"block":    ActionBlock,
+	"drop":     ActionDrop,
+	"annotate": ActionAnnotate,
+}
+
+func (a Action) String() string {
+	for name, action := range actionNames {
+		if action == a {
+			return name
+		}
+	}
+	return "none"
+}
+
+type fieldType uint8
+
+const (
+	fieldString fieldType = iota + 1
+	fieldNumber
+	fieldBool
+)
+
+// fields lists the connection attributes available to scripts.
+var fields = map[string]fieldType{
+	"domain":        fieldString,
+	"ip":            fieldString,
+	"port":          fieldNumber,
+	"protocol":      fieldNumber,
+	"inbound":       fieldBool,
+	"scope":         fieldString,
+	"country":       fieldString,
+	"asn":           fieldNumber,
+	"profile":       fieldString,
+	"profile_id":    fieldString,
+	"process":       fieldString,
+	"securitylevel": fieldNumber,
+}
+
+// Rule is a single rule of a script.
+type Rule struct {
+	Action Action
+	// Text is the reason of a verdict or the annotation to add.
+	Text string
+	// Line is the line number of the rule in the script.
+	Line int
+
+	condition node
+}
+
+// Script is a parsed policy script.
+type Script struct {
+	Name  string
+	Rules []*Rule
+}
+
+// Parse parses the given script source.
+func Parse(name, source string) (*Script, error) {
+	script := &Script{Name: name}
+
+	scanner := bufio.NewScanner(strings.NewReader(source))
+	var lineNumber int
+	for scanner.Scan() {
+		lineNumber++
+
+		tokens, err := tokenize(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		if len(tokens) == 0 {
+			continue
+		}
+
+		rule, err := parseRule(tokens)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		rule.Line = lineNumber
+		script.Rules = append(script.Rules, rule)
+
+		if len(script.Rules) > maxRules {
+			return nil, fmt.Errorf("script exceeds maximum of %d rules", maxRules)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return script, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *parser) next() (token, bool) {
+	t, ok := p.peek()
+	if ok {
+		p.pos++
+	}
+	return t, ok
+}
+
+func (p *parser) nextIsKeyword(keyword string) bool {
+	t, ok := p.peek()
+	return ok && t.typ == tokenIdent && t.value == keyword
+}
+
+func parseRule(tokens []token) (*Rule, error) {
+	p := &parser{tokens: tokens}
+	rule := &Rule{}
+
+	// Action
+	t, _ := p.next()
+	action, ok := actionNames[t.value]
+	if t.typ != tokenIdent || !ok {
+		return nil, fmt.Errorf("expected action (allow, block, drop or annotate), got %s", t)
+	}
+	rule.Action = action
+
+	// Optional reason or annotation.
+	if t, ok := p.peek(); ok && t.typ == tokenString {
+		rule.Text = t.value
+		p.pos++
+	}
+	if rule.Action == ActionAnnotate && rule.Text == "" {
+		return nil, errors.New("annotate requires an annotation text")
+	}
+
+	// Condition
+	if !p.nextIsKeyword("if") {
+		return nil, errors.New(`expected "if" after action`)
+	}
+	p.pos++
+	condition, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := p.peek(); ok {
+		return nil, fmt.Errorf("unexpected %s after condition", t)
+	}
+	rule.condition = condition
+
+	return rule, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.nextIsKeyword("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.nextIsKeyword("and") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	t, ok := p.next()
+	switch {
+	case !ok:
+		return nil, errors.New("unexpected end of condition")
+
+	case t.typ == tokenIdent && t.value == "not":
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{n: n}, nil
+
+	case t.typ == tokenOpenParen:
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := p.next(); !ok || t.typ != tokenCloseParen {
+			return nil, errors.New("missing closing parenthesis")
+		}
+		return n, nil
+
+	case t.typ == tokenIdent:
+		return p.parseComparison(t.value)
+
+	default:
+		return nil, fmt.Errorf("unexpected %s", t)
+	}
+}
+
+func (p *parser) parseComparison(field string) (node, error) {
+	typ, ok := fields[field]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %q", field)
+	}
+
+	// Boolean fields may be used without comparison.
+	opToken, ok := p.peek()
+	if typ == fieldBool && (!ok || opToken.typ == tokenCloseParen ||
+		(opToken.typ == tokenIdent && (opToken.value == "and" || opToken.value == "or"))) {
+		return &cmpNode{field: field, op: "==", value: true}, nil
+	}
+
+	opToken, ok = p.next()
+	if !ok || (opToken.typ != tokenOperator && opToken.typ != tokenIdent) {
+		return nil, fmt.Errorf("expected operator after %s", field)
+	}
+	op := opToken.value
+
+	valueToken, ok := p.next()
+	if !ok {
+		return nil, fmt.Errorf("expected value after %s %s", field, op)
+	}
+
+	switch typ {
+	case fieldString:
+		switch op {
+		case "==", "!=", "contains", "startswith", "endswith":
+		default:
+			return nil, fmt.Errorf("operator %s is not supported for %s", op, field)
+		}
+		if valueToken.typ != tokenString {
+			return nil, fmt.Errorf("%s must be compared to a string", field)
+		}
+		return &cmpNode{field: field, op: op, value: valueToken.value}, nil
+
+	case fieldNumber:
+		switch op {
+		case "==", "!=", "<", "<=", ">", ">=":
+		default:
+			return nil, fmt.Errorf("operator %s is not supported for %s", op, field)
+		}
+		if valueToken.typ != tokenNumber {
+			return nil, fmt.Errorf("%s must be compared to a number", field)
+		}
+		n, err := strconv.ParseFloat(valueToken.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s: %w", valueToken.value, err)
+		}
+		return &cmpNode{field: field, op: op, value: n}, nil
+
+	default: // fieldBool
+		if op != "==" && op != "!=" {
+			return nil, fmt.Errorf("operator %s is not supported for %s", op, field)
+		}
+		if valueToken.typ != tokenIdent || (valueToken.value != "true" && valueToken.value != "false") {
+			return nil, fmt.Errorf("%s must be compared to true or false", field)
+		}
+		return &cmpNode{field: field, op: op, value: valueToken.value == "true"}, nil
+	}
+}
@@ -0,0 +1,83 @@
+package policyscript
+
+import (
+	"testing"
+	"time"
+)
+
+var testLimits = Limits{
+	MaxSteps:    1000,
+	MaxDuration: time.Second,
+}
+
+func TestEvaluate(t *testing.T) {
+	t.Parallel()
+
+	script, err := Parse("test", `
+# comment
+annotate "high port" if port > 10000
+block "tracker" if domain endswith ".tracker.example" and not profile == "Firefox"
+allow if scope == "lan" and (port == 22 or port == 443)
+drop if inbound
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		input       Input
+		action      Action
+		annotations int
+	}{
+		{Input{"domain": "a.tracker.example", "profile": "Curl", "port": float64(443)}, ActionBlock, 0},
+		{Input{"domain": "a.tracker.example", "profile": "firefox", "port": float64(443)}, ActionNone, 0},
+		{Input{"scope": "LAN", "port": float64(22)}, ActionAllow, 0},
+		{Input{"scope": "lan", "port": float64(80)}, ActionNone, 0},
+		{Input{"inbound": true, "port": float64(50000)}, ActionDrop, 1},
+	}
+	for i, tc := range testCases {
+		result, err := script.Evaluate(tc.input, testLimits)
+		if err != nil {
+			t.Fatalf("test case %d: %s", i, err)
+		}
+		if result.Action != tc.action {
+			t.Errorf("test case %d: expected action %s, got %s", i, tc.action, result.Action)
+		}
+		if len(result.Annotations) != tc.annotations {
+			t.Errorf("test case %d: expected %d annotations, got %v", i, tc.annotations, result.Annotations)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, source := range []string{
+		`allow`,
+		`permit if port == 1`,
+		`annotate if port == 1`,
+		`allow if unknown == 1`,
+		`allow if port == "1"`,
+		`allow if domain > "a"`,
+		`allow if (port == 1`,
+		`allow if port == 1 port`,
+		`allow "unterminated if port == 1`,
+	} {
+		if _, err := Parse("test", source); err == nil {
+			t.Errorf("expected error for %q", source)
+		}
+	}
+}
+
+func TestStepLimit(t *testing.T) {
+	t.Parallel()
+
+	script, err := Parse("test", `allow if port == 1 or port == 2 or port == 3`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = script.Evaluate(Input{"port": float64(3)}, Limits{MaxSteps: 2, MaxDuration: time.Second})
+	if err != ErrStepLimit {
+		t.Errorf("expected step limit error, got %v", err)
+	}
+}
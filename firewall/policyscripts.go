@@ -0,0 +1,297 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/dataroot"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/firewall/policyscript"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/profile"
+)
+
+const (
+	policyScriptsDir       = "scripts"
+	policyScriptsExtension = ".policy"
+)
+
+var (
+	policyScriptLimits = policyscript.Limits{
+		MaxSteps:    10000,
+		MaxDuration: 5 * time.Millisecond,
+	}
+
+	policyScripts     []*loadedPolicyScript
+	policyScriptsLock sync.RWMutex
+)
+
+type loadedPolicyScript struct {
+	script *policyscript.Script
+	stats  *PolicyScriptStats
+}
+
+// PolicyScriptStats holds usage metrics of a policy script.
+type PolicyScriptStats struct {
+	sync.Mutex
+
+	Name          string
+	Rules         int
+	Evaluations   uint64
+	Verdicts      uint64
+	Annotations   uint64
+	Errors        uint64
+	LimitExceeded uint64
+	TotalDuration time.Duration
+	MaxDuration   time.Duration
+	LastError     string
+}
+
+// policyScriptContext is set as the reason context for verdicts issued by
+// policy scripts.
+type policyScriptContext struct {
+	Script string
+	Line   int
+}
+
+func startPolicyScripts() error {
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "filter/scripts",
+		Read:      api.PermitAdmin,
+		BelongsTo: interceptionModule,
+		StructFunc: func(ar *api.Request) (i interface{}, err error) {
+			return getPolicyScriptStats(), nil
+		},
+		Name:        "Get Policy Script Stats",
+		Description: "Returns usage metrics of all loaded policy scripts.",
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "filter/scripts/reload",
+		Write:     api.PermitAdmin,
+		BelongsTo: interceptionModule,
+		ActionFunc: func(ar *api.Request) (msg string, err error) {
+			if err := loadPolicyScripts(); err != nil {
+				return "", err
+			}
+			return "policy scripts reloaded", nil
+		},
+		Name:        "Reload Policy Scripts",
+		Description: "Reloads all policy scripts from the scripts directory.",
+	}); err != nil {
+		return err
+	}
+
+	if err := interceptionModule.RegisterEventHook(
+		"config",
+		"config change",
+		"load policy scripts",
+		func(_ context.Context, _ interface{}) error {
+			policyScriptsLock.RLock()
+			loaded := len(policyScripts) > 0
+			policyScriptsLock.RUnlock()
+
+			if enablePolicyScripts() != loaded {
+				return loadPolicyScripts()
+			}
+			return nil
+		},
+	); err != nil {
+		return err
+	}
+
+	if err := loadPolicyScripts(); err != nil {
+		log.Warningf("filter: %s", err)
+	}
+	return nil
+}
+
+// loadPolicyScripts loads all policy scripts from the scripts directory in
+// the data root, if policy scripts are enabled.
+func loadPolicyScripts() error {
+	if !enablePolicyScripts() {
+		policyScriptsLock.Lock()
+		policyScripts = nil
+		policyScriptsLock.Unlock()
+		return nil
+	}
+
+	scriptsDir := dataroot.Root().ChildDir(policyScriptsDir, 0755)
+	if err := scriptsDir.Ensure(); err != nil {
+		return fmt.Errorf("failed to create scripts directory: %w", err)
+	}
+	files, err := filepath.Glob(filepath.Join(scriptsDir.Path, "*"+policyScriptsExtension))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	loaded := make([]*loadedPolicyScript, 0, len(files))
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), policyScriptsExtension)
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read policy script %s: %w", name, err)
+		}
+		script, err := policyscript.Parse(name, string(data))
+		if err != nil {
+			return fmt.Errorf("failed to parse policy script %s: %w", name, err)
+		}
+		loaded = append(loaded, &loadedPolicyScript{
+			script: script,
+			stats: &PolicyScriptStats{
+				Name:  name,
+				Rules: len(script.Rules),
+			},
+		})
+	}
+
+	policyScriptsLock.Lock()
+	policyScripts = loaded
+	policyScriptsLock.Unlock()
+
+	log.Infof("filter: loaded %d policy scripts", len(loaded))
+	return nil
+}
+
+// checkPolicyScripts runs the loaded policy scripts on the connection. Scripts
+// are evaluated in alphabetical order and the first script that issues a
+// verdict decides.
+func checkPolicyScripts(ctx context.Context, conn *network.Connection, p *profile.LayeredProfile, _ packet.Packet) bool {
+	policyScriptsLock.RLock()
+	scripts := policyScripts
+	policyScriptsLock.RUnlock()
+	if len(scripts) == 0 {
+		return false
+	}
+
+	input := policyScriptInput(ctx, conn, p)
+	for _, ps := range scripts {
+		start := time.Now()
+		result, err := ps.script.Evaluate(input, policyScriptLimits)
+		ps.stats.record(result, err, time.Since(start))
+
+		if err != nil {
+			log.Tracer(ctx).Warningf("filter: policy script %s failed: %s", ps.script.Name, err)
+			continue
+		}
+
+		for _, annotation := range result.Annotations {
+			conn.AddAnnotation(annotation)
+		}
+
+		reason := result.Reason
+		if reason == "" {
+			reason = "policy script " + ps.script.Name
+		}
+		reasonCtx := &policyScriptContext{
+			Script: ps.script.Name,
+			Line:   result.Line,
+		}
+		switch result.Action {
+		case policyscript.ActionAllow:
+			conn.AcceptWithContext(reason, CfgOptionPolicyScriptsKey, reasonCtx)
+			return true
+		case policyscript.ActionBlock:
+			conn.BlockWithContext(reason, CfgOptionPolicyScriptsKey, reasonCtx)
+			return true
+		case policyscript.ActionDrop:
+			conn.DropWithContext(reason, CfgOptionPolicyScriptsKey, reasonCtx)
+			return true
+		}
+	}
+
+	return false
+}
+
+func policyScriptInput(ctx context.Context, conn *network.Connection, p *profile.LayeredProfile) policyscript.Input {
+	input := policyscript.Input{
+		"domain":        strings.TrimSuffix(conn.Entity.Domain, "."),
+		"port":          float64(conn.Entity.Port),
+		"protocol":      float64(conn.Entity.Protocol),
+		"inbound":       conn.Inbound,
+		"profile":       conn.ProcessContext.ProfileName,
+		"profile_id":    conn.ProcessContext.Source + "/" + conn.ProcessContext.Profile,
+		"process":       conn.ProcessContext.BinaryPath,
+		"securitylevel": float64(p.SecurityLevel()),
+	}
+
+	if conn.Entity.IP != nil {
+		input["ip"] = conn.Entity.IP.String()
+		switch {
+		case conn.Entity.IPScope.IsLocalhost():
+			input["scope"] = "localhost"
+		case conn.Entity.IPScope.IsLAN():
+			input["scope"] = "lan"
+		case conn.Entity.IPScope.IsGlobal():
+			input["scope"] = "global"
+		}
+		if country, ok := conn.Entity.GetCountry(ctx); ok {
+			input["country"] = country
+		}
+		if asn, ok := conn.Entity.GetASN(ctx); ok {
+			input["asn"] = float64(asn)
+		}
+	}
+
+	return input
+}
+
+func (stats *PolicyScriptStats) record(result *policyscript.Result, err error, duration time.Duration) {
+	stats.Lock()
+	defer stats.Unlock()
+
+	stats.Evaluations++
+	stats.TotalDuration += duration
+	if duration > stats.MaxDuration {
+		stats.MaxDuration = duration
+	}
+
+	switch {
+	case err == policyscript.ErrStepLimit || err == policyscript.ErrTimeLimit:
+		stats.LimitExceeded++
+		stats.LastError = err.Error()
+	case err != nil:
+		stats.Errors++
+		stats.LastError = err.Error()
+	default:
+		if result.Action != policyscript.ActionNone {
+			stats.Verdicts++
+		}
+		stats.Annotations += uint64(len(result.Annotations))
+	}
+}
+
+func getPolicyScriptStats() []*PolicyScriptStats {
+	policyScriptsLock.RLock()
+	defer policyScriptsLock.RUnlock()
+
+	stats := make([]*PolicyScriptStats, 0, len(policyScripts))
+	for _, ps := range policyScripts {
+		ps.stats.Lock()
+		stats = append(stats, &PolicyScriptStats{
+			Name:          ps.stats.Name,
+			Rules:         ps.stats.Rules,
+			Evaluations:   ps.stats.Evaluations,
+			Verdicts:      ps.stats.Verdicts,
+			Annotations:   ps.stats.Annotations,
+			Errors:        ps.stats.Errors,
+			LimitExceeded: ps.stats.LimitExceeded,
+			TotalDuration: ps.stats.TotalDuration,
+			MaxDuration:   ps.stats.MaxDuration,
+			LastError:     ps.stats.LastError,
+		})
+		ps.stats.Unlock()
+	}
+	return stats
+}
@@ -0,0 +1,121 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/notifications"
+	"github.com/safing/portmaster/l10n"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/profile"
+)
+
+const (
+	// notification action IDs
+	allowPort = "allow-port"
+	blockPort = "block-port"
+
+	portPromptIDPrefix = "filter:port-prompt"
+)
+
+// checkPortLearning blocks connections to destination ports that were not
+// learned for the profile and asks the user to confirm them. It runs after the
+// rules, so that it only applies to connections that are not explicitly
+// handled by a rule. Ports are only learned from packets, not when existing
+// connections are re-evaluated.
+func checkPortLearning(ctx context.Context, conn *network.Connection, p *profile.LayeredProfile, pkt packet.Packet) bool {
+	if pkt == nil || conn.Inbound || conn.Type != network.IPConnection || conn.Entity.Port == 0 {
+		return false
+	}
+	switch packet.IPProtocol(conn.Entity.Protocol) {
+	case packet.TCP, packet.UDP, packet.UDPLite:
+	default:
+		return false
+	}
+
+	switch p.LearnPort(conn.Entity.Protocol, conn.Entity.Port) {
+	case profile.PortLearned:
+		log.Tracer(ctx).Infof("filter: learned new destination port %d/%d", conn.Entity.Protocol, conn.Entity.Port)
+	case profile.PortDenied:
+		conn.Deny("port was denied after port learning", profile.CfgOptionPortLearningKey)
+		return true
+	case profile.PortUnknown:
+		if p.SilencePrompts() {
+			conn.Deny("new port after port learning, prompts are silenced", profile.CfgOptionPortLearningKey)
+			return true
+		}
+		promptForPort(ctx, conn, p.LocalProfile())
+		conn.Deny("new port after port learning, please respond to prompt", profile.CfgOptionPortLearningKey)
+		return true
+	}
+
+	return false
+}
+
+// promptForPort asks the user whether the profile may use a new destination
+// port. The response is saved to the learned ports of the profile and applies
+// to the next connection.
+func promptForPort(ctx context.Context, conn *network.Connection, localProfile *profile.Profile) {
+	if localProfile == nil {
+		return
+	}
+
+	portKey := profile.PortKey(packet.IPProtocol(conn.Entity.Protocol), conn.Entity.Port)
+	nID := fmt.Sprintf("%s-%s-%s", portPromptIDPrefix, localProfile.ID, portKey)
+	expires := time.Now().Add(time.Duration(askTimeout()) * time.Second).Unix()
+
+	promptNotificationCreation.Lock()
+	defer promptNotificationCreation.Unlock()
+
+	// Extend an existing prompt instead of creating a new one.
+	if n := notifications.Get(nID); n != nil {
+		n.Lock()
+		active := n.State == notifications.Active
+		n.Unlock()
+		if active {
+			n.Update(expires)
+			return
+		}
+	}
+
+	scopedID := localProfile.ScopedID()
+	n := &notifications.Notification{
+		EventID:      nID,
+		Type:         notifications.Prompt,
+		Title:        l10n.T("New Port"),
+		Category:     l10n.T("Privacy Filter"),
+		ShowOnSystem: askWithSystemNotifications(),
+		Message: l10n.Sprintf(
+			"%s wants to connect to %s on the port %s, which it did not use while learning.",
+			localProfile.Name,
+			conn.Entity.IP.String(),
+			portKey,
+		),
+		Expires: expires,
+		AvailableActions: []*notifications.Action{
+			{
+				ID:   allowPort,
+				Text: l10n.T("Allow"),
+			},
+			{
+				ID:   blockPort,
+				Text: l10n.T("Block"),
+			},
+		},
+	}
+	n.SetActionFunction(func(_ context.Context, n *notifications.Notification) error {
+		switch n.SelectedActionID {
+		case allowPort:
+			return profile.SetLearnedPort(scopedID, portKey, true)
+		case blockPort:
+			return profile.SetLearnedPort(scopedID, portKey, false)
+		}
+		return nil
+	})
+
+	n.Save()
+	log.Tracer(ctx).Debugf("filter: sent port prompt notification")
+}
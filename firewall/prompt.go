@@ -9,6 +9,7 @@
 	"github.com/safing/portbase/log"
 	"github.com/safing/portbase/notifications"
 	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/l10n"
 	"github.com/safing/portmaster/network"
 	"github.com/safing/portmaster/network/packet"
 	"github.com/safing/portmaster/profile"
@@ -39,6 +40,54 @@
 type promptData struct {
 	Entity  *intel.Entity
 	Profile promptProfile
+	// Recipient is the name of the user that should be prompted. UIs of other
+	// users must not show the prompt. If empty, all UIs show the prompt.
+	// Prompts with a recipient can only be answered by that user via the
+	// prompt response API.
+	Recipient string
+
+	// authorizedAction holds the action that the recipient selected via the
+	// prompt response API.
+	authorizedAction     string
+	authorizedActionLock sync.Mutex
+}
+
+// authorize marks the given action as selected by the recipient.
+func (pd *promptData) authorize(actionID string) {
+	pd.authorizedActionLock.Lock()
+	defer pd.authorizedActionLock.Unlock()
+
+	pd.authorizedAction = actionID
+}
+
+// responseAuthorized returns whether the given selected action may be
+// applied. Responses to prompts without a recipient are always applied.
+func (pd *promptData) responseAuthorized(actionID string) bool {
+	if pd.Recipient == "" {
+		return true
+	}
+
+	pd.authorizedActionLock.Lock()
+	defer pd.authorizedActionLock.Unlock()
+
+	return actionID != "" && pd.authorizedAction == actionID
+}
+
+// promptResponseAuthorized returns whether the given selected action of the
+// prompt notification may be applied.
+func promptResponseAuthorized(n *notifications.Notification, actionID string) bool {
+	n.Lock()
+	pd, ok := n.EventData.(*promptData)
+	n.Unlock()
+	if !ok {
+		return false
+	}
+
+	if !pd.responseAuthorized(actionID) {
+		log.Warningf("filter: ignoring response to prompt %s for user %s, as it was not answered by that user", n.EventID, pd.Recipient)
+		return false
+	}
+	return true
 }
 
 type promptProfile struct {
@@ -48,8 +97,33 @@ type promptProfile struct {
 }
 
 func prompt(ctx context.Context, conn *network.Connection, pkt packet.Packet) {
+	// Handle connections of apps with silenced prompts like connections of
+	// users without UI.
+	if promptsSilenced(conn) {
+		if promptFallbackAction() == promptFallbackPermit {
+			conn.Accept("allowed, as prompts are silenced for this app", profile.CfgOptionSilencePromptsKey)
+		} else {
+			conn.Deny("blocked, as prompts are silenced for this app", profile.CfgOptionSilencePromptsKey)
+		}
+		return
+	}
+
+	// Route the prompt to the UI of the user running the process.
+	recipient, routed := getPromptRecipient(conn.Process().UserName)
+	if routed && recipient == "" {
+		switch promptFallbackAction() {
+		case promptFallbackPermit:
+			conn.Accept("allowed, as the user of the app has no UI to prompt", CfgOptionPromptFallbackActionKey)
+			return
+		case promptFallbackBlock:
+			conn.Deny("blocked, as the user of the app has no UI to prompt", CfgOptionPromptFallbackActionKey)
+			return
+		}
+		log.Tracer(ctx).Debugf("filter: user %s has no UI, prompting all users", conn.Process().UserName)
+	}
+
 	// Create notification.
-	n := createPrompt(ctx, conn, pkt)
+	n := createPrompt(ctx, conn, pkt, recipient)
 	if n == nil {
 		// createPrompt returns nil when no further action should be taken.
 		return
@@ -64,6 +138,10 @@ func prompt(ctx context.Context, conn *network.Connection, pkt packet.Packet) {
 	// wait for response/timeout
 	select {
 	case promptResponse := <-n.Response():
+		if !promptResponseAuthorized(n, promptResponse) {
+			conn.Deny("blocked, as the prompt was answered by another user", profile.CfgOptionEndpointsKey)
+			return
+		}
 		switch promptResponse {
 		case allowDomainAll, allowDomainDistinct, allowIP, allowServingIP:
 			conn.Accept("allowed via prompt", profile.CfgOptionEndpointsKey)
@@ -81,11 +159,24 @@ func prompt(ctx context.Context, conn *network.Connection, pkt packet.Packet) {
 	}
 }
 
+// promptsSilenced returns whether prompts are silenced for the profile of the
+// connection.
+func promptsSilenced(conn *network.Connection) bool {
+	layeredProfile := conn.Process().Profile()
+	if layeredProfile == nil {
+		return false
+	}
+
+	layeredProfile.LockForUsage()
+	defer layeredProfile.UnlockForUsage()
+	return layeredProfile.SilencePrompts()
+}
+
 // promptIDPrefix is an identifier for privacy filter prompts. This is also use
 // in the UI, so don't change!
 const promptIDPrefix = "filter:prompt"
 
-func createPrompt(ctx context.Context, conn *network.Connection, pkt packet.Packet) (n *notifications.Notification) {
+func createPrompt(ctx context.Context, conn *network.Connection, pkt packet.Packet, recipient string) (n *notifications.Notification) {
 	expires := time.Now().Add(time.Duration(askTimeout()) * time.Second).Unix()
 
 	// Get local profile.
@@ -121,6 +212,11 @@ func createPrompt(ctx context.Context, conn *network.Connection, pkt packet.Pack
 		)
 	}
 
+	// Prompt users separately.
+	if recipient != "" {
+		nID += "-" + recipient
+	}
+
 	// Only handle one notification at a time.
 	promptNotificationCreation.Lock()
 	defer promptNotificationCreation.Unlock()
@@ -149,7 +245,7 @@ func createPrompt(ctx context.Context, conn *network.Connection, pkt packet.Pack
 		// action we can perform.
 		// If there already is an action defined, we won't be fast enough to
 		// receive the action with n.Response(), so we take direct action here.
-		if action != "" {
+		if action != "" && promptResponseAuthorized(n, action) {
 			switch action {
 			case allowDomainAll, allowDomainDistinct, allowIP, allowServingIP:
 				conn.Accept("allowed via prompt", profile.CfgOptionEndpointsKey)
@@ -171,8 +267,8 @@ func createPrompt(ctx context.Context, conn *network.Connection, pkt packet.Pack
 	n = &notifications.Notification{
 		EventID:      nID,
 		Type:         notifications.Prompt,
-		Title:        "Connection Prompt",
-		Category:     "Privacy Filter",
+		Title:        l10n.T("Connection Prompt"),
+		Category:     l10n.T("Privacy Filter"),
 		ShowOnSystem: askWithSystemNotifications(),
 		EventData: &promptData{
 			Entity: entity,
@@ -181,12 +277,16 @@ func createPrompt(ctx context.Context, conn *network.Connection, pkt packet.Pack
 				ID:         localProfile.ID,
 				LinkedPath: localProfile.LinkedPath,
 			},
+			Recipient: recipient,
 		},
 		Expires: expires,
 	}
 
 	// Set action function.
 	n.SetActionFunction(func(_ context.Context, n *notifications.Notification) error {
+		if !promptResponseAuthorized(n, n.SelectedActionID) {
+			return nil
+		}
 		return saveResponse(
 			localProfile,
 			entity,
@@ -196,43 +296,41 @@ func createPrompt(ctx context.Context, conn *network.Connection, pkt packet.Pack
 
 	// Get name of profile for notification. The profile is read-locked by the firewall handler.
 	profileName := localProfile.Name
+	n.Message = promptMessage(conn, profileName)
 
-	// add message and actions
+	// add actions
 	switch {
 	case conn.Inbound:
-		n.Message = fmt.Sprintf("%s wants to accept connections from %s (%d/%d)", profileName, conn.Entity.IP.String(), conn.Entity.Protocol, conn.Entity.Port)
 		n.AvailableActions = []*notifications.Action{
 			{
 				ID:   allowServingIP,
-				Text: "Allow",
+				Text: l10n.T("Allow"),
 			},
 			{
 				ID:   blockServingIP,
-				Text: "Block",
+				Text: l10n.T("Block"),
 			},
 		}
 	case conn.Entity.Domain == "": // direct connection
-		n.Message = fmt.Sprintf("%s wants to connect to %s (%d/%d)", profileName, conn.Entity.IP.String(), conn.Entity.Protocol, conn.Entity.Port)
 		n.AvailableActions = []*notifications.Action{
 			{
 				ID:   allowIP,
-				Text: "Allow",
+				Text: l10n.T("Allow"),
 			},
 			{
 				ID:   blockIP,
-				Text: "Block",
+				Text: l10n.T("Block"),
 			},
 		}
 	default: // connection to domain
-		n.Message = fmt.Sprintf("%s wants to connect to %s", profileName, conn.Entity.Domain)
 		n.AvailableActions = []*notifications.Action{
 			{
 				ID:   allowDomainAll,
-				Text: "Allow",
+				Text: l10n.T("Allow"),
 			},
 			{
 				ID:   blockDomainAll,
-				Text: "Block",
+				Text: l10n.T("Block"),
 			},
 		}
 	}
@@ -243,6 +341,43 @@ func createPrompt(ctx context.Context, conn *network.Connection, pkt packet.Pack
 	return n
 }
 
+// promptMessage returns the message of a prompt for the given connection. If
+// screen reader friendly texts are enabled, the message is more verbose and
+// spells out domains and addresses.
+func promptMessage(conn *network.Connection, profileName string) string {
+	if l10n.AccessibleText() {
+		switch {
+		case conn.Inbound:
+			return l10n.Sprintf(
+				"The application %s wants to accept connections from %s.",
+				profileName,
+				l10n.DescribeEndpoint(conn.Entity.IP, conn.Entity.Protocol, conn.Entity.Port),
+			)
+		case conn.Entity.Domain == "":
+			return l10n.Sprintf(
+				"The application %s wants to connect to %s.",
+				profileName,
+				l10n.DescribeEndpoint(conn.Entity.IP, conn.Entity.Protocol, conn.Entity.Port),
+			)
+		default:
+			return l10n.Sprintf(
+				"The application %s wants to connect to the domain %s.",
+				profileName,
+				l10n.SpellDomain(conn.Entity.Domain),
+			)
+		}
+	}
+
+	switch {
+	case conn.Inbound:
+		return l10n.Sprintf("%s wants to accept connections from %s (%d/%d)", profileName, conn.Entity.IP.String(), conn.Entity.Protocol, conn.Entity.Port)
+	case conn.Entity.Domain == "": // direct connection
+		return l10n.Sprintf("%s wants to connect to %s (%d/%d)", profileName, conn.Entity.IP.String(), conn.Entity.Protocol, conn.Entity.Port)
+	default: // connection to domain
+		return l10n.Sprintf("%s wants to connect to %s", profileName, conn.Entity.Domain)
+	}
+}
+
 // promptSavingLock makes sure that only one prompt is saved at a time.
 // Should prompts be persisted in bulk, the next save process might load an
 // outdated profile and save it, losing config data.
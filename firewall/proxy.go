@@ -0,0 +1,152 @@
+package firewall
+
+import (
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/firewall/inspection"
+	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/profile"
+	"github.com/safing/portmaster/profile/endpoints"
+)
+
+// maxProxyInspectionPackets limits the amount of packets inspected per
+// connection, as the client may never send any data.
+const maxProxyInspectionPackets = 16
+
+// proxyInspectorIndex is the key of the proxy inspector state in the inspector
+// data of a connection.
+var proxyInspectorIndex uint8
+
+type proxyInspectorState struct {
+	detector inspection.ProxyDetector
+	packets  int
+	disabled bool
+}
+
+func init() {
+	proxyInspectorIndex = uint8(inspection.RegisterInspector(
+		"Proxy Detection",
+		inspectProxy,
+		network.VerdictAccept,
+	))
+}
+
+// proxyDestinationContext is set as the reason context for verdicts on the
+// real destination of proxied connections.
+type proxyDestinationContext struct {
+	Protocol string
+	Domain   string `json:",omitempty"`
+	IP       string `json:",omitempty"`
+	Port     uint16
+	Reason   interface{} `json:",omitempty"`
+}
+
+// inspectProxy detects proxy handshakes and checks the real destination the
+// client requested from the proxy.
+func inspectProxy(conn *network.Connection, pkt packet.Packet) uint8 {
+	state, ok := conn.GetInspectorData()[proxyInspectorIndex].(*proxyInspectorState)
+	if !ok {
+		// The connection may only be inspected for other reasons.
+		state = &proxyInspectorState{
+			disabled: conn.Inbound || !proxyInspectionRequired(conn),
+		}
+		conn.GetInspectorData()[proxyInspectorIndex] = state
+	}
+	state.packets++
+	if state.disabled || state.packets > maxProxyInspectionPackets {
+		return inspection.STOP_INSPECTING
+	}
+
+	// Only the client side of the handshake is of interest.
+	if !pkt.IsOutbound() {
+		return inspection.DO_NOTHING
+	}
+
+	if err := pkt.LoadPacketData(); err != nil {
+		log.Tracer(pkt.Ctx()).Debugf("filter: failed to load packet data for proxy detection: %s", err)
+		return inspection.STOP_INSPECTING
+	}
+	request, done := state.detector.Inspect(pkt.Payload())
+	if !done {
+		return inspection.DO_NOTHING
+	}
+	if request == nil {
+		return inspection.STOP_INSPECTING
+	}
+
+	return checkProxyDestination(conn, pkt, request)
+}
+
+// checkProxyDestination blocks the connection if proxy use is blocked, or if
+// the rules or filter lists deny the real destination requested from the
+// proxy.
+func checkProxyDestination(conn *network.Connection, pkt packet.Packet, request *inspection.ProxyRequest) uint8 {
+	ctx := pkt.Ctx()
+
+	// Build the entity of the real destination.
+	entity := &intel.Entity{
+		Protocol: uint8(packet.TCP),
+		Port:     request.Port,
+		Domain:   request.Domain,
+	}
+	entity.SetDstPort(request.Port)
+	reasonCtx := &proxyDestinationContext{
+		Protocol: request.Protocol,
+		Domain:   request.Domain,
+		Port:     request.Port,
+	}
+	if request.IP != nil {
+		entity.SetIP(request.IP)
+		reasonCtx.IP = request.IP.String()
+	}
+
+	conn.AddAnnotation("proxy:" + request.Protocol)
+	conn.ReportBypassAttempt(network.BypassProxy, bypassDestination(conn))
+	log.Tracer(ctx).Infof("filter: detected %s proxy use to %s%s:%d", request.Protocol, request.Domain, reasonCtx.IP, request.Port)
+
+	layeredProfile := conn.Process().Profile()
+	if layeredProfile == nil {
+		return inspection.STOP_INSPECTING
+	}
+	layeredProfile.LockForUsage()
+	defer layeredProfile.UnlockForUsage()
+
+	if layeredProfile.BlockProxies() {
+		conn.BlockWithContext("proxy use blocked", profile.CfgOptionBlockProxiesKey, reasonCtx)
+		return inspection.BLOCK_CONN
+	}
+
+	// Apply the rules and filter lists to the real destination.
+	result, reason := layeredProfile.MatchEndpoint(ctx, entity)
+	if result == endpoints.Denied {
+		reasonCtx.Reason = reason.Context()
+		conn.BlockWithContext("proxied "+reason.String(), profile.CfgOptionEndpointsKey, reasonCtx)
+		return inspection.BLOCK_CONN
+	}
+	if result != endpoints.Permitted {
+		result, reason = layeredProfile.MatchFilterLists(ctx, entity)
+		if result == endpoints.Denied {
+			reasonCtx.Reason = reason.Context()
+			conn.BlockWithContext("proxied "+reason.String(), profile.CfgOptionFilterListsKey, reasonCtx)
+			return inspection.BLOCK_CONN
+		}
+	}
+
+	return inspection.STOP_INSPECTING
+}
+
+// proxyInspectionRequired returns whether the connection needs to be
+// inspected for proxy use: Either proxy use is blocked, or rules or filter
+// lists could deny the real destination. As the detection is complete with
+// the first outgoing payload, other connections leave the inspection early.
+func proxyInspectionRequired(conn *network.Connection) bool {
+	layeredProfile := conn.Process().Profile()
+	if layeredProfile == nil {
+		return false
+	}
+	layeredProfile.LockForUsage()
+	defer layeredProfile.UnlockForUsage()
+
+	return layeredProfile.BlockProxies() || layeredProfile.FiltersEndpoints()
+}
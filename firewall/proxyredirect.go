@@ -0,0 +1,301 @@
+package firewall
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tevino/abool"
+	"golang.org/x/net/proxy"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/profile"
+)
+
+// Connections of apps with a configured proxy are redirected by the
+// interception to a local listener, which forwards them to the original
+// destination through the proxy. The original destination is registered
+// before the redirect, keyed by the local address of the app, as the listener
+// only sees the redirected connection.
+
+const (
+	proxyRedirectPort = 727
+
+	// proxyRequestTTL defines how long a registered redirect waits for the
+	// redirected connection.
+	proxyRequestTTL = 30 * time.Second
+
+	proxyDialTimeout = 30 * time.Second
+)
+
+var (
+	proxyRedirectIPv4 = net.IPv4(127, 0, 0, 17)
+	proxyRedirectIPv6 = net.IPv6loopback
+
+	// proxyRedirectReady is set when the listeners are running.
+	proxyRedirectReady = abool.New()
+
+	proxyRequests     = make(map[string]*proxyRequest)
+	proxyRequestsLock sync.Mutex
+)
+
+type proxyRequest struct {
+	destination string
+	proxyURL    string
+	expires     time.Time
+}
+
+// redirectToProxy redirects the connection to the proxy configured for its
+// app. It returns whether the connection is affected by a proxy.
+func redirectToProxy(ctx context.Context, conn *network.Connection, pkt packet.Packet) bool {
+	if pkt == nil ||
+		!pkt.IsOutbound() ||
+		conn.Verdict != network.VerdictAccept ||
+		conn.Process().Pid == ownPID ||
+		!conn.Entity.IPScope.IsGlobal() {
+		return false
+	}
+	layeredProfile := conn.Process().Profile()
+	if layeredProfile == nil || strings.HasPrefix(layeredProfile.LocalProfile().ID, "_") {
+		return false
+	}
+	proxyURL := layeredProfile.Proxy()
+	if proxyURL == "" {
+		return false
+	}
+
+	switch {
+	case conn.Entity.Protocol != uint8(packet.TCP):
+		conn.Block("only TCP connections can be forced through the proxy", profile.CfgOptionProxyKey)
+		return true
+	case !proxyRedirectReady.IsSet():
+		conn.Block("forcing connections through the proxy is not available", profile.CfgOptionProxyKey)
+		return true
+	}
+
+	info := pkt.Info()
+	key := net.JoinHostPort(info.Src.String(), strconv.Itoa(int(info.SrcPort)))
+	proxyRequestsLock.Lock()
+	proxyRequests[key] = &proxyRequest{
+		destination: net.JoinHostPort(info.Dst.String(), strconv.Itoa(int(info.DstPort))),
+		proxyURL:    proxyURL,
+		expires:     time.Now().Add(proxyRequestTTL),
+	}
+	proxyRequestsLock.Unlock()
+
+	log.Tracer(ctx).Trace("filter: redirecting connection to proxy")
+	conn.Verdict = network.VerdictRerouteToProxy
+	conn.Inspecting = false
+	return true
+}
+
+// takeProxyRequest returns and removes the redirect registered for the given
+// local address of an app.
+func takeProxyRequest(remoteAddr net.Addr) *proxyRequest {
+	tcpAddr, ok := remoteAddr.(*net.TCPAddr)
+	if !ok {
+		return nil
+	}
+	key := net.JoinHostPort(tcpAddr.IP.String(), strconv.Itoa(tcpAddr.Port))
+
+	proxyRequestsLock.Lock()
+	defer proxyRequestsLock.Unlock()
+
+	now := time.Now()
+	for k, request := range proxyRequests {
+		if now.After(request.expires) {
+			delete(proxyRequests, k)
+		}
+	}
+	request, ok := proxyRequests[key]
+	if !ok {
+		return nil
+	}
+	delete(proxyRequests, key)
+	return request
+}
+
+// startProxyRedirect starts the listeners for redirected connections. Only
+// the nfqueue interception supports redirecting to the proxy.
+func startProxyRedirect() {
+	if runtime.GOOS != "linux" {
+		return
+	}
+
+	var started bool
+	for _, ip := range []net.IP{proxyRedirectIPv4, proxyRedirectIPv6} {
+		listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: ip, Port: proxyRedirectPort})
+		if err != nil {
+			log.Warningf("filter: failed to listen for connections redirected to proxy on %s: %s", ip, err)
+			continue
+		}
+		started = true
+
+		interceptionModule.StartServiceWorker("proxy redirect listener", 0, func(ctx context.Context) error {
+			return serveProxyRedirect(ctx, listener)
+		})
+	}
+	if started {
+		proxyRedirectReady.Set()
+	}
+}
+
+func serveProxyRedirect(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		interceptionModule.StartWorker("proxy redirect", func(ctx context.Context) error {
+			handleProxyRedirect(ctx, conn)
+			return nil
+		})
+	}
+}
+
+func handleProxyRedirect(ctx context.Context, conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	request := takeProxyRequest(conn.RemoteAddr())
+	if request == nil {
+		log.Warningf("filter: dropping unexpected connection from %s to proxy redirect", conn.RemoteAddr())
+		return
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, proxyDialTimeout)
+	upstream, err := dialThroughProxy(dialCtx, request.proxyURL, request.destination)
+	cancel()
+	if err != nil {
+		log.Warningf("filter: failed to connect to %s through proxy: %s", request.destination, err)
+		return
+	}
+	defer func() {
+		_ = upstream.Close()
+	}()
+
+	// Copy in both directions until one side is done.
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(upstream, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(conn, upstream)
+		done <- struct{}{}
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// dialThroughProxy connects to the destination through the given SOCKS5 or
+// HTTP proxy.
+func dialThroughProxy(ctx context.Context, proxyURL, destination string) (net.Conn, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy: %w", err)
+	}
+	dialer := &net.Dialer{}
+
+	switch u.Scheme {
+	case "socks5":
+		socksDialer, err := proxy.FromURL(u, dialer)
+		if err != nil {
+			return nil, err
+		}
+		if contextDialer, ok := socksDialer.(proxy.ContextDialer); ok {
+			return contextDialer.DialContext(ctx, "tcp", destination)
+		}
+		return socksDialer.Dial("tcp", destination)
+
+	case "http":
+		return dialHTTPConnect(ctx, dialer, u, destination)
+
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+}
+
+// dialHTTPConnect connects to the destination with an HTTP CONNECT request.
+func dialHTTPConnect(ctx context.Context, dialer *net.Dialer, u *url.URL, destination string) (net.Conn, error) {
+	proxyAddress := u.Host
+	if u.Port() == "" {
+		proxyAddress = net.JoinHostPort(u.Hostname(), "80")
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddress)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: destination},
+		Host:   destination,
+		Header: make(http.Header),
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, errors.New("proxy refused connection: " + resp.Status)
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	// Keep data that the proxy already sent after its response.
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (bc *bufferedConn) Read(p []byte) (int, error) {
+	return bc.reader.Read(p)
+}
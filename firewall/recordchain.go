@@ -0,0 +1,119 @@
+package firewall
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/safing/portbase/api"
+)
+
+// Decision captures may be chained, so that exported captures can be verified
+// as untampered. Every decision record holds a hash over the hash of the
+// previous record, the record itself and, for the first record of a profile,
+// the profile snapshot. The hash of the last record, the chain head, is logged
+// when the recording is stopped, so that it can be kept out of band and
+// compared with the chain head of an exported capture.
+
+// CaptureVerification holds the result of verifying a chained capture.
+type CaptureVerification struct {
+	Valid     bool
+	Decisions int
+	ChainHead string `json:",omitempty"`
+	Error     string `json:",omitempty"`
+}
+
+func registerRecordingVerifyAPI() error {
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:      "filter/recording/verify",
+		Write:     api.PermitUser,
+		BelongsTo: interceptionModule,
+		StructFunc: func(ar *api.Request) (i interface{}, err error) {
+			capture := &DecisionCapture{}
+			if err := json.Unmarshal(ar.InputData, capture); err != nil {
+				return nil, fmt.Errorf("failed to parse capture: %w", err)
+			}
+
+			verification := &CaptureVerification{
+				Decisions: len(capture.Decisions),
+				ChainHead: capture.ChainHead,
+			}
+			if err := VerifyDecisionCapture(capture); err != nil {
+				verification.Error = err.Error()
+			} else {
+				verification.Valid = true
+			}
+			return verification, nil
+		},
+		Name:        "Verify Recorded Decisions",
+		Description: "Verifies the hash chain of an exported capture of recorded decisions. Compare the returned chain head with the one that was logged when the recording was stopped.",
+	})
+}
+
+// chainGenesis returns the hash that the chain of the capture starts with.
+func chainGenesis(capture *DecisionCapture) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("portmaster decision capture v%d started %d", capture.Version, capture.Started)))
+	return hex.EncodeToString(sum[:])
+}
+
+// chainHash returns the hash of the record, chained to the previous hash. The
+// snapshot must be given for the first record of a profile.
+func chainHash(previous string, record *DecisionRecord, snapshot *ProfileSnapshot) (string, error) {
+	unhashed := *record
+	unhashed.Hash = ""
+	recordData, err := json.Marshal(&unhashed)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	h.Write([]byte(previous))
+	h.Write(recordData)
+	if snapshot != nil {
+		snapshotData, err := json.Marshal(snapshot)
+		if err != nil {
+			return "", err
+		}
+		h.Write(snapshotData)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// VerifyDecisionCapture verifies the hash chain of the given capture.
+func VerifyDecisionCapture(capture *DecisionCapture) error {
+	if !capture.Chained {
+		return errors.New("capture is not chained")
+	}
+
+	previous := chainGenesis(capture)
+	seenProfiles := make(map[string]struct{}, len(capture.Profiles))
+	for i, record := range capture.Decisions {
+		var snapshot *ProfileSnapshot
+		if _, seen := seenProfiles[record.Profile]; !seen {
+			seenProfiles[record.Profile] = struct{}{}
+			snapshot = capture.Profiles[record.Profile]
+			if snapshot == nil {
+				return fmt.Errorf("profile %s of decision %d is missing", record.Profile, i+1)
+			}
+		}
+
+		hash, err := chainHash(previous, record, snapshot)
+		if err != nil {
+			return fmt.Errorf("failed to hash decision %d: %w", i+1, err)
+		}
+		if hash != record.Hash {
+			return fmt.Errorf("decision %d was modified", i+1)
+		}
+		previous = hash
+	}
+
+	if len(seenProfiles) != len(capture.Profiles) {
+		return errors.New("capture contains profiles that were added")
+	}
+	if previous != capture.ChainHead {
+		return errors.New("chain head does not match, decisions were removed")
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+package firewall
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/history"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/profile"
+)
+
+// Decision recording captures the inputs of firewall decisions, so that they
+// can be replayed against a modified configuration. The capture is
+// anonymized: it holds the destinations and the profile configuration, but
+// no information about processes, users or local addresses.
+
+const (
+	// captureFormatVersion is the version of the capture format.
+	captureFormatVersion = 1
+
+	// maxRecordedDecisions limits the amount of decisions in a capture.
+	maxRecordedDecisions = 10000
+)
+
+// Types of recorded decisions.
+const (
+	RecordTypeDNS = "dns"
+	RecordTypeIP  = "ip"
+)
+
+// DecisionCapture holds recorded decisions and the configuration of the
+// involved profiles at the time.
+type DecisionCapture struct {
+	Version int
+	Started int64
+	Ended   int64 `json:",omitempty"`
+
+	// Profiles holds the snapshots of the involved profiles by scoped ID.
+	Profiles map[string]*ProfileSnapshot
+	// Decisions holds the recorded decisions in the order they were made.
+	Decisions []*DecisionRecord
+	// Truncated is set when decisions were dropped because the capture was
+	// full.
+	Truncated bool `json:",omitempty"`
+
+	// Chained is set when the decisions are chained with hashes.
+	Chained bool `json:",omitempty"`
+	// ChainHead holds the hash of the last decision.
+	ChainHead string `json:",omitempty"`
+}
+
+// ProfileSnapshot holds the configuration of a profile.
+type ProfileSnapshot struct {
+	ID   string
+	Name string
+	// Config holds the flattened configuration of the profile.
+	Config map[string]interface{}
+}
+
+// DecisionRecord holds the inputs and result of a single decision.
+type DecisionRecord struct {
+	// Profile is the scoped ID of the profile.
+	Profile  string
+	Type     string
+	Inbound  bool   `json:",omitempty"`
+	Domain   string `json:",omitempty"`
+	IP       string `json:",omitempty"`
+	Protocol uint8  `json:",omitempty"`
+	Port     uint16 `json:",omitempty"`
+
+	Verdict   string
+	Reason    string
+	OptionKey string `json:",omitempty"`
+
+	// Hash holds the chained hash of the record, if the capture is chained.
+	Hash string `json:",omitempty"`
+}
+
+var (
+	recording     *DecisionCapture
+	lastRecording *DecisionCapture
+	recordingLock sync.Mutex
+)
+
+func registerRecordingAPI() error {
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "filter/recording/start",
+		Write:     api.PermitAdmin,
+		BelongsTo: interceptionModule,
+		ActionFunc: func(_ *api.Request) (msg string, err error) {
+			if err := StartRecording(); err != nil {
+				return "", err
+			}
+			return "started recording decisions", nil
+		},
+		Name:        "Start Recording Decisions",
+		Description: "Starts recording the inputs of firewall decisions for replaying them against a modified configuration.",
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "filter/recording/stop",
+		Write:     api.PermitAdmin,
+		BelongsTo: interceptionModule,
+		ActionFunc: func(_ *api.Request) (msg string, err error) {
+			if err := StopRecording(); err != nil {
+				return "", err
+			}
+			return "stopped recording decisions", nil
+		},
+		Name:        "Stop Recording Decisions",
+		Description: "Stops recording the inputs of firewall decisions.",
+	}); err != nil {
+		return err
+	}
+
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:      "filter/recording",
+		Read:      api.PermitAdmin,
+		BelongsTo: interceptionModule,
+		StructFunc: func(_ *api.Request) (i interface{}, err error) {
+			capture := GetRecording()
+			if capture == nil {
+				return nil, errors.New("no decisions were recorded")
+			}
+			return capture, nil
+		},
+		Name:        "Get Recorded Decisions",
+		Description: "Returns the current or last capture of recorded decisions, including the configuration of the involved profiles.",
+	})
+}
+
+// StartRecording starts recording decisions. A previous capture is
+// discarded.
+func StartRecording() error {
+	recordingLock.Lock()
+	defer recordingLock.Unlock()
+
+	if recording != nil {
+		return errors.New("already recording")
+	}
+	if profile.EphemeralSessionActive() {
+		return errors.New("decisions are not recorded in guest mode")
+	}
+	recording = &DecisionCapture{
+		Version:  captureFormatVersion,
+		Started:  time.Now().Unix(),
+		Profiles: make(map[string]*ProfileSnapshot),
+	}
+	if chainRecordings() {
+		recording.Chained = true
+		recording.ChainHead = chainGenesis(recording)
+	}
+	lastRecording = nil
+
+	log.Info("filter: started recording decisions")
+	return nil
+}
+
+// StopRecording stops recording decisions.
+func StopRecording() error {
+	recordingLock.Lock()
+	defer recordingLock.Unlock()
+
+	if recording == nil {
+		return errors.New("not recording")
+	}
+	recording.Ended = time.Now().Unix()
+	lastRecording = recording
+	recording = nil
+
+	log.Infof("filter: stopped recording decisions, recorded %d decisions", len(lastRecording.Decisions))
+	if lastRecording.Chained {
+		log.Infof("filter: chain head of decision recording started at %d: %s", lastRecording.Started, lastRecording.ChainHead)
+	}
+	saveRecordingToHistory(lastRecording)
+	return nil
+}
+
+// GetRecording returns the current or last capture. The returned capture
+// must not be modified.
+func GetRecording() *DecisionCapture {
+	recordingLock.Lock()
+	defer recordingLock.Unlock()
+
+	if recording != nil {
+		// Return a shallow copy, as the current capture is still being
+		// modified.
+		current := *recording
+		current.Profiles = make(map[string]*ProfileSnapshot, len(recording.Profiles))
+		for scopedID, snapshot := range recording.Profiles {
+			current.Profiles[scopedID] = snapshot
+		}
+		current.Decisions = recording.Decisions[:len(recording.Decisions):len(recording.Decisions)]
+		return &current
+	}
+	return lastRecording
+}
+
+// recordDecision records the decision on the connection, if recording is
+// active. Decisions are not recorded in guest mode. The caller must hold the
+// connection lock.
+func recordDecision(conn *network.Connection) {
+	recordingLock.Lock()
+	defer recordingLock.Unlock()
+
+	if recording == nil || conn.Internal || conn.Entity == nil || profile.EphemeralSessionActive() {
+		return
+	}
+	if len(recording.Decisions) >= maxRecordedDecisions {
+		recording.Truncated = true
+		return
+	}
+
+	scopedID := conn.ProcessContext.Source + "/" + conn.ProcessContext.Profile
+	var newSnapshot *ProfileSnapshot
+	if _, ok := recording.Profiles[scopedID]; !ok {
+		newSnapshot = snapshotProfile(conn)
+		recording.Profiles[scopedID] = newSnapshot
+	}
+
+	record := &DecisionRecord{
+		Profile:   scopedID,
+		Type:      RecordTypeIP,
+		Inbound:   conn.Inbound,
+		Domain:    conn.Entity.Domain,
+		Protocol:  conn.Entity.Protocol,
+		Port:      conn.Entity.Port,
+		Verdict:   conn.Verdict.String(),
+		Reason:    conn.Reason.Msg,
+		OptionKey: conn.Reason.OptionKey,
+	}
+	if conn.Type == network.DNSRequest {
+		record.Type = RecordTypeDNS
+	}
+	if conn.Entity.IP != nil {
+		record.IP = conn.Entity.IP.String()
+	}
+	if recording.Chained {
+		hash, err := chainHash(recording.ChainHead, record, newSnapshot)
+		if err != nil {
+			log.Warningf("filter: failed to chain recorded decision: %s", err)
+			return
+		}
+		record.Hash = hash
+		recording.ChainHead = hash
+	}
+	recording.Decisions = append(recording.Decisions, record)
+}
+
+func snapshotProfile(conn *network.Connection) *ProfileSnapshot {
+	snapshot := &ProfileSnapshot{
+		ID:     conn.ProcessContext.Profile,
+		Name:   conn.ProcessContext.ProfileName,
+		Config: make(map[string]interface{}),
+	}
+
+	layeredProfile := conn.Process().Profile()
+	if layeredProfile == nil {
+		return snapshot
+	}
+	localProfile := layeredProfile.LocalProfile()
+	if localProfile == nil {
+		return snapshot
+	}
+
+	localProfile.Lock()
+	defer localProfile.Unlock()
+	snapshot.Config = config.Flatten(localProfile.Config)
+	return snapshot
+}
+
+// RecordingHistoryKind is the history kind of finished decision recordings.
+// They are identified by the unix timestamp of their start.
+const RecordingHistoryKind = "decision-recording"
+
+// saveRecordingToHistory saves the finished capture to the history, so that
+// it survives restarts.
+func saveRecordingToHistory(capture *DecisionCapture) {
+	interceptionModule.StartWorker("save decision recording", func(_ context.Context) error {
+		id := strconv.FormatInt(capture.Started, 10)
+		if err := history.Save(RecordingHistoryKind, id, time.Unix(capture.Ended, 0), capture); err != nil {
+			log.Warningf("filter: failed to save decision recording to history: %s", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,204 @@
+package firewall
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/process"
+	"github.com/safing/portmaster/profile"
+)
+
+// replayDeciders are the deciders that are evaluated when replaying recorded
+// decisions. They only depend on the recorded inputs and the configuration
+// and do not have side effects, such as prompts or learning ports.
+var replayDeciders = []deciderFn{
+	checkConnectionType,
+	checkConnectionScope,
+	checkEndpointLists,
+	checkFilterLists,
+}
+
+// ReplayRequest holds a capture and the configuration changes to replay it
+// against. Configuration is given in the flattened form, eg.
+// {"filter/endpoints": ["- ads.example.com"]}.
+type ReplayRequest struct {
+	Capture *DecisionCapture
+	// Config holds configuration that is applied to all profiles.
+	Config map[string]interface{}
+	// Profiles holds configuration per scoped profile ID. It takes
+	// precedence over Config.
+	Profiles map[string]map[string]interface{}
+}
+
+// ReplayReport reports the decisions that change with the modified
+// configuration.
+type ReplayReport struct {
+	// Decisions is the amount of replayed decisions.
+	Decisions int
+	// Skipped is the amount of decisions that could not be replayed, because
+	// their profile is missing in the capture.
+	Skipped int
+	// Changed is the amount of decisions with a changed verdict.
+	Changed int
+	Diffs   []*ReplayDiff
+}
+
+// ReplayDiff describes a decision that changes with the modified
+// configuration.
+type ReplayDiff struct {
+	Decision *DecisionRecord
+	Before   *ReplayResult
+	After    *ReplayResult
+}
+
+// ReplayResult is the result of replaying a decision.
+type ReplayResult struct {
+	Verdict   string
+	Reason    string
+	OptionKey string `json:",omitempty"`
+}
+
+func registerReplayAPI() error {
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:      "filter/replay",
+		Write:     api.PermitAdmin,
+		BelongsTo: interceptionModule,
+		StructFunc: func(ar *api.Request) (i interface{}, err error) {
+			request := &ReplayRequest{}
+			if err := json.Unmarshal(ar.InputData, request); err != nil {
+				return nil, fmt.Errorf("failed to parse replay request: %w", err)
+			}
+			return Replay(ar.Context(), request)
+		},
+		Name:        "Replay Recorded Decisions",
+		Description: "Re-evaluates recorded decisions with the configuration of the capture and with the given configuration changes, and reports the decisions whose verdict changes. Only the network scope, rules and filter lists are evaluated.",
+		Parameters: []api.Parameter{{
+			Method:      http.MethodPost,
+			Field:       "body",
+			Value:       `{"Capture": {...}, "Config": {"filter/lists": ["TRAC"]}, "Profiles": {"local/<id>": {"filter/endpoints": ["- *"]}}}`,
+			Description: "Supply the capture, as returned by filter/recording, and the configuration changes in the flattened form.",
+		}},
+	})
+}
+
+// Replay re-evaluates the recorded decisions of the capture with the
+// configuration of the capture and with the requested changes applied, and
+// reports the differences.
+func Replay(ctx context.Context, request *ReplayRequest) (*ReplayReport, error) {
+	capture := request.Capture
+	switch {
+	case capture == nil:
+		return nil, errors.New("missing capture")
+	case capture.Version != captureFormatVersion:
+		return nil, fmt.Errorf("unsupported capture format version %d", capture.Version)
+	case len(capture.Decisions) > maxRecordedDecisions:
+		return nil, fmt.Errorf("too many decisions, at most %d can be replayed", maxRecordedDecisions)
+	}
+
+	// Create the processes of the original and modified profiles.
+	type replayProcesses struct {
+		before, after *process.Process
+	}
+	processes := make(map[string]*replayProcesses, len(capture.Profiles))
+	for scopedID, snapshot := range capture.Profiles {
+		modified := make(map[string]interface{}, len(snapshot.Config))
+		for key, value := range snapshot.Config {
+			modified[key] = value
+		}
+		for key, value := range request.Config {
+			modified[key] = value
+		}
+		for key, value := range request.Profiles[scopedID] {
+			modified[key] = value
+		}
+
+		processes[scopedID] = &replayProcesses{
+			before: process.NewSyntheticProcess(profile.NewSyntheticProfile(snapshot.ID, snapshot.Name, snapshot.Config)),
+			after:  process.NewSyntheticProcess(profile.NewSyntheticProfile(snapshot.ID, snapshot.Name, modified)),
+		}
+	}
+
+	report := &ReplayReport{}
+	for i, decision := range capture.Decisions {
+		procs, ok := processes[decision.Profile]
+		if !ok {
+			report.Skipped++
+			continue
+		}
+		report.Decisions++
+
+		before, err := replayDecision(ctx, fmt.Sprintf("replay-%d-before", i), procs.before, decision)
+		if err != nil {
+			return nil, fmt.Errorf("failed to replay decision %d: %w", i, err)
+		}
+		after, err := replayDecision(ctx, fmt.Sprintf("replay-%d-after", i), procs.after, decision)
+		if err != nil {
+			return nil, fmt.Errorf("failed to replay decision %d: %w", i, err)
+		}
+
+		if before.Verdict != after.Verdict {
+			report.Changed++
+			report.Diffs = append(report.Diffs, &ReplayDiff{
+				Decision: decision,
+				Before:   before,
+				After:    after,
+			})
+		}
+	}
+
+	return report, nil
+}
+
+func replayDecision(ctx context.Context, connID string, proc *process.Process, decision *DecisionRecord) (*ReplayResult, error) {
+	var conn *network.Connection
+	switch decision.Type {
+	case RecordTypeDNS:
+		conn = network.NewSyntheticDNSRequest(ctx, connID, proc, decision.Domain)
+	case RecordTypeIP:
+		entity := &intel.Entity{
+			Domain:   decision.Domain,
+			Protocol: decision.Protocol,
+			Port:     decision.Port,
+		}
+		ip := net.ParseIP(decision.IP)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP %q", decision.IP)
+		}
+		entity.SetIP(ip)
+		entity.SetDstPort(decision.Port)
+		conn = network.NewSyntheticConnection(ctx, connID, proc, entity)
+		conn.Inbound = decision.Inbound
+	default:
+		return nil, fmt.Errorf("unknown decision type %q", decision.Type)
+	}
+
+	conn.Lock()
+	defer conn.Unlock()
+
+	layeredProfile := proc.Profile()
+	done, defaultAction := runDeciders(ctx, replayDeciders, conn, layeredProfile, nil)
+	if !done {
+		switch defaultAction {
+		case profile.DefaultActionPermit:
+			conn.Accept("allowed by default action", profile.CfgOptionDefaultActionKey)
+		case profile.DefaultActionAsk:
+			conn.Reason.Msg = "the user would be prompted"
+			conn.Reason.OptionKey = profile.CfgOptionDefaultActionKey
+		default:
+			conn.Deny("blocked by default action", profile.CfgOptionDefaultActionKey)
+		}
+	}
+
+	return &ReplayResult{
+		Verdict:   conn.Verdict.String(),
+		Reason:    conn.Reason.Msg,
+		OptionKey: conn.Reason.OptionKey,
+	}, nil
+}
@@ -0,0 +1,193 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/notifications"
+	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/intel/filterlists"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/profile/endpoints"
+)
+
+// Retro-hunting checks the recently allowed connections against the filter
+// lists after they were updated, in order to find destinations that were
+// allowed before the filter lists knew about them.
+
+const retroHuntNotificationID = "filter:retro-hunt"
+
+var (
+	// retroHuntReported holds the IDs of the connections that were already
+	// reported, so that they are only reported once.
+	retroHuntReported     = make(map[string]struct{})
+	retroHuntReportedLock sync.Mutex
+)
+
+func registerRetroHuntHook() error {
+	return interceptionModule.RegisterEventHook(
+		"filterlists",
+		filterlists.ListsUpdatedEvent,
+		"re-check recent connections",
+		func(ctx context.Context, _ interface{}) error {
+			if !retroHunt() {
+				return nil
+			}
+			runRetroHunt(ctx)
+			return nil
+		},
+	)
+}
+
+// retroHuntCandidate holds the information of a connection that is needed to
+// check it again.
+type retroHuntCandidate struct {
+	conn    *network.Connection
+	id      string
+	app     string
+	entity  *intel.Entity
+	display string
+}
+
+// runRetroHunt checks the recently allowed connections against the filter
+// lists and notifies the user about the ones that are now blocked.
+func runRetroHunt(ctx context.Context) {
+	candidates := getRetroHuntCandidates()
+
+	flagged := make(map[string][]string)
+	seen := make(map[string]struct{}, len(candidates))
+	for _, candidate := range candidates {
+		seen[candidate.id] = struct{}{}
+
+		retroHuntReportedLock.Lock()
+		_, reported := retroHuntReported[candidate.id]
+		retroHuntReportedLock.Unlock()
+		if reported {
+			continue
+		}
+
+		layeredProfile := candidate.conn.Process().Profile()
+		if layeredProfile == nil {
+			continue
+		}
+		layeredProfile.LockForUsage()
+		result, reason := layeredProfile.MatchFilterLists(ctx, candidate.entity)
+		layeredProfile.UnlockForUsage()
+		if result != endpoints.Denied {
+			continue
+		}
+
+		log.Infof("filter: connection %s of %s to %s is now blocked: %s", candidate.id, candidate.app, candidate.display, reason)
+		flagged[candidate.app] = append(flagged[candidate.app], candidate.display)
+
+		retroHuntReportedLock.Lock()
+		retroHuntReported[candidate.id] = struct{}{}
+		retroHuntReportedLock.Unlock()
+	}
+
+	// Forget about connections that are gone.
+	retroHuntReportedLock.Lock()
+	for id := range retroHuntReported {
+		if _, ok := seen[id]; !ok {
+			delete(retroHuntReported, id)
+		}
+	}
+	retroHuntReportedLock.Unlock()
+
+	if len(flagged) > 0 {
+		notifyAboutRetroHunt(flagged)
+	}
+}
+
+func getRetroHuntCandidates() []*retroHuntCandidate {
+	var candidates []*retroHuntCandidate
+	for _, conn := range network.GetAllConnections() {
+		conn.Lock()
+		if !conn.Internal &&
+			conn.Entity != nil &&
+			conn.Verdict == network.VerdictAccept {
+			candidates = append(candidates, newRetroHuntCandidate(conn))
+		}
+		conn.Unlock()
+	}
+	return candidates
+}
+
+// newRetroHuntCandidate copies the connection's entity, so that it can be
+// checked again without the lists that were loaded previously. The caller
+// must hold the connection lock.
+func newRetroHuntCandidate(conn *network.Connection) *retroHuntCandidate {
+	entity := &intel.Entity{
+		Protocol: conn.Entity.Protocol,
+		Port:     conn.Entity.Port,
+		Domain:   conn.Entity.Domain,
+		CNAME:    conn.Entity.CNAME,
+	}
+	if conn.Entity.IP != nil {
+		entity.SetIP(conn.Entity.IP)
+	}
+	entity.SetDstPort(conn.Entity.DstPort())
+
+	display := strings.TrimSuffix(conn.Entity.Domain, ".")
+	if display == "" && conn.Entity.IP != nil {
+		display = conn.Entity.IP.String()
+	}
+
+	return &retroHuntCandidate{
+		conn:    conn,
+		id:      conn.ID,
+		app:     conn.ProcessContext.ProfileName,
+		entity:  entity,
+		display: display,
+	}
+}
+
+func notifyAboutRetroHunt(flagged map[string][]string) {
+	apps := make([]string, 0, len(flagged))
+	for app := range flagged {
+		apps = append(apps, app)
+	}
+	sort.Strings(apps)
+
+	lines := make([]string, 0, len(apps))
+	for _, app := range apps {
+		destinations := uniqueStrings(flagged[app])
+		sort.Strings(destinations)
+		lines = append(lines, fmt.Sprintf("%s: %s", app, strings.Join(destinations, ", ")))
+	}
+
+	notifications.Notify(&notifications.Notification{
+		EventID:  retroHuntNotificationID,
+		Type:     notifications.Warning,
+		Title:    "Allowed Connections Now Blocked by Filter Lists",
+		Category: "Privacy Filter",
+		Message: fmt.Sprintf(
+			"The updated filter lists block destinations that the following apps recently connected to. The connections were allowed, as the filter lists did not include these destinations at the time.\n\n- %s",
+			strings.Join(lines, "\n- "),
+		),
+		ShowOnSystem: true,
+		AvailableActions: []*notifications.Action{
+			{
+				ID:   "ack",
+				Text: "OK",
+			},
+		},
+	})
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]struct{}, len(values))
+	unique := make([]string, 0, len(values))
+	for _, value := range values {
+		if _, ok := seen[value]; ok {
+			continue
+		}
+		seen[value] = struct{}{}
+		unique = append(unique, value)
+	}
+	return unique
+}
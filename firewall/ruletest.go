@@ -0,0 +1,237 @@
+package firewall
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/profile/endpoints"
+)
+
+const (
+	// maxTestSamples limits the amount of samples that can be tested at once.
+	maxTestSamples = 1000
+
+	// maxTestConnections limits the amount of reported recent connections.
+	maxTestConnections = 100
+)
+
+// RuleTestRequest holds a candidate rule and the destinations to test it
+// against.
+type RuleTestRequest struct {
+	Rule    string
+	Samples []*RuleTestSample
+	// Profile optionally limits the recent connections to the profile with
+	// the given scoped ID, eg. "local/<id>".
+	Profile string
+	// Inbound selects whether the rule is tested against recent incoming
+	// connections, as for rules of incoming connections, or outgoing ones.
+	Inbound bool
+}
+
+// RuleTestSample is a destination to test a rule against. At least the
+// domain or the IP must be set.
+type RuleTestSample struct {
+	Domain   string `json:",omitempty"`
+	IP       string `json:",omitempty"`
+	Protocol uint8  `json:",omitempty"`
+	Port     uint16 `json:",omitempty"`
+}
+
+// RuleTestResult reports how a rule matches the samples and recent
+// connections.
+type RuleTestResult struct {
+	// Valid is set if the rule could be parsed.
+	Valid bool
+	// Error holds the parse diagnostics of an invalid rule.
+	Error string `json:",omitempty"`
+	// Rule holds the rule as it is interpreted.
+	Rule string `json:",omitempty"`
+
+	Samples []*RuleTestMatch
+	// Connections holds the recent connections that the rule matches.
+	Connections []*RuleTestConnection
+	// Truncated is set if more recent connections matched than are
+	// reported.
+	Truncated bool `json:",omitempty"`
+}
+
+// RuleTestMatch is the result of matching a rule against a sample.
+type RuleTestMatch struct {
+	Sample *RuleTestSample
+	// Result is one of "NoMatch", "Undeterminable", "Denied" and
+	// "Permitted".
+	Result string
+	Reason string `json:",omitempty"`
+	// Error is set if the sample is invalid.
+	Error string `json:",omitempty"`
+}
+
+// RuleTestConnection describes a recent connection that a rule matches.
+type RuleTestConnection struct {
+	ID       string
+	Profile  string
+	Domain   string `json:",omitempty"`
+	IP       string `json:",omitempty"`
+	Protocol uint8  `json:",omitempty"`
+	Port     uint16 `json:",omitempty"`
+	// Verdict is the verdict the connection received.
+	Verdict string
+	// Result is the result of matching the rule.
+	Result string
+	// Changed is set if the rule would have changed whether the connection
+	// was allowed, if it took precedence over other settings.
+	Changed bool
+}
+
+func registerRuleTestAPI() error {
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:      "filter/test-rule",
+		Write:     api.PermitUser,
+		BelongsTo: interceptionModule,
+		StructFunc: func(ar *api.Request) (i interface{}, err error) {
+			request := &RuleTestRequest{}
+			if err := json.Unmarshal(ar.InputData, request); err != nil {
+				return nil, fmt.Errorf("failed to parse request: %w", err)
+			}
+			return TestRule(ar.Context(), request)
+		},
+		Name:        "Test Rule",
+		Description: "Parses the given rule and reports how it matches the given destinations and which recent connections it matches. The rule is not saved.",
+		Parameters: []api.Parameter{{
+			Method:      http.MethodPost,
+			Field:       "body",
+			Value:       `{"Rule": "- *.example.com TCP/443", "Samples": [{"Domain": "ads.example.com", "IP": "10.0.0.1", "Protocol": 6, "Port": 443}], "Profile": "local/<id>", "Inbound": false}`,
+			Description: "Supply the rule, the destinations to test and optionally the profile to limit the recent connections to.",
+		}},
+	})
+}
+
+// TestRule parses the rule of the request and matches it against the samples
+// and the recent connections. An invalid rule is reported in the result.
+func TestRule(ctx context.Context, request *RuleTestRequest) (*RuleTestResult, error) {
+	if len(request.Samples) > maxTestSamples {
+		return nil, fmt.Errorf("too many samples, at most %d can be tested at once", maxTestSamples)
+	}
+
+	result := &RuleTestResult{}
+	ep, err := endpoints.ParseEndpoint(strings.TrimSpace(request.Rule))
+	if err != nil {
+		result.Error = err.Error()
+		return result, nil
+	}
+	result.Valid = true
+	result.Rule = ep.String()
+
+	for _, sample := range request.Samples {
+		match := &RuleTestMatch{Sample: sample}
+		entity, err := sample.entity()
+		if err != nil {
+			match.Error = err.Error()
+			match.Result = endpoints.NoMatch.String()
+		} else {
+			epResult, reason := ep.Matches(ctx, entity)
+			match.Result = epResult.String()
+			if reason != nil {
+				match.Reason = reason.String()
+			}
+		}
+		result.Samples = append(result.Samples, match)
+	}
+
+	result.Connections, result.Truncated = testRuleOnConnections(ctx, ep, request.Profile, request.Inbound)
+	return result, nil
+}
+
+func (sample *RuleTestSample) entity() (*intel.Entity, error) {
+	if sample.Domain == "" && sample.IP == "" {
+		return nil, errors.New("domain or IP required")
+	}
+
+	entity := &intel.Entity{
+		Protocol: sample.Protocol,
+		Port:     sample.Port,
+	}
+	if sample.Domain != "" {
+		entity.Domain = dns.Fqdn(strings.ToLower(sample.Domain))
+	}
+	if sample.IP != "" {
+		ip := net.ParseIP(sample.IP)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP %q", sample.IP)
+		}
+		entity.SetIP(ip)
+	}
+	entity.SetDstPort(sample.Port)
+	return entity, nil
+}
+
+// testRuleOnConnections returns the recent connections that the rule
+// matches.
+func testRuleOnConnections(ctx context.Context, ep endpoints.Endpoint, scopedProfileID string, inbound bool) (matches []*RuleTestConnection, truncated bool) {
+	matches = make([]*RuleTestConnection, 0)
+	for _, conn := range network.GetAllConnections() {
+		conn.Lock()
+		match := testRuleOnConnection(ctx, ep, conn, scopedProfileID, inbound)
+		conn.Unlock()
+
+		if match == nil {
+			continue
+		}
+		if len(matches) >= maxTestConnections {
+			return matches, true
+		}
+		matches = append(matches, match)
+	}
+	return matches, false
+}
+
+// testRuleOnConnection matches the rule against the connection and returns
+// nil if it does not match. The caller must hold the connection lock.
+func testRuleOnConnection(ctx context.Context, ep endpoints.Endpoint, conn *network.Connection, scopedProfileID string, inbound bool) *RuleTestConnection {
+	profileID := conn.ProcessContext.Source + "/" + conn.ProcessContext.Profile
+	switch {
+	case conn.Internal || conn.Entity == nil:
+		return nil
+	case conn.Inbound != inbound:
+		return nil
+	case scopedProfileID != "" && profileID != scopedProfileID:
+		return nil
+	}
+
+	epResult, _ := ep.Matches(ctx, conn.Entity)
+	if epResult == endpoints.NoMatch {
+		return nil
+	}
+
+	match := &RuleTestConnection{
+		ID:       conn.ID,
+		Profile:  profileID,
+		Domain:   strings.TrimSuffix(conn.Entity.Domain, "."),
+		Protocol: conn.Entity.Protocol,
+		Port:     conn.Entity.Port,
+		Verdict:  conn.Verdict.String(),
+		Result:   epResult.String(),
+	}
+	if conn.Entity.IP != nil {
+		match.IP = conn.Entity.IP.String()
+	}
+
+	blocked := conn.Verdict == network.VerdictBlock || conn.Verdict == network.VerdictDrop
+	switch epResult {
+	case endpoints.Permitted:
+		match.Changed = blocked
+	case endpoints.Denied:
+		match.Changed = !blocked
+	}
+	return match
+}
@@ -0,0 +1,23 @@
+package firewall
+
+import (
+	"context"
+
+	"github.com/safing/portmaster/network"
+)
+
+// registerConnectionSamplingHook applies the configured connection sampling
+// rate now and whenever the configuration changes.
+func registerConnectionSamplingHook() error {
+	network.SetSamplingRate(int(connectionSampling()))
+
+	return interceptionModule.RegisterEventHook(
+		"config",
+		"config change",
+		"update connection sampling rate",
+		func(_ context.Context, _ interface{}) error {
+			network.SetSamplingRate(int(connectionSampling()))
+			return nil
+		},
+	)
+}
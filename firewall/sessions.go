@@ -0,0 +1,205 @@
+package firewall
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/notifications"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/process"
+)
+
+// Fallback actions for prompts of users without a UI.
+const (
+	promptFallbackBlock     = "block"
+	promptFallbackPermit    = "permit"
+	promptFallbackBroadcast = "broadcast"
+)
+
+// uiSessionTTL defines how long a UI session is considered active after it
+// was last announced. UIs are expected to announce themselves every minute.
+const uiSessionTTL = 3 * time.Minute
+
+var (
+	// uiSessions holds the users that have a UI running, mapped to when the
+	// UI was last announced.
+	uiSessions     = make(map[string]time.Time)
+	uiSessionsLock sync.Mutex
+)
+
+// promptResponseDB is used to apply authorized prompt responses like the UI.
+var promptResponseDB = database.NewInterface(&database.Options{
+	Local:    true,
+	Internal: true,
+})
+
+func registerUISessionAPI() error {
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        "ui/session",
+		Write:       api.PermitUser,
+		BelongsTo:   interceptionModule,
+		ActionFunc:  handleUISessionAnnouncement,
+		Name:        "Announce UI Session",
+		Description: "Announces that a UI, such as the App or the Notifier, is running in the desktop session of the requesting user. If UIs of multiple users are active, prompts are only shown to the user of the requesting app. UIs should announce themselves every minute.",
+	}); err != nil {
+		return err
+	}
+
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:        "ui/prompt/respond",
+		Write:       api.PermitUser,
+		BelongsTo:   interceptionModule,
+		ActionFunc:  handlePromptResponse,
+		Name:        "Respond to Prompt",
+		Description: "Selects an action of a connection prompt. Prompts that are routed to a user can only be answered with this endpoint by a UI running in the session of that user.",
+		Parameters: []api.Parameter{{
+			Method:      http.MethodPost,
+			Field:       "id",
+			Value:       "filter:prompt-...",
+			Description: "Specify the event ID of the prompt.",
+		}, {
+			Method:      http.MethodPost,
+			Field:       "action",
+			Value:       "allow-domain-all",
+			Description: "Specify the ID of the selected action.",
+		}},
+	})
+}
+
+func handleUISessionAnnouncement(ar *api.Request) (msg string, err error) {
+	userName, err := getAPIRequestUser(ar.Context(), ar.Request.RemoteAddr)
+	if err != nil {
+		return "", err
+	}
+
+	uiSessionsLock.Lock()
+	_, known := uiSessions[userName]
+	uiSessions[userName] = time.Now()
+	uiSessionsLock.Unlock()
+
+	if !known {
+		log.Infof("filter: registered UI session of user %s", userName)
+	}
+	return fmt.Sprintf("announced UI session of user %s", userName), nil
+}
+
+func handlePromptResponse(ar *api.Request) (msg string, err error) {
+	query := ar.Request.URL.Query()
+	id, actionID := query.Get("id"), query.Get("action")
+	if !strings.HasPrefix(id, promptIDPrefix) || actionID == "" {
+		return "", errors.New("missing or invalid prompt ID or action")
+	}
+
+	n := notifications.Get(id)
+	if n == nil {
+		return "", errors.New("prompt not found")
+	}
+	n.Lock()
+	pd, ok := n.EventData.(*promptData)
+	n.Unlock()
+	if !ok {
+		return "", errors.New("prompt not found")
+	}
+
+	// Only the recipient may answer routed prompts.
+	if pd.Recipient != "" {
+		userName, err := getAPIRequestUser(ar.Context(), ar.Request.RemoteAddr)
+		if err != nil {
+			return "", err
+		}
+		if userName != pd.Recipient {
+			return "", errors.New("prompt belongs to another user")
+		}
+	}
+	pd.authorize(actionID)
+
+	response := &notifications.Notification{
+		EventID:          id,
+		SelectedActionID: actionID,
+	}
+	response.SetKey("notifications:all/" + id)
+	response.UpdateMeta()
+	if err := promptResponseDB.Put(response); err != nil {
+		return "", err
+	}
+	return "responded to prompt", nil
+}
+
+// getAPIRequestUser returns the name of the user running the process that
+// made the API request from the given remote address.
+func getAPIRequestUser(ctx context.Context, remoteAddr string) (string, error) {
+	if !apiPortSet {
+		return "", errors.New("api address is unknown")
+	}
+
+	remoteIP, remotePort, err := parseHostPort(remoteAddr)
+	if err != nil {
+		return "", fmt.Errorf("failed to get remote IP/Port: %w", err)
+	}
+
+	proc, _, err := process.GetProcessByConnection(ctx, &packet.Info{
+		Inbound:  false, // outbound as we are looking for the process of the source address
+		Version:  packet.IPv4,
+		Protocol: packet.TCP,
+		Src:      remoteIP,   // source as in the process we are looking for
+		SrcPort:  remotePort, // source as in the process we are looking for
+		Dst:      apiIP,
+		DstPort:  apiPort,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to identify requesting process: %w", err)
+	}
+	if proc.UserName == "" {
+		return "", errors.New("failed to identify user of requesting process")
+	}
+
+	return proc.UserName, nil
+}
+
+// activeUISessions returns the users that currently have a UI running.
+func activeUISessions() []string {
+	uiSessionsLock.Lock()
+	defer uiSessionsLock.Unlock()
+
+	users := make([]string, 0, len(uiSessions))
+	for userName, lastSeen := range uiSessions {
+		if time.Since(lastSeen) > uiSessionTTL {
+			delete(uiSessions, userName)
+			continue
+		}
+		users = append(users, userName)
+	}
+	sort.Strings(users)
+	return users
+}
+
+// getPromptRecipient returns the user whose UI should show the prompt for a
+// process of the given user. An empty recipient means that the prompt is
+// shown to everyone. If routed is set, but the recipient is empty, the user
+// has no UI running, which is the case for system services and other users
+// without a desktop session. These prompts are handled according to the
+// prompt fallback action, which broadcasts them by default.
+func getPromptRecipient(userName string) (recipient string, routed bool) {
+	// Only route prompts if there are UIs in multiple sessions. Otherwise,
+	// prompts of system services go to the only user.
+	sessions := activeUISessions()
+	if len(sessions) < 2 {
+		return "", false
+	}
+
+	for _, sessionUser := range sessions {
+		if sessionUser == userName {
+			return userName, true
+		}
+	}
+	return "", true
+}
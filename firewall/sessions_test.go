@@ -0,0 +1,48 @@
+package firewall
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetPromptRecipient(t *testing.T) {
+	previousSessions := uiSessions
+	defer func() {
+		uiSessions = previousSessions
+	}()
+
+	// With a single UI, all prompts go to it.
+	uiSessions = map[string]time.Time{"alice": time.Now()}
+	if recipient, routed := getPromptRecipient("root"); recipient != "" || routed {
+		t.Errorf("prompt was routed with a single UI: %q", recipient)
+	}
+
+	// With multiple UIs, prompts go to the UI of the user.
+	uiSessions["bob"] = time.Now()
+	if recipient, routed := getPromptRecipient("bob"); recipient != "bob" || !routed {
+		t.Errorf("prompt was not routed to the user: %q", recipient)
+	}
+	// Users without UI, such as system services, are handled by the fallback.
+	if recipient, routed := getPromptRecipient("root"); recipient != "" || !routed {
+		t.Errorf("prompt of user without UI was routed to %q", recipient)
+	}
+}
+
+func TestPromptResponseAuthorized(t *testing.T) {
+	broadcast := &promptData{}
+	if !broadcast.responseAuthorized(allowIP) {
+		t.Error("response to broadcast prompt was not authorized")
+	}
+
+	routed := &promptData{Recipient: "alice"}
+	if routed.responseAuthorized(allowIP) {
+		t.Error("response to routed prompt was authorized without the recipient")
+	}
+	routed.authorize(blockIP)
+	if routed.responseAuthorized(allowIP) {
+		t.Error("response to routed prompt was authorized with another action")
+	}
+	if !routed.responseAuthorized(blockIP) {
+		t.Error("response of the recipient was not authorized")
+	}
+}
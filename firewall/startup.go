@@ -0,0 +1,239 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tevino/abool"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/intel/filterlists"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/network/packet"
+)
+
+const (
+	// maxStartupHold is the maximum time new connections are held back during
+	// startup, in case a component fails to become ready.
+	maxStartupHold = 3 * time.Minute
+
+	// maxStartupReportEntries limits the amount of distinct entries in the
+	// startup report.
+	maxStartupReportEntries = 1000
+)
+
+var (
+	startupComplete = abool.New()
+
+	startupReport     = &StartupReport{index: make(map[string]*HeldBackConnection)}
+	startupReportLock sync.Mutex
+)
+
+// StartupReport holds information about the connections that were held back
+// while the Portmaster was starting.
+type StartupReport struct {
+	Started   time.Time
+	Completed time.Time
+	TimedOut  bool
+	HeldBack  []*HeldBackConnection
+	Total     int
+	Truncated bool
+
+	index map[string]*HeldBackConnection
+}
+
+// HeldBackConnection describes connections that were blocked during startup.
+type HeldBackConnection struct {
+	ProcessPath string
+	Domain      string
+	IP          string
+	Protocol    uint8
+	Port        uint16
+	Count       int
+	FirstSeen   time.Time
+}
+
+func (hbc *HeldBackConnection) key() string {
+	return fmt.Sprintf("%s|%s|%s|%d|%d", hbc.ProcessPath, hbc.Domain, hbc.IP, hbc.Protocol, hbc.Port)
+}
+
+func startStartupHold() error {
+	startupReportLock.Lock()
+	startupReport = &StartupReport{
+		Started: time.Now(),
+		index:   make(map[string]*HeldBackConnection),
+	}
+	startupReportLock.Unlock()
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "filter/startup",
+		Read:      api.PermitUser,
+		BelongsTo: interceptionModule,
+		StructFunc: func(ar *api.Request) (i interface{}, err error) {
+			return getStartupReport(), nil
+		},
+		Name:        "Get Startup Report",
+		Description: "Returns which connections were held back while the Portmaster was starting.",
+	}); err != nil {
+		return err
+	}
+
+	interceptionModule.StartWorker("startup hold", func(ctx context.Context) error {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		timeout := time.After(maxStartupHold)
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-timeout:
+				finishStartup(true)
+				return nil
+			case <-ticker.C:
+				if startupReady() {
+					finishStartup(false)
+					return nil
+				}
+			}
+		}
+	})
+
+	return nil
+}
+
+// startupReady returns whether all components required for filtering are
+// fully loaded.
+func startupReady() bool {
+	select {
+	case <-filterModule.StartCompleted():
+	default:
+		return false
+	}
+
+	return filterlists.IsLoaded()
+}
+
+func finishStartup(timedOut bool) {
+	if !startupComplete.SetToIf(false, true) {
+		return
+	}
+
+	startupReportLock.Lock()
+	defer startupReportLock.Unlock()
+
+	startupReport.Completed = time.Now()
+	startupReport.TimedOut = timedOut
+
+	switch {
+	case timedOut:
+		log.Warningf(
+			"filter: startup did not complete within %s, releasing hold after blocking %d connections",
+			maxStartupHold,
+			startupReport.Total,
+		)
+	case startupReport.Total > 0:
+		log.Infof(
+			"filter: startup completed after %s, blocked %d connections during startup",
+			startupReport.Completed.Sub(startupReport.Started).Round(time.Millisecond),
+			startupReport.Total,
+		)
+	default:
+		log.Debugf("filter: startup completed after %s", startupReport.Completed.Sub(startupReport.Started).Round(time.Millisecond))
+	}
+}
+
+// holdDuringStartup blocks the given connection if the Portmaster is still
+// starting and blocking during startup is enabled. It returns whether the
+// connection was handled.
+// Held back connections keep their firewall handler, so that their next
+// packet is handled again. Once the startup is complete, their verdict is
+// reset, so that they are decided on regularly.
+func holdDuringStartup(conn *network.Connection, pkt packet.Packet) bool {
+	switch {
+	case startupComplete.IsSet():
+		releaseHeldBack(conn)
+		return false
+	case !blockDuringStartup():
+		return false
+	case conn.Inbound:
+		// Incoming connections are handled regularly.
+		return false
+	case conn.Process().Pid == ownPID:
+		return false
+	}
+
+	// Only record new connections, not their further packets.
+	if conn.Reason.OptionKey != CfgOptionBlockDuringStartupKey {
+		conn.Block("held back during startup", CfgOptionBlockDuringStartupKey)
+		recordHeldBack(conn)
+	}
+
+	// Do not make the verdict permanent and keep the firewall handler, so that
+	// the connection is handled regularly once the startup is complete.
+	issueVerdict(conn, pkt, 0, false)
+	return true
+}
+
+// releaseHeldBack resets the verdict of the connection, if it was held back
+// during startup.
+func releaseHeldBack(conn *network.Connection) {
+	if conn.Verdict == network.VerdictBlock && conn.Reason.OptionKey == CfgOptionBlockDuringStartupKey {
+		conn.Verdict = network.VerdictUndecided
+		conn.Reason = network.Reason{}
+	}
+}
+
+func recordHeldBack(conn *network.Connection) {
+	hbc := &HeldBackConnection{
+		ProcessPath: conn.Process().Path,
+		Protocol:    conn.Entity.Protocol,
+		Port:        conn.Entity.Port,
+		Domain:      conn.Entity.Domain,
+		Count:       1,
+		FirstSeen:   time.Now(),
+	}
+	if conn.Entity.IP != nil {
+		hbc.IP = conn.Entity.IP.String()
+	}
+
+	startupReportLock.Lock()
+	defer startupReportLock.Unlock()
+
+	startupReport.Total++
+	key := hbc.key()
+	if existing, ok := startupReport.index[key]; ok {
+		existing.Count++
+		return
+	}
+
+	if len(startupReport.HeldBack) >= maxStartupReportEntries {
+		startupReport.Truncated = true
+		return
+	}
+	startupReport.HeldBack = append(startupReport.HeldBack, hbc)
+	startupReport.index[key] = hbc
+}
+
+func getStartupReport() *StartupReport {
+	startupReportLock.Lock()
+	defer startupReportLock.Unlock()
+
+	// Return a copy, so the report can be used safely.
+	report := *startupReport
+	report.index = nil
+	report.HeldBack = make([]*HeldBackConnection, 0, len(startupReport.HeldBack))
+	for _, hbc := range startupReport.HeldBack {
+		copied := *hbc
+		report.HeldBack = append(report.HeldBack, &copied)
+	}
+	sort.Slice(report.HeldBack, func(i, j int) bool {
+		return report.HeldBack[i].Count > report.HeldBack[j].Count
+	})
+
+	return &report
+}
@@ -0,0 +1,34 @@
+package firewall
+
+import (
+	"testing"
+
+	"github.com/safing/portmaster/network"
+)
+
+func TestReleaseHeldBack(t *testing.T) {
+	previousStartupComplete := startupComplete.IsSet()
+	defer startupComplete.SetTo(previousStartupComplete)
+	startupComplete.Set()
+
+	// Connections held back during startup are decided on again.
+	held := &network.Connection{}
+	held.Verdict = network.VerdictBlock
+	held.Reason.Msg = "held back during startup"
+	held.Reason.OptionKey = CfgOptionBlockDuringStartupKey
+	if holdDuringStartup(held, nil) {
+		t.Fatal("connection was held back after the startup completed")
+	}
+	if held.Verdict != network.VerdictUndecided || held.Reason.OptionKey != "" {
+		t.Errorf("verdict of held back connection was not reset: %s (%s)", held.Verdict, held.Reason.Msg)
+	}
+
+	// Other verdicts are kept.
+	blocked := &network.Connection{}
+	blocked.Verdict = network.VerdictBlock
+	blocked.Reason.OptionKey = "filter/lists"
+	holdDuringStartup(blocked, nil)
+	if blocked.Verdict != network.VerdictBlock {
+		t.Errorf("verdict of blocked connection was reset")
+	}
+}
@@ -0,0 +1,220 @@
+package firewall
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/profile"
+)
+
+// minSuggestionDomains defines how many distinct domains of the same zone
+// must share a decision before a consolidating rule is suggested.
+const minSuggestionDomains = 3
+
+// RuleSuggestion is a proposal to consolidate multiple decisions or rules of
+// a profile into a single rule.
+type RuleSuggestion struct {
+	// ID identifies the suggestion for applying it.
+	ID string
+	// Rule is the suggested endpoint rule.
+	Rule string
+	// Description explains the suggestion to the user.
+	Description string
+	// Domains holds the domains that led to the suggestion.
+	Domains []string
+	// Replaces holds existing rules that are superseded by the suggested rule.
+	Replaces []string
+}
+
+// suggestionZone collects decisions for all domains of a zone.
+type suggestionZone struct {
+	zone         string
+	allowed      map[string]struct{}
+	blocked      map[string]struct{}
+	allowedRules []string
+	blockedRules []string
+}
+
+func registerSuggestionsAPI() error {
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:        "filter/suggestions/{source:[a-z]+}/{id:[^/]+}",
+		Read:        api.PermitUser,
+		Write:       api.PermitUser,
+		BelongsTo:   interceptionModule,
+		StructFunc:  handleRuleSuggestions,
+		Name:        "Get or Apply Rule Suggestions",
+		Description: "Returns suggestions for consolidating rules of a profile based on recent connections and existing rules. Send a suggestion ID via POST to apply it.",
+		Parameters: []api.Parameter{{
+			Method:      http.MethodPost,
+			Field:       "body",
+			Value:       `{"ID":"<suggestion ID>"}`,
+			Description: "Apply the suggestion with the given ID.",
+		}},
+	})
+}
+
+func handleRuleSuggestions(ar *api.Request) (i interface{}, err error) {
+	if ar.URLVars["source"] != string(profile.SourceLocal) {
+		return nil, errors.New("suggestions are only available for local profiles")
+	}
+	p, err := profile.GetProfile(profile.SourceLocal, ar.URLVars["id"], "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get profile: %w", err)
+	}
+
+	suggestions := getRuleSuggestions(p)
+
+	switch ar.Method {
+	case http.MethodPost, http.MethodPut:
+		selected := &RuleSuggestion{}
+		if err := json.Unmarshal(ar.InputData, selected); err != nil {
+			return nil, fmt.Errorf("failed to parse request: %w", err)
+		}
+		for _, suggestion := range suggestions {
+			if suggestion.ID == selected.ID {
+				if err := p.ConsolidateEndpoints(suggestion.Replaces, suggestion.Rule); err != nil {
+					return nil, fmt.Errorf("failed to apply suggestion: %w", err)
+				}
+				return getRuleSuggestions(p), nil
+			}
+		}
+		return nil, errors.New("suggestion not found, it may be outdated")
+	}
+
+	return suggestions, nil
+}
+
+// getRuleSuggestions analyzes the recent connections and the existing rules
+// of the given profile and returns suggestions for consolidating them.
+func getRuleSuggestions(p *profile.Profile) []*RuleSuggestion {
+	zones := make(map[string]*suggestionZone)
+	getZone := func(domain string) *suggestionZone {
+		zone, err := publicsuffix.EffectiveTLDPlusOne(domain)
+		if err != nil {
+			return nil
+		}
+		sz, ok := zones[zone]
+		if !ok {
+			sz = &suggestionZone{
+				zone:    zone,
+				allowed: make(map[string]struct{}),
+				blocked: make(map[string]struct{}),
+			}
+			zones[zone] = sz
+		}
+		return sz
+	}
+
+	// Collect decisions of recent connections.
+	for _, conn := range network.GetAllConnections() {
+		conn.Lock()
+		belongsToProfile := conn.ProcessContext.Source == string(p.Source) &&
+			conn.ProcessContext.Profile == p.ID
+		domain := strings.TrimSuffix(conn.Entity.Domain, ".")
+		verdict := conn.Verdict
+		internal := conn.Internal
+		conn.Unlock()
+
+		if !belongsToProfile || internal || domain == "" {
+			continue
+		}
+		sz := getZone(domain)
+		if sz == nil {
+			continue
+		}
+		switch verdict {
+		case network.VerdictAccept:
+			sz.allowed[domain] = struct{}{}
+		case network.VerdictBlock, network.VerdictDrop:
+			sz.blocked[domain] = struct{}{}
+		}
+	}
+
+	// Collect existing rules for single domains.
+	existingRules := make(map[string]struct{})
+	for _, rule := range p.EndpointRules() {
+		fields := strings.Fields(rule)
+		existingRules[strings.Join(fields, " ")] = struct{}{}
+		if len(fields) != 2 {
+			continue
+		}
+		domain := strings.TrimSuffix(strings.ToLower(fields[1]), ".")
+		if strings.ContainsAny(domain, "*/:") || strings.HasPrefix(domain, ".") {
+			continue
+		}
+		sz := getZone(domain)
+		if sz == nil {
+			continue
+		}
+		switch fields[0] {
+		case "+":
+			sz.allowedRules = append(sz.allowedRules, rule)
+			sz.allowed[domain] = struct{}{}
+		case "-":
+			sz.blockedRules = append(sz.blockedRules, rule)
+			sz.blocked[domain] = struct{}{}
+		}
+	}
+
+	// Create suggestions for zones with consistent decisions.
+	suggestions := make([]*RuleSuggestion, 0)
+	for _, sz := range zones {
+		var (
+			permit  string
+			action  string
+			domains map[string]struct{}
+			rules   []string
+		)
+		switch {
+		case len(sz.allowed) >= minSuggestionDomains && len(sz.blocked) == 0:
+			permit, action, domains, rules = "+", "allowed", sz.allowed, sz.allowedRules
+		case len(sz.blocked) >= minSuggestionDomains && len(sz.allowed) == 0:
+			permit, action, domains, rules = "-", "blocked", sz.blocked, sz.blockedRules
+		default:
+			continue
+		}
+
+		rule := permit + " ." + sz.zone
+		if _, exists := existingRules[rule]; exists {
+			continue
+		}
+
+		suggestion := &RuleSuggestion{
+			Rule: rule,
+			Description: fmt.Sprintf(
+				"You %s %d domains of %s - create a rule for %s and all its subdomains?",
+				action, len(domains), sz.zone, sz.zone,
+			),
+			Domains:  make([]string, 0, len(domains)),
+			Replaces: rules,
+		}
+		for domain := range domains {
+			suggestion.Domains = append(suggestion.Domains, domain)
+		}
+		sort.Strings(suggestion.Domains)
+		sort.Strings(suggestion.Replaces)
+
+		idSum := sha256.Sum256([]byte(rule + "|" + strings.Join(suggestion.Replaces, "|")))
+		suggestion.ID = hex.EncodeToString(idSum[:8])
+
+		suggestions = append(suggestions, suggestion)
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if len(suggestions[i].Domains) != len(suggestions[j].Domains) {
+			return len(suggestions[i].Domains) > len(suggestions[j].Domains)
+		}
+		return suggestions[i].Rule < suggestions[j].Rule
+	})
+	return suggestions
+}
@@ -0,0 +1,756 @@
+package firewall
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tevino/abool"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/netenv"
+	"github.com/safing/portmaster/network"
+	"github.com/safing/portmaster/profile"
+)
+
+// Config transactions batch changes of the global settings and the settings
+// of profiles. The combined effect can be previewed before the changes are
+// applied together. After applying, connectivity is watched for a while and
+// the changes are reverted automatically if it breaks, so that a bad change
+// cannot lock out the user. Applied transactions are saved until they are
+// confirmed, and reverted at the next start if the Portmaster stopped before.
+//
+// The connectivity checks of the Portmaster itself are not subject to the
+// rules of apps, so the decisions on connections of apps are watched too, and
+// the changes are reverted if apps are cut off from the Internet.
+//
+// The config package can only set one option at a time, so global changes are
+// applied one by one. They are validated together before, but connections
+// decided while applying may see only some of the changes.
+
+const (
+	defaultTransactionWatch = 60 * time.Second
+	maxTransactionWatch     = 30 * time.Minute
+
+	transactionWatchInterval = 5 * time.Second
+
+	// minBlockedAppConnections is the minimum amount of blocked connections of
+	// apps, without any allowed connection, after which apps are considered
+	// to be cut off from the Internet.
+	minBlockedAppConnections = 10
+
+	transactionRevertedNotification = "filter:config-transaction-reverted"
+
+	transactionDBKey = "core:filter/config-transaction"
+)
+
+// Config Transaction States
+const (
+	TransactionStaged   = "staged"
+	TransactionApplied  = "applied"
+	TransactionReverted = "reverted"
+)
+
+// ConfigTransaction holds staged changes of the settings. Settings are given
+// in the flattened form, eg. {"filter/endpoints": ["- ads.example.com"]}. A
+// nil value resets the setting.
+type ConfigTransaction struct {
+	// Config holds changes of the global settings.
+	Config map[string]interface{}
+	// Profiles holds changes of profile settings per scoped profile ID.
+	Profiles map[string]map[string]interface{}
+
+	State   string
+	Created int64
+	// Applied holds when the changes were applied.
+	Applied int64 `json:",omitempty"`
+	// WatchUntil holds until when connectivity is watched after applying. The
+	// changes are reverted if connectivity breaks before.
+	WatchUntil int64 `json:",omitempty"`
+
+	previousConfig   map[string]interface{}
+	previousProfiles map[string]map[string]interface{}
+	stopWatch        context.CancelFunc
+}
+
+// storedTransaction is an applied config transaction in the database.
+type storedTransaction struct {
+	record.Base
+	sync.Mutex
+
+	Transaction      *ConfigTransaction
+	PreviousConfig   map[string]interface{}
+	PreviousProfiles map[string]map[string]interface{}
+}
+
+// TransactionPreview describes the combined effect of a config transaction.
+type TransactionPreview struct {
+	Changes []*SettingChange
+	// Replay holds the decisions of the recorded traffic that change, if
+	// decisions were recorded.
+	Replay *ReplayReport `json:",omitempty"`
+}
+
+// SettingChange describes the change of a setting.
+type SettingChange struct {
+	// Profile is the scoped profile ID, or empty for global settings.
+	Profile string `json:",omitempty"`
+	Key     string
+	Name    string
+	Before  interface{}
+	After   interface{}
+	// AddedRules and RemovedRules hold the changed entries of list settings,
+	// such as rules and filter lists.
+	AddedRules   []string `json:",omitempty"`
+	RemovedRules []string `json:",omitempty"`
+}
+
+var (
+	transaction     *ConfigTransaction
+	transactionLock sync.Mutex
+
+	transactionDB = database.NewInterface(&database.Options{
+		Local:    true,
+		Internal: true,
+	})
+
+	// Decisions on connections of apps to the Internet while watching a
+	// transaction.
+	watchingAppConnections = abool.New()
+	allowedAppConnections  uint64
+	blockedAppConnections  uint64
+)
+
+func registerTransactionAPI() error {
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "config/transaction",
+		Read:      api.PermitAdmin,
+		Write:     api.PermitAdmin,
+		BelongsTo: interceptionModule,
+		StructFunc: func(ar *api.Request) (i interface{}, err error) {
+			if len(ar.InputData) > 0 {
+				changes := &ConfigTransaction{}
+				if err := json.Unmarshal(ar.InputData, changes); err != nil {
+					return nil, fmt.Errorf("failed to parse changes: %w", err)
+				}
+				return StageConfigChanges(changes.Config, changes.Profiles)
+			}
+
+			t := GetConfigTransaction()
+			if t == nil {
+				return nil, errors.New("no staged changes")
+			}
+			return t, nil
+		},
+		Name:        "Get or Stage Config Changes",
+		Description: "Returns the current config transaction, or adds the sent changes to it. Settings are given in the flattened form, a null value resets the setting.",
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "config/transaction/preview",
+		Read:      api.PermitAdmin,
+		BelongsTo: interceptionModule,
+		StructFunc: func(ar *api.Request) (i interface{}, err error) {
+			return PreviewConfigTransaction(ar.Context())
+		},
+		Name:        "Preview Config Changes",
+		Description: "Returns the staged changes with their previous values and the changed decisions of the recorded traffic.",
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "config/transaction/apply",
+		Write:     api.PermitAdmin,
+		BelongsTo: interceptionModule,
+		ActionFunc: func(ar *api.Request) (msg string, err error) {
+			watch := defaultTransactionWatch
+			if s := ar.Request.URL.Query().Get("watch"); s != "" {
+				seconds, err := strconv.Atoi(s)
+				if err != nil {
+					return "", fmt.Errorf("invalid watch duration: %w", err)
+				}
+				watch = time.Duration(seconds) * time.Second
+			}
+			if err := ApplyConfigTransaction(watch); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("applied changes, watching connectivity for %s", watch), nil
+		},
+		Name:        "Apply Config Changes",
+		Description: "Applies the staged changes together. The changes are reverted if connectivity breaks within the watch duration.",
+		Parameters: []api.Parameter{{
+			Method:      http.MethodPost,
+			Field:       "watch",
+			Value:       "60",
+			Description: "Seconds to watch connectivity after applying.",
+		}},
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "config/transaction/confirm",
+		Write:     api.PermitAdmin,
+		BelongsTo: interceptionModule,
+		ActionFunc: func(_ *api.Request) (msg string, err error) {
+			if err := ConfirmConfigTransaction(); err != nil {
+				return "", err
+			}
+			return "confirmed changes", nil
+		},
+		Name:        "Confirm Config Changes",
+		Description: "Keeps the applied changes and stops watching connectivity.",
+	}); err != nil {
+		return err
+	}
+
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:      "config/transaction/revert",
+		Write:     api.PermitAdmin,
+		BelongsTo: interceptionModule,
+		ActionFunc: func(_ *api.Request) (msg string, err error) {
+			if err := RevertConfigTransaction(); err != nil {
+				return "", err
+			}
+			return "reverted changes", nil
+		},
+		Name:        "Revert Config Changes",
+		Description: "Reverts the applied changes, or discards the staged changes.",
+	})
+}
+
+// GetConfigTransaction returns a copy of the current config transaction.
+func GetConfigTransaction() *ConfigTransaction {
+	transactionLock.Lock()
+	defer transactionLock.Unlock()
+
+	if transaction == nil {
+		return nil
+	}
+	return transaction.copy()
+}
+
+// StageConfigChanges adds the given changes to the current config
+// transaction, or starts a new one.
+func StageConfigChanges(globalChanges map[string]interface{}, profileChanges map[string]map[string]interface{}) (*ConfigTransaction, error) {
+	for key := range globalChanges {
+		if _, err := config.GetOption(key); err != nil {
+			return nil, fmt.Errorf("unknown setting %s", key)
+		}
+	}
+	for scopedID, changes := range profileChanges {
+		for key := range changes {
+			if _, err := config.GetOption(key); err != nil {
+				return nil, fmt.Errorf("unknown setting %s of profile %s", key, scopedID)
+			}
+		}
+	}
+
+	transactionLock.Lock()
+	defer transactionLock.Unlock()
+
+	switch {
+	case transaction == nil || transaction.State == TransactionReverted:
+		transaction = &ConfigTransaction{
+			Config:   make(map[string]interface{}),
+			Profiles: make(map[string]map[string]interface{}),
+			State:    TransactionStaged,
+			Created:  time.Now().Unix(),
+		}
+		interceptionModule.Resolve(transactionRevertedNotification)
+	case transaction.State == TransactionApplied:
+		return nil, errors.New("changes were applied and are being watched, confirm or revert them first")
+	}
+
+	for key, value := range globalChanges {
+		transaction.Config[key] = value
+	}
+	for scopedID, changes := range profileChanges {
+		staged, ok := transaction.Profiles[scopedID]
+		if !ok {
+			staged = make(map[string]interface{}, len(changes))
+			transaction.Profiles[scopedID] = staged
+		}
+		for key, value := range changes {
+			staged[key] = value
+		}
+	}
+
+	return transaction.copy(), nil
+}
+
+// PreviewConfigTransaction returns the combined effect of the staged
+// changes. Recorded decisions are replayed against the changes, if there
+// are any.
+func PreviewConfigTransaction(ctx context.Context) (*TransactionPreview, error) {
+	t := GetConfigTransaction()
+	if t == nil || t.State != TransactionStaged {
+		return nil, errors.New("no staged changes")
+	}
+
+	preview := &TransactionPreview{}
+	for key, after := range t.Config {
+		before, err := globalUserValue(key)
+		if err != nil {
+			return nil, err
+		}
+		preview.Changes = append(preview.Changes, newSettingChange("", key, before, after))
+	}
+	for scopedID, changes := range t.Profiles {
+		current, err := profile.GetProfileConfig(scopedID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get profile %s: %w", scopedID, err)
+		}
+		for key, after := range changes {
+			preview.Changes = append(preview.Changes, newSettingChange(scopedID, key, current[key], after))
+		}
+	}
+	sort.Slice(preview.Changes, func(i, j int) bool {
+		if preview.Changes[i].Profile != preview.Changes[j].Profile {
+			return preview.Changes[i].Profile < preview.Changes[j].Profile
+		}
+		return preview.Changes[i].Key < preview.Changes[j].Key
+	})
+
+	// Simulate the verdicts of the recorded traffic.
+	if capture := GetRecording(); capture != nil && len(capture.Decisions) > 0 {
+		// Resets cannot be replayed, as the replay only overrides settings.
+		replayProfiles := make(map[string]map[string]interface{}, len(t.Profiles))
+		for scopedID, changes := range t.Profiles {
+			replayProfiles[scopedID] = withoutResets(changes)
+		}
+		report, err := Replay(ctx, &ReplayRequest{
+			Capture:  capture,
+			Config:   withoutResets(t.Config),
+			Profiles: replayProfiles,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to replay recorded decisions: %w", err)
+		}
+		preview.Replay = report
+	}
+
+	return preview, nil
+}
+
+// ApplyConfigTransaction applies all staged changes. All changes are
+// validated and the transaction is saved with the previous values before
+// anything is changed, so that it can be reverted even if the Portmaster
+// stops before the changes are confirmed. If applying a change fails, the
+// already applied changes are reverted. Connectivity is watched for the given
+// duration and the changes are reverted if it breaks.
+func ApplyConfigTransaction(watch time.Duration) error {
+	switch {
+	case watch <= 0:
+		watch = defaultTransactionWatch
+	case watch > maxTransactionWatch:
+		return fmt.Errorf("watch duration may be at most %s", maxTransactionWatch)
+	}
+
+	transactionLock.Lock()
+	defer transactionLock.Unlock()
+
+	if transaction == nil || transaction.State != TransactionStaged {
+		return errors.New("no staged changes")
+	}
+	t := transaction
+	wasOnline := netenv.Online()
+
+	// Validate the global changes together, as they are applied one by one.
+	if _, err := config.NewPerspective(withoutResets(t.Config)); err != nil {
+		return fmt.Errorf("invalid settings: %w", err)
+	}
+
+	// Collect the previous values before changing anything.
+	previousConfig := make(map[string]interface{}, len(t.Config))
+	for key := range t.Config {
+		previous, err := globalUserValue(key)
+		if err != nil {
+			return err
+		}
+		previousConfig[key] = previous
+	}
+	previousProfiles := make(map[string]map[string]interface{}, len(t.Profiles))
+	for scopedID, changes := range t.Profiles {
+		current, err := profile.GetProfileConfig(scopedID)
+		if err != nil {
+			return fmt.Errorf("failed to get profile %s: %w", scopedID, err)
+		}
+		previous := make(map[string]interface{}, len(changes))
+		for key := range changes {
+			previous[key] = current[key]
+		}
+		previousProfiles[scopedID] = previous
+	}
+
+	now := time.Now()
+	t.State = TransactionApplied
+	t.Applied = now.Unix()
+	t.WatchUntil = now.Add(watch).Unix()
+	t.previousConfig = previousConfig
+	t.previousProfiles = previousProfiles
+	if err := t.save(); err != nil {
+		t.State = TransactionStaged
+		t.Applied = 0
+		t.WatchUntil = 0
+		t.previousConfig = nil
+		t.previousProfiles = nil
+		return fmt.Errorf("failed to save config transaction: %w", err)
+	}
+
+	// Apply global changes.
+	for key, value := range t.Config {
+		if err := config.SetConfigOption(key, value); err != nil {
+			t.revertChanges()
+			return fmt.Errorf("failed to apply %s: %w", key, err)
+		}
+	}
+
+	// Apply profile changes.
+	for scopedID, changes := range t.Profiles {
+		if _, err := profile.SetProfileConfig(scopedID, changes); err != nil {
+			t.revertChanges()
+			return fmt.Errorf("failed to apply changes of profile %s: %w", scopedID, err)
+		}
+	}
+
+	log.Infof("filter: applied config transaction with %d global and %d profile changes", len(t.Config), len(t.Profiles))
+
+	// Watch connectivity.
+	atomic.StoreUint64(&allowedAppConnections, 0)
+	atomic.StoreUint64(&blockedAppConnections, 0)
+	watchingAppConnections.Set()
+	ctx, cancel := context.WithTimeout(interceptionModule.Ctx, watch)
+	t.stopWatch = cancel
+	interceptionModule.StartWorker("watch connectivity after config change", func(_ context.Context) error {
+		watchTransaction(ctx, t, wasOnline)
+		return nil
+	})
+
+	return nil
+}
+
+// watchTransaction reverts the transaction if connectivity breaks before
+// the context is done.
+func watchTransaction(ctx context.Context, t *ConfigTransaction, wasOnline bool) {
+	defer watchingAppConnections.UnSet()
+
+	ticker := time.NewTicker(transactionWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !wasOnline || (netenv.Online() && !appsCutOff()) {
+				continue
+			}
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.Canceled) {
+				// Confirmed or reverted.
+				return
+			}
+			// Check connectivity a last time before keeping the changes.
+			if !wasOnline ||
+				(netenv.CheckAndGetOnlineStatus() >= netenv.StatusSemiOnline && !appsCutOff()) {
+				finishTransaction(t)
+				return
+			}
+		}
+
+		// Connectivity broke.
+		autoRevertTransaction(t)
+		return
+	}
+}
+
+// observeAppConnection counts the decision on the connection while watching a
+// transaction, if it is a connection of an app to the Internet.
+func observeAppConnection(conn *network.Connection) {
+	switch {
+	case !watchingAppConnections.IsSet():
+		return
+	case conn.Inbound || conn.Process().Pid == ownPID || conn.Process().IsSystemResolver():
+		return
+	case conn.Type == network.IPConnection && (conn.Entity == nil || !conn.Entity.IPScope.IsGlobal()):
+		return
+	}
+
+	switch conn.Verdict {
+	case network.VerdictAccept,
+		network.VerdictRerouteToNameserver,
+		network.VerdictRerouteToTunnel,
+		network.VerdictRerouteToProxy:
+		atomic.AddUint64(&allowedAppConnections, 1)
+	case network.VerdictBlock, network.VerdictDrop:
+		atomic.AddUint64(&blockedAppConnections, 1)
+	}
+}
+
+// appsCutOff returns whether apps were cut off from the Internet since the
+// transaction was applied.
+func appsCutOff() bool {
+	return atomic.LoadUint64(&allowedAppConnections) == 0 &&
+		atomic.LoadUint64(&blockedAppConnections) >= minBlockedAppConnections
+}
+
+// autoRevertTransaction reverts the transaction after connectivity broke,
+// unless it was confirmed or reverted in the meantime.
+func autoRevertTransaction(t *ConfigTransaction) {
+	transactionLock.Lock()
+	defer transactionLock.Unlock()
+
+	if transaction == t && t.State == TransactionApplied {
+		log.Warning("filter: connectivity broke after applying config transaction, reverting")
+		t.stopWatch()
+		t.revertChanges()
+		interceptionModule.Warning(
+			transactionRevertedNotification,
+			"Settings Reverted",
+			"Connectivity broke after changing the settings, so the changes were reverted. You can find the reverted changes in the config transaction.",
+		)
+	}
+}
+
+// finishTransaction keeps the applied changes of the transaction.
+func finishTransaction(t *ConfigTransaction) {
+	transactionLock.Lock()
+	defer transactionLock.Unlock()
+
+	if transaction == t && t.State == TransactionApplied {
+		transaction = nil
+		deleteStoredTransaction()
+		log.Info("filter: kept changes of config transaction")
+	}
+}
+
+// ConfirmConfigTransaction keeps the applied changes and stops watching
+// connectivity.
+func ConfirmConfigTransaction() error {
+	transactionLock.Lock()
+	defer transactionLock.Unlock()
+
+	if transaction == nil || transaction.State != TransactionApplied {
+		return errors.New("no applied changes")
+	}
+	transaction.stopWatch()
+	transaction = nil
+	deleteStoredTransaction()
+	log.Info("filter: confirmed changes of config transaction")
+	return nil
+}
+
+// RevertConfigTransaction reverts the applied changes or discards the staged
+// changes.
+func RevertConfigTransaction() error {
+	transactionLock.Lock()
+	defer transactionLock.Unlock()
+
+	switch {
+	case transaction == nil || transaction.State == TransactionReverted:
+		return errors.New("no staged or applied changes")
+	case transaction.State == TransactionStaged:
+		transaction = nil
+		log.Info("filter: discarded staged changes of config transaction")
+	default:
+		transaction.stopWatch()
+		transaction.revertChanges()
+		log.Info("filter: reverted changes of config transaction")
+	}
+	return nil
+}
+
+// revertChanges restores the previous values of the applied changes and
+// deletes the saved transaction. The transaction lock must be held.
+func (t *ConfigTransaction) revertChanges() {
+	for key, value := range t.previousConfig {
+		if err := config.SetConfigOption(key, value); err != nil {
+			log.Warningf("filter: failed to revert %s: %s", key, err)
+		}
+	}
+	for scopedID, previous := range t.previousProfiles {
+		if _, err := profile.SetProfileConfig(scopedID, previous); err != nil {
+			log.Warningf("filter: failed to revert changes of profile %s: %s", scopedID, err)
+		}
+	}
+
+	t.State = TransactionReverted
+	t.previousConfig = nil
+	t.previousProfiles = nil
+	deleteStoredTransaction()
+}
+
+// save saves the applied transaction with the previous values.
+func (t *ConfigTransaction) save() error {
+	r := &storedTransaction{
+		Transaction:      t.copy(),
+		PreviousConfig:   t.previousConfig,
+		PreviousProfiles: t.previousProfiles,
+	}
+	r.SetKey(transactionDBKey)
+	r.SetMeta(&record.Meta{})
+	r.Meta().MakeSecret()
+	return transactionDB.Put(r)
+}
+
+func deleteStoredTransaction() {
+	if err := transactionDB.Delete(transactionDBKey); err != nil && !errors.Is(err, database.ErrNotFound) {
+		log.Warningf("filter: failed to delete saved config transaction: %s", err)
+	}
+}
+
+// revertUnconfirmedTransaction reverts a transaction that was applied but
+// neither confirmed nor reverted before the Portmaster stopped, as it cannot
+// be known whether the changes broke connectivity.
+func revertUnconfirmedTransaction() error {
+	r, err := transactionDB.Get(transactionDBKey)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	stored, ok := r.(*storedTransaction)
+	if r.IsWrapped() {
+		stored = &storedTransaction{}
+		if err := record.Unwrap(r, stored); err != nil {
+			deleteStoredTransaction()
+			return fmt.Errorf("failed to parse saved config transaction: %w", err)
+		}
+	} else if !ok {
+		deleteStoredTransaction()
+		return fmt.Errorf("saved config transaction not of type *storedTransaction, but %T", r)
+	}
+
+	transactionLock.Lock()
+	defer transactionLock.Unlock()
+
+	t := stored.Transaction
+	t.previousConfig = stored.PreviousConfig
+	t.previousProfiles = stored.PreviousProfiles
+	t.revertChanges()
+	transaction = t
+
+	log.Warning("filter: reverted config transaction that was not confirmed before the last shutdown")
+	interceptionModule.Warning(
+		transactionRevertedNotification,
+		"Settings Reverted",
+		"The Portmaster stopped before the changed settings were confirmed, so the changes were reverted. You can find the reverted changes in the config transaction.",
+	)
+	return nil
+}
+
+// copy returns a copy of the transaction for use outside of the lock.
+func (t *ConfigTransaction) copy() *ConfigTransaction {
+	cp := &ConfigTransaction{
+		Config:     make(map[string]interface{}, len(t.Config)),
+		Profiles:   make(map[string]map[string]interface{}, len(t.Profiles)),
+		State:      t.State,
+		Created:    t.Created,
+		Applied:    t.Applied,
+		WatchUntil: t.WatchUntil,
+	}
+	for key, value := range t.Config {
+		cp.Config[key] = value
+	}
+	for scopedID, changes := range t.Profiles {
+		cp.Profiles[scopedID] = make(map[string]interface{}, len(changes))
+		for key, value := range changes {
+			cp.Profiles[scopedID][key] = value
+		}
+	}
+	return cp
+}
+
+// globalUserValue returns the user defined value of the global setting, or
+// nil if it is not set.
+func globalUserValue(key string) (interface{}, error) {
+	option, err := config.GetOption(key)
+	if err != nil {
+		return nil, fmt.Errorf("unknown setting %s", key)
+	}
+	r, err := option.Export()
+	if err != nil {
+		return nil, err
+	}
+	value, _ := r.GetAccessor(r).Get("Value")
+	return value, nil
+}
+
+func newSettingChange(scopedID, key string, before, after interface{}) *SettingChange {
+	change := &SettingChange{
+		Profile: scopedID,
+		Key:     key,
+		Name:    key,
+		Before:  before,
+		After:   after,
+	}
+	if option, err := config.GetOption(key); err == nil {
+		change.Name = option.Name
+	}
+
+	// List the changed entries of list settings.
+	beforeList, beforeIsList := toStringList(before)
+	afterList, afterIsList := toStringList(after)
+	if beforeIsList || afterIsList {
+		change.AddedRules = listDifference(afterList, beforeList)
+		change.RemovedRules = listDifference(beforeList, afterList)
+	}
+	return change
+}
+
+func withoutResets(changes map[string]interface{}) map[string]interface{} {
+	values := make(map[string]interface{}, len(changes))
+	for key, value := range changes {
+		if value != nil {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+func toStringList(value interface{}) ([]string, bool) {
+	switch v := value.(type) {
+	case []string:
+		return v, true
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, entry := range v {
+			if s, ok := entry.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list, true
+	default:
+		return nil, false
+	}
+}
+
+// listDifference returns the entries of a that are not in b.
+func listDifference(a, b []string) []string {
+	inB := make(map[string]struct{}, len(b))
+	for _, entry := range b {
+		inB[entry] = struct{}{}
+	}
+
+	var diff []string
+	for _, entry := range a {
+		if _, ok := inB[entry]; !ok {
+			diff = append(diff, entry)
+		}
+	}
+	return diff
+}
@@ -0,0 +1,197 @@
+package firewall
+
+import (
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/database"
+	_ "github.com/safing/portbase/database/storage/hashmap"
+)
+
+var initTransactionTestOnce sync.Once
+
+// initTransactionTest registers a test setting and an in-memory core
+// database for saving transactions.
+func initTransactionTest(t *testing.T) {
+	t.Helper()
+
+	initTransactionTestOnce.Do(func() {
+		dir, err := ioutil.TempDir("", "portmaster-transaction-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := database.InitializeWithPath(dir); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := database.Register(&database.Database{
+			Name:        "core",
+			StorageType: "hashmap",
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := config.Register(&config.Option{
+			Name:         "Transaction Test",
+			Key:          "test/transaction",
+			Description:  "Test",
+			OptType:      config.OptTypeString,
+			DefaultValue: "default",
+		}); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if err := config.SetConfigOption("test/transaction", "before"); err != nil {
+		t.Fatal(err)
+	}
+	transactionLock.Lock()
+	transaction = nil
+	transactionLock.Unlock()
+}
+
+func stageAndApplyTestTransaction(t *testing.T) *ConfigTransaction {
+	t.Helper()
+
+	if _, err := StageConfigChanges(map[string]interface{}{"test/transaction": "after"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyConfigTransaction(maxTransactionWatch); err != nil {
+		t.Fatal(err)
+	}
+	assertTransactionTestValue(t, "after")
+
+	transactionLock.Lock()
+	defer transactionLock.Unlock()
+	return transaction
+}
+
+func assertTransactionTestValue(t *testing.T, expected string) {
+	t.Helper()
+
+	value, err := globalUserValue("test/transaction")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != expected {
+		t.Errorf("expected setting to be %q, got %v", expected, value)
+	}
+}
+
+func assertTransactionSaved(t *testing.T, expected bool) {
+	t.Helper()
+
+	exists, err := transactionDB.Exists(transactionDBKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists != expected {
+		t.Errorf("expected saved transaction to exist=%v", expected)
+	}
+}
+
+func TestApplyConfigTransaction(t *testing.T) {
+	initTransactionTest(t)
+
+	stageAndApplyTestTransaction(t)
+	assertTransactionSaved(t, true)
+
+	if err := ConfirmConfigTransaction(); err != nil {
+		t.Fatal(err)
+	}
+	assertTransactionTestValue(t, "after")
+	assertTransactionSaved(t, false)
+	if GetConfigTransaction() != nil {
+		t.Error("expected confirmed transaction to be finished")
+	}
+}
+
+func TestApplyInvalidConfigTransaction(t *testing.T) {
+	initTransactionTest(t)
+
+	if _, err := StageConfigChanges(map[string]interface{}{"test/transaction": 1}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyConfigTransaction(0); err == nil {
+		t.Fatal("expected invalid changes to be refused")
+	}
+	assertTransactionTestValue(t, "before")
+	assertTransactionSaved(t, false)
+	if GetConfigTransaction().State != TransactionStaged {
+		t.Error("expected refused changes to stay staged")
+	}
+}
+
+func TestRevertConfigTransaction(t *testing.T) {
+	initTransactionTest(t)
+
+	stageAndApplyTestTransaction(t)
+	if err := RevertConfigTransaction(); err != nil {
+		t.Fatal(err)
+	}
+	assertTransactionTestValue(t, "before")
+	assertTransactionSaved(t, false)
+	if GetConfigTransaction().State != TransactionReverted {
+		t.Error("expected transaction to be reverted")
+	}
+}
+
+func TestAutoRevertConfigTransaction(t *testing.T) {
+	initTransactionTest(t)
+
+	applied := stageAndApplyTestTransaction(t)
+	autoRevertTransaction(applied)
+	assertTransactionTestValue(t, "before")
+	assertTransactionSaved(t, false)
+	if GetConfigTransaction().State != TransactionReverted {
+		t.Error("expected transaction to be reverted")
+	}
+}
+
+func TestRevertUnconfirmedConfigTransaction(t *testing.T) {
+	initTransactionTest(t)
+
+	applied := stageAndApplyTestTransaction(t)
+	applied.stopWatch()
+
+	// Simulate a restart: only the saved transaction is left.
+	transactionLock.Lock()
+	transaction = nil
+	transactionLock.Unlock()
+
+	if err := revertUnconfirmedTransaction(); err != nil {
+		t.Fatal(err)
+	}
+	assertTransactionTestValue(t, "before")
+	assertTransactionSaved(t, false)
+	if GetConfigTransaction().State != TransactionReverted {
+		t.Error("expected transaction to be reverted")
+	}
+
+	// Nothing is left to revert at the next start.
+	if err := revertUnconfirmedTransaction(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAppsCutOff(t *testing.T) {
+	defer func() {
+		atomic.StoreUint64(&allowedAppConnections, 0)
+		atomic.StoreUint64(&blockedAppConnections, 0)
+	}()
+
+	atomic.StoreUint64(&blockedAppConnections, minBlockedAppConnections-1)
+	if appsCutOff() {
+		t.Error("apps must not be considered cut off after a few blocked connections")
+	}
+	atomic.AddUint64(&blockedAppConnections, 1)
+	if !appsCutOff() {
+		t.Error("apps must be considered cut off when all connections are blocked")
+	}
+	atomic.AddUint64(&allowedAppConnections, 1)
+	if appsCutOff() {
+		t.Error("apps must not be considered cut off when connections are allowed")
+	}
+}
@@ -0,0 +1,40 @@
+package flightrecorder
+
+import (
+	"strings"
+
+	"github.com/safing/portbase/api"
+)
+
+func registerAPIEndpoints() error {
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "debug/flightrecorder",
+		Read:      api.PermitAdmin,
+		BelongsTo: module,
+		DataFunc: func(ar *api.Request) (data []byte, err error) {
+			if !recording.IsSet() {
+				return nil, ErrNotRecording
+			}
+			return []byte(strings.Join(entries(), "\n")), nil
+		},
+		Name:        "Get Flight Recorder Buffer",
+		Description: "Returns the current contents of the flight recorder buffer.",
+	}); err != nil {
+		return err
+	}
+
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:      "debug/flightrecorder/flush",
+		Write:     api.PermitAdmin,
+		BelongsTo: module,
+		ActionFunc: func(ar *api.Request) (msg string, err error) {
+			path, err := Trigger("requested by user", true)
+			if err != nil {
+				return "", err
+			}
+			return "flight recorder written to " + path, nil
+		},
+		Name:        "Flush Flight Recorder",
+		Description: "Writes the flight recorder buffer to disk.",
+	})
+}
@@ -0,0 +1,147 @@
+package flightrecorder
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/modules"
+)
+
+const (
+	recordingsDir    = "flightrecorder"
+	recordingsSuffix = ".log"
+)
+
+// ErrNotRecording is returned when the flight recorder is triggered while it
+// is disabled.
+var ErrNotRecording = errors.New("flight recorder is not enabled")
+
+// ErrGuestMode is returned when the flight recorder is triggered in guest
+// mode, as nothing is written to disk during guest sessions.
+var ErrGuestMode = errors.New("flight recorder is suspended in guest mode")
+
+var (
+	module *modules.Module
+
+	// CfgFlightRecorderKey is the config key for enabling the flight recorder.
+	CfgFlightRecorderKey = "core/flightRecorder"
+	cfgFlightRecorder    config.BoolOption
+
+	// CfgBlockTriggersKey is the config key for the block triggers.
+	CfgBlockTriggersKey = "core/flightRecorderBlockTriggers"
+	cfgBlockTriggers    config.StringArrayOption
+)
+
+func init() {
+	module = modules.Register("flightrecorder", prep, start, stop, "base")
+}
+
+func prep() error {
+	if err := config.Register(&config.Option{
+		Name:           "Flight Recorder",
+		Key:            CfgFlightRecorderKey,
+		Description:    "Keep verbose logs and packet metadata in memory and only write them to disk when a trigger fires: a crash, a block trigger or a manual request. This gives detailed diagnostics without writing debug logs all the time.",
+		OptType:        config.OptTypeBool,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   false,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 515,
+			config.CategoryAnnotation:     "Development",
+		},
+	}); err != nil {
+		return err
+	}
+	cfgFlightRecorder = config.Concurrent.GetAsBool(CfgFlightRecorderKey, false)
+
+	if err := config.Register(&config.Option{
+		Name:           "Flight Recorder Block Triggers",
+		Key:            CfgBlockTriggersKey,
+		Description:    "Write the flight recorder to disk when a connection to one of these domains or IPs is blocked. Domains also match their subdomains.",
+		OptType:        config.OptTypeStringArray,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   []string{},
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 516,
+			config.CategoryAnnotation:     "Development",
+		},
+	}); err != nil {
+		return err
+	}
+	cfgBlockTriggers = config.Concurrent.GetAsStringArray(CfgBlockTriggersKey, []string{})
+
+	return registerAPIEndpoints()
+}
+
+func start() error {
+	if err := module.RegisterEventHook(
+		"config",
+		"config change",
+		"start or stop flight recorder",
+		func(_ context.Context, _ interface{}) error {
+			applyConfig()
+			return nil
+		},
+	); err != nil {
+		return err
+	}
+	applyConfig()
+
+	// Flush the buffer when a module panics.
+	reports := make(chan *modules.ModuleError, 8)
+	modules.SetErrorReportingChannel(reports)
+	module.StartServiceWorker("crash trigger", 0, func(ctx context.Context) error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case report := <-reports:
+				if report.Severity == "panic" {
+					_, _ = Trigger("panic in module "+report.ModuleName+": "+report.Message, false)
+				}
+			}
+		}
+	})
+
+	return nil
+}
+
+func stop() error {
+	modules.SetErrorReportingChannel(nil)
+	stopRecording()
+	return nil
+}
+
+func applyConfig() {
+	if cfgFlightRecorder() {
+		startRecording()
+	} else {
+		stopRecording()
+	}
+}
+
+// TriggerOnBlock flushes the buffer if the blocked destination matches one of
+// the configured block triggers.
+func TriggerOnBlock(domain string, ip net.IP) {
+	if !recording.IsSet() {
+		return
+	}
+
+	domain = strings.TrimSuffix(domain, ".")
+	for _, trigger := range cfgBlockTriggers() {
+		trigger = strings.TrimSuffix(strings.ToLower(trigger), ".")
+		switch {
+		case trigger == "":
+		case domain != "" && (domain == trigger || strings.HasSuffix(domain, "."+trigger)):
+		case ip != nil && ip.Equal(net.ParseIP(trigger)):
+		default:
+			continue
+		}
+		_, _ = Trigger("blocked connection to "+trigger, false)
+		return
+	}
+}
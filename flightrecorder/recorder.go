@@ -0,0 +1,212 @@
+package flightrecorder
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/tevino/abool"
+
+	"github.com/safing/portbase/dataroot"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/profile"
+)
+
+const (
+	// bufferSize is the amount of entries kept in the rolling buffer.
+	bufferSize = 10000
+
+	// minFlushInterval limits how often automatic triggers may flush the buffer.
+	minFlushInterval = time.Minute
+
+	timeFormat = "060102 15:04:05.000"
+)
+
+// recordedPackages are the packages that are logged verbosely while the flight
+// recorder is active.
+var recordedPackages = []string{
+	"firewall",
+	"inspection",
+	"interception",
+	"nameserver",
+	"netenv",
+	"network",
+	"process",
+	"resolver",
+	"state",
+}
+
+var (
+	recording = abool.New()
+
+	buffer     = make([]string, bufferSize)
+	bufferNext int
+	bufferFull bool
+	lastFlush  time.Time
+	bufferLock sync.Mutex
+)
+
+// RecordLog must be called by the log adapter for every log line. While
+// recording, all log lines are added to the buffer. It returns whether the log
+// line should be written to the log output, as only lines of the configured
+// log level should be. Nothing is recorded in guest mode.
+func RecordLog(msg log.Message, duplicates uint64) (output bool) {
+	switch {
+	case !recording.IsSet():
+		return true
+	case profile.EphemeralSessionActive():
+		return msg.Severity() >= log.GetLogLevel()
+	}
+
+	line := fmt.Sprintf(
+		"%s %s %s:%d: %s",
+		msg.Time().Format(timeFormat),
+		msg.Severity().Name(),
+		msg.File(),
+		msg.LineNumber(),
+		msg.Text(),
+	)
+	if duplicates > 0 {
+		line += fmt.Sprintf(" (%d duplicates)", duplicates)
+	}
+	add(line)
+
+	return msg.Severity() >= log.GetLogLevel()
+}
+
+// RecordPacket records the metadata of a packet and its verdict. Nothing is
+// recorded in guest mode.
+func RecordPacket(pkt packet.Packet, verdict string) {
+	if !recording.IsSet() || profile.EphemeralSessionActive() {
+		return
+	}
+
+	add(fmt.Sprintf(
+		"%s packet %s %s: %s",
+		time.Now().Format(timeFormat),
+		pkt.GetConnectionID(),
+		pkt,
+		verdict,
+	))
+}
+
+func add(line string) {
+	bufferLock.Lock()
+	defer bufferLock.Unlock()
+
+	buffer[bufferNext] = line
+	bufferNext++
+	if bufferNext >= len(buffer) {
+		bufferNext = 0
+		bufferFull = true
+	}
+}
+
+// entries returns the buffered entries in chronological order.
+func entries() []string {
+	bufferLock.Lock()
+	defer bufferLock.Unlock()
+
+	if !bufferFull {
+		return append([]string(nil), buffer[:bufferNext]...)
+	}
+	copied := make([]string, 0, len(buffer))
+	copied = append(copied, buffer[bufferNext:]...)
+	return append(copied, buffer[:bufferNext]...)
+}
+
+func reset() {
+	bufferLock.Lock()
+	defer bufferLock.Unlock()
+
+	for i := range buffer {
+		buffer[i] = ""
+	}
+	bufferNext = 0
+	bufferFull = false
+}
+
+// Recording returns whether the flight recorder is currently recording.
+func Recording() bool {
+	return recording.IsSet()
+}
+
+// startRecording enables verbose logging of the recorded packages.
+func startRecording() {
+	if !recording.SetToIf(false, true) {
+		return
+	}
+
+	levels := make(map[string]log.Severity, len(recordedPackages))
+	for _, pkg := range recordedPackages {
+		levels[pkg] = log.TraceLevel
+	}
+	log.SetPkgLevels(levels)
+	log.Info("flightrecorder: started recording")
+}
+
+// stopRecording stops recording and discards the buffer.
+func stopRecording() {
+	if !recording.SetToIf(true, false) {
+		return
+	}
+
+	log.UnSetPkgLevels()
+	reset()
+	log.Info("flightrecorder: stopped recording")
+}
+
+// Trigger flushes the buffer to disk. Triggers other than user actions are
+// rate limited in order to not flood the disk. The buffer is not written to
+// disk in guest mode.
+func Trigger(reason string, userAction bool) (path string, err error) {
+	switch {
+	case !recording.IsSet():
+		return "", ErrNotRecording
+	case profile.EphemeralSessionActive():
+		return "", ErrGuestMode
+	}
+
+	bufferLock.Lock()
+	if !userAction && time.Since(lastFlush) < minFlushInterval {
+		bufferLock.Unlock()
+		return "", nil
+	}
+	lastFlush = time.Now()
+	bufferLock.Unlock()
+
+	path, err = flush(reason)
+	if err != nil {
+		log.Warningf("flightrecorder: failed to flush buffer: %s", err)
+		return "", err
+	}
+	log.Infof("flightrecorder: flushed buffer to %s: %s", path, reason)
+	return path, nil
+}
+
+func flush(reason string) (path string, err error) {
+	dir := dataroot.Root().ChildDir("logs", 0777).ChildDir(recordingsDir, 0755)
+	if err := dir.Ensure(); err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	path = filepath.Join(dir.Path, time.Now().UTC().Format("2006-01-02-15-04-05")+recordingsSuffix)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	w := bufio.NewWriter(file)
+	fmt.Fprintf(w, "flight recorder triggered at %s: %s\n\n", time.Now().Format(timeFormat), reason)
+	for _, line := range entries() {
+		fmt.Fprintln(w, line)
+	}
+	return path, w.Flush()
+}
@@ -0,0 +1,28 @@
+package flightrecorder
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestRollingBuffer(t *testing.T) {
+	defer reset()
+
+	for i := 0; i < 10; i++ {
+		add(strconv.Itoa(i))
+	}
+	if e := entries(); len(e) != 10 || e[0] != "0" || e[9] != "9" {
+		t.Fatalf("unexpected entries before wrapping: %v", e)
+	}
+
+	for i := 10; i < bufferSize+5; i++ {
+		add(strconv.Itoa(i))
+	}
+	e := entries()
+	if len(e) != bufferSize {
+		t.Fatalf("expected %d entries, got %d", bufferSize, len(e))
+	}
+	if e[0] != "5" || e[len(e)-1] != strconv.Itoa(bufferSize+4) {
+		t.Fatalf("unexpected order after wrapping: first=%s last=%s", e[0], e[len(e)-1])
+	}
+}
@@ -0,0 +1,166 @@
+package guest
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/portbase/notifications"
+	"github.com/safing/portmaster/profile"
+	"github.com/safing/portmaster/resolver"
+	"github.com/safing/portmaster/status"
+)
+
+const (
+	// guestSecurityLevel is the security level that is enforced during a
+	// guest session.
+	guestSecurityLevel = status.SecurityLevelHigh
+
+	mitigationID   = "guest-mode"
+	notificationID = "guest:active"
+)
+
+var (
+	module *modules.Module
+
+	sessionLock sync.Mutex
+)
+
+func init() {
+	module = modules.Register("guest", prep, start, nil, "profiles", "status", "resolver")
+}
+
+func prep() error {
+	return registerAPIEndpoints()
+}
+
+func start() error {
+	// Continue a guest session that was active before the last shutdown.
+	if profile.EphemeralSessionActive() {
+		activate()
+	}
+	return nil
+}
+
+// Active returns whether a guest session is active.
+func Active() bool {
+	return profile.EphemeralSessionActive()
+}
+
+// Start starts a guest session: the security level is raised and all changes
+// to profiles and settings are discarded when the session ends. No history is kept during
+// the session: decision recordings, top talkers, profile stats and the flight
+// recorder are suspended.
+func Start() error {
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+
+	// Without an admin passphrase, guest mode could not be ended.
+	if !profile.AdminPassphraseSet() {
+		return errors.New("an admin passphrase must be set before starting guest mode")
+	}
+	if err := profile.StartEphemeralSession(); err != nil {
+		return err
+	}
+	activate()
+
+	log.Info("guest: started guest session")
+	return nil
+}
+
+// End ends the guest session, discards all changes to profiles and settings
+// made during the session and clears the DNS and IP caches, which hold the visited
+// domains.
+func End(ctx context.Context) error {
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+
+	if err := profile.EndEphemeralSession(); err != nil {
+		return err
+	}
+	status.DeleteMitigationLevel(mitigationID)
+	notifications.Delete(notificationID)
+
+	var lastErr error
+	if _, err := resolver.ClearNameCache(ctx); err != nil {
+		lastErr = err
+		log.Warningf("guest: failed to clear dns cache: %s", err)
+	}
+	if _, err := resolver.ClearIPInfoCache(ctx); err != nil {
+		lastErr = err
+		log.Warningf("guest: failed to clear ip infos: %s", err)
+	}
+
+	log.Info("guest: ended guest session")
+	return lastErr
+}
+
+func activate() {
+	status.SetMitigationLevel(mitigationID, guestSecurityLevel)
+
+	notifications.NotifyInfo(
+		notificationID,
+		"Guest Mode Active",
+		"The Portmaster enforces a high security level, keeps no history of network activity and discards all changes to apps and settings when guest mode ends. Guest mode can only be ended with the admin passphrase.",
+	).AttachToModule(module)
+}
+
+func registerAPIEndpoints() error {
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "guest/status",
+		Read:      api.PermitUser,
+		BelongsTo: module,
+		StructFunc: func(ar *api.Request) (i interface{}, err error) {
+			return struct {
+				Active bool
+			}{
+				Active: Active(),
+			}, nil
+		},
+		Name:        "Get Guest Mode Status",
+		Description: "Returns whether guest mode is active.",
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "guest/start",
+		Write:     api.PermitUser,
+		BelongsTo: module,
+		ActionFunc: func(ar *api.Request) (msg string, err error) {
+			if err := Start(); err != nil {
+				return "", err
+			}
+			return "guest mode started", nil
+		},
+		Name:        "Start Guest Mode",
+		Description: "Raises the security level and discards all changes to apps and settings when guest mode ends. Requires an admin passphrase to be set.",
+	}); err != nil {
+		return err
+	}
+
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:      "guest/end",
+		Write:     api.PermitUser,
+		BelongsTo: module,
+		ActionFunc: func(ar *api.Request) (msg string, err error) {
+			// The API permissions cannot tell the guest apart from an admin.
+			if !profile.AdminAuthorized(ar) {
+				return "", profile.ErrInvalidAdminPassphrase
+			}
+			err = End(ar.Context())
+			switch {
+			case errors.Is(err, profile.ErrNoEphemeralSession):
+				return "guest mode is not active", nil
+			case err != nil:
+				return "", err
+			}
+			return "guest mode ended", nil
+		},
+		Name:        "End Guest Mode",
+		Description: "Ends guest mode, discards all changes to apps and settings made during guest mode and clears the DNS cache. Requires the admin passphrase in the X-Portmaster-Admin header.",
+	})
+}
@@ -0,0 +1,162 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portbase/database/record"
+)
+
+// The database backend keeps the history in the history database of the
+// Portmaster, at history:<kind>/<id>.
+
+const historyDBPrefix = "history:"
+
+func init() {
+	registerBackend(&Backend{
+		Name:        "database",
+		Description: "Keep the history in the Portmaster database",
+		Open: func(_ string) (Store, error) {
+			return &databaseStore{
+				db: database.NewInterface(&database.Options{
+					Local:    true,
+					Internal: true,
+				}),
+			}, nil
+		},
+	})
+}
+
+type databaseStore struct {
+	db *database.Interface
+}
+
+type entryRecord struct {
+	record.Base
+	sync.Mutex
+
+	Kind string
+	ID   string
+	Time int64
+	Data json.RawMessage
+}
+
+func (s *databaseStore) Put(entry *Entry) error {
+	r := &entryRecord{
+		Kind: entry.Kind,
+		ID:   entry.ID,
+		Time: entry.Time.Unix(),
+		Data: entry.Data,
+	}
+	r.SetKey(historyDBPrefix + entry.Kind + "/" + entry.ID)
+	r.UpdateMeta()
+	return s.db.Put(r)
+}
+
+func (s *databaseStore) Get(kind, id string) (*Entry, error) {
+	r, err := s.db.Get(historyDBPrefix + kind + "/" + id)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	er, err := ensureEntryRecord(r)
+	if err != nil {
+		return nil, err
+	}
+	return er.entry(), nil
+}
+
+func (s *databaseStore) Query(kind string, from, to time.Time) ([]*Entry, error) {
+	it, err := s.db.Query(query.New(historyDBPrefix + kind + "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*Entry
+	for r := range it.Next {
+		er, err := ensureEntryRecord(r)
+		if err != nil {
+			it.Cancel()
+			return nil, err
+		}
+		if er.Time < from.Unix() || er.Time > to.Unix() {
+			continue
+		}
+		entries = append(entries, er.entry())
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	return entries, nil
+}
+
+func (s *databaseStore) DeleteBefore(t time.Time) (int, error) {
+	it, err := s.db.Query(query.New(historyDBPrefix))
+	if err != nil {
+		return 0, err
+	}
+
+	var expired []string
+	for r := range it.Next {
+		er, err := ensureEntryRecord(r)
+		if err != nil {
+			it.Cancel()
+			return 0, err
+		}
+		if er.Time < t.Unix() {
+			expired = append(expired, er.Key())
+		}
+	}
+	if err := it.Err(); err != nil {
+		return 0, err
+	}
+
+	for i, key := range expired {
+		if err := s.db.Delete(key); err != nil {
+			return i, err
+		}
+	}
+	return len(expired), nil
+}
+
+func (s *databaseStore) Close() error {
+	return nil
+}
+
+func (er *entryRecord) entry() *Entry {
+	return &Entry{
+		Kind: er.Kind,
+		ID:   er.ID,
+		Time: time.Unix(er.Time, 0),
+		Data: er.Data,
+	}
+}
+
+func ensureEntryRecord(r record.Record) (*entryRecord, error) {
+	if r.IsWrapped() {
+		er := &entryRecord{}
+		if err := record.Unwrap(r, er); err != nil {
+			return nil, err
+		}
+		return er, nil
+	}
+
+	er, ok := r.(*entryRecord)
+	if !ok {
+		return nil, fmt.Errorf("record not of type *entryRecord, but %T", r)
+	}
+	return er, nil
+}
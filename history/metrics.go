@@ -0,0 +1,57 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/metrics"
+	"github.com/safing/portbase/modules"
+)
+
+const (
+	// MetricsKind is the kind of the metric snapshot entries. Their data maps
+	// the labeled metric IDs to their values.
+	MetricsKind = "metrics"
+
+	metricsSnapshotInterval = time.Hour
+)
+
+// saveMetricsSnapshot saves the current values of all metrics.
+func saveMetricsSnapshot(_ context.Context, _ *modules.Task) error {
+	buf := &bytes.Buffer{}
+	metrics.WriteMetrics(buf, api.PermitAdmin, config.ExpertiseLevelDeveloper)
+
+	now := time.Now()
+	return Save(MetricsKind, strconv.FormatInt(now.Unix(), 10), now, parseMetrics(buf))
+}
+
+// parseMetrics parses metrics in the Prometheus text format into a map of
+// the labeled metric IDs to their values.
+func parseMetrics(buf *bytes.Buffer) map[string]float64 {
+	values := make(map[string]float64)
+
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		split := strings.LastIndexByte(line, ' ')
+		if split <= 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(line[split+1:], 64)
+		if err != nil {
+			continue
+		}
+		values[line[:split]] = value
+	}
+
+	return values
+}
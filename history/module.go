@@ -0,0 +1,146 @@
+// Package history persists historic data, such as finished decision
+// recordings and metric snapshots. The data is kept in a storage backend
+// that can be selected in the settings: the Portmaster database or an SQLite
+// file, which external tools can query with standard SQL.
+package history
+
+import (
+	"context"
+	"time"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/dataroot"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+)
+
+const (
+	defaultStorage   = "database"
+	defaultRetention = 30
+)
+
+var (
+	module *modules.Module
+
+	// CfgStorageKey is the config key for the storage backend of the history.
+	CfgStorageKey = "core/historyStorage"
+	cfgStorage    config.StringOption
+
+	// CfgRetentionKey is the config key for the history retention in days.
+	CfgRetentionKey = "core/historyRetention"
+	cfgRetention    config.IntOption
+)
+
+func init() {
+	module = modules.Register("history", prep, start, stop, "base")
+}
+
+func prep() error {
+	backendsLock.Lock()
+	possibleValues := make([]config.PossibleValue, 0, len(backends))
+	for _, backend := range backends {
+		possibleValues = append(possibleValues, config.PossibleValue{
+			Name:        backend.Name,
+			Value:       backend.Name,
+			Description: backend.Description,
+		})
+	}
+	backendsLock.Unlock()
+
+	if err := config.Register(&config.Option{
+		Name:           "History Storage",
+		Key:            CfgStorageKey,
+		Description:    "Where historic data, such as decision recordings and metric snapshots, is kept. The SQLite file is history/history.sqlite in the data directory and can be queried with standard SQL and copied for backups. Changes take effect after a restart. Existing history is not moved to the new storage.",
+		OptType:        config.OptTypeString,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   defaultStorage,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 530,
+			config.DisplayHintAnnotation:  config.DisplayHintOneOf,
+			config.CategoryAnnotation:     "Development",
+		},
+		PossibleValues: possibleValues,
+	}); err != nil {
+		return err
+	}
+	cfgStorage = config.Concurrent.GetAsString(CfgStorageKey, defaultStorage)
+
+	if err := config.Register(&config.Option{
+		Name:           "History Retention",
+		Key:            CfgRetentionKey,
+		Description:    "How long historic data is kept. Set to 0 to keep it until it is deleted manually.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   defaultRetention,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 531,
+			config.UnitAnnotation:         "days",
+			config.CategoryAnnotation:     "Development",
+		},
+		ValidationRegex: `^[0-9]{1,4}$`,
+	}); err != nil {
+		return err
+	}
+	cfgRetention = config.Concurrent.GetAsInt(CfgRetentionKey, defaultRetention)
+
+	return nil
+}
+
+func start() error {
+	backend, err := getBackend(cfgStorage())
+	if err != nil {
+		return err
+	}
+
+	dir := dataroot.Root().ChildDir("history", 0700)
+	if err := dir.Ensure(); err != nil {
+		return err
+	}
+	s, err := backend.Open(dir.Path)
+	if err != nil {
+		return err
+	}
+
+	storeLock.Lock()
+	store = s
+	storeLock.Unlock()
+	log.Infof("history: using %s storage", backend.Name)
+
+	module.NewTask("delete expired history", deleteExpired).Repeat(time.Hour).Schedule(time.Now().Add(time.Minute))
+	module.NewTask("save metrics snapshot", saveMetricsSnapshot).Repeat(metricsSnapshotInterval)
+	return nil
+}
+
+func stop() error {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	if store == nil {
+		return nil
+	}
+	err := store.Close()
+	store = nil
+	return err
+}
+
+func deleteExpired(_ context.Context, _ *modules.Task) error {
+	retention := cfgRetention()
+	if retention <= 0 {
+		return nil
+	}
+
+	s, err := getStore()
+	if err != nil {
+		return err
+	}
+	deleted, err := s.DeleteBefore(time.Now().Add(-time.Duration(retention) * 24 * time.Hour))
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		log.Infof("history: deleted %d expired entries", deleted)
+	}
+	return nil
+}
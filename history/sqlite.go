@@ -0,0 +1,135 @@
+package history
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	// SQLite driver without cgo.
+	_ "modernc.org/sqlite"
+)
+
+// The SQLite backend keeps the history in a single SQLite file, which can be
+// queried with standard SQL by external tools and copied for backups:
+//
+//   sqlite3 history.sqlite "SELECT id, json_extract(data, '$.Truncated') FROM history WHERE kind = 'decision-recording'"
+//
+// Times are stored as unix timestamps and data as JSON text.
+
+const sqliteFileName = "history.sqlite"
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS history (
+	kind TEXT NOT NULL,
+	id   TEXT NOT NULL,
+	time INTEGER NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (kind, id)
+);
+CREATE INDEX IF NOT EXISTS history_kind_time ON history (kind, time);
+`
+
+func init() {
+	registerBackend(&Backend{
+		Name:        "sqlite",
+		Description: "Keep the history in an SQLite file, which can be queried by other tools",
+		Open:        openSQLiteStore,
+	})
+}
+
+type sqliteStore struct {
+	db *sql.DB
+}
+
+func openSQLiteStore(dir string) (Store, error) {
+	db, err := sql.Open("sqlite", filepath.Join(dir, sqliteFileName))
+	if err != nil {
+		return nil, err
+	}
+	// Writes are serialized by SQLite anyway. Using a single connection
+	// avoids busy errors, while external readers are not blocked thanks to
+	// the write-ahead log.
+	db.SetMaxOpenConns(1)
+
+	for _, stmt := range []string{"PRAGMA journal_mode=WAL", sqliteSchema} {
+		if _, err := db.Exec(stmt); err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("failed to prepare sqlite database: %w", err)
+		}
+	}
+	return &sqliteStore{db: db}, nil
+}
+
+func (s *sqliteStore) Put(entry *Entry) error {
+	_, err := s.db.Exec(
+		"INSERT OR REPLACE INTO history (kind, id, time, data) VALUES (?, ?, ?, ?)",
+		entry.Kind, entry.ID, entry.Time.Unix(), string(entry.Data),
+	)
+	return err
+}
+
+func (s *sqliteStore) Get(kind, id string) (*Entry, error) {
+	row := s.db.QueryRow("SELECT time, data FROM history WHERE kind = ? AND id = ?", kind, id)
+
+	entry, err := scanEntry(kind, id, row.Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return entry, err
+}
+
+func (s *sqliteStore) Query(kind string, from, to time.Time) ([]*Entry, error) {
+	rows, err := s.db.Query(
+		"SELECT id, time, data FROM history WHERE kind = ? AND time >= ? AND time <= ? ORDER BY time",
+		kind, from.Unix(), to.Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close() //nolint:errcheck
+
+	var entries []*Entry
+	for rows.Next() {
+		var id string
+		entry, err := scanEntry(kind, "", func(dest ...interface{}) error {
+			return rows.Scan(append([]interface{}{&id}, dest...)...)
+		})
+		if err != nil {
+			return nil, err
+		}
+		entry.ID = id
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (s *sqliteStore) DeleteBefore(t time.Time) (int, error) {
+	result, err := s.db.Exec("DELETE FROM history WHERE time < ?", t.Unix())
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	return int(deleted), err
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
+
+func scanEntry(kind, id string, scan func(dest ...interface{}) error) (*Entry, error) {
+	var (
+		timestamp int64
+		data      string
+	)
+	if err := scan(&timestamp, &data); err != nil {
+		return nil, err
+	}
+	return &Entry{
+		Kind: kind,
+		ID:   id,
+		Time: time.Unix(timestamp, 0),
+		Data: []byte(data),
+	}, nil
+}
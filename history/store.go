@@ -0,0 +1,131 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when an entry does not exist.
+	ErrNotFound = errors.New("history entry not found")
+
+	// ErrNotRunning is returned when the history is used before it was
+	// started or after it was stopped.
+	ErrNotRunning = errors.New("history is not running")
+)
+
+// Entry is a historic data point, such as a finished decision recording.
+type Entry struct {
+	// Kind groups entries of the same type, eg. "decision-recording".
+	Kind string
+	// ID identifies the entry within its kind.
+	ID string
+	// Time is the point in time the entry describes. It is used for
+	// querying and for the retention.
+	Time time.Time
+	// Data holds the entry as JSON.
+	Data json.RawMessage
+}
+
+// Store persists history entries. It is implemented by the storage backends.
+type Store interface {
+	// Put saves the entry. An existing entry with the same kind and ID is
+	// replaced.
+	Put(entry *Entry) error
+	// Get returns the entry with the given kind and ID.
+	Get(kind, id string) (*Entry, error)
+	// Query returns the entries of the given kind within the given time
+	// range, ordered by time.
+	Query(kind string, from, to time.Time) ([]*Entry, error)
+	// DeleteBefore deletes all entries older than the given time and
+	// returns how many were deleted.
+	DeleteBefore(t time.Time) (int, error)
+	// Close closes the store.
+	Close() error
+}
+
+// Backend is a storage backend of the history.
+type Backend struct {
+	Name        string
+	Description string
+	// Open opens the store in the given directory.
+	Open func(dir string) (Store, error)
+}
+
+var (
+	backends     []*Backend
+	backendsLock sync.Mutex
+
+	store     Store
+	storeLock sync.RWMutex
+)
+
+func registerBackend(backend *Backend) {
+	backendsLock.Lock()
+	defer backendsLock.Unlock()
+
+	backends = append(backends, backend)
+}
+
+func getBackend(name string) (*Backend, error) {
+	backendsLock.Lock()
+	defer backendsLock.Unlock()
+
+	for _, backend := range backends {
+		if backend.Name == name {
+			return backend, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown history storage backend %q", name)
+}
+
+func getStore() (Store, error) {
+	storeLock.RLock()
+	defer storeLock.RUnlock()
+
+	if store == nil {
+		return nil, ErrNotRunning
+	}
+	return store, nil
+}
+
+// Save saves data as history entry of the given kind.
+func Save(kind, id string, t time.Time, data interface{}) error {
+	s, err := getStore()
+	if err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to serialize history entry: %w", err)
+	}
+	return s.Put(&Entry{
+		Kind: kind,
+		ID:   id,
+		Time: t,
+		Data: raw,
+	})
+}
+
+// Get returns the history entry with the given kind and ID.
+func Get(kind, id string) (*Entry, error) {
+	s, err := getStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.Get(kind, id)
+}
+
+// Query returns the history entries of the given kind within the given time
+// range, ordered by time.
+func Query(kind string, from, to time.Time) ([]*Entry, error) {
+	s, err := getStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.Query(kind, from, to)
+}
@@ -0,0 +1,127 @@
+package history
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/safing/portbase/database"
+	_ "github.com/safing/portbase/database/storage/hashmap"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "portmaster-history-test")
+	if err != nil {
+		panic(err)
+	}
+	if err := database.InitializeWithPath(dir); err != nil {
+		panic(err)
+	}
+	if _, err := database.Register(&database.Database{
+		Name:        "history",
+		StorageType: "hashmap",
+	}); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestBackends(t *testing.T) {
+	for _, backend := range backends {
+		backend := backend
+		t.Run(backend.Name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "portmaster-history-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir) //nolint:errcheck
+
+			s, err := backend.Open(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close() //nolint:errcheck
+
+			testStore(t, s)
+		})
+	}
+}
+
+func testStore(t *testing.T, s Store) {
+	t.Helper()
+
+	start := time.Unix(1600000000, 0)
+	for i, id := range []string{"3", "1", "2"} {
+		if err := s.Put(&Entry{
+			Kind: "test",
+			ID:   id,
+			Time: start.Add(time.Duration(3-i) * time.Hour),
+			Data: []byte(`{"ID":` + id + `}`),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Put(&Entry{Kind: "other", ID: "1", Time: start, Data: []byte(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replace an entry.
+	if err := s.Put(&Entry{Kind: "test", ID: "2", Time: start.Add(time.Hour), Data: []byte(`{"ID":22}`)}); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := s.Get("test", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(entry.Data) != `{"ID":22}` || !entry.Time.Equal(start.Add(time.Hour)) {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+	if _, err := s.Get("test", "4"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	// Query by kind and time, ordered by time.
+	entries, err := s.Query("test", start, start.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, entry := range entries {
+		ids = append(ids, entry.ID)
+	}
+	if len(ids) != 2 || ids[0] != "2" || ids[1] != "1" {
+		t.Errorf("unexpected query result: %v", ids)
+	}
+
+	// Delete old entries of all kinds.
+	deleted, err := s.DeleteBefore(start.Add(90 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Errorf("expected 2 deleted entries, got %d", deleted)
+	}
+	entries, err = s.Query("test", start, start.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("expected 2 remaining entries, got %d", len(entries))
+	}
+}
+
+func TestParseMetrics(t *testing.T) {
+	t.Parallel()
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("# HELP x\n# TYPE x counter\nportmaster_dns_requests{blocked=\"true\"} 12\nportmaster_uptime 3.5\n\ninvalid\n")
+	values := parseMetrics(buf)
+	if len(values) != 2 || values[`portmaster_dns_requests{blocked="true"}`] != 12 || values["portmaster_uptime"] != 3.5 {
+		t.Errorf("unexpected metrics: %v", values)
+	}
+}
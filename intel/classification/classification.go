@@ -0,0 +1,125 @@
+package classification
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/updater"
+	"github.com/safing/portmaster/updates"
+)
+
+// Labels
+const (
+	Unknown    = ""
+	Telemetry  = "telemetry"
+	Analytics  = "analytics"
+	Ads        = "ads"
+	Functional = "functional"
+)
+
+const datasetIdentifier = "intel/classification/domains.json"
+
+// dataset holds the labels of domains. Labels of a domain also apply to its
+// subdomains, unless a subdomain has its own label.
+type dataset struct {
+	// Domains maps domains to their label for all applications.
+	Domains map[string]string
+	// Apps maps application identifiers to domains and their label for that
+	// application. They take precedence over the labels for all applications.
+	Apps map[string]map[string]string
+}
+
+var (
+	data        *dataset
+	datasetFile *updater.File
+	dataLock    sync.RWMutex
+)
+
+// Classify returns the label of the domain when used by the given
+// application. The application identifier is the lowercase name of its
+// binary, without extension. Unknown is returned if the domain is not
+// classified.
+func Classify(app, fqdn string) string {
+	dataLock.RLock()
+	defer dataLock.RUnlock()
+
+	if data == nil {
+		return Unknown
+	}
+	appDomains := data.Apps[app]
+
+	domain := strings.ToLower(strings.TrimSuffix(fqdn, "."))
+	for domain != "" {
+		if label, ok := appDomains[domain]; ok {
+			return label
+		}
+		if label, ok := data.Domains[domain]; ok {
+			return label
+		}
+
+		dot := strings.IndexByte(domain, '.')
+		if dot < 0 {
+			break
+		}
+		domain = domain[dot+1:]
+	}
+
+	return Unknown
+}
+
+// AppIdentifier returns the application identifier of the binary at the
+// given path.
+func AppIdentifier(binaryPath string) string {
+	name := strings.ToLower(filepath.Base(binaryPath))
+	return strings.TrimSuffix(name, ".exe")
+}
+
+// IsTracking returns whether the label marks a destination that only serves
+// telemetry or analytics.
+func IsTracking(label string) bool {
+	return label == Telemetry || label == Analytics
+}
+
+func loadDataset(onlyIfUpgraded bool) {
+	dataLock.RLock()
+	if onlyIfUpgraded && (datasetFile == nil || !datasetFile.UpgradeAvailable()) {
+		dataLock.RUnlock()
+		return
+	}
+	dataLock.RUnlock()
+
+	file, err := updates.GetFile(datasetIdentifier)
+	if err != nil {
+		log.Debugf("intel/classification: failed to get dataset: %s", err)
+		return
+	}
+	loaded, err := parseDataset(file.Path())
+	if err != nil {
+		log.Warningf("intel/classification: %s", err)
+		return
+	}
+
+	dataLock.Lock()
+	data = loaded
+	datasetFile = file
+	dataLock.Unlock()
+
+	log.Infof("intel/classification: loaded %d domain labels for %d apps", len(loaded.Domains), len(loaded.Apps))
+}
+
+func parseDataset(path string) (*dataset, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dataset: %w", err)
+	}
+	loaded := &dataset{}
+	if err := json.Unmarshal(raw, loaded); err != nil {
+		return nil, fmt.Errorf("failed to parse dataset: %w", err)
+	}
+	return loaded, nil
+}
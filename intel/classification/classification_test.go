@@ -0,0 +1,46 @@
+package classification
+
+import "testing"
+
+func TestClassify(t *testing.T) {
+	dataLock.Lock()
+	data = &dataset{
+		Domains: map[string]string{
+			"telemetry.example.com": Telemetry,
+			"example.com":           Functional,
+			"ads.example.net":       Ads,
+		},
+		Apps: map[string]map[string]string{
+			"browser": {
+				"telemetry.example.com": Functional,
+			},
+		},
+	}
+	dataLock.Unlock()
+	defer func() {
+		dataLock.Lock()
+		data = nil
+		dataLock.Unlock()
+	}()
+
+	for _, test := range []struct {
+		app      string
+		fqdn     string
+		expected string
+	}{
+		{"app", "telemetry.example.com.", Telemetry},
+		{"app", "eu.telemetry.example.com.", Telemetry},
+		{"browser", "eu.telemetry.example.com.", Functional},
+		{"app", "www.example.com.", Functional},
+		{"app", "Ads.Example.net.", Ads},
+		{"app", "example.net.", Unknown},
+	} {
+		if label := Classify(test.app, test.fqdn); label != test.expected {
+			t.Errorf("expected %s used by %s to be classified as %q, got %q", test.fqdn, test.app, test.expected, label)
+		}
+	}
+
+	if id := AppIdentifier("/usr/bin/Browser.exe"); id != "browser" {
+		t.Errorf("unexpected app identifier %q", id)
+	}
+}
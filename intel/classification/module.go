@@ -0,0 +1,28 @@
+package classification
+
+import (
+	"context"
+
+	"github.com/safing/portbase/modules"
+	"github.com/safing/portmaster/updates"
+)
+
+var module *modules.Module
+
+func init() {
+	module = modules.Register("classification", nil, start, nil, "base", "updates")
+}
+
+func start() error {
+	loadDataset(false)
+
+	return module.RegisterEventHook(
+		updates.ModuleName,
+		updates.ResourceUpdateEvent,
+		"Check for classification dataset updates",
+		func(_ context.Context, _ interface{}) error {
+			loadDataset(true)
+			return nil
+		},
+	)
+}
@@ -7,12 +7,15 @@
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/intel/classification"
 	"github.com/safing/portmaster/intel/filterlists"
 	"github.com/safing/portmaster/intel/geoip"
 	"github.com/safing/portmaster/network/netutils"
 	"github.com/safing/portmaster/status"
+	"github.com/safing/portmaster/tracing"
 	"golang.org/x/net/publicsuffix"
 )
 
@@ -73,6 +76,11 @@ type Entity struct {
 
 	location *geoip.Location
 
+	// Classification holds the label of the domain for the application that
+	// connects to it, such as "telemetry" or "functional". It is empty if the
+	// domain was not classified.
+	Classification string
+
 	// BlockedByLists holds list source IDs that
 	// are used to block the entity.
 	BlockedByLists []string
@@ -93,6 +101,7 @@ type Entity struct {
 	loadIPListOnce     sync.Once
 	loadCoutryListOnce sync.Once
 	loadAsnListOnce    sync.Once
+	classifyOnce       sync.Once
 }
 
 // Init initializes the internal state and returns the entity.
@@ -232,6 +241,10 @@ func (e *Entity) getLocation(ctx context.Context) {
 			return
 		}
 
+		_, span := tracing.StartSpan(ctx, "geoip lookup")
+		defer span.End()
+		defer tracing.ObserveStage(tracing.StageGeoIP, time.Now())
+
 		// get location data
 		loc, err := geoip.GetLocation(e.IP)
 		if err != nil {
@@ -275,8 +288,35 @@ func (e *Entity) GetASN(ctx context.Context) (uint, bool) {
 	return e.ASN, true
 }
 
+// GetASOrg returns the name of the AS owner and whether it is set.
+func (e *Entity) GetASOrg(ctx context.Context) (string, bool) {
+	e.getLocation(ctx)
+
+	if e.ASOrg == "" {
+		return "", false
+	}
+	return e.ASOrg, true
+}
+
+// Classification
+
+// Classify classifies the domain for the given application and returns the
+// label. See package classification for details.
+func (e *Entity) Classify(app string) string {
+	e.classifyOnce.Do(func() {
+		if e.Domain != "" {
+			e.Classification = classification.Classify(app, e.Domain)
+		}
+	})
+	return e.Classification
+}
+
 // Lists
 func (e *Entity) getLists(ctx context.Context) {
+	ctx, span := tracing.StartSpan(ctx, "filterlist lookup")
+	defer span.End()
+	defer tracing.ObserveStage(tracing.StageFilterLists, time.Now())
+
 	e.getDomainLists(ctx)
 	e.getASNLists(ctx)
 	e.getIPLists(ctx)
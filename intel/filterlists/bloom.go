@@ -6,17 +6,23 @@
 	"strings"
 	"sync"
 
-	"github.com/tannerryan/ring"
+	"github.com/safing/portbase/database"
 	"github.com/safing/portbase/database/record"
 	"github.com/safing/portbase/log"
+	"github.com/tannerryan/ring"
 )
 
-var defaultFilter = newScopedBloom()
+// defaultFilter holds the bloom filters of the active generation. It is
+// replaced as a whole when a new generation is swapped in and must only be
+// accessed while holding filterListLock.
+var defaultFilter = newScopedBloom("")
 
 // scopedBloom is a wrapper around a bloomfilter implementation
 // providing scoped filters for different entity types.
 type scopedBloom struct {
-	rw      sync.RWMutex
+	rw         sync.RWMutex
+	generation string
+
 	domain  *ring.Ring
 	asn     *ring.Ring
 	country *ring.Ring
@@ -24,7 +30,7 @@ type scopedBloom struct {
 	ipv6    *ring.Ring
 }
 
-func newScopedBloom() *scopedBloom {
+func newScopedBloom(generation string) *scopedBloom {
 	mustInit := func(size int) *ring.Ring {
 		f, err := ring.Init(size, bfFalsePositiveRate)
 		if err != nil {
@@ -36,11 +42,12 @@ func newScopedBloom() *scopedBloom {
 		return f
 	}
 	return &scopedBloom{
-		domain:  mustInit(domainBfSize),
-		asn:     mustInit(asnBfSize),
-		country: mustInit(countryBfSize),
-		ipv4:    mustInit(ipv4BfSize),
-		ipv6:    mustInit(ipv6BfSize),
+		generation: generation,
+		domain:     mustInit(domainBfSize),
+		asn:        mustInit(asnBfSize),
+		country:    mustInit(countryBfSize),
+		ipv4:       mustInit(ipv4BfSize),
+		ipv6:       mustInit(ipv6BfSize),
 	}
 }
 
@@ -66,8 +73,8 @@ func (bf *scopedBloom) getBloomForType(entityType string) (*ring.Ring, error) {
 }
 
 func (bf *scopedBloom) add(scope, value string) {
-	bf.rw.RLock()
-	defer bf.rw.RUnlock()
+	bf.rw.Lock()
+	defer bf.rw.Unlock()
 
 	r, err := bf.getBloomForType(scope)
 	if err != nil {
@@ -100,19 +107,19 @@ func (bf *scopedBloom) loadFromCache() error {
 	bf.rw.Lock()
 	defer bf.rw.Unlock()
 
-	if err := loadBloomFromCache(bf.domain, "domain"); err != nil {
+	if err := loadBloomFromCache(bf.domain, bf.generation, "domain"); err != nil {
 		return err
 	}
-	if err := loadBloomFromCache(bf.asn, "asn"); err != nil {
+	if err := loadBloomFromCache(bf.asn, bf.generation, "asn"); err != nil {
 		return err
 	}
-	if err := loadBloomFromCache(bf.country, "country"); err != nil {
+	if err := loadBloomFromCache(bf.country, bf.generation, "country"); err != nil {
 		return err
 	}
-	if err := loadBloomFromCache(bf.ipv4, "ipv4"); err != nil {
+	if err := loadBloomFromCache(bf.ipv4, bf.generation, "ipv4"); err != nil {
 		return err
 	}
-	if err := loadBloomFromCache(bf.ipv6, "ipv6"); err != nil {
+	if err := loadBloomFromCache(bf.ipv6, bf.generation, "ipv6"); err != nil {
 		return err
 	}
 
@@ -123,37 +130,35 @@ func (bf *scopedBloom) saveToCache() error {
 	bf.rw.RLock()
 	defer bf.rw.RUnlock()
 
-	if err := saveBloomToCache(bf.domain, "domain"); err != nil {
+	if err := saveBloomToCache(bf.domain, bf.generation, "domain"); err != nil {
 		return err
 	}
-	if err := saveBloomToCache(bf.asn, "asn"); err != nil {
+	if err := saveBloomToCache(bf.asn, bf.generation, "asn"); err != nil {
 		return err
 	}
-	if err := saveBloomToCache(bf.country, "country"); err != nil {
+	if err := saveBloomToCache(bf.country, bf.generation, "country"); err != nil {
 		return err
 	}
-	if err := saveBloomToCache(bf.ipv4, "ipv4"); err != nil {
+	if err := saveBloomToCache(bf.ipv4, bf.generation, "ipv4"); err != nil {
 		return err
 	}
-	if err := saveBloomToCache(bf.ipv6, "ipv6"); err != nil {
+	if err := saveBloomToCache(bf.ipv6, bf.generation, "ipv6"); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (bf *scopedBloom) replaceWith(other *scopedBloom) {
-	bf.rw.Lock()
-	defer bf.rw.Unlock()
-
-	other.rw.RLock()
-	defer other.rw.RUnlock()
-
-	bf.domain = other.domain
-	bf.asn = other.asn
-	bf.country = other.country
-	bf.ipv4 = other.ipv4
-	bf.ipv6 = other.ipv6
+// deleteFromCache deletes the bloom filters of the generation from the cache
+// db.
+func (bf *scopedBloom) deleteFromCache() error {
+	for _, scope := range []string{"domain", "asn", "country", "ipv4", "ipv6"} {
+		err := cache.DB().Delete(makeBloomCacheKey(bf.generation, scope))
+		if err != nil && err != database.ErrNotFound {
+			return err
+		}
+	}
+	return nil
 }
 
 type bloomFilterRecord struct {
@@ -164,9 +169,9 @@ type bloomFilterRecord struct {
 }
 
 // loadBloomFromCache loads the bloom filter stored under scope
-// into bf.
-func loadBloomFromCache(bf *ring.Ring, scope string) error {
-	r, err := cache.Get(makeBloomCacheKey(scope))
+// of generation into bf.
+func loadBloomFromCache(bf *ring.Ring, generation, scope string) error {
+	r, err := cache.DB().Get(makeBloomCacheKey(generation, scope))
 	if err != nil {
 		return err
 	}
@@ -198,8 +203,8 @@ func loadBloomFromCache(bf *ring.Ring, scope string) error {
 }
 
 // saveBloomToCache saves the bitset of the bloomfilter bf
-// in the cache db.
-func saveBloomToCache(bf *ring.Ring, scope string) error {
+// in the cache db under scope of generation.
+func saveBloomToCache(bf *ring.Ring, generation, scope string) error {
 	blob, err := bf.MarshalBinary()
 	if err != nil {
 		return err
@@ -211,7 +216,7 @@ func saveBloomToCache(bf *ring.Ring, scope string) error {
 		Filter: filter,
 	}
 
-	r.SetKey(makeBloomCacheKey(scope))
+	r.SetKey(makeBloomCacheKey(generation, scope))
 
-	return cache.Put(r)
+	return cache.DB().Put(r)
 }
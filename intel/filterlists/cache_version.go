@@ -16,52 +16,54 @@ type cacheVersionRecord struct {
 	record.Base
 	sync.Mutex
 
-	Version string
-	Reset   string
+	Version    string
+	Reset      string
+	Generation string
 }
 
 // getCacheDatabaseVersion reads and returns the cache
-// database version record.
-func getCacheDatabaseVersion() (*version.Version, error) {
-	r, err := cache.Get(filterListCacheVersionKey)
+// database version and the active generation.
+func getCacheDatabaseVersion() (*version.Version, string, error) {
+	r, err := cache.DB().Get(filterListCacheVersionKey)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	var verRecord *cacheVersionRecord
 	if r.IsWrapped() {
 		verRecord = new(cacheVersionRecord)
 		if err := record.Unwrap(r, verRecord); err != nil {
-			return nil, err
+			return nil, "", err
 		}
 	} else {
 		var ok bool
 		verRecord, ok = r.(*cacheVersionRecord)
 		if !ok {
-			return nil, fmt.Errorf("invalid type, expected cacheVersionRecord but got %T", r)
+			return nil, "", fmt.Errorf("invalid type, expected cacheVersionRecord but got %T", r)
 		}
 	}
 
 	if verRecord.Reset != resetVersion {
-		return nil, database.ErrNotFound
+		return nil, "", database.ErrNotFound
 	}
 
 	ver, err := version.NewSemver(verRecord.Version)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	return ver, nil
+	return ver, verRecord.Generation, nil
 }
 
 // setCacheDatabaseVersion updates the cache database
-// version record to ver.
-func setCacheDatabaseVersion(ver string) error {
+// version record to ver and the active generation.
+func setCacheDatabaseVersion(ver, generation string) error {
 	verRecord := &cacheVersionRecord{
-		Version: ver,
-		Reset:   resetVersion,
+		Version:    ver,
+		Reset:      resetVersion,
+		Generation: generation,
 	}
 
 	verRecord.SetKey(filterListCacheVersionKey)
-	return cache.Put(verRecord)
+	return cache.DB().Put(verRecord)
 }
@@ -13,6 +13,7 @@
 	"github.com/safing/portbase/database/record"
 	"github.com/safing/portbase/log"
 	"github.com/safing/portbase/updater"
+	"github.com/safing/portmaster/caches"
 	"github.com/safing/portmaster/updates"
 	"golang.org/x/sync/errgroup"
 )
@@ -47,10 +48,11 @@
 )
 
 var (
-	cache = database.NewInterface(&database.Options{
+	// Cache entity records, as the same domains and IPs are looked up often.
+	cache = caches.NewDatabaseCache("filter list", 1, 256, &database.Options{
 		Local:     true,
 		Internal:  true,
-		CacheSize: 2 ^ 8,
+		CacheSize: 256,
 	})
 )
 
@@ -78,8 +80,15 @@ func isLoaded() bool {
 	}
 }
 
+// IsLoaded returns whether the filter lists are loaded and ready to be used
+// for lookups.
+func IsLoaded() bool {
+	return isLoaded()
+}
+
 // processListFile opens the latest version of file and decodes it's DSDL
-// content. It calls processEntry for each decoded filterlists entry.
+// content. It calls processEntry for each decoded filterlists entry, which
+// adds it to the generation of filter.
 func processListFile(ctx context.Context, filter *scopedBloom, file *updater.File) error {
 	f, err := os.Open(file.Path())
 	if err != nil {
@@ -217,7 +226,7 @@ func processEntry(ctx context.Context, filter *scopedBloom, entry *listEntry, re
 		r.Meta().Delete()
 	}
 
-	key := makeListCacheKey(strings.ToLower(r.Type), r.Value)
+	key := makeListCacheKey(filter.generation, strings.ToLower(r.Type), r.Value)
 	r.SetKey(key)
 
 	select {
@@ -140,7 +140,7 @@ func (index *ListIndexFile) getDistictSourceIDs(ids ...string) []string {
 }
 
 func getListIndexFromCache() (*ListIndexFile, error) {
-	r, err := cache.Get(filterListIndexKey)
+	r, err := cache.DB().Get(filterListIndexKey)
 	if err != nil {
 		return nil, err
 	}
@@ -220,7 +220,7 @@ func updateListIndex() error {
 	}
 	index.SetKey(filterListIndexKey)
 
-	if err := cache.Put(index); err != nil {
+	if err := cache.DB().Put(index); err != nil {
 		return err
 	}
 	log.Debugf("intel/filterlists: updated list index in cache to %s", index.Version)
@@ -17,10 +17,26 @@
 	filterListKeyPrefix = cacheDBPrefix + "/lists/"
 )
 
-func makeBloomCacheKey(scope string) string {
-	return cacheDBPrefix + "/bloom/" + scope
+// Filter list entries and bloom filters are stored per generation. Every base
+// update creates a new generation alongside the active one, so that lookups
+// continue to use the complete previous data until the new generation is
+// swapped in. The empty generation refers to the layout used before
+// generations were introduced.
+
+func makeBloomCacheKey(generation, scope string) string {
+	if generation == "" {
+		return cacheDBPrefix + "/bloom/" + scope
+	}
+	return cacheDBPrefix + "/bloom/" + generation + "/" + scope
+}
+
+func makeListCachePrefix(generation string) string {
+	if generation == "" {
+		return filterListKeyPrefix
+	}
+	return filterListKeyPrefix + generation + "/"
 }
 
-func makeListCacheKey(scope, key string) string {
-	return filterListKeyPrefix + scope + "/" + key
+func makeListCacheKey(generation, scope, key string) string {
+	return makeListCachePrefix(generation) + scope + "/" + key
 }
@@ -14,22 +14,25 @@
 // key does not exist, instead, an empty slice is
 // returned.
 func lookupBlockLists(entity, value string) ([]string, error) {
-	key := makeListCacheKey(entity, value)
 	if !isLoaded() {
-		log.Warningf("intel/filterlists: not searching for %s because filterlists not loaded", key)
+		log.Warningf("intel/filterlists: not searching for %s %s because filterlists not loaded", entity, value)
 		// filterLists have not yet been loaded so
 		// there's no point querying into the cache
 		// database.
 		return nil, nil
 	}
 
+	// Only hold the lock to get the active generation, so that swapping in a
+	// new generation does not wait for lookups.
 	filterListLock.RLock()
-	defer filterListLock.RUnlock()
+	filter := defaultFilter
+	filterListLock.RUnlock()
 
-	if !defaultFilter.test(entity, value) {
+	if !filter.test(entity, value) {
 		return nil, nil
 	}
 
+	key := makeListCacheKey(filter.generation, entity, value)
 	log.Debugf("intel/filterlists: searching for entries with %s", key)
 	entry, err := getEntityRecordByKey(key)
 	if err != nil {
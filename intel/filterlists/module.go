@@ -16,6 +16,9 @@
 )
 
 const (
+	// ListsUpdatedEvent is emitted after the filter lists were updated.
+	ListsUpdatedEvent = "lists updated"
+
 	filterlistsDisabled          = "filterlists:disabled"
 	filterlistsUpdateFailed      = "filterlists:update-failed"
 	filterlistsStaleDataSurvived = "filterlists:staledata"
@@ -33,6 +36,7 @@ func init() {
 	ignoreNetEnvEvents.Set()
 
 	module = modules.Register("filterlists", prep, start, stop, "base", "updates")
+	module.RegisterEvent(ListsUpdatedEvent, false)
 }
 
 func prep() error {
@@ -78,10 +82,11 @@ func start() error {
 	filterListLock.Lock()
 	defer filterListLock.Unlock()
 
-	ver, err := getCacheDatabaseVersion()
+	ver, generation, err := getCacheDatabaseVersion()
 	if err == nil {
 		log.Debugf("intel/filterlists: cache database has version %s", ver.String())
 
+		defaultFilter = newScopedBloom(generation)
 		if err = defaultFilter.loadFromCache(); err != nil {
 			err = fmt.Errorf("failed to initialize bloom filters: %w", err)
 		}
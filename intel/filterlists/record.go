@@ -18,7 +18,7 @@ type entityRecord struct {
 }
 
 func getEntityRecordByKey(key string) (*entityRecord, error) {
-	r, err := cache.Get(key)
+	r, err := cache.DB().Get(key)
 	if err != nil {
 		return nil, err
 	}
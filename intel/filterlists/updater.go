@@ -64,8 +64,12 @@ func performUpdate(ctx context.Context) error {
 		return nil
 	}
 
-	cleanupRequired := false
-	filterToUpdate := defaultFilter
+	filterListLock.RLock()
+	activeFilter := defaultFilter
+	filterListLock.RUnlock()
+
+	filterToUpdate := activeFilter
+	updateStarted := time.Now()
 
 	// perform the actual upgrade by processing each file
 	// in the returned order.
@@ -80,83 +84,104 @@ func performUpdate(ctx context.Context) error {
 				// CPU and IO resources for nothing when processing
 				// the previous files.
 			}
-			cleanupRequired = true
 
-			// since we are processing a base update we will create our
-			// bloom filters from scratch.
-			filterToUpdate = newScopedBloom()
+			// since we are processing a base update we will build a new
+			// generation from scratch alongside the active one, which is
+			// still used for lookups until the new one is complete.
+			filterToUpdate = newScopedBloom(newGeneration())
 		}
 
 		if err := processListFile(ctx, filterToUpdate, file); err != nil {
+			if filterToUpdate != activeFilter {
+				discardGeneration(filterToUpdate)
+			}
 			return fmt.Errorf("failed to process upgrade %s: %w", file.Identifier(), err)
 		}
 	}
 
-	if filterToUpdate != defaultFilter {
-		// replace the bloom filters in our default
-		// filter.
-		defaultFilter.replaceWith(filterToUpdate)
+	if err := filterToUpdate.saveToCache(); err != nil {
+		// just handle the error by logging as it's only consequence
+		// is that we will need to reprocess all files during the next
+		// start.
+		log.Errorf("intel/filterlists: failed to persist bloom filters in cache database: %s", err)
 	}
 
+	// try to save the highest version of our files.
+	highestVersion := upgradables[len(upgradables)-1]
+	if err := setCacheDatabaseVersion(highestVersion.Version(), filterToUpdate.generation); err != nil {
+		log.Errorf("intel/filterlists: failed to save cache database version: %s", err)
+	} else {
+		log.Infof("intel/filterlists: successfully migrated cache database to %s", highestVersion.Version())
+	}
+
+	// Swap in the new generation. From now on, lookups use the new data.
+	filterListLock.Lock()
+	defaultFilter = filterToUpdate
+	filterListLock.Unlock()
+
 	// from now on, the database is ready and can be used if
 	// it wasn't loaded yet.
 	if !isLoaded() {
 		close(filterListsLoaded)
 	}
 
-	if err := defaultFilter.saveToCache(); err != nil {
-		// just handle the error by logging as it's only consequence
-		// is that we will need to reprocess all files during the next
-		// start.
-		log.Errorf("intel/filterlists: failed to persist bloom filters in cache database: %s", err)
-	}
-
-	// if we processed the base file we need to perform
-	// some cleanup on filterlists entities that have not
-	// been updated now. Once we are done, start a worker
-	// for that purpose.
-	if cleanupRequired {
-		if err := module.RunWorker("filterlists:cleanup", removeAllObsoleteFilterEntries); err != nil {
-			// if we failed to remove all stale cache entries
-			// we abort now WITHOUT updating the database version. This means
-			// we'll try again during the next update.
+	// if we built a new generation we need to remove the
+	// previous one, which is not used anymore.
+	if filterToUpdate != activeFilter {
+		err := module.RunWorker("filterlists:cleanup", func(ctx context.Context) error {
+			return removeGeneration(ctx, activeFilter, updateStarted)
+		})
+		if err != nil {
+			// The stale data of the previous generation is not used for
+			// lookups anymore, it just takes up space.
 			module.Warning(
 				filterlistsStaleDataSurvived,
-				"Filter Lists May Overblock",
-				fmt.Sprintf("The Portmaster failed to delete outdated filter list data. Filtering capabilities are fully available, but overblocking may occur. Error: %s", err.Error()),
+				"Filter Lists Cleanup Failed",
+				fmt.Sprintf("The Portmaster failed to delete outdated filter list data. Filtering capabilities are fully available, but outdated data takes up disk space. Error: %s", err.Error()),
 			)
 			return fmt.Errorf("failed to cleanup stale cache records: %w", err)
 		}
 	}
 
-	// try to save the highest version of our files.
-	highestVersion := upgradables[len(upgradables)-1]
-	if err := setCacheDatabaseVersion(highestVersion.Version()); err != nil {
-		log.Errorf("intel/filterlists: failed to save cache database version: %s", err)
-	} else {
-		log.Infof("intel/filterlists: successfully migrated cache database to %s", highestVersion.Version())
-	}
-
 	// The list update suceeded, resolve any states.
 	module.Resolve("")
+	module.TriggerEvent(ListsUpdatedEvent, nil)
 	return nil
 }
 
-func removeAllObsoleteFilterEntries(ctx context.Context) error {
+// newGeneration returns a new unique generation name.
+func newGeneration() string {
+	return fmt.Sprintf("gen%d", time.Now().UnixNano())
+}
+
+// removeGeneration removes the filter list entries and bloom filters of the
+// generation of filter. Only entries updated before the given time are
+// removed, as the entries of the legacy generation share their key prefix
+// with the other generations.
+func removeGeneration(ctx context.Context, filter *scopedBloom, before time.Time) error {
 	log.Debugf("intel/filterlists: cleanup task started, removing obsolete filter list entries ...")
-	n, err := cache.Purge(ctx, query.New(filterListKeyPrefix).Where(
-		// TODO(ppacher): remember the timestamp we started the last update
-		// and use that rather than "one hour ago"
-		query.Where("UpdatedAt", query.LessThan, time.Now().Add(-time.Hour).Unix()),
+	n, err := cache.DB().Purge(ctx, query.New(makeListCachePrefix(filter.generation)).Where(
+		query.Where("UpdatedAt", query.LessThan, before.Unix()),
 	))
 	if err != nil {
 		return err
 	}
+	if err := filter.deleteFromCache(); err != nil {
+		return err
+	}
 
 	log.Debugf("intel/filterlists: successfully removed %d obsolete entries", n)
 	return nil
 }
 
+// discardGeneration removes the incomplete generation of filter in the
+// background.
+func discardGeneration(filter *scopedBloom) {
+	module.StartWorker("filterlists:discard", func(ctx context.Context) error {
+		return removeGeneration(ctx, filter, time.Now().Add(time.Hour))
+	})
+}
+
 // getUpgradableFiles returns a slice of filterlists files
 // that should be updated. The files MUST be updated and
 // processed in the returned order!
@@ -214,7 +239,7 @@ func resolveUpdateOrder(updateOrder []*updater.File) ([]*updater.File, error) {
 		cacheDBVersion, _ = version.NewSemver("v0.0.0")
 	} else {
 		var err error
-		cacheDBVersion, err = getCacheDatabaseVersion()
+		cacheDBVersion, _, err = getCacheDatabaseVersion()
 		if err != nil {
 			if err != database.ErrNotFound {
 				log.Errorf("intel/filterlists: failed to get cache database version: %s", err)
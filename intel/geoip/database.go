@@ -1,6 +1,7 @@
 package geoip
 
 import (
+	"context"
 	"fmt"
 	"sync"
 
@@ -13,17 +14,27 @@
 	"github.com/safing/portmaster/updates"
 )
 
+// geoIPDB holds a set of opened GeoIP databases.
+type geoIPDB struct {
+	v4File   *updater.File
+	v6File   *updater.File
+	v4Reader *maxminddb.Reader
+	v6Reader *maxminddb.Reader
+}
+
 var (
-	geoDBv4File *updater.File
-	geoDBv6File *updater.File
-	dbFileLock  sync.Mutex
+	// activeDB holds the databases used for lookups. Lookups hold a read lock
+	// while using the readers, so that they are not closed during a lookup.
+	// New databases are opened alongside the active ones and swapped in, so
+	// lookups do not wait while databases are being loaded.
+	activeDB *geoIPDB
+	dbLock   sync.RWMutex
 
-	geoDBv4Reader *maxminddb.Reader
-	geoDBv6Reader *maxminddb.Reader
-	dbLock        sync.Mutex
+	// loadLock serializes loading new databases.
+	loadLock sync.Mutex
 
 	dbInUse    = abool.NewBool(false) // only activate if used for first time
-	dbDoReload = abool.NewBool(true)  // if database should be reloaded
+	dbDoReload = abool.NewBool(false) // if database should be reloaded
 )
 
 // ReloadDatabases reloads the geoip database, if they are in use.
@@ -33,85 +44,121 @@ func ReloadDatabases() error {
 		return nil
 	}
 
-	dbFileLock.Lock()
-	defer dbFileLock.Unlock()
-	dbLock.Lock()
-	defer dbLock.Unlock()
-
 	dbDoReload.Set()
 	return doReload()
 }
 
 func prepDatabaseForUse() error {
 	dbInUse.Set()
+
+	dbLock.RLock()
+	loaded := activeDB != nil
+	dbLock.RUnlock()
+	if loaded {
+		return nil
+	}
+
 	return doReload()
 }
 
 func doReload() error {
-	// reload if needed
-	if dbDoReload.SetToIf(true, false) {
-		closeDBs()
-		if err := openDBs(); err != nil {
-			// try again the next time
-			dbDoReload.SetTo(true)
-			return err
-		}
+	loadLock.Lock()
+	defer loadLock.Unlock()
+
+	// The active databases are only replaced while holding loadLock.
+	if activeDB != nil && !dbDoReload.IsSet() {
+		return nil
+	}
+
+	// Open the new databases while the previous ones are still in use. If
+	// this fails, the previous databases continue to be used.
+	newDB, err := openDBs()
+	if err != nil {
+		return err
 	}
 
+	dbLock.Lock()
+	oldDB := activeDB
+	activeDB = newDB
+	dbDoReload.UnSet()
+	dbLock.Unlock()
+
+	// No lookups use the previous databases anymore.
+	if oldDB != nil {
+		oldDB.close()
+	}
 	return nil
 }
 
-func openDBs() error {
+func openDBs() (*geoIPDB, error) {
+	db := &geoIPDB{}
 	var err error
 
-	geoDBv4File, err = updates.GetFile("intel/geoip/geoipv4.mmdb.gz")
+	db.v4File, err = updates.GetFile("intel/geoip/geoipv4.mmdb.gz")
 	if err != nil {
-		return fmt.Errorf("could not get GeoIP v4 database file: %s", err)
+		return nil, fmt.Errorf("could not get GeoIP v4 database file: %s", err)
 	}
-	unpackedV4, err := geoDBv4File.Unpack(".gz", updater.UnpackGZIP)
+	unpackedV4, err := db.v4File.Unpack(".gz", updater.UnpackGZIP)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	geoDBv4Reader, err = maxminddb.Open(unpackedV4)
+	db.v4Reader, err = maxminddb.Open(unpackedV4)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	geoDBv6File, err = updates.GetFile("intel/geoip/geoipv6.mmdb.gz")
+	db.v6File, err = updates.GetFile("intel/geoip/geoipv6.mmdb.gz")
 	if err != nil {
-		return fmt.Errorf("could not get GeoIP v6 database file: %s", err)
+		db.close()
+		return nil, fmt.Errorf("could not get GeoIP v6 database file: %s", err)
 	}
-	unpackedV6, err := geoDBv6File.Unpack(".gz", updater.UnpackGZIP)
+	unpackedV6, err := db.v6File.Unpack(".gz", updater.UnpackGZIP)
 	if err != nil {
-		return err
+		db.close()
+		return nil, err
 	}
-	geoDBv6Reader, err = maxminddb.Open(unpackedV6)
+	db.v6Reader, err = maxminddb.Open(unpackedV6)
 	if err != nil {
-		return err
+		db.close()
+		return nil, err
 	}
 
-	return nil
+	return db, nil
 }
 
-func handleError(err error) {
-	log.Errorf("network/geoip: lookup failed, reloading databases: %s", err)
-	dbDoReload.Set()
+// upgradeAvailable returns whether an upgrade is available for any of the
+// databases.
+func (db *geoIPDB) upgradeAvailable() bool {
+	return db.v4File.UpgradeAvailable() || db.v6File.UpgradeAvailable()
 }
 
-func closeDBs() {
-	if geoDBv4Reader != nil {
-		err := geoDBv4Reader.Close()
+func (db *geoIPDB) close() {
+	if db.v4Reader != nil {
+		err := db.v4Reader.Close()
 		if err != nil {
 			log.Warningf("network/geoip: failed to close database: %s", err)
 		}
 	}
-	geoDBv4Reader = nil
+	db.v4Reader = nil
 
-	if geoDBv6Reader != nil {
-		err := geoDBv6Reader.Close()
+	if db.v6Reader != nil {
+		err := db.v6Reader.Close()
 		if err != nil {
 			log.Warningf("network/geoip: failed to close database: %s", err)
 		}
 	}
-	geoDBv6Reader = nil
+	db.v6Reader = nil
+}
+
+// handleError reloads the databases in the background after a failed lookup.
+func handleError(err error) {
+	if !dbDoReload.SetToIf(false, true) {
+		// Reload already pending.
+		return
+	}
+
+	log.Errorf("network/geoip: lookup failed, reloading databases: %s", err)
+	module.StartWorker("reload geoip databases", func(_ context.Context) error {
+		return doReload()
+	})
 }
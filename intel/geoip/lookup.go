@@ -1,50 +1,41 @@
 package geoip
 
 import (
+	"errors"
 	"net"
 
 	"github.com/oschwald/maxminddb-golang"
 )
 
-func getReader(ip net.IP) *maxminddb.Reader {
+func (db *geoIPDB) getReader(ip net.IP) *maxminddb.Reader {
 	if v4 := ip.To4(); v4 != nil {
-		return geoDBv4Reader
+		return db.v4Reader
 	}
-	return geoDBv6Reader
+	return db.v6Reader
 }
 
 // GetLocation returns Location data of an IP address
 func GetLocation(ip net.IP) (record *Location, err error) {
-	dbLock.Lock()
-	defer dbLock.Unlock()
-
 	err = prepDatabaseForUse()
 	if err != nil {
 		return nil, err
 	}
 
-	db := getReader(ip)
+	dbLock.RLock()
+	defer dbLock.RUnlock()
+
+	if activeDB == nil {
+		return nil, errors.New("geoip databases not loaded")
+	}
 
 	record = &Location{}
 
 	// fetch
-	err = db.Lookup(ip, record)
-
-	// retry
+	err = activeDB.getReader(ip).Lookup(ip, record)
 	if err != nil {
-		// reprep
+		// Reload the databases in the background, the next lookup will use
+		// them as soon as they are loaded.
 		handleError(err)
-		err = prepDatabaseForUse()
-		if err != nil {
-			return nil, err
-		}
-		db = getReader(ip)
-
-		// refetch
-		err = db.Lookup(ip, record)
-	}
-
-	if err != nil {
 		return nil, err
 	}
 
@@ -25,15 +25,9 @@ func prep() error {
 }
 
 func upgradeDatabases(_ context.Context, _ interface{}) error {
-	dbFileLock.Lock()
-	reload := false
-	if geoDBv4File != nil && geoDBv4File.UpgradeAvailable() {
-		reload = true
-	}
-	if geoDBv6File != nil && geoDBv6File.UpgradeAvailable() {
-		reload = true
-	}
-	dbFileLock.Unlock()
+	dbLock.RLock()
+	reload := activeDB != nil && activeDB.upgradeAvailable()
+	dbLock.RUnlock()
 
 	if reload {
 		return ReloadDatabases()
@@ -10,5 +10,5 @@
 )
 
 func init() {
-	Module = modules.Register("intel", nil, nil, nil, "geoip", "filterlists")
+	Module = modules.Register("intel", nil, nil, nil, "geoip", "filterlists", "classification")
 }
@@ -0,0 +1,66 @@
+package l10n
+
+import (
+	"net"
+	"strings"
+)
+
+// Screen readers often read domains, addresses and abbreviations as a single
+// word or skip punctuation altogether. When accessible texts are enabled,
+// these are spelled out and abbreviations are avoided.
+
+// AccessibleText returns whether screen reader friendly texts should be
+// generated.
+func AccessibleText() bool {
+	return cfgAccessibleText()
+}
+
+// SpellDomain returns the given domain with its punctuation spelled out, eg.
+// "www dot example dot com".
+func SpellDomain(domain string) string {
+	domain = strings.TrimSuffix(domain, ".")
+	return strings.NewReplacer(
+		".", " "+T("dot")+" ",
+		"-", " "+T("dash")+" ",
+		"_", " "+T("underscore")+" ",
+	).Replace(domain)
+}
+
+// SpellIP returns the given IP address with its punctuation spelled out.
+func SpellIP(ip net.IP) string {
+	if ip == nil {
+		return T("unknown address")
+	}
+	return strings.NewReplacer(
+		".", " "+T("dot")+" ",
+		":", " "+T("colon")+" ",
+	).Replace(ip.String())
+}
+
+// ProtocolName returns the full name of the given IP protocol number.
+func ProtocolName(protocol uint8) string {
+	switch protocol {
+	case 1:
+		return T("Internet Control Message Protocol")
+	case 6:
+		return T("Transmission Control Protocol")
+	case 17:
+		return T("User Datagram Protocol")
+	case 58:
+		return T("Internet Control Message Protocol version 6")
+	case 136:
+		return T("Lightweight User Datagram Protocol")
+	default:
+		return Sprintf("protocol number %d", protocol)
+	}
+}
+
+// DescribeEndpoint returns a screen reader friendly description of an IP
+// address, protocol and port, eg. "the address 10 dot 0 dot 0 dot 1, using
+// the Transmission Control Protocol on port 443".
+func DescribeEndpoint(ip net.IP, protocol uint8, port uint16) string {
+	if port == 0 {
+		return Sprintf("the address %s, using the %s", SpellIP(ip), ProtocolName(protocol))
+	}
+	return Sprintf("the address %s, using the %s on port %d", SpellIP(ip), ProtocolName(protocol), port)
+}
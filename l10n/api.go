@@ -0,0 +1,80 @@
+package l10n
+
+import (
+	"fmt"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/config"
+)
+
+// OptionText holds the localized texts of a config option. Possible values
+// are keyed by their value.
+type OptionText struct {
+	Name           string
+	Description    string
+	Help           string                        `json:",omitempty"`
+	PossibleValues map[string]*PossibleValueText `json:",omitempty"`
+}
+
+// PossibleValueText holds the localized texts of a possible value of a config
+// option.
+type PossibleValueText struct {
+	Name        string
+	Description string `json:",omitempty"`
+}
+
+func registerAPIEndpoints() error {
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "l10n/catalog",
+		Read:      api.PermitUser,
+		BelongsTo: module,
+		StructFunc: func(ar *api.Request) (i interface{}, err error) {
+			return GetCatalog(), nil
+		},
+		Name:        "Get Language Catalog",
+		Description: "Returns the translations of the configured language, keyed by the English text. Block reasons of connections are translated by the Portmaster already.",
+	}); err != nil {
+		return err
+	}
+
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:      "l10n/options",
+		Read:      api.PermitUser,
+		BelongsTo: module,
+		StructFunc: func(ar *api.Request) (i interface{}, err error) {
+			return getOptionTexts(), nil
+		},
+		Name:        "Get Localized Setting Texts",
+		Description: "Returns the names and descriptions of all settings and the descriptions of their possible values in the configured language, keyed by the setting key.",
+	})
+}
+
+// getOptionTexts returns the localized texts of all config options. Texts
+// without a translation are returned in English.
+func getOptionTexts() map[string]*OptionText {
+	texts := make(map[string]*OptionText)
+	_ = config.ForEachOption(func(opt *config.Option) error {
+		text := &OptionText{
+			Name:        T(opt.Name),
+			Description: T(opt.Description),
+		}
+		if opt.Help != "" {
+			text.Help = T(opt.Help)
+		}
+		if len(opt.PossibleValues) > 0 {
+			text.PossibleValues = make(map[string]*PossibleValueText, len(opt.PossibleValues))
+			for _, pv := range opt.PossibleValues {
+				pvText := &PossibleValueText{
+					Name: T(pv.Name),
+				}
+				if pv.Description != "" {
+					pvText.Description = T(pv.Description)
+				}
+				text.PossibleValues[fmt.Sprint(pv.Value)] = pvText
+			}
+		}
+		texts[opt.Key] = text
+		return nil
+	})
+	return texts
+}
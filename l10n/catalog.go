@@ -0,0 +1,130 @@
+package l10n
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/updater"
+	"github.com/safing/portmaster/updates"
+)
+
+// The catalog is delivered via the updates registry and maps languages to
+// their translations. Translations are keyed by the original English text, so
+// that texts without a translation are simply shown in English.
+
+const (
+	defaultLanguage   = "en"
+	catalogIdentifier = "l10n/catalog.json"
+)
+
+var (
+	catalogFile     *updater.File
+	catalogLanguage string
+	translations    map[string]string
+	catalogLock     sync.RWMutex
+)
+
+// Catalog holds the translations of the active language.
+type Catalog struct {
+	Language string
+	Strings  map[string]string
+}
+
+func loadCatalog(onlyIfUpgraded bool) {
+	lang := cfgLanguage()
+
+	catalogLock.Lock()
+	defer catalogLock.Unlock()
+
+	switch {
+	case onlyIfUpgraded && (catalogFile == nil || !catalogFile.UpgradeAvailable()):
+		return
+	case !onlyIfUpgraded && lang == catalogLanguage:
+		return
+	case lang == defaultLanguage:
+		catalogFile = nil
+		catalogLanguage = lang
+		translations = nil
+		return
+	}
+
+	file, err := updates.GetFile(catalogIdentifier)
+	if err != nil {
+		log.Warningf("l10n: failed to get language catalog: %s", err)
+		return
+	}
+	data, err := ioutil.ReadFile(file.Path())
+	if err != nil {
+		log.Warningf("l10n: failed to read language catalog: %s", err)
+		return
+	}
+	languages := make(map[string]map[string]string)
+	if err := json.Unmarshal(data, &languages); err != nil {
+		log.Warningf("l10n: failed to parse language catalog: %s", err)
+		return
+	}
+
+	catalogFile = file
+	catalogLanguage = lang
+	translations = selectLanguage(languages, lang)
+	if translations == nil {
+		log.Infof("l10n: no translations available for %s, using English", lang)
+		return
+	}
+	log.Infof("l10n: loaded %d translations for %s", len(translations), lang)
+}
+
+// selectLanguage returns the translations for the given language. If there
+// are no translations for a regional variant (eg. "de-AT"), the translations
+// of the base language are used.
+func selectLanguage(languages map[string]map[string]string, lang string) map[string]string {
+	if t, ok := languages[lang]; ok {
+		return t
+	}
+	if i := strings.IndexByte(lang, '-'); i > 0 {
+		if t, ok := languages[lang[:i]]; ok {
+			return t
+		}
+	}
+	return nil
+}
+
+// T returns the given English text translated to the configured language. If
+// no translation is available, the text is returned unchanged.
+func T(text string) string {
+	catalogLock.RLock()
+	defer catalogLock.RUnlock()
+
+	if translated, ok := translations[text]; ok && translated != "" {
+		return translated
+	}
+	return text
+}
+
+// Sprintf translates the format string and formats it with the given
+// arguments.
+func Sprintf(format string, a ...interface{}) string {
+	return fmt.Sprintf(T(format), a...)
+}
+
+// GetCatalog returns the translations of the active language.
+func GetCatalog() *Catalog {
+	catalogLock.RLock()
+	defer catalogLock.RUnlock()
+
+	c := &Catalog{
+		Language: catalogLanguage,
+		Strings:  make(map[string]string, len(translations)),
+	}
+	if c.Language == "" {
+		c.Language = defaultLanguage
+	}
+	for text, translated := range translations {
+		c.Strings[text] = translated
+	}
+	return c
+}
@@ -0,0 +1,75 @@
+package l10n
+
+import (
+	"testing"
+
+	"github.com/safing/portbase/config"
+)
+
+func TestSelectLanguage(t *testing.T) {
+	t.Parallel()
+
+	languages := map[string]map[string]string{
+		"de":    {"Allow": "Erlauben"},
+		"pt-BR": {"Allow": "Permitir"},
+	}
+
+	testCases := map[string]string{
+		"de":    "Erlauben",
+		"de-AT": "Erlauben",
+		"pt-BR": "Permitir",
+		"pt":    "",
+		"fr":    "",
+	}
+	for lang, expected := range testCases {
+		if translated := selectLanguage(languages, lang)["Allow"]; translated != expected {
+			t.Errorf("%s: expected %q, got %q", lang, expected, translated)
+		}
+	}
+}
+
+func TestSpellDomain(t *testing.T) {
+	t.Parallel()
+
+	if spelled := SpellDomain("my-cdn.example.com."); spelled != "my dash cdn dot example dot com" {
+		t.Errorf("unexpected spelling: %q", spelled)
+	}
+}
+
+func TestOptionTexts(t *testing.T) {
+	if err := config.Register(&config.Option{
+		Name:         "Test Option",
+		Key:          "test/l10n",
+		Description:  "Test description.",
+		OptType:      config.OptTypeString,
+		DefaultValue: "a",
+		PossibleValues: []config.PossibleValue{
+			{Name: "A", Value: "a", Description: "Value A."},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	catalogLock.Lock()
+	translations = map[string]string{
+		"Test Option": "Testoption",
+		"Value A.":    "Wert A.",
+	}
+	catalogLock.Unlock()
+	defer func() {
+		catalogLock.Lock()
+		translations = nil
+		catalogLock.Unlock()
+	}()
+
+	text := getOptionTexts()["test/l10n"]
+	if text == nil {
+		t.Fatal("missing texts of option")
+	}
+	if text.Name != "Testoption" || text.Description != "Test description." {
+		t.Errorf("unexpected option texts: %+v", text)
+	}
+	if pv := text.PossibleValues["a"]; pv == nil || pv.Name != "A" || pv.Description != "Wert A." {
+		t.Errorf("unexpected possible value texts: %+v", pv)
+	}
+}
@@ -0,0 +1,99 @@
+package l10n
+
+import (
+	"context"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/portmaster/updates"
+)
+
+var (
+	module *modules.Module
+
+	// CfgLanguageKey is the config key for the language of texts generated by
+	// the Portmaster.
+	CfgLanguageKey = "core/language"
+	cfgLanguage    config.StringOption
+
+	// CfgAccessibleTextKey is the config key for generating screen reader
+	// friendly texts.
+	CfgAccessibleTextKey = "core/accessibleText"
+	cfgAccessibleText    config.BoolOption
+)
+
+func init() {
+	module = modules.Register("l10n", prep, start, nil, "base", "updates")
+}
+
+func prep() error {
+	if err := config.Register(&config.Option{
+		Name:            "Language",
+		Key:             CfgLanguageKey,
+		Description:     "Language of notifications, block reasons and setting descriptions, as a two letter language code (eg. \"de\"). Falls back to English if no translation is available.",
+		OptType:         config.OptTypeString,
+		ExpertiseLevel:  config.ExpertiseLevelUser,
+		ReleaseLevel:    config.ReleaseLevelBeta,
+		DefaultValue:    defaultLanguage,
+		ValidationRegex: `^[a-z]{2}(-[A-Z]{2})?$`,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 1,
+			config.CategoryAnnotation:     "General",
+		},
+	}); err != nil {
+		return err
+	}
+	cfgLanguage = config.Concurrent.GetAsString(CfgLanguageKey, defaultLanguage)
+
+	if err := config.Register(&config.Option{
+		Name:           "Screen Reader Friendly Texts",
+		Key:            CfgAccessibleTextKey,
+		Description:    "Generate verbose texts for prompts and notifications that work well with screen readers. Domains and addresses are spelled out and abbreviations are avoided.",
+		OptType:        config.OptTypeBool,
+		ExpertiseLevel: config.ExpertiseLevelUser,
+		ReleaseLevel:   config.ReleaseLevelBeta,
+		DefaultValue:   false,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 2,
+			config.CategoryAnnotation:     "General",
+		},
+	}); err != nil {
+		return err
+	}
+	cfgAccessibleText = config.Concurrent.GetAsBool(CfgAccessibleTextKey, false)
+
+	return nil
+}
+
+func start() error {
+	if err := module.RegisterEventHook(
+		"config",
+		"config change",
+		"load language catalog",
+		func(_ context.Context, _ interface{}) error {
+			loadCatalog(false)
+			return nil
+		},
+	); err != nil {
+		return err
+	}
+
+	if err := module.RegisterEventHook(
+		updates.ModuleName,
+		updates.ResourceUpdateEvent,
+		"check for language catalog updates",
+		func(_ context.Context, _ interface{}) error {
+			loadCatalog(true)
+			return nil
+		},
+	); err != nil {
+		return err
+	}
+
+	if err := registerAPIEndpoints(); err != nil {
+		return err
+	}
+
+	loadCatalog(false)
+	return nil
+}
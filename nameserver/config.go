@@ -3,6 +3,7 @@
 import (
 	"flag"
 	"runtime"
+	"strings"
 
 	"github.com/safing/portbase/config"
 	"github.com/safing/portmaster/core"
@@ -11,6 +12,8 @@
 // Config Keys
 const (
 	CfgDefaultNameserverAddressKey = "dns/listenAddress"
+	CfgSystemDNSIntegrationKey     = "dns/systemIntegration"
+	CfgRewriteRulesKey             = "dns/rewriteRules"
 )
 
 var (
@@ -19,6 +22,10 @@
 	nameserverAddressConfig  config.StringOption
 
 	networkServiceMode config.BoolOption
+
+	systemDNSIntegration config.BoolOption
+
+	rewriteRulesConfig config.StringArrayOption
 )
 
 func init() {
@@ -58,5 +65,50 @@ func registerConfig() error {
 
 	networkServiceMode = config.Concurrent.GetAsBool(core.CfgNetworkServiceKey, false)
 
+	if runtime.GOOS == "linux" {
+		err = config.Register(&config.Option{
+			Name:           "Configure System DNS",
+			Key:            CfgSystemDNSIntegrationKey,
+			Description:    "Configure systemd-resolved or NetworkManager to use the Portmaster as the DNS server, instead of relying on intercepting DNS queries only. The previous configuration is restored when the Portmaster stops. Note that DNS queries are then attributed to systemd-resolved instead of the querying app, if it is used.",
+			OptType:        config.OptTypeBool,
+			ExpertiseLevel: config.ExpertiseLevelExpert,
+			ReleaseLevel:   config.ReleaseLevelExperimental,
+			DefaultValue:   false,
+			Annotations: config.Annotations{
+				config.CategoryAnnotation: "Resolving",
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	systemDNSIntegration = config.Concurrent.GetAsBool(CfgSystemDNSIntegrationKey, false)
+
+	err = config.Register(&config.Option{
+		Name: "DNS Rewrite Rules",
+		Key:  CfgRewriteRulesKey,
+		Description: strings.ReplaceAll(`Override the answers for domains before they are resolved, for example to point a domain to a development server or to force apps onto specific endpoints. Every rule has the format "<domain> <type> <value>":
+
+- "dev.example.com A 127.0.0.1": answer with the given IPv4 address
+- "*.example.com AAAA ::1": answer with the given IPv6 address for all subdomains
+- "/^(.+)\.staging\.example\.com$/ CNAME $1.test.example.com": answer with an alias, which is then resolved
+
+Domains enclosed in slashes are regular expressions, whose submatches may be used in the value of CNAME rules. Queries for other types of domains with A or AAAA rules are answered without records. The rules apply to all apps and are checked after the DNS request was permitted.`, `"`, "`"),
+		OptType:         config.OptTypeStringArray,
+		ExpertiseLevel:  config.ExpertiseLevelDeveloper,
+		ReleaseLevel:    config.ReleaseLevelExperimental,
+		DefaultValue:    []string{},
+		ValidationRegex: rewriteRuleValidationRegex,
+		Annotations: config.Annotations{
+			config.DisplayHintAnnotation:  config.DisplayHintOrdered,
+			config.DisplayOrderAnnotation: 515,
+			config.CategoryAnnotation:     "Development",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	rewriteRulesConfig = config.Concurrent.GetAsStringArray(CfgRewriteRulesKey, []string{})
+
 	return nil
 }
@@ -46,6 +46,19 @@ func start() error {
 		return fmt.Errorf("failed to parse nameserver listen address: %w", err)
 	}
 
+	// Configure the system to use the nameserver.
+	listenIPs := []net.IP{ip1}
+	if ip2 != nil {
+		listenIPs = append(listenIPs, ip2)
+	}
+	if err := startSystemDNSIntegration(listenIPs); err != nil {
+		return err
+	}
+
+	if err := startRewriteRules(); err != nil {
+		return err
+	}
+
 	// Start listener(s).
 	if ip2 == nil {
 		// Start a single listener.
@@ -123,6 +136,10 @@ func startListener(ip net.IP, port uint16) *dns.Server {
 }
 
 func stop() error {
+	if err := restoreSystemDNS(); err != nil {
+		log.Warningf("nameserver: failed to restore system DNS configuration: %s", err)
+	}
+
 	if stopListener != nil {
 		if err := stopListener(); err != nil {
 			log.Warningf("nameserver: failed to stop: %s", err)
@@ -5,23 +5,31 @@
 	"errors"
 	"fmt"
 	"net"
+	"runtime/pprof"
 	"strings"
 	"time"
 
 	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/core"
 	"github.com/safing/portmaster/firewall"
 	"github.com/safing/portmaster/nameserver/nsutil"
 	"github.com/safing/portmaster/netenv"
 	"github.com/safing/portmaster/network"
 	"github.com/safing/portmaster/network/netutils"
 	"github.com/safing/portmaster/resolver"
+	"github.com/safing/portmaster/tracing"
 
 	"github.com/miekg/dns"
 )
 
 func handleRequestAsWorker(w dns.ResponseWriter, query *dns.Msg) {
-	err := module.RunWorker("dns request", func(ctx context.Context) error {
-		return handleRequest(ctx, w, query)
+	err := module.RunWorker("dns request", func(ctx context.Context) (err error) {
+		// Label the request for attributing resource usage.
+		labels := pprof.Labels(core.ModuleLabel, module.Name, core.TaskLabel, "dns request")
+		pprof.Do(ctx, labels, func(ctx context.Context) {
+			err = handleRequest(ctx, w, query)
+		})
+		return err
 	})
 	if err != nil {
 		log.Warningf("nameserver: failed to handle dns request: %s", err)
@@ -65,6 +73,11 @@ func handleRequest(ctx context.Context, w dns.ResponseWriter, request *dns.Msg)
 	defer tracer.Submit()
 	tracer.Tracef("nameserver: handling new request for %s from %s:%d", q.ID(), remoteAddr.IP, remoteAddr.Port)
 
+	// Start span for tracing the decision pipeline.
+	ctx, span := tracing.StartSpan(ctx, "dns request")
+	defer span.End()
+	span.SetAttribute("dns.question", q.ID())
+
 	// Check if there are more than one question.
 	if len(request.Question) > 1 {
 		tracer.Warningf("nameserver: received more than one question from (%s:%d), first question is %s", remoteAddr.IP, remoteAddr.Port, q.ID())
@@ -127,6 +140,9 @@ func handleRequest(ctx context.Context, w dns.ResponseWriter, request *dns.Msg)
 			tracer.Warningf("nameserver: failed to get host/profile for request for %s%s: %s", q.FQDN, q.QType, err)
 			return nil // Do no reply, drop request immediately.
 		}
+		if device := conn.ProcessContext.Device; device != "" {
+			tracer.Tracef("nameserver: request for %s is from device %s", q.ID(), device)
+		}
 
 	default:
 		tracer.Warningf("nameserver: external request for %s%s, ignoring", q.FQDN, q.QType)
@@ -187,8 +203,12 @@ func handleRequest(ctx context.Context, w dns.ResponseWriter, request *dns.Msg)
 	// Save security level to query, so that the resolver can react to configuration.
 	q.SecurityLevel = conn.Process().Profile().SecurityLevel()
 
-	// Resolve request.
-	rrCache, err = resolver.Resolve(ctx, q)
+	// Apply rewrite rules or resolve request.
+	var rewritten, synthesized bool
+	rrCache, rewritten, synthesized, err = rewriteQuery(ctx, q)
+	if !rewritten {
+		rrCache, err = resolver.Resolve(ctx, q)
+	}
 	// Handle error.
 	if err != nil {
 		switch {
@@ -223,8 +243,12 @@ func handleRequest(ctx context.Context, w dns.ResponseWriter, request *dns.Msg)
 		return reply(nsutil.NxDomain("no answer found (NXDomain)"))
 	}
 
-	tracer.Trace("nameserver: deciding on resolved dns")
-	rrCache = firewall.FilterResolvedDNS(ctx, conn, q, rrCache)
+	// Answers synthesized from rewrite rules were defined by the user and are
+	// not filtered.
+	if !synthesized {
+		tracer.Trace("nameserver: deciding on resolved dns")
+		rrCache = firewall.FilterResolvedDNS(ctx, conn, q, rrCache)
+	}
 
 	// Check again if there is a responder from the firewall.
 	if responder, ok := conn.Reason.Context.(nsutil.Responder); ok {
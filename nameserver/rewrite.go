@@ -0,0 +1,269 @@
+package nameserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/network/netutils"
+	"github.com/safing/portmaster/resolver"
+)
+
+// DNS rewrite rules override the answers for matching domains before they
+// are resolved upstream. A rule has the format "<domain> <type> <value>":
+//
+//   dev.example.com A 127.0.0.1
+//   *.example.com AAAA ::1
+//   /^(.+)\.staging\.example\.com$/ CNAME $1.test.example.com
+//
+// Domains may be exact, use a "*." wildcard for all subdomains or be a
+// regular expression enclosed in slashes, whose submatches may be used in
+// the value of CNAME rules.
+// A and AAAA rules answer queries with the given IP. Queries for other types
+// are answered without records, so that apps do not use the original
+// records. CNAME rules answer all queries with an alias to the given domain,
+// which is then resolved upstream.
+
+const (
+	rewriteTTL = 60
+
+	rewriteRulesFailed = "nameserver:rewrite-rules-failed"
+)
+
+// rewriteRuleValidationRegex does a basic check of the rule format. Details
+// are checked when the rules are parsed.
+const rewriteRuleValidationRegex = `^\S+ (A|AAAA|CNAME) \S+$`
+
+var (
+	rewriteRules     []*rewriteRule
+	rewriteRulesLock sync.RWMutex
+
+	rewriteResolverInfo = &resolver.ResolverInfo{
+		Name:    "DNS Rewrite Rules",
+		Type:    "rewrite",
+		Source:  resolver.ServerSourceConfigured,
+		IPScope: netutils.HostLocal,
+	}
+)
+
+type rewriteRule struct {
+	// Only one of domain, suffix and regex is set.
+	domain string
+	suffix string
+	regex  *regexp.Regexp
+
+	rrType uint16
+	ip     net.IP
+	target string
+}
+
+func parseRewriteRule(definition string) (*rewriteRule, error) {
+	fields := strings.Fields(definition)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("invalid rule %q: expected format <domain> <type> <value>", definition)
+	}
+	pattern, rrType, value := fields[0], strings.ToUpper(fields[1]), fields[2]
+	rule := &rewriteRule{}
+
+	// Parse domain pattern.
+	switch {
+	case len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/"):
+		regex, err := regexp.Compile("(?i)" + pattern[1:len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %q: invalid regular expression: %w", definition, err)
+		}
+		rule.regex = regex
+	case strings.HasPrefix(pattern, "*."):
+		rule.suffix = dns.Fqdn(strings.ToLower(pattern[1:]))
+	default:
+		rule.domain = dns.Fqdn(strings.ToLower(pattern))
+		if !netutils.IsValidFqdn(rule.domain) {
+			return nil, fmt.Errorf("invalid rule %q: invalid domain", definition)
+		}
+	}
+
+	// Parse value.
+	switch rrType {
+	case "A":
+		rule.rrType = dns.TypeA
+		rule.ip = net.ParseIP(value).To4()
+		if rule.ip == nil {
+			return nil, fmt.Errorf("invalid rule %q: invalid IPv4 address", definition)
+		}
+	case "AAAA":
+		rule.rrType = dns.TypeAAAA
+		rule.ip = net.ParseIP(value)
+		if rule.ip == nil || rule.ip.To4() != nil {
+			return nil, fmt.Errorf("invalid rule %q: invalid IPv6 address", definition)
+		}
+	case "CNAME":
+		rule.rrType = dns.TypeCNAME
+		rule.target = dns.Fqdn(strings.ToLower(value))
+		if rule.regex == nil && !netutils.IsValidFqdn(rule.target) {
+			return nil, fmt.Errorf("invalid rule %q: invalid target domain", definition)
+		}
+	default:
+		return nil, fmt.Errorf("invalid rule %q: unsupported type %s", definition, rrType)
+	}
+
+	return rule, nil
+}
+
+// match checks if the rule matches the given FQDN and returns the CNAME
+// target for CNAME rules.
+func (rule *rewriteRule) match(fqdn string) (target string, ok bool) {
+	switch {
+	case rule.regex != nil:
+		domain := strings.TrimSuffix(fqdn, ".")
+		submatches := rule.regex.FindStringSubmatchIndex(domain)
+		if submatches == nil {
+			return "", false
+		}
+		if rule.rrType == dns.TypeCNAME {
+			target = dns.Fqdn(string(rule.regex.ExpandString(nil, rule.target, domain, submatches)))
+		}
+		return target, true
+	case rule.suffix != "":
+		return rule.target, strings.HasSuffix(fqdn, rule.suffix)
+	default:
+		return rule.target, fqdn == rule.domain
+	}
+}
+
+// startRewriteRules parses the configured rewrite rules and keeps them up to
+// date.
+func startRewriteRules() error {
+	updateRewriteRules()
+
+	return module.RegisterEventHook(
+		"config",
+		"config change",
+		"update dns rewrite rules",
+		func(_ context.Context, _ interface{}) error {
+			updateRewriteRules()
+			return nil
+		},
+	)
+}
+
+// updateRewriteRules parses the configured rewrite rules.
+func updateRewriteRules() {
+	definitions := rewriteRulesConfig()
+	rules := make([]*rewriteRule, 0, len(definitions))
+	var failed []string
+	for _, definition := range definitions {
+		rule, err := parseRewriteRule(definition)
+		if err != nil {
+			log.Warningf("nameserver: %s", err)
+			failed = append(failed, err.Error())
+			continue
+		}
+		rules = append(rules, rule)
+	}
+
+	rewriteRulesLock.Lock()
+	rewriteRules = rules
+	rewriteRulesLock.Unlock()
+
+	if len(failed) > 0 {
+		module.Warning(
+			rewriteRulesFailed,
+			"Invalid DNS Rewrite Rules",
+			"The following DNS rewrite rules are invalid and are ignored:\n\n- "+strings.Join(failed, "\n- "),
+		)
+	} else {
+		module.Resolve(rewriteRulesFailed)
+	}
+}
+
+// rewriteQuery applies the rewrite rules to the query and reports whether a
+// rule matched. Answers of A and AAAA rules are synthesized from the rules and
+// must not be filtered, as they were defined by the user. Answers of CNAME
+// rules include the upstream answer for the target.
+func rewriteQuery(ctx context.Context, q *resolver.Query) (rrCache *resolver.RRCache, rewritten, synthesized bool, err error) {
+	rewriteRulesLock.RLock()
+	defer rewriteRulesLock.RUnlock()
+
+	var answer []dns.RR
+	for _, rule := range rewriteRules {
+		target, ok := rule.match(q.FQDN)
+		if !ok {
+			continue
+		}
+
+		// The first matching CNAME rule overrides all other rules.
+		if rule.rrType == dns.TypeCNAME {
+			log.Tracer(ctx).Debugf("nameserver: rewriting %s to CNAME %s", q.ID(), target)
+			rrCache, err = resolveRewriteTarget(ctx, q, target)
+			return rrCache, true, false, err
+		}
+
+		rewritten = true
+		if rule.rrType == uint16(q.QType) {
+			answer = append(answer, rule.makeRR(q.FQDN))
+		}
+	}
+	if !rewritten {
+		return nil, false, false, nil
+	}
+
+	log.Tracer(ctx).Debugf("nameserver: rewriting %s with %d records", q.ID(), len(answer))
+	return &resolver.RRCache{
+		Domain:   q.FQDN,
+		Question: q.QType,
+		RCode:    dns.RcodeSuccess,
+		Answer:   answer,
+		Expires:  time.Now().Add(rewriteTTL * time.Second).Unix(),
+		Resolver: rewriteResolverInfo,
+	}, true, true, nil
+}
+
+// makeRR creates the answer of an A or AAAA rule.
+func (rule *rewriteRule) makeRR(fqdn string) dns.RR {
+	header := dns.RR_Header{
+		Name:   fqdn,
+		Rrtype: rule.rrType,
+		Class:  dns.ClassINET,
+		Ttl:    rewriteTTL,
+	}
+	if rule.rrType == dns.TypeA {
+		return &dns.A{Hdr: header, A: rule.ip}
+	}
+	return &dns.AAAA{Hdr: header, AAAA: rule.ip}
+}
+
+// resolveRewriteTarget resolves the CNAME target upstream and returns it with
+// the CNAME record prepended.
+func resolveRewriteTarget(ctx context.Context, q *resolver.Query, target string) (*resolver.RRCache, error) {
+	targetRRCache, err := resolver.Resolve(ctx, &resolver.Query{
+		FQDN:          target,
+		QType:         q.QType,
+		SecurityLevel: q.SecurityLevel,
+	})
+	// The resolver may return a backup answer with an error.
+	if targetRRCache == nil {
+		return nil, err
+	}
+
+	// Copy, as the answer of the target may be cached.
+	rrCache := targetRRCache.ShallowCopy()
+	rrCache.Domain = q.FQDN
+	rrCache.Question = q.QType
+	rrCache.Answer = append([]dns.RR{&dns.CNAME{
+		Hdr: dns.RR_Header{
+			Name:   q.FQDN,
+			Rrtype: dns.TypeCNAME,
+			Class:  dns.ClassINET,
+			Ttl:    rewriteTTL,
+		},
+		Target: target,
+	}}, targetRRCache.Answer...)
+	return rrCache, err
+}
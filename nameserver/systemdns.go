@@ -0,0 +1,72 @@
+package nameserver
+
+import (
+	"context"
+	"net"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/netenv"
+)
+
+// startSystemDNSIntegration configures the system to use the nameserver on
+// the given IPs, if enabled, and keeps the configuration up to date.
+func startSystemDNSIntegration(listenIPs []net.IP) error {
+	// Restore the configuration if the Portmaster was not shut down cleanly.
+	if err := restorePersistedSystemDNS(); err != nil {
+		log.Warningf("nameserver: failed to restore system DNS configuration of previous run: %s", err)
+	}
+
+	for _, ip := range listenIPs {
+		if ip.IsUnspecified() {
+			log.Warning("nameserver: system DNS integration is not available when listening on all interfaces")
+			return nil
+		}
+	}
+
+	enabled := systemDNSIntegration()
+	if enabled {
+		applySystemDNSWithLogging(listenIPs)
+	}
+
+	// Enable or disable after config change.
+	err := module.RegisterEventHook(
+		"config",
+		"config change",
+		"update system dns integration",
+		func(_ context.Context, _ interface{}) error {
+			switch {
+			case systemDNSIntegration() == enabled:
+			case systemDNSIntegration():
+				enabled = true
+				applySystemDNSWithLogging(listenIPs)
+			default:
+				enabled = false
+				return restoreSystemDNS()
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	// Re-apply after network change, as the network configuration tools may
+	// have overwritten the configuration.
+	return module.RegisterEventHook(
+		"netenv",
+		netenv.NetworkChangedEvent,
+		"re-apply system dns integration",
+		func(_ context.Context, _ interface{}) error {
+			if systemDNSIntegration() {
+				applySystemDNSWithLogging(listenIPs)
+			}
+			return nil
+		},
+	)
+}
+
+func applySystemDNSWithLogging(listenIPs []net.IP) {
+	if err := applySystemDNS(listenIPs); err != nil {
+		log.Warningf("nameserver: failed to configure system DNS: %s", err)
+	}
+}
@@ -0,0 +1,127 @@
+package systemdns
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// DBus names of systemd-resolved and NetworkManager.
+const (
+	ResolvedDest       = "org.freedesktop.resolve1"
+	ResolvedPath       = dbus.ObjectPath("/org/freedesktop/resolve1")
+	ResolvedManager    = "org.freedesktop.resolve1.Manager"
+	ResolvedLinkPrefix = "org.freedesktop.resolve1.Link"
+
+	NetworkManagerDest = "org.freedesktop.NetworkManager"
+	NetworkManagerPath = dbus.ObjectPath("/org/freedesktop/NetworkManager")
+	NMGlobalDNSConfig  = "org.freedesktop.NetworkManager.GlobalDnsConfiguration"
+)
+
+// Restore restores the given original system DNS configuration. It restores
+// as much as possible and returns the last error.
+func Restore(conn *dbus.Conn, state *State) error {
+	var lastErr error
+
+	if len(state.ResolvedLinks) > 0 {
+		manager := conn.Object(ResolvedDest, ResolvedPath)
+		for ifIndex, servers := range state.ResolvedLinks {
+			var err error
+			if len(servers) == 0 {
+				// Reverting the link resets the DNS servers to the
+				// configuration files of systemd-resolved.
+				err = manager.Call(ResolvedManager+".RevertLink", 0, ifIndex).Err
+			} else {
+				err = manager.Call(ResolvedManager+".SetLinkDNS", 0, ifIndex, servers).Err
+			}
+			if err != nil {
+				lastErr = fmt.Errorf("failed to restore DNS servers of link %d in systemd-resolved: %w", ifIndex, err)
+			}
+		}
+	}
+
+	if state.NetworkManager != nil {
+		err := conn.Object(NetworkManagerDest, NetworkManagerPath).SetProperty(
+			NMGlobalDNSConfig,
+			dbus.MakeVariant(state.NetworkManager.DBusValue()),
+		)
+		if err != nil {
+			lastErr = fmt.Errorf("failed to restore global DNS configuration of NetworkManager: %w", err)
+		}
+	}
+
+	return lastErr
+}
+
+// NetworkManagerDNSFromDBus converts the global DNS configuration property of
+// NetworkManager. Unknown keys are ignored.
+func NetworkManagerDNSFromDBus(value interface{}) (*NetworkManagerDNS, error) {
+	config, ok := value.(map[string]dbus.Variant)
+	if !ok {
+		return nil, errors.New("unexpected type")
+	}
+
+	nmDNS := &NetworkManagerDNS{}
+	if v, ok := config["searches"]; ok {
+		if err := dbus.Store([]interface{}{v.Value()}, &nmDNS.Searches); err != nil {
+			return nil, fmt.Errorf("invalid searches: %w", err)
+		}
+	}
+	if v, ok := config["options"]; ok {
+		if err := dbus.Store([]interface{}{v.Value()}, &nmDNS.Options); err != nil {
+			return nil, fmt.Errorf("invalid options: %w", err)
+		}
+	}
+	if v, ok := config["domains"]; ok {
+		var domains map[string]map[string]dbus.Variant
+		if err := dbus.Store([]interface{}{v.Value()}, &domains); err != nil {
+			return nil, fmt.Errorf("invalid domains: %w", err)
+		}
+
+		nmDNS.Domains = make(map[string]NetworkManagerDomainDNS, len(domains))
+		for domain, domainConfig := range domains {
+			var domainDNS NetworkManagerDomainDNS
+			if v, ok := domainConfig["servers"]; ok {
+				if err := dbus.Store([]interface{}{v.Value()}, &domainDNS.Servers); err != nil {
+					return nil, fmt.Errorf("invalid servers of domain %s: %w", domain, err)
+				}
+			}
+			if v, ok := domainConfig["options"]; ok {
+				if err := dbus.Store([]interface{}{v.Value()}, &domainDNS.Options); err != nil {
+					return nil, fmt.Errorf("invalid options of domain %s: %w", domain, err)
+				}
+			}
+			nmDNS.Domains[domain] = domainDNS
+		}
+	}
+
+	return nmDNS, nil
+}
+
+// DBusValue returns the configuration as value of the global DNS
+// configuration property of NetworkManager.
+func (nmDNS *NetworkManagerDNS) DBusValue() map[string]dbus.Variant {
+	config := make(map[string]dbus.Variant)
+	if len(nmDNS.Searches) > 0 {
+		config["searches"] = dbus.MakeVariant(nmDNS.Searches)
+	}
+	if len(nmDNS.Options) > 0 {
+		config["options"] = dbus.MakeVariant(nmDNS.Options)
+	}
+	if len(nmDNS.Domains) > 0 {
+		domains := make(map[string]map[string]dbus.Variant, len(nmDNS.Domains))
+		for domain, domainDNS := range nmDNS.Domains {
+			domainConfig := make(map[string]dbus.Variant)
+			if len(domainDNS.Servers) > 0 {
+				domainConfig["servers"] = dbus.MakeVariant(domainDNS.Servers)
+			}
+			if len(domainDNS.Options) > 0 {
+				domainConfig["options"] = dbus.MakeVariant(domainDNS.Options)
+			}
+			domains[domain] = domainConfig
+		}
+		config["domains"] = dbus.MakeVariant(domains)
+	}
+	return config
+}
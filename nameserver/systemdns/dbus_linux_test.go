@@ -0,0 +1,44 @@
+package systemdns
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNetworkManagerDNSConversion(t *testing.T) {
+	t.Parallel()
+
+	nmDNS := &NetworkManagerDNS{
+		Searches: []string{"example.com", "example.org"},
+		Options:  []string{"rotate"},
+		Domains: map[string]NetworkManagerDomainDNS{
+			"*":           {Servers: []string{"192.168.1.1", "fd00::1"}},
+			"example.com": {Servers: []string{"10.0.0.1"}, Options: []string{"private"}},
+		},
+	}
+
+	converted, err := NetworkManagerDNSFromDBus(nmDNS.DBusValue())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(nmDNS, converted) {
+		t.Errorf("converted configuration %+v does not match %+v", converted, nmDNS)
+	}
+
+	// An empty configuration resets the global configuration.
+	empty := &NetworkManagerDNS{}
+	if len(empty.DBusValue()) != 0 {
+		t.Error("expected empty configuration")
+	}
+	converted, err = NetworkManagerDNSFromDBus(empty.DBusValue())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(empty, converted) {
+		t.Errorf("converted configuration %+v does not match %+v", converted, empty)
+	}
+
+	if _, err := NetworkManagerDNSFromDBus("invalid"); err == nil {
+		t.Error("expected error for invalid configuration")
+	}
+}
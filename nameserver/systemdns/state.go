@@ -0,0 +1,91 @@
+// Package systemdns persists the original system DNS configuration while the
+// Portmaster changes it, so that it can be restored after the Portmaster was
+// not shut down cleanly: either when the Portmaster starts again, or with the
+// recover-network command of portmaster-start.
+package systemdns
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/safing/portbase/utils/renameio"
+)
+
+// StateFileName is the name of the file in the data directory that holds the
+// original system DNS configuration.
+const StateFileName = "system-dns.json"
+
+// State holds the original system DNS configuration.
+type State struct {
+	// ResolvedLinks holds the original DNS servers of the systemd-resolved
+	// links that were changed, by interface index.
+	ResolvedLinks map[int32][]ResolvedLinkDNS `json:",omitempty"`
+	// NetworkManager holds the original global DNS configuration of
+	// NetworkManager, if it was changed.
+	NetworkManager *NetworkManagerDNS `json:",omitempty"`
+}
+
+// ResolvedLinkDNS is a DNS server of a link in systemd-resolved: the address
+// family and the raw IP.
+type ResolvedLinkDNS struct {
+	Family  int32
+	Address []byte
+}
+
+// NetworkManagerDNS is the global DNS configuration of NetworkManager.
+type NetworkManagerDNS struct {
+	Searches []string                           `json:",omitempty"`
+	Options  []string                           `json:",omitempty"`
+	Domains  map[string]NetworkManagerDomainDNS `json:",omitempty"`
+}
+
+// NetworkManagerDomainDNS is the DNS configuration of a domain in the global
+// DNS configuration of NetworkManager.
+type NetworkManagerDomainDNS struct {
+	Servers []string `json:",omitempty"`
+	Options []string `json:",omitempty"`
+}
+
+// IsEmpty returns whether the state holds no configuration to restore.
+func (s *State) IsEmpty() bool {
+	return s == nil || (len(s.ResolvedLinks) == 0 && s.NetworkManager == nil)
+}
+
+// LoadState loads the persisted state from the given data directory. It
+// returns nil if there is no state.
+func LoadState(dataDir string) (*State, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dataDir, StateFileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	state := &State{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// Save persists the state in the given data directory.
+func (s *State) Save(dataDir string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return renameio.WriteFile(filepath.Join(dataDir, StateFileName), data, 0600)
+}
+
+// RemoveState removes the persisted state from the given data directory.
+func RemoveState(dataDir string) error {
+	err := os.Remove(filepath.Join(dataDir, StateFileName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package systemdns
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestState(t *testing.T) {
+	t.Parallel()
+
+	dataDir, err := ioutil.TempDir("", "systemdns-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir) //nolint:errcheck
+
+	// No state.
+	state, err := LoadState(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.IsEmpty() {
+		t.Fatal("expected empty state")
+	}
+
+	// Save and load.
+	state = &State{
+		ResolvedLinks: map[int32][]ResolvedLinkDNS{
+			2: {{Family: 2, Address: []byte{192, 168, 1, 1}}},
+			3: nil,
+		},
+		NetworkManager: &NetworkManagerDNS{
+			Searches: []string{"example.com"},
+			Domains: map[string]NetworkManagerDomainDNS{
+				"*": {Servers: []string{"192.168.1.1"}},
+			},
+		},
+	}
+	if err := state.Save(dataDir); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadState(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(state, loaded) {
+		t.Errorf("loaded state %+v does not match saved state %+v", loaded, state)
+	}
+
+	// Remove.
+	if err := RemoveState(dataDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveState(dataDir); err != nil {
+		t.Fatal(err)
+	}
+	state, err = LoadState(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != nil {
+		t.Fatal("expected no state after removing")
+	}
+}
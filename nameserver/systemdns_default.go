@@ -0,0 +1,20 @@
+// +build !linux
+
+package nameserver
+
+import (
+	"errors"
+	"net"
+)
+
+func applySystemDNS(listenIPs []net.IP) error {
+	return errors.New("system DNS integration is not supported on this platform")
+}
+
+func restoreSystemDNS() error {
+	return nil
+}
+
+func restorePersistedSystemDNS() error {
+	return nil
+}
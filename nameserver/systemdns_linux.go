@@ -0,0 +1,296 @@
+package nameserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+
+	"github.com/safing/portbase/dataroot"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/nameserver/systemdns"
+	"github.com/safing/portmaster/netenv"
+)
+
+// System DNS integration on Linux.
+//
+// If systemd-resolved is running, the DNS servers of all links are set to the
+// Portmaster via its DBus API. The domains of the links are not touched, so
+// search and routing domains continue to work as configured.
+// Otherwise, if NetworkManager is running, its global DNS configuration is
+// set to the Portmaster, retaining the search domains of all connections.
+//
+// The previous settings are restored when the Portmaster stops. They are also
+// persisted, so that they are restored when the Portmaster starts again after
+// it was not shut down cleanly. As the network configuration tools may
+// overwrite the settings when the network changes, the settings are
+// re-applied on every network change.
+
+var (
+	systemDNSLock sync.Mutex
+
+	// originalDNS holds the original DNS configuration that was changed. It is
+	// persisted in the data directory, see systemdns.State.
+	originalDNS *systemdns.State
+)
+
+// applySystemDNS points the system DNS configuration at the given listen IPs.
+func applySystemDNS(listenIPs []net.IP) error {
+	systemDNSLock.Lock()
+	defer systemDNSLock.Unlock()
+
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return fmt.Errorf("failed to connect to system bus: %w", err)
+	}
+
+	switch {
+	case dbusServiceAvailable(conn, systemdns.ResolvedDest):
+		return applyResolvedDNS(conn, listenIPs)
+	case dbusServiceAvailable(conn, systemdns.NetworkManagerDest):
+		return applyNetworkManagerDNS(conn, listenIPs)
+	default:
+		return errors.New("neither systemd-resolved nor NetworkManager is running")
+	}
+}
+
+// restoreSystemDNS restores the system DNS configuration that was in place
+// before it was changed by applySystemDNS.
+func restoreSystemDNS() error {
+	systemDNSLock.Lock()
+	defer systemDNSLock.Unlock()
+
+	if originalDNS.IsEmpty() {
+		return nil
+	}
+	if err := restoreOriginalDNS(originalDNS); err != nil {
+		return err
+	}
+	originalDNS = nil
+	updateUpstreamNameservers()
+	return nil
+}
+
+// restorePersistedSystemDNS restores the system DNS configuration that was
+// persisted, but not restored, by a previous run.
+func restorePersistedSystemDNS() error {
+	systemDNSLock.Lock()
+	defer systemDNSLock.Unlock()
+
+	state, err := systemdns.LoadState(dataroot.Root().Path)
+	if err != nil {
+		return fmt.Errorf("failed to load original system DNS configuration: %w", err)
+	}
+	if state.IsEmpty() {
+		return nil
+	}
+
+	log.Warning("nameserver: restoring system DNS configuration left over from previous run")
+	if err := restoreOriginalDNS(state); err != nil {
+		// Keep the original configuration, so that it is not replaced by the
+		// one of the previous run when applying again.
+		originalDNS = state
+		return err
+	}
+	return nil
+}
+
+// restoreOriginalDNS restores the given configuration and removes the
+// persisted state, if successful.
+func restoreOriginalDNS(state *systemdns.State) error {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return fmt.Errorf("failed to connect to system bus: %w", err)
+	}
+
+	if err := systemdns.Restore(conn, state); err != nil {
+		return err
+	}
+	if err := systemdns.RemoveState(dataroot.Root().Path); err != nil {
+		log.Warningf("nameserver: failed to remove original system DNS configuration: %s", err)
+	}
+
+	log.Info("nameserver: restored system DNS configuration")
+	return nil
+}
+
+// saveOriginalDNS persists the original DNS configuration.
+func saveOriginalDNS() {
+	if err := originalDNS.Save(dataroot.Root().Path); err != nil {
+		log.Warningf("nameserver: failed to save original system DNS configuration: %s", err)
+	}
+}
+
+func applyResolvedDNS(conn *dbus.Conn, listenIPs []net.IP) error {
+	ourServers := make([]systemdns.ResolvedLinkDNS, 0, len(listenIPs))
+	for _, ip := range listenIPs {
+		if ip4 := ip.To4(); ip4 != nil {
+			ourServers = append(ourServers, systemdns.ResolvedLinkDNS{Family: 2, Address: ip4}) // AF_INET
+		} else {
+			ourServers = append(ourServers, systemdns.ResolvedLinkDNS{Family: 10, Address: ip.To16()}) // AF_INET6
+		}
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return fmt.Errorf("failed to list interfaces: %w", err)
+	}
+
+	if originalDNS == nil {
+		originalDNS = &systemdns.State{}
+	}
+	if originalDNS.ResolvedLinks == nil {
+		originalDNS.ResolvedLinks = make(map[int32][]systemdns.ResolvedLinkDNS)
+	}
+	manager := conn.Object(systemdns.ResolvedDest, systemdns.ResolvedPath)
+	var applied int
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		ifIndex := int32(iface.Index)
+
+		var linkPath dbus.ObjectPath
+		err := manager.Call(systemdns.ResolvedManager+".GetLink", 0, ifIndex).Store(&linkPath)
+		if err != nil {
+			log.Debugf("nameserver: failed to get link %s from systemd-resolved: %s", iface.Name, err)
+			continue
+		}
+
+		// Remember the current servers, unless they are already ours.
+		var current []systemdns.ResolvedLinkDNS
+		variant, err := conn.Object(systemdns.ResolvedDest, linkPath).GetProperty(systemdns.ResolvedLinkPrefix + ".DNS")
+		if err == nil {
+			err = dbus.Store([]interface{}{variant.Value()}, &current)
+		}
+		if err != nil {
+			log.Debugf("nameserver: failed to get DNS servers of link %s from systemd-resolved: %s", iface.Name, err)
+			continue
+		}
+		if !sameResolvedServers(current, ourServers) {
+			// Persist the original servers before they are changed.
+			originalDNS.ResolvedLinks[ifIndex] = current
+			saveOriginalDNS()
+		}
+
+		err = manager.Call(systemdns.ResolvedManager+".SetLinkDNS", 0, ifIndex, ourServers).Err
+		if err != nil {
+			log.Warningf("nameserver: failed to set DNS servers of link %s in systemd-resolved: %s", iface.Name, err)
+			continue
+		}
+		applied++
+	}
+
+	if applied == 0 {
+		return errors.New("no links could be configured in systemd-resolved")
+	}
+	log.Infof("nameserver: configured systemd-resolved to use the Portmaster on %d links", applied)
+	updateUpstreamNameservers()
+	return nil
+}
+
+// updateUpstreamNameservers tells netenv which servers systemd-resolved used
+// before it was pointed at the Portmaster. Otherwise, the stub resolver of
+// systemd-resolved in /etc/resolv.conf would be used as the system resolver,
+// which forwards queries back to the Portmaster. The caller must hold
+// systemDNSLock.
+func updateUpstreamNameservers() {
+	if originalDNS == nil || originalDNS.ResolvedLinks == nil {
+		netenv.SetUpstreamNameservers(nil)
+		return
+	}
+
+	ifIndexes := make([]int, 0, len(originalDNS.ResolvedLinks))
+	for ifIndex := range originalDNS.ResolvedLinks {
+		ifIndexes = append(ifIndexes, int(ifIndex))
+	}
+	sort.Ints(ifIndexes)
+
+	upstream := make([]netenv.Nameserver, 0)
+	for _, ifIndex := range ifIndexes {
+		for _, server := range originalDNS.ResolvedLinks[int32(ifIndex)] {
+			upstream = append(upstream, netenv.Nameserver{IP: net.IP(server.Address)})
+		}
+	}
+	netenv.SetUpstreamNameservers(upstream)
+}
+
+func sameResolvedServers(a, b []systemdns.ResolvedLinkDNS) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Family != b[i].Family || !net.IP(a[i].Address).Equal(net.IP(b[i].Address)) {
+			return false
+		}
+	}
+	return true
+}
+
+func applyNetworkManagerDNS(conn *dbus.Conn, listenIPs []net.IP) error {
+	nm := conn.Object(systemdns.NetworkManagerDest, systemdns.NetworkManagerPath)
+
+	// Remember and persist the current global configuration, if we have not
+	// set it.
+	if originalDNS == nil || originalDNS.NetworkManager == nil {
+		variant, err := nm.GetProperty(systemdns.NMGlobalDNSConfig)
+		if err != nil {
+			return fmt.Errorf("failed to get global DNS configuration of NetworkManager: %w", err)
+		}
+		original, err := systemdns.NetworkManagerDNSFromDBus(variant.Value())
+		if err != nil {
+			return fmt.Errorf("failed to parse global DNS configuration of NetworkManager: %w", err)
+		}
+
+		if originalDNS == nil {
+			originalDNS = &systemdns.State{}
+		}
+		originalDNS.NetworkManager = original
+		saveOriginalDNS()
+	}
+
+	servers := make([]string, 0, len(listenIPs))
+	for _, ip := range listenIPs {
+		servers = append(servers, ip.String())
+	}
+
+	// The global configuration overrides the one of the connections, so carry
+	// over their search domains.
+	var searches []string
+	seen := make(map[string]struct{})
+	for _, ns := range netenv.Nameservers() {
+		for _, search := range ns.Search {
+			if _, ok := seen[search]; !ok {
+				seen[search] = struct{}{}
+				searches = append(searches, search)
+			}
+		}
+	}
+
+	globalConfig := map[string]dbus.Variant{
+		"domains": dbus.MakeVariant(map[string]map[string]dbus.Variant{
+			"*": {
+				"servers": dbus.MakeVariant(servers),
+			},
+		}),
+	}
+	if len(searches) > 0 {
+		globalConfig["searches"] = dbus.MakeVariant(searches)
+	}
+
+	err := nm.SetProperty(systemdns.NMGlobalDNSConfig, dbus.MakeVariant(globalConfig))
+	if err != nil {
+		return fmt.Errorf("failed to set global DNS configuration of NetworkManager: %w", err)
+	}
+	log.Info("nameserver: configured NetworkManager to use the Portmaster")
+	return nil
+}
+
+func dbusServiceAvailable(conn *dbus.Conn, name string) bool {
+	var hasOwner bool
+	err := conn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, name).Store(&hasOwner)
+	return err == nil && hasOwner
+}
@@ -50,5 +50,31 @@ func registerAPIEndpoints() error {
 		return err
 	}
 
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "network/overlays",
+		Read:      api.PermitUser,
+		BelongsTo: module,
+		StructFunc: func(ar *api.Request) (i interface{}, err error) {
+			return GetOverlayNetworks(), nil
+		},
+		Name:        "Get Overlay Networks",
+		Description: "Returns the detected overlay networks, such as Tailscale or ZeroTier.",
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "network/identity",
+		Read:      api.PermitUser,
+		BelongsTo: module,
+		StructFunc: func(ar *api.Request) (i interface{}, err error) {
+			return GetNetworkIdentity(), nil
+		},
+		Name:        "Get Network Identity",
+		Description: "Returns the identity of the current network, which is derived from the gateway hardware addresses, the wireless network name and the assigned search domains.",
+	}); err != nil {
+		return err
+	}
+
 	return nil
 }
@@ -0,0 +1,59 @@
+package netenv
+
+import (
+	"context"
+	"sync"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+)
+
+// NetworkCategoryChangedEvent is emitted when the category of the current
+// network changed.
+const NetworkCategoryChangedEvent = "network category changed"
+
+// NetworkCategory is the category the operating system assigned to a network,
+// which describes how much the network is trusted.
+type NetworkCategory string
+
+// Network Categories
+const (
+	NetworkCategoryUnknown NetworkCategory = ""
+	NetworkCategoryPublic  NetworkCategory = "public"
+	NetworkCategoryPrivate NetworkCategory = "private"
+	NetworkCategoryDomain  NetworkCategory = "domain"
+)
+
+var (
+	networkCategory     NetworkCategory
+	networkCategoryLock sync.Mutex
+)
+
+// GetNetworkCategory returns the category of the current network. It is only
+// supported on Windows and is unknown on other platforms.
+func GetNetworkCategory() NetworkCategory {
+	networkCategoryLock.Lock()
+	defer networkCategoryLock.Unlock()
+
+	return networkCategory
+}
+
+// checkNetworkCategory checks if the network category changed and emits the
+// NetworkCategoryChangedEvent if it did. The category is checked when the
+// network changes and when the operating system reports a change to the
+// networks, as it may be changed by the user without any change to the
+// network.
+func checkNetworkCategory(_ context.Context, _ *modules.Task) error {
+	category := getNetworkCategory()
+
+	networkCategoryLock.Lock()
+	changed := category != networkCategory
+	networkCategory = category
+	networkCategoryLock.Unlock()
+
+	if changed {
+		log.Infof("netenv: category of current network is %q", category)
+		module.TriggerEvent(NetworkCategoryChangedEvent, category)
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+// +build !windows
+
+package netenv
+
+import "context"
+
+func getNetworkCategory() NetworkCategory {
+	return NetworkCategoryUnknown
+}
+
+func monitorNetworkCategory(_ context.Context, _ func()) error {
+	return nil
+}
@@ -0,0 +1,335 @@
+package netenv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+	"golang.org/x/sys/windows"
+
+	"github.com/safing/portbase/log"
+)
+
+// The network category is read from the Network List Manager of Windows,
+// which also notifies about changes to the category of networks. See
+// netlistmgr.h for the interfaces.
+
+var (
+	clsidNetworkListManager = ole.NewGUID("{DCB00C01-570F-4A9B-8D69-199FDBA5723B}")
+	iidINetworkListManager  = ole.NewGUID("{DCB00000-570F-4A9B-8D69-199FDBA5723B}")
+	iidINetworkEvents       = ole.NewGUID("{DCB00004-570F-4A9B-8D69-199FDBA5723B}")
+)
+
+const (
+	nlmEnumNetworkConnected = 0x01
+
+	nlmNetworkCategoryPublic              = 0
+	nlmNetworkCategoryPrivate             = 1
+	nlmNetworkCategoryDomainAuthenticated = 2
+
+	// Vtable indexes of the used methods. All interfaces except
+	// INetworkEvents are based on IDispatch, which has 7 methods.
+	methodNetworkListManagerGetNetworks = 7
+	methodEnumNext                      = 8
+	methodNetworkGetNetworkConnections  = 13
+	methodNetworkGetCategory            = 18
+	methodConnectionGetAdapterID        = 12
+
+	hresultFalse = 0x1
+)
+
+// getNetworkCategory returns the category of the network of the default
+// interface, as assigned by Windows.
+func getNetworkCategory() NetworkCategory {
+	defaultIf := getDefaultInterface()
+	if defaultIf == nil || defaultIf.InterfaceIndex == "" {
+		return NetworkCategoryUnknown
+	}
+
+	adapterID, err := getAdapterID(defaultIf.InterfaceIndex)
+	if err != nil {
+		log.Warningf("netenv: failed to get network category: %s", err)
+		return NetworkCategoryUnknown
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := initCOM(); err != nil {
+		log.Warningf("netenv: failed to get network category: %s", err)
+		return NetworkCategoryUnknown
+	}
+	defer ole.CoUninitialize()
+
+	category, err := getAdapterNetworkCategory(adapterID)
+	if err != nil {
+		log.Warningf("netenv: failed to get network category: %s", err)
+		return NetworkCategoryUnknown
+	}
+
+	switch category {
+	case nlmNetworkCategoryPublic:
+		return NetworkCategoryPublic
+	case nlmNetworkCategoryPrivate:
+		return NetworkCategoryPrivate
+	case nlmNetworkCategoryDomainAuthenticated:
+		return NetworkCategoryDomain
+	default:
+		return NetworkCategoryUnknown
+	}
+}
+
+// getAdapterID returns the GUID of the network adapter with the given
+// interface index.
+func getAdapterID(interfaceIndex string) (string, error) {
+	index, err := strconv.ParseUint(interfaceIndex, 10, 32)
+	if err != nil {
+		return "", fmt.Errorf("invalid interface index %q: %w", interfaceIndex, err)
+	}
+
+	size := uint32(15000)
+	for {
+		buf := make([]byte, size)
+		adapters := (*windows.IpAdapterAddresses)(unsafe.Pointer(&buf[0]))
+		err := windows.GetAdaptersAddresses(windows.AF_UNSPEC, 0, 0, adapters, &size)
+		if errors.Is(err, windows.ERROR_BUFFER_OVERFLOW) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to get adapters: %w", err)
+		}
+
+		for adapter := adapters; adapter != nil; adapter = adapter.Next {
+			if adapter.IfIndex == uint32(index) {
+				return windows.BytePtrToString(adapter.AdapterName), nil
+			}
+		}
+		return "", fmt.Errorf("adapter of interface %d not found", index)
+	}
+}
+
+// getAdapterNetworkCategory returns the category of the connected network
+// that the given adapter is connected to.
+func getAdapterNetworkCategory(adapterID string) (int32, error) {
+	manager, err := ole.CreateInstance(clsidNetworkListManager, iidINetworkListManager)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create network list manager: %w", err)
+	}
+	defer manager.Release()
+
+	var networks *ole.IUnknown
+	if _, err := comCall(manager, methodNetworkListManagerGetNetworks, nlmEnumNetworkConnected, uintptr(unsafe.Pointer(&networks))); err != nil {
+		return 0, fmt.Errorf("failed to get networks: %w", err)
+	}
+	defer networks.Release()
+
+	for {
+		network, err := enumNext(networks)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get networks: %w", err)
+		}
+		if network == nil {
+			return 0, errors.New("no connected network found for adapter")
+		}
+
+		connected, err := networkHasAdapter(network, adapterID)
+		if err == nil && connected {
+			var category int32
+			_, err = comCall(network, methodNetworkGetCategory, uintptr(unsafe.Pointer(&category)))
+			network.Release()
+			if err != nil {
+				return 0, fmt.Errorf("failed to get category: %w", err)
+			}
+			return category, nil
+		}
+		network.Release()
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// networkHasAdapter returns whether the network is connected via the given
+// adapter.
+func networkHasAdapter(network *ole.IUnknown, adapterID string) (bool, error) {
+	var connections *ole.IUnknown
+	if _, err := comCall(network, methodNetworkGetNetworkConnections, uintptr(unsafe.Pointer(&connections))); err != nil {
+		return false, fmt.Errorf("failed to get network connections: %w", err)
+	}
+	defer connections.Release()
+
+	for {
+		connection, err := enumNext(connections)
+		if err != nil {
+			return false, fmt.Errorf("failed to get network connections: %w", err)
+		}
+		if connection == nil {
+			return false, nil
+		}
+
+		var id ole.GUID
+		_, err = comCall(connection, methodConnectionGetAdapterID, uintptr(unsafe.Pointer(&id)))
+		connection.Release()
+		if err != nil {
+			return false, fmt.Errorf("failed to get adapter of network connection: %w", err)
+		}
+		if strings.EqualFold(id.String(), adapterID) {
+			return true, nil
+		}
+	}
+}
+
+// enumNext returns the next item of the Network List Manager enumeration. It
+// returns nil at the end of the enumeration.
+func enumNext(enum *ole.IUnknown) (*ole.IUnknown, error) {
+	var (
+		item    *ole.IUnknown
+		fetched uint32
+	)
+	if _, err := comCall(enum, methodEnumNext, 1, uintptr(unsafe.Pointer(&item)), uintptr(unsafe.Pointer(&fetched))); err != nil {
+		return nil, err
+	}
+	if fetched == 0 {
+		return nil, nil
+	}
+	return item, nil
+}
+
+// comCall calls the method with the given vtable index on the COM object.
+func comCall(obj *ole.IUnknown, method int, args ...uintptr) (uintptr, error) {
+	fn := *(*uintptr)(unsafe.Pointer(uintptr(unsafe.Pointer(obj.RawVTable)) + uintptr(method)*unsafe.Sizeof(uintptr(0))))
+
+	var callArgs [6]uintptr
+	callArgs[0] = uintptr(unsafe.Pointer(obj))
+	copy(callArgs[1:], args)
+
+	hr, _, _ := syscall.Syscall6(fn, uintptr(len(args)+1), callArgs[0], callArgs[1], callArgs[2], callArgs[3], callArgs[4], callArgs[5])
+	if int32(hr) < 0 {
+		return hr, ole.NewError(hr)
+	}
+	return hr, nil
+}
+
+// initCOM initializes COM for the current thread in the multithreaded
+// apartment. It must be undone with ole.CoUninitialize.
+func initCOM() error {
+	err := ole.CoInitializeEx(0, ole.COINIT_MULTITHREADED)
+	var oleErr *ole.OleError
+	if errors.As(err, &oleErr) && oleErr.Code() == hresultFalse {
+		// COM was already initialized for this thread.
+		return nil
+	}
+	return err
+}
+
+// networkEventsSink implements INetworkEvents in order to be notified of
+// changes to networks by the Network List Manager.
+type networkEventsSink struct {
+	vtbl   *networkEventsVtbl
+	refs   int32
+	notify func()
+}
+
+type networkEventsVtbl struct {
+	QueryInterface             uintptr
+	AddRef                     uintptr
+	Release                    uintptr
+	NetworkAdded               uintptr
+	NetworkDeleted             uintptr
+	NetworkConnectivityChanged uintptr
+	NetworkPropertyChanged     uintptr
+}
+
+// The network GUIDs are passed by reference on amd64, the only supported
+// architecture on Windows, so every argument fits into an uintptr.
+var networkEventsSinkVtbl = &networkEventsVtbl{
+	QueryInterface: syscall.NewCallback(func(sink *networkEventsSink, iid *ole.GUID, obj **networkEventsSink) uintptr {
+		if !ole.IsEqualGUID(iid, ole.IID_IUnknown) && !ole.IsEqualGUID(iid, iidINetworkEvents) {
+			*obj = nil
+			return ole.E_NOINTERFACE
+		}
+		atomic.AddInt32(&sink.refs, 1)
+		*obj = sink
+		return ole.S_OK
+	}),
+	AddRef: syscall.NewCallback(func(sink *networkEventsSink) uintptr {
+		return uintptr(atomic.AddInt32(&sink.refs, 1))
+	}),
+	Release: syscall.NewCallback(func(sink *networkEventsSink) uintptr {
+		return uintptr(atomic.AddInt32(&sink.refs, -1))
+	}),
+	NetworkAdded: syscall.NewCallback(func(sink *networkEventsSink, _ uintptr) uintptr {
+		sink.notify()
+		return ole.S_OK
+	}),
+	NetworkDeleted: syscall.NewCallback(func(sink *networkEventsSink, _ uintptr) uintptr {
+		sink.notify()
+		return ole.S_OK
+	}),
+	NetworkConnectivityChanged: syscall.NewCallback(func(sink *networkEventsSink, _, _ uintptr) uintptr {
+		sink.notify()
+		return ole.S_OK
+	}),
+	NetworkPropertyChanged: syscall.NewCallback(func(sink *networkEventsSink, _, _ uintptr) uintptr {
+		sink.notify()
+		return ole.S_OK
+	}),
+}
+
+// monitorNetworkCategory calls notify whenever the Network List Manager
+// reports a change to the networks, such as a changed category, until the
+// context is canceled.
+func monitorNetworkCategory(ctx context.Context, notify func()) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := initCOM(); err != nil {
+		return fmt.Errorf("failed to initialize COM: %w", err)
+	}
+	defer ole.CoUninitialize()
+
+	manager, err := ole.CreateInstance(clsidNetworkListManager, iidINetworkListManager)
+	if err != nil {
+		return fmt.Errorf("failed to create network list manager: %w", err)
+	}
+	defer manager.Release()
+
+	container, err := manager.QueryInterface(ole.IID_IConnectionPointContainer)
+	if err != nil {
+		return fmt.Errorf("failed to get connection points: %w", err)
+	}
+	defer container.Release()
+
+	var point *ole.IConnectionPoint
+	if err := (*ole.IConnectionPointContainer)(unsafe.Pointer(container)).FindConnectionPoint(iidINetworkEvents, &point); err != nil {
+		return fmt.Errorf("failed to get network events: %w", err)
+	}
+	defer point.Release()
+
+	// The sink is referenced by the Network List Manager until unadvised and
+	// must stay alive until then.
+	sink := &networkEventsSink{
+		vtbl:   networkEventsSinkVtbl,
+		notify: notify,
+	}
+	cookie, err := point.Advise((*ole.IUnknown)(unsafe.Pointer(sink)))
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to network events: %w", err)
+	}
+	defer func() {
+		_ = point.Unadvise(cookie)
+		runtime.KeepAlive(sink)
+	}()
+
+	// Events are delivered on threads of the multithreaded apartment, so
+	// there is no need to dispatch messages here.
+	<-ctx.Done()
+	return nil
+}
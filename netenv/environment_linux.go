@@ -22,8 +22,59 @@
 	nameservers                   = make([]Nameserver, 0)
 	nameserversLock               sync.Mutex
 	nameserversNetworkChangedFlag = GetNetworkChangedFlag()
+	nameserversOutdated           bool
+
+	// resolvedStubIPs are the addresses of the local stub resolver of
+	// systemd-resolved, which /etc/resolv.conf usually points to.
+	resolvedStubIPs = []net.IP{
+		net.IPv4(127, 0, 0, 53),
+		net.IPv4(127, 0, 0, 54),
+	}
+	// upstreamNameservers holds the nameservers that systemd-resolved used
+	// before it was pointed at the Portmaster.
+	upstreamNameservers []Nameserver
 )
 
+// SetUpstreamNameservers sets the nameservers that systemd-resolved used
+// before the system DNS configuration was pointed at the Portmaster. While
+// set, they are used instead of the stub resolver of systemd-resolved, which
+// would forward queries back to the Portmaster. Set to nil when the system DNS
+// configuration was restored.
+func SetUpstreamNameservers(upstream []Nameserver) {
+	nameserversLock.Lock()
+	changed := !sameNameservers(upstreamNameservers, upstream)
+	if changed {
+		upstreamNameservers = upstream
+		nameserversOutdated = true
+	}
+	nameserversLock.Unlock()
+
+	if changed {
+		module.TriggerEvent(NameserversChangedEvent, nil)
+	}
+}
+
+func sameNameservers(a, b []Nameserver) bool {
+	if (a == nil) != (b == nil) || len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].IP.Equal(b[i].IP) {
+			return false
+		}
+	}
+	return true
+}
+
+func isResolvedStub(ip net.IP) bool {
+	for _, stubIP := range resolvedStubIPs {
+		if stubIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 // Gateways returns the currently active gateways.
 func Gateways() []net.IP {
 	gatewaysLock.Lock()
@@ -111,10 +162,11 @@ func Nameservers() []Nameserver {
 	nameserversLock.Lock()
 	defer nameserversLock.Unlock()
 	// Check if the network changed, if not, return cache.
-	if !nameserversNetworkChangedFlag.IsSet() {
+	if !nameserversNetworkChangedFlag.IsSet() && !nameserversOutdated {
 		return nameservers
 	}
 	nameserversNetworkChangedFlag.Refresh()
+	nameserversOutdated = false
 
 	// logic
 	// TODO: try:
@@ -136,6 +188,21 @@ func Nameservers() []Nameserver {
 	if err != nil {
 		log.Warningf("environment: could not get nameservers from resolvconf: %s", err)
 	} else {
+		if upstreamNameservers != nil {
+			// Replace the stub resolver of systemd-resolved with the servers
+			// it used before it was pointed at the Portmaster.
+			filtered := make([]Nameserver, 0, len(resolvconfNameservers))
+			for _, ns := range resolvconfNameservers {
+				if isResolvedStub(ns.IP) {
+					for _, upstream := range upstreamNameservers {
+						filtered = append(filtered, Nameserver{IP: upstream.IP, Search: ns.Search})
+					}
+					continue
+				}
+				filtered = append(filtered, ns)
+			}
+			resolvconfNameservers = filtered
+		}
 		nameservers = addNameservers(nameservers, resolvconfNameservers)
 	}
 
@@ -0,0 +1,122 @@
+package netenv
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/utils"
+)
+
+// NetworkIdentityChangedEvent is emitted when the device moved to a network
+// with a different identity.
+const NetworkIdentityChangedEvent = "network identity changed"
+
+// NetworkIdentity describes the network the device is connected to, using
+// attributes that stay the same when IP addresses change, so that networks
+// can be recognized again.
+type NetworkIdentity struct {
+	// ID is derived from all other attributes. It is empty if the network
+	// could not be identified.
+	ID string
+	// GatewayMACs holds the hardware addresses of the default gateways.
+	GatewayMACs []string
+	// SSID is the name of the connected wireless network, if any.
+	SSID string
+	// Domains holds the search domains assigned by the network, usually via
+	// DHCP.
+	Domains []string
+}
+
+var (
+	networkIdentity                   *NetworkIdentity
+	networkIdentityLock               sync.Mutex
+	networkIdentityNetworkChangedFlag = GetNetworkChangedFlag()
+)
+
+// GetNetworkIdentity returns the identity of the current network.
+func GetNetworkIdentity() *NetworkIdentity {
+	networkIdentityLock.Lock()
+	defer networkIdentityLock.Unlock()
+
+	// Check if the network changed, if not, return cache.
+	if networkIdentity != nil && !networkIdentityNetworkChangedFlag.IsSet() {
+		return networkIdentity
+	}
+	networkIdentityNetworkChangedFlag.Refresh()
+
+	identity := &NetworkIdentity{
+		SSID: getSSID(),
+	}
+	for _, gw := range Gateways() {
+		if mac := getHardwareAddress(gw); mac != "" {
+			identity.GatewayMACs = append(identity.GatewayMACs, mac)
+		}
+	}
+	for _, ns := range Nameservers() {
+		for _, domain := range ns.Search {
+			domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+			if domain != "" && !utils.StringInSlice(identity.Domains, domain) {
+				identity.Domains = append(identity.Domains, domain)
+			}
+		}
+	}
+	identity.ID = identity.makeID()
+
+	networkIdentity = identity
+	return identity
+}
+
+// makeID returns a stable ID for the network identity.
+func (identity *NetworkIdentity) makeID() string {
+	if len(identity.GatewayMACs) == 0 && identity.SSID == "" && len(identity.Domains) == 0 {
+		return ""
+	}
+
+	macs := append([]string(nil), identity.GatewayMACs...)
+	sort.Strings(macs)
+	domains := append([]string(nil), identity.Domains...)
+	sort.Strings(domains)
+
+	hasher := sha256.New()
+	_, _ = io.WriteString(hasher, strings.Join(macs, ","))
+	_, _ = io.WriteString(hasher, "|"+identity.SSID+"|")
+	_, _ = io.WriteString(hasher, strings.Join(domains, ","))
+	return hex.EncodeToString(hasher.Sum(nil)[:16])
+}
+
+// checkNetworkIdentity checks if the network identity changed and emits the
+// NetworkIdentityChangedEvent if it did.
+func checkNetworkIdentity() func(context.Context, interface{}) error {
+	var lastID string
+	return func(_ context.Context, _ interface{}) error {
+		identity := GetNetworkIdentity()
+		if identity.ID == lastID {
+			return nil
+		}
+		lastID = identity.ID
+
+		if identity.ID == "" {
+			log.Infof("netenv: could not identify current network")
+		} else {
+			log.Infof("netenv: current network identity is %s", identity.ID)
+		}
+		module.TriggerEvent(NetworkIdentityChangedEvent, identity)
+		return nil
+	}
+}
+
+// normalizeMAC converts hardware addresses to the lowercase, colon separated
+// format.
+func normalizeMAC(mac string) string {
+	mac = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(mac), "-", ":"))
+	if mac == "" || mac == "00:00:00:00:00:00" {
+		return ""
+	}
+	return mac
+}
@@ -0,0 +1,13 @@
+//+build !windows,!linux
+
+package netenv
+
+import "net"
+
+func getHardwareAddress(ip net.IP) string {
+	return ""
+}
+
+func getSSID() string {
+	return ""
+}
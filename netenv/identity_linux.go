@@ -0,0 +1,57 @@
+package netenv
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/safing/portbase/log"
+)
+
+// getHardwareAddress returns the hardware address of the given IP from the
+// ARP table. Only IPv4 is supported.
+func getHardwareAddress(ip net.IP) string {
+	if ip.To4() == nil {
+		return ""
+	}
+
+	arpTable, err := os.Open("/proc/net/arp")
+	if err != nil {
+		log.Warningf("netenv: could not read /proc/net/arp: %s", err)
+		return ""
+	}
+	defer arpTable.Close()
+
+	return findInARPTable(arpTable, ip)
+}
+
+// findInARPTable returns the hardware address of the given IP from an ARP
+// table in the format of /proc/net/arp.
+func findInARPTable(arpTable io.Reader, ip net.IP) string {
+	scanner := bufio.NewScanner(arpTable)
+	scanner.Split(bufio.ScanLines)
+	for scanner.Scan() {
+		// IP address, HW type, Flags, HW address, Mask, Device
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		if entryIP := net.ParseIP(fields[0]); entryIP != nil && entryIP.Equal(ip) {
+			return normalizeMAC(fields[3])
+		}
+	}
+	return ""
+}
+
+// getSSID returns the SSID of the connected wireless network.
+func getSSID() string {
+	output, err := exec.Command("iwgetid", "--raw").Output()
+	if err != nil {
+		// Not connected to a wireless network or iwgetid is not available.
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
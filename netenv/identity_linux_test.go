@@ -0,0 +1,44 @@
+package netenv
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestFindInARPTable(t *testing.T) {
+	t.Parallel()
+
+	arpTable := `IP address       HW type     Flags       HW address            Mask     Device
+192.168.1.1      0x1         0x2         AA:BB:CC:DD:EE:FF     *        wlan0
+192.168.1.23     0x1         0x0         00:00:00:00:00:00     *        wlan0
+`
+	if mac := findInARPTable(strings.NewReader(arpTable), net.ParseIP("192.168.1.1")); mac != "aa:bb:cc:dd:ee:ff" {
+		t.Errorf("unexpected hardware address %q", mac)
+	}
+	if mac := findInARPTable(strings.NewReader(arpTable), net.ParseIP("192.168.1.23")); mac != "" {
+		t.Errorf("incomplete entry should be ignored, got %q", mac)
+	}
+}
+
+func TestParseNeighborTables(t *testing.T) {
+	t.Parallel()
+
+	arpTable := `IP address       HW type     Flags       HW address            Mask     Device
+192.168.1.1      0x1         0x2         AA:BB:CC:DD:EE:FF     *        eth0
+192.168.1.23     0x1         0x0         00:00:00:00:00:00     *        eth0
+`
+	neighbors := parseARPTable(strings.NewReader(arpTable))
+	if len(neighbors) != 1 || neighbors[0].MAC != "aa:bb:cc:dd:ee:ff" || neighbors[0].Interface != "eth0" {
+		t.Errorf("unexpected ARP neighbors: %+v", neighbors)
+	}
+
+	ndpTable := `fe80::1 dev eth0 lladdr aa:bb:cc:dd:ee:ff router REACHABLE
+fe80::2 dev eth0  FAILED
+2001:db8::5 dev eth0 lladdr 11:22:33:44:55:66 STALE
+`
+	neighbors = parseNDPTable(strings.NewReader(ndpTable))
+	if len(neighbors) != 2 || neighbors[1].MAC != "11:22:33:44:55:66" || !neighbors[1].IP.Equal(net.ParseIP("2001:db8::5")) {
+		t.Errorf("unexpected NDP neighbors: %+v", neighbors)
+	}
+}
@@ -0,0 +1,42 @@
+package netenv
+
+import (
+	"bufio"
+	"net"
+	"os/exec"
+	"strings"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/utils/osdetail"
+)
+
+// getHardwareAddress returns the hardware address of the given IP from the
+// neighbor table.
+func getHardwareAddress(ip net.IP) string {
+	output, err := osdetail.RunPowershellCmd(
+		"Get-NetNeighbor -IPAddress '" + ip.String() + "' | Select-Object -First 1 -ExpandProperty LinkLayerAddress",
+	)
+	if err != nil {
+		log.Warningf("netenv: failed to get hardware address of %s: %s", ip, err)
+		return ""
+	}
+	return normalizeMAC(output)
+}
+
+// getSSID returns the SSID of the connected wireless network.
+func getSSID() string {
+	output, err := exec.Command("netsh", "wlan", "show", "interfaces").Output()
+	if err != nil {
+		// WLAN service is not running.
+		return ""
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		segments := strings.SplitN(scanner.Text(), ":", 2)
+		if len(segments) == 2 && strings.TrimSpace(segments[0]) == "SSID" {
+			return strings.TrimSpace(segments[1])
+		}
+	}
+	return ""
+}
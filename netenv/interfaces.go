@@ -0,0 +1,52 @@
+package netenv
+
+import (
+	"net"
+	"sync"
+
+	"github.com/safing/portbase/log"
+)
+
+var (
+	interfacesByIP                   map[string]string
+	interfacesByIPLock               sync.Mutex
+	interfacesByIPNetworkChangedFlag = GetNetworkChangedFlag()
+)
+
+// GetInterfaceByIP returns the name of the local interface that the given IP
+// is assigned to.
+func GetInterfaceByIP(ip net.IP) (ifaceName string, ok bool) {
+	interfacesByIPLock.Lock()
+	defer interfacesByIPLock.Unlock()
+
+	if interfacesByIP == nil || interfacesByIPNetworkChangedFlag.IsSet() {
+		interfacesByIPNetworkChangedFlag.Refresh()
+		interfacesByIP = loadInterfacesByIP()
+	}
+
+	ifaceName, ok = interfacesByIP[ip.String()]
+	return ifaceName, ok
+}
+
+func loadInterfacesByIP() map[string]string {
+	byIP := make(map[string]string)
+
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		log.Warningf("netenv: failed to get interfaces: %s", err)
+		return byIP
+	}
+	for _, iface := range interfaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				byIP[ipNet.IP.String()] = iface.Name
+			}
+		}
+	}
+
+	return byIP
+}
@@ -1,6 +1,9 @@
 package netenv
 
 import (
+	"context"
+	"runtime"
+
 	"github.com/safing/portbase/modules"
 )
 
@@ -8,6 +11,7 @@
 const (
 	NetworkChangedEvent      = "network changed"
 	OnlineStatusChangedEvent = "online status changed"
+	NameserversChangedEvent  = "nameservers changed"
 )
 
 var (
@@ -18,6 +22,9 @@ func init() {
 	module = modules.Register("netenv", prep, start, nil)
 	module.RegisterEvent(NetworkChangedEvent, true)
 	module.RegisterEvent(OnlineStatusChangedEvent, true)
+	module.RegisterEvent(NetworkIdentityChangedEvent, true)
+	module.RegisterEvent(NetworkCategoryChangedEvent, true)
+	module.RegisterEvent(NameserversChangedEvent, true)
 }
 
 func prep() error {
@@ -33,6 +40,44 @@ func start() error {
 		return err
 	}
 
+	if err := registerOverlayHook(); err != nil {
+		return err
+	}
+
+	if err := module.RegisterEventHook(
+		"netenv",
+		NetworkChangedEvent,
+		"check network identity",
+		checkNetworkIdentity(),
+	); err != nil {
+		return err
+	}
+
+	// Network categories are only supported on Windows.
+	if runtime.GOOS == "windows" {
+		categoryTask := module.NewTask("check network category", checkNetworkCategory)
+		module.StartServiceWorker(
+			"monitor network category",
+			0,
+			func(ctx context.Context) error {
+				return monitorNetworkCategory(ctx, func() {
+					categoryTask.StartASAP()
+				})
+			},
+		)
+		if err := module.RegisterEventHook(
+			"netenv",
+			NetworkChangedEvent,
+			"check network category",
+			func(_ context.Context, _ interface{}) error {
+				categoryTask.StartASAP()
+				return nil
+			},
+		); err != nil {
+			return err
+		}
+	}
+
 	module.StartServiceWorker(
 		"monitor network changes",
 		0,
@@ -0,0 +1,17 @@
+package netenv
+
+import "net"
+
+// Neighbor is a device on the local network, as seen in the ARP and NDP
+// tables.
+type Neighbor struct {
+	IP        net.IP
+	MAC       string
+	Interface string
+}
+
+// Neighbors returns the devices on the local networks that are currently
+// known to the operating system.
+func Neighbors() []*Neighbor {
+	return getNeighbors()
+}
@@ -0,0 +1,7 @@
+//+build !linux
+
+package netenv
+
+func getNeighbors() []*Neighbor {
+	return nil
+}
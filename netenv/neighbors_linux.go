@@ -0,0 +1,91 @@
+package netenv
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/safing/portbase/log"
+)
+
+func getNeighbors() []*Neighbor {
+	var neighbors []*Neighbor
+
+	arpTable, err := os.Open("/proc/net/arp")
+	if err != nil {
+		log.Warningf("netenv: could not read /proc/net/arp: %s", err)
+	} else {
+		neighbors = parseARPTable(arpTable)
+		_ = arpTable.Close()
+	}
+
+	// The NDP table is not available in /proc.
+	output, err := exec.Command("ip", "-6", "neigh", "show").Output()
+	if err != nil {
+		log.Debugf("netenv: could not read NDP table: %s", err)
+	} else {
+		neighbors = append(neighbors, parseNDPTable(strings.NewReader(string(output)))...)
+	}
+
+	return neighbors
+}
+
+// parseARPTable returns all complete entries of an ARP table in the format of
+// /proc/net/arp.
+func parseARPTable(arpTable io.Reader) (neighbors []*Neighbor) {
+	scanner := bufio.NewScanner(arpTable)
+	scanner.Split(bufio.ScanLines)
+	for scanner.Scan() {
+		// IP address, HW type, Flags, HW address, Mask, Device
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		mac := normalizeMAC(fields[3])
+		if ip == nil || mac == "" {
+			continue
+		}
+		neighbors = append(neighbors, &Neighbor{
+			IP:        ip,
+			MAC:       mac,
+			Interface: fields[5],
+		})
+	}
+	return neighbors
+}
+
+// parseNDPTable returns all entries with a hardware address of an NDP table
+// in the format of `ip -6 neigh show`.
+func parseNDPTable(ndpTable io.Reader) (neighbors []*Neighbor) {
+	scanner := bufio.NewScanner(ndpTable)
+	scanner.Split(bufio.ScanLines)
+	for scanner.Scan() {
+		// fe80::1 dev eth0 lladdr aa:bb:cc:dd:ee:ff router REACHABLE
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			continue
+		}
+
+		neighbor := &Neighbor{IP: ip}
+		for i := 1; i < len(fields)-1; i++ {
+			switch fields[i] {
+			case "dev":
+				neighbor.Interface = fields[i+1]
+			case "lladdr":
+				neighbor.MAC = normalizeMAC(fields[i+1])
+			}
+		}
+		if neighbor.MAC != "" {
+			neighbors = append(neighbors, neighbor)
+		}
+	}
+	return neighbors
+}
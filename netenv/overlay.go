@@ -0,0 +1,218 @@
+package netenv
+
+import (
+	"context"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/safing/portbase/log"
+)
+
+// Overlay network types.
+const (
+	OverlayTypeTailscale = "tailscale"
+	OverlayTypeZeroTier  = "zerotier"
+)
+
+// OverlayNetwork describes an overlay (mesh VPN) network that is configured on
+// the host.
+type OverlayNetwork struct {
+	Type      string
+	Interface string
+	Networks  []*net.IPNet
+}
+
+var (
+	// Tailscale assigns addresses from the CGNAT range (RFC6598) and its own
+	// IPv6 ULA prefix.
+	tailscaleIPv4Net = mustParseCIDR("100.64.0.0/10")
+	tailscaleIPv6Net = mustParseCIDR("fd7a:115c:a1e0::/48")
+
+	// overlayNetworks holds the detected overlay networks as
+	// []*OverlayNetwork. It is replaced as a whole when the network changes,
+	// so that lookups do not need to lock.
+	overlayNetworks     atomic.Value
+	overlayNetworksLock sync.Mutex
+)
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return ipNet
+}
+
+// getOverlayType returns the overlay type of the given interface, based on
+// its name. It returns an empty string if the interface is not recognized as
+// an overlay interface.
+func getOverlayType(ifaceName string) string {
+	name := strings.ToLower(ifaceName)
+	switch {
+	case strings.HasPrefix(name, "tailscale"):
+		// Linux: tailscale0, Windows: Tailscale
+		return OverlayTypeTailscale
+	case strings.HasPrefix(name, "zerotier"):
+		// Windows: ZeroTier One [<network ID>]
+		return OverlayTypeZeroTier
+	case strings.HasPrefix(name, "zt") && len(name) > 2:
+		// Linux/BSD: zt<hash>
+		return OverlayTypeZeroTier
+	default:
+		return ""
+	}
+}
+
+// isTunInterface returns whether the given interface is a generic tunnel
+// device, which overlays may use without a recognizable name.
+func isTunInterface(ifaceName string) bool {
+	name := strings.ToLower(ifaceName)
+	if strings.HasPrefix(name, "utun") || strings.HasPrefix(name, "tun") {
+		// eg. Tailscale on macOS
+		return true
+	}
+
+	// Linux exposes the flags of tun/tap devices, whatever their name.
+	_, err := os.Stat("/sys/class/net/" + ifaceName + "/tun_flags")
+	return err == nil
+}
+
+// isOverlayAddress checks whether the given address assigned to a tunnel
+// interface of unknown type is part of a well-known overlay network range.
+func isOverlayAddress(ip net.IP) (overlayType string, ok bool) {
+	switch {
+	case tailscaleIPv4Net.Contains(ip), tailscaleIPv6Net.Contains(ip):
+		return OverlayTypeTailscale, true
+	default:
+		return "", false
+	}
+}
+
+// GetOverlayNetworks returns the overlay networks currently configured on the
+// host.
+func GetOverlayNetworks() []*OverlayNetwork {
+	overlays, ok := overlayNetworks.Load().([]*OverlayNetwork)
+	if !ok {
+		return refreshOverlayNetworks()
+	}
+	return overlays
+}
+
+// IsOverlayIP returns whether the given IP is part of an overlay network that
+// is configured on the host, and which type the overlay network is.
+func IsOverlayIP(ip net.IP) (overlayType string, ok bool) {
+	for _, overlay := range GetOverlayNetworks() {
+		for _, ipNet := range overlay.Networks {
+			if ipNet.Contains(ip) {
+				return overlay.Type, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// registerOverlayHook reloads the overlay networks when the network changes.
+func registerOverlayHook() error {
+	return module.RegisterEventHook(
+		"netenv",
+		NetworkChangedEvent,
+		"refresh overlay networks",
+		func(_ context.Context, _ interface{}) error {
+			refreshOverlayNetworks()
+			return nil
+		},
+	)
+}
+
+// refreshOverlayNetworks detects the overlay networks on the host and replaces
+// the current ones.
+func refreshOverlayNetworks() []*OverlayNetwork {
+	overlayNetworksLock.Lock()
+	defer overlayNetworksLock.Unlock()
+
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		log.Warningf("netenv: failed to get interfaces for overlay network detection: %s", err)
+		overlays, _ := overlayNetworks.Load().([]*OverlayNetwork)
+		return overlays
+	}
+
+	overlays := make([]*OverlayNetwork, 0)
+	for _, iface := range interfaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		ipNets := make([]*net.IPNet, 0, len(addrs))
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				ipNets = append(ipNets, ipNet)
+			}
+		}
+
+		if overlay := detectOverlay(iface.Name, isTunInterface(iface.Name), ipNets); overlay != nil {
+			log.Debugf("netenv: detected %s overlay network on %s: %v", overlay.Type, overlay.Interface, overlay.Networks)
+			overlays = append(overlays, overlay)
+		}
+	}
+
+	overlayNetworks.Store(overlays)
+	return overlays
+}
+
+// detectOverlay returns the overlay network on the interface with the given
+// name and addresses, if any. Overlays are detected by the interface name.
+// Tunnel interfaces without a known name are only detected by addresses in
+// well-known overlay ranges and only these addresses are used, as the ranges
+// are also used for other purposes, eg. 100.64.0.0/10 for carrier-grade NAT.
+func detectOverlay(ifaceName string, isTun bool, addrs []*net.IPNet) *OverlayNetwork {
+	overlay := &OverlayNetwork{
+		Type:      getOverlayType(ifaceName),
+		Interface: ifaceName,
+	}
+	if overlay.Type == "" && !isTun {
+		return nil
+	}
+
+	for _, ipNet := range addrs {
+		if ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+
+		if getOverlayType(ifaceName) == "" {
+			// Detect overlays by address, eg. Tailscale on utun interfaces.
+			overlayType, ok := isOverlayAddress(ipNet.IP)
+			if !ok || (overlay.Type != "" && overlay.Type != overlayType) {
+				continue
+			}
+			overlay.Type = overlayType
+		}
+
+		overlay.Networks = append(overlay.Networks, overlayRange(overlay.Type, ipNet))
+	}
+
+	if overlay.Type == "" || len(overlay.Networks) == 0 {
+		return nil
+	}
+	return overlay
+}
+
+// overlayRange returns the network that peers of the given overlay type are
+// reachable in. Tailscale assigns a /32 (or /128) to the interface, but peers
+// are spread across the whole range. ZeroTier assigns the network prefix
+// (RFC4193 or 6plane for IPv6, freely configurable for IPv4) directly.
+func overlayRange(overlayType string, ipNet *net.IPNet) *net.IPNet {
+	switch {
+	case overlayType == OverlayTypeTailscale && tailscaleIPv4Net.Contains(ipNet.IP):
+		return tailscaleIPv4Net
+	case overlayType == OverlayTypeTailscale && tailscaleIPv6Net.Contains(ipNet.IP):
+		return tailscaleIPv6Net
+	default:
+		return ipNet
+	}
+}
@@ -0,0 +1,85 @@
+package netenv
+
+import (
+	"net"
+	"testing"
+)
+
+func TestOverlayDetection(t *testing.T) {
+	t.Parallel()
+
+	for ifaceName, expected := range map[string]string{
+		"tailscale0":                      OverlayTypeTailscale,
+		"Tailscale":                       OverlayTypeTailscale,
+		"ztks5abcde":                      OverlayTypeZeroTier,
+		"ZeroTier One [8056c2e21c000001]": OverlayTypeZeroTier,
+		"eth0":                            "",
+		"utun3":                           "",
+	} {
+		if overlayType := getOverlayType(ifaceName); overlayType != expected {
+			t.Errorf("interface %q: got overlay type %q, expected %q", ifaceName, overlayType, expected)
+		}
+	}
+
+	for ip, expected := range map[string]bool{
+		"100.64.0.1":          true,
+		"100.101.102.103":     true,
+		"100.127.255.254":     true,
+		"100.128.0.1":         false,
+		"192.168.1.1":         false,
+		"fd7a:115c:a1e0::1":   true,
+		"fd00:1234:5678::1":   false,
+		"2001:db8::1":         false,
+		"fd7a:115c:a1e1::abc": false,
+	} {
+		if _, ok := isOverlayAddress(net.ParseIP(ip)); ok != expected {
+			t.Errorf("address %s: got overlay %v, expected %v", ip, ok, expected)
+		}
+	}
+
+	// Tailscale peers are spread across the whole CGNAT range.
+	_, assigned, _ := net.ParseCIDR("100.101.102.103/32")
+	if !overlayRange(OverlayTypeTailscale, assigned).Contains(net.ParseIP("100.80.1.2")) {
+		t.Error("tailscale overlay range should cover all of 100.64.0.0/10")
+	}
+}
+
+func TestDetectOverlay(t *testing.T) {
+	t.Parallel()
+
+	parse := func(cidrs ...string) []*net.IPNet {
+		ipNets := make([]*net.IPNet, 0, len(cidrs))
+		for _, cidr := range cidrs {
+			ip, ipNet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ipNet.IP = ip
+			ipNets = append(ipNets, ipNet)
+		}
+		return ipNets
+	}
+
+	// A carrier-grade NAT address on a regular interface is not an overlay.
+	if overlay := detectOverlay("eth0", false, parse("100.72.10.5/10", "192.168.1.5/24")); overlay != nil {
+		t.Errorf("CGNAT interface was detected as overlay: %v", overlay.Networks)
+	}
+
+	// Tunnel interfaces are detected by address, but only with the overlay range.
+	overlay := detectOverlay("utun3", true, parse("100.101.102.103/32", "10.8.0.2/24"))
+	if overlay == nil || overlay.Type != OverlayTypeTailscale {
+		t.Fatalf("tailscale on utun was not detected: %v", overlay)
+	}
+	if len(overlay.Networks) != 1 || overlay.Networks[0] != tailscaleIPv4Net {
+		t.Errorf("unexpected networks of tailscale on utun: %v", overlay.Networks)
+	}
+	if overlay := detectOverlay("tun0", true, parse("10.8.0.2/24")); overlay != nil {
+		t.Errorf("VPN tunnel was detected as overlay: %v", overlay.Networks)
+	}
+
+	// Known interfaces are detected by name.
+	overlay = detectOverlay("ztks5abcde", false, parse("10.147.17.5/24"))
+	if overlay == nil || overlay.Type != OverlayTypeZeroTier || len(overlay.Networks) != 1 {
+		t.Errorf("zerotier interface was not detected: %v", overlay)
+	}
+}
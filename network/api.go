@@ -61,6 +61,10 @@ func registerAPIEndpoints() error {
 		return err
 	}
 
+	if err := registerBypassAttemptsAPI(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -150,7 +154,8 @@ func AddNetworkDebugData(di *debug.Info, profile, where string) {
 		switch conn.Verdict {
 		case VerdictAccept,
 			VerdictRerouteToNameserver,
-			VerdictRerouteToTunnel:
+			VerdictRerouteToTunnel,
+			VerdictRerouteToProxy:
 			accepted++
 		}
 
@@ -226,7 +231,7 @@ func (conn *Connection) debugInfoLine() string {
 	}
 
 	return fmt.Sprintf(
-		"% 14s %s%- 25s %s-%s P#%d [%s] %s - by %s @ %s",
+		"% 14s %s%- 25s %s-%s P#%d [%s] %s - by %s @ %s%s",
 		conn.Verdict.Verb(),
 		connectionData,
 		conn.fmtDomainComponent(),
@@ -237,6 +242,7 @@ func (conn *Connection) debugInfoLine() string {
 		conn.Reason.Msg,
 		conn.Reason.OptionKey,
 		conn.fmtReasonProfileComponent(),
+		conn.fmtTagsComponent(),
 	)
 }
 
@@ -290,6 +296,13 @@ func (conn *Connection) fmtReasonProfileComponent() string {
 	return conn.Reason.Profile
 }
 
+func (conn *Connection) fmtTagsComponent() string {
+	if len(conn.Tags) == 0 {
+		return ""
+	}
+	return " #" + strings.Join(conn.Tags, " #")
+}
+
 type connectionsByGroup []*Connection
 
 func (a connectionsByGroup) Len() int      { return len(a) }
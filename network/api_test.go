@@ -37,6 +37,7 @@ func TestDebugInfoFormatting(t *testing.T) {
 			ASN:           0,
 		},
 		Verdict: 4,
+		Tags:    []string{"lan", "work"},
 		Reason: Reason{
 			Msg:       "incoming connection blocked by default",
 			OptionKey: "filter/serviceEndpoints",
@@ -0,0 +1,247 @@
+package network
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/api"
+)
+
+// Types of attempts to bypass the filtering of the Portmaster.
+const (
+	// BypassEncryptedDNS is the use of an own DNS-over-HTTPS or DNS-over-TLS
+	// resolver.
+	BypassEncryptedDNS = "encrypted-dns"
+	// BypassHardcodedDNS is the use of a hard-coded plain DNS server instead
+	// of the system resolver.
+	BypassHardcodedDNS = "hardcoded-dns"
+	// BypassIPAfterBlockedDNS is a connection to the IP of a domain after the
+	// DNS request for that domain was blocked.
+	BypassIPAfterBlockedDNS = "ip-after-blocked-dns"
+	// BypassProxy is the use of a proxy, which hides the real destination.
+	BypassProxy = "proxy"
+)
+
+const (
+	// bypassAttemptsWindow defines how long bypass attempts are kept.
+	bypassAttemptsWindow = 24 * time.Hour
+
+	// maxBypassAttemptsPerProfile limits the amount of distinct bypass
+	// attempts kept per profile.
+	maxBypassAttemptsPerProfile = 50
+
+	// blockedDomainsWindow defines how long blocked DNS requests are
+	// remembered in order to detect connections to their IPs.
+	blockedDomainsWindow = 10 * time.Minute
+)
+
+// BypassAttempt describes attempts of a profile to bypass the filtering of
+// the Portmaster with the same method and destination.
+type BypassAttempt struct {
+	Type        string
+	Destination string
+	// Count is the amount of attempts.
+	Count     int
+	FirstSeen int64
+	LastSeen  int64
+}
+
+var (
+	// bypassAttempts holds the bypass attempts by scoped profile ID.
+	bypassAttempts     = make(map[string]*profileBypassAttempts)
+	bypassAttemptsLock sync.Mutex
+
+	// blockedDomains holds the domains of blocked DNS requests by scoped
+	// profile ID, mapped to when they were blocked.
+	blockedDomains     = make(map[string]map[string]time.Time)
+	blockedDomainsLock sync.Mutex
+)
+
+// profileBypassAttempts holds the bypass attempts of a profile by type and
+// destination.
+type profileBypassAttempts struct {
+	profileName string
+	attempts    map[string]*BypassAttempt
+}
+
+// ReportBypassAttempt records an attempt of the connection's profile to
+// bypass the filtering. The caller must hold the connection lock.
+func (conn *Connection) ReportBypassAttempt(attemptType, destination string) {
+	if conn.ProcessContext.Profile == "" {
+		return
+	}
+	scopedID := conn.ProcessContext.Source + "/" + conn.ProcessContext.Profile
+	now := time.Now().Unix()
+
+	bypassAttemptsLock.Lock()
+	defer bypassAttemptsLock.Unlock()
+
+	profileAttempts, ok := bypassAttempts[scopedID]
+	if !ok {
+		profileAttempts = &profileBypassAttempts{
+			profileName: conn.ProcessContext.ProfileName,
+			attempts:    make(map[string]*BypassAttempt),
+		}
+		bypassAttempts[scopedID] = profileAttempts
+	}
+
+	key := attemptType + "|" + destination
+	attempt, ok := profileAttempts.attempts[key]
+	if !ok {
+		if len(profileAttempts.attempts) >= maxBypassAttemptsPerProfile {
+			return
+		}
+		attempt = &BypassAttempt{
+			Type:        attemptType,
+			Destination: destination,
+			FirstSeen:   now,
+		}
+		profileAttempts.attempts[key] = attempt
+	}
+	attempt.Count++
+	attempt.LastSeen = now
+}
+
+// GetBypassAttempts returns copies of the recent bypass attempts of the given
+// profile, most recent first.
+func GetBypassAttempts(source, profileID string) []*BypassAttempt {
+	bypassAttemptsLock.Lock()
+	defer bypassAttemptsLock.Unlock()
+
+	profileAttempts, ok := bypassAttempts[source+"/"+profileID]
+	if !ok {
+		return []*BypassAttempt{}
+	}
+	list := make([]*BypassAttempt, 0, len(profileAttempts.attempts))
+	for _, attempt := range profileAttempts.attempts {
+		copied := *attempt
+		list = append(list, &copied)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastSeen > list[j].LastSeen
+	})
+	return list
+}
+
+// addBypassAttemptsToStats removes expired bypass attempts and adds the
+// amount of recent attempts to the given profile stats.
+func addBypassAttemptsToStats(current map[string]*ProfileStats, now time.Time) {
+	threshold := now.Add(-bypassAttemptsWindow).Unix()
+
+	bypassAttemptsLock.Lock()
+	defer bypassAttemptsLock.Unlock()
+
+	for scopedID, profileAttempts := range bypassAttempts {
+		var count int
+		for key, attempt := range profileAttempts.attempts {
+			if attempt.LastSeen < threshold {
+				delete(profileAttempts.attempts, key)
+				continue
+			}
+			count += attempt.Count
+		}
+		if count == 0 {
+			delete(bypassAttempts, scopedID)
+			continue
+		}
+
+		stats, ok := current[scopedID]
+		if !ok {
+			source := strings.SplitN(scopedID, "/", 2)
+			if len(source) != 2 {
+				continue
+			}
+			stats = &ProfileStats{
+				Source:      source[0],
+				Profile:     source[1],
+				ProfileName: profileAttempts.profileName,
+			}
+			current[scopedID] = stats
+		}
+		stats.BypassAttempts = count
+	}
+}
+
+// rememberBlockedDomain remembers the domain of a blocked DNS request. The
+// caller must hold the connection lock.
+func (conn *Connection) rememberBlockedDomain() {
+	if conn.Type != DNSRequest || conn.ProcessContext.Profile == "" {
+		return
+	}
+	scopedID := conn.ProcessContext.Source + "/" + conn.ProcessContext.Profile
+
+	blockedDomainsLock.Lock()
+	defer blockedDomainsLock.Unlock()
+
+	domains, ok := blockedDomains[scopedID]
+	if !ok {
+		domains = make(map[string]time.Time)
+		blockedDomains[scopedID] = domains
+	}
+	domains[conn.Entity.Domain] = time.Now()
+}
+
+// RecentlyBlockedDomain returns the first of the given domains for which a
+// DNS request of the connection's profile was blocked recently. The caller
+// must hold the connection lock.
+func (conn *Connection) RecentlyBlockedDomain(domains ...string) (domain string, ok bool) {
+	scopedID := conn.ProcessContext.Source + "/" + conn.ProcessContext.Profile
+	threshold := time.Now().Add(-blockedDomainsWindow)
+
+	blockedDomainsLock.Lock()
+	defer blockedDomainsLock.Unlock()
+
+	blocked := blockedDomains[scopedID]
+	for _, domain := range domains {
+		if blockedAt, ok := blocked[domain]; ok && blockedAt.After(threshold) {
+			return domain, true
+		}
+	}
+	return "", false
+}
+
+// cleanBlockedDomains removes expired blocked domains.
+func cleanBlockedDomains() {
+	threshold := time.Now().Add(-blockedDomainsWindow)
+
+	blockedDomainsLock.Lock()
+	defer blockedDomainsLock.Unlock()
+
+	for scopedID, domains := range blockedDomains {
+		for domain, blockedAt := range domains {
+			if blockedAt.Before(threshold) {
+				delete(domains, domain)
+			}
+		}
+		if len(domains) == 0 {
+			delete(blockedDomains, scopedID)
+		}
+	}
+}
+
+func registerBypassAttemptsAPI() error {
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:      "network/bypass-attempts",
+		Read:      api.PermitUser,
+		BelongsTo: module,
+		StructFunc: func(ar *api.Request) (i interface{}, err error) {
+			scopedID := strings.SplitN(ar.Request.URL.Query().Get("profile"), "/", 2)
+			if len(scopedID) != 2 {
+				return nil, errors.New("invalid profile, use <source>/<id>")
+			}
+			return GetBypassAttempts(scopedID[0], scopedID[1]), nil
+		},
+		Name:        "Get Bypass Attempts",
+		Description: "Returns the attempts of an app to bypass the filtering within the last 24 hours, such as using its own encrypted DNS resolver, a hard-coded DNS server, connecting to IPs of blocked domains or using a proxy.",
+		Parameters: []api.Parameter{{
+			Method:      http.MethodGet,
+			Field:       "profile",
+			Value:       "local/<profile ID>",
+			Description: "Specify the scoped ID of the profile.",
+		}},
+	})
+}
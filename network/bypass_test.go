@@ -0,0 +1,48 @@
+package network
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBypassAttempts(t *testing.T) {
+	conn := &Connection{
+		ProcessContext: ProcessContext{
+			Source:      "local",
+			Profile:     "bypass-test",
+			ProfileName: "Bypass Test",
+		},
+	}
+
+	conn.ReportBypassAttempt(BypassHardcodedDNS, "8.8.8.8:53")
+	conn.ReportBypassAttempt(BypassHardcodedDNS, "8.8.8.8:53")
+	conn.ReportBypassAttempt(BypassProxy, "proxy.example.com")
+
+	attempts := GetBypassAttempts("local", "bypass-test")
+	if len(attempts) != 2 {
+		t.Fatalf("expected 2 distinct bypass attempts, got %d", len(attempts))
+	}
+
+	current := make(map[string]*ProfileStats)
+	addBypassAttemptsToStats(current, time.Now())
+	stats, ok := current["local/bypass-test"]
+	if !ok {
+		t.Fatal("expected profile stats for profile with bypass attempts")
+	}
+	if stats.BypassAttempts != 3 {
+		t.Errorf("expected 3 bypass attempts, got %d", stats.BypassAttempts)
+	}
+	if stats.ProfileName != "Bypass Test" {
+		t.Errorf("unexpected profile name %q", stats.ProfileName)
+	}
+
+	// Attempts expire after the window.
+	current = make(map[string]*ProfileStats)
+	addBypassAttemptsToStats(current, time.Now().Add(bypassAttemptsWindow+time.Minute))
+	if len(current) != 0 {
+		t.Errorf("expected bypass attempts to expire, got %d profiles", len(current))
+	}
+	if attempts := GetBypassAttempts("local", "bypass-test"); len(attempts) != 0 {
+		t.Errorf("expected no bypass attempts after expiry, got %d", len(attempts))
+	}
+}
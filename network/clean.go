@@ -29,6 +29,7 @@ func connectionCleaner(ctx context.Context) error {
 			// clean connections and processes
 			activePIDs := cleanConnections()
 			process.CleanProcessStorage(activePIDs)
+			cleanMPTCPTokens()
 
 			// clean udp connection states
 			state.CleanUDPStates(ctx)
@@ -12,10 +12,13 @@
 	"github.com/safing/portbase/database/record"
 	"github.com/safing/portbase/log"
 	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/l10n"
 	"github.com/safing/portmaster/network/netutils"
 	"github.com/safing/portmaster/network/packet"
 	"github.com/safing/portmaster/process"
+	"github.com/safing/portmaster/profile"
 	"github.com/safing/portmaster/resolver"
+	"github.com/safing/portmaster/tracing"
 )
 
 // FirewallHandler defines the function signature for a firewall
@@ -42,6 +45,9 @@ type ProcessContext struct {
 	Profile string
 	// Source is the source of the profile.
 	Source string
+	// Device is the hardware address of the network device the connection
+	// was served to, if it was identified.
+	Device string `json:",omitempty"`
 }
 
 type ConnectionType int8
@@ -106,6 +112,18 @@ type Connection struct {
 	// Resolver holds information about the resolver used to resolve
 	// Entity.Domain.
 	Resolver *resolver.ResolverInfo
+	// DomainConfidence describes how certain the attribution of Entity.Domain
+	// to the connection is. Domains with low confidence are not attributed,
+	// but only added as "possible-domain" annotation.
+	DomainConfidence resolver.DomainConfidence
+	// MPTCPParentID holds the ID of the first subflow of the multipath TCP
+	// connection, if this connection is an additional subflow of it.
+	// Subflows are handled as part of the logical connection of the first
+	// subflow.
+	MPTCPParentID string
+	// TCPFastOpen is set if the connection was opened with data in the SYN
+	// packet.
+	TCPFastOpen bool
 	// Verdict is the final decision that has been made for a connection.
 	// The verdict may change so any access to it must be guarded by the
 	// connection lock.
@@ -114,6 +132,14 @@ type Connection struct {
 	// information about the reason.
 	// Access to Reason must be guarded by the connection lock.
 	Reason Reason
+	// Annotations holds additional notes about the connection that were
+	// added by policy scripts or plugins. Access to Annotations must be
+	// guarded by the connection lock.
+	Annotations []string
+	// Tags holds the tags of the profile and the destination that the user
+	// added. They are set when the connection object is created and are
+	// considered immutable afterwards.
+	Tags []string
 	// Started holds the number of seconds in UNIX epoch time at which
 	// the connection has been initated and first seen by the portmaster.
 	// Started is only ever set when creating a new connection object
@@ -145,6 +171,16 @@ type Connection struct {
 	// Portmaster internal connection. Internal may be set at different
 	// points and access to it must be guarded by the connection lock.
 	Internal bool
+	// BytesSent and BytesReceived hold the amount of bytes sent and received
+	// on the connection, including headers. They are only counted while the
+	// packets of the connection are handled by the Portmaster, eg. when a
+	// bandwidth limit applies. Access must be guarded by the connection lock.
+	BytesSent     uint64
+	BytesReceived uint64
+	// ThrottledPackets holds the amount of packets that were dropped in order
+	// to enforce a bandwidth limit. Access must be guarded by the connection
+	// lock.
+	ThrottledPackets uint64
 	// process holds a reference to the actor process. That is, the
 	// process instance that initated the connection.
 	process *process.Process
@@ -173,6 +209,20 @@ type Connection struct {
 	// addedToMetrics signifies if the connection has already been counted in
 	// the metrics.
 	addedToMetrics bool
+	// addedToProfileStats signifies if the connection has already been
+	// counted in the profile stats.
+	addedToProfileStats bool
+	// addedToTopTalkers signifies if the connection has already been counted
+	// in the top talkers.
+	addedToTopTalkers bool
+	// created holds when the connection was created from its first packet.
+	created time.Time
+	// unsampled is set if the connection was skipped by connection sampling.
+	// Unsampled connections are neither enriched nor persisted.
+	unsampled bool
+	// trafficSaved holds when the connection was last saved because of
+	// changed traffic counters.
+	trafficSaved time.Time
 }
 
 // Reason holds information justifying a verdict, as well as additional
@@ -180,6 +230,9 @@ type Connection struct {
 type Reason struct {
 	// Msg is a human readable description of the reason.
 	Msg string
+	// LocalizedMsg holds Msg translated to the configured language, if a
+	// translation is available.
+	LocalizedMsg string `json:",omitempty"`
 	// OptionKey is the configuration option key of the setting that
 	// was responsible for the verdict.
 	OptionKey string
@@ -191,6 +244,15 @@ type Reason struct {
 	Context interface{}
 }
 
+// LocalizedText returns the description of the reason in the configured
+// language, if a translation is available.
+func (r Reason) LocalizedText() string {
+	if r.LocalizedMsg != "" {
+		return r.LocalizedMsg
+	}
+	return r.Msg
+}
+
 func getProcessContext(ctx context.Context, proc *process.Process) ProcessContext {
 	// Gather process information.
 	pCtx := ProcessContext{
@@ -198,6 +260,7 @@ func getProcessContext(ctx context.Context, proc *process.Process) ProcessContex
 		BinaryPath:  proc.Path,
 		CmdLine:     proc.CmdLine,
 		PID:         proc.Pid,
+		Device:      proc.NetworkHostDevice(),
 	}
 
 	// Get local profile.
@@ -223,8 +286,9 @@ func NewConnectionFromDNSRequest(ctx context.Context, fqdn string, cnames []stri
 	}
 
 	// get Process
+	lookupCtx, span := tracing.StartSpan(ctx, "profile lookup")
 	proc, _, err := process.GetProcessByConnection(
-		ctx,
+		lookupCtx,
 		&packet.Info{
 			Inbound:  false, // outbound as we are looking for the process of the source address
 			Version:  ipVersion,
@@ -235,6 +299,7 @@ func NewConnectionFromDNSRequest(ctx context.Context, fqdn string, cnames []stri
 			DstPort:  0,         // do not record direction
 		},
 	)
+	span.End()
 	if err != nil {
 		log.Tracer(ctx).Debugf("network: failed to find process of dns request for %s: %s", fqdn, err)
 		proc = process.GetUnidentifiedProcess(ctx)
@@ -255,9 +320,10 @@ func NewConnectionFromDNSRequest(ctx context.Context, fqdn string, cnames []stri
 		Ended:          timestamp,
 	}
 
-	// Inherit internal status of profile.
+	// Inherit internal status and tags of profile.
 	if localProfile := proc.Profile().LocalProfile(); localProfile != nil {
 		dnsConn.Internal = localProfile.Internal
+		dnsConn.Tags = profile.GetDestinationTags(localProfile, fqdn, nil)
 	}
 
 	// Always mark dns queries from the system resolver as internal.
@@ -294,9 +360,10 @@ func NewConnectionFromExternalDNSRequest(ctx context.Context, fqdn string, cname
 		Ended:          timestamp,
 	}
 
-	// Inherit internal status of profile.
+	// Inherit internal status and tags of profile.
 	if localProfile := remoteHost.Profile().LocalProfile(); localProfile != nil {
 		dnsConn.Internal = localProfile.Internal
+		dnsConn.Tags = profile.GetDestinationTags(localProfile, fqdn, nil)
 	}
 
 	// DNS Requests are saved by the nameserver depending on the result of the
@@ -306,13 +373,114 @@ func NewConnectionFromExternalDNSRequest(ctx context.Context, fqdn string, cname
 	return dnsConn, nil
 }
 
+// NewSyntheticDNSRequest returns a new DNS request of the given process for
+// the given domain. It does not represent a real request and must not be
+// saved. It is used to evaluate the configuration.
+func NewSyntheticDNSRequest(ctx context.Context, connID string, proc *process.Process, fqdn string) *Connection {
+	timestamp := time.Now().Unix()
+	return &Connection{
+		ID:    connID,
+		Type:  DNSRequest,
+		Scope: fqdn,
+		Entity: &intel.Entity{
+			Domain: fqdn,
+		},
+		process:        proc,
+		ProcessContext: getProcessContext(ctx, proc),
+		Started:        timestamp,
+		Ended:          timestamp,
+	}
+}
+
+// NewSyntheticConnection returns a new outgoing IP connection of the given
+// process to the given entity. It does not represent a real connection and
+// must not be saved. It is used to measure the decision process.
+func NewSyntheticConnection(ctx context.Context, connID string, proc *process.Process, entity *intel.Entity) *Connection {
+	ipVersion := packet.IPv4
+	if entity.IP != nil && entity.IP.To4() == nil {
+		ipVersion = packet.IPv6
+	}
+
+	timestamp := time.Now().Unix()
+	return &Connection{
+		ID:             connID,
+		Type:           IPConnection,
+		Scope:          entity.Domain,
+		IPVersion:      ipVersion,
+		IPProtocol:     packet.IPProtocol(entity.Protocol),
+		Entity:         entity,
+		process:        proc,
+		ProcessContext: getProcessContext(ctx, proc),
+		Started:        timestamp,
+		Ended:          timestamp,
+	}
+}
+
+// getDomainOfIP returns the domain that most probably belongs to the remote
+// IP of the packet, together with the confidence of the attribution.
+func getDomainOfIP(pkt packet.Packet, proc *process.Process) (*resolver.ResolvedDomain, resolver.DomainConfidence) {
+	remoteIP := pkt.Info().RemoteIP()
+	profileID := proc.Profile().LocalProfile().ID
+
+	// Check for domains resolved by the same profile.
+	ipinfo, err := resolver.GetIPInfo(profileID, remoteIP.String())
+	if err == nil {
+		if resolved := ipinfo.GetDomain(); resolved != nil {
+			if resolved.Expired() {
+				log.Tracer(pkt.Ctx()).Tracef("network: using expired domain %s for %s", resolved.Domain, remoteIP)
+				return resolved, resolver.DomainConfidenceMedium
+			}
+			return resolved, resolver.DomainConfidenceHigh
+		}
+	}
+
+	// Try again with the global scope, in case DNS went through the system resolver.
+	ipinfo, err = resolver.GetIPInfo(resolver.IPInfoProfileScopeGlobal, remoteIP.String())
+	if err == nil {
+		if resolved := ipinfo.GetDomain(); resolved != nil {
+			return resolved, resolver.DomainConfidenceMedium
+		}
+	}
+
+	// Finally, check the recent DNS answers of all applications.
+	resolved, confidence := resolver.GetRecentAnswer(remoteIP, profileID, proc.Pid)
+	if resolved != nil {
+		log.Tracer(pkt.Ctx()).Tracef("network: attributed %s to %s from recent dns answers with %s confidence", remoteIP, resolved.Domain, confidence)
+	}
+	return resolved, confidence
+}
+
 // NewConnectionFromFirstPacket returns a new connection based on the given packet.
 func NewConnectionFromFirstPacket(pkt packet.Packet) *Connection {
+	created := time.Now()
+
+	// Start span for tracing the decision pipeline. It is ended by the firewall
+	// when the first packet was handled.
+	ctx, _ := tracing.StartSpan(pkt.Ctx(), "new connection")
+	pkt.SetCtx(ctx)
+
 	// get Process
-	proc, inbound, err := process.GetProcessByConnection(pkt.Ctx(), pkt.Info())
-	if err != nil {
-		log.Tracer(pkt.Ctx()).Debugf("network: failed to find process of packet %s: %s", pkt, err)
-		proc = process.GetUnidentifiedProcess(pkt.Ctx())
+	var proc *process.Process
+	var inbound bool
+	tcpInfo := pkt.TCPInfo()
+	mptcpParent, isSubflow := getMPTCPParent(tcpInfo)
+	if isSubflow {
+		// Additional subflows of multipath TCP connections are opened by the
+		// kernel and belong to the process of the first subflow.
+		proc = mptcpParent.Process()
+		inbound = pkt.IsInbound()
+		log.Tracer(pkt.Ctx()).Tracef("network: packet %s is a multipath tcp subflow of %s", pkt, mptcpParent.ID)
+	} else {
+		lookupCtx, span := tracing.StartSpan(ctx, "profile lookup")
+		lookupStarted := time.Now()
+		var err error
+		proc, inbound, err = process.GetProcessByConnection(lookupCtx, pkt.Info())
+		tracing.ObserveStage(tracing.StageProfileLookup, lookupStarted)
+		span.End()
+		if err != nil {
+			log.Tracer(pkt.Ctx()).Debugf("network: failed to find process of packet %s: %s", pkt, err)
+			proc = process.GetUnidentifiedProcess(pkt.Ctx())
+		}
 	}
 
 	// Create the (remote) entity.
@@ -325,6 +493,9 @@ func NewConnectionFromFirstPacket(pkt packet.Packet) *Connection {
 
 	var scope string
 	var resolverInfo *resolver.ResolverInfo
+	var lastResolvedDomain *resolver.ResolvedDomain
+	var domainConfidence resolver.DomainConfidence
+	var possibleDomain string
 
 	if inbound {
 
@@ -345,20 +516,20 @@ func NewConnectionFromFirstPacket(pkt packet.Packet) *Connection {
 	} else {
 
 		// check if we can find a domain for that IP
-		ipinfo, err := resolver.GetIPInfo(proc.Profile().LocalProfile().ID, pkt.Info().RemoteIP().String())
-		if err != nil {
-			// Try again with the global scope, in case DNS went through the system resolver.
-			ipinfo, err = resolver.GetIPInfo(resolver.IPInfoProfileScopeGlobal, pkt.Info().RemoteIP().String())
+		lastResolvedDomain, domainConfidence = getDomainOfIP(pkt, proc)
+		if domainConfidence == resolver.DomainConfidenceLow {
+			// Domains resolved by other applications are not verified enough
+			// to be matched by rules, only note them.
+			possibleDomain = lastResolvedDomain.Domain
+			lastResolvedDomain = nil
+			domainConfidence = resolver.DomainConfidenceNone
 		}
-		if err == nil {
-			lastResolvedDomain := ipinfo.MostRecentDomain()
-			if lastResolvedDomain != nil {
-				scope = lastResolvedDomain.Domain
-				entity.Domain = lastResolvedDomain.Domain
-				entity.CNAME = lastResolvedDomain.CNAMEs
-				resolverInfo = lastResolvedDomain.Resolver
-				removeOpenDNSRequest(proc.Pid, lastResolvedDomain.Domain)
-			}
+		if lastResolvedDomain != nil {
+			scope = lastResolvedDomain.Domain
+			entity.Domain = lastResolvedDomain.Domain
+			entity.CNAME = lastResolvedDomain.CNAMEs
+			resolverInfo = lastResolvedDomain.Resolver
+			removeOpenDNSRequest(proc.Pid, lastResolvedDomain.Domain)
 		}
 
 		// check if destination IP is the captive portal's IP
@@ -366,6 +537,7 @@ func NewConnectionFromFirstPacket(pkt packet.Packet) *Connection {
 		if pkt.Info().RemoteIP().Equal(portal.IP) {
 			scope = portal.Domain
 			entity.Domain = portal.Domain
+			domainConfidence = resolver.DomainConfidenceHigh
 		}
 
 		if scope == "" {
@@ -403,16 +575,31 @@ func NewConnectionFromFirstPacket(pkt packet.Packet) *Connection {
 		// remote endpoint
 		Entity: entity,
 		// resolver used to resolve dns request
-		Resolver: resolverInfo,
+		Resolver:         resolverInfo,
+		DomainConfidence: domainConfidence,
 		// meta
 		Started:                time.Now().Unix(),
 		ProfileRevisionCounter: proc.Profile().RevisionCnt(),
 	}
 	newConn.SetLocalIP(pkt.Info().LocalIP())
+	newConn.created = created
+	if isSubflow {
+		newConn.MPTCPParentID = mptcpParent.ID
+	}
+	if tcpInfo != nil {
+		newConn.TCPFastOpen = tcpInfo.FastOpen
+	}
+	if possibleDomain != "" {
+		newConn.AddAnnotation("possible-domain:" + possibleDomain)
+	}
+	newConn.unsampled = !sampleConnection()
 
-	// Inherit internal status of profile.
+	// Inherit internal status and tags of profile.
 	if localProfile := proc.Profile().LocalProfile(); localProfile != nil {
 		newConn.Internal = localProfile.Internal
+		if !newConn.unsampled {
+			newConn.Tags = profile.GetDestinationTags(localProfile, entity.Domain, entity.IP)
+		}
 	}
 
 	// Save connection to internal state in order to mitigate creation of
@@ -422,11 +609,28 @@ func NewConnectionFromFirstPacket(pkt packet.Packet) *Connection {
 	return newConn
 }
 
+// Created returns when the connection was created from its first packet. It
+// is zero for connections that were not created from a packet.
+func (conn *Connection) Created() time.Time {
+	return conn.created
+}
+
 // GetConnection fetches a Connection from the database.
 func GetConnection(id string) (*Connection, bool) {
 	return conns.get(id)
 }
 
+// GetAllConnections returns all IP connections that are currently tracked.
+// The returned connections are not locked.
+func GetAllConnections() []*Connection {
+	all := conns.clone()
+	list := make([]*Connection, 0, len(all))
+	for _, conn := range all {
+		list = append(list, conn)
+	}
+	return list
+}
+
 // SetLocalIP sets the local IP address together with its network scope. The
 // connection is not locked for this.
 func (conn *Connection) SetLocalIP(ip net.IP) {
@@ -501,6 +705,10 @@ func (conn *Connection) SetVerdict(newVerdict Verdict, reason, reasonOptionKey s
 	if newVerdict >= conn.Verdict {
 		conn.Verdict = newVerdict
 		conn.Reason.Msg = reason
+		conn.Reason.LocalizedMsg = ""
+		if translated := l10n.T(reason); translated != reason {
+			conn.Reason.LocalizedMsg = translated
+		}
 		conn.Reason.Context = reasonCtx
 		if reasonOptionKey != "" && conn.Process() != nil {
 			conn.Reason.OptionKey = reasonOptionKey
@@ -511,6 +719,17 @@ func (conn *Connection) SetVerdict(newVerdict Verdict, reason, reasonOptionKey s
 	return false
 }
 
+// AddAnnotation adds the given annotation to the connection, if it does not
+// exist yet. The connection must be locked.
+func (conn *Connection) AddAnnotation(annotation string) {
+	for _, existing := range conn.Annotations {
+		if existing == annotation {
+			return
+		}
+	}
+	conn.Annotations = append(conn.Annotations, annotation)
+}
+
 // Process returns the connection's process.
 func (conn *Connection) Process() *process.Process {
 	return conn.process
@@ -528,6 +747,10 @@ func (conn *Connection) SaveWhenFinished() {
 // Save().
 func (conn *Connection) Save() {
 	conn.addToMetrics()
+	if !conn.unsampled {
+		conn.addToProfileStats()
+		conn.addToTopTalkers()
+	}
 	conn.UpdateMeta()
 
 	if !conn.KeyIsSet() {
@@ -540,6 +763,11 @@ func (conn *Connection) Save() {
 		}
 	}
 
+	// Unsampled connections are only kept internally.
+	if conn.unsampled {
+		return
+	}
+
 	// notify database controller
 	dbController.PushUpdate(conn)
 }
@@ -558,7 +786,9 @@ func (conn *Connection) delete() {
 	}
 
 	conn.Meta().Delete()
-	dbController.PushUpdate(conn)
+	if !conn.unsampled {
+		dbController.PushUpdate(conn)
+	}
 }
 
 // SetFirewallHandler sets the firewall handler for this link, and starts a
@@ -100,7 +100,7 @@ func (s *StorageInterface) Get(key string) (record.Record, error) {
 			return r, nil
 		}
 	case "ip":
-		if r, ok := conns.get(id); ok {
+		if r, ok := conns.get(id); ok && !r.unsampled {
 			return r, nil
 		}
 	case "":
@@ -153,6 +153,9 @@ func (s *StorageInterface) processQuery(q *query.Query, it *iterator.Iterator) {
 	if scope == "" || scope == "ip" {
 		// connections
 		for _, conn := range conns.clone() {
+			if conn.unsampled {
+				continue
+			}
 			conn.Lock()
 			if q.Matches(conn) {
 				it.Next <- conn
@@ -35,6 +35,18 @@ func start() error {
 		return err
 	}
 
+	if err := registerProfileStatsProvider(); err != nil {
+		return err
+	}
+
+	if err := registerTopTalkersAPI(); err != nil {
+		return err
+	}
+
+	if err := registerSamplingAPI(); err != nil {
+		return err
+	}
+
 	module.StartServiceWorker("clean connections", 0, connectionCleaner)
 	module.StartServiceWorker("write open dns requests", 0, openDNSRequestWriter)
 
@@ -0,0 +1,60 @@
+package network
+
+import (
+	"sync"
+
+	"github.com/safing/portmaster/network/packet"
+)
+
+var (
+	// mptcpTokens maps the tokens of multipath TCP connections to the ID of
+	// their first subflow.
+	mptcpTokens     = make(map[uint32]string)
+	mptcpTokensLock sync.Mutex
+)
+
+// LearnMPTCPKeys registers the tokens of the multipath TCP keys in the given
+// TCP details for the connection, so that further subflows can be attributed
+// to it. It returns whether both keys of the connection are known.
+func (conn *Connection) LearnMPTCPKeys(tcpInfo *packet.TCPInfo) (complete bool) {
+	if tcpInfo == nil || !tcpInfo.MPTCPCapable {
+		return false
+	}
+
+	mptcpTokensLock.Lock()
+	defer mptcpTokensLock.Unlock()
+
+	for _, key := range tcpInfo.MPTCPKeys {
+		mptcpTokens[packet.MPTCPToken(key, tcpInfo.MPTCPVersion)] = conn.ID
+	}
+	return len(tcpInfo.MPTCPKeys) == 2
+}
+
+// getMPTCPParent returns the first subflow of the multipath TCP connection
+// that is joined with the given TCP details, if any.
+func getMPTCPParent(tcpInfo *packet.TCPInfo) (*Connection, bool) {
+	if tcpInfo == nil || !tcpInfo.MPTCPJoin || tcpInfo.MPTCPJoinToken == 0 {
+		return nil, false
+	}
+
+	mptcpTokensLock.Lock()
+	connID, ok := mptcpTokens[tcpInfo.MPTCPJoinToken]
+	mptcpTokensLock.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	return GetConnection(connID)
+}
+
+// cleanMPTCPTokens removes the tokens of connections that were deleted.
+func cleanMPTCPTokens() {
+	mptcpTokensLock.Lock()
+	defer mptcpTokensLock.Unlock()
+
+	for token, connID := range mptcpTokens {
+		if _, ok := conns.get(connID); !ok {
+			delete(mptcpTokens, token)
+		}
+	}
+}
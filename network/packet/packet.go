@@ -228,6 +228,7 @@ type Packet interface {
 	PermanentDrop() error
 	RerouteToNameserver() error
 	RerouteToTunnel() error
+	RerouteToProxy() error
 	FastTrackedByIntegration() bool
 
 	// INFO
@@ -247,6 +248,7 @@ type Packet interface {
 	Layers() gopacket.Packet
 	Raw() []byte
 	Payload() []byte
+	TCPInfo() *TCPInfo
 
 	// MATCHING
 	MatchesAddress(bool, IPProtocol, *net.IPNet, uint16) bool
@@ -0,0 +1,98 @@
+package packet
+
+import (
+	"crypto/sha1" //nolint:gosec // Required by MPTCPv0.
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/google/gopacket/layers"
+)
+
+// TCP option kind and subtypes of multipath TCP (RFC 8684).
+const (
+	tcpOptionKindMPTCP = 30
+
+	mptcpSubtypeCapable = 0
+	mptcpSubtypeJoin    = 1
+)
+
+// TCPInfo holds details of a TCP packet that are relevant for tracking the
+// logical connection of the packet.
+type TCPInfo struct {
+	SYN bool
+	ACK bool
+
+	// FastOpen is set for SYN packets that already carry data (TFO).
+	FastOpen bool
+
+	// MPTCPCapable is set if the packet carries the MP_CAPABLE option, which
+	// is used on the first subflow of a multipath TCP connection.
+	MPTCPCapable bool
+	// MPTCPVersion is the multipath TCP version of the MP_CAPABLE option.
+	MPTCPVersion uint8
+	// MPTCPKeys holds the keys of the MP_CAPABLE option, if present.
+	MPTCPKeys [][]byte
+
+	// MPTCPJoin is set if the packet carries the MP_JOIN option, which is used
+	// to add a subflow to an existing multipath TCP connection.
+	MPTCPJoin bool
+	// MPTCPJoinToken is the token of the multipath TCP connection the subflow
+	// joins. It is only set on SYN packets.
+	MPTCPJoinToken uint32
+}
+
+// TCPInfo returns the TCP details of the packet. It returns nil if the packet
+// is not a TCP packet or has not been parsed.
+func (pkt *Base) TCPInfo() *TCPInfo {
+	if pkt.info.Protocol != TCP || pkt.layers == nil {
+		return nil
+	}
+	tcp, ok := pkt.layers.TransportLayer().(*layers.TCP)
+	if !ok {
+		return nil
+	}
+
+	info := &TCPInfo{
+		SYN:      tcp.SYN,
+		ACK:      tcp.ACK,
+		FastOpen: tcp.SYN && len(tcp.Payload) > 0,
+	}
+
+	for _, option := range tcp.Options {
+		if option.OptionType != tcpOptionKindMPTCP || len(option.OptionData) < 1 {
+			continue
+		}
+		// Option data starts after kind and length.
+		data := option.OptionData
+
+		switch data[0] >> 4 {
+		case mptcpSubtypeCapable:
+			info.MPTCPCapable = true
+			info.MPTCPVersion = data[0] & 0x0f
+			// Subtype and version, flags, then up to two keys.
+			for offset := 2; offset+8 <= len(data) && len(info.MPTCPKeys) < 2; offset += 8 {
+				info.MPTCPKeys = append(info.MPTCPKeys, data[offset:offset+8])
+			}
+
+		case mptcpSubtypeJoin:
+			info.MPTCPJoin = true
+			// Subtype and flags, address ID, then the token in SYN packets.
+			if tcp.SYN && !tcp.ACK && len(data) >= 6 {
+				info.MPTCPJoinToken = binary.BigEndian.Uint32(data[2:6])
+			}
+		}
+	}
+
+	return info
+}
+
+// MPTCPToken returns the token that identifies a multipath TCP connection in
+// MP_JOIN options, derived from the given key of the MP_CAPABLE option.
+func MPTCPToken(key []byte, version uint8) uint32 {
+	if version == 0 {
+		sum := sha1.Sum(key) //nolint:gosec // Required by MPTCPv0.
+		return binary.BigEndian.Uint32(sum[:4])
+	}
+	sum := sha256.Sum256(key)
+	return binary.BigEndian.Uint32(sum[:4])
+}
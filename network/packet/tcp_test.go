@@ -0,0 +1,80 @@
+package packet
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func buildTCPPacket(t *testing.T, tcp *layers.TCP, payload []byte) *Base {
+	t.Helper()
+
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    net.IPv4(192, 0, 2, 1),
+		DstIP:    net.IPv4(192, 0, 2, 2),
+	}
+	if err := tcp.SetNetworkLayerForChecksum(ip); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ip, tcp, gopacket.Payload(payload)); err != nil {
+		t.Fatal(err)
+	}
+
+	pkt := &Base{}
+	if err := Parse(buf.Bytes(), pkt); err != nil {
+		t.Fatal(err)
+	}
+	return pkt
+}
+
+func TestTCPInfo(t *testing.T) {
+	t.Parallel()
+
+	key := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	// SYN with data and MP_CAPABLE (v0) carrying the key of the sender.
+	info := buildTCPPacket(t, &layers.TCP{
+		SrcPort: 50000,
+		DstPort: 443,
+		SYN:     true,
+		Options: []layers.TCPOption{{
+			OptionType:   tcpOptionKindMPTCP,
+			OptionLength: 12,
+			OptionData:   append([]byte{mptcpSubtypeCapable << 4, 0x81}, key...),
+		}},
+	}, []byte("hello")).TCPInfo()
+	if info == nil || !info.SYN || !info.FastOpen {
+		t.Fatalf("expected SYN with data, got %+v", info)
+	}
+	if !info.MPTCPCapable || info.MPTCPVersion != 0 || len(info.MPTCPKeys) != 1 {
+		t.Fatalf("expected MP_CAPABLE with one key, got %+v", info)
+	}
+
+	// SYN with MP_JOIN carrying the token derived from the key.
+	token := MPTCPToken(key, 0)
+	info = buildTCPPacket(t, &layers.TCP{
+		SrcPort: 50001,
+		DstPort: 443,
+		SYN:     true,
+		Options: []layers.TCPOption{{
+			OptionType:   tcpOptionKindMPTCP,
+			OptionLength: 12,
+			OptionData: []byte{
+				mptcpSubtypeJoin << 4, 0,
+				byte(token >> 24), byte(token >> 16), byte(token >> 8), byte(token),
+				0, 0, 0, 1,
+			},
+		}},
+	}, nil).TCPInfo()
+	if info == nil || info.FastOpen || !info.MPTCPJoin || info.MPTCPJoinToken != token {
+		t.Fatalf("expected MP_JOIN with token %d, got %+v", token, info)
+	}
+}
@@ -0,0 +1,269 @@
+package network
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/portbase/runtime"
+	"github.com/safing/portmaster/profile"
+)
+
+const (
+	profileStatsProviderPrefix = "network/profile-stats/"
+	profileStatsUpdateInterval = 5 * time.Second
+
+	blockedStatsWindow     = time.Hour
+	blockedStatsBucketSize = time.Minute
+	blockedStatsBuckets    = int(blockedStatsWindow / blockedStatsBucketSize)
+)
+
+var (
+	pushProfileStats runtime.PushFunc = func(...record.Record) {}
+
+	profileStats     = make(map[string]*ProfileStats)
+	profileStatsLock sync.Mutex
+
+	blockedStats     = make(map[string]*blockedCounter)
+	blockedStatsLock sync.Mutex
+)
+
+// ProfileStats is a compact summary of the network activity of a profile. It
+// is updated at a throttled rate, so that the tray and notifiers can show
+// badges without subscribing to all connections.
+type ProfileStats struct {
+	record.Base
+	sync.Mutex
+
+	// Profile is the ID of the profile.
+	Profile string
+	// Source is the source of the profile.
+	Source string
+	// ProfileName is the name of the profile.
+	ProfileName string
+
+	// ActiveConnections is the amount of connections that have not yet ended.
+	ActiveConnections int
+	// BlockedLastHour is the amount of connections and DNS requests that
+	// were blocked or dropped within the last hour.
+	BlockedLastHour int
+	// ConnectionsPerMinute is the amount of connections that were started
+	// within the last minute. There is no accounting of transferred data, so
+	// this is the best available measure of the data rate.
+	ConnectionsPerMinute int
+	// BypassAttempts is the amount of attempts to bypass the filtering
+	// within the last 24 hours. Details are available via the
+	// network/bypass-attempts API endpoint.
+	BypassAttempts int
+}
+
+// blockedCounter counts blocked connections in per-minute buckets.
+type blockedCounter struct {
+	profileName string
+
+	buckets [blockedStatsBuckets]int
+	// slots holds the minute each bucket belongs to.
+	slots [blockedStatsBuckets]int64
+}
+
+func (bc *blockedCounter) add(now time.Time) {
+	slot := now.Unix() / int64(blockedStatsBucketSize/time.Second)
+	idx := int(slot % int64(blockedStatsBuckets))
+	if bc.slots[idx] != slot {
+		bc.slots[idx] = slot
+		bc.buckets[idx] = 0
+	}
+	bc.buckets[idx]++
+}
+
+func (bc *blockedCounter) sum(now time.Time) (total int) {
+	oldest := now.Unix()/int64(blockedStatsBucketSize/time.Second) - int64(blockedStatsBuckets)
+	for idx, slot := range bc.slots {
+		if slot > oldest {
+			total += bc.buckets[idx]
+		}
+	}
+	return total
+}
+
+func registerProfileStatsProvider() error {
+	push, err := runtime.Register(
+		profileStatsProviderPrefix,
+		runtime.SimpleValueGetterFunc(getProfileStats),
+	)
+	if err != nil {
+		return err
+	}
+	pushProfileStats = push
+
+	module.NewTask("update profile stats", updateProfileStats).Repeat(profileStatsUpdateInterval)
+	return nil
+}
+
+func getProfileStats(key string) ([]record.Record, error) {
+	key = strings.TrimPrefix(key, profileStatsProviderPrefix)
+
+	profileStatsLock.Lock()
+	defer profileStatsLock.Unlock()
+
+	records := make([]record.Record, 0, len(profileStats))
+	for scopedID, stats := range profileStats {
+		if strings.HasPrefix(scopedID, key) {
+			records = append(records, stats)
+		}
+	}
+	return records, nil
+}
+
+// addToProfileStats counts the connection in the stats of its profile, if it
+// was blocked. Connections are not counted in guest mode. The caller must hold
+// the connection lock.
+func (conn *Connection) addToProfileStats() {
+	if conn.addedToProfileStats || conn.ProcessContext.Profile == "" || conn.MPTCPParentID != "" {
+		return
+	}
+
+	switch conn.Verdict {
+	case VerdictBlock, VerdictDrop:
+	default:
+		return
+	}
+	conn.addedToProfileStats = true
+	conn.rememberBlockedDomain()
+	if profile.EphemeralSessionActive() {
+		return
+	}
+
+	scopedID := conn.ProcessContext.Source + "/" + conn.ProcessContext.Profile
+	blockedStatsLock.Lock()
+	defer blockedStatsLock.Unlock()
+
+	counter, ok := blockedStats[scopedID]
+	if !ok {
+		counter = &blockedCounter{
+			profileName: conn.ProcessContext.ProfileName,
+		}
+		blockedStats[scopedID] = counter
+	}
+	counter.add(time.Now())
+}
+
+func updateProfileStats(_ context.Context, _ *modules.Task) error {
+	now := time.Now()
+	current := collectProfileStats(now)
+	addBypassAttemptsToStats(current, now)
+	cleanBlockedDomains()
+
+	profileStatsLock.Lock()
+	defer profileStatsLock.Unlock()
+
+	for scopedID, stats := range current {
+		existing, ok := profileStats[scopedID]
+		if ok {
+			existing.Lock()
+			changed := existing.ActiveConnections != stats.ActiveConnections ||
+				existing.BlockedLastHour != stats.BlockedLastHour ||
+				existing.ConnectionsPerMinute != stats.ConnectionsPerMinute ||
+				existing.BypassAttempts != stats.BypassAttempts
+			existing.ProfileName = stats.ProfileName
+			existing.ActiveConnections = stats.ActiveConnections
+			existing.BlockedLastHour = stats.BlockedLastHour
+			existing.ConnectionsPerMinute = stats.ConnectionsPerMinute
+			existing.BypassAttempts = stats.BypassAttempts
+			if changed {
+				existing.UpdateMeta()
+				pushProfileStats(existing)
+			}
+			existing.Unlock()
+			continue
+		}
+
+		stats.SetKey(runtime.DefaultRegistry.DatabaseName() + ":" + profileStatsProviderPrefix + scopedID)
+		stats.UpdateMeta()
+		profileStats[scopedID] = stats
+		stats.Lock()
+		pushProfileStats(stats)
+		stats.Unlock()
+	}
+
+	// Remove the stats of profiles without any activity.
+	for scopedID, stats := range profileStats {
+		if _, ok := current[scopedID]; ok {
+			continue
+		}
+		delete(profileStats, scopedID)
+		stats.Lock()
+		stats.Meta().Delete()
+		pushProfileStats(stats)
+		stats.Unlock()
+	}
+
+	return nil
+}
+
+// collectProfileStats aggregates the stats of all profiles with activity.
+func collectProfileStats(now time.Time) map[string]*ProfileStats {
+	current := make(map[string]*ProfileStats)
+	getStats := func(pCtx ProcessContext) *ProfileStats {
+		scopedID := pCtx.Source + "/" + pCtx.Profile
+		stats, ok := current[scopedID]
+		if !ok {
+			stats = &ProfileStats{
+				Profile:     pCtx.Profile,
+				Source:      pCtx.Source,
+				ProfileName: pCtx.ProfileName,
+			}
+			current[scopedID] = stats
+		}
+		return stats
+	}
+
+	lastMinute := now.Add(-time.Minute).Unix()
+	for _, conn := range conns.clone() {
+		conn.Lock()
+		// Multipath TCP subflows are counted as part of their first subflow.
+		if conn.ProcessContext.Profile != "" &&
+			conn.MPTCPParentID == "" &&
+			(conn.Ended == 0 || conn.Started > lastMinute) {
+			stats := getStats(conn.ProcessContext)
+			if conn.Ended == 0 {
+				stats.ActiveConnections++
+			}
+			if conn.Started > lastMinute {
+				stats.ConnectionsPerMinute++
+			}
+		}
+		conn.Unlock()
+	}
+
+	blockedStatsLock.Lock()
+	defer blockedStatsLock.Unlock()
+
+	for scopedID, counter := range blockedStats {
+		blocked := counter.sum(now)
+		if blocked == 0 {
+			delete(blockedStats, scopedID)
+			continue
+		}
+
+		stats, ok := current[scopedID]
+		if !ok {
+			source := strings.SplitN(scopedID, "/", 2)
+			if len(source) != 2 {
+				continue
+			}
+			stats = &ProfileStats{
+				Source:      source[0],
+				Profile:     source[1],
+				ProfileName: counter.profileName,
+			}
+			current[scopedID] = stats
+		}
+		stats.BlockedLastHour = blocked
+	}
+
+	return current
+}
@@ -0,0 +1,26 @@
+package network
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBlockedCounter(t *testing.T) {
+	bc := &blockedCounter{}
+	now := time.Unix(1600000000, 0)
+
+	bc.add(now.Add(-2 * time.Hour))
+	bc.add(now.Add(-30 * time.Minute))
+	bc.add(now.Add(-time.Minute))
+	bc.add(now)
+	bc.add(now)
+	if sum := bc.sum(now); sum != 4 {
+		t.Errorf("expected 4 blocked connections in the last hour, got %d", sum)
+	}
+
+	// Buckets of older slots are reset when reused.
+	bc.add(now.Add(time.Hour))
+	if sum := bc.sum(now.Add(time.Hour)); sum != 1 {
+		t.Errorf("expected 1 blocked connection in the last hour, got %d", sum)
+	}
+}
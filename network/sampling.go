@@ -0,0 +1,79 @@
+package network
+
+import (
+	"sync/atomic"
+
+	"github.com/safing/portbase/api"
+)
+
+// Connection sampling keeps observability affordable on hosts with extreme
+// connection churn: Only one in N connections is fully enriched and
+// persisted, ie. tagged, added to the statistics and propagated through the
+// database. Verdicts are still enforced for all connections and all
+// connections are counted in the metrics.
+
+var (
+	samplingRate       int64 = 1
+	samplingCounter    uint64
+	sampledConnections uint64
+	skippedConnections uint64
+)
+
+// SamplingStats holds the current connection sampling rate and counts.
+type SamplingStats struct {
+	// Rate is the sampling rate: One in Rate connections is sampled.
+	Rate int
+	// Sampled is the amount of connections that were sampled.
+	Sampled uint64
+	// Skipped is the amount of connections that were not sampled.
+	Skipped uint64
+}
+
+// SetSamplingRate sets the connection sampling rate: Only one in rate
+// connections is fully enriched and persisted. A rate of 1 or lower samples
+// all connections.
+func SetSamplingRate(rate int) {
+	if rate < 1 {
+		rate = 1
+	}
+	atomic.StoreInt64(&samplingRate, int64(rate))
+}
+
+// GetSamplingStats returns the current connection sampling rate and counts.
+func GetSamplingStats() *SamplingStats {
+	return &SamplingStats{
+		Rate:    int(atomic.LoadInt64(&samplingRate)),
+		Sampled: atomic.LoadUint64(&sampledConnections),
+		Skipped: atomic.LoadUint64(&skippedConnections),
+	}
+}
+
+// sampleConnection returns whether the next connection should be sampled.
+func sampleConnection() bool {
+	rate := uint64(atomic.LoadInt64(&samplingRate))
+	if rate <= 1 || atomic.AddUint64(&samplingCounter, 1)%rate == 0 {
+		atomic.AddUint64(&sampledConnections, 1)
+		return true
+	}
+
+	atomic.AddUint64(&skippedConnections, 1)
+	return false
+}
+
+// Sampled returns whether the connection is fully enriched and persisted.
+func (conn *Connection) Sampled() bool {
+	return !conn.unsampled
+}
+
+func registerSamplingAPI() error {
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:      "network/sampling",
+		Read:      api.PermitUser,
+		BelongsTo: module,
+		StructFunc: func(_ *api.Request) (interface{}, error) {
+			return GetSamplingStats(), nil
+		},
+		Name:        "Get Connection Sampling Stats",
+		Description: "Returns the connection sampling rate and how many connections were sampled and skipped. Only sampled connections are fully enriched and persisted.",
+	})
+}
@@ -0,0 +1,41 @@
+package network
+
+import "testing"
+
+func TestConnectionSampling(t *testing.T) {
+	defer SetSamplingRate(1)
+
+	// All connections are sampled by default.
+	before := GetSamplingStats()
+	for i := 0; i < 10; i++ {
+		if !sampleConnection() {
+			t.Fatal("all connections should be sampled with a rate of 1")
+		}
+	}
+
+	// Only one in four connections is sampled.
+	SetSamplingRate(4)
+	var sampled int
+	for i := 0; i < 100; i++ {
+		if sampleConnection() {
+			sampled++
+		}
+	}
+	if sampled != 25 {
+		t.Errorf("expected 25 sampled connections, got %d", sampled)
+	}
+
+	stats := GetSamplingStats()
+	if stats.Rate != 4 {
+		t.Errorf("unexpected sampling rate %d", stats.Rate)
+	}
+	if stats.Sampled-before.Sampled != 35 || stats.Skipped-before.Skipped != 75 {
+		t.Errorf("unexpected sampling counts: %+v", stats)
+	}
+
+	// Invalid rates sample all connections.
+	SetSamplingRate(0)
+	if GetSamplingStats().Rate != 1 {
+		t.Error("invalid rate should fall back to 1")
+	}
+}
@@ -15,6 +15,7 @@
 	VerdictRerouteToNameserver Verdict = 5
 	VerdictRerouteToTunnel     Verdict = 6
 	VerdictFailed              Verdict = 7
+	VerdictRerouteToProxy      Verdict = 8
 )
 
 func (v Verdict) String() string {
@@ -35,6 +36,8 @@ func (v Verdict) String() string {
 		return "RerouteToTunnel"
 	case VerdictFailed:
 		return "Failed"
+	case VerdictRerouteToProxy:
+		return "RerouteToProxy"
 	default:
 		return "<INVALID VERDICT>"
 	}
@@ -59,6 +62,8 @@ func (v Verdict) Verb() string {
 		return "to tunnel"
 	case VerdictFailed:
 		return "failed"
+	case VerdictRerouteToProxy:
+		return "to proxy"
 	default:
 		return "invalid"
 	}
@@ -0,0 +1,279 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portmaster/profile"
+)
+
+// Top talkers are the destinations with the most connections. Connections are
+// counted in time buckets when they are decided, so that the statistics cover
+// a longer period than the connections are kept in memory. There is no
+// accounting of transferred data, so connections are the only measure.
+
+const (
+	topTalkersBucketSize = 5 * time.Minute
+	topTalkersMaxWindow  = 24 * time.Hour
+	topTalkersBuckets    = int(topTalkersMaxWindow / topTalkersBucketSize)
+
+	defaultTopTalkersLimit = 20
+	maxTopTalkersLimit     = 500
+
+	topTalkersByDomain = "domain"
+	topTalkersByIP     = "ip"
+)
+
+var (
+	topTalkers     [topTalkersBuckets]*talkerBucket
+	topTalkersLock sync.Mutex
+)
+
+type talkerKey struct {
+	profile string
+	domain  string
+	ip      string
+}
+
+type talkerBucket struct {
+	slot   int64
+	counts map[talkerKey]*talkerCount
+}
+
+type talkerCount struct {
+	connections int
+	blocked     int
+}
+
+// TopTalker holds the statistics of a destination.
+type TopTalker struct {
+	// Destination is the domain or IP address, depending on the grouping.
+	Destination string
+	// Connections is the amount of connections to the destination.
+	Connections int
+	// Blocked is the amount of blocked connections to the destination.
+	Blocked int
+	// Profiles holds the scoped IDs of the profiles that connected to the
+	// destination. It is only set for global statistics.
+	Profiles []string `json:",omitempty"`
+}
+
+// TopTalkers holds the destinations with the most connections within a
+// window.
+type TopTalkers struct {
+	Window       string
+	Profile      string
+	By           string
+	Destinations []*TopTalker
+	// Truncated is set if destinations were omitted because of the limit.
+	Truncated bool
+}
+
+// addToTopTalkers counts the connection in the top talkers, once it is
+// decided. DNS requests are only counted if they were blocked, as permitted
+// requests are followed by a connection. Connections are not counted in guest
+// mode. The caller must hold the connection lock.
+func (conn *Connection) addToTopTalkers() {
+	if conn.addedToTopTalkers || conn.Entity == nil || conn.MPTCPParentID != "" ||
+		profile.EphemeralSessionActive() {
+		return
+	}
+
+	var blocked bool
+	switch conn.Verdict {
+	case VerdictBlock, VerdictDrop:
+		blocked = true
+	case VerdictAccept, VerdictRerouteToNameserver, VerdictRerouteToTunnel, VerdictRerouteToProxy:
+		if conn.Type == DNSRequest {
+			return
+		}
+	default:
+		return
+	}
+	conn.addedToTopTalkers = true
+
+	key := talkerKey{
+		profile: conn.ProcessContext.Source + "/" + conn.ProcessContext.Profile,
+		domain:  strings.TrimSuffix(conn.Entity.Domain, "."),
+	}
+	if conn.Entity.IP != nil {
+		key.ip = conn.Entity.IP.String()
+	}
+
+	topTalkersLock.Lock()
+	defer topTalkersLock.Unlock()
+
+	bucket := currentTalkerBucket(time.Now())
+	count, ok := bucket.counts[key]
+	if !ok {
+		count = &talkerCount{}
+		bucket.counts[key] = count
+	}
+	count.connections++
+	if blocked {
+		count.blocked++
+	}
+}
+
+// currentTalkerBucket returns the bucket for the given time and resets it if
+// it holds outdated counts. The caller must hold topTalkersLock.
+func currentTalkerBucket(now time.Time) *talkerBucket {
+	slot := now.Unix() / int64(topTalkersBucketSize/time.Second)
+	idx := int(slot % int64(topTalkersBuckets))
+	bucket := topTalkers[idx]
+	if bucket == nil || bucket.slot != slot {
+		bucket = &talkerBucket{
+			slot:   slot,
+			counts: make(map[talkerKey]*talkerCount),
+		}
+		topTalkers[idx] = bucket
+	}
+	return bucket
+}
+
+// GetTopTalkers returns the destinations with the most connections within the
+// given window, optionally limited to the profile with the given scoped ID.
+// Destinations are grouped by domain or by IP address.
+func GetTopTalkers(window time.Duration, scopedProfileID, by string, limit int) (*TopTalkers, error) {
+	switch {
+	case window < topTalkersBucketSize || window > topTalkersMaxWindow:
+		return nil, fmt.Errorf("window must be between %s and %s", topTalkersBucketSize, topTalkersMaxWindow)
+	case by != topTalkersByDomain && by != topTalkersByIP:
+		return nil, fmt.Errorf("invalid grouping %q", by)
+	case limit <= 0 || limit > maxTopTalkersLimit:
+		return nil, fmt.Errorf("limit must be between 1 and %d", maxTopTalkersLimit)
+	}
+
+	talkers := make(map[string]*TopTalker)
+	profiles := make(map[string]map[string]struct{})
+	oldest := time.Now().Add(-window).Unix() / int64(topTalkersBucketSize/time.Second)
+
+	topTalkersLock.Lock()
+	for _, bucket := range topTalkers {
+		if bucket == nil || bucket.slot <= oldest {
+			continue
+		}
+		for key, count := range bucket.counts {
+			if scopedProfileID != "" && key.profile != scopedProfileID {
+				continue
+			}
+
+			destination := key.ip
+			if by == topTalkersByDomain && key.domain != "" {
+				destination = key.domain
+			}
+			if destination == "" {
+				continue
+			}
+
+			talker, ok := talkers[destination]
+			if !ok {
+				talker = &TopTalker{Destination: destination}
+				talkers[destination] = talker
+				profiles[destination] = make(map[string]struct{})
+			}
+			talker.Connections += count.connections
+			talker.Blocked += count.blocked
+			profiles[destination][key.profile] = struct{}{}
+		}
+	}
+	topTalkersLock.Unlock()
+
+	result := &TopTalkers{
+		Window:       window.String(),
+		Profile:      scopedProfileID,
+		By:           by,
+		Destinations: make([]*TopTalker, 0, len(talkers)),
+	}
+	for destination, talker := range talkers {
+		if scopedProfileID == "" {
+			for profile := range profiles[destination] {
+				talker.Profiles = append(talker.Profiles, profile)
+			}
+			sort.Strings(talker.Profiles)
+		}
+		result.Destinations = append(result.Destinations, talker)
+	}
+	sort.Slice(result.Destinations, func(i, j int) bool {
+		a, b := result.Destinations[i], result.Destinations[j]
+		if a.Connections != b.Connections {
+			return a.Connections > b.Connections
+		}
+		return a.Destination < b.Destination
+	})
+	if len(result.Destinations) > limit {
+		result.Destinations = result.Destinations[:limit]
+		result.Truncated = true
+	}
+
+	return result, nil
+}
+
+func registerTopTalkersAPI() error {
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:      "network/top-talkers",
+		Read:      api.PermitUser,
+		BelongsTo: module,
+		StructFunc: func(ar *api.Request) (i interface{}, err error) {
+			q := ar.Request.URL.Query()
+
+			window := time.Hour
+			if q.Get("window") != "" {
+				window, err = time.ParseDuration(q.Get("window"))
+				if err != nil {
+					return nil, errors.New("invalid window")
+				}
+			}
+
+			by := q.Get("by")
+			if by == "" {
+				by = topTalkersByDomain
+			}
+
+			limit := defaultTopTalkersLimit
+			if q.Get("limit") != "" {
+				limit, err = strconv.Atoi(q.Get("limit"))
+				if err != nil {
+					return nil, errors.New("invalid limit")
+				}
+			}
+
+			return GetTopTalkers(window, q.Get("profile"), by, limit)
+		},
+		Name:        "Get Top Talkers",
+		Description: "Returns the destinations with the most connections within the given window, globally or for a profile. Connections are counted when they are decided. Transferred data is not accounted.",
+		Parameters: []api.Parameter{
+			{
+				Method:      http.MethodGet,
+				Field:       "window",
+				Value:       "1h",
+				Description: "Specify the window as a duration between 5m and 24h. The default is 1h.",
+			},
+			{
+				Method:      http.MethodGet,
+				Field:       "profile",
+				Value:       "<Source>/<ID>",
+				Description: "Specify a profile source and ID to only include its connections. The default is to include all profiles.",
+			},
+			{
+				Method:      http.MethodGet,
+				Field:       "by",
+				Value:       "domain",
+				Description: `Specify whether to group destinations by "domain" or by "ip". Connections without a domain are grouped by IP address. The default is "domain".`,
+			},
+			{
+				Method:      http.MethodGet,
+				Field:       "limit",
+				Value:       "20",
+				Description: "Specify the maximum amount of destinations to return. The default is 20.",
+			},
+		},
+	})
+}
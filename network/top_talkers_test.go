@@ -0,0 +1,63 @@
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/safing/portmaster/intel"
+)
+
+func TestTopTalkers(t *testing.T) {
+	newConn := func(profile, domain, ip string, verdict Verdict) *Connection {
+		conn := &Connection{
+			Type:    IPConnection,
+			Verdict: verdict,
+			Entity: &intel.Entity{
+				Domain: domain,
+				IP:     net.ParseIP(ip),
+			},
+		}
+		conn.ProcessContext.Source = "local"
+		conn.ProcessContext.Profile = profile
+		return conn
+	}
+
+	newConn("a", "example.com.", "10.0.0.1", VerdictAccept).addToTopTalkers()
+	newConn("a", "example.com.", "10.0.0.2", VerdictAccept).addToTopTalkers()
+	newConn("b", "example.com.", "10.0.0.1", VerdictBlock).addToTopTalkers()
+	newConn("b", "", "10.0.0.3", VerdictAccept).addToTopTalkers()
+	newConn("b", "", "10.0.0.3", VerdictUndecided).addToTopTalkers()
+
+	// Connections are only counted once.
+	counted := newConn("a", "", "10.0.0.3", VerdictAccept)
+	counted.addToTopTalkers()
+	counted.addToTopTalkers()
+
+	byDomain, err := GetTopTalkers(time.Hour, "", topTalkersByDomain, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(byDomain.Destinations) != 2 {
+		t.Fatalf("expected 2 destinations, got %d", len(byDomain.Destinations))
+	}
+	top := byDomain.Destinations[0]
+	if top.Destination != "example.com" || top.Connections != 3 || top.Blocked != 1 || len(top.Profiles) != 2 {
+		t.Errorf("unexpected top destination: %+v", top)
+	}
+
+	byIP, err := GetTopTalkers(time.Hour, "local/b", topTalkersByIP, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(byIP.Destinations) != 1 || !byIP.Truncated {
+		t.Fatalf("expected 1 destination and truncation, got %+v", byIP)
+	}
+	if top := byIP.Destinations[0]; top.Destination != "10.0.0.1" || top.Connections != 1 || top.Profiles != nil {
+		t.Errorf("unexpected top destination: %+v", top)
+	}
+
+	if _, err := GetTopTalkers(48*time.Hour, "", topTalkersByDomain, 10); err == nil {
+		t.Error("expected window to be rejected")
+	}
+}
@@ -0,0 +1,31 @@
+package network
+
+import (
+	"time"
+
+	"github.com/safing/portmaster/network/packet"
+)
+
+// trafficSaveInterval defines how often connections are saved when only their
+// traffic counters changed.
+const trafficSaveInterval = 10 * time.Second
+
+// CountPacket adds the given packet to the traffic counters of the
+// connection. If throttled is set, the packet was dropped in order to enforce
+// a bandwidth limit. The connection must be locked.
+func (conn *Connection) CountPacket(pkt packet.Packet, size int, throttled bool) {
+	switch {
+	case throttled:
+		conn.ThrottledPackets++
+	case pkt.IsInbound():
+		conn.BytesReceived += uint64(size)
+	default:
+		conn.BytesSent += uint64(size)
+	}
+
+	// Save the connection now and then to propagate the counters.
+	if time.Since(conn.trafficSaved) > trafficSaveInterval {
+		conn.trafficSaved = time.Now()
+		conn.SaveWhenFinished()
+	}
+}
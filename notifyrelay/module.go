@@ -0,0 +1,149 @@
+package notifyrelay
+
+import (
+	"context"
+	"time"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/modules"
+)
+
+var (
+	module *modules.Module
+
+	// CfgServiceKey is the config key for the relay service.
+	CfgServiceKey = "core/notificationRelayService"
+	cfgService    config.StringOption
+
+	// CfgURLKey is the config key for the URL of the relay service.
+	CfgURLKey = "core/notificationRelayURL"
+	cfgURL    config.StringOption
+
+	// CfgTokenKey is the config key for the access token of the relay service.
+	CfgTokenKey = "core/notificationRelayToken"
+	cfgToken    config.StringOption
+
+	// CfgDelayKey is the config key for the delay after which unanswered
+	// notifications are relayed.
+	CfgDelayKey = "core/notificationRelayDelay"
+	cfgDelay    config.IntOption
+)
+
+// checkInterval defines how often pending notifications are checked.
+const checkInterval = 30 * time.Second
+
+func init() {
+	module = modules.Register("notifyrelay", prep, start, nil, "notifications")
+}
+
+func prep() error {
+	if err := config.Register(&config.Option{
+		Name:           "Notification Relay",
+		Key:            CfgServiceKey,
+		Description:    "Forward warnings and errors, such as threat alerts or SPN failures, to your phone via a push notification service, if they are not answered on the device in time.",
+		OptType:        config.OptTypeString,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   "",
+		Annotations: config.Annotations{
+			config.DisplayHintAnnotation:  config.DisplayHintOneOf,
+			config.DisplayOrderAnnotation: 520,
+			config.CategoryAnnotation:     "Notifications",
+		},
+		PossibleValues: []config.PossibleValue{
+			{
+				Name:        "Disabled",
+				Value:       "",
+				Description: "Do not relay notifications",
+			},
+			{
+				Name:        "ntfy",
+				Value:       ServiceNtfy,
+				Description: "Publish to the ntfy topic URL",
+			},
+			{
+				Name:        "Gotify",
+				Value:       ServiceGotify,
+				Description: "Send to the Gotify server URL with an application token",
+			},
+			{
+				Name:        "Pushover",
+				Value:       ServicePushover,
+				Description: `Send via Pushover with a token in the format "<app token>:<user key>"`,
+			},
+			{
+				Name:        "Webhook",
+				Value:       ServiceWebhook,
+				Description: "Post the notification as JSON to the URL",
+			},
+		},
+	}); err != nil {
+		return err
+	}
+	cfgService = config.Concurrent.GetAsString(CfgServiceKey, "")
+
+	if err := config.Register(&config.Option{
+		Name:            "Notification Relay URL",
+		Key:             CfgURLKey,
+		Description:     "The URL of the notification relay service, eg. https://ntfy.sh/my-secret-topic. For Pushover, the official API is used if empty.",
+		OptType:         config.OptTypeString,
+		ExpertiseLevel:  config.ExpertiseLevelExpert,
+		ReleaseLevel:    config.ReleaseLevelExperimental,
+		DefaultValue:    "",
+		ValidationRegex: `^(https?://[^ ]+)?$`,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 521,
+			config.CategoryAnnotation:     "Notifications",
+		},
+	}); err != nil {
+		return err
+	}
+	cfgURL = config.Concurrent.GetAsString(CfgURLKey, "")
+
+	if err := config.Register(&config.Option{
+		Name:           "Notification Relay Token",
+		Key:            CfgTokenKey,
+		Description:    "The access token for the notification relay service, if required.",
+		OptType:        config.OptTypeString,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   "",
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 522,
+			config.CategoryAnnotation:     "Notifications",
+		},
+	}); err != nil {
+		return err
+	}
+	cfgToken = config.Concurrent.GetAsString(CfgTokenKey, "")
+
+	if err := config.Register(&config.Option{
+		Name:           "Notification Relay Delay",
+		Key:            CfgDelayKey,
+		Description:    "Only relay notifications that are still unanswered after the given amount of minutes, so that notifications are only forwarded when the device is unattended. Set to 0 to relay notifications immediately.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   5,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 523,
+			config.CategoryAnnotation:     "Notifications",
+			config.UnitAnnotation:         "minutes",
+		},
+	}); err != nil {
+		return err
+	}
+	cfgDelay = config.Concurrent.GetAsInt(CfgDelayKey, 5)
+
+	return registerAPI()
+}
+
+func start() error {
+	module.NewTask("relay pending notifications", relayPending).Repeat(checkInterval)
+	return startNotificationRelay()
+}
+
+func relayPending(ctx context.Context, _ *modules.Task) error {
+	checkPending(ctx)
+	return nil
+}
@@ -0,0 +1,159 @@
+package notifyrelay
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/notifications"
+)
+
+// Warnings and errors are relayed if they are still active after the
+// configured delay, ie. if nobody answered them on the device. Each
+// notification is only relayed once while it is active.
+
+const notificationsDBPath = "notifications:all/"
+
+var (
+	db = database.NewInterface(&database.Options{
+		Local:    true,
+		Internal: true,
+	})
+
+	// pending holds the IDs of the active notifications that were not yet
+	// relayed, mapped to when they were first seen.
+	pending = make(map[string]time.Time)
+	// relayed holds the IDs of the active notifications that were relayed.
+	relayed     = make(map[string]struct{})
+	pendingLock sync.Mutex
+)
+
+func startNotificationRelay() error {
+	sub, err := db.Subscribe(query.New(notificationsDBPath))
+	if err != nil {
+		return err
+	}
+
+	module.StartServiceWorker("relay notifications", 0, func(ctx context.Context) error {
+		for {
+			select {
+			case r := <-sub.Feed:
+				if r == nil {
+					return errors.New("subscription canceled")
+				}
+				if handleNotification(r) {
+					checkPending(ctx)
+				}
+			case <-ctx.Done():
+				return sub.Cancel()
+			}
+		}
+	})
+	return nil
+}
+
+// handleNotification tracks the notification if it is relevant. It returns
+// whether a new notification is pending.
+func handleNotification(r record.Record) (added bool) {
+	n, ok := r.(*notifications.Notification)
+	if !ok {
+		return false
+	}
+
+	n.Lock()
+	id := n.EventID
+	relevant := n.Type == notifications.Warning || n.Type == notifications.Error
+	active := n.State == notifications.Active && !n.Meta().IsDeleted()
+	n.Unlock()
+
+	pendingLock.Lock()
+	defer pendingLock.Unlock()
+
+	// Forget notifications that were answered or deleted.
+	if !relevant || !active {
+		delete(pending, id)
+		delete(relayed, id)
+		return false
+	}
+	if cfgService() == "" {
+		return false
+	}
+
+	if _, ok := relayed[id]; ok {
+		return false
+	}
+	if _, ok := pending[id]; ok {
+		return false
+	}
+	pending[id] = time.Now()
+	return true
+}
+
+// checkPending relays the pending notifications that are still active after
+// the configured delay.
+func checkPending(ctx context.Context) {
+	service := cfgService()
+	delay := time.Duration(cfgDelay()) * time.Minute
+
+	pendingLock.Lock()
+	var due []string
+	for id, seen := range pending {
+		if service == "" {
+			delete(pending, id)
+			continue
+		}
+		if time.Since(seen) >= delay {
+			due = append(due, id)
+			delete(pending, id)
+			relayed[id] = struct{}{}
+		}
+	}
+	pendingLock.Unlock()
+
+	for _, id := range due {
+		n := notifications.Get(id)
+		if n == nil {
+			continue
+		}
+
+		n.Lock()
+		msg := &Message{
+			EventID:  n.EventID,
+			Type:     typeName(n.Type),
+			Title:    n.Title,
+			Category: n.Category,
+			Message:  n.Message,
+		}
+		active := n.State == notifications.Active
+		n.Unlock()
+		if !active {
+			continue
+		}
+
+		if err := send(ctx, service, msg); err != nil {
+			log.Warningf("notifyrelay: failed to relay notification %s: %s", id, err)
+			continue
+		}
+		log.Infof("notifyrelay: relayed notification %s via %s", id, service)
+	}
+}
+
+func typeName(t notifications.Type) string {
+	switch t {
+	case notifications.Info:
+		return "info"
+	case notifications.Warning:
+		return "warning"
+	case notifications.Prompt:
+		return "prompt"
+	case notifications.Error:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,187 @@
+package notifyrelay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/info"
+)
+
+// Relay Services
+const (
+	ServiceNtfy     = "ntfy"
+	ServiceGotify   = "gotify"
+	ServicePushover = "pushover"
+	ServiceWebhook  = "webhook"
+)
+
+const (
+	defaultPushoverURL = "https://api.pushover.net/1/messages.json"
+
+	sendTimeout = 30 * time.Second
+)
+
+// Message is a relayed notification. It is posted as is to webhooks.
+type Message struct {
+	EventID  string
+	Type     string
+	Title    string
+	Category string `json:",omitempty"`
+	Message  string
+}
+
+func registerAPI() error {
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:      "notifyrelay/test",
+		Write:     api.PermitAdmin,
+		BelongsTo: module,
+		ActionFunc: func(ar *api.Request) (msg string, err error) {
+			service := cfgService()
+			if service == "" {
+				return "", errors.New("notification relay is disabled")
+			}
+			err = send(ar.Context(), service, &Message{
+				EventID: "notifyrelay:test",
+				Type:    "info",
+				Title:   "Portmaster Test Notification",
+				Message: "Notifications of the Portmaster are relayed to this device.",
+			})
+			if err != nil {
+				return "", err
+			}
+			return "sent test notification via " + service, nil
+		},
+		Name:        "Test Notification Relay",
+		Description: "Sends a test notification via the configured notification relay service.",
+	})
+}
+
+// send sends the message via the given service.
+func send(ctx context.Context, service string, msg *Message) error {
+	req, err := buildRequest(ctx, service, cfgURL(), cfgToken(), msg)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "Portmaster/"+info.Version())
+
+	client := &http.Client{Timeout: sendTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, &io.LimitedReader{R: resp.Body, N: 4096})
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// buildRequest builds the request for sending the message via the given
+// service.
+func buildRequest(ctx context.Context, service, serviceURL, token string, msg *Message) (*http.Request, error) {
+	title := "Portmaster: " + msg.Title
+
+	switch service {
+	case ServiceNtfy:
+		if serviceURL == "" {
+			return nil, errors.New("missing topic URL")
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, serviceURL, strings.NewReader(msg.Message))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Title", title)
+		req.Header.Set("Priority", "high")
+		req.Header.Set("Tags", msg.Type)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return req, nil
+
+	case ServiceGotify:
+		if serviceURL == "" {
+			return nil, errors.New("missing server URL")
+		}
+		if token == "" {
+			return nil, errors.New("missing application token")
+		}
+		data, err := json.Marshal(map[string]interface{}{
+			"title":    title,
+			"message":  msg.Message,
+			"priority": 8,
+		})
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(serviceURL, "/")+"/message", bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Gotify-Key", token)
+		return req, nil
+
+	case ServicePushover:
+		if serviceURL == "" {
+			serviceURL = defaultPushoverURL
+		}
+		appToken, userKey := splitPushoverToken(token)
+		if appToken == "" || userKey == "" {
+			return nil, errors.New(`token must be in the format "<app token>:<user key>"`)
+		}
+		form := url.Values{
+			"token":    {appToken},
+			"user":     {userKey},
+			"title":    {title},
+			"message":  {msg.Message},
+			"priority": {"1"},
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, serviceURL, strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+
+	case ServiceWebhook:
+		if serviceURL == "" {
+			return nil, errors.New("missing webhook URL")
+		}
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, serviceURL, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return req, nil
+
+	default:
+		return nil, fmt.Errorf("unknown relay service %q", service)
+	}
+}
+
+func splitPushoverToken(token string) (appToken, userKey string) {
+	parts := strings.SplitN(token, ":", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+	return parts[0], parts[1]
+}
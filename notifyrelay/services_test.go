@@ -0,0 +1,84 @@
+package notifyrelay
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+func TestBuildRequest(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	msg := &Message{
+		EventID: "test",
+		Type:    "warning",
+		Title:   "Threat Detected",
+		Message: "Something happened.",
+	}
+
+	// ntfy
+	req, err := buildRequest(ctx, ServiceNtfy, "https://ntfy.example.com/topic", "", msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	if string(body) != msg.Message {
+		t.Errorf("unexpected ntfy body: %s", body)
+	}
+	if req.Header.Get("Title") != "Portmaster: Threat Detected" {
+		t.Errorf("unexpected ntfy title: %s", req.Header.Get("Title"))
+	}
+
+	// Gotify
+	if _, err := buildRequest(ctx, ServiceGotify, "https://gotify.example.com", "", msg); err == nil {
+		t.Error("gotify without token should fail")
+	}
+	req, err = buildRequest(ctx, ServiceGotify, "https://gotify.example.com/", "secret", msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.URL.String() != "https://gotify.example.com/message" {
+		t.Errorf("unexpected gotify URL: %s", req.URL)
+	}
+	if req.Header.Get("X-Gotify-Key") != "secret" {
+		t.Error("gotify token missing")
+	}
+
+	// Pushover
+	if _, err := buildRequest(ctx, ServicePushover, "", "invalid", msg); err == nil {
+		t.Error("pushover with invalid token should fail")
+	}
+	req, err = buildRequest(ctx, ServicePushover, "", "app:user", msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.URL.String() != defaultPushoverURL {
+		t.Errorf("unexpected pushover URL: %s", req.URL)
+	}
+	if err := req.ParseForm(); err != nil {
+		t.Fatal(err)
+	}
+	if req.PostForm.Get("token") != "app" || req.PostForm.Get("user") != "user" {
+		t.Errorf("unexpected pushover form: %v", req.PostForm)
+	}
+
+	// Webhook
+	req, err = buildRequest(ctx, ServiceWebhook, "https://hook.example.com", "", msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	posted := &Message{}
+	if err := json.NewDecoder(req.Body).Decode(posted); err != nil {
+		t.Fatal(err)
+	}
+	if *posted != *msg {
+		t.Errorf("unexpected webhook message: %+v", posted)
+	}
+
+	// Unknown
+	if _, err := buildRequest(ctx, "unknown", "https://example.com", "", msg); err == nil {
+		t.Error("unknown service should fail")
+	}
+}
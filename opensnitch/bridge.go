@@ -0,0 +1,280 @@
+package opensnitch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/info"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/notifications"
+	"github.com/safing/portmaster/network/packet"
+	"github.com/safing/portmaster/opensnitch/uipb"
+)
+
+const (
+	notificationsDBPath = "notifications:all/"
+
+	// promptIDPrefix must match the prefix of prompt notifications of the
+	// firewall.
+	promptIDPrefix = "filter:prompt"
+)
+
+var (
+	db = database.NewInterface(&database.Options{
+		Local:    true,
+		Internal: true,
+	})
+
+	clientLock sync.Mutex
+	uiClient   *client
+	uiAddress  string
+	connected  bool
+	pingID     uint64
+
+	forwardedLock sync.Mutex
+	forwarded     = make(map[string]struct{})
+)
+
+// getClient returns the client for the configured UI. It returns nil if the
+// bridge is disabled or not connected.
+func getClient() *client {
+	clientLock.Lock()
+	defer clientLock.Unlock()
+
+	if !connected {
+		return nil
+	}
+	return uiClient
+}
+
+// checkConnection (re)connects to the configured UI and pings it.
+func checkConnection(ctx context.Context) {
+	clientLock.Lock()
+	defer clientLock.Unlock()
+
+	address := cfgUIAddress()
+	if address != uiAddress {
+		resetClientLocked()
+		uiAddress = address
+	}
+	if address == "" {
+		return
+	}
+
+	if uiClient == nil {
+		c, err := newClient(address)
+		if err != nil {
+			log.Warningf("opensnitch: invalid ui address %q: %s", address, err)
+			return
+		}
+		uiClient = c
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pingInterval)
+	defer cancel()
+
+	if !connected {
+		hostname, _ := os.Hostname()
+		if err := uiClient.subscribe(ctx, &uipb.ClientConfig{
+			Name:              hostname,
+			Version:           "Portmaster " + info.Version(),
+			IsFirewallRunning: true,
+		}); err != nil {
+			log.Debugf("opensnitch: failed to connect to ui at %s: %s", address, err)
+			return
+		}
+		connected = true
+		log.Infof("opensnitch: connected to ui at %s", address)
+	}
+
+	pingID++
+	if err := uiClient.ping(ctx, pingID); err != nil {
+		log.Warningf("opensnitch: lost connection to ui at %s: %s", address, err)
+		connected = false
+	}
+}
+
+func resetClient() {
+	clientLock.Lock()
+	defer clientLock.Unlock()
+
+	resetClientLocked()
+}
+
+func resetClientLocked() {
+	if uiClient != nil {
+		uiClient.close()
+		uiClient = nil
+	}
+	connected = false
+}
+
+func startPromptForwarder() error {
+	sub, err := db.Subscribe(query.New(notificationsDBPath + promptIDPrefix))
+	if err != nil {
+		return err
+	}
+
+	module.StartServiceWorker("forward prompts", 0, func(ctx context.Context) error {
+		for {
+			select {
+			case r := <-sub.Feed:
+				if r == nil {
+					return errors.New("subscription canceled")
+				}
+				handleNotification(r)
+			case <-ctx.Done():
+				return sub.Cancel()
+			}
+		}
+	})
+	return nil
+}
+
+// promptData mirrors the event data of prompt notifications.
+type promptData struct {
+	Entity struct {
+		Protocol uint8
+		Port     uint16
+		Domain   string
+		IP       net.IP
+	}
+	Profile struct {
+		LinkedPath string
+	}
+}
+
+func handleNotification(r record.Record) {
+	n, ok := r.(*notifications.Notification)
+	if !ok {
+		return
+	}
+
+	n.Lock()
+	id := n.EventID
+	active := n.State == notifications.Active && !n.Meta().IsDeleted()
+	expires := n.Expires
+	eventData, err := json.Marshal(n.EventData)
+	actions := make([]string, 0, len(n.AvailableActions))
+	for _, action := range n.AvailableActions {
+		actions = append(actions, action.ID)
+	}
+	n.Unlock()
+
+	forwardedLock.Lock()
+	defer forwardedLock.Unlock()
+
+	// Forget prompts that have been answered or deleted, and only forward
+	// each prompt once, as prompts are updated while they are active.
+	if !active {
+		delete(forwarded, id)
+		return
+	}
+	if _, ok := forwarded[id]; ok {
+		return
+	}
+
+	c := getClient()
+	if c == nil {
+		return
+	}
+	if err != nil {
+		log.Warningf("opensnitch: failed to read prompt %s: %s", id, err)
+		return
+	}
+	data := &promptData{}
+	if err := json.Unmarshal(eventData, data); err != nil {
+		log.Warningf("opensnitch: failed to read prompt %s: %s", id, err)
+		return
+	}
+	forwarded[id] = struct{}{}
+
+	module.StartWorker("forward prompt", func(ctx context.Context) error {
+		ctx, cancel := context.WithDeadline(ctx, time.Unix(expires, 0))
+		defer cancel()
+
+		rule, err := c.askRule(ctx, newConnection(data))
+		if err != nil {
+			log.Warningf("opensnitch: failed to forward prompt %s: %s", id, err)
+			return nil
+		}
+
+		actionID := selectAction(rule, actions)
+		if actionID == "" {
+			log.Warningf("opensnitch: ui replied with unsupported action %q to prompt %s", rule.Action, id)
+			return nil
+		}
+		log.Infof("opensnitch: ui decided %s on prompt %s with rule %q", actionID, id, rule.Name)
+		return respond(id, actionID)
+	})
+}
+
+func newConnection(data *promptData) *uipb.Connection {
+	conn := &uipb.Connection{
+		DstHost:     strings.TrimSuffix(data.Entity.Domain, "."),
+		DstPort:     uint32(data.Entity.Port),
+		ProcessPath: data.Profile.LinkedPath,
+	}
+	if data.Entity.IP != nil {
+		conn.DstIp = data.Entity.IP.String()
+	}
+
+	switch packet.IPProtocol(data.Entity.Protocol) {
+	case packet.TCP:
+		conn.Protocol = "tcp"
+	case packet.UDP:
+		conn.Protocol = "udp"
+	default:
+		conn.Protocol = packet.IPProtocol(data.Entity.Protocol).String()
+	}
+	if data.Entity.IP != nil && data.Entity.IP.To4() == nil {
+		conn.Protocol += "6"
+	}
+
+	return conn
+}
+
+// selectAction returns the prompt action matching the action of the rule.
+func selectAction(rule *uipb.Rule, actions []string) string {
+	var prefix string
+	switch rule.Action {
+	case "allow":
+		prefix = "allow-"
+	case "deny", "reject":
+		prefix = "block-"
+	default:
+		return ""
+	}
+
+	for _, actionID := range actions {
+		if strings.HasPrefix(actionID, prefix) {
+			return actionID
+		}
+	}
+	return ""
+}
+
+// respond selects the action of the prompt like the Portmaster UI does.
+func respond(id, actionID string) error {
+	if notifications.Get(id) == nil {
+		// The prompt expired in the meantime.
+		return nil
+	}
+
+	n := &notifications.Notification{
+		EventID:          id,
+		SelectedActionID: actionID,
+	}
+	n.SetKey(notificationsDBPath + id)
+	n.UpdateMeta()
+	return db.Put(n)
+}
@@ -0,0 +1,61 @@
+package opensnitch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc"
+
+	"github.com/safing/portmaster/opensnitch/uipb"
+)
+
+// The UI is called with unary gRPC requests, as defined in ui.proto. The
+// client stubs in the uipb package are generated from it with protoc-gen-go
+// and protoc-gen-go-grpc.
+
+//go:generate protoc --go_out=. --go_opt=module=github.com/safing/portmaster/opensnitch --go-grpc_out=. --go-grpc_opt=module=github.com/safing/portmaster/opensnitch ui.proto
+
+// client is a client of the UI service. gRPC is spoken without TLS, as the UI
+// only listens locally.
+type client struct {
+	conn *grpc.ClientConn
+	ui   uipb.UIClient
+}
+
+// newClient returns a client for the given UI address, which is either a
+// unix socket as "unix:///path" or a TCP address as "host:port". The
+// connection is established in the background and re-established after
+// failures by gRPC.
+func newClient(address string) (*client, error) {
+	if address == "" || address == "unix://" {
+		return nil, errors.New("empty address")
+	}
+
+	conn, err := grpc.Dial(address, grpc.WithInsecure())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to ui: %w", err)
+	}
+	return &client{
+		conn: conn,
+		ui:   uipb.NewUIClient(conn),
+	}, nil
+}
+
+func (c *client) ping(ctx context.Context, id uint64) error {
+	_, err := c.ui.Ping(ctx, &uipb.PingRequest{Id: id})
+	return err
+}
+
+func (c *client) subscribe(ctx context.Context, cc *uipb.ClientConfig) error {
+	_, err := c.ui.Subscribe(ctx, cc)
+	return err
+}
+
+func (c *client) askRule(ctx context.Context, conn *uipb.Connection) (*uipb.Rule, error) {
+	return c.ui.AskRule(ctx, conn)
+}
+
+func (c *client) close() {
+	_ = c.conn.Close()
+}
@@ -0,0 +1,77 @@
+package opensnitch
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	"github.com/safing/portmaster/opensnitch/uipb"
+)
+
+// testUI replies with a rule to allow every connection.
+type testUI struct {
+	uipb.UnimplementedUIServer
+
+	asked *uipb.Connection
+}
+
+func (ui *testUI) AskRule(_ context.Context, conn *uipb.Connection) (*uipb.Rule, error) {
+	ui.asked = conn
+	return &uipb.Rule{
+		Name:     "allow-curl",
+		Enabled:  true,
+		Action:   "allow",
+		Duration: "always",
+	}, nil
+}
+
+func TestAskRule(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ui := &testUI{}
+	server := grpc.NewServer()
+	uipb.RegisterUIServer(server, ui)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	c, err := newClient(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.close()
+
+	rule, err := c.askRule(context.Background(), &uipb.Connection{
+		Protocol:    "tcp",
+		DstHost:     "example.com",
+		DstPort:     443,
+		ProcessPath: "/usr/bin/curl",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Name != "allow-curl" || rule.Action != "allow" || rule.Duration != "always" {
+		t.Errorf("unexpected rule: %+v", rule)
+	}
+	if ui.asked.GetDstHost() != "example.com" || ui.asked.GetProcessPath() != "/usr/bin/curl" {
+		t.Errorf("unexpected connection: %+v", ui.asked)
+	}
+
+	if err := c.ping(context.Background(), 1); err == nil {
+		t.Error("expected unimplemented method to fail")
+	}
+
+	actions := []string{"allow-domain-all", "block-domain-all"}
+	if actionID := selectAction(rule, actions); actionID != "allow-domain-all" {
+		t.Errorf("unexpected action %q", actionID)
+	}
+	if actionID := selectAction(&uipb.Rule{Action: "reject"}, actions); actionID != "block-domain-all" {
+		t.Errorf("unexpected action %q", actionID)
+	}
+}
@@ -0,0 +1,60 @@
+package opensnitch
+
+import (
+	"context"
+	"time"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/modules"
+)
+
+var (
+	module *modules.Module
+
+	// CfgUIAddressKey is the config key for the address of the OpenSnitch UI.
+	CfgUIAddressKey = "core/openSnitchUIAddress"
+	cfgUIAddress    config.StringOption
+)
+
+const pingInterval = 5 * time.Second
+
+func init() {
+	module = modules.Register("opensnitch", prep, start, stop, "notifications", "filter")
+}
+
+func prep() error {
+	if err := config.Register(&config.Option{
+		Name:            "OpenSnitch UI Bridge",
+		Key:             CfgUIAddressKey,
+		Description:     "Forward connection prompts to an OpenSnitch compatible UI, for desktops where the Portmaster UI is not available. Enter the address the UI listens on, eg. unix:///tmp/osui.sock or 127.0.0.1:50051. Decisions of the UI are saved as rules, regardless of the selected duration.",
+		OptType:         config.OptTypeString,
+		ExpertiseLevel:  config.ExpertiseLevelDeveloper,
+		ReleaseLevel:    config.ReleaseLevelExperimental,
+		DefaultValue:    "",
+		ValidationRegex: `^(unix:///.+|(127\.0\.0\.1|localhost|\[::1\]):[0-9]{1,5})?$`,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 519,
+			config.CategoryAnnotation:     "Development",
+		},
+	}); err != nil {
+		return err
+	}
+	cfgUIAddress = config.Concurrent.GetAsString(CfgUIAddressKey, "")
+
+	return nil
+}
+
+func start() error {
+	module.NewTask("ping opensnitch ui", pingUI).Repeat(pingInterval)
+	return startPromptForwarder()
+}
+
+func stop() error {
+	resetClient()
+	return nil
+}
+
+func pingUI(ctx context.Context, _ *modules.Task) error {
+	checkConnection(ctx)
+	return nil
+}
@@ -0,0 +1,126 @@
+package peersync
+
+import (
+	"github.com/safing/portbase/config"
+	"github.com/safing/portmaster/profile"
+)
+
+var (
+	// CfgEnableKey is the config key for enabling peer sync.
+	CfgEnableKey = "core/peerSync"
+	cfgEnable    config.BoolOption
+
+	// CfgPeersKey is the config key for the addresses of the peers.
+	CfgPeersKey = "core/peerSyncPeers"
+	cfgPeers    config.StringArrayOption
+
+	// CfgSecretKey is the config key for the shared secret of the peers.
+	CfgSecretKey = "core/peerSyncSecret"
+	cfgSecret    config.StringOption
+
+	// CfgProfilesKey is the config key for synchronizing profiles.
+	CfgProfilesKey = "core/peerSyncProfiles"
+	cfgProfiles    config.BoolOption
+
+	// CfgSettingsKey is the config key for the global settings that are
+	// synchronized.
+	CfgSettingsKey = "core/peerSyncSettings"
+	cfgSettings    config.StringArrayOption
+
+	defaultSyncedSettings = []string{
+		profile.CfgOptionDefaultActionKey,
+		profile.CfgOptionEndpointsKey,
+		profile.CfgOptionServiceEndpointsKey,
+		profile.CfgOptionFilterListsKey,
+	}
+)
+
+func registerConfig() error {
+	if err := config.Register(&config.Option{
+		Name:           "Peer Sync",
+		Key:            CfgEnableKey,
+		Description:    "Synchronize profiles and selected global settings with other devices running the Portmaster, such as your desktop and laptop. All data is end-to-end encrypted with the shared secret. If an item was changed on multiple devices, the most recent change wins.",
+		OptType:        config.OptTypeBool,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   false,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 540,
+			config.CategoryAnnotation:     "General",
+		},
+	}); err != nil {
+		return err
+	}
+	cfgEnable = config.Concurrent.GetAsBool(CfgEnableKey, false)
+
+	if err := config.Register(&config.Option{
+		Name:            "Peer Sync Devices",
+		Key:             CfgPeersKey,
+		Description:     "The addresses of the other devices to synchronize with, in the format \"host:port\". The Portmaster listens for peers on port 8718 and only accepts connections from the addresses of these devices.",
+		OptType:         config.OptTypeStringArray,
+		ExpertiseLevel:  config.ExpertiseLevelExpert,
+		ReleaseLevel:    config.ReleaseLevelExperimental,
+		DefaultValue:    []string{},
+		ValidationRegex: `^[^ :]+:[0-9]{1,5}$|^\[[0-9a-fA-F:]+\]:[0-9]{1,5}$`,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 541,
+			config.CategoryAnnotation:     "General",
+		},
+	}); err != nil {
+		return err
+	}
+	cfgPeers = config.Concurrent.GetAsStringArray(CfgPeersKey, []string{})
+
+	if err := config.Register(&config.Option{
+		Name:           "Peer Sync Secret",
+		Key:            CfgSecretKey,
+		Description:    "The secret that all devices share. The encryption key is derived from it with scrypt. It should still be long and random.",
+		OptType:        config.OptTypeString,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   "",
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 542,
+			config.CategoryAnnotation:     "General",
+		},
+	}); err != nil {
+		return err
+	}
+	cfgSecret = config.Concurrent.GetAsString(CfgSecretKey, "")
+
+	if err := config.Register(&config.Option{
+		Name:           "Sync Profiles",
+		Key:            CfgProfilesKey,
+		Description:    "Synchronize the settings and rules of app profiles. Single profiles can be excluded in their settings.",
+		OptType:        config.OptTypeBool,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   true,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 543,
+			config.CategoryAnnotation:     "General",
+		},
+	}); err != nil {
+		return err
+	}
+	cfgProfiles = config.Concurrent.GetAsBool(CfgProfilesKey, true)
+
+	if err := config.Register(&config.Option{
+		Name:           "Synced Global Settings",
+		Key:            CfgSettingsKey,
+		Description:    "The keys of the global settings that are synchronized. Settings that are not listed are neither sent to nor changed by peers.",
+		OptType:        config.OptTypeStringArray,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   defaultSyncedSettings,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 544,
+			config.CategoryAnnotation:     "General",
+		},
+	}); err != nil {
+		return err
+	}
+	cfgSettings = config.Concurrent.GetAsStringArray(CfgSettingsKey, defaultSyncedSettings)
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package peersync
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Sealed data is prefixed with the random salt that the key was derived with,
+// followed by the nonce. As deriving keys is slow on purpose, the key for
+// sealing is reused with its salt, and the last key derived for opening data
+// of every peer is kept. Peers only change their salt when they restart or the
+// secret changes.
+//
+// Every sync request carries a random challenge, which the peer binds to the
+// response as additional data, so that old responses cannot be replayed.
+
+const (
+	saltSize      = 16
+	keySize       = 32
+	challengeSize = 16
+
+	// scrypt parameters, as recommended for interactive logins.
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+var (
+	sealingKey     *derivedKey
+	sealingKeyLock sync.Mutex
+
+	openingKeys     = make(map[string]*derivedKey)
+	openingKeysLock sync.Mutex
+)
+
+type derivedKey struct {
+	secret string
+	salt   []byte
+	key    []byte
+}
+
+// deriveKey derives the encryption key from the shared secret and the salt.
+func deriveKey(secret string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(secret), salt, scryptN, scryptR, scryptP, keySize)
+}
+
+// getSealingKey returns the key and salt for sealing data with the secret.
+func getSealingKey(secret string) (key, salt []byte, err error) {
+	sealingKeyLock.Lock()
+	defer sealingKeyLock.Unlock()
+
+	if sealingKey != nil && sealingKey.secret == secret {
+		return sealingKey.key, sealingKey.salt, nil
+	}
+
+	salt = make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, err
+	}
+	key, err = deriveKey(secret, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	sealingKey = &derivedKey{
+		secret: secret,
+		salt:   salt,
+		key:    key,
+	}
+	return key, salt, nil
+}
+
+// getOpeningKey returns the key for opening data of the peer sealed with the
+// secret and the salt. Only the last key of every peer is kept.
+func getOpeningKey(peer, secret string, salt []byte) ([]byte, error) {
+	openingKeysLock.Lock()
+	dk, ok := openingKeys[peer]
+	openingKeysLock.Unlock()
+	if ok && dk.secret == secret && bytes.Equal(dk.salt, salt) {
+		return dk.key, nil
+	}
+
+	// Derive the key without holding the lock, as this takes a while.
+	key, err := deriveKey(secret, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	openingKeysLock.Lock()
+	defer openingKeysLock.Unlock()
+	openingKeys[peer] = &derivedKey{
+		secret: secret,
+		salt:   append([]byte(nil), salt...),
+		key:    key,
+	}
+	return key, nil
+}
+
+// newChallenge returns a random challenge for a sync request.
+func newChallenge() ([]byte, error) {
+	challenge := make([]byte, challengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts and authenticates the data and the challenge with the shared
+// secret. The salt and the nonce are prepended to the ciphertext.
+func seal(secret string, data, challenge []byte) ([]byte, error) {
+	key, salt, err := getSealingKey(secret)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := make([]byte, saltSize+aead.NonceSize(), saltSize+aead.NonceSize()+len(data)+aead.Overhead())
+	copy(sealed, salt)
+	nonce := sealed[saltSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(sealed, nonce, data, challenge), nil
+}
+
+// open decrypts and verifies data of the peer sealed with the shared secret
+// and the challenge.
+func open(peer, secret string, sealed, challenge []byte) ([]byte, error) {
+	if len(sealed) < saltSize {
+		return nil, errors.New("message too short")
+	}
+	key, err := getOpeningKey(peer, secret, sealed[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed = sealed[saltSize:]
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("message too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, challenge)
+}
@@ -0,0 +1,146 @@
+package peersync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/utils"
+	"github.com/safing/portmaster/profile"
+)
+
+// Item ID prefixes
+const (
+	itemPrefixProfile = "profile:"
+	itemPrefixSetting = "setting:"
+)
+
+// Item is a synchronized profile or global setting.
+type Item struct {
+	// ID identifies the item across devices. Profiles are identified by the
+	// path of their application, settings by their key.
+	ID string
+	// Modified holds the UTC timestamp in seconds when the item was last
+	// changed on any device.
+	Modified int64
+	// Hash is the hash of Data.
+	Hash string
+	// Data holds the portable profile or the value of the setting. A null
+	// value resets the setting to its default.
+	Data json.RawMessage
+}
+
+func newItem(id string, v interface{}) (*Item, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	return &Item{
+		ID:   id,
+		Hash: hex.EncodeToString(sum[:]),
+		Data: data,
+	}, nil
+}
+
+// collectLocalItems returns the items that this device synchronizes.
+func collectLocalItems() (map[string]*Item, error) {
+	items := make(map[string]*Item)
+
+	if cfgProfiles() {
+		profiles, err := profile.SyncableProfiles()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get profiles: %w", err)
+		}
+		for _, pp := range profiles {
+			item, err := newItem(itemPrefixProfile+pp.LinkedPath, pp)
+			if err != nil {
+				return nil, err
+			}
+			items[item.ID] = item
+		}
+	}
+
+	for _, key := range cfgSettings() {
+		value, err := getSettingValue(key)
+		if err != nil {
+			// The setting may be unknown in this version.
+			continue
+		}
+		item, err := newItem(itemPrefixSetting+key, value)
+		if err != nil {
+			return nil, err
+		}
+		items[item.ID] = item
+	}
+
+	return items, nil
+}
+
+// syncsItem returns whether this device synchronizes the item with the given
+// ID. Profiles that are excluded from sync are checked when applying them.
+func syncsItem(id string) bool {
+	switch {
+	case strings.HasPrefix(id, itemPrefixProfile):
+		return cfgProfiles()
+	case strings.HasPrefix(id, itemPrefixSetting):
+		return utils.StringInSlice(cfgSettings(), strings.TrimPrefix(id, itemPrefixSetting))
+	default:
+		return false
+	}
+}
+
+// applyItem applies an item received from a peer.
+func applyItem(item *Item) error {
+	switch {
+	case strings.HasPrefix(item.ID, itemPrefixProfile):
+		pp := &profile.PortableProfile{}
+		if err := json.Unmarshal(item.Data, pp); err != nil {
+			return err
+		}
+		if pp.LinkedPath != strings.TrimPrefix(item.ID, itemPrefixProfile) {
+			return errors.New("profile does not match item")
+		}
+		return profile.ImportSyncedProfile(pp)
+
+	case strings.HasPrefix(item.ID, itemPrefixSetting):
+		var value interface{}
+		if err := json.Unmarshal(item.Data, &value); err != nil {
+			return err
+		}
+		return config.SetConfigOption(strings.TrimPrefix(item.ID, itemPrefixSetting), value)
+
+	default:
+		return fmt.Errorf("unknown item %s", item.ID)
+	}
+}
+
+// getSettingValue returns the value set by the user for the setting with the
+// given key, or nil if the default is used.
+func getSettingValue(key string) (interface{}, error) {
+	option, err := config.GetOption(key)
+	if err != nil {
+		return nil, err
+	}
+	r, err := option.Export()
+	if err != nil {
+		return nil, err
+	}
+	wrapper, ok := r.(*record.Wrapper)
+	if !ok {
+		return nil, fmt.Errorf("unexpected record type %T", r)
+	}
+
+	value := gjson.GetBytes(wrapper.Data, "Value")
+	if !value.Exists() {
+		return nil, nil
+	}
+	return value.Value(), nil
+}
@@ -0,0 +1,46 @@
+package peersync
+
+import (
+	"context"
+
+	"github.com/safing/portbase/modules"
+)
+
+var module *modules.Module
+
+func init() {
+	module = modules.Register("peersync", prep, start, stop, "profiles")
+}
+
+func prep() error {
+	return registerConfig()
+}
+
+func start() error {
+	if err := module.RegisterEventHook(
+		"config",
+		"config change",
+		"update peer sync server",
+		func(ctx context.Context, _ interface{}) error {
+			updatePeerIPs(ctx)
+			updateServer()
+			return nil
+		}); err != nil {
+		return err
+	}
+	updatePeerIPs(module.Ctx)
+	updateServer()
+
+	module.NewTask("sync with peers", func(ctx context.Context, _ *modules.Task) error {
+		return syncWithPeers(ctx)
+	}).Repeat(syncInterval)
+	return nil
+}
+
+func stop() error {
+	serverLock.Lock()
+	defer serverLock.Unlock()
+
+	stopServer()
+	return nil
+}
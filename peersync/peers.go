@@ -0,0 +1,81 @@
+package peersync
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/safing/portbase/log"
+)
+
+// Only the configured peers may connect to the sync server. The addresses of
+// the peers are resolved when the configuration changes and before every
+// sync, and connections from all other addresses are closed right away.
+
+var (
+	peerIPs     = make(map[string]struct{})
+	peerIPsLock sync.RWMutex
+)
+
+// updatePeerIPs resolves the configured peers to the addresses that are
+// allowed to connect.
+func updatePeerIPs(ctx context.Context) {
+	ips := make(map[string]struct{})
+	for _, peer := range cfgPeers() {
+		host, _, err := net.SplitHostPort(peer)
+		if err != nil {
+			log.Warningf("peersync: invalid peer address %s: %s", peer, err)
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			ips[ip.String()] = struct{}{}
+			continue
+		}
+
+		resolved, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			log.Warningf("peersync: failed to resolve peer %s: %s", peer, err)
+			continue
+		}
+		for _, ipAddr := range resolved {
+			ips[ipAddr.IP.String()] = struct{}{}
+		}
+	}
+
+	peerIPsLock.Lock()
+	defer peerIPsLock.Unlock()
+	peerIPs = ips
+}
+
+// isPeer returns whether the address belongs to a configured peer.
+func isPeer(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	peerIPsLock.RLock()
+	defer peerIPsLock.RUnlock()
+	_, ok = peerIPs[tcpAddr.IP.String()]
+	return ok
+}
+
+// peerListener only accepts connections from the configured peers.
+type peerListener struct {
+	net.Listener
+}
+
+func (l *peerListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if isPeer(conn.RemoteAddr()) {
+			return conn, nil
+		}
+
+		log.Debugf("peersync: rejected connection from %s, which is not a configured peer", conn.RemoteAddr())
+		_ = conn.Close()
+	}
+}
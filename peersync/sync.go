@@ -0,0 +1,393 @@
+package peersync
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/utils"
+	"github.com/safing/portmaster/profile"
+)
+
+// Every device serves its items to its peers and regularly pulls the items of
+// its peers. Items are versioned by the time of their last change, which is
+// tracked by comparing the hash of every item to the last known hash. Items
+// that changed on multiple devices are resolved by taking the most recent
+// change. Deleted profiles are not synchronized.
+
+const (
+	// PeerSyncPort is the TCP port on which items are served to peers.
+	PeerSyncPort = 8718
+
+	syncPath       = "/peersync"
+	challengeParam = "challenge"
+	syncInterval   = 5 * time.Minute
+	syncTimeout    = 30 * time.Second
+
+	// maxStateSize limits the size of the state received from peers.
+	maxStateSize = 16 << 20
+
+	stateKey = "core:peersync/state"
+)
+
+var (
+	db = database.NewInterface(&database.Options{
+		Local:    true,
+		Internal: true,
+	})
+
+	state     *syncState
+	stateLock sync.Mutex
+)
+
+// syncState holds the last known version of every item.
+type syncState struct {
+	record.Base
+	sync.Mutex
+
+	// Instance is a random ID of this device, used to break ties between
+	// changes made at the same time.
+	Instance string
+	// Versions maps item IDs to their last known version.
+	Versions map[string]*itemVersion
+}
+
+type itemVersion struct {
+	Hash     string
+	Modified int64
+}
+
+// peerState is what a device serves to its peers.
+type peerState struct {
+	Instance string
+	Items    []*Item
+}
+
+func loadState() (*syncState, error) {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	if state != nil {
+		return state, nil
+	}
+
+	r, err := db.Get(stateKey)
+	switch {
+	case err == nil:
+		s := &syncState{}
+		if err := record.Unwrap(r, s); err != nil {
+			return nil, fmt.Errorf("failed to parse sync state: %w", err)
+		}
+		state = s
+	case errors.Is(err, database.ErrNotFound):
+		state = &syncState{
+			Instance: utils.RandomUUID("").String(),
+		}
+		state.SetKey(stateKey)
+	default:
+		return nil, fmt.Errorf("failed to load sync state: %w", err)
+	}
+	if state.Versions == nil {
+		state.Versions = make(map[string]*itemVersion)
+	}
+	return state, nil
+}
+
+// refresh updates the known versions with the local items and sets the
+// versions of the items accordingly. It returns whether any version changed.
+// The caller must hold the state lock.
+func (s *syncState) refresh(items map[string]*Item, now int64) (changed bool) {
+	for id, item := range items {
+		version, ok := s.Versions[id]
+		if !ok || version.Hash != item.Hash {
+			version = &itemVersion{
+				Hash:     item.Hash,
+				Modified: now,
+			}
+			s.Versions[id] = version
+			changed = true
+		}
+		item.Modified = version.Modified
+	}
+	return changed
+}
+
+// newer returns whether the remote item is newer than the local version. The
+// caller must hold the state lock.
+func (s *syncState) newer(remoteInstance string, remote *Item) bool {
+	local, ok := s.Versions[remote.ID]
+	switch {
+	case !ok:
+		return true
+	case local.Hash == remote.Hash:
+		return false
+	case remote.Modified != local.Modified:
+		return remote.Modified > local.Modified
+	default:
+		// Break ties deterministically, so that all devices agree.
+		return remoteInstance > s.Instance
+	}
+}
+
+// localState returns the current local items with their versions.
+func localState() (*peerState, error) {
+	s, err := loadState()
+	if err != nil {
+		return nil, err
+	}
+	items, err := collectLocalItems()
+	if err != nil {
+		return nil, err
+	}
+
+	s.Lock()
+	changed := s.refresh(items, time.Now().Unix())
+	s.Unlock()
+	if changed {
+		if err := db.Put(s); err != nil {
+			return nil, fmt.Errorf("failed to save sync state: %w", err)
+		}
+	}
+
+	ps := &peerState{
+		Instance: s.Instance,
+		Items:    make([]*Item, 0, len(items)),
+	}
+	for _, item := range items {
+		ps.Items = append(ps.Items, item)
+	}
+	return ps, nil
+}
+
+// syncWithPeers pulls the items of all configured peers and applies newer
+// items.
+func syncWithPeers(ctx context.Context) error {
+	secret := cfgSecret()
+	if !cfgEnable() || secret == "" {
+		return nil
+	}
+
+	updatePeerIPs(ctx)
+	for _, peer := range cfgPeers() {
+		if err := syncWithPeer(ctx, peer, secret); err != nil {
+			log.Warningf("peersync: failed to sync with %s: %s", peer, err)
+		}
+	}
+	return nil
+}
+
+func syncWithPeer(ctx context.Context, peer, secret string) error {
+	remote, err := fetchPeerState(ctx, peer, secret)
+	if err != nil {
+		return err
+	}
+
+	s, err := loadState()
+	if err != nil {
+		return err
+	}
+	if remote.Instance == s.Instance {
+		return errors.New("peer is this device")
+	}
+
+	// Update the local versions before comparing.
+	if _, err := localState(); err != nil {
+		return err
+	}
+
+	// applied maps the IDs of the applied items to their remote hash.
+	applied := make(map[string]string)
+	for _, item := range remote.Items {
+		if !syncsItem(item.ID) {
+			continue
+		}
+		s.Lock()
+		newer := s.newer(remote.Instance, item)
+		s.Unlock()
+		if !newer {
+			continue
+		}
+
+		err := applyItem(item)
+		switch {
+		case errors.Is(err, profile.ErrSyncDisabled):
+			continue
+		case err != nil:
+			log.Warningf("peersync: failed to apply %s from %s: %s", item.ID, peer, err)
+			continue
+		}
+
+		s.Lock()
+		s.Versions[item.ID] = &itemVersion{
+			Hash:     item.Hash,
+			Modified: item.Modified,
+		}
+		s.Unlock()
+		applied[item.ID] = item.Hash
+		log.Infof("peersync: applied newer %s from %s", item.ID, peer)
+	}
+	if len(applied) == 0 {
+		return nil
+	}
+
+	// Applied items may be stored slightly differently, so take over the local
+	// hashes of the applied items without changing their versions.
+	items, err := collectLocalItems()
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	for id, remoteHash := range applied {
+		version := s.Versions[id]
+		if item, ok := items[id]; ok && version.Hash == remoteHash {
+			version.Hash = item.Hash
+		}
+	}
+	s.Unlock()
+
+	return db.Put(s)
+}
+
+func fetchPeerState(ctx context.Context, peer, secret string) (*peerState, error) {
+	ctx, cancel := context.WithTimeout(ctx, syncTimeout)
+	defer cancel()
+
+	challenge, err := newChallenge()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		"http://"+peer+syncPath+"?"+challengeParam+"="+hex.EncodeToString(challenge),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	sealed, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxStateSize))
+	if err != nil {
+		return nil, err
+	}
+	data, err := open(peer, secret, sealed, challenge)
+	if err != nil {
+		return nil, errors.New("failed to decrypt, check the secret")
+	}
+
+	ps := &peerState{}
+	if err := json.Unmarshal(data, ps); err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+// serveSyncRequest serves the encrypted local items. Only configured peers
+// can connect, and only peers with the shared secret can read them.
+func serveSyncRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet || r.URL.Path != syncPath {
+		http.NotFound(w, r)
+		return
+	}
+	secret := cfgSecret()
+	if secret == "" {
+		http.Error(w, "not configured", http.StatusServiceUnavailable)
+		return
+	}
+	challenge, err := hex.DecodeString(r.URL.Query().Get(challengeParam))
+	if err != nil || len(challenge) != challengeSize {
+		http.Error(w, "invalid challenge", http.StatusBadRequest)
+		return
+	}
+
+	ps, err := localState()
+	if err != nil {
+		log.Warningf("peersync: failed to get local state: %s", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	data, err := json.Marshal(ps)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	sealed, err := seal(secret, data, challenge)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Debugf("peersync: serving %d items to %s", len(ps.Items), r.RemoteAddr)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = io.Copy(w, bytes.NewReader(sealed))
+}
+
+var (
+	server     *http.Server
+	serverLock sync.Mutex
+)
+
+// updateServer starts or stops serving peers according to the configuration.
+func updateServer() {
+	serverLock.Lock()
+	defer serverLock.Unlock()
+
+	enabled := cfgEnable() && cfgSecret() != ""
+	switch {
+	case enabled && server == nil:
+		listener, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(PeerSyncPort)))
+		if err != nil {
+			log.Warningf("peersync: failed to listen for peers: %s", err)
+			return
+		}
+		server = &http.Server{
+			Handler:      http.HandlerFunc(serveSyncRequest),
+			ReadTimeout:  syncTimeout,
+			WriteTimeout: syncTimeout,
+		}
+		srv := server
+		module.StartWorker("peer sync server", func(_ context.Context) error {
+			err := srv.Serve(&peerListener{Listener: listener})
+			if errors.Is(err, http.ErrServerClosed) {
+				return nil
+			}
+			return err
+		})
+		log.Infof("peersync: serving configured peers on port %d", PeerSyncPort)
+
+	case !enabled && server != nil:
+		stopServer()
+	}
+}
+
+// stopServer stops serving peers. The caller must hold the server lock.
+func stopServer() {
+	if server != nil {
+		_ = server.Close()
+		server = nil
+		log.Info("peersync: stopped serving peers")
+	}
+}
@@ -0,0 +1,176 @@
+package peersync
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSeal(t *testing.T) {
+	data := []byte(`{"Instance":"a"}`)
+	challenge, err := newChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := seal("secret", data, challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, data) {
+		t.Error("sealed data must not contain the plaintext")
+	}
+
+	opened, err := open("peer", "secret", sealed, challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, data) {
+		t.Errorf("unexpected data: %s", opened)
+	}
+
+	if _, err := open("peer", "other secret", sealed, challenge); err == nil {
+		t.Error("data must not open with another secret")
+	}
+	otherChallenge, err := newChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := open("peer", "secret", sealed, otherChallenge); err == nil {
+		t.Error("replayed data must not open with another challenge")
+	}
+	sealed[len(sealed)-1] ^= 1
+	if _, err := open("peer", "secret", sealed, challenge); err == nil {
+		t.Error("tampered data must not open")
+	}
+
+	// Only the last key of a peer is kept.
+	if len(openingKeys) != 1 || !bytes.Equal(openingKeys["peer"].salt, sealed[:saltSize]) {
+		t.Errorf("unexpected opening keys: %v", openingKeys)
+	}
+}
+
+func TestDeriveKey(t *testing.T) {
+	salt := bytes.Repeat([]byte{1}, saltSize)
+	key, err := deriveKey("secret", salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != keySize {
+		t.Errorf("unexpected key size %d", len(key))
+	}
+
+	otherSalt := bytes.Repeat([]byte{2}, saltSize)
+	otherKey, err := deriveKey("secret", otherSalt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(key, otherKey) {
+		t.Error("keys derived with different salts must differ")
+	}
+
+	// Sealed data must carry the salt, which must differ between secrets.
+	sealed, err := seal("secret", []byte("a"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSealed, err := seal("other secret", []byte("a"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(sealed[:saltSize], otherSealed[:saltSize]) {
+		t.Error("secrets must not share a salt")
+	}
+}
+
+func TestPeerListener(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl := &peerListener{Listener: listener}
+	defer func() {
+		_ = pl.Close()
+	}()
+
+	previousPeerIPs := peerIPs
+	defer func() {
+		peerIPsLock.Lock()
+		peerIPs = previousPeerIPs
+		peerIPsLock.Unlock()
+	}()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := pl.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	connect := func() {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+	}
+
+	// Connections from other addresses must be rejected.
+	peerIPsLock.Lock()
+	peerIPs = map[string]struct{}{"192.0.2.1": {}}
+	peerIPsLock.Unlock()
+	connect()
+	select {
+	case <-accepted:
+		t.Fatal("connection from unknown address was accepted")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Connections from peers must be accepted.
+	peerIPsLock.Lock()
+	peerIPs = map[string]struct{}{"127.0.0.1": {}}
+	peerIPsLock.Unlock()
+	connect()
+	select {
+	case conn := <-accepted:
+		_ = conn.Close()
+	case <-time.After(time.Second):
+		t.Fatal("connection from peer was not accepted")
+	}
+}
+
+func TestConflictResolution(t *testing.T) {
+	s := &syncState{
+		Instance: "b",
+		Versions: make(map[string]*itemVersion),
+	}
+
+	// Local items are versioned when they change.
+	items := map[string]*Item{"setting:a": {ID: "setting:a", Hash: "1"}}
+	if !s.refresh(items, 100) || items["setting:a"].Modified != 100 {
+		t.Fatalf("expected new item to be versioned at 100, got %d", items["setting:a"].Modified)
+	}
+	if s.refresh(map[string]*Item{"setting:a": {ID: "setting:a", Hash: "1"}}, 200) {
+		t.Error("unchanged item must keep its version")
+	}
+
+	for _, test := range []struct {
+		instance string
+		remote   *Item
+		newer    bool
+	}{
+		{"a", &Item{ID: "setting:b", Hash: "2", Modified: 50}, true},
+		{"a", &Item{ID: "setting:a", Hash: "1", Modified: 300}, false},
+		{"a", &Item{ID: "setting:a", Hash: "2", Modified: 150}, true},
+		{"a", &Item{ID: "setting:a", Hash: "2", Modified: 50}, false},
+		{"a", &Item{ID: "setting:a", Hash: "2", Modified: 100}, false},
+		{"c", &Item{ID: "setting:a", Hash: "2", Modified: 100}, true},
+	} {
+		if newer := s.newer(test.instance, test.remote); newer != test.newer {
+			t.Errorf("unexpected result for %+v from %s: got %v", test.remote, test.instance, newer)
+		}
+	}
+}
@@ -7,8 +7,17 @@
 // Configuration Keys.
 var (
 	CfgOptionEnableProcessDetectionKey = "core/enableProcessDetection"
+	CfgOptionFingerprintMismatchKey    = "core/fingerprintMismatch"
 
 	enableProcessDetection config.BoolOption
+	fingerprintMismatch    config.StringOption
+)
+
+// Fingerprint Mismatch Actions
+const (
+	FingerprintMismatchOff      = "off"
+	FingerprintMismatchWarn     = "warn"
+	FingerprintMismatchSeparate = "separate"
 )
 
 func registerConfiguration() error {
@@ -31,5 +40,42 @@ func registerConfiguration() error {
 	}
 	enableProcessDetection = config.Concurrent.GetAsBool(CfgOptionEnableProcessDetectionKey, true)
 
+	// Fingerprint Mismatch
+	err = config.Register(&config.Option{
+		Name:           "Changed App Binaries",
+		Key:            CfgOptionFingerprintMismatchKey,
+		Description:    "App profiles are matched by the path of the app binary. The Portmaster additionally records the checksum and the signing identity of the binary, and checks them when the app is started. The signing identity is the code signature on Windows and the installed package on Linux. This defines what happens if the binary at the path of a profile changed without being signed by the same identity, which may happen with updates of unsigned apps, but also when an app is replaced by another.",
+		OptType:        config.OptTypeString,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   FingerprintMismatchOff,
+		Annotations: config.Annotations{
+			config.DisplayHintAnnotation:  config.DisplayHintOneOf,
+			config.DisplayOrderAnnotation: 529,
+			config.CategoryAnnotation:     "Advanced",
+		},
+		PossibleValues: []config.PossibleValue{
+			{
+				Name:        "Do Not Check",
+				Value:       FingerprintMismatchOff,
+				Description: "Do not record and check binaries",
+			},
+			{
+				Name:        "Warn",
+				Value:       FingerprintMismatchWarn,
+				Description: "Notify about the changed binary and continue to use the profile. Binaries without a signing identity are reported on every update",
+			},
+			{
+				Name:        "Separate Profile",
+				Value:       FingerprintMismatchSeparate,
+				Description: "Use a separate profile for the changed binary",
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	fingerprintMismatch = config.Concurrent.GetAsString(CfgOptionFingerprintMismatchKey, FingerprintMismatchOff)
+
 	return nil
 }
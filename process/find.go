@@ -4,6 +4,7 @@
 	"context"
 	"fmt"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/safing/portbase/log"
@@ -49,6 +50,19 @@ func GetProcessByConnection(ctx context.Context, pktInfo *packet.Info) (process
 	return process, connInbound, nil
 }
 
+var deviceIdentifier func(ip net.IP) (mac, name string, ok bool)
+
+// SetDeviceIdentifier allows the devices module to register a function to
+// identify the device of a network host by its IP.
+func SetDeviceIdentifier(fn func(ip net.IP) (mac, name string, ok bool)) {
+	if deviceIdentifier == nil {
+		deviceIdentifier = fn
+	}
+}
+
+// GetNetworkHost returns a pseudo process for requests of a host on the
+// network. Hosts that are known devices get a profile bound to their hardware
+// address, other hosts get a profile bound to their IP.
 func GetNetworkHost(ctx context.Context, remoteIP net.IP) (process *Process, err error) { //nolint:interfacer
 	now := time.Now().Unix()
 	networkHost := &Process{
@@ -57,13 +71,35 @@ func GetNetworkHost(ctx context.Context, remoteIP net.IP) (process *Process, err
 		UserID:    NetworkHostProcessID,
 		Pid:       NetworkHostProcessID,
 		ParentPid: NetworkHostProcessID,
-		Path:      fmt.Sprintf("net:%s", remoteIP),
+		Path:      networkHostPathPrefix + remoteIP.String(),
 		FirstSeen: now,
 		LastSeen:  now,
 	}
+	profileID := remoteIP.String()
+
+	// Identify the device of the host.
+	var isDevice bool
+	if deviceIdentifier != nil {
+		var mac, name string
+		mac, name, isDevice = deviceIdentifier(remoteIP)
+		if isDevice {
+			networkHost.Name = fmt.Sprintf("Device %s", name)
+			networkHost.SpecialDetail = mac
+			profileID = "device-" + strings.ReplaceAll(mac, ":", "-")
+			log.Tracer(ctx).Tracef("process: identified network host %s as device %s", remoteIP, mac)
+		}
+	}
+
+	// Keep applying the settings of hosts that had a profile of their IP
+	// before they were identified as a device.
+	if isDevice {
+		if err := profile.MigrateNetworkHostProfile(profileID, remoteIP.String()); err != nil {
+			log.Tracer(ctx).Warningf("process: failed to migrate profile of network host %s to device profile: %s", remoteIP, err)
+		}
+	}
 
 	// Get the (linked) local profile.
-	networkHostProfile, err := profile.GetProfile(profile.SourceNetwork, remoteIP.String(), "")
+	networkHostProfile, err := profile.GetProfile(profile.SourceNetwork, profileID, "")
 	if err != nil {
 		return nil, err
 	}
@@ -72,10 +108,16 @@ func GetNetworkHost(ctx context.Context, remoteIP net.IP) (process *Process, err
 	networkHost.LocalProfileKey = networkHostProfile.Key()
 	networkHost.profile = networkHostProfile.LayeredProfile()
 
-	if networkHostProfile.Name == "" {
-		// Assign name and save.
+	// Assign name and save. Names are not updated later, as the user may
+	// have renamed the profile.
+	networkHostProfile.Lock()
+	changed := networkHostProfile.Name == ""
+	if changed {
 		networkHostProfile.Name = networkHost.Name
+	}
+	networkHostProfile.Unlock()
 
+	if changed {
 		err := networkHostProfile.Save()
 		if err != nil {
 			log.Warningf("process: failed to save profile %s: %s", networkHostProfile.ScopedID(), err)
@@ -84,3 +126,20 @@ func GetNetworkHost(ctx context.Context, remoteIP net.IP) (process *Process, err
 
 	return networkHost, nil
 }
+
+// NetworkHostIP returns the IP of a network host process.
+func (p *Process) NetworkHostIP() net.IP {
+	if p == nil || p.Pid != NetworkHostProcessID {
+		return nil
+	}
+	return net.ParseIP(strings.TrimPrefix(p.Path, networkHostPathPrefix))
+}
+
+// NetworkHostDevice returns the hardware address of the device of a network
+// host process, if it was identified.
+func (p *Process) NetworkHostDevice() string {
+	if p == nil || p.Pid != NetworkHostProcessID {
+		return ""
+	}
+	return p.SpecialDetail
+}
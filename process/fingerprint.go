@@ -0,0 +1,242 @@
+package process
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/notifications"
+	"github.com/safing/portmaster/profile"
+)
+
+// cachedFingerprint holds the fingerprint of a binary as long as the binary
+// is not modified.
+type cachedFingerprint struct {
+	modTime     time.Time
+	size        int64
+	fingerprint *profile.Fingerprint
+}
+
+var (
+	fingerprintCache     = make(map[string]*cachedFingerprint)
+	fingerprintCacheLock sync.Mutex
+	fingerprintGroup     singleflight.Group
+
+	// ignoredFingerprintMismatches holds the event IDs of mismatch
+	// notifications the user ignored during this run.
+	ignoredFingerprintMismatches sync.Map
+)
+
+// getCachedFingerprint returns the fingerprint of the binary at the given
+// path, if it was already calculated and the binary was not modified since.
+func getCachedFingerprint(path string) (*profile.Fingerprint, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false
+	}
+
+	fingerprintCacheLock.Lock()
+	defer fingerprintCacheLock.Unlock()
+
+	cached, ok := fingerprintCache[path]
+	if ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.fingerprint, true
+	}
+	return nil, false
+}
+
+// getFingerprint returns the fingerprint of the binary at the given path.
+// Concurrent calls for the same path share the calculation.
+func getFingerprint(path string) (*profile.Fingerprint, error) {
+	if fingerprint, ok := getCachedFingerprint(path); ok {
+		return fingerprint, nil
+	}
+
+	fingerprint, err, _ := fingerprintGroup.Do(path, func() (interface{}, error) {
+		return calculateFingerprint(path)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fingerprint.(*profile.Fingerprint), nil
+}
+
+func calculateFingerprint(path string) (*profile.Fingerprint, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return nil, err
+	}
+	fingerprint := &profile.Fingerprint{
+		SHA256: hex.EncodeToString(hasher.Sum(nil)),
+	}
+
+	fingerprint.SigningIdentity, err = getSigningIdentity(path)
+	if err != nil {
+		log.Debugf("process: failed to get signing identity of %s: %s", path, err)
+	}
+
+	fingerprintCacheLock.Lock()
+	fingerprintCache[path] = &cachedFingerprint{
+		modTime:     info.ModTime(),
+		size:        info.Size(),
+		fingerprint: fingerprint,
+	}
+	fingerprintCacheLock.Unlock()
+
+	return fingerprint, nil
+}
+
+// checkFingerprint checks the binary of the process against the fingerprint
+// of the given profile and returns the profile to use for the process.
+// Hashing the binary may take a while, so it is only done before returning if
+// the profile depends on the result. Otherwise, the binary is checked in the
+// background and the given profile is returned.
+func (p *Process) checkFingerprint(ctx context.Context, localProfile *profile.Profile, linkedPath string) (*profile.Profile, error) {
+	action := fingerprintMismatch()
+	if action == FingerprintMismatchOff {
+		return localProfile, nil
+	}
+
+	fingerprint, ok := getCachedFingerprint(p.Path)
+	if !ok && action != FingerprintMismatchSeparate {
+		binaryPath := p.Path
+		module.StartWorker("check binary fingerprint", func(ctx context.Context) error {
+			fingerprint, err := getFingerprint(binaryPath)
+			if err != nil {
+				log.Warningf("process: failed to get fingerprint of %s: %s", binaryPath, err)
+				return nil
+			}
+			_, err = applyFingerprint(ctx, localProfile, binaryPath, linkedPath, fingerprint, action)
+			return err
+		})
+		return localProfile, nil
+	}
+
+	if !ok {
+		// A separate profile must be selected before the first connection of
+		// the process is decided.
+		var err error
+		fingerprint, err = getFingerprint(p.Path)
+		if err != nil {
+			log.Tracer(ctx).Warningf("process: failed to get fingerprint of %s: %s", p.Path, err)
+			return localProfile, nil
+		}
+	}
+
+	return applyFingerprint(ctx, localProfile, p.Path, linkedPath, fingerprint, action)
+}
+
+// applyFingerprint checks the fingerprint of the binary against the given
+// profile, applies the configured action and returns the profile to use.
+func applyFingerprint(
+	ctx context.Context,
+	localProfile *profile.Profile,
+	binaryPath, linkedPath string,
+	fingerprint *profile.Fingerprint,
+	action string,
+) (*profile.Profile, error) {
+	switch localProfile.MatchFingerprint(fingerprint) {
+	case profile.FingerprintMatches:
+		return localProfile, nil
+	case profile.FingerprintUnknown, profile.FingerprintSameSigner:
+		// Record the fingerprint of new profiles and of binaries that were
+		// updated by the same vendor.
+		if err := localProfile.UpdateFingerprint(fingerprint); err != nil {
+			log.Tracer(ctx).Warningf("process: failed to save fingerprint of profile %s: %s", localProfile.ScopedID(), err)
+		}
+		return localProfile, nil
+	}
+
+	// The binary changed and was not signed by the same identity. Unsigned
+	// binaries are reported too, as it cannot be told whether the change was
+	// an update.
+	log.Tracer(ctx).Warningf("process: binary %s does not match the fingerprint of profile %s", binaryPath, localProfile.ScopedID())
+	if action != FingerprintMismatchSeparate {
+		notifyFingerprintMismatch(localProfile, binaryPath, fingerprint)
+		return localProfile, nil
+	}
+
+	// Use a separate profile for the changed binary.
+	separateProfile, err := profile.GetProfile(
+		profile.SourceLocal, "",
+		profile.MakeFingerprintLinkedPath(linkedPath, fingerprint.SHA256),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if separateProfile.MatchFingerprint(fingerprint) == profile.FingerprintUnknown {
+		if err := separateProfile.UpdateFingerprint(fingerprint); err != nil {
+			log.Tracer(ctx).Warningf("process: failed to save fingerprint of profile %s: %s", separateProfile.ScopedID(), err)
+		}
+	}
+	return separateProfile, nil
+}
+
+// notifyFingerprintMismatch notifies the user that the binary of a profile
+// changed and offers to trust the new binary.
+func notifyFingerprintMismatch(localProfile *profile.Profile, binaryPath string, fingerprint *profile.Fingerprint) {
+	eventID := fmt.Sprintf("process:fingerprint-mismatch:%s:%s", localProfile.ScopedID(), fingerprint.SHA256)
+	if _, ignored := ignoredFingerprintMismatches.Load(eventID); ignored {
+		return
+	}
+	if notifications.Get(eventID) != nil {
+		return
+	}
+
+	message := fmt.Sprintf(
+		"The binary %s of the app %s changed since it was last used. This is expected if the app was updated, but may also mean that the app was replaced. The settings of the app are still applied.",
+		binaryPath,
+		localProfile.Name,
+	)
+	if fingerprint.SigningIdentity == "" {
+		message += " The binary is not signed, so it cannot be verified whether it was updated by the same vendor."
+	}
+
+	n := notifications.Notify(&notifications.Notification{
+		EventID:      eventID,
+		Type:         notifications.Warning,
+		Title:        "App Binary Changed",
+		Message:      message,
+		ShowOnSystem: true,
+		AvailableActions: []*notifications.Action{
+			{
+				ID:   "trust",
+				Text: "Trust Changed Binary",
+			},
+			{
+				ID:   "ignore",
+				Text: "Ignore",
+			},
+		},
+	})
+	n.SetActionFunction(func(_ context.Context, n *notifications.Notification) error {
+		defer n.Delete()
+
+		switch n.SelectedActionID {
+		case "trust":
+			return localProfile.UpdateFingerprint(fingerprint)
+		case "ignore":
+			ignoredFingerprintMismatches.Store(eventID, struct{}{})
+		}
+		return nil
+	})
+}
@@ -0,0 +1,9 @@
+// +build !windows,!linux
+
+package process
+
+// getSigningIdentity returns the identity of the signer of the binary at the
+// given path. Signing identities are only supported on Windows and Linux.
+func getSigningIdentity(_ string) (string, error) {
+	return "", nil
+}
@@ -0,0 +1,135 @@
+package process
+
+import (
+	"bufio"
+	"bytes"
+	"os/exec"
+	"strings"
+)
+
+// Linux binaries are not signed themselves, but the packages of the
+// distribution are. Binaries that were installed by a package manager and
+// were not modified since are therefore identified by their package, so that
+// they keep their identity when they are updated by the package manager.
+
+// packageManager describes how to find the package that owns a file and how
+// to check that the files of a package were not modified.
+type packageManager struct {
+	name string
+	// owner returns the command to get the package owning the file.
+	owner func(path string) []string
+	// parseOwner returns the package name from the output of owner.
+	parseOwner func(output []byte) string
+	// verify returns the command to verify the files of the package. It must
+	// print the modified files, one per line, with the path at the end.
+	verify func(pkg string) []string
+}
+
+var packageManagers = []*packageManager{
+	{
+		name: "dpkg",
+		owner: func(path string) []string {
+			return []string{"dpkg-query", "--search", path}
+		},
+		parseOwner: func(output []byte) string {
+			// Format: "package[:arch][, package]: /path"
+			scanner := bufio.NewScanner(bytes.NewReader(output))
+			for scanner.Scan() {
+				line := scanner.Text()
+				if strings.HasPrefix(line, "diversion ") {
+					continue
+				}
+				if i := strings.Index(line, ": "); i > 0 && !strings.Contains(line[:i], ",") {
+					return strings.SplitN(line[:i], ":", 2)[0]
+				}
+			}
+			return ""
+		},
+		verify: func(pkg string) []string {
+			return []string{"dpkg", "--verify", pkg}
+		},
+	},
+	{
+		name: "rpm",
+		owner: func(path string) []string {
+			return []string{"rpm", "--query", "--file", "--queryformat", "%{NAME}\n", path}
+		},
+		parseOwner: func(output []byte) string {
+			lines := strings.Fields(string(output))
+			if len(lines) != 1 {
+				// Not owned or owned by multiple packages.
+				return ""
+			}
+			return lines[0]
+		},
+		verify: func(pkg string) []string {
+			return []string{"rpm", "--verify", pkg}
+		},
+	},
+	{
+		name: "pacman",
+		owner: func(path string) []string {
+			return []string{"pacman", "--query", "--owns", "--quiet", path}
+		},
+		parseOwner: func(output []byte) string {
+			lines := strings.Fields(string(output))
+			if len(lines) != 1 {
+				return ""
+			}
+			return lines[0]
+		},
+		verify: func(pkg string) []string {
+			// Prints warnings with the path followed by the reason, which are
+			// matched by the path with a trailing space.
+			return []string{"pacman", "--query", "--check", "--check", "--quiet", pkg}
+		},
+	},
+}
+
+// getSigningIdentity returns the package manager and package that the binary
+// at the given path belongs to. It returns an empty string if the binary does
+// not belong to a package or was modified since it was installed.
+func getSigningIdentity(path string) (string, error) {
+	for _, pm := range packageManagers {
+		ownerCmd := pm.owner(path)
+		if _, err := exec.LookPath(ownerCmd[0]); err != nil {
+			continue
+		}
+
+		// The command fails if the file is not owned by any package.
+		output, err := exec.Command(ownerCmd[0], ownerCmd[1:]...).Output() //nolint:gosec // fixed commands
+		if err != nil {
+			continue
+		}
+		pkg := pm.parseOwner(output)
+		if pkg == "" {
+			continue
+		}
+
+		// Check that the binary was not modified. The verification command
+		// exits with an error if any file of the package was modified, such
+		// as a config file, so only its output is checked.
+		verifyCmd := pm.verify(pkg)
+		output, _ = exec.Command(verifyCmd[0], verifyCmd[1:]...).CombinedOutput() //nolint:gosec // fixed commands
+		if isListedAsModified(output, path) {
+			return "", nil
+		}
+
+		return pm.name + ":" + pkg, nil
+	}
+
+	return "", nil
+}
+
+// isListedAsModified returns whether the given path is listed in the output
+// of a package verification.
+func isListedAsModified(output []byte, path string) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasSuffix(line, " "+path) || strings.Contains(line, " "+path+" ") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package process
+
+import "testing"
+
+func TestParsePackageOwner(t *testing.T) {
+	dpkg := packageManagers[0]
+	for output, expected := range map[string]string{
+		"firefox: /usr/lib/firefox/firefox\n":                "firefox",
+		"libc-bin:amd64: /usr/bin/ldd\n":                     "libc-bin",
+		"diversion by dash from: /bin/sh\ndash: /bin/sh\n":   "dash",
+		"libfoo:amd64, libfoo:i386: /usr/share/doc/libfoo\n": "",
+	} {
+		if pkg := dpkg.parseOwner([]byte(output)); pkg != expected {
+			t.Errorf("expected owner %q of %q, got %q", expected, output, pkg)
+		}
+	}
+}
+
+func TestIsListedAsModified(t *testing.T) {
+	output := []byte("??5??????   /usr/bin/modified\n??5?????? c /etc/foo.conf\nwarning: pkg: /usr/bin/other (Modification time mismatch)\n")
+	for path, expected := range map[string]bool{
+		"/usr/bin/modified":   true,
+		"/usr/bin/other":      true,
+		"/usr/bin/unmodified": false,
+		"/usr/bin/mod":        false,
+	} {
+		if isListedAsModified(output, path) != expected {
+			t.Errorf("expected %s to be listed as modified=%v", path, expected)
+		}
+	}
+}
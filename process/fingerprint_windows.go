@@ -0,0 +1,83 @@
+package process
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// getSigningIdentity returns the subject of the code signing certificate of
+// the binary at the given path. It returns an empty string if the binary has
+// no valid embedded signature.
+func getSigningIdentity(path string) (string, error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return "", err
+	}
+
+	// Verify the signature. Revocation is not checked, as this would require
+	// network access.
+	fileInfo := &windows.WinTrustFileInfo{
+		Size:     uint32(unsafe.Sizeof(windows.WinTrustFileInfo{})),
+		FilePath: pathPtr,
+	}
+	trustData := &windows.WinTrustData{
+		Size:                            uint32(unsafe.Sizeof(windows.WinTrustData{})),
+		UIChoice:                        windows.WTD_UI_NONE,
+		RevocationChecks:                windows.WTD_REVOKE_NONE,
+		UnionChoice:                     windows.WTD_CHOICE_FILE,
+		FileOrCatalogOrBlobOrSgnrOrCert: unsafe.Pointer(fileInfo),
+		StateAction:                     windows.WTD_STATEACTION_IGNORE,
+		ProvFlags:                       windows.WTD_CACHE_ONLY_URL_RETRIEVAL,
+	}
+	if err := windows.WinVerifyTrustEx(windows.InvalidHWND, &windows.WINTRUST_ACTION_GENERIC_VERIFY_V2, trustData); err != nil {
+		// The binary is not signed or the signature is not valid.
+		return "", nil
+	}
+
+	// Get the certificates of the signature.
+	var (
+		encoding, contentType, formatType uint32
+		store                             windows.Handle
+	)
+	err = windows.CryptQueryObject(
+		windows.CERT_QUERY_OBJECT_FILE,
+		unsafe.Pointer(pathPtr),
+		windows.CERT_QUERY_CONTENT_FLAG_PKCS7_SIGNED_EMBED,
+		windows.CERT_QUERY_FORMAT_FLAG_BINARY,
+		0,
+		&encoding, &contentType, &formatType,
+		&store, nil, nil,
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to read signature: %w", err)
+	}
+	defer windows.CertCloseStore(store, 0) //nolint:errcheck
+
+	// Find the code signing certificate, which is the only end-entity
+	// certificate used for code signing.
+	var certCtx *windows.CertContext
+	for {
+		certCtx, err = windows.CertEnumCertificatesInStore(store, certCtx)
+		if err != nil || certCtx == nil {
+			break
+		}
+
+		encoded := (*[1 << 20]byte)(unsafe.Pointer(certCtx.EncodedCert))[:certCtx.Length:certCtx.Length]
+		cert, err := x509.ParseCertificate(encoded)
+		if err != nil || cert.IsCA {
+			continue
+		}
+		for _, usage := range cert.ExtKeyUsage {
+			if usage == x509.ExtKeyUsageCodeSigning {
+				_ = windows.CertFreeCertificateContext(certCtx)
+				return cert.Subject.String(), nil
+			}
+		}
+	}
+
+	return "", errors.New("no code signing certificate found")
+}
@@ -0,0 +1,81 @@
+package process
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Store apps (UWP/MSIX) may run inside of generic system host processes. In
+// order to give them their own profiles, the package identity of processes of
+// these hosts is resolved.
+var packageHostExecutables = map[string]struct{}{
+	"applicationframehost.exe": {},
+	"runtimebroker.exe":        {},
+	"wwahost.exe":              {},
+	"backgroundtaskhost.exe":   {},
+}
+
+const (
+	// appmodelErrorNoPackage is returned if the process has no package identity.
+	appmodelErrorNoPackage = 15700
+	// errorInsufficientBuffer is returned if the supplied buffer is too small.
+	errorInsufficientBuffer = 122
+)
+
+var (
+	errNoPackage = errors.New("process has no package identity")
+
+	procGetPackageFamilyName = windows.NewLazySystemDLL("kernel32.dll").NewProc("GetPackageFamilyName")
+)
+
+// isPackageHost returns whether the given executable name is a known host for
+// store apps.
+func isPackageHost(execName string) bool {
+	_, ok := packageHostExecutables[strings.ToLower(execName)]
+	return ok
+}
+
+// getPackageFamilyName returns the package family name of the process with
+// the given PID.
+func getPackageFamilyName(pid int) (string, error) {
+	if err := procGetPackageFamilyName.Find(); err != nil {
+		// Not available before Windows 8.
+		return "", err
+	}
+
+	handle, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return "", fmt.Errorf("failed to open process: %w", err)
+	}
+	defer func() {
+		_ = windows.CloseHandle(handle)
+	}()
+
+	length := uint32(256)
+	for i := 0; i < 2; i++ {
+		buf := make([]uint16, length)
+		ret, _, _ := procGetPackageFamilyName.Call(
+			uintptr(handle),
+			uintptr(unsafe.Pointer(&length)),
+			uintptr(unsafe.Pointer(&buf[0])),
+		)
+		switch ret {
+		case 0:
+			return windows.UTF16ToString(buf), nil
+		case appmodelErrorNoPackage:
+			return "", errNoPackage
+		case errorInsufficientBuffer:
+			// Length was updated, try again.
+			continue
+		default:
+			return "", syscall.Errno(ret)
+		}
+	}
+
+	return "", errors.New("failed to get package family name: buffer too small")
+}
@@ -48,6 +48,10 @@ type Process struct {
 	// based on any of the previous attributes.
 	SpecialDetail string
 
+	// PackageFamilyName holds the package family name of store apps on
+	// Windows that run within a generic host process.
+	PackageFamilyName string
+
 	LocalProfileKey string
 	profile         *profile.LayeredProfile
 
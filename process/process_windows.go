@@ -25,4 +25,18 @@ func (p *Process) specialOSInit() {
 			log.Warningf("process: failed to get service name for svchost.exe (pid %d): %s", p.Pid, err)
 		}
 	}
+
+	// add package family name of store apps running in a host process
+	if isPackageHost(p.ExecName) {
+		familyName, err := getPackageFamilyName(p.Pid)
+		switch err {
+		case nil:
+			p.Name += fmt.Sprintf(" (%s)", familyName)
+			p.PackageFamilyName = familyName
+		case errNoPackage:
+			log.Tracef("process: %s (pid %d) has no package identity", p.ExecName, p.Pid)
+		default:
+			log.Warningf("process: failed to get package family name for %s (pid %d): %s", p.ExecName, p.Pid, err)
+		}
+	}
 }
@@ -78,12 +78,26 @@ func (p *Process) GetProfile(ctx context.Context) (changed bool, err error) {
 		}
 	}
 
+	// Store apps running within a host process are linked via their package.
+	linkedPath := p.Path
+	if p.PackageFamilyName != "" {
+		linkedPath = profile.MakePackageLinkedPath(p.PackageFamilyName)
+	}
+
 	// Get the (linked) local profile.
-	localProfile, err := profile.GetProfile(profile.SourceLocal, profileID, p.Path)
+	localProfile, err := profile.GetProfile(profile.SourceLocal, profileID, linkedPath)
 	if err != nil {
 		return false, err
 	}
 
+	// Check the binary of apps against the fingerprint of their profile.
+	if profileID == "" && p.PackageFamilyName == "" {
+		localProfile, err = p.checkFingerprint(ctx, localProfile, linkedPath)
+		if err != nil {
+			return false, err
+		}
+	}
+
 	// Assign profile to process.
 	p.LocalProfileKey = localProfile.Key()
 	p.profile = localProfile.LayeredProfile()
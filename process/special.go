@@ -6,6 +6,7 @@
 	"time"
 
 	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/profile"
 	"golang.org/x/sync/singleflight"
 )
 
@@ -20,6 +21,10 @@
 
 	// NetworkHostProcessID is the PID used for requests served to the network.
 	NetworkHostProcessID = -255
+
+	// networkHostPathPrefix is the prefix of the path of network hosts, which
+	// is followed by their IP.
+	networkHostPathPrefix = "net:"
 )
 
 var (
@@ -78,3 +83,19 @@ func getSpecialProcess(ctx context.Context, template *Process) *Process {
 	})
 	return p.(*Process)
 }
+
+// NewSyntheticProcess returns a process that uses the given local profile, but
+// is not backed by a running process. It is not added to the process storage
+// and is used to evaluate the configuration of a profile.
+func NewSyntheticProcess(localProfile *profile.Profile) *Process {
+	return &Process{
+		UserID:          UndefinedProcessID,
+		Pid:             UndefinedProcessID,
+		ParentPid:       UndefinedProcessID,
+		Name:            localProfile.Name,
+		Path:            localProfile.LinkedPath,
+		FirstSeen:       time.Now().Unix(),
+		LocalProfileKey: localProfile.Key(),
+		profile:         localProfile.LayeredProfile(),
+	}
+}
@@ -79,6 +79,17 @@ func markActiveProfileAsOutdated(scopedID string) {
 	}
 }
 
+// markAllActiveProfilesAsOutdated marks all active profiles as outdated, so
+// that connections are re-evaluated.
+func markAllActiveProfilesAsOutdated() {
+	activeProfilesLock.RLock()
+	defer activeProfilesLock.RUnlock()
+
+	for _, profile := range activeProfiles {
+		profile.outdated.Set()
+	}
+}
+
 func cleanActiveProfiles(ctx context.Context) error {
 	for {
 		select {
@@ -0,0 +1,126 @@
+package profile
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/record"
+)
+
+// The admin passphrase guards settings that the user of the device must not
+// be able to change, such as locked schedules. The UI is granted full API
+// permissions, so the API permissions cannot tell an admin apart from the
+// user. Instead, admin requests must carry the admin passphrase, of which
+// only a hash is stored in a secret record.
+
+const (
+	adminPassphraseKey = "core:profile-admin/passphrase"
+
+	// AdminPassphraseHeader is the HTTP header that holds the admin
+	// passphrase of API requests.
+	AdminPassphraseHeader = "X-Portmaster-Admin"
+
+	minAdminPassphraseLength = 8
+)
+
+var (
+	// ErrNoAdminPassphrase is returned when an admin action is requested
+	// while no admin passphrase is set.
+	ErrNoAdminPassphrase = errors.New("no admin passphrase is set")
+
+	// ErrInvalidAdminPassphrase is returned when the given admin passphrase
+	// is wrong.
+	ErrInvalidAdminPassphrase = errors.New("invalid admin passphrase")
+
+	adminPassphraseLock sync.Mutex
+)
+
+type adminPassphrase struct {
+	record.Base
+	sync.Mutex
+
+	// Hash holds the bcrypt hash of the passphrase.
+	Hash []byte
+}
+
+func getAdminPassphrase() (*adminPassphrase, error) {
+	r, err := profileDB.Get(adminPassphraseKey)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, ErrNoAdminPassphrase
+		}
+		return nil, err
+	}
+
+	ap := &adminPassphrase{}
+	if err := record.Unwrap(r, ap); err != nil {
+		return nil, fmt.Errorf("failed to parse admin passphrase: %w", err)
+	}
+	return ap, nil
+}
+
+// AdminPassphraseSet returns whether an admin passphrase is set.
+func AdminPassphraseSet() bool {
+	_, err := getAdminPassphrase()
+	return err == nil
+}
+
+// CheckAdminPassphrase returns nil if the given passphrase is the admin
+// passphrase.
+func CheckAdminPassphrase(passphrase string) error {
+	ap, err := getAdminPassphrase()
+	if err != nil {
+		return err
+	}
+	if bcrypt.CompareHashAndPassword(ap.Hash, []byte(passphrase)) != nil {
+		return ErrInvalidAdminPassphrase
+	}
+	return nil
+}
+
+// AdminAuthorized returns whether the API request carries the admin
+// passphrase.
+func AdminAuthorized(ar *api.Request) bool {
+	passphrase := ar.Header.Get(AdminPassphraseHeader)
+	if passphrase == "" {
+		return false
+	}
+	return CheckAdminPassphrase(passphrase) == nil
+}
+
+// SetAdminPassphrase sets a new admin passphrase. If a passphrase is already
+// set, current must match it. The passphrase cannot be changed during an
+// ephemeral session.
+func SetAdminPassphrase(current, newPassphrase string) error {
+	adminPassphraseLock.Lock()
+	defer adminPassphraseLock.Unlock()
+
+	if EphemeralSessionActive() {
+		return errors.New("the admin passphrase cannot be changed during an ephemeral session")
+	}
+	if len(newPassphrase) < minAdminPassphraseLength {
+		return fmt.Errorf("the admin passphrase must have at least %d characters", minAdminPassphraseLength)
+	}
+	switch err := CheckAdminPassphrase(current); {
+	case err == nil, errors.Is(err, ErrNoAdminPassphrase):
+	default:
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(newPassphrase), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	ap := &adminPassphrase{
+		Hash: hash,
+	}
+	ap.SetKey(adminPassphraseKey)
+	ap.SetMeta(&record.Meta{})
+	ap.Meta().MakeSecret()
+	return profileDB.Put(ap)
+}
@@ -0,0 +1,209 @@
+package profile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/utils"
+	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/profile/endpoints"
+)
+
+// Allowlists are shared lists of exemptions from filter list blocking. They
+// are referenced by ID from the global configuration and from profiles, so
+// that exemptions do not have to be duplicated as rules in every profile.
+
+const allowlistsDBPath = "core:allowlists/"
+
+// Allowlist is a shared list of domains, IPs and ASNs that are exempt from
+// filter list blocking.
+type Allowlist struct {
+	record.Base
+	sync.Mutex
+
+	// ID is a unique identifier for the allowlist.
+	ID string
+	// Name is a human readable name of the allowlist.
+	Name string
+	// Description may hold the purpose of the allowlist.
+	Description string
+	// Entries holds the exempted domains, IPs, IP ranges and ASNs in the
+	// format of endpoint rules, without the leading action.
+	Entries []string
+	// LastEdited holds the UTC timestamp in seconds when the allowlist was
+	// last changed.
+	LastEdited int64
+
+	endpoints endpoints.Endpoints
+}
+
+var (
+	allowlists     = make(map[string]*Allowlist)
+	allowlistsLock sync.RWMutex
+)
+
+// parse validates and parses the entries of the allowlist.
+func (al *Allowlist) parse() error {
+	rules := make([]string, 0, len(al.Entries))
+	for _, entry := range al.Entries {
+		rules = append(rules, "+ "+strings.TrimSpace(entry))
+	}
+
+	eps, err := endpoints.ParseEndpoints(rules)
+	if err != nil {
+		return err
+	}
+	for i, ep := range eps {
+		switch ep.(type) {
+		case *endpoints.EndpointDomain,
+			*endpoints.EndpointIP,
+			*endpoints.EndpointIPRange,
+			*endpoints.EndpointASN:
+		default:
+			return fmt.Errorf("entry %q is not a domain, IP, IP range or ASN", al.Entries[i])
+		}
+	}
+
+	al.endpoints = eps
+	return nil
+}
+
+// loadAllowlists loads all allowlists from the database.
+func loadAllowlists() error {
+	it, err := profileDB.Query(query.New(allowlistsDBPath))
+	if err != nil {
+		return err
+	}
+
+	allowlistsLock.Lock()
+	defer allowlistsLock.Unlock()
+
+	for r := range it.Next {
+		al := &Allowlist{}
+		if err := record.Unwrap(r, al); err != nil {
+			log.Warningf("profile: failed to parse allowlist %s: %s", r.Key(), err)
+			continue
+		}
+		if err := al.parse(); err != nil {
+			log.Warningf("profile: failed to parse entries of allowlist %s: %s", al.ID, err)
+		}
+		allowlists[al.ID] = al
+	}
+	return it.Err()
+}
+
+// GetAllowlist returns a copy of the allowlist with the given ID.
+func GetAllowlist(id string) (*Allowlist, bool) {
+	allowlistsLock.RLock()
+	defer allowlistsLock.RUnlock()
+
+	al, ok := allowlists[id]
+	if !ok {
+		return nil, false
+	}
+	return al.copy(), true
+}
+
+// ListAllowlists returns copies of all allowlists, sorted by name.
+func ListAllowlists() []*Allowlist {
+	allowlistsLock.RLock()
+	defer allowlistsLock.RUnlock()
+
+	list := make([]*Allowlist, 0, len(allowlists))
+	for _, al := range allowlists {
+		list = append(list, al.copy())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// SaveAllowlist validates and saves the given allowlist. A new ID is assigned
+// if it has none. Connections are re-evaluated with the new allowlist.
+func SaveAllowlist(al *Allowlist) (*Allowlist, error) {
+	if al.Name == "" {
+		return nil, errors.New("allowlist needs a name")
+	}
+	if al.ID == "" {
+		al.ID = utils.RandomUUID("").String()
+	}
+	if err := al.parse(); err != nil {
+		return nil, err
+	}
+	al.LastEdited = time.Now().Unix()
+	al.SetKey(allowlistsDBPath + al.ID)
+
+	if err := profileDB.Put(al); err != nil {
+		return nil, err
+	}
+
+	allowlistsLock.Lock()
+	allowlists[al.ID] = al
+	allowlistsLock.Unlock()
+
+	markAllActiveProfilesAsOutdated()
+	return al.copy(), nil
+}
+
+// DeleteAllowlist deletes the allowlist with the given ID. References to it
+// are ignored from then on.
+func DeleteAllowlist(id string) error {
+	allowlistsLock.Lock()
+	_, ok := allowlists[id]
+	delete(allowlists, id)
+	allowlistsLock.Unlock()
+	if !ok {
+		return fmt.Errorf("allowlist %s does not exist", id)
+	}
+
+	if err := profileDB.Delete(allowlistsDBPath + id); err != nil {
+		return err
+	}
+
+	markAllActiveProfilesAsOutdated()
+	return nil
+}
+
+// matchAllowlists returns the allowlist with one of the given IDs that the
+// entity matches, if any.
+func matchAllowlists(ctx context.Context, ids []string, entity *intel.Entity) *Allowlist {
+	allowlistsLock.RLock()
+	defer allowlistsLock.RUnlock()
+
+	for _, id := range ids {
+		al, ok := allowlists[id]
+		if !ok {
+			continue
+		}
+		if result, _ := al.endpoints.Match(ctx, entity); result == endpoints.Permitted {
+			return al
+		}
+	}
+	return nil
+}
+
+// copy returns a copy of the allowlist for use outside of this package.
+func (al *Allowlist) copy() *Allowlist {
+	al.Lock()
+	defer al.Unlock()
+
+	cp := &Allowlist{
+		ID:          al.ID,
+		Name:        al.Name,
+		Description: al.Description,
+		Entries:     append([]string(nil), al.Entries...),
+		LastEdited:  al.LastEdited,
+		endpoints:   al.endpoints,
+	}
+	cp.SetKey(al.Key())
+	return cp
+}
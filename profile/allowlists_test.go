@@ -0,0 +1,58 @@
+package profile
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/safing/portmaster/intel"
+)
+
+func TestAllowlistParsing(t *testing.T) {
+	al := &Allowlist{
+		Entries: []string{"example.com", " 192.0.2.1", "198.51.100.0/24", "AS64496"},
+	}
+	if err := al.parse(); err != nil {
+		t.Fatalf("failed to parse valid allowlist: %s", err)
+	}
+
+	for _, entry := range []string{"TCP/443", "not a valid entry"} {
+		al := &Allowlist{Entries: []string{entry}}
+		if err := al.parse(); err == nil {
+			t.Errorf("expected entry %q to be rejected", entry)
+		}
+	}
+}
+
+func TestMatchAllowlists(t *testing.T) {
+	al := &Allowlist{
+		ID:      "test",
+		Entries: []string{"example.com", "192.0.2.1"},
+	}
+	if err := al.parse(); err != nil {
+		t.Fatal(err)
+	}
+	allowlistsLock.Lock()
+	allowlists[al.ID] = al
+	allowlistsLock.Unlock()
+	defer func() {
+		allowlistsLock.Lock()
+		delete(allowlists, al.ID)
+		allowlistsLock.Unlock()
+	}()
+
+	ctx := context.Background()
+	domainEntity := (&intel.Entity{Domain: "example.com."}).Init()
+	if matchAllowlists(ctx, []string{"missing", "test"}, domainEntity) != al {
+		t.Error("expected domain to match allowlist")
+	}
+	if matchAllowlists(ctx, []string{"missing"}, domainEntity) != nil {
+		t.Error("expected unreferenced allowlist to be ignored")
+	}
+
+	ipEntity := (&intel.Entity{}).Init()
+	ipEntity.SetIP(net.ParseIP("192.0.2.2"))
+	if matchAllowlists(ctx, []string{"test"}, ipEntity) != nil {
+		t.Error("expected other IP not to match allowlist")
+	}
+}
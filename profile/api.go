@@ -0,0 +1,596 @@
+package profile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/safing/portbase/api"
+)
+
+func registerAPIEndpoints() error {
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        "profile/quick-settings/{source:[a-z]+}/{id:[^/]+}",
+		Read:        api.PermitUser,
+		Write:       api.PermitUser,
+		BelongsTo:   module,
+		StructFunc:  handleQuickSettings,
+		Name:        "Get or Set Quick Settings",
+		Description: "Returns the quick settings of a profile, or applies them if sent via POST. Quick settings are compiled to rules of the profile.",
+		Parameters: []api.Parameter{{
+			Method:      http.MethodPost,
+			Field:       "body",
+			Value:       `{"BlockInternet":true,"BlockLAN":false,"BlockP2P":false,"BlockIncoming":true}`,
+			Description: "Apply the given quick settings.",
+		}},
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        "profile/learned-ports/{source:[a-z]+}/{id:[^/]+}",
+		Read:        api.PermitUser,
+		Write:       api.PermitUser,
+		BelongsTo:   module,
+		StructFunc:  handleLearnedPorts,
+		Name:        "Get or Review Learned Ports",
+		Description: "Returns the destination ports learned for a profile. Send an action via POST to allow, deny or forget ports, or to restart learning.",
+		Parameters: []api.Parameter{{
+			Method:      http.MethodPost,
+			Field:       "body",
+			Value:       `{"Action":"allow|deny|forget|restart","Ports":["TCP/8080"]}`,
+			Description: "Apply the action to the given ports.",
+		}},
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        "profile/schedules/{source:[a-z]+}/{id:[^/]+}",
+		Read:        api.PermitUser,
+		Write:       api.PermitUser,
+		BelongsTo:   module,
+		StructFunc:  handleSchedules,
+		Name:        "Get or Set Profile Schedules",
+		Description: "Returns the schedules of a profile, or replaces them with the ones sent via POST. Schedules apply alternative settings during a time window. Locked schedules may only be changed with the admin passphrase in the X-Portmaster-Admin header.",
+		Parameters: []api.Parameter{{
+			Method:      http.MethodPost,
+			Field:       "body",
+			Value:       `[{"ID":"school-nights","Name":"School Nights","Days":[0,1,2,3,4],"Start":"21:00","End":"07:00","Config":{"filter/defaultAction":"block"},"Locked":true}]`,
+			Description: "Replace the schedules of the profile.",
+		}},
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        "profile/admin-passphrase",
+		Write:       api.PermitUser,
+		BelongsTo:   module,
+		ActionFunc:  handleSetAdminPassphrase,
+		Name:        "Set Admin Passphrase",
+		Description: "Sets the admin passphrase, which is required to change locked schedules. If a passphrase is already set, the current one must be given.",
+		Parameters: []api.Parameter{{
+			Method:      http.MethodPost,
+			Field:       "body",
+			Value:       `{"Current":"","New":"correct horse battery staple"}`,
+			Description: "The current and the new admin passphrase.",
+		}},
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        "profile/allowlists",
+		Read:        api.PermitUser,
+		Write:       api.PermitUser,
+		BelongsTo:   module,
+		StructFunc:  handleAllowlists,
+		Name:        "List or Save Allowlists",
+		Description: "Returns all shared allowlists, or creates or updates the allowlist sent via POST. Allowlists exempt domains, IPs and ASNs from filter list blocking.",
+		Parameters: []api.Parameter{{
+			Method:      http.MethodPost,
+			Field:       "body",
+			Value:       `{"ID":"","Name":"Work","Entries":["example.com","192.0.2.0/24","AS64496"]}`,
+			Description: "Create or update the given allowlist.",
+		}},
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "profile/allowlists/{id:[a-zA-Z0-9\\-]+}",
+		Read:      api.PermitUser,
+		BelongsTo: module,
+		StructFunc: func(ar *api.Request) (i interface{}, err error) {
+			al, ok := GetAllowlist(ar.URLVars["id"])
+			if !ok {
+				return nil, errors.New("allowlist not found")
+			}
+			return al, nil
+		},
+		Name:        "Get Allowlist",
+		Description: "Returns the allowlist with the given ID.",
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "profile/allowlists/{id:[a-zA-Z0-9\\-]+}/delete",
+		Write:     api.PermitUser,
+		BelongsTo: module,
+		ActionFunc: func(ar *api.Request) (msg string, err error) {
+			if err := DeleteAllowlist(ar.URLVars["id"]); err != nil {
+				return "", err
+			}
+			return "allowlist deleted", nil
+		},
+		Name:        "Delete Allowlist",
+		Description: "Deletes the allowlist with the given ID. Profiles referencing it no longer exempt its entries.",
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        "profile/templates",
+		Read:        api.PermitUser,
+		Write:       api.PermitUser,
+		BelongsTo:   module,
+		StructFunc:  handleTemplates,
+		Name:        "List or Save Profile Templates",
+		Description: "Returns all shipped and local profile templates, or creates or updates the local template sent via POST. Templates hold curated settings that can be applied to profiles.",
+		Parameters: []api.Parameter{{
+			Method:      http.MethodPost,
+			Field:       "body",
+			Value:       `{"ID":"lan-only","Name":"Block all except LAN","Config":{"filter/defaultAction":"block","filter/endpoints":["+ LAN","+ Localhost"]}}`,
+			Description: "Save the given local template.",
+		}},
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "profile/templates/{id:[a-z0-9\\-]+}/delete",
+		Write:     api.PermitUser,
+		BelongsTo: module,
+		ActionFunc: func(ar *api.Request) (msg string, err error) {
+			if err := DeleteTemplate(ar.URLVars["id"]); err != nil {
+				return "", err
+			}
+			return "template deleted", nil
+		},
+		Name:        "Delete Profile Template",
+		Description: "Deletes the local template with the given ID. Profiles it was applied to keep their settings.",
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "profile/templates/{template:[a-z0-9\\-]+}/apply/{source:[a-z]+}/{id:[^/]+}",
+		Write:     api.PermitUser,
+		BelongsTo: module,
+		ActionFunc: func(ar *api.Request) (msg string, err error) {
+			scopedID := makeScopedID(profileSource(ar.URLVars["source"]), ar.URLVars["id"])
+			if err := ApplyTemplate(scopedID, ar.URLVars["template"], ar.Request.URL.Query().Get("strategy")); err != nil {
+				return "", err
+			}
+			return "template applied", nil
+		},
+		Name:        "Apply Profile Template",
+		Description: "Applies the settings of the template to the profile. Settings that are not part of the template are kept.",
+		Parameters: []api.Parameter{{
+			Method:      http.MethodPost,
+			Field:       "strategy",
+			Value:       ImportStrategyMerge,
+			Description: "Specify how the settings are applied: merge adds the rules of the template on top and only sets settings that are not set yet, overwrite replaces the values of the settings of the template. The default is merge.",
+		}},
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "profile/tags",
+		Read:      api.PermitUser,
+		BelongsTo: module,
+		StructFunc: func(ar *api.Request) (i interface{}, err error) {
+			return ListTags()
+		},
+		Name:        "List Tags",
+		Description: "Returns all tags in use by profiles and destination notes.",
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "profile/tags/{tag:[^/]+}/profiles",
+		Read:      api.PermitUser,
+		BelongsTo: module,
+		StructFunc: func(ar *api.Request) (i interface{}, err error) {
+			return GetProfilesByTag(ar.URLVars["tag"])
+		},
+		Name:        "List Profiles By Tag",
+		Description: "Returns all profiles with the given tag.",
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        "profile/bulk-edit",
+		Write:       api.PermitUser,
+		BelongsTo:   module,
+		StructFunc:  handleBulkEdit,
+		Name:        "Bulk Edit Profiles",
+		Description: "Adds or removes tags and applies a template to all profiles with the given tag and to the given profiles.",
+		Parameters: []api.Parameter{{
+			Method:      http.MethodPost,
+			Field:       "body",
+			Value:       `{"Tag":"games","Profiles":["local/abc"],"AddTags":["work"],"RemoveTags":[],"Template":"","Strategy":""}`,
+			Description: "Apply the given bulk edit.",
+		}},
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:        "profile/destination-notes",
+		Read:        api.PermitUser,
+		Write:       api.PermitUser,
+		BelongsTo:   module,
+		StructFunc:  handleDestinationNotes,
+		Name:        "List or Save Destination Notes",
+		Description: "Returns the tags and notes of destinations, optionally filtered by scope and tag, or saves the destination note sent via POST. Notes without tags and text are deleted.",
+		Parameters: []api.Parameter{
+			{
+				Method:      http.MethodGet,
+				Field:       "scope",
+				Value:       DestinationNotesGlobalScope,
+				Description: "Only return notes of the given scope: a scoped profile ID or \"global\".",
+			},
+			{
+				Method:      http.MethodGet,
+				Field:       "tag",
+				Value:       "work",
+				Description: "Only return notes with the given tag.",
+			},
+			{
+				Method:      http.MethodPost,
+				Field:       "body",
+				Value:       `{"Scope":"global","Destination":"example.com","Tags":["work"],"Note":""}`,
+				Description: "Save the given destination note.",
+			},
+		},
+	}); err != nil {
+		return err
+	}
+
+	exportParams := []api.Parameter{{
+		Method:      http.MethodGet,
+		Field:       "format",
+		Value:       ExportFormatNFTables,
+		Description: "Specify the rule syntax: nftables, iptables or windows.",
+	}}
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "profile/export-rules/global",
+		Read:      api.PermitUser,
+		BelongsTo: module,
+		DataFunc: func(ar *api.Request) (data []byte, err error) {
+			return ExportRules("", ar.Request.URL.Query().Get("format"))
+		},
+		Name:        "Export Global Rules",
+		Description: "Renders the global rules in nftables, iptables or Windows Firewall syntax. Rules that native firewalls cannot enforce are skipped.",
+		Parameters:  exportParams,
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "profile/export-rules/{source:[a-z]+}/{id:[^/]+}",
+		Read:      api.PermitUser,
+		BelongsTo: module,
+		DataFunc: func(ar *api.Request) (data []byte, err error) {
+			scopedID := makeScopedID(profileSource(ar.URLVars["source"]), ar.URLVars["id"])
+			return ExportRules(scopedID, ar.Request.URL.Query().Get("format"))
+		},
+		Name:        "Export Profile Rules",
+		Description: "Renders the effective rules of a profile, including the global rules, in nftables, iptables or Windows Firewall syntax. Rules that native firewalls cannot enforce are skipped.",
+		Parameters:  exportParams,
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "profile/policy-document",
+		Read:      api.PermitUser,
+		BelongsTo: module,
+		DataFunc: func(ar *api.Request) (data []byte, err error) {
+			return RenderPolicyDocument(ar.Request.URL.Query().Get("format"))
+		},
+		Name:        "Export Policy Document",
+		Description: "Renders the effective policy, ie. the global settings and the settings and rules of all apps with the layer they come from, as a human readable document for audits and documentation.",
+		Parameters: []api.Parameter{{
+			Method:      http.MethodGet,
+			Field:       "format",
+			Value:       PolicyFormatMarkdown,
+			Description: "Specify the document format: markdown or html. The default is markdown.",
+		}},
+	}); err != nil {
+		return err
+	}
+
+	portableFormatParam := api.Parameter{
+		Method:      http.MethodGet,
+		Field:       "format",
+		Value:       PortableFormatJSON,
+		Description: "Specify the document format: json or yaml. The default is json.",
+	}
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "profile/export",
+		Read:      api.PermitUser,
+		BelongsTo: module,
+		DataFunc: func(ar *api.Request) (data []byte, err error) {
+			return ExportProfiles("", ar.Request.URL.Query().Get("format"))
+		},
+		Name:        "Export Profiles",
+		Description: "Exports all local profiles, including their settings and rules, to a portable document that can be imported on other machines.",
+		Parameters:  []api.Parameter{portableFormatParam},
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "profile/export/{source:[a-z]+}/{id:[^/]+}",
+		Read:      api.PermitUser,
+		BelongsTo: module,
+		DataFunc: func(ar *api.Request) (data []byte, err error) {
+			scopedID := makeScopedID(profileSource(ar.URLVars["source"]), ar.URLVars["id"])
+			return ExportProfiles(scopedID, ar.Request.URL.Query().Get("format"))
+		},
+		Name:        "Export Profile",
+		Description: "Exports a local profile, including its settings and rules, to a portable document that can be imported on other machines.",
+		Parameters:  []api.Parameter{portableFormatParam},
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "profile/import",
+		Write:     api.PermitUser,
+		BelongsTo: module,
+		StructFunc: func(ar *api.Request) (i interface{}, err error) {
+			query := ar.Request.URL.Query()
+			return ImportProfiles(query.Get("format"), query.Get("strategy"), ar.InputData)
+		},
+		Name:        "Import Profiles",
+		Description: "Imports the profiles of a portable document. Profiles are matched to existing profiles by the path of the application. Missing profiles are created.",
+		Parameters: []api.Parameter{
+			{
+				Method:      http.MethodPost,
+				Field:       "format",
+				Value:       PortableFormatJSON,
+				Description: "Specify the document format: json or yaml. The default is json.",
+			},
+			{
+				Method:      http.MethodPost,
+				Field:       "strategy",
+				Value:       ImportStrategyMerge,
+				Description: "Specify how existing profiles are changed: merge adds imported rules on top and only sets settings that are not set yet, overwrite replaces settings, rules and metadata. The default is merge.",
+			},
+			{
+				Method:      http.MethodPost,
+				Field:       "body",
+				Value:       `{"version":1,"profiles":[{"name":"curl","linkedPath":"/usr/bin/curl","settings":{"filter/endpoints":["+ example.com"]}}]}`,
+				Description: "Supply the document to import.",
+			},
+		},
+	}); err != nil {
+		return err
+	}
+
+	importParams := []api.Parameter{
+		{
+			Method:      http.MethodPost,
+			Field:       "format",
+			Value:       ImportFormatLittleSnitch,
+			Description: "Specify the format of the rules: littlesnitch, opensnitch or hosts.",
+		},
+		{
+			Method:      http.MethodPost,
+			Field:       "body",
+			Value:       `{"rules":[{"action":"deny","process":"any","remote-domains":"example.com"}]}`,
+			Description: "Supply the rules to import.",
+		},
+	}
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "profile/import-rules/preview",
+		Write:     api.PermitUser,
+		BelongsTo: module,
+		StructFunc: func(ar *api.Request) (i interface{}, err error) {
+			return PreviewImport(ar.Request.URL.Query().Get("format"), ar.InputData)
+		},
+		Name:        "Preview Rule Import",
+		Description: "Converts rules from Little Snitch, OpenSnitch or a hosts file and returns the rules that an import would add to the global settings and to profiles.",
+		Parameters:  importParams,
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      "profile/import-rules",
+		Write:     api.PermitUser,
+		BelongsTo: module,
+		StructFunc: func(ar *api.Request) (i interface{}, err error) {
+			return ApplyImport(ar.Request.URL.Query().Get("format"), ar.InputData)
+		},
+		Name:        "Import Rules",
+		Description: "Converts rules from Little Snitch, OpenSnitch or a hosts file and adds them to the top of the rules of the global settings and of the profiles of the applications. Missing profiles are created.",
+		Parameters:  importParams,
+	}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func handleDestinationNotes(ar *api.Request) (i interface{}, err error) {
+	switch ar.Method {
+	case http.MethodPost, http.MethodPut:
+		dn := &DestinationNote{}
+		if err := json.Unmarshal(ar.InputData, dn); err != nil {
+			return nil, fmt.Errorf("failed to parse destination note: %w", err)
+		}
+		return SaveDestinationNote(dn)
+	}
+
+	query := ar.Request.URL.Query()
+	return ListDestinationNotes(query.Get("scope"), query.Get("tag")), nil
+}
+
+func handleBulkEdit(ar *api.Request) (i interface{}, err error) {
+	edit := &BulkEdit{}
+	if err := json.Unmarshal(ar.InputData, edit); err != nil {
+		return nil, fmt.Errorf("failed to parse bulk edit: %w", err)
+	}
+	return BulkEditProfiles(edit)
+}
+
+type adminPassphraseRequest struct {
+	Current string
+	New     string
+}
+
+func handleSetAdminPassphrase(ar *api.Request) (msg string, err error) {
+	req := &adminPassphraseRequest{}
+	if err := json.Unmarshal(ar.InputData, req); err != nil {
+		return "", fmt.Errorf("failed to parse request: %w", err)
+	}
+	if err := SetAdminPassphrase(req.Current, req.New); err != nil {
+		return "", err
+	}
+	return "admin passphrase set", nil
+}
+
+type schedulesResponse struct {
+	Schedules        []*Schedule
+	ActiveScheduleID string
+}
+
+func handleSchedules(ar *api.Request) (i interface{}, err error) {
+	scopedID := makeScopedID(profileSource(ar.URLVars["source"]), ar.URLVars["id"])
+	if _, err := getProfile(scopedID); err != nil {
+		return nil, fmt.Errorf("failed to get profile: %w", err)
+	}
+
+	switch ar.Method {
+	case http.MethodPost, http.MethodPut:
+		var newSchedules []*Schedule
+		if err := json.Unmarshal(ar.InputData, &newSchedules); err != nil {
+			return nil, fmt.Errorf("failed to parse schedules: %w", err)
+		}
+		if err := SetSchedules(scopedID, newSchedules, AdminAuthorized(ar)); err != nil {
+			return nil, err
+		}
+	}
+
+	response := &schedulesResponse{
+		Schedules: GetSchedules(scopedID),
+	}
+	if s := activeSchedule(scopedID, time.Now()); s != nil {
+		response.ActiveScheduleID = s.ID
+	}
+	return response, nil
+}
+
+func handleAllowlists(ar *api.Request) (i interface{}, err error) {
+	switch ar.Method {
+	case http.MethodPost, http.MethodPut:
+		al := &Allowlist{}
+		if err := json.Unmarshal(ar.InputData, al); err != nil {
+			return nil, fmt.Errorf("failed to parse allowlist: %w", err)
+		}
+		return SaveAllowlist(al)
+	}
+
+	return ListAllowlists(), nil
+}
+
+func handleQuickSettings(ar *api.Request) (i interface{}, err error) {
+	profile, err := getProfile(makeScopedID(profileSource(ar.URLVars["source"]), ar.URLVars["id"]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get profile: %w", err)
+	}
+
+	switch ar.Method {
+	case http.MethodPost, http.MethodPut:
+		if len(ar.InputData) == 0 {
+			return nil, errors.New("missing quick settings in request body")
+		}
+		qs := &QuickSettings{}
+		if err := json.Unmarshal(ar.InputData, qs); err != nil {
+			return nil, fmt.Errorf("failed to parse quick settings: %w", err)
+		}
+		if _, err := profile.SetQuickSettings(qs); err != nil {
+			return nil, fmt.Errorf("failed to apply quick settings: %w", err)
+		}
+	}
+
+	return profile.GetQuickSettings(), nil
+}
+
+type learnedPortsAction struct {
+	Action string
+	Ports  []string
+}
+
+func handleLearnedPorts(ar *api.Request) (i interface{}, err error) {
+	scopedID := makeScopedID(profileSource(ar.URLVars["source"]), ar.URLVars["id"])
+	if _, err := getProfile(scopedID); err != nil {
+		return nil, fmt.Errorf("failed to get profile: %w", err)
+	}
+
+	switch ar.Method {
+	case http.MethodPost, http.MethodPut:
+		action := &learnedPortsAction{}
+		if err := json.Unmarshal(ar.InputData, action); err != nil {
+			return nil, fmt.Errorf("failed to parse action: %w", err)
+		}
+
+		switch action.Action {
+		case "allow", "deny":
+			for _, port := range action.Ports {
+				if err := SetLearnedPort(scopedID, port, action.Action == "allow"); err != nil {
+					return nil, err
+				}
+			}
+		case "forget":
+			for _, port := range action.Ports {
+				if err := ForgetLearnedPort(scopedID, port); err != nil {
+					return nil, err
+				}
+			}
+		case "restart":
+			RestartPortLearning(scopedID)
+		default:
+			return nil, fmt.Errorf("unknown action %q", action.Action)
+		}
+	}
+
+	return GetLearnedPorts(scopedID), nil
+}
+
+func handleTemplates(ar *api.Request) (i interface{}, err error) {
+	switch ar.Method {
+	case http.MethodPost, http.MethodPut:
+		t := &Template{}
+		if err := json.Unmarshal(ar.InputData, t); err != nil {
+			return nil, fmt.Errorf("failed to parse template: %w", err)
+		}
+		if err := SaveTemplate(t); err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+
+	return GetTemplates(), nil
+}
@@ -0,0 +1,63 @@
+package profile
+
+import (
+	"fmt"
+
+	"github.com/safing/portbase/config"
+)
+
+// GetProfileConfig returns the settings of the profile with the given scoped
+// ID in the flattened form.
+func GetProfileConfig(scopedID string) (map[string]interface{}, error) {
+	profile, err := getProfile(scopedID)
+	if err != nil {
+		return nil, err
+	}
+
+	profile.Lock()
+	defer profile.Unlock()
+
+	return config.Flatten(profile.Config), nil
+}
+
+// SetProfileConfig sets the given settings of the profile with the given
+// scoped ID, in the flattened form. Settings with a nil value are removed
+// from the profile. It returns the previous values of the changed settings,
+// which are nil if they were not set.
+func SetProfileConfig(scopedID string, changes map[string]interface{}) (previous map[string]interface{}, err error) {
+	// Check if the new settings are valid.
+	newValues := make(map[string]interface{}, len(changes))
+	for key, value := range changes {
+		if _, err := config.GetOption(key); err != nil {
+			return nil, fmt.Errorf("unknown setting %s", key)
+		}
+		if value != nil {
+			newValues[key] = value
+		}
+	}
+	if _, err := config.NewPerspective(newValues); err != nil {
+		return nil, fmt.Errorf("invalid settings: %w", err)
+	}
+
+	profile, err := getProfile(scopedID)
+	if err != nil {
+		return nil, err
+	}
+
+	profile.Lock()
+	flat := config.Flatten(profile.Config)
+	previous = make(map[string]interface{}, len(changes))
+	for key, value := range changes {
+		previous[key] = flat[key]
+		if value == nil {
+			delete(flat, key)
+		} else {
+			flat[key] = value
+		}
+	}
+	profile.Config = config.Expand(flat)
+	profile.Unlock()
+
+	// Active profiles are reloaded when they are saved.
+	return previous, profile.Save()
+}
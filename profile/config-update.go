@@ -19,6 +19,11 @@
 	cfgEndpoints        endpoints.Endpoints
 	cfgServiceEndpoints endpoints.Endpoints
 	cfgFilterLists      []string
+	cfgAllowlists       []string
+
+	cfgEndpointScheduleState string
+
+	cfgAllowedInterfaces []*interfaceMatcher
 )
 
 func registerConfigUpdater() error {
@@ -69,12 +74,21 @@ func updateGlobalConfigProfile(ctx context.Context, task *modules.Task) error {
 		lastErr = err
 	}
 
+	cfgEndpointScheduleState = getGlobalEndpointScheduleState(time.Now())
+
 	list = cfgOptionFilterLists()
 	cfgFilterLists, err = filterlists.ResolveListIDs(list)
 	if err != nil {
 		lastErr = err
 	}
 
+	cfgAllowlists = cfgOptionAllowlists()
+
+	cfgAllowedInterfaces, err = parseInterfaceMatchers(cfgOptionAllowedInterfaces())
+	if err != nil {
+		lastErr = err
+	}
+
 	// build global profile for reference
 	profile := New(SourceSpecial, "global-config", "", nil)
 	profile.Name = "Global Configuration"
@@ -0,0 +1,56 @@
+package profile
+
+import (
+	"testing"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portmaster/profile/endpoints"
+)
+
+func TestEndpointListValidation(t *testing.T) {
+	err := config.Register(&config.Option{
+		Name:            "Endpoint List Test",
+		Key:             "test/endpoints",
+		Description:     "Test",
+		OptType:         config.OptTypeStringArray,
+		DefaultValue:    []string{},
+		ValidationRegex: endpointListValidationRegex,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range []string{
+		"+ *",
+		"- example.com",
+		"+ .example.com TCP/443",
+		"- 10.0.0.0/8 UDP/53",
+		"+ AS13335",
+		"+ ASN:13335",
+		`+ ASN:"Cloudflare"`,
+		`- ASN:"Cloudflare, Inc." TCP/443`,
+		"+ * */3389",
+		"- * weekdays 22:00-07:00",
+		"- example.com TCP/443 mon,fri",
+	} {
+		if err := config.SetConfigOption("test/endpoints", []string{entry}); err != nil {
+			t.Errorf("valid entry %q was rejected: %s", entry, err)
+			continue
+		}
+		if _, err := endpoints.ParseEndpoints([]string{entry}); err != nil {
+			t.Errorf("entry %q was accepted by the config, but failed to parse: %s", entry, err)
+		}
+	}
+
+	for _, entry := range []string{
+		"* example.com",
+		"+",
+		`+ ASN:""`,
+		`+ ASN:"Cloudflare`,
+		`+ "Cloudflare"`,
+	} {
+		if err := config.SetConfigOption("test/endpoints", []string{entry}); err == nil {
+			t.Errorf("invalid entry %q was accepted", entry)
+		}
+	}
+}
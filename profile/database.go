@@ -83,7 +83,21 @@ func (h *databaseHook) PrePut(r record.Record) (record.Record, error) {
 		return nil, err
 	}
 
-	// clean config
+	// check links to other profiles
+	if err := profile.checkLinkedProfiles(); err != nil {
+		return nil, err
+	}
+
+	// check network overrides
+	if err := profile.checkNetworkOverrides(); err != nil {
+		return nil, err
+	}
+
+	// clean tags
+	profile.Tags = normalizeTags(profile.Tags)
+
+	// migrate and clean config
+	profile.migrateConfig()
 	config.CleanHierarchicalConfig(profile.Config)
 
 	// prepare config
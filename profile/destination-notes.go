@@ -0,0 +1,295 @@
+package profile
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/network/netutils"
+)
+
+const (
+	destinationNotesDBPath = "core:destination-notes/"
+
+	// DestinationNotesGlobalScope is the scope of destination notes that
+	// apply to all profiles.
+	DestinationNotesGlobalScope = "global"
+)
+
+// DestinationNote holds user provided tags and notes for a destination,
+// either for all profiles or for a single profile.
+type DestinationNote struct {
+	record.Base
+	sync.Mutex
+
+	// Scope is either the scoped ID of a profile or
+	// DestinationNotesGlobalScope.
+	Scope string
+	// Destination is a domain or an IP address. Notes of a domain also
+	// apply to its subdomains.
+	Destination string
+	// Tags holds short labels, such as "work" or "telemetry".
+	Tags []string
+	// Note holds free text of the user.
+	Note string
+	// LastEdited holds the UTC timestamp in seconds when the note was last
+	// changed.
+	LastEdited int64
+}
+
+var (
+	destinationNotes     = make(map[string]*DestinationNote)
+	destinationNotesLock sync.RWMutex
+)
+
+func destinationNoteKey(scope, destination string) string {
+	return scope + "/" + destination
+}
+
+// normalizeDestination returns the destination in the format used for
+// storing notes.
+func normalizeDestination(destination string) (string, error) {
+	destination = strings.ToLower(strings.TrimSpace(destination))
+	if ip := net.ParseIP(destination); ip != nil {
+		return ip.String(), nil
+	}
+
+	destination = strings.TrimSuffix(destination, ".")
+	if !netutils.IsValidFqdn(destination + ".") {
+		return "", fmt.Errorf("%q is not a valid domain or IP address", destination)
+	}
+	return destination, nil
+}
+
+// normalizeTags trims, lowercases and deduplicates tags.
+func normalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]struct{}, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+		if _, ok := seen[tag]; ok {
+			continue
+		}
+		seen[tag] = struct{}{}
+		normalized = append(normalized, tag)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// loadDestinationNotes loads all destination notes from the database.
+func loadDestinationNotes() error {
+	it, err := profileDB.Query(query.New(destinationNotesDBPath))
+	if err != nil {
+		return err
+	}
+
+	destinationNotesLock.Lock()
+	defer destinationNotesLock.Unlock()
+
+	for r := range it.Next {
+		dn := &DestinationNote{}
+		if err := record.Unwrap(r, dn); err != nil {
+			log.Warningf("profile: failed to parse destination note %s: %s", r.Key(), err)
+			continue
+		}
+		destinationNotes[destinationNoteKey(dn.Scope, dn.Destination)] = dn
+	}
+	return it.Err()
+}
+
+// ListDestinationNotes returns copies of all destination notes of the given
+// scope that have the given tag. An empty scope or tag matches all.
+func ListDestinationNotes(scope, tag string) []*DestinationNote {
+	tag = strings.ToLower(tag)
+
+	destinationNotesLock.RLock()
+	defer destinationNotesLock.RUnlock()
+
+	list := make([]*DestinationNote, 0, len(destinationNotes))
+	for _, dn := range destinationNotes {
+		if scope != "" && dn.Scope != scope {
+			continue
+		}
+		if tag != "" && !hasTag(dn.Tags, tag) {
+			continue
+		}
+		list = append(list, dn.copy())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Scope != list[j].Scope {
+			return list[i].Scope < list[j].Scope
+		}
+		return list[i].Destination < list[j].Destination
+	})
+	return list
+}
+
+// SaveDestinationNote validates and saves the given destination note. Notes
+// without tags and text are deleted.
+func SaveDestinationNote(dn *DestinationNote) (*DestinationNote, error) {
+	if dn.Scope == "" {
+		return nil, errors.New("destination note needs a scope")
+	}
+	if dn.Scope != DestinationNotesGlobalScope {
+		if _, err := getProfile(dn.Scope); err != nil {
+			return nil, fmt.Errorf("failed to get profile %s: %w", dn.Scope, err)
+		}
+	}
+
+	destination, err := normalizeDestination(dn.Destination)
+	if err != nil {
+		return nil, err
+	}
+	dn.Destination = destination
+	dn.Tags = normalizeTags(dn.Tags)
+	dn.Note = strings.TrimSpace(dn.Note)
+	if len(dn.Tags) == 0 && dn.Note == "" {
+		return nil, DeleteDestinationNote(dn.Scope, dn.Destination)
+	}
+
+	key := destinationNoteKey(dn.Scope, dn.Destination)
+	dn.LastEdited = time.Now().Unix()
+	dn.SetKey(destinationNotesDBPath + key)
+	if err := profileDB.Put(dn); err != nil {
+		return nil, err
+	}
+
+	destinationNotesLock.Lock()
+	destinationNotes[key] = dn
+	destinationNotesLock.Unlock()
+
+	return dn.copy(), nil
+}
+
+// DeleteDestinationNote deletes the destination note of the given scope and
+// destination, if it exists.
+func DeleteDestinationNote(scope, destination string) error {
+	destination, err := normalizeDestination(destination)
+	if err != nil {
+		return err
+	}
+	key := destinationNoteKey(scope, destination)
+
+	destinationNotesLock.Lock()
+	_, ok := destinationNotes[key]
+	delete(destinationNotes, key)
+	destinationNotesLock.Unlock()
+	if !ok {
+		return nil
+	}
+
+	return profileDB.Delete(destinationNotesDBPath + key)
+}
+
+// GetDestinationTags returns the tags of the given profile merged with the
+// tags of the destination notes that apply to the domain or IP for that
+// profile or globally.
+func GetDestinationTags(profile *Profile, domain string, ip net.IP) []string {
+	var tags []string
+	scopes := []string{DestinationNotesGlobalScope}
+	if profile != nil {
+		profile.Lock()
+		tags = append(tags, profile.Tags...)
+		profile.Unlock()
+		scopes = append(scopes, profile.ScopedID())
+	}
+
+	var destinations []string
+	if domain != "" {
+		// Add the domain and all its parent domains.
+		domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+		for {
+			destinations = append(destinations, domain)
+			dot := strings.IndexByte(domain, '.')
+			if dot < 0 {
+				break
+			}
+			domain = domain[dot+1:]
+		}
+	}
+	if ip != nil {
+		destinations = append(destinations, ip.String())
+	}
+
+	destinationNotesLock.RLock()
+	for _, scope := range scopes {
+		for _, destination := range destinations {
+			if dn, ok := destinationNotes[destinationNoteKey(scope, destination)]; ok {
+				tags = append(tags, dn.Tags...)
+			}
+		}
+	}
+	destinationNotesLock.RUnlock()
+
+	if len(tags) == 0 {
+		return nil
+	}
+	return normalizeTags(tags)
+}
+
+// ListTags returns all tags in use by profiles and destination notes.
+func ListTags() ([]string, error) {
+	var tags []string
+
+	it, err := profileDB.Query(query.New(profilesDBPath))
+	if err != nil {
+		return nil, err
+	}
+	for r := range it.Next {
+		profile, err := EnsureProfile(r)
+		if err != nil {
+			continue
+		}
+		profile.Lock()
+		tags = append(tags, profile.Tags...)
+		profile.Unlock()
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	destinationNotesLock.RLock()
+	for _, dn := range destinationNotes {
+		tags = append(tags, dn.Tags...)
+	}
+	destinationNotesLock.RUnlock()
+
+	return normalizeTags(tags), nil
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// copy returns a copy of the destination note for use outside of this
+// package.
+func (dn *DestinationNote) copy() *DestinationNote {
+	dn.Lock()
+	defer dn.Unlock()
+
+	cp := &DestinationNote{
+		Scope:       dn.Scope,
+		Destination: dn.Destination,
+		Tags:        append([]string(nil), dn.Tags...),
+		Note:        dn.Note,
+		LastEdited:  dn.LastEdited,
+	}
+	cp.SetKey(dn.Key())
+	return cp
+}
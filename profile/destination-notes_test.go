@@ -0,0 +1,50 @@
+package profile
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestGetDestinationTags(t *testing.T) {
+	profile := &Profile{
+		ID:     "test",
+		Source: SourceLocal,
+		Tags:   []string{"work"},
+	}
+
+	destinationNotesLock.Lock()
+	for _, dn := range []*DestinationNote{
+		{Scope: DestinationNotesGlobalScope, Destination: "example.com", Tags: []string{"telemetry"}},
+		{Scope: profile.ScopedID(), Destination: "api.example.com", Tags: []string{"api", "work"}},
+		{Scope: "local/other", Destination: "example.com", Tags: []string{"other"}},
+		{Scope: DestinationNotesGlobalScope, Destination: "192.0.2.1", Tags: []string{"game servers"}},
+	} {
+		destinationNotes[destinationNoteKey(dn.Scope, dn.Destination)] = dn
+	}
+	destinationNotesLock.Unlock()
+	defer func() {
+		destinationNotesLock.Lock()
+		destinationNotes = make(map[string]*DestinationNote)
+		destinationNotesLock.Unlock()
+	}()
+
+	for _, test := range []struct {
+		domain   string
+		ip       net.IP
+		expected []string
+	}{
+		{"api.example.com.", nil, []string{"api", "telemetry", "work"}},
+		{"www.example.com.", net.ParseIP("192.0.2.1"), []string{"game servers", "telemetry", "work"}},
+		{"example.org.", net.ParseIP("192.0.2.2"), []string{"work"}},
+	} {
+		tags := GetDestinationTags(profile, test.domain, test.ip)
+		if !reflect.DeepEqual(tags, test.expected) {
+			t.Errorf("unexpected tags for %s/%s: got %v, expected %v", test.domain, test.ip, tags, test.expected)
+		}
+	}
+
+	if tags := GetDestinationTags(nil, "example.org.", nil); tags != nil {
+		t.Errorf("expected no tags, got %v", tags)
+	}
+}
@@ -5,23 +5,33 @@
 	"fmt"
 	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/safing/portmaster/intel"
 )
 
 var (
-	asnRegex = regexp.MustCompile("^(AS)?[0-9]+$")
+	asnRegex     = regexp.MustCompile("^(AS|ASN:)?[0-9]+$")
+	asnNameRegex = regexp.MustCompile(`^ASN:"([^"]+)"$`)
 )
 
-// EndpointASN matches ASNs.
+// EndpointASN matches ASNs, either by number or by the name of their owner.
 type EndpointASN struct {
 	EndpointBase
 
 	ASN uint
+
+	// Name, if set, matches all ASNs whose owner name contains it,
+	// case-insensitively. It is stored in lower case.
+	Name string
 }
 
 // Matches checks whether the given entity matches this endpoint definition.
 func (ep *EndpointASN) Matches(ctx context.Context, entity *intel.Entity) (EPResult, Reason) {
+	if ep.Name != "" {
+		return ep.matchName(ctx, entity)
+	}
+
 	asn, ok := entity.GetASN(ctx)
 	if !ok {
 		return Undeterminable, nil
@@ -35,13 +45,30 @@ func (ep *EndpointASN) Matches(ctx context.Context, entity *intel.Entity) (EPRes
 	return NoMatch, nil
 }
 
+func (ep *EndpointASN) matchName(ctx context.Context, entity *intel.Entity) (EPResult, Reason) {
+	asOrg, ok := entity.GetASOrg(ctx)
+	if !ok {
+		return Undeterminable, nil
+	}
+
+	if strings.Contains(strings.ToLower(asOrg), ep.Name) {
+		return ep.match(ep, entity, asOrg, "IP is part of AS owned by", "asn", entity.ASN)
+	}
+
+	return NoMatch, nil
+}
+
 func (ep *EndpointASN) String() string {
+	if ep.Name != "" {
+		return ep.renderPPP(`ASN:"` + ep.Name + `"`)
+	}
 	return ep.renderPPP("AS" + strconv.FormatInt(int64(ep.ASN), 10))
 }
 
 func parseTypeASN(fields []string) (Endpoint, error) {
 	if asnRegex.MatchString(fields[1]) {
-		asn, err := strconv.ParseUint(fields[1][2:], 10, 64)
+		asnStr := strings.TrimPrefix(strings.TrimPrefix(fields[1], "ASN:"), "AS")
+		asn, err := strconv.ParseUint(asnStr, 10, 64)
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse AS number %s", fields[1])
 		}
@@ -52,5 +79,17 @@ func parseTypeASN(fields []string) (Endpoint, error) {
 		return ep.parsePPP(ep, fields)
 	}
 
+	if matches := asnNameRegex.FindStringSubmatch(fields[1]); matches != nil {
+		name := strings.ToLower(strings.TrimSpace(matches[1]))
+		if name == "" {
+			return nil, invalidDefinitionError(fields, "AS name can't be empty")
+		}
+
+		ep := &EndpointASN{
+			Name: name,
+		}
+		return ep.parsePPP(ep, fields)
+	}
+
 	return nil, nil
 }
@@ -0,0 +1,43 @@
+package endpoints
+
+import (
+	"context"
+	"strings"
+
+	"github.com/safing/portmaster/intel"
+)
+
+const (
+	p2pName    = "P2P"
+	p2pMatcher = "p2p"
+)
+
+// EndpointP2P matches direct connections to the Internet, which were not
+// preceded by resolving a domain.
+type EndpointP2P struct {
+	EndpointBase
+}
+
+// Matches checks whether the given entity matches this endpoint definition.
+func (ep *EndpointP2P) Matches(_ context.Context, entity *intel.Entity) (EPResult, Reason) {
+	if entity.IP == nil {
+		return Undeterminable, nil
+	}
+
+	if entity.Domain == "" && entity.IPScope.IsGlobal() {
+		return ep.match(ep, entity, p2pName, "direct connection matches")
+	}
+	return NoMatch, nil
+}
+
+func (ep *EndpointP2P) String() string {
+	return ep.renderPPP(p2pName)
+}
+
+func parseTypeP2P(fields []string) (Endpoint, error) {
+	if strings.ToLower(fields[1]) == p2pMatcher {
+		ep := &EndpointP2P{}
+		return ep.parsePPP(ep, fields)
+	}
+	return nil, nil
+}
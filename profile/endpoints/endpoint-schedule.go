@@ -0,0 +1,186 @@
+package endpoints
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/safing/portmaster/intel"
+)
+
+// Endpoints may end with a schedule qualifier, so that they only apply at
+// certain times, eg. "- * weekdays 22:00-07:00". The qualifier consists of the
+// days, the time window in local time, or both. The days refer to the start
+// of the time window.
+
+var (
+	timeWindowRegex = regexp.MustCompile(`^([01][0-9]|2[0-3]):([0-5][0-9])-([01][0-9]|2[0-3]):([0-5][0-9])$`)
+
+	dayNames = map[string]time.Weekday{
+		"sun": time.Sunday,
+		"mon": time.Monday,
+		"tue": time.Tuesday,
+		"wed": time.Wednesday,
+		"thu": time.Thursday,
+		"fri": time.Friday,
+		"sat": time.Saturday,
+	}
+
+	dayGroups = map[string][]time.Weekday{
+		"daily":    {time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday},
+		"weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+		"weekends": {time.Saturday, time.Sunday},
+	}
+
+	// timeNow returns the current local time and is replaced in tests.
+	timeNow = time.Now
+)
+
+// EndpointSchedule wraps an endpoint that only applies at certain times.
+type EndpointSchedule struct {
+	Endpoint
+
+	// days holds the week days on which the endpoint applies. The days refer
+	// to the start of the time window.
+	days [7]bool
+	// start and end are the minutes of the day of the time window. If they
+	// are equal, the endpoint applies the whole day.
+	start, end int
+
+	definition string
+}
+
+// Matches checks whether the given entity matches this endpoint definition.
+// The endpoint does not match outside of its schedule.
+func (ep *EndpointSchedule) Matches(ctx context.Context, entity *intel.Entity) (EPResult, Reason) {
+	if !ep.activeAt(timeNow()) {
+		return NoMatch, nil
+	}
+	return ep.Endpoint.Matches(ctx, entity)
+}
+
+func (ep *EndpointSchedule) String() string {
+	return ep.Endpoint.String() + " " + ep.definition
+}
+
+// activeAt returns whether the schedule is active at the given time.
+func (ep *EndpointSchedule) activeAt(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+
+	switch {
+	case ep.start == ep.end:
+		// Whole day.
+	case ep.start < ep.end:
+		if minute < ep.start || minute >= ep.end {
+			return false
+		}
+	case minute >= ep.start:
+		// Window spans midnight, before midnight on the start day.
+	case minute < ep.end:
+		// Window spans midnight, after midnight the window started on the
+		// previous day.
+		day = (day + 6) % 7
+	default:
+		return false
+	}
+
+	return ep.days[day]
+}
+
+// ScheduleState returns which of the scheduled endpoints apply at the given
+// time. It changes whenever a scheduled endpoint starts or stops applying and
+// is empty if there are no scheduled endpoints.
+func (e Endpoints) ScheduleState(t time.Time) string {
+	var state strings.Builder
+	for _, entry := range e {
+		if ep, ok := entry.(*EndpointSchedule); ok {
+			if ep.activeAt(t) {
+				state.WriteByte('1')
+			} else {
+				state.WriteByte('0')
+			}
+		}
+	}
+	return state.String()
+}
+
+// parseSchedule removes a trailing schedule qualifier from the fields and
+// returns it. It returns nil if the fields do not end with a schedule.
+func parseSchedule(fields []string) ([]string, *EndpointSchedule, error) {
+	schedule := &EndpointSchedule{}
+	for day := range schedule.days {
+		schedule.days[day] = true
+	}
+	var qualifier []string
+
+	// Only fields after the permission and the entity may be a schedule.
+	last := len(fields) - 1
+	if last >= 2 {
+		if match := timeWindowRegex.FindStringSubmatch(fields[last]); match != nil {
+			schedule.start = minuteOfDay(match[1], match[2])
+			schedule.end = minuteOfDay(match[3], match[4])
+			if schedule.start == schedule.end {
+				return nil, nil, invalidDefinitionError(fields, "schedule start and end must differ")
+			}
+			qualifier = append(qualifier, fields[last])
+			last--
+		}
+	}
+	if last >= 2 {
+		if days, ok := parseDays(fields[last]); ok {
+			schedule.days = days
+			qualifier = append([]string{fields[last]}, qualifier...)
+			last--
+		}
+	}
+
+	if len(qualifier) == 0 {
+		return fields, nil, nil
+	}
+	schedule.definition = strings.Join(qualifier, " ")
+	return fields[:last+1], schedule, nil
+}
+
+// parseDays parses a day group, such as "weekdays", or a comma separated list
+// of days and day ranges, such as "mon-thu,sat".
+func parseDays(value string) (days [7]bool, ok bool) {
+	value = strings.ToLower(value)
+	if group, ok := dayGroups[value]; ok {
+		for _, day := range group {
+			days[day] = true
+		}
+		return days, true
+	}
+
+	for _, part := range strings.Split(value, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		first, ok := dayNames[bounds[0]]
+		if !ok {
+			return days, false
+		}
+		lastDay := first
+		if len(bounds) == 2 {
+			if lastDay, ok = dayNames[bounds[1]]; !ok {
+				return days, false
+			}
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			days[day] = true
+			if day == lastDay {
+				break
+			}
+		}
+	}
+	return days, true
+}
+
+// minuteOfDay returns the minute of the day of the given hour and minute,
+// which must be valid numbers.
+func minuteOfDay(hour, minute string) int {
+	h, _ := strconv.Atoi(hour)
+	m, _ := strconv.Atoi(minute)
+	return h*60 + m
+}
@@ -5,6 +5,7 @@
 	"fmt"
 	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/safing/portmaster/intel"
 	"github.com/safing/portmaster/network/reference"
@@ -198,16 +199,63 @@ func (ep *EndpointBase) parsePPP(typedEp Endpoint, fields []string) (Endpoint, e
 	return typedEp, nil
 }
 
+// splitEndpointFields splits the endpoint definition at whitespace, but keeps
+// double quoted strings together.
+func splitEndpointFields(value string) []string {
+	if !strings.Contains(value, `"`) {
+		return strings.Fields(value)
+	}
+
+	var (
+		fields  []string
+		current strings.Builder
+		quoted  bool
+	)
+	for _, r := range value {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			current.WriteRune(r)
+		case !quoted && unicode.IsSpace(r):
+			if current.Len() > 0 {
+				fields = append(fields, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		fields = append(fields, current.String())
+	}
+	return fields
+}
+
 func invalidDefinitionError(fields []string, msg string) error {
 	return fmt.Errorf(`invalid endpoint definition: "%s" - %s`, strings.Join(fields, " "), msg)
 }
 
-func parseEndpoint(value string) (endpoint Endpoint, err error) { //nolint:gocognit
-	fields := strings.Fields(value)
+func parseEndpoint(value string) (endpoint Endpoint, err error) {
+	fields := splitEndpointFields(value)
 	if len(fields) < 2 {
 		return nil, fmt.Errorf(`invalid endpoint definition: "%s"`, value)
 	}
 
+	// Remove schedule qualifier.
+	fields, schedule, err := parseSchedule(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint, err = parseEndpointType(value, fields)
+	if err != nil || schedule == nil {
+		return endpoint, err
+	}
+	schedule.Endpoint = endpoint
+	return schedule, nil
+}
+
+func parseEndpointType(value string, fields []string) (endpoint Endpoint, err error) { //nolint:gocognit
 	// any
 	if endpoint, err = parseTypeAny(fields); endpoint != nil || err != nil {
 		return
@@ -232,6 +280,10 @@ func parseEndpoint(value string) (endpoint Endpoint, err error) { //nolint:gocog
 	if endpoint, err = parseTypeScope(fields); endpoint != nil || err != nil {
 		return
 	}
+	// p2p
+	if endpoint, err = parseTypeP2P(fields); endpoint != nil || err != nil {
+		return
+	}
 	// lists
 	if endpoint, err = parseTypeList(fields); endpoint != nil || err != nil {
 		return
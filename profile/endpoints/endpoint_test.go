@@ -49,6 +49,9 @@ func TestEndpointParsing(t *testing.T) {
 	testParsing(t, "+ Internet")
 	testParsing(t, "+ Localhost,LAN,Internet")
 
+	// direct connections
+	testParsing(t, "- P2P")
+
 	// protocol and ports
 	testParsing(t, "+ * TCP/1-1024")
 	testParsing(t, "+ * */DNS")
@@ -57,6 +60,12 @@ func TestEndpointParsing(t *testing.T) {
 	testParsing(t, "+ * UDP/1234")
 	testParsing(t, "+ * TCP/HTTP")
 	testParsing(t, "+ * TCP/80-443")
+
+	// schedules
+	testParsing(t, "- * weekdays 22:00-07:00")
+	testParsing(t, "- * 22:00-07:00")
+	testParsing(t, "- * weekends")
+	testParsing(t, "+ example.com TCP/HTTPS mon-thu,sat 08:00-18:00")
 }
 
 func testParsing(t *testing.T, value string) {
@@ -58,6 +58,11 @@ func ParseEndpoints(entries []string) (Endpoints, error) {
 	return endpoints, nil
 }
 
+// ParseEndpoint parses a single endpoint definition.
+func ParseEndpoint(entry string) (Endpoint, error) {
+	return parseEndpoint(entry)
+}
+
 // IsSet returns whether the Endpoints object is "set".
 func (e Endpoints) IsSet() bool {
 	return len(e) > 0
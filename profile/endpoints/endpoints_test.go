@@ -5,6 +5,7 @@
 	"net"
 	"runtime"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -55,6 +56,115 @@ func TestEndpointFormat(t *testing.T) {
 	testFormat(t, "+ *has.prefix.*", true)
 	testFormat(t, "+ .sub.and.prefix.*", false)
 	testFormat(t, "+ *.sub..and.prefix.*", false)
+	testFormat(t, "+ AS13335", true)
+	testFormat(t, "+ ASN:13335", true)
+	testFormat(t, `+ ASN:"Cloudflare"`, true)
+	testFormat(t, `- ASN:"Cloudflare, Inc." TCP/443`, true)
+	testFormat(t, `+ ASN:""`, false)
+	testFormat(t, `+ ASN:"Cloudflare`, false)
+	testFormat(t, "- * weekdays 22:00-07:00", true)
+	testFormat(t, "- * 22:00-22:00", false)
+	testFormat(t, "- * someday 22:00-07:00", false)
+}
+
+func TestScheduledEndpointMatching(t *testing.T) {
+	defer func() {
+		timeNow = time.Now
+	}()
+	setTime := func(value string) {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", value, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		timeNow = func() time.Time { return tm }
+	}
+
+	ep, err := parseEndpoint("- * weekdays 22:00-07:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entity := &intel.Entity{}
+	entity.SetIP(net.ParseIP("1.1.1.1"))
+
+	// 2021-03-01 is a Monday.
+	setTime("2021-03-01 21:59")
+	testEndpointMatch(t, ep, entity, NoMatch)
+	setTime("2021-03-01 22:00")
+	testEndpointMatch(t, ep, entity, Denied)
+	setTime("2021-03-02 06:59")
+	testEndpointMatch(t, ep, entity, Denied)
+	setTime("2021-03-02 07:00")
+	testEndpointMatch(t, ep, entity, NoMatch)
+	// Friday night belongs to a weekday.
+	setTime("2021-03-06 03:00")
+	testEndpointMatch(t, ep, entity, Denied)
+	// Saturday night does not.
+	setTime("2021-03-07 03:00")
+	testEndpointMatch(t, ep, entity, NoMatch)
+
+	ep, err = parseEndpoint("+ * sat,sun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setTime("2021-03-06 12:00")
+	testEndpointMatch(t, ep, entity, Permitted)
+	setTime("2021-03-08 12:00")
+	testEndpointMatch(t, ep, entity, NoMatch)
+}
+
+func TestEndpointScheduleState(t *testing.T) {
+	eps, err := ParseEndpoints([]string{"+ 1.1.1.1", "- * weekdays 22:00-07:00", "+ * sat,sun"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2021-03-01 is a Monday.
+	for value, expected := range map[string]string{
+		"2021-03-01 21:59": "00",
+		"2021-03-01 22:00": "10",
+		"2021-03-06 03:00": "11",
+		"2021-03-06 12:00": "01",
+	} {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", value, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state := eps.ScheduleState(tm); state != expected {
+			t.Errorf("unexpected schedule state at %s: got %q, expected %q", value, state, expected)
+		}
+	}
+
+	eps, err = ParseEndpoints([]string{"+ 1.1.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state := eps.ScheduleState(time.Now()); state != "" {
+		t.Errorf("unexpected schedule state without schedules: %q", state)
+	}
+}
+
+func TestASNEndpointParsing(t *testing.T) {
+	ep, err := parseEndpoint("+ ASN:13335")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint(13335), ep.(*EndpointASN).ASN)
+	assert.Equal(t, "+ AS13335", ep.String())
+
+	ep, err = parseEndpoint(`- ASN:"Cloudflare, Inc." TCP/443`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "cloudflare, inc.", ep.(*EndpointASN).Name)
+	assert.Equal(t, `- ASN:"cloudflare, inc." TCP/HTTPS`, ep.String())
+
+	testEndpointMatch(t, ep, &intel.Entity{
+		IP:       net.ParseIP("1.1.1.1"),
+		Protocol: 6,
+		Port:     443,
+		ASN:      13335,
+		ASOrg:    "CLOUDFLARE, INC.",
+	}, Denied)
 }
 
 func TestEndpointMatching(t *testing.T) {
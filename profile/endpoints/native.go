@@ -0,0 +1,121 @@
+package endpoints
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// NativeRule is a best-effort representation of an endpoint that native
+// firewalls can enforce. It only matches on addresses, protocol and ports.
+type NativeRule struct {
+	// Networks holds the matched networks. If empty, any address matches.
+	Networks []*net.IPNet
+
+	Protocol  uint8
+	StartPort uint16
+	EndPort   uint16
+
+	Permitted bool
+
+	// Source is the endpoint the rule was created from.
+	Source string
+}
+
+// MatchesAll returns whether the rule matches all connections.
+func (nr *NativeRule) MatchesAll() bool {
+	return len(nr.Networks) == 0 && nr.Protocol == 0 && nr.StartPort == 0
+}
+
+var (
+	nativeLocalhostNets = mustParseCIDRs(
+		"127.0.0.0/8",
+		"::1/128",
+	)
+	nativeLANNets = mustParseCIDRs(
+		"10.0.0.0/8",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"169.254.0.0/16",
+		"224.0.0.0/24",
+		"fc00::/7",
+		"fe80::/10",
+		"ff02::/16",
+	)
+)
+
+// ToNativeRule converts the endpoint to a native rule. Endpoints that depend
+// on information native firewalls do not have, such as domains, ASNs,
+// countries or filter lists, return an error.
+func ToNativeRule(ep Endpoint) (*NativeRule, error) {
+	var (
+		base     *EndpointBase
+		networks []*net.IPNet
+	)
+
+	switch typedEp := ep.(type) {
+	case *EndpointAny:
+		base = &typedEp.EndpointBase
+	case *EndpointIP:
+		base = &typedEp.EndpointBase
+		bits := 32
+		if typedEp.IP.To4() == nil {
+			bits = 128
+		}
+		networks = []*net.IPNet{{
+			IP:   typedEp.IP,
+			Mask: net.CIDRMask(bits, bits),
+		}}
+	case *EndpointIPRange:
+		base = &typedEp.EndpointBase
+		networks = []*net.IPNet{typedEp.Net}
+	case *EndpointScope:
+		base = &typedEp.EndpointBase
+		if typedEp.scopes&scopeInternet > 0 {
+			if typedEp.scopes != scopeLocalhost|scopeLAN|scopeInternet {
+				return nil, fmt.Errorf("the %s scope cannot be expressed as address ranges", scopeInternetName)
+			}
+			// All scopes together match any address.
+			break
+		}
+		if typedEp.scopes&scopeLocalhost > 0 {
+			networks = append(networks, nativeLocalhostNets...)
+		}
+		if typedEp.scopes&scopeLAN > 0 {
+			networks = append(networks, nativeLANNets...)
+		}
+	case *EndpointDomain:
+		return nil, errors.New("domains require DNS filtering")
+	case *EndpointASN, *EndpointCountry:
+		return nil, errors.New("ASNs and countries require geolocation data")
+	case *EndpointLists:
+		return nil, errors.New("filter lists require their data")
+	case *EndpointP2P:
+		return nil, errors.New("P2P detection requires DNS filtering")
+	case *EndpointSchedule:
+		return nil, errors.New("schedules require evaluation at connection time")
+	default:
+		return nil, errors.New("unsupported rule type")
+	}
+
+	return &NativeRule{
+		Networks:  networks,
+		Protocol:  base.Protocol,
+		StartPort: base.StartPort,
+		EndPort:   base.EndPort,
+		Permitted: base.Permitted,
+		Source:    ep.String(),
+	}, nil
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets
+}
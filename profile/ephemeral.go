@@ -0,0 +1,300 @@
+package profile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tevino/abool"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/log"
+)
+
+// An ephemeral session discards all changes to profiles and their related
+// data when it ends. At the start, all records of the profile package and the
+// user values of the global settings are snapshotted into a session record,
+// so that the session survives restarts.
+
+const ephemeralSessionKey = "core:profile-session/ephemeral"
+
+// ErrNoEphemeralSession is returned when ending an ephemeral session while
+// none is active.
+var ErrNoEphemeralSession = errors.New("no ephemeral session is active")
+
+// ephemeralSessionPaths are the database paths that are restored at the end
+// of an ephemeral session.
+var ephemeralSessionPaths = []string{
+	profilesDBPath,
+	learnedPortsDBPath,
+	allowlistsDBPath,
+	destinationNotesDBPath,
+	schedulesDBPath,
+}
+
+type ephemeralSession struct {
+	record.Base
+	sync.Mutex
+
+	// Started holds the UTC timestamp in seconds when the session started.
+	Started int64
+	// Snapshot holds the serialized records at the start of the session.
+	Snapshot map[string][]byte
+	// Config holds the user values of the global settings at the start of
+	// the session, in the flat (key=value) form.
+	Config map[string]interface{}
+}
+
+var (
+	// ephemeralSessionActive is read in the packet path, so it does not
+	// require ephemeralSessionLock, which serializes starting and ending.
+	ephemeralSessionActive = abool.New()
+	ephemeralSessionLock   sync.Mutex
+)
+
+// EphemeralSessionActive returns whether an ephemeral session is active.
+func EphemeralSessionActive() bool {
+	return ephemeralSessionActive.IsSet()
+}
+
+// loadEphemeralSession checks whether an ephemeral session was active before
+// the last shutdown.
+func loadEphemeralSession() error {
+	exists, err := profileDB.Exists(ephemeralSessionKey)
+	if err != nil {
+		return err
+	}
+
+	ephemeralSessionLock.Lock()
+	defer ephemeralSessionLock.Unlock()
+
+	ephemeralSessionActive.SetTo(exists)
+	if exists {
+		log.Infof("profile: resuming ephemeral session")
+	}
+	return nil
+}
+
+// StartEphemeralSession snapshots all profiles, their related data and the
+// global settings. The snapshot is restored by EndEphemeralSession.
+func StartEphemeralSession() error {
+	ephemeralSessionLock.Lock()
+	defer ephemeralSessionLock.Unlock()
+
+	if ephemeralSessionActive.IsSet() {
+		return errors.New("an ephemeral session is already active")
+	}
+
+	session := &ephemeralSession{
+		Started:  time.Now().Unix(),
+		Snapshot: make(map[string][]byte),
+	}
+	var err error
+	session.Config, err = globalConfigValues()
+	if err != nil {
+		return fmt.Errorf("failed to snapshot global settings: %w", err)
+	}
+	for _, path := range ephemeralSessionPaths {
+		it, err := profileDB.Query(query.New(path))
+		if err != nil {
+			return err
+		}
+		for r := range it.Next {
+			data, err := r.MarshalRecord(r)
+			if err != nil {
+				it.Cancel()
+				return fmt.Errorf("failed to snapshot %s: %w", r.Key(), err)
+			}
+			session.Snapshot[r.DatabaseKey()] = data
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+	}
+
+	session.SetKey(ephemeralSessionKey)
+	session.SetMeta(&record.Meta{})
+	session.Meta().MakeSecret()
+	if err := profileDB.Put(session); err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+
+	ephemeralSessionActive.Set()
+	log.Infof("profile: started ephemeral session with %d records and %d global settings", len(session.Snapshot), len(session.Config))
+	return nil
+}
+
+// EndEphemeralSession restores the snapshot of the ephemeral session: records
+// created during the session are deleted, changed records are reverted and
+// the global settings are restored.
+func EndEphemeralSession() error {
+	ephemeralSessionLock.Lock()
+	defer ephemeralSessionLock.Unlock()
+
+	if !ephemeralSessionActive.IsSet() {
+		return ErrNoEphemeralSession
+	}
+
+	r, err := profileDB.Get(ephemeralSessionKey)
+	if err != nil {
+		return fmt.Errorf("failed to load session: %w", err)
+	}
+	session := &ephemeralSession{}
+	if err := record.Unwrap(r, session); err != nil {
+		return fmt.Errorf("failed to parse session: %w", err)
+	}
+
+	var deleted, reverted int
+	for _, path := range ephemeralSessionPaths {
+		// Collect first, as the database must not be changed while iterating.
+		var changed []record.Record
+		it, err := profileDB.Query(query.New(path))
+		if err != nil {
+			return err
+		}
+		for r := range it.Next {
+			changed = append(changed, r)
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+
+		for _, r := range changed {
+			_, existed := session.Snapshot[r.DatabaseKey()]
+			switch {
+			case !existed:
+				if err := profileDB.Delete(r.Key()); err != nil {
+					log.Warningf("profile: failed to delete %s created in ephemeral session: %s", r.Key(), err)
+				}
+				deleted++
+			case r.Meta().Modified >= session.Started:
+				// Reverted below with all other snapshotted records.
+			default:
+				delete(session.Snapshot, r.DatabaseKey())
+			}
+		}
+	}
+
+	// Restore records that were changed or deleted.
+	for dbKey, data := range session.Snapshot {
+		w, err := record.NewRawWrapper("core", dbKey, data)
+		if err != nil {
+			log.Warningf("profile: failed to restore %s: %s", dbKey, err)
+			continue
+		}
+		w.Meta().Update()
+		if strings.HasPrefix(w.Key(), schedulesDBPath) {
+			w.Meta().MakeSecret()
+		}
+		if err := profileDB.Put(w); err != nil {
+			log.Warningf("profile: failed to restore %s: %s", dbKey, err)
+			continue
+		}
+		reverted++
+	}
+
+	reverted += restoreGlobalConfig(session.Config)
+
+	if err := profileDB.Delete(ephemeralSessionKey); err != nil && !errors.Is(err, database.ErrNotFound) {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	ephemeralSessionActive.UnSet()
+
+	resetProfileData()
+	log.Infof("profile: ended ephemeral session, deleted %d and reverted %d records", deleted, reverted)
+	return nil
+}
+
+// globalConfigValues returns the user values of all global settings that are
+// set.
+func globalConfigValues() (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	err := config.ForEachOption(func(option *config.Option) error {
+		r, err := option.Export()
+		if err != nil {
+			return err
+		}
+		if value, ok := r.GetAccessor(r).Get("Value"); ok {
+			values[option.Key] = value
+		}
+		return nil
+	})
+	return values, err
+}
+
+// restoreGlobalConfig sets the user values of the global settings to the
+// given snapshot and returns the amount of settings that were changed.
+func restoreGlobalConfig(snapshot map[string]interface{}) (reverted int) {
+	// Sessions started by older versions have no snapshot of the settings.
+	if snapshot == nil {
+		return 0
+	}
+
+	current, err := globalConfigValues()
+	if err != nil {
+		log.Warningf("profile: failed to get global settings: %s", err)
+		return 0
+	}
+
+	for key := range current {
+		if _, ok := snapshot[key]; !ok {
+			snapshot[key] = nil
+		}
+	}
+	for key, value := range snapshot {
+		if configValueEqual(current[key], value) {
+			continue
+		}
+		if err := config.SetConfigOption(key, value); err != nil {
+			log.Warningf("profile: failed to restore global setting %s: %s", key, err)
+			continue
+		}
+		reverted++
+	}
+	return reverted
+}
+
+// configValueEqual compares config values by their JSON form, as values
+// loaded from the database differ in type from the ones set.
+func configValueEqual(a, b interface{}) bool {
+	aJSON, errA := json.Marshal(a)
+	bJSON, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(aJSON) == string(bJSON)
+}
+
+// resetProfileData reloads all data related to profiles from the database
+// and marks all active profiles as outdated.
+func resetProfileData() {
+	learnedPortsLock.Lock()
+	learnedPorts = make(map[string]*LearnedPorts)
+	learnedPortsLock.Unlock()
+
+	allowlistsLock.Lock()
+	allowlists = make(map[string]*Allowlist)
+	allowlistsLock.Unlock()
+	if err := loadAllowlists(); err != nil {
+		log.Warningf("profile: failed to reload allowlists: %s", err)
+	}
+
+	destinationNotesLock.Lock()
+	destinationNotes = make(map[string]*DestinationNote)
+	destinationNotesLock.Unlock()
+	if err := loadDestinationNotes(); err != nil {
+		log.Warningf("profile: failed to reload destination notes: %s", err)
+	}
+
+	schedulesLock.Lock()
+	schedules = make(map[string]*ProfileSchedules)
+	schedulesLock.Unlock()
+	if err := loadSchedules(); err != nil {
+		log.Warningf("profile: failed to reload schedules: %s", err)
+	}
+
+	markAllActiveProfilesAsOutdated()
+}
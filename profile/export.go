@@ -0,0 +1,327 @@
+package profile
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/safing/portmaster/profile/endpoints"
+)
+
+// Export Formats
+const (
+	ExportFormatNFTables = "nftables"
+	ExportFormatIPTables = "iptables"
+	ExportFormatWindows  = "windows"
+)
+
+const (
+	protocolTCP = 6
+	protocolUDP = 17
+)
+
+// exportRuleSet holds the effective rules of a profile or of the global
+// configuration in a form that native firewalls can enforce.
+type exportRuleSet struct {
+	name string
+
+	outbound      []*endpoints.NativeRule
+	outboundBlock bool
+	inbound       []*endpoints.NativeRule
+	inboundBlock  bool
+
+	// notes holds rules that could not be exported and other caveats.
+	notes []string
+}
+
+// ExportRules renders the effective rules of the profile with the given
+// scoped ID, or of the global configuration if it is empty, in the syntax of
+// the given native firewall. The export is best-effort: rules that depend on
+// domains, ASNs, countries or filter lists are skipped and listed in the
+// header of the export.
+func ExportRules(scopedID, format string) ([]byte, error) {
+	var profile *Profile
+	if scopedID != "" {
+		var err error
+		profile, err = getProfile(scopedID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get profile: %w", err)
+		}
+	}
+
+	rs := buildExportRuleSet(profile)
+	switch format {
+	case ExportFormatNFTables:
+		return rs.renderNFTables(), nil
+	case ExportFormatIPTables:
+		return rs.renderIPTables(), nil
+	case ExportFormatWindows:
+		return rs.renderWindows(), nil
+	default:
+		return nil, fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+func buildExportRuleSet(profile *Profile) *exportRuleSet {
+	rs := &exportRuleSet{
+		name: "global settings",
+		// Incoming connections are blocked by default.
+		inboundBlock: true,
+	}
+
+	cfgLock.RLock()
+	defer cfgLock.RUnlock()
+
+	defaultAction := cfgDefaultAction
+	var outbound, inbound endpoints.Endpoints
+	if profile != nil {
+		rs.name = profile.Name
+		if profile.defaultAction != DefaultActionNotSet {
+			defaultAction = profile.defaultAction
+		}
+		outbound = append(outbound, profile.endpoints...)
+		inbound = append(inbound, profile.serviceEndpoints...)
+	}
+	outbound = append(outbound, cfgEndpoints...)
+	inbound = append(inbound, cfgServiceEndpoints...)
+
+	switch defaultAction {
+	case DefaultActionPermit:
+		rs.outboundBlock = false
+	case DefaultActionAsk:
+		rs.outboundBlock = true
+		rs.notes = append(rs.notes, "default action is to ask, which is exported as block")
+	default:
+		rs.outboundBlock = true
+	}
+
+	rs.outbound = rs.convert(outbound, &rs.outboundBlock)
+	rs.inbound = rs.convert(inbound, &rs.inboundBlock)
+	return rs
+}
+
+// convert converts the endpoints to native rules. A rule that matches all
+// connections ends the list and replaces the default action.
+func (rs *exportRuleSet) convert(eps endpoints.Endpoints, block *bool) []*endpoints.NativeRule {
+	rules := make([]*endpoints.NativeRule, 0, len(eps))
+	for _, ep := range eps {
+		rule, err := endpoints.ToNativeRule(ep)
+		if err != nil {
+			rs.notes = append(rs.notes, fmt.Sprintf("skipped %q: %s", ep.String(), err))
+			continue
+		}
+		if rule.MatchesAll() {
+			*block = !rule.Permitted
+			break
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func (rs *exportRuleSet) writeHeader(buf *bytes.Buffer, comment string, caveats ...string) {
+	fmt.Fprintf(buf, "%s Portmaster rules of %s.\n", comment, rs.name)
+	fmt.Fprintf(buf, "%s This export is best-effort and does not replace the Portmaster.\n", comment)
+	for _, note := range append(caveats, rs.notes...) {
+		fmt.Fprintf(buf, "%s - %s\n", comment, note)
+	}
+	buf.WriteString("\n")
+}
+
+func (rs *exportRuleSet) renderNFTables() []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString("#!/usr/sbin/nft -f\n")
+	rs.writeHeader(buf, "#")
+
+	buf.WriteString("table inet portmaster {\n")
+	rs.writeNFTablesChain(buf, "output", "daddr", rs.outbound, rs.outboundBlock)
+	buf.WriteString("\n")
+	rs.writeNFTablesChain(buf, "input", "saddr", rs.inbound, rs.inboundBlock)
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+func (rs *exportRuleSet) writeNFTablesChain(buf *bytes.Buffer, hook, addrField string, rules []*endpoints.NativeRule, block bool) {
+	fmt.Fprintf(buf, "\tchain %s {\n", hook)
+	fmt.Fprintf(buf, "\t\ttype filter hook %s priority 0; policy accept;\n", hook)
+	buf.WriteString("\t\tct state established,related accept\n")
+
+	for _, rule := range rules {
+		var ppp string
+		switch {
+		case rule.StartPort > 0 && rule.Protocol == 0:
+			ppp = "meta l4proto { tcp, udp } th dport " + renderPortRange(rule, "-")
+		case rule.StartPort > 0:
+			ppp = "meta l4proto " + strconv.Itoa(int(rule.Protocol)) + " th dport " + renderPortRange(rule, "-")
+		case rule.Protocol > 0:
+			ppp = "meta l4proto " + strconv.Itoa(int(rule.Protocol))
+		}
+
+		verdict := "drop"
+		if rule.Permitted {
+			verdict = "accept"
+		}
+		comment := fmt.Sprintf("comment %q", strings.ReplaceAll(rule.Source, `"`, "'"))
+
+		if len(rule.Networks) == 0 {
+			fmt.Fprintf(buf, "\t\t%s %s\n", joinNonEmpty(ppp, verdict), comment)
+			continue
+		}
+		for _, network := range rule.Networks {
+			family := "ip"
+			if network.IP.To4() == nil {
+				family = "ip6"
+			}
+			fmt.Fprintf(buf, "\t\t%s %s %s\n", joinNonEmpty(family, addrField, network.String(), ppp), verdict, comment)
+		}
+	}
+
+	if block {
+		buf.WriteString("\t\tdrop\n")
+	}
+	buf.WriteString("\t}\n")
+}
+
+func (rs *exportRuleSet) renderIPTables() []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString("#!/bin/sh\n")
+	rs.writeHeader(buf, "#")
+
+	for _, cmd := range []string{"iptables", "ip6tables"} {
+		rs.writeIPTablesChain(buf, cmd, "OUTPUT", "PORTMASTER-OUT", "-d", rs.outbound, rs.outboundBlock)
+		rs.writeIPTablesChain(buf, cmd, "INPUT", "PORTMASTER-IN", "-s", rs.inbound, rs.inboundBlock)
+	}
+	return buf.Bytes()
+}
+
+func (rs *exportRuleSet) writeIPTablesChain(buf *bytes.Buffer, cmd, builtinChain, chain, addrFlag string, rules []*endpoints.NativeRule, block bool) {
+	ipv6 := cmd == "ip6tables"
+
+	fmt.Fprintf(buf, "%s -N %s\n", cmd, chain)
+	fmt.Fprintf(buf, "%s -A %s -j %s\n", cmd, builtinChain, chain)
+	fmt.Fprintf(buf, "%s -A %s -m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT\n", cmd, chain)
+
+	for _, rule := range rules {
+		target := "DROP"
+		if rule.Permitted {
+			target = "ACCEPT"
+		}
+		comment := "-m comment --comment '" + strings.ReplaceAll(rule.Source, "'", `"`) + "'"
+
+		var addrs []string
+		if len(rule.Networks) == 0 {
+			addrs = []string{""}
+		}
+		for _, network := range rule.Networks {
+			if (network.IP.To4() == nil) == ipv6 {
+				addrs = append(addrs, addrFlag+" "+network.String())
+			}
+		}
+
+		for _, addr := range addrs {
+			for _, ppp := range iptablesPPP(rule) {
+				fmt.Fprintf(buf, "%s -A %s %s -j %s\n", cmd, chain, joinNonEmpty(addr, ppp, comment), target)
+			}
+		}
+	}
+
+	if block {
+		fmt.Fprintf(buf, "%s -A %s -j DROP\n", cmd, chain)
+	}
+	buf.WriteString("\n")
+}
+
+func iptablesPPP(rule *endpoints.NativeRule) []string {
+	switch {
+	case rule.StartPort > 0 && rule.Protocol == 0:
+		ports := renderPortRange(rule, ":")
+		return []string{
+			"-p tcp --dport " + ports,
+			"-p udp --dport " + ports,
+		}
+	case rule.StartPort > 0:
+		return []string{"-p " + strconv.Itoa(int(rule.Protocol)) + " --dport " + renderPortRange(rule, ":")}
+	case rule.Protocol > 0:
+		return []string{"-p " + strconv.Itoa(int(rule.Protocol))}
+	default:
+		return []string{""}
+	}
+}
+
+func (rs *exportRuleSet) renderWindows() []byte {
+	buf := &bytes.Buffer{}
+	rs.writeHeader(
+		buf, "#",
+		"Windows Firewall applies block rules before allow rules, so exceptions to block rules are lost",
+	)
+
+	rs.writeWindowsRules(buf, "Outbound", "RemotePort", rs.outbound)
+	rs.writeWindowsRules(buf, "Inbound", "LocalPort", rs.inbound)
+
+	fmt.Fprintf(
+		buf,
+		"Set-NetFirewallProfile -All -DefaultOutboundAction %s -DefaultInboundAction %s\n",
+		windowsAction(!rs.outboundBlock),
+		windowsAction(!rs.inboundBlock),
+	)
+	return buf.Bytes()
+}
+
+func (rs *exportRuleSet) writeWindowsRules(buf *bytes.Buffer, direction, portParam string, rules []*endpoints.NativeRule) {
+	for _, rule := range rules {
+		params := []string{
+			"-Group 'Portmaster'",
+			"-DisplayName 'Portmaster: " + strings.ReplaceAll(rule.Source, "'", "''") + "'",
+			"-Direction " + direction,
+			"-Action " + windowsAction(rule.Permitted),
+		}
+		if len(rule.Networks) > 0 {
+			networks := make([]string, 0, len(rule.Networks))
+			for _, network := range rule.Networks {
+				networks = append(networks, network.String())
+			}
+			params = append(params, "-RemoteAddress "+strings.Join(networks, ","))
+		}
+
+		protocols := []uint8{rule.Protocol}
+		if rule.StartPort > 0 && rule.Protocol == 0 {
+			protocols = []uint8{protocolTCP, protocolUDP}
+		}
+		for _, protocol := range protocols {
+			ruleParams := append([]string{}, params...)
+			if protocol > 0 {
+				ruleParams = append(ruleParams, "-Protocol "+strconv.Itoa(int(protocol)))
+			}
+			if rule.StartPort > 0 {
+				ruleParams = append(ruleParams, "-"+portParam+" "+renderPortRange(rule, "-"))
+			}
+			fmt.Fprintf(buf, "New-NetFirewallRule %s\n", strings.Join(ruleParams, " "))
+		}
+	}
+	buf.WriteString("\n")
+}
+
+func windowsAction(permitted bool) string {
+	if permitted {
+		return "Allow"
+	}
+	return "Block"
+}
+
+func renderPortRange(rule *endpoints.NativeRule, sep string) string {
+	if rule.StartPort == rule.EndPort {
+		return strconv.Itoa(int(rule.StartPort))
+	}
+	return strconv.Itoa(int(rule.StartPort)) + sep + strconv.Itoa(int(rule.EndPort))
+}
+
+func joinNonEmpty(parts ...string) string {
+	nonEmpty := parts[:0:0]
+	for _, part := range parts {
+		if part != "" {
+			nonEmpty = append(nonEmpty, part)
+		}
+	}
+	return strings.Join(nonEmpty, " ")
+}
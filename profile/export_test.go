@@ -0,0 +1,73 @@
+package profile
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/safing/portmaster/profile/endpoints"
+)
+
+func TestExportRules(t *testing.T) {
+	eps, err := endpoints.ParseEndpoints([]string{
+		"+ 10.0.0.1 TCP/22",
+		"- example.com",
+		"+ LAN */53",
+		"- 2001:db8::/32",
+		"+ *",
+		"- 1.1.1.1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs := &exportRuleSet{
+		name:          "test",
+		outboundBlock: true,
+		inboundBlock:  true,
+	}
+	rs.outbound = rs.convert(eps, &rs.outboundBlock)
+	if rs.outboundBlock {
+		t.Error("a permit all rule should replace the default action")
+	}
+	if len(rs.outbound) != 3 {
+		t.Fatalf("expected 3 native rules, got %d", len(rs.outbound))
+	}
+	if len(rs.notes) != 1 || !strings.Contains(rs.notes[0], "example.com") {
+		t.Errorf("expected the domain rule to be skipped, got notes %v", rs.notes)
+	}
+
+	for _, test := range []struct {
+		rendered string
+		expected []string
+	}{
+		{
+			string(rs.renderNFTables()),
+			[]string{
+				`ip daddr 10.0.0.1/32 meta l4proto 6 th dport 22 accept`,
+				`ip daddr 192.168.0.0/16 meta l4proto { tcp, udp } th dport 53 accept`,
+				`ip6 daddr 2001:db8::/32 drop`,
+			},
+		},
+		{
+			string(rs.renderIPTables()),
+			[]string{
+				`iptables -A PORTMASTER-OUT -d 10.0.0.1/32 -p 6 --dport 22`,
+				`ip6tables -A PORTMASTER-OUT -d fe80::/10 -p udp --dport 53`,
+				`iptables -A PORTMASTER-IN -j DROP`,
+			},
+		},
+		{
+			string(rs.renderWindows()),
+			[]string{
+				`-Direction Outbound -Action Allow -RemoteAddress 10.0.0.1/32 -Protocol 6 -RemotePort 22`,
+				`-DefaultOutboundAction Allow -DefaultInboundAction Block`,
+			},
+		},
+	} {
+		for _, expected := range test.expected {
+			if !strings.Contains(test.rendered, expected) {
+				t.Errorf("expected export to contain %q:\n%s", expected, test.rendered)
+			}
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package profile
+
+import (
+	"strings"
+	"time"
+
+	"github.com/safing/portbase/utils/osdetail"
+)
+
+// Profiles are linked to binaries by their path, which is easy to spoof. The
+// fingerprint of the binary a profile was created for is therefore recorded
+// and checked when the profile is assigned to a process.
+
+// Fingerprint identifies the binary of an app.
+type Fingerprint struct {
+	// SHA256 holds the hex encoded SHA256 sum of the binary.
+	SHA256 string
+	// SigningIdentity holds the subject of the code signing certificate of
+	// the binary, if it has a valid signature. It is only available on
+	// Windows.
+	SigningIdentity string `json:",omitempty"`
+	// Recorded holds the UTC timestamp in seconds when the fingerprint was
+	// recorded.
+	Recorded int64
+}
+
+// FingerprintMatch describes how a binary matches the fingerprint of a
+// profile.
+type FingerprintMatch uint8
+
+// Fingerprint Matches
+const (
+	// FingerprintUnknown means that the profile has no fingerprint yet.
+	FingerprintUnknown FingerprintMatch = iota
+	// FingerprintMatches means that the binary is the one of the profile.
+	FingerprintMatches
+	// FingerprintSameSigner means that the binary changed, but is signed by
+	// the same identity, as is the case with updates.
+	FingerprintSameSigner
+	// FingerprintMismatch means that the binary changed.
+	FingerprintMismatch
+	// FingerprintUnsigned means that the binary changed, but neither it nor
+	// the recorded binary is signed, so the change cannot be verified. This
+	// is the case with every update of unsigned apps.
+	FingerprintUnsigned
+)
+
+// fingerprintLinkedPathPrefix is used for linked paths of separate profiles,
+// which are created for binaries that do not match the fingerprint of the
+// profile of their path.
+const fingerprintLinkedPathPrefix = "fingerprint:"
+
+// Compare returns how the binary with the given fingerprint matches this
+// fingerprint.
+func (fp *Fingerprint) Compare(binary *Fingerprint) FingerprintMatch {
+	switch {
+	case fp == nil || fp.SHA256 == "":
+		return FingerprintUnknown
+	case fp.SHA256 == binary.SHA256:
+		return FingerprintMatches
+	case fp.SigningIdentity != "" && fp.SigningIdentity == binary.SigningIdentity:
+		return FingerprintSameSigner
+	case fp.SigningIdentity == "" && binary.SigningIdentity == "":
+		return FingerprintUnsigned
+	default:
+		return FingerprintMismatch
+	}
+}
+
+// MatchFingerprint returns how the binary with the given fingerprint matches
+// the fingerprint of the profile.
+func (profile *Profile) MatchFingerprint(binary *Fingerprint) FingerprintMatch {
+	profile.Lock()
+	defer profile.Unlock()
+
+	return profile.Fingerprint.Compare(binary)
+}
+
+// UpdateFingerprint records the given fingerprint of the binary of the
+// profile and saves the profile.
+func (profile *Profile) UpdateFingerprint(binary *Fingerprint) error {
+	profile.Lock()
+	profile.Fingerprint = &Fingerprint{
+		SHA256:          binary.SHA256,
+		SigningIdentity: binary.SigningIdentity,
+		Recorded:        time.Now().Unix(),
+	}
+	profile.Unlock()
+
+	return profile.Save()
+}
+
+// MakeFingerprintLinkedPath returns the linked path for a separate profile of
+// the binary at the given path with the given SHA256 sum.
+func MakeFingerprintLinkedPath(binaryPath, sha256 string) string {
+	return fingerprintLinkedPathPrefix + sha256 + ":" + binaryPath
+}
+
+// IsFingerprintLinkedPath returns whether the given linked path refers to a
+// separate profile of a binary that did not match its fingerprint.
+func IsFingerprintLinkedPath(linkedPath string) bool {
+	return strings.HasPrefix(linkedPath, fingerprintLinkedPathPrefix)
+}
+
+// generateNameFromFingerprint returns a profile name from the linked path of
+// a separate profile of a binary that did not match its fingerprint.
+func generateNameFromFingerprint(linkedPath string) string {
+	binaryPath := strings.TrimPrefix(linkedPath, fingerprintLinkedPathPrefix)
+	if i := strings.Index(binaryPath, ":"); i >= 0 {
+		binaryPath = binaryPath[i+1:]
+	}
+	return osdetail.GenerateBinaryNameFromPath(binaryPath) + " (Changed Binary)"
+}
@@ -0,0 +1,81 @@
+package profile
+
+import (
+	"testing"
+)
+
+func TestFingerprintCompare(t *testing.T) {
+	recorded := &Fingerprint{
+		SHA256:          "aaaa",
+		SigningIdentity: "CN=Example Vendor",
+	}
+	unsigned := &Fingerprint{
+		SHA256: "aaaa",
+	}
+
+	for _, test := range []struct {
+		name     string
+		recorded *Fingerprint
+		binary   *Fingerprint
+		expected FingerprintMatch
+	}{
+		{
+			name:     "no fingerprint",
+			binary:   &Fingerprint{SHA256: "bbbb"},
+			expected: FingerprintUnknown,
+		},
+		{
+			name:     "same binary",
+			recorded: recorded,
+			binary:   &Fingerprint{SHA256: "aaaa", SigningIdentity: "CN=Example Vendor"},
+			expected: FingerprintMatches,
+		},
+		{
+			name:     "updated binary",
+			recorded: recorded,
+			binary:   &Fingerprint{SHA256: "bbbb", SigningIdentity: "CN=Example Vendor"},
+			expected: FingerprintSameSigner,
+		},
+		{
+			name:     "binary of other vendor",
+			recorded: recorded,
+			binary:   &Fingerprint{SHA256: "bbbb", SigningIdentity: "CN=Other Vendor"},
+			expected: FingerprintMismatch,
+		},
+		{
+			name:     "unsigned binary",
+			recorded: recorded,
+			binary:   &Fingerprint{SHA256: "bbbb"},
+			expected: FingerprintMismatch,
+		},
+		{
+			name:     "changed unsigned binary",
+			recorded: unsigned,
+			binary:   &Fingerprint{SHA256: "bbbb"},
+			expected: FingerprintUnsigned,
+		},
+		{
+			name:     "signed binary replacing unsigned binary",
+			recorded: unsigned,
+			binary:   &Fingerprint{SHA256: "bbbb", SigningIdentity: "CN=Example Vendor"},
+			expected: FingerprintMismatch,
+		},
+	} {
+		if match := test.recorded.Compare(test.binary); match != test.expected {
+			t.Errorf("%s: expected %d, got %d", test.name, test.expected, match)
+		}
+	}
+}
+
+func TestFingerprintLinkedPath(t *testing.T) {
+	linkedPath := MakeFingerprintLinkedPath("/usr/bin/curl", "aaaa")
+	if !IsFingerprintLinkedPath(linkedPath) {
+		t.Errorf("expected %q to be a fingerprint linked path", linkedPath)
+	}
+	if IsFingerprintLinkedPath("/usr/bin/curl") {
+		t.Error("expected binary path not to be a fingerprint linked path")
+	}
+	if name := generateNameFromFingerprint(linkedPath); name != "Curl (Changed Binary)" {
+		t.Errorf("unexpected name %q", name)
+	}
+}
@@ -1,6 +1,7 @@
 package profile
 
 import (
+	"context"
 	"errors"
 
 	"github.com/safing/portbase/database"
@@ -44,9 +45,11 @@ func GetProfile(source profileSource, id, linkedPath string) ( //nolint:gocognit
 
 				if profile.outdated.IsSet() {
 					previousVersion = profile
-				} else {
+				} else if profile.layeredProfile != nil || !usesLayeredProfile(profile) {
 					return profile, nil
 				}
+				// Otherwise, the profile was only loaded as a linked profile of
+				// another profile and is reloaded with its own layered profile.
 			}
 			// Get from database.
 			profile, err = getProfile(scopedID)
@@ -74,9 +77,11 @@ func GetProfile(source profileSource, id, linkedPath string) ( //nolint:gocognit
 			if profile != nil {
 				if profile.outdated.IsSet() {
 					previousVersion = profile
-				} else {
+				} else if profile.layeredProfile != nil || !usesLayeredProfile(profile) {
 					return profile, nil
 				}
+				// Otherwise, the profile was only loaded as a linked profile of
+				// another profile and is reloaded with its own layered profile.
 			}
 			// Get from database.
 			profile, err = findProfile(linkedPath)
@@ -92,7 +97,7 @@ func GetProfile(source profileSource, id, linkedPath string) ( //nolint:gocognit
 		// As we don't use any caching, these will be new objects.
 
 		// Add a layeredProfile to local and network profiles.
-		if profile.Source == SourceLocal || profile.Source == SourceNetwork {
+		if usesLayeredProfile(profile) {
 			// If we are refetching, assign the layered profile from the previous version.
 			if previousVersion != nil {
 				profile.layeredProfile = previousVersion.layeredProfile
@@ -120,6 +125,59 @@ func GetProfile(source profileSource, id, linkedPath string) ( //nolint:gocognit
 	return p.(*Profile), nil
 }
 
+// usesLayeredProfile returns whether the profile is used with a layered
+// profile.
+func usesLayeredProfile(profile *Profile) bool {
+	return profile.Source == SourceLocal || profile.Source == SourceNetwork
+}
+
+// getLinkedProfile returns the profile with the given scoped ID for use as a
+// linked layer of another profile. It does not create a layered profile for
+// the linked profile, so that linked profiles may link back without locking up.
+func getLinkedProfile(scopedID string) (*Profile, error) {
+	previous := getActiveProfile(scopedID)
+	if previous != nil && !previous.outdated.IsSet() {
+		previous.MarkStillActive()
+		return previous, nil
+	}
+
+	profile, err := getProfile(scopedID)
+	if err != nil {
+		return nil, err
+	}
+	if previous != nil {
+		profile.layeredProfile = previous.layeredProfile
+	}
+
+	// Add the profile to the active profiles, so that it is marked as outdated
+	// when it changes.
+	addActiveProfile(profile)
+	return profile, nil
+}
+
+// MigrateNetworkHostProfile creates the profile of a network device that was
+// previously identified by its IP only. The new profile links to the profile
+// of the IP, so that its settings continue to apply. Nothing is done if the
+// device already has a profile or the IP has none.
+func MigrateNetworkHostProfile(deviceID, ipID string) error {
+	_, err := profileDB.Get(makeProfileKey(SourceNetwork, deviceID))
+	if !errors.Is(err, database.ErrNotFound) {
+		return err
+	}
+	ipProfile, err := getProfile(makeScopedID(SourceNetwork, ipID))
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	deviceProfile := New(SourceNetwork, deviceID, "", nil)
+	deviceProfile.LinkedProfiles = []string{ipProfile.ScopedID()}
+	log.Infof("profile: linking new device profile %s to %s", deviceProfile.ScopedID(), ipProfile.ScopedID())
+	return deviceProfile.Save()
+}
+
 // getProfile fetches the profile for the given scoped ID.
 func getProfile(scopedID string) (profile *Profile, err error) {
 	// Get profile from the database.
@@ -135,6 +193,20 @@ func getProfile(scopedID string) (profile *Profile, err error) {
 // findProfile searches for a profile with the given linked path. If it cannot
 // find one, it will create a new profile for the given linked path.
 func findProfile(linkedPath string) (profile *Profile, err error) {
+	profile, err = queryProfileByPath(linkedPath)
+	if err != nil || profile != nil {
+		return profile, err
+	}
+
+	// If there was no profile in the database, create a new one, and return it.
+	profile = New(SourceLocal, "", linkedPath, newProfileConfig(linkedPath))
+
+	return profile, nil
+}
+
+// queryProfileByPath searches for a local profile with the given linked path.
+// It returns nil if there is none.
+func queryProfileByPath(linkedPath string) (profile *Profile, err error) {
 	// Search the database for a matching profile.
 	it, err := profileDB.Query(
 		query.New(makeProfileKey(SourceLocal, "")).Where(
@@ -152,14 +224,10 @@ func findProfile(linkedPath string) (profile *Profile, err error) {
 
 	// Prep and return an existing profile.
 	if r != nil {
-		profile, err = prepProfile(r)
-		return profile, err
+		return prepProfile(r)
 	}
 
-	// If there was no profile in the database, create a new one, and return it.
-	profile = New(SourceLocal, "", linkedPath, nil)
-
-	return profile, nil
+	return nil, nil
 }
 
 func prepProfile(r record.Record) (*Profile, error) {
@@ -169,7 +237,13 @@ func prepProfile(r record.Record) (*Profile, error) {
 		return nil, err
 	}
 
-	// prepare config
+	// migrate and prepare config
+	if profile.migrateConfig() {
+		// Persist the migrated config and the applied migrations.
+		module.StartWorker("save migrated profile", func(_ context.Context) error {
+			return profile.Save()
+		})
+	}
 	err = profile.prepConfig()
 	if err != nil {
 		log.Errorf("profiles: profile %s has (partly) invalid configuration: %s", profile.ID, err)
@@ -184,3 +258,27 @@ func prepProfile(r record.Record) (*Profile, error) {
 	// return parsed profile
 	return profile, nil
 }
+
+// GetLocalProfiles returns all local profiles from the database. The returned
+// profiles are not shared with active profiles and must not be modified.
+func GetLocalProfiles() ([]*Profile, error) {
+	it, err := profileDB.Query(query.New(makeProfileKey(SourceLocal, "")))
+	if err != nil {
+		return nil, err
+	}
+
+	var profiles []*Profile
+	for r := range it.Next {
+		profile, err := prepProfile(r)
+		if err != nil {
+			log.Warningf("profiles: failed to load profile %s: %s", r.Key(), err)
+			continue
+		}
+		profiles = append(profiles, profile)
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	return profiles, nil
+}
@@ -0,0 +1,544 @@
+package profile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portmaster/profile/endpoints"
+)
+
+// Import Formats
+const (
+	ImportFormatLittleSnitch = "littlesnitch"
+	ImportFormatOpenSnitch   = "opensnitch"
+	ImportFormatHosts        = "hosts"
+)
+
+// ImportedRules holds imported endpoint rules.
+type ImportedRules struct {
+	Endpoints        []string `json:",omitempty"`
+	ServiceEndpoints []string `json:",omitempty"`
+}
+
+// IsEmpty returns whether there are no rules.
+func (ir *ImportedRules) IsEmpty() bool {
+	return len(ir.Endpoints) == 0 && len(ir.ServiceEndpoints) == 0
+}
+
+// ImportedProfile holds the rules imported for an application.
+type ImportedProfile struct {
+	ImportedRules
+
+	LinkedPath string
+	// ScopedID is the ID of the existing profile the rules are merged into.
+	// It is empty if a new profile will be created.
+	ScopedID string `json:",omitempty"`
+}
+
+// ImportPreview describes the changes of an import. Rules that are already
+// present are left out, as they are not changed by the import.
+type ImportPreview struct {
+	Format   string
+	Global   ImportedRules
+	Profiles []*ImportedProfile
+	// Skipped holds the entries that could not be converted.
+	Skipped []string
+}
+
+// importer collects the rules converted from another format.
+type importer struct {
+	global   ImportedRules
+	profiles map[string]*ImportedProfile
+	order    []string
+	seen     map[string]struct{}
+	skipped  []string
+}
+
+func newImporter() *importer {
+	return &importer{
+		profiles: make(map[string]*ImportedProfile),
+		seen:     make(map[string]struct{}),
+	}
+}
+
+// add adds the rule for the application with the given path, or to the
+// global rules if the path is empty. Invalid rules are skipped.
+func (imp *importer) add(linkedPath string, inbound bool, rule string) {
+	rule = normalizeRule(rule)
+	if _, err := endpoints.ParseEndpoints([]string{rule}); err != nil {
+		imp.skip("%s", err)
+		return
+	}
+
+	dedupKey := fmt.Sprintf("%s|%v|%s", linkedPath, inbound, rule)
+	if _, ok := imp.seen[dedupKey]; ok {
+		return
+	}
+	imp.seen[dedupKey] = struct{}{}
+
+	rules := &imp.global
+	if linkedPath != "" {
+		ip, ok := imp.profiles[linkedPath]
+		if !ok {
+			ip = &ImportedProfile{LinkedPath: linkedPath}
+			imp.profiles[linkedPath] = ip
+			imp.order = append(imp.order, linkedPath)
+		}
+		rules = &ip.ImportedRules
+	}
+
+	if inbound {
+		rules.ServiceEndpoints = append(rules.ServiceEndpoints, rule)
+	} else {
+		rules.Endpoints = append(rules.Endpoints, rule)
+	}
+}
+
+func (imp *importer) skip(format string, a ...interface{}) {
+	imp.skipped = append(imp.skipped, fmt.Sprintf(format, a...))
+}
+
+// PreviewImport converts the data of the given format to profiles and rules
+// and returns the changes that applying the import would make.
+func PreviewImport(format string, data []byte) (*ImportPreview, error) {
+	imp := newImporter()
+	var err error
+	switch format {
+	case ImportFormatLittleSnitch:
+		err = imp.parseLittleSnitch(data)
+	case ImportFormatOpenSnitch:
+		err = imp.parseOpenSnitch(data)
+	case ImportFormatHosts:
+		err = imp.parseHosts(data)
+	default:
+		return nil, fmt.Errorf("unknown import format %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s rules: %w", format, err)
+	}
+
+	preview := &ImportPreview{
+		Format:  format,
+		Skipped: imp.skipped,
+		Global: ImportedRules{
+			Endpoints:        missingRules(imp.global.Endpoints, cfgOptionEndpoints()),
+			ServiceEndpoints: missingRules(imp.global.ServiceEndpoints, cfgOptionServiceEndpoints()),
+		},
+	}
+
+	for _, linkedPath := range imp.order {
+		ip := imp.profiles[linkedPath]
+
+		existing, err := queryProfileByPath(linkedPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search profile of %s: %w", linkedPath, err)
+		}
+		if existing != nil {
+			ip.ScopedID = existing.ScopedID()
+			existing.Lock()
+			stored := config.Flatten(existing.Config)
+			existing.Unlock()
+			existingEndpoints, _ := toStringSlice(stored[CfgOptionEndpointsKey])
+			existingServiceEndpoints, _ := toStringSlice(stored[CfgOptionServiceEndpointsKey])
+			ip.Endpoints = missingRules(ip.Endpoints, existingEndpoints)
+			ip.ServiceEndpoints = missingRules(ip.ServiceEndpoints, existingServiceEndpoints)
+		}
+
+		if !ip.IsEmpty() {
+			preview.Profiles = append(preview.Profiles, ip)
+		}
+	}
+
+	return preview, nil
+}
+
+// ApplyImport converts the data of the given format and merges the rules
+// into the global settings and the profiles of the applications, creating
+// profiles as needed. Imported rules are added to the top of the existing
+// rules. It returns the applied changes.
+func ApplyImport(format string, data []byte) (*ImportPreview, error) {
+	preview, err := PreviewImport(format, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(preview.Global.Endpoints) > 0 {
+		newList := append(append([]string{}, preview.Global.Endpoints...), cfgOptionEndpoints()...)
+		if err := config.SetConfigOption(CfgOptionEndpointsKey, newList); err != nil {
+			return nil, fmt.Errorf("failed to update global rules: %w", err)
+		}
+	}
+	if len(preview.Global.ServiceEndpoints) > 0 {
+		newList := append(append([]string{}, preview.Global.ServiceEndpoints...), cfgOptionServiceEndpoints()...)
+		if err := config.SetConfigOption(CfgOptionServiceEndpointsKey, newList); err != nil {
+			return nil, fmt.Errorf("failed to update global incoming rules: %w", err)
+		}
+	}
+
+	for _, ip := range preview.Profiles {
+		var profile *Profile
+		if ip.ScopedID != "" {
+			profile, err = getProfile(ip.ScopedID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get profile of %s: %w", ip.LinkedPath, err)
+			}
+		} else {
+			profile = New(SourceLocal, "", ip.LinkedPath, nil)
+			profile.Name = filepath.Base(ip.LinkedPath)
+			ip.ScopedID = profile.ScopedID()
+		}
+
+		if err := profile.mergeImportedRules(&ip.ImportedRules); err != nil {
+			return nil, fmt.Errorf("failed to import rules of %s: %w", ip.LinkedPath, err)
+		}
+	}
+
+	return preview, nil
+}
+
+func (profile *Profile) mergeImportedRules(ir *ImportedRules) error {
+	profile.Lock()
+
+	for cfgKey, imported := range map[string][]string{
+		CfgOptionEndpointsKey:        ir.Endpoints,
+		CfgOptionServiceEndpointsKey: ir.ServiceEndpoints,
+	} {
+		if len(imported) == 0 {
+			continue
+		}
+		list, _ := toStringSlice(config.Flatten(profile.Config)[cfgKey])
+		newList := append(append([]string{}, imported...), list...)
+		config.PutValueIntoHierarchicalConfig(profile.Config, cfgKey, newList)
+	}
+
+	// Reload the profile config manually in order to apply the new rules.
+	if err := profile.reloadConfig(); err != nil {
+		profile.Unlock()
+		return err
+	}
+
+	profile.Unlock()
+
+	return profile.Save()
+}
+
+// missingRules returns the rules that are not yet in the existing list.
+func missingRules(rules, existing []string) []string {
+	var missing []string
+nextRule:
+	for _, rule := range rules {
+		for _, entry := range existing {
+			if strings.EqualFold(normalizeRule(entry), rule) {
+				continue nextRule
+			}
+		}
+		missing = append(missing, rule)
+	}
+	return missing
+}
+
+// buildImportedRules builds rules for every combination of the given entities
+// and protocol/port definitions.
+func buildImportedRules(permitted bool, entities, ppps []string) []string {
+	prefix := "-"
+	if permitted {
+		prefix = "+"
+	}
+	if len(entities) == 0 {
+		entities = []string{"*"}
+	}
+	if len(ppps) == 0 {
+		ppps = []string{""}
+	}
+
+	rules := make([]string, 0, len(entities)*len(ppps))
+	for _, entity := range entities {
+		for _, ppp := range ppps {
+			rules = append(rules, strings.TrimSpace(prefix+" "+entity+" "+ppp))
+		}
+	}
+	return rules
+}
+
+// buildImportedPPPs builds the protocol/port definitions of rules from a
+// protocol name and a list of ports or port ranges.
+func buildImportedPPPs(protocol string, ports []string) []string {
+	protocol = strings.ToUpper(strings.TrimSpace(protocol))
+	if protocol == "ANY" {
+		protocol = ""
+	}
+
+	var ppps []string
+	for _, port := range ports {
+		port = strings.TrimSpace(port)
+		if port == "" || strings.EqualFold(port, "any") {
+			continue
+		}
+		if protocol == "" {
+			ppps = append(ppps, "*/"+port)
+		} else {
+			ppps = append(ppps, protocol+"/"+port)
+		}
+	}
+
+	if len(ppps) == 0 && protocol != "" {
+		ppps = append(ppps, protocol)
+	}
+	return ppps
+}
+
+// Little Snitch
+
+type lsRuleGroup struct {
+	Name                  string   `json:"name"`
+	Rules                 []lsRule `json:"rules"`
+	DeniedRemoteDomains   lsList   `json:"denied-remote-domains"`
+	DeniedRemoteHosts     lsList   `json:"denied-remote-hosts"`
+	DeniedRemoteAddresses lsList   `json:"denied-remote-addresses"`
+}
+
+type lsRule struct {
+	Action          string `json:"action"`
+	Process         string `json:"process"`
+	Direction       string `json:"direction"`
+	Disabled        bool   `json:"disabled"`
+	Protocol        string `json:"protocol"`
+	Ports           string `json:"ports"`
+	Remote          string `json:"remote"`
+	RemoteDomains   lsList `json:"remote-domains"`
+	RemoteHosts     lsList `json:"remote-hosts"`
+	RemoteAddresses lsList `json:"remote-addresses"`
+}
+
+// lsList is a list of values that may also be given as a single string.
+// Entries may contain multiple comma separated values.
+type lsList []string
+
+func (l *lsList) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		var single string
+		if err := json.Unmarshal(data, &single); err != nil {
+			return err
+		}
+		list = []string{single}
+	}
+
+	*l = nil
+	for _, entry := range list {
+		for _, value := range strings.Split(entry, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				*l = append(*l, value)
+			}
+		}
+	}
+	return nil
+}
+
+func (imp *importer) parseLittleSnitch(data []byte) error {
+	group := &lsRuleGroup{}
+	if err := json.Unmarshal(data, group); err != nil {
+		return err
+	}
+
+	for i, rule := range group.Rules {
+		if rule.Disabled {
+			imp.skip("rule %d: disabled", i+1)
+			continue
+		}
+
+		var permitted bool
+		switch rule.Action {
+		case "allow":
+			permitted = true
+		case "deny":
+		default:
+			imp.skip("rule %d: unsupported action %q", i+1, rule.Action)
+			continue
+		}
+
+		linkedPath := rule.Process
+		if linkedPath == "any" {
+			linkedPath = ""
+		}
+
+		var entities []string
+		for _, domain := range rule.RemoteDomains {
+			entities = append(entities, "."+strings.TrimPrefix(domain, "."))
+		}
+		entities = append(entities, rule.RemoteHosts...)
+		entities = append(entities, rule.RemoteAddresses...)
+		switch rule.Remote {
+		case "":
+		case "any":
+			entities = append(entities, "*")
+		case "local-net":
+			entities = append(entities, "LAN")
+		default:
+			imp.skip("rule %d: unsupported remote %q", i+1, rule.Remote)
+			continue
+		}
+
+		ppps := buildImportedPPPs(rule.Protocol, strings.Split(rule.Ports, ","))
+		for _, r := range buildImportedRules(permitted, entities, ppps) {
+			imp.add(linkedPath, rule.Direction == "incoming", r)
+		}
+	}
+
+	for _, domain := range group.DeniedRemoteDomains {
+		imp.add("", false, "- ."+strings.TrimPrefix(domain, "."))
+	}
+	for _, host := range group.DeniedRemoteHosts {
+		imp.add("", false, "- "+host)
+	}
+	for _, address := range group.DeniedRemoteAddresses {
+		imp.add("", false, "- "+address)
+	}
+
+	return nil
+}
+
+// OpenSnitch
+
+type osRule struct {
+	Name     string     `json:"name"`
+	Enabled  bool       `json:"enabled"`
+	Action   string     `json:"action"`
+	Operator osOperator `json:"operator"`
+}
+
+type osOperator struct {
+	Type    string       `json:"type"`
+	Operand string       `json:"operand"`
+	Data    string       `json:"data"`
+	List    []osOperator `json:"list"`
+}
+
+func (imp *importer) parseOpenSnitch(data []byte) error {
+	// Accept a single rule, as stored by OpenSnitch, or a list of rules.
+	var rules []osRule
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("[")) {
+		if err := json.Unmarshal(data, &rules); err != nil {
+			return err
+		}
+	} else {
+		rules = make([]osRule, 1)
+		if err := json.Unmarshal(data, &rules[0]); err != nil {
+			return err
+		}
+	}
+
+nextRule:
+	for _, rule := range rules {
+		if !rule.Enabled {
+			imp.skip("rule %s: disabled", rule.Name)
+			continue
+		}
+
+		var permitted bool
+		switch rule.Action {
+		case "allow":
+			permitted = true
+		case "deny", "reject":
+		default:
+			imp.skip("rule %s: unsupported action %q", rule.Name, rule.Action)
+			continue
+		}
+
+		operators := []osOperator{rule.Operator}
+		if rule.Operator.Type == "list" {
+			operators = rule.Operator.List
+		}
+
+		var linkedPath, entity, protocol, port string
+		for _, op := range operators {
+			if op.Type != "simple" && op.Type != "network" {
+				imp.skip("rule %s: unsupported operator type %q", rule.Name, op.Type)
+				continue nextRule
+			}
+
+			var target *string
+			switch op.Operand {
+			case "process.path":
+				target = &linkedPath
+			case "dest.host", "dest.ip", "dest.network":
+				target = &entity
+			case "dest.port":
+				target = &port
+			case "protocol":
+				target = &protocol
+			default:
+				imp.skip("rule %s: unsupported condition %q", rule.Name, op.Operand)
+				continue nextRule
+			}
+			if *target != "" {
+				imp.skip("rule %s: multiple conditions on the destination", rule.Name)
+				continue nextRule
+			}
+			*target = strings.TrimSpace(op.Data)
+		}
+
+		var entities []string
+		if entity != "" {
+			entities = []string{entity}
+		}
+		ppps := buildImportedPPPs(strings.TrimSuffix(protocol, "6"), []string{port})
+		for _, r := range buildImportedRules(permitted, entities, ppps) {
+			imp.add(linkedPath, false, r)
+		}
+	}
+
+	return nil
+}
+
+// Hosts and Domain Lists
+
+var hostsLocalNames = map[string]struct{}{
+	"localhost":             {},
+	"localhost.localdomain": {},
+	"local":                 {},
+	"broadcasthost":         {},
+	"ip6-localhost":         {},
+	"ip6-loopback":          {},
+	"0.0.0.0":               {},
+}
+
+func (imp *importer) parseHosts(data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if idx := strings.IndexByte(text, '#'); idx >= 0 {
+			text = text[:idx]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+
+		// Hosts file entries start with an IP. Only entries that point to
+		// an unreachable address are blocking entries.
+		if ip := net.ParseIP(fields[0]); ip != nil {
+			if !ip.IsUnspecified() && !ip.IsLoopback() {
+				imp.skip("line %d: %s is not a blocking address", line, ip)
+				continue
+			}
+			fields = fields[1:]
+		}
+
+		for _, domain := range fields {
+			if _, ok := hostsLocalNames[strings.ToLower(domain)]; ok {
+				continue
+			}
+			imp.add("", false, "- "+domain)
+		}
+	}
+
+	return scanner.Err()
+}
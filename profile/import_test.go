@@ -0,0 +1,92 @@
+package profile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestImportLittleSnitch(t *testing.T) {
+	imp := newImporter()
+	err := imp.parseLittleSnitch([]byte(`{
+		"name": "Test",
+		"rules": [
+			{"action": "allow", "process": "/usr/bin/curl", "remote-hosts": "example.com", "protocol": "tcp", "ports": "80, 443"},
+			{"action": "deny", "process": "/usr/bin/curl", "remote": "local-net"},
+			{"action": "allow", "process": "/usr/sbin/sshd", "direction": "incoming", "ports": "22"},
+			{"action": "ask", "process": "any", "remote": "any"},
+			{"action": "deny", "process": "any", "remote-addresses": ["10.0.0.0/8", "10.0.0.1-10.0.0.9"]}
+		],
+		"denied-remote-domains": ["tracker.example"]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []string{"+ example.com TCP/80", "+ example.com TCP/443", "- LAN"}; !reflect.DeepEqual(imp.profiles["/usr/bin/curl"].Endpoints, expected) {
+		t.Errorf("unexpected curl rules: %v", imp.profiles["/usr/bin/curl"].Endpoints)
+	}
+	if expected := []string{"+ * */22"}; !reflect.DeepEqual(imp.profiles["/usr/sbin/sshd"].ServiceEndpoints, expected) {
+		t.Errorf("unexpected sshd rules: %v", imp.profiles["/usr/sbin/sshd"].ServiceEndpoints)
+	}
+	if expected := []string{"- 10.0.0.0/8", "- .tracker.example"}; !reflect.DeepEqual(imp.global.Endpoints, expected) {
+		t.Errorf("unexpected global rules: %v", imp.global.Endpoints)
+	}
+	if len(imp.skipped) != 2 {
+		t.Errorf("expected the ask rule and the address range to be skipped, got %v", imp.skipped)
+	}
+}
+
+func TestImportOpenSnitch(t *testing.T) {
+	imp := newImporter()
+	err := imp.parseOpenSnitch([]byte(`[
+		{"name": "allow-curl", "enabled": true, "action": "allow", "operator": {"type": "list", "operand": "list", "list": [
+			{"type": "simple", "operand": "process.path", "data": "/usr/bin/curl"},
+			{"type": "simple", "operand": "dest.host", "data": "example.com"},
+			{"type": "simple", "operand": "dest.port", "data": "443"},
+			{"type": "simple", "operand": "protocol", "data": "tcp6"}
+		]}},
+		{"name": "deny-net", "enabled": true, "action": "reject", "operator": {"type": "network", "operand": "dest.network", "data": "192.0.2.0/24"}},
+		{"name": "regexp", "enabled": true, "action": "deny", "operator": {"type": "regexp", "operand": "dest.host", "data": ".*"}},
+		{"name": "disabled", "enabled": false, "action": "deny", "operator": {"type": "simple", "operand": "dest.host", "data": "example.org"}}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []string{"+ example.com TCP/443"}; !reflect.DeepEqual(imp.profiles["/usr/bin/curl"].Endpoints, expected) {
+		t.Errorf("unexpected curl rules: %v", imp.profiles["/usr/bin/curl"].Endpoints)
+	}
+	if expected := []string{"- 192.0.2.0/24"}; !reflect.DeepEqual(imp.global.Endpoints, expected) {
+		t.Errorf("unexpected global rules: %v", imp.global.Endpoints)
+	}
+	if len(imp.skipped) != 2 {
+		t.Errorf("expected the regexp and disabled rules to be skipped, got %v", imp.skipped)
+	}
+}
+
+func TestImportHosts(t *testing.T) {
+	imp := newImporter()
+	err := imp.parseHosts([]byte(`# blocklist
+127.0.0.1 localhost
+0.0.0.0 ads.example.com tracker.example.com # trackers
+192.168.1.1 router.lan
+plain.example.com
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []string{"- ads.example.com", "- tracker.example.com", "- plain.example.com"}; !reflect.DeepEqual(imp.global.Endpoints, expected) {
+		t.Errorf("unexpected rules: %v", imp.global.Endpoints)
+	}
+	if len(imp.skipped) != 1 {
+		t.Errorf("expected the router entry to be skipped, got %v", imp.skipped)
+	}
+}
+
+func TestMissingRules(t *testing.T) {
+	missing := missingRules([]string{"- example.com", "+ LAN"}, []string{"-  Example.com"})
+	if !reflect.DeepEqual(missing, []string{"+ LAN"}) {
+		t.Errorf("unexpected missing rules: %v", missing)
+	}
+}
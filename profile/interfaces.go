@@ -0,0 +1,113 @@
+package profile
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// interfaceMatcher matches a local interface by name, with an optional
+// trailing wildcard, or by the IP or network of the local address.
+type interfaceMatcher struct {
+	name     string
+	wildcard bool
+	ipNet    *net.IPNet
+}
+
+func parseInterfaceMatchers(entries []string) ([]*interfaceMatcher, error) {
+	matchers := make([]*interfaceMatcher, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "" || entry == "*":
+			return nil, fmt.Errorf("invalid interface %q", entry)
+		case strings.Contains(entry, "/"):
+			_, ipNet, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid network %q: %w", entry, err)
+			}
+			matchers = append(matchers, &interfaceMatcher{ipNet: ipNet})
+		case net.ParseIP(entry) != nil:
+			ip := net.ParseIP(entry)
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+				bits = 8 * net.IPv4len
+			}
+			matchers = append(matchers, &interfaceMatcher{
+				ipNet: &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)},
+			})
+		case strings.HasSuffix(entry, "*"):
+			matchers = append(matchers, &interfaceMatcher{
+				name:     strings.TrimSuffix(entry, "*"),
+				wildcard: true,
+			})
+		default:
+			matchers = append(matchers, &interfaceMatcher{name: entry})
+		}
+	}
+	return matchers, nil
+}
+
+func (m *interfaceMatcher) matches(ifaceName string, localIP net.IP) bool {
+	switch {
+	case m.ipNet != nil:
+		return m.ipNet.Contains(localIP)
+	case ifaceName == "":
+		return false
+	case m.wildcard:
+		return strings.HasPrefix(ifaceName, m.name)
+	default:
+		return ifaceName == m.name
+	}
+}
+
+// InterfaceAllowed returns whether a connection using the given local
+// interface and IP is allowed, and whether allowed interfaces are configured
+// at all. The interface name may be empty if it is unknown. This function
+// requires the layered profile to be read locked.
+func (lp *LayeredProfile) InterfaceAllowed(ifaceName string, localIP net.IP) (allowed, restricted bool) {
+	var matchers []*interfaceMatcher
+	var layerSet bool
+	for _, layer := range lp.layers {
+		// Use the first layer that has allowed interfaces set.
+		if layer.allowedInterfacesSet {
+			matchers = layer.allowedInterfaces
+			layerSet = true
+			break
+		}
+	}
+	if !layerSet {
+		cfgLock.RLock()
+		matchers = cfgAllowedInterfaces
+		cfgLock.RUnlock()
+	}
+	if len(matchers) == 0 {
+		return true, false
+	}
+
+	for _, m := range matchers {
+		if m.matches(ifaceName, localIP) {
+			return true, true
+		}
+	}
+	return false, true
+}
+
+// ReevaluateInterfaceBindings marks active profiles that restrict the allowed
+// interfaces as outdated, so that their connections are re-evaluated. It must
+// be called when the local interfaces changed.
+func ReevaluateInterfaceBindings() {
+	cfgLock.RLock()
+	globallyRestricted := len(cfgAllowedInterfaces) > 0
+	cfgLock.RUnlock()
+
+	activeProfilesLock.RLock()
+	defer activeProfilesLock.RUnlock()
+
+	for _, profile := range activeProfiles {
+		if globallyRestricted || profile.allowedInterfacesSet {
+			profile.outdated.Set()
+		}
+	}
+}
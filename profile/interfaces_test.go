@@ -0,0 +1,44 @@
+package profile
+
+import (
+	"net"
+	"testing"
+)
+
+func TestInterfaceMatchers(t *testing.T) {
+	matchers, err := parseInterfaceMatchers([]string{"tun0", "wg*", "10.8.0.0/24", "fd00::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		iface   string
+		ip      string
+		matches bool
+	}{
+		{"tun0", "192.168.1.2", true},
+		{"tun1", "192.168.1.2", false},
+		{"wg-vpn", "192.168.1.2", true},
+		{"eth0", "10.8.0.5", true},
+		{"", "10.8.1.5", false},
+		{"eth0", "fd00::1", true},
+		{"eth0", "fd00::2", false},
+	} {
+		var matched bool
+		for _, m := range matchers {
+			if m.matches(test.iface, net.ParseIP(test.ip)) {
+				matched = true
+				break
+			}
+		}
+		if matched != test.matches {
+			t.Errorf("expected %s/%s to match=%v", test.iface, test.ip, test.matches)
+		}
+	}
+
+	for _, invalid := range []string{"*", "", "10.8.0.0/33"} {
+		if _, err := parseInterfaceMatchers([]string{invalid}); err == nil {
+			t.Errorf("expected %q to be invalid", invalid)
+		}
+	}
+}
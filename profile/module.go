@@ -1,10 +1,12 @@
 package profile
 
 import (
+	"context"
 	"os"
 
 	"github.com/safing/portbase/log"
 	"github.com/safing/portbase/modules"
+	"github.com/safing/portmaster/netenv"
 	"github.com/safing/portmaster/updates"
 
 	// module dependencies
@@ -55,6 +57,61 @@ func start() error {
 		return err
 	}
 
+	err = loadAllowlists()
+	if err != nil {
+		log.Warningf("profile: failed to load allowlists: %s", err)
+	}
+
+	err = loadDestinationNotes()
+	if err != nil {
+		log.Warningf("profile: failed to load destination notes: %s", err)
+	}
+
+	err = loadSchedules()
+	if err != nil {
+		log.Warningf("profile: failed to load schedules: %s", err)
+	}
+	module.NewTask("check profile schedules", checkSchedules).Repeat(scheduleCheckInterval)
+
+	for _, event := range []string{netenv.NetworkCategoryChangedEvent, netenv.NetworkIdentityChangedEvent} {
+		err = module.RegisterEventHook(
+			"netenv",
+			event,
+			"check profile network overrides",
+			checkActiveNetworkOverrides,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = loadTemplates()
+	if err != nil {
+		log.Warningf("profile: failed to load templates: %s", err)
+	}
+	err = module.RegisterEventHook(
+		updates.ModuleName,
+		updates.ResourceUpdateEvent,
+		"Check for profile template updates",
+		func(_ context.Context, _ interface{}) error {
+			loadShippedTemplates(true)
+			return nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	err = loadEphemeralSession()
+	if err != nil {
+		log.Warningf("profile: failed to check for ephemeral session: %s", err)
+	}
+
+	err = registerAPIEndpoints()
+	if err != nil {
+		return err
+	}
+
 	module.StartServiceWorker("clean active profiles", 0, cleanActiveProfiles)
 
 	err = updateGlobalConfigProfile(module.Ctx, nil)
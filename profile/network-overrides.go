@@ -0,0 +1,173 @@
+package profile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/utils"
+	"github.com/safing/portmaster/netenv"
+)
+
+// Network overrides activate alternative settings of a profile while the
+// device is connected to certain networks, for example stricter rules on
+// public Wi-Fi than at home. Networks are matched by their category, which
+// describes how much the network is trusted, by their SSID or by their
+// network identity, as determined by netenv.
+
+// NetworkOverride defines alternative settings that are active while
+// connected to matching networks. All given criteria must match. Criteria that
+// are empty match any network.
+type NetworkOverride struct {
+	// ID is a unique identifier of the override within the profile.
+	ID string
+	// Name is a human readable name of the override.
+	Name string
+	// Categories holds the network categories in which the override applies,
+	// eg. "public".
+	Categories []netenv.NetworkCategory `json:",omitempty"`
+	// SSIDs holds the names of the wireless networks in which the override
+	// applies.
+	SSIDs []string `json:",omitempty"`
+	// NetworkIDs holds the IDs of the network identities in which the
+	// override applies.
+	NetworkIDs []string `json:",omitempty"`
+	// Config holds the settings that are applied while the override is
+	// active, in the flat (key=value) form. They take precedence over the
+	// settings of the profile.
+	Config map[string]interface{}
+}
+
+// currentNetwork describes the network the device is connected to.
+type currentNetwork struct {
+	category netenv.NetworkCategory
+	ssid     string
+	id       string
+}
+
+func getCurrentNetwork() *currentNetwork {
+	identity := netenv.GetNetworkIdentity()
+	return &currentNetwork{
+		category: netenv.GetNetworkCategory(),
+		ssid:     identity.SSID,
+		id:       identity.ID,
+	}
+}
+
+// check validates the network override.
+func (no *NetworkOverride) check() error {
+	if no.ID == "" {
+		return errors.New("network override needs an ID")
+	}
+	if len(no.Categories) == 0 && len(no.SSIDs) == 0 && len(no.NetworkIDs) == 0 {
+		return errors.New("network override does not match any network")
+	}
+	for _, category := range no.Categories {
+		switch category {
+		case netenv.NetworkCategoryPublic, netenv.NetworkCategoryPrivate, netenv.NetworkCategoryDomain:
+		default:
+			return fmt.Errorf("invalid network category %q", category)
+		}
+	}
+	if len(no.Config) == 0 {
+		return errors.New("network override has no settings")
+	}
+	for key := range no.Config {
+		if !isProfileOption(key) {
+			return fmt.Errorf("%s is not a setting of app profiles", key)
+		}
+	}
+	return nil
+}
+
+// matches returns whether the override applies in the given network.
+func (no *NetworkOverride) matches(network *currentNetwork) bool {
+	if len(no.Categories) > 0 {
+		var ok bool
+		for _, category := range no.Categories {
+			if category == network.category {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	if len(no.SSIDs) > 0 && (network.ssid == "" || !utils.StringInSlice(no.SSIDs, network.ssid)) {
+		return false
+	}
+	if len(no.NetworkIDs) > 0 && (network.id == "" || !utils.StringInSlice(no.NetworkIDs, network.id)) {
+		return false
+	}
+	return true
+}
+
+// checkNetworkOverrides checks that the network overrides are valid.
+func (profile *Profile) checkNetworkOverrides() error {
+	seen := make(map[string]struct{}, len(profile.NetworkOverrides))
+	for _, no := range profile.NetworkOverrides {
+		if err := no.check(); err != nil {
+			return fmt.Errorf("invalid network override %q: %w", no.ID, err)
+		}
+		if _, ok := seen[no.ID]; ok {
+			return fmt.Errorf("duplicate network override ID %q", no.ID)
+		}
+		seen[no.ID] = struct{}{}
+	}
+	return nil
+}
+
+// activeNetworkOverride returns the first network override of the profile that
+// applies in the given network, if any.
+func (profile *Profile) activeNetworkOverride(network *currentNetwork) *NetworkOverride {
+	for _, no := range profile.NetworkOverrides {
+		if no.matches(network) {
+			return no
+		}
+	}
+	return nil
+}
+
+// applyNetworkOverride returns the given config with the settings of the
+// active network override applied and the ID of that override.
+func (profile *Profile) applyNetworkOverride(cfg map[string]interface{}) (map[string]interface{}, string) {
+	if len(profile.NetworkOverrides) == 0 {
+		return cfg, ""
+	}
+	no := profile.activeNetworkOverride(getCurrentNetwork())
+	if no == nil {
+		return cfg, ""
+	}
+
+	flat := config.Flatten(cfg)
+	for key, value := range no.Config {
+		flat[key] = value
+	}
+	return config.Expand(flat), no.ID
+}
+
+// checkActiveNetworkOverrides marks active profiles as outdated when their
+// active network override changed, so that they are reloaded with the new
+// settings.
+func checkActiveNetworkOverrides(_ context.Context, _ interface{}) error {
+	network := getCurrentNetwork()
+	for _, profile := range getAllActiveProfiles() {
+		profile.Lock()
+		var overrideID string
+		if no := profile.activeNetworkOverride(network); no != nil {
+			overrideID = no.ID
+		}
+		changed := profile.activeNetworkOverrideID != overrideID
+		profile.Unlock()
+
+		if changed {
+			log.Infof("profile: switching network override of profile %s to %q", profile.ScopedID(), overrideID)
+			profile.outdated.Set()
+		}
+	}
+	return nil
+}
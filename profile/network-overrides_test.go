@@ -0,0 +1,94 @@
+package profile
+
+import (
+	"testing"
+
+	"github.com/safing/portmaster/netenv"
+)
+
+func TestNetworkOverrideMatches(t *testing.T) {
+	public := &NetworkOverride{
+		ID:         "public",
+		Categories: []netenv.NetworkCategory{netenv.NetworkCategoryPublic},
+	}
+	home := &NetworkOverride{
+		ID:    "home",
+		SSIDs: []string{"Home"},
+	}
+	office := &NetworkOverride{
+		ID:         "office",
+		Categories: []netenv.NetworkCategory{netenv.NetworkCategoryDomain},
+		NetworkIDs: []string{"0123456789abcdef"},
+	}
+	profile := &Profile{
+		NetworkOverrides: []*NetworkOverride{office, home, public},
+	}
+
+	for _, test := range []struct {
+		name     string
+		network  *currentNetwork
+		expected *NetworkOverride
+	}{
+		{
+			name:    "unknown network",
+			network: &currentNetwork{},
+		},
+		{
+			name:     "public network",
+			network:  &currentNetwork{category: netenv.NetworkCategoryPublic, ssid: "Cafe"},
+			expected: public,
+		},
+		{
+			name:     "home network",
+			network:  &currentNetwork{category: netenv.NetworkCategoryPrivate, ssid: "Home"},
+			expected: home,
+		},
+		{
+			name:     "first matching override",
+			network:  &currentNetwork{category: netenv.NetworkCategoryPublic, ssid: "Home"},
+			expected: home,
+		},
+		{
+			name:    "all criteria must match",
+			network: &currentNetwork{category: netenv.NetworkCategoryDomain, id: "fedcba9876543210"},
+		},
+		{
+			name:     "office network",
+			network:  &currentNetwork{category: netenv.NetworkCategoryDomain, id: "0123456789abcdef"},
+			expected: office,
+		},
+	} {
+		if no := profile.activeNetworkOverride(test.network); no != test.expected {
+			t.Errorf("%s: unexpected network override %+v", test.name, no)
+		}
+	}
+}
+
+func TestCheckNetworkOverrides(t *testing.T) {
+	for _, no := range []*NetworkOverride{
+		{
+			Config: map[string]interface{}{"filter/defaultAction": "block"},
+			SSIDs:  []string{"Home"},
+		},
+		{
+			ID:     "no-criteria",
+			Config: map[string]interface{}{"filter/defaultAction": "block"},
+		},
+		{
+			ID:         "invalid-category",
+			Categories: []netenv.NetworkCategory{"unknown"},
+			Config:     map[string]interface{}{"filter/defaultAction": "block"},
+		},
+		{
+			ID:    "no-settings",
+			SSIDs: []string{"Home"},
+		},
+	} {
+		profile := &Profile{
+			NetworkOverrides: []*NetworkOverride{no},
+		}
+		if err := profile.checkNetworkOverrides(); err == nil {
+			t.Errorf("expected network override %q to be invalid", no.ID)
+		}
+	}
+}
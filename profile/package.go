@@ -0,0 +1,33 @@
+package profile
+
+import "strings"
+
+// packageLinkedPathPrefix is used for linked paths of store apps on Windows,
+// which are identified by their package family name instead of their binary.
+const packageLinkedPathPrefix = "package:"
+
+// MakePackageLinkedPath returns the linked path for the given package family
+// name.
+func MakePackageLinkedPath(packageFamilyName string) string {
+	return packageLinkedPathPrefix + packageFamilyName
+}
+
+// IsPackageLinkedPath returns whether the given linked path refers to a
+// package instead of a binary.
+func IsPackageLinkedPath(linkedPath string) bool {
+	return strings.HasPrefix(linkedPath, packageLinkedPathPrefix)
+}
+
+// generateNameFromPackage returns a profile name from the linked path of a
+// package. Package family names consist of the package name and a publisher
+// hash, eg. "Microsoft.WindowsCalculator_8wekyb3d8bbwe".
+func generateNameFromPackage(linkedPath string) string {
+	name := strings.TrimPrefix(linkedPath, packageLinkedPathPrefix)
+	if i := strings.LastIndex(name, "_"); i > 0 {
+		name = name[:i]
+	}
+	if i := strings.LastIndex(name, "."); i >= 0 && i < len(name)-1 {
+		name = name[i+1:]
+	}
+	return name
+}
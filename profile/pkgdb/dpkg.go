@@ -0,0 +1,115 @@
+package pkgdb
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// findDpkgPackage returns the name of the package that lists the given path
+// in the given dpkg info directory.
+func findDpkgPackage(infoDir, binaryPath string) (string, error) {
+	lists, err := filepath.Glob(filepath.Join(infoDir, "*.list"))
+	if err != nil {
+		return "", err
+	}
+
+	for _, list := range lists {
+		found, err := listContains(list, binaryPath)
+		if err != nil {
+			continue
+		}
+		if found {
+			// Multi-arch packages are listed as "name:arch.list".
+			name := strings.TrimSuffix(filepath.Base(list), ".list")
+			return strings.SplitN(name, ":", 2)[0], nil
+		}
+	}
+	return "", ErrNotFound
+}
+
+func listContains(listPath, binaryPath string) (bool, error) {
+	file, err := os.Open(listPath)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if scanner.Text() == binaryPath {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// parseDpkgStatus returns the metadata of the given package from a dpkg
+// status database.
+func parseDpkgStatus(r io.Reader, name string) (*Info, error) {
+	var (
+		info    *Info
+		current string
+	)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// An empty line ends the paragraph of a package.
+		if line == "" {
+			if info != nil {
+				return info, nil
+			}
+			current = ""
+			continue
+		}
+		// Continuation lines belong to the previous field.
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			continue
+		}
+
+		field := strings.SplitN(line, ":", 2)
+		if len(field) != 2 {
+			continue
+		}
+		key, value := field[0], strings.TrimSpace(field[1])
+		if key == "Package" {
+			current = value
+			if current == name {
+				info = &Info{
+					Manager: ManagerDpkg,
+					Name:    name,
+				}
+			}
+			continue
+		}
+		if info == nil || current != name {
+			continue
+		}
+
+		switch key {
+		case "Version":
+			info.Version = value
+		case "Maintainer":
+			info.Vendor = value
+		case "Description":
+			info.Description = value
+		case "Homepage":
+			info.Homepage = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if info == nil {
+		return nil, ErrNotFound
+	}
+	return info, nil
+}
@@ -0,0 +1,39 @@
+// Package pkgdb looks up the installed package a binary belongs to in the
+// package databases of the system.
+package pkgdb
+
+import "errors"
+
+// Package databases.
+const (
+	ManagerDpkg    = "dpkg"
+	ManagerRPM     = "rpm"
+	ManagerScoop   = "scoop"
+	ManagerWindows = "windows" // Installed programs, including winget.
+)
+
+var (
+	// ErrNotFound is returned if the binary does not belong to a package.
+	ErrNotFound = errors.New("binary does not belong to a package")
+	// ErrNotSupported is returned if there are no supported package
+	// databases on this system.
+	ErrNotSupported = errors.New("package databases not supported")
+)
+
+// Info holds the metadata of an installed package.
+type Info struct {
+	// Manager is the package database the package was found in.
+	Manager string
+	// Name is the name of the package.
+	Name    string
+	Version string `json:",omitempty"`
+	// Vendor holds the vendor or maintainer of the package.
+	Vendor      string `json:",omitempty"`
+	Description string `json:",omitempty"`
+	Homepage    string `json:",omitempty"`
+}
+
+// Lookup returns the package the binary at the given path belongs to.
+func Lookup(binaryPath string) (*Info, error) {
+	return lookup(binaryPath)
+}
@@ -0,0 +1,7 @@
+// +build !linux,!windows
+
+package pkgdb
+
+func lookup(binaryPath string) (*Info, error) {
+	return nil, ErrNotSupported
+}
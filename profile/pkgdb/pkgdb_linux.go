@@ -0,0 +1,86 @@
+package pkgdb
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	dpkgInfoDir    = "/var/lib/dpkg/info"
+	dpkgStatusFile = "/var/lib/dpkg/status"
+
+	rpmQueryFormat = "%{NAME}\n%{VERSION}-%{RELEASE}\n%{VENDOR}\n%{SUMMARY}\n%{URL}\n"
+)
+
+func lookup(binaryPath string) (*Info, error) {
+	supported := false
+
+	// Debian based distributions.
+	if _, err := os.Stat(dpkgStatusFile); err == nil {
+		supported = true
+		info, err := lookupDpkg(binaryPath)
+		if !errors.Is(err, ErrNotFound) {
+			return info, err
+		}
+	}
+
+	// RPM based distributions.
+	if rpmPath, err := exec.LookPath("rpm"); err == nil {
+		supported = true
+		info, err := lookupRPM(rpmPath, binaryPath)
+		if !errors.Is(err, ErrNotFound) {
+			return info, err
+		}
+	}
+
+	if !supported {
+		return nil, ErrNotSupported
+	}
+	return nil, ErrNotFound
+}
+
+func lookupDpkg(binaryPath string) (*Info, error) {
+	name, err := findDpkgPackage(dpkgInfoDir, binaryPath)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := os.Open(dpkgStatusFile)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = status.Close()
+	}()
+	return parseDpkgStatus(status, name)
+}
+
+func lookupRPM(rpmPath, binaryPath string) (*Info, error) {
+	output, err := exec.Command(rpmPath, "--query", "--file", "--queryformat", rpmQueryFormat, binaryPath).Output()
+	if err != nil {
+		// rpm fails if the file is not owned by a package.
+		return nil, ErrNotFound
+	}
+
+	fields := strings.Split(string(output), "\n")
+	if len(fields) < 5 || fields[0] == "" {
+		return nil, ErrNotFound
+	}
+	info := &Info{
+		Manager:     ManagerRPM,
+		Name:        fields[0],
+		Version:     fields[1],
+		Vendor:      fields[2],
+		Description: fields[3],
+		Homepage:    fields[4],
+	}
+	// rpm reports missing values as "(none)".
+	for _, value := range []*string{&info.Vendor, &info.Description, &info.Homepage} {
+		if *value == "(none)" {
+			*value = ""
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,83 @@
+package pkgdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testDpkgStatus = `Package: curl
+Status: install ok installed
+Maintainer: Ubuntu Developers <ubuntu-devel-discuss@lists.ubuntu.com>
+Version: 7.68.0-1ubuntu2
+Description: command line tool for transferring data with URL syntax
+ curl is a command line tool for transferring data with URL syntax,
+ supporting DICT, FILE, FTP, FTPS, GOPHER, HTTP, HTTPS.
+Homepage: http://curl.haxx.se
+
+Package: firefox
+Status: install ok installed
+Maintainer: Mozilla
+Version: 89.0
+Description: Safe and easy web browser from Mozilla
+`
+
+func TestParseDpkgStatus(t *testing.T) {
+	t.Parallel()
+
+	info, err := parseDpkgStatus(strings.NewReader(testDpkgStatus), "curl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Info{
+		Manager:     ManagerDpkg,
+		Name:        "curl",
+		Version:     "7.68.0-1ubuntu2",
+		Vendor:      "Ubuntu Developers <ubuntu-devel-discuss@lists.ubuntu.com>",
+		Description: "command line tool for transferring data with URL syntax",
+		Homepage:    "http://curl.haxx.se",
+	}
+	if *info != expected {
+		t.Errorf("unexpected info: %+v", info)
+	}
+
+	info, err = parseDpkgStatus(strings.NewReader(testDpkgStatus), "firefox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != "89.0" || info.Homepage != "" {
+		t.Errorf("unexpected info: %+v", info)
+	}
+
+	if _, err := parseDpkgStatus(strings.NewReader(testDpkgStatus), "wget"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestFindDpkgPackage(t *testing.T) {
+	t.Parallel()
+
+	infoDir, err := ioutil.TempDir("", "pkgdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(infoDir)
+	}()
+
+	err = ioutil.WriteFile(filepath.Join(infoDir, "curl:amd64.list"), []byte("/.\n/usr\n/usr/bin\n/usr/bin/curl\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name, err := findDpkgPackage(infoDir, "/usr/bin/curl")
+	if err != nil || name != "curl" {
+		t.Errorf("expected curl, got %q, %v", name, err)
+	}
+	if _, err := findDpkgPackage(infoDir, "/usr/bin/wget"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package pkgdb
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// uninstallKey holds the installed programs, including the ones installed
+// with winget.
+const uninstallKey = `Software\Microsoft\Windows\CurrentVersion\Uninstall`
+
+type uninstallRoot struct {
+	root   registry.Key
+	access uint32
+}
+
+var uninstallRoots = []uninstallRoot{
+	{root: registry.LOCAL_MACHINE, access: registry.WOW64_64KEY},
+	{root: registry.LOCAL_MACHINE, access: registry.WOW64_32KEY},
+	{root: registry.CURRENT_USER},
+}
+
+func lookup(binaryPath string) (*Info, error) {
+	info, err := lookupScoop(binaryPath)
+	if !errors.Is(err, ErrNotFound) {
+		return info, err
+	}
+	return lookupInstalledPrograms(binaryPath)
+}
+
+// lookupScoop returns the scoop app of binaries within the scoop apps
+// directory, eg. "C:\Users\user\scoop\apps\<name>\<version>\app.exe".
+func lookupScoop(binaryPath string) (*Info, error) {
+	parts := strings.Split(filepath.Clean(binaryPath), string(filepath.Separator))
+	for i := 0; i+3 < len(parts); i++ {
+		if !strings.EqualFold(parts[i], "scoop") || !strings.EqualFold(parts[i+1], "apps") {
+			continue
+		}
+
+		appDir := strings.Join(parts[:i+4], string(filepath.Separator))
+		data, err := ioutil.ReadFile(filepath.Join(appDir, "manifest.json"))
+		if err != nil {
+			return nil, ErrNotFound
+		}
+		var manifest struct {
+			Version     string `json:"version"`
+			Description string `json:"description"`
+			Homepage    string `json:"homepage"`
+		}
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return nil, err
+		}
+		return &Info{
+			Manager:     ManagerScoop,
+			Name:        parts[i+2],
+			Version:     manifest.Version,
+			Description: manifest.Description,
+			Homepage:    manifest.Homepage,
+		}, nil
+	}
+	return nil, ErrNotFound
+}
+
+// lookupInstalledPrograms returns the installed program whose install
+// location contains the binary.
+func lookupInstalledPrograms(binaryPath string) (*Info, error) {
+	binaryPath = strings.ToLower(filepath.Clean(binaryPath))
+
+	for _, root := range uninstallRoots {
+		key, err := registry.OpenKey(root.root, uninstallKey, registry.ENUMERATE_SUB_KEYS|registry.QUERY_VALUE|root.access)
+		if err != nil {
+			continue
+		}
+		names, err := key.ReadSubKeyNames(-1)
+		_ = key.Close()
+		if err != nil {
+			continue
+		}
+
+		for _, name := range names {
+			if info := matchInstalledProgram(root, name, binaryPath); info != nil {
+				return info, nil
+			}
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func matchInstalledProgram(root uninstallRoot, name, binaryPath string) *Info {
+	key, err := registry.OpenKey(root.root, uninstallKey+`\`+name, registry.QUERY_VALUE|root.access)
+	if err != nil {
+		return nil
+	}
+	defer func() {
+		_ = key.Close()
+	}()
+
+	location, _, err := key.GetStringValue("InstallLocation")
+	if err != nil || strings.TrimSpace(location) == "" {
+		return nil
+	}
+	location = strings.ToLower(filepath.Clean(strings.Trim(location, `"`)))
+	if !strings.HasPrefix(binaryPath, location+string(filepath.Separator)) {
+		return nil
+	}
+
+	info := &Info{
+		Manager: ManagerWindows,
+		Name:    name,
+	}
+	if displayName, _, err := key.GetStringValue("DisplayName"); err == nil && displayName != "" {
+		info.Name = displayName
+	}
+	info.Version, _, _ = key.GetStringValue("DisplayVersion")
+	info.Vendor, _, _ = key.GetStringValue("Publisher")
+	info.Homepage, _, _ = key.GetStringValue("URLInfoAbout")
+	return info
+}
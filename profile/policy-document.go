@@ -0,0 +1,376 @@
+package profile
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/database/query"
+)
+
+// Policy Document Formats
+const (
+	PolicyFormatMarkdown = "markdown"
+	PolicyFormatHTML     = "html"
+)
+
+// Provenance of settings in the policy document.
+const (
+	provenanceDefault = "Default"
+	provenanceGlobal  = "Global Settings"
+	provenanceApp     = "App"
+)
+
+// policyWriter renders the building blocks of the policy document.
+type policyWriter interface {
+	heading(level int, text string)
+	paragraph(text string)
+	list(items []string)
+	table(header []string, rows [][]string)
+	bytes() []byte
+}
+
+// RenderPolicyDocument renders the effective policy, ie. the global settings
+// and the settings and rules of all profiles, as a human readable document
+// for audits and documentation. Every setting and rule states which layer it
+// comes from.
+func RenderPolicyDocument(format string) ([]byte, error) {
+	var w policyWriter
+	switch format {
+	case PolicyFormatMarkdown, "":
+		w = &markdownPolicyWriter{}
+	case PolicyFormatHTML:
+		w = newHTMLPolicyWriter()
+	default:
+		return nil, fmt.Errorf("unknown policy document format %q", format)
+	}
+
+	profiles, err := getAllProfiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get profiles: %w", err)
+	}
+
+	w.heading(1, "Portmaster Policy")
+	w.paragraph(fmt.Sprintf(
+		"Generated on %s. Settings of apps override the global settings. Rules are evaluated from top to bottom and the first matching rule applies.",
+		time.Now().Format(time.RFC1123),
+	))
+
+	keys := policyOptionKeys()
+
+	w.heading(2, "Global Settings")
+	rows := make([][]string, 0, len(keys))
+	for _, key := range keys {
+		value, provenance := globalPolicyValue(key)
+		rows = append(rows, []string{policyOptionName(key), value, provenance})
+	}
+	w.table([]string{"Setting", "Value", "Source"}, rows)
+
+	w.heading(3, "Outgoing Rules")
+	writePolicyRules(w, nil, CfgOptionEndpointsKey)
+	w.heading(3, "Incoming Rules")
+	writePolicyRules(w, nil, CfgOptionServiceEndpointsKey)
+
+	w.heading(2, "Apps")
+	if len(profiles) == 0 {
+		w.paragraph("There are no app profiles.")
+	}
+	for _, profile := range profiles {
+		writeProfilePolicy(w, profile, keys)
+	}
+
+	return w.bytes(), nil
+}
+
+func getAllProfiles() ([]*Profile, error) {
+	it, err := profileDB.Query(query.New(profilesDBPath))
+	if err != nil {
+		return nil, err
+	}
+
+	var profiles []*Profile
+	for r := range it.Next {
+		profile, err := prepProfile(r)
+		if err != nil {
+			continue
+		}
+		profiles = append(profiles, profile)
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(profiles, func(i, j int) bool {
+		return strings.ToLower(profiles[i].Name) < strings.ToLower(profiles[j].Name)
+	})
+	return profiles, nil
+}
+
+func writeProfilePolicy(w policyWriter, profile *Profile, keys []string) {
+	profile.RLock()
+	defer profile.RUnlock()
+
+	w.heading(3, profile.Name)
+	if profile.configPerspective == nil {
+		w.paragraph("The settings of this app are invalid.")
+		return
+	}
+	details := []string{"Profile: " + profile.ScopedID()}
+	if profile.LinkedPath != "" {
+		details = append(details, "Path: "+profile.LinkedPath)
+	}
+	if len(profile.Tags) > 0 {
+		details = append(details, "Tags: "+strings.Join(profile.Tags, ", "))
+	}
+	if profile.Description != "" {
+		details = append(details, "Description: "+profile.Description)
+	}
+	w.list(details)
+
+	var rows [][]string
+	for _, key := range keys {
+		if value, ok := profilePolicyValue(profile, key); ok {
+			rows = append(rows, []string{policyOptionName(key), value, provenanceApp})
+		}
+	}
+	if len(rows) == 0 {
+		w.paragraph("This app uses the global settings.")
+	} else {
+		w.table([]string{"Setting", "Value", "Source"}, rows)
+	}
+
+	w.heading(4, "Outgoing Rules")
+	writePolicyRules(w, profile, CfgOptionEndpointsKey)
+	w.heading(4, "Incoming Rules")
+	writePolicyRules(w, profile, CfgOptionServiceEndpointsKey)
+}
+
+// writePolicyRules writes the effective rules of the given key. The rules of
+// the profile, if given, are followed by the global rules. The caller must
+// hold the profile lock.
+func writePolicyRules(w policyWriter, profile *Profile, key string) {
+	var rows [][]string
+	if profile != nil {
+		if rules, ok := profile.configPerspective.GetAsStringArray(key); ok {
+			for _, rule := range rules {
+				rows = append(rows, []string{rule, provenanceApp})
+			}
+		}
+	}
+	for _, rule := range cfgStringArrayOptions[key]() {
+		rows = append(rows, []string{rule, provenanceGlobal})
+	}
+
+	if len(rows) == 0 {
+		w.paragraph("No rules. The default action applies.")
+		return
+	}
+	w.table([]string{"Rule", "Source"}, rows)
+}
+
+// policyOptionKeys returns the keys of all settings that can be set per app,
+// except the rules, which are listed separately.
+func policyOptionKeys() []string {
+	keys := make([]string, 0, len(cfgStringOptions)+len(cfgStringArrayOptions)+len(cfgIntOptions)+len(cfgBoolOptions))
+	for key := range cfgStringOptions {
+		keys = append(keys, key)
+	}
+	for key := range cfgStringArrayOptions {
+		if key != CfgOptionEndpointsKey && key != CfgOptionServiceEndpointsKey {
+			keys = append(keys, key)
+		}
+	}
+	for key := range cfgIntOptions {
+		keys = append(keys, key)
+	}
+	for key := range cfgBoolOptions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func policyOptionName(key string) string {
+	option, err := config.GetOption(key)
+	if err != nil {
+		return key
+	}
+	return option.Name
+}
+
+// globalPolicyValue returns the formatted global value of the setting and
+// whether it is the default value.
+func globalPolicyValue(key string) (value, provenance string) {
+	var raw interface{}
+	switch {
+	case cfgStringOptions[key] != nil:
+		raw = cfgStringOptions[key]()
+	case cfgStringArrayOptions[key] != nil:
+		raw = cfgStringArrayOptions[key]()
+	case cfgIntOptions[key] != nil:
+		raw = cfgIntOptions[key]()
+	case cfgBoolOptions[key] != nil:
+		raw = cfgBoolOptions[key]()
+	}
+
+	provenance = provenanceGlobal
+	if option, err := config.GetOption(key); err == nil && isDefaultValue(option, raw) {
+		provenance = provenanceDefault
+	}
+	return formatPolicyValue(key, raw), provenance
+}
+
+// profilePolicyValue returns the formatted value of the setting, if it is set
+// in the profile. The caller must hold the profile lock.
+func profilePolicyValue(profile *Profile, key string) (value string, ok bool) {
+	var raw interface{}
+	switch {
+	case cfgStringOptions[key] != nil:
+		raw, ok = profile.configPerspective.GetAsString(key)
+	case cfgStringArrayOptions[key] != nil:
+		raw, ok = profile.configPerspective.GetAsStringArray(key)
+	case cfgIntOptions[key] != nil:
+		raw, ok = profile.configPerspective.GetAsInt(key)
+	case cfgBoolOptions[key] != nil:
+		raw, ok = profile.configPerspective.GetAsBool(key)
+	}
+	if !ok {
+		return "", false
+	}
+	return formatPolicyValue(key, raw), true
+}
+
+func isDefaultValue(option *config.Option, value interface{}) bool {
+	// Default values are registered with various types, eg. int or uint8 for
+	// int options, so compare their formatting.
+	return fmt.Sprint(option.DefaultValue) == fmt.Sprint(value)
+}
+
+// formatPolicyValue formats the value, using the names of the possible values
+// if available.
+func formatPolicyValue(key string, value interface{}) string {
+	option, err := config.GetOption(key)
+	if err == nil {
+		for _, possible := range option.PossibleValues {
+			if fmt.Sprint(possible.Value) == fmt.Sprint(value) {
+				return possible.Name
+			}
+		}
+	}
+
+	switch v := value.(type) {
+	case []string:
+		if len(v) == 0 {
+			return "none"
+		}
+		return strings.Join(v, ", ")
+	case bool:
+		if v {
+			return "enabled"
+		}
+		return "disabled"
+	case string:
+		if v == "" {
+			return "not set"
+		}
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+type markdownPolicyWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *markdownPolicyWriter) heading(level int, text string) {
+	fmt.Fprintf(&w.buf, "%s %s\n\n", strings.Repeat("#", level), text)
+}
+
+func (w *markdownPolicyWriter) paragraph(text string) {
+	w.buf.WriteString(text + "\n\n")
+}
+
+func (w *markdownPolicyWriter) list(items []string) {
+	for _, item := range items {
+		w.buf.WriteString("- " + item + "\n")
+	}
+	w.buf.WriteString("\n")
+}
+
+func (w *markdownPolicyWriter) table(header []string, rows [][]string) {
+	w.tableRow(header)
+	separators := make([]string, len(header))
+	for i := range separators {
+		separators[i] = "---"
+	}
+	w.tableRow(separators)
+	for _, row := range rows {
+		w.tableRow(row)
+	}
+	w.buf.WriteString("\n")
+}
+
+func (w *markdownPolicyWriter) tableRow(cells []string) {
+	escaped := make([]string, len(cells))
+	for i, cell := range cells {
+		escaped[i] = strings.ReplaceAll(cell, "|", `\|`)
+	}
+	w.buf.WriteString("| " + strings.Join(escaped, " | ") + " |\n")
+}
+
+func (w *markdownPolicyWriter) bytes() []byte {
+	return w.buf.Bytes()
+}
+
+type htmlPolicyWriter struct {
+	buf bytes.Buffer
+}
+
+func newHTMLPolicyWriter() *htmlPolicyWriter {
+	w := &htmlPolicyWriter{}
+	w.buf.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>Portmaster Policy</title>\n")
+	w.buf.WriteString("<style>body{font-family:sans-serif}table{border-collapse:collapse;margin-bottom:1em}th,td{border:1px solid #ccc;padding:4px 8px;text-align:left}</style>\n")
+	w.buf.WriteString("</head>\n<body>\n")
+	return w
+}
+
+func (w *htmlPolicyWriter) heading(level int, text string) {
+	fmt.Fprintf(&w.buf, "<h%d>%s</h%d>\n", level, html.EscapeString(text), level)
+}
+
+func (w *htmlPolicyWriter) paragraph(text string) {
+	w.buf.WriteString("<p>" + html.EscapeString(text) + "</p>\n")
+}
+
+func (w *htmlPolicyWriter) list(items []string) {
+	w.buf.WriteString("<ul>\n")
+	for _, item := range items {
+		w.buf.WriteString("<li>" + html.EscapeString(item) + "</li>\n")
+	}
+	w.buf.WriteString("</ul>\n")
+}
+
+func (w *htmlPolicyWriter) table(header []string, rows [][]string) {
+	w.buf.WriteString("<table>\n<tr>")
+	for _, cell := range header {
+		w.buf.WriteString("<th>" + html.EscapeString(cell) + "</th>")
+	}
+	w.buf.WriteString("</tr>\n")
+	for _, row := range rows {
+		w.buf.WriteString("<tr>")
+		for _, cell := range row {
+			w.buf.WriteString("<td>" + html.EscapeString(cell) + "</td>")
+		}
+		w.buf.WriteString("</tr>\n")
+	}
+	w.buf.WriteString("</table>\n")
+}
+
+func (w *htmlPolicyWriter) bytes() []byte {
+	return append(w.buf.Bytes(), "</body>\n</html>\n"...)
+}
@@ -0,0 +1,26 @@
+package profile
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPolicyWriters(t *testing.T) {
+	md := &markdownPolicyWriter{}
+	md.heading(2, "Rules")
+	md.table([]string{"Rule", "Source"}, [][]string{{"+ a|b.com", provenanceApp}})
+	expected := "## Rules\n\n| Rule | Source |\n| --- | --- |\n| + a\\|b.com | App |\n\n"
+	if got := string(md.bytes()); got != expected {
+		t.Errorf("unexpected markdown:\n%s", got)
+	}
+
+	h := newHTMLPolicyWriter()
+	h.list([]string{"<script>"})
+	got := string(h.bytes())
+	if !strings.Contains(got, "<li>&lt;script&gt;</li>") {
+		t.Errorf("html is not escaped:\n%s", got)
+	}
+	if !strings.HasSuffix(got, "</html>\n") {
+		t.Errorf("html is not closed:\n%s", got)
+	}
+}
@@ -0,0 +1,269 @@
+package profile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/network/packet"
+)
+
+// Port learning records the destination ports a profile uses during a
+// learning window. Afterwards, the learned set is frozen and connections to
+// other ports need to be confirmed by the user. The learned ports are stored
+// separately from the profile, as they are updated while the profile is in
+// use for a decision.
+
+const learnedPortsDBPath = "core:learned-ports/"
+
+// PortLearningResult is the result of checking a port against the learned
+// ports of a profile.
+type PortLearningResult uint8
+
+// Port Learning Results
+const (
+	PortLearningDisabled PortLearningResult = iota
+	PortKnown
+	PortLearned
+	PortDenied
+	PortUnknown
+)
+
+// LearnedPorts holds the destination ports learned for a profile.
+type LearnedPorts struct {
+	record.Base
+	sync.Mutex
+
+	// Started holds the UTC timestamp in seconds when learning started.
+	Started int64
+	// Ports maps learned or allowed ports, formatted as "<protocol>/<port>",
+	// to the UTC timestamp in seconds when they were added.
+	Ports map[string]int64
+	// Denied maps ports that were denied by the user to the UTC timestamp in
+	// seconds of the decision.
+	Denied map[string]int64
+}
+
+var (
+	learnedPorts     = make(map[string]*LearnedPorts) // key: scoped profile ID
+	learnedPortsLock sync.Mutex
+)
+
+// LearnPort checks the given destination port against the learned ports of
+// the local profile. During the learning window, new ports are added to the
+// learned set. This function requires the layered profile to be read locked.
+func (lp *LayeredProfile) LearnPort(protocol uint8, port uint16) PortLearningResult {
+	days := lp.PortLearning()
+	if days <= 0 {
+		return PortLearningDisabled
+	}
+
+	ports := getLearnedPorts(lp.localProfile.ScopedID())
+	key := PortKey(packet.IPProtocol(protocol), port)
+
+	ports.Lock()
+	defer ports.Unlock()
+
+	switch {
+	case ports.Denied[key] != 0:
+		return PortDenied
+	case ports.Ports[key] != 0:
+		return PortKnown
+	case time.Now().Before(time.Unix(ports.Started, 0).Add(time.Duration(days) * 24 * time.Hour)):
+		ports.Ports[key] = time.Now().Unix()
+		ports.saveAsync()
+		return PortLearned
+	default:
+		return PortUnknown
+	}
+}
+
+// GetLearnedPorts returns a copy of the learned ports of the profile with the
+// given scoped ID. It does not start learning.
+func GetLearnedPorts(scopedID string) *LearnedPorts {
+	learnedPortsLock.Lock()
+	ports, ok := learnedPorts[scopedID]
+	learnedPortsLock.Unlock()
+	if !ok {
+		var err error
+		ports, err = loadLearnedPorts(scopedID)
+		if err != nil {
+			return &LearnedPorts{
+				Ports:  make(map[string]int64),
+				Denied: make(map[string]int64),
+			}
+		}
+	}
+
+	ports.Lock()
+	defer ports.Unlock()
+
+	cp := &LearnedPorts{
+		Started: ports.Started,
+		Ports:   make(map[string]int64, len(ports.Ports)),
+		Denied:  make(map[string]int64, len(ports.Denied)),
+	}
+	for key, added := range ports.Ports {
+		cp.Ports[key] = added
+	}
+	for key, decided := range ports.Denied {
+		cp.Denied[key] = decided
+	}
+	return cp
+}
+
+// SetLearnedPort allows or denies the given port, formatted as
+// "<protocol>/<port>", for the profile with the given scoped ID.
+func SetLearnedPort(scopedID, portKey string, allow bool) error {
+	key, err := parsePortKey(portKey)
+	if err != nil {
+		return err
+	}
+
+	ports := getLearnedPorts(scopedID)
+	ports.Lock()
+	defer ports.Unlock()
+
+	if allow {
+		delete(ports.Denied, key)
+		ports.Ports[key] = time.Now().Unix()
+	} else {
+		delete(ports.Ports, key)
+		ports.Denied[key] = time.Now().Unix()
+	}
+	ports.saveAsync()
+	return nil
+}
+
+// ForgetLearnedPort removes the given port, formatted as "<protocol>/<port>",
+// from the learned and denied ports of the profile with the given scoped ID.
+func ForgetLearnedPort(scopedID, portKey string) error {
+	key, err := parsePortKey(portKey)
+	if err != nil {
+		return err
+	}
+
+	ports := getLearnedPorts(scopedID)
+	ports.Lock()
+	defer ports.Unlock()
+
+	delete(ports.Ports, key)
+	delete(ports.Denied, key)
+	ports.saveAsync()
+	return nil
+}
+
+// RestartPortLearning clears the learned ports of the profile with the given
+// scoped ID and starts a new learning window.
+func RestartPortLearning(scopedID string) {
+	ports := getLearnedPorts(scopedID)
+	ports.Lock()
+	defer ports.Unlock()
+
+	ports.Started = time.Now().Unix()
+	ports.Ports = make(map[string]int64)
+	ports.Denied = make(map[string]int64)
+	ports.saveAsync()
+}
+
+// getLearnedPorts returns the learned ports of the profile with the given
+// scoped ID. If none exist yet, learning starts now.
+func getLearnedPorts(scopedID string) *LearnedPorts {
+	learnedPortsLock.Lock()
+	defer learnedPortsLock.Unlock()
+
+	ports, ok := learnedPorts[scopedID]
+	if ok {
+		return ports
+	}
+
+	ports, err := loadLearnedPorts(scopedID)
+	if err != nil {
+		ports = &LearnedPorts{
+			Started: time.Now().Unix(),
+			Ports:   make(map[string]int64),
+			Denied:  make(map[string]int64),
+		}
+		ports.SetKey(learnedPortsDBPath + scopedID)
+		ports.saveAsync()
+	}
+	learnedPorts[scopedID] = ports
+	return ports
+}
+
+func loadLearnedPorts(scopedID string) (*LearnedPorts, error) {
+	r, err := profileDB.Get(learnedPortsDBPath + scopedID)
+	if err != nil {
+		return nil, err
+	}
+
+	ports := &LearnedPorts{}
+	if r.IsWrapped() {
+		err = record.Unwrap(r, ports)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var ok bool
+		ports, ok = r.(*LearnedPorts)
+		if !ok {
+			return nil, fmt.Errorf("invalid type, expected LearnedPorts but got %T", r)
+		}
+	}
+
+	if ports.Ports == nil {
+		ports.Ports = make(map[string]int64)
+	}
+	if ports.Denied == nil {
+		ports.Denied = make(map[string]int64)
+	}
+	return ports, nil
+}
+
+// saveAsync saves the learned ports in a worker, as saving locks the record.
+// The learned ports must be locked.
+func (ports *LearnedPorts) saveAsync() {
+	module.StartWorker("save learned ports", func(_ context.Context) error {
+		if err := profileDB.Put(ports); err != nil {
+			log.Warningf("profile: failed to save learned ports %s: %s", ports.Key(), err)
+		}
+		return nil
+	})
+}
+
+// PortKey returns the key of the given port, as used for learned ports.
+func PortKey(protocol packet.IPProtocol, port uint16) string {
+	return protocol.String() + "/" + strconv.FormatUint(uint64(port), 10)
+}
+
+// parsePortKey parses and normalizes a port formatted as "<protocol>/<port>".
+func parsePortKey(portKey string) (string, error) {
+	splitted := strings.SplitN(portKey, "/", 2)
+	if len(splitted) != 2 {
+		return "", errors.New(`port must be formatted as "<protocol>/<port>"`)
+	}
+
+	var protocol packet.IPProtocol
+	switch strings.ToUpper(splitted[0]) {
+	case "TCP":
+		protocol = packet.TCP
+	case "UDP":
+		protocol = packet.UDP
+	case "UDPLITE":
+		protocol = packet.UDPLite
+	default:
+		return "", fmt.Errorf("unsupported protocol %q", splitted[0])
+	}
+
+	port, err := strconv.ParseUint(splitted[1], 10, 16)
+	if err != nil || port == 0 {
+		return "", fmt.Errorf("invalid port %q", splitted[1])
+	}
+	return PortKey(protocol, uint16(port)), nil
+}
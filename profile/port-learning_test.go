@@ -0,0 +1,24 @@
+package profile
+
+import "testing"
+
+func TestParsePortKey(t *testing.T) {
+	for portKey, expected := range map[string]string{
+		"TCP/443":     "TCP/443",
+		"udp/53":      "UDP/53",
+		" TCP/443":    "",
+		"UDPLite/123": "UDPLite/123",
+		"ICMP/1":      "",
+		"TCP/0":       "",
+		"TCP/65536":   "",
+		"TCP":         "",
+	} {
+		key, err := parsePortKey(portKey)
+		switch {
+		case expected == "" && err == nil:
+			t.Errorf("expected %q to be invalid, got %q", portKey, key)
+		case expected != "" && key != expected:
+			t.Errorf("expected %q to be parsed as %q, got %q (%v)", portKey, expected, key, err)
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package profile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/safing/portbase/config"
+)
+
+// Portable Formats
+const (
+	PortableFormatJSON = "json"
+	PortableFormatYAML = "yaml"
+)
+
+// Import Strategies
+const (
+	// ImportStrategyMerge adds the imported rules on top of the existing rules
+	// and only applies imported settings that are not set yet.
+	ImportStrategyMerge = "merge"
+	// ImportStrategyOverwrite replaces the settings, rules and metadata of
+	// existing profiles with the imported ones.
+	ImportStrategyOverwrite = "overwrite"
+)
+
+// ErrSyncDisabled is returned when a synchronized profile is applied to a
+// profile that is excluded from sync.
+var ErrSyncDisabled = errors.New("profile is excluded from sync")
+
+// portableDocumentVersion is the version of the portable document format.
+const portableDocumentVersion = 1
+
+// PortableDocument holds exported profiles in a form that can be imported on
+// other machines. Profiles are matched by the path of their executable, as
+// profile IDs are random and differ between machines.
+type PortableDocument struct {
+	Version  int                `json:"version" yaml:"version"`
+	Exported int64              `json:"exported" yaml:"exported"`
+	Profiles []*PortableProfile `json:"profiles" yaml:"profiles"`
+}
+
+// PortableProfile holds the metadata, settings and rules of an exported
+// profile.
+type PortableProfile struct {
+	Name        string   `json:"name" yaml:"name"`
+	Description string   `json:"description,omitempty" yaml:"description,omitempty"`
+	Homepage    string   `json:"homepage,omitempty" yaml:"homepage,omitempty"`
+	LinkedPath  string   `json:"linkedPath" yaml:"linkedPath"`
+	Tags        []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Notes       string   `json:"notes,omitempty" yaml:"notes,omitempty"`
+	// Settings holds the settings of the profile, including the endpoint
+	// rules, in the flat (key=value) form.
+	Settings map[string]interface{} `json:"settings,omitempty" yaml:"settings,omitempty"`
+}
+
+// ProfileImportResult describes the changes of a profile import.
+type ProfileImportResult struct {
+	Strategy string
+	// Created holds the linked paths of the created profiles.
+	Created []string `json:",omitempty"`
+	// Updated holds the linked paths of the changed existing profiles.
+	Updated []string `json:",omitempty"`
+	// Skipped holds the profiles that could not be imported.
+	Skipped []string `json:",omitempty"`
+}
+
+// ExportProfiles exports the local profile with the given scoped ID, or all
+// local profiles if it is empty, to a portable document in the given format.
+func ExportProfiles(scopedID, format string) ([]byte, error) {
+	var profiles []*Profile
+	if scopedID != "" {
+		profile, err := getProfile(scopedID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get profile: %w", err)
+		}
+		if profile.Source != SourceLocal {
+			return nil, fmt.Errorf("profile %s is not a local profile", scopedID)
+		}
+		profiles = append(profiles, profile)
+	} else {
+		var err error
+		profiles, err = GetLocalProfiles()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get profiles: %w", err)
+		}
+	}
+
+	doc := &PortableDocument{
+		Version:  portableDocumentVersion,
+		Exported: time.Now().Unix(),
+	}
+	for _, profile := range profiles {
+		if !profile.isPortable() {
+			continue
+		}
+		doc.Profiles = append(doc.Profiles, profile.toPortable())
+	}
+	sort.Slice(doc.Profiles, func(i, j int) bool {
+		return doc.Profiles[i].LinkedPath < doc.Profiles[j].LinkedPath
+	})
+
+	return encodePortableDocument(doc, format)
+}
+
+// SyncableProfiles returns the local profiles that are synchronized with
+// peers, in the portable form.
+func SyncableProfiles() ([]*PortableProfile, error) {
+	profiles, err := GetLocalProfiles()
+	if err != nil {
+		return nil, err
+	}
+
+	portable := make([]*PortableProfile, 0, len(profiles))
+	for _, profile := range profiles {
+		if profile.isPortable() && !profile.DisableSync {
+			portable = append(portable, profile.toPortable())
+		}
+	}
+	return portable, nil
+}
+
+// ImportSyncedProfile applies a profile received from a peer, replacing the
+// settings, rules and metadata of the local profile. Profiles that are
+// excluded from sync are not changed and ErrSyncDisabled is returned.
+func ImportSyncedProfile(pp *PortableProfile) error {
+	if pp.LinkedPath == "" {
+		return errors.New("missing linked path")
+	}
+	settings, err := normalizePortableSettings(pp.Settings)
+	if err != nil {
+		return err
+	}
+
+	existing, err := queryProfileByPath(pp.LinkedPath)
+	switch {
+	case err != nil:
+		return fmt.Errorf("failed to search profile: %w", err)
+	case existing == nil:
+		return createPortableProfile(pp, settings)
+	case existing.DisableSync:
+		return ErrSyncDisabled
+	default:
+		return existing.applyPortable(pp, settings, ImportStrategyOverwrite)
+	}
+}
+
+// isPortable returns whether the profile can be exported. Profiles of internal
+// processes are not tied to a machine-independent path and are recreated
+// automatically.
+func (profile *Profile) isPortable() bool {
+	return !profile.Internal && profile.LinkedPath != ""
+}
+
+func (profile *Profile) toPortable() *PortableProfile {
+	profile.RLock()
+	defer profile.RUnlock()
+
+	return &PortableProfile{
+		Name:        profile.Name,
+		Description: profile.Description,
+		Homepage:    profile.Homepage,
+		LinkedPath:  profile.LinkedPath,
+		Tags:        append([]string(nil), profile.Tags...),
+		Notes:       profile.Notes,
+		Settings:    config.Flatten(profile.Config),
+	}
+}
+
+func encodePortableDocument(doc *PortableDocument, format string) ([]byte, error) {
+	switch format {
+	case PortableFormatJSON, "":
+		return json.MarshalIndent(doc, "", "  ")
+	case PortableFormatYAML:
+		return yaml.Marshal(doc)
+	default:
+		return nil, fmt.Errorf("unknown profile format %q", format)
+	}
+}
+
+func decodePortableDocument(data []byte, format string) (*PortableDocument, error) {
+	doc := &PortableDocument{}
+	var err error
+	switch format {
+	case PortableFormatJSON, "":
+		err = json.Unmarshal(data, doc)
+	case PortableFormatYAML:
+		err = yaml.Unmarshal(data, doc)
+	default:
+		return nil, fmt.Errorf("unknown profile format %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s document: %w", format, err)
+	}
+
+	if doc.Version > portableDocumentVersion {
+		return nil, fmt.Errorf("unsupported document version %d", doc.Version)
+	}
+	return doc, nil
+}
+
+// ImportProfiles imports the profiles of a portable document in the given
+// format with the given strategy. Imported profiles are matched to existing
+// local profiles by their linked path. Missing profiles are created.
+func ImportProfiles(format, strategy string, data []byte) (*ProfileImportResult, error) {
+	switch strategy {
+	case ImportStrategyMerge, ImportStrategyOverwrite:
+	case "":
+		strategy = ImportStrategyMerge
+	default:
+		return nil, fmt.Errorf("unknown import strategy %q", strategy)
+	}
+
+	doc, err := decodePortableDocument(data, format)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ProfileImportResult{Strategy: strategy}
+	for _, pp := range doc.Profiles {
+		if pp.LinkedPath == "" {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("%s: missing linked path", pp.Name))
+			continue
+		}
+		settings, err := normalizePortableSettings(pp.Settings)
+		if err != nil {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("%s: %s", pp.LinkedPath, err))
+			continue
+		}
+
+		existing, err := queryProfileByPath(pp.LinkedPath)
+		if err != nil {
+			return result, fmt.Errorf("failed to search profile of %s: %w", pp.LinkedPath, err)
+		}
+
+		if existing == nil {
+			if err := createPortableProfile(pp, settings); err != nil {
+				return result, fmt.Errorf("failed to save profile of %s: %w", pp.LinkedPath, err)
+			}
+			result.Created = append(result.Created, pp.LinkedPath)
+			continue
+		}
+
+		if err := existing.applyPortable(pp, settings, strategy); err != nil {
+			return result, fmt.Errorf("failed to import profile of %s: %w", pp.LinkedPath, err)
+		}
+		result.Updated = append(result.Updated, pp.LinkedPath)
+	}
+
+	return result, nil
+}
+
+// normalizePortableSettings converts the decoded settings to the types used
+// by the config system and validates them.
+func normalizePortableSettings(settings map[string]interface{}) (map[string]interface{}, error) {
+	normalized := make(map[string]interface{}, len(settings))
+	for key, value := range settings {
+		option, err := config.GetOption(key)
+		if err != nil {
+			return nil, fmt.Errorf("unknown setting %s", key)
+		}
+
+		if option.OptType == config.OptTypeStringArray {
+			list, ok := toStringSlice(value)
+			if !ok {
+				return nil, fmt.Errorf("setting %s is not a list of strings", key)
+			}
+			value = list
+		}
+		normalized[key] = value
+	}
+
+	if _, err := config.NewPerspective(normalized); err != nil {
+		return nil, fmt.Errorf("invalid settings: %w", err)
+	}
+	return normalized, nil
+}
+
+func toStringSlice(value interface{}) ([]string, bool) {
+	switch v := value.(type) {
+	case []string:
+		return v, true
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, entry := range v {
+			s, ok := entry.(string)
+			if !ok {
+				return nil, false
+			}
+			list = append(list, s)
+		}
+		return list, true
+	default:
+		return nil, false
+	}
+}
+
+// mergePortableSettings merges the imported settings into the existing
+// settings, both in the flat form. Imported list entries, such as rules, are
+// added on top of the existing entries, while other imported settings are only
+// applied if they are not set yet.
+func mergePortableSettings(existing, imported map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(existing)+len(imported))
+	for key, value := range existing {
+		merged[key] = value
+	}
+
+	for key, value := range imported {
+		current, ok := merged[key]
+		if !ok {
+			merged[key] = value
+			continue
+		}
+
+		importedList, ok := toStringSlice(value)
+		if !ok {
+			continue
+		}
+		currentList, ok := toStringSlice(current)
+		if !ok {
+			continue
+		}
+		missing := missingRules(normalizeRules(importedList), currentList)
+		if len(missing) > 0 {
+			merged[key] = append(missing, currentList...)
+		}
+	}
+
+	return merged
+}
+
+func normalizeRules(rules []string) []string {
+	normalized := make([]string, len(rules))
+	for i, rule := range rules {
+		normalized[i] = normalizeRule(rule)
+	}
+	return normalized
+}
+
+func (pp *PortableProfile) applyMetadata(profile *Profile, strategy string) {
+	set := func(field *string, value string) {
+		if value != "" && (strategy == ImportStrategyOverwrite || *field == "") {
+			*field = value
+		}
+	}
+	set(&profile.Name, pp.Name)
+	set(&profile.Description, pp.Description)
+	set(&profile.Homepage, pp.Homepage)
+	set(&profile.Notes, pp.Notes)
+
+	if strategy == ImportStrategyOverwrite {
+		profile.Tags = pp.Tags
+	} else {
+		profile.Tags = append(profile.Tags, missingTags(pp.Tags, profile.Tags)...)
+	}
+
+	if profile.Name == "" {
+		profile.Name = filepath.Base(profile.LinkedPath)
+	}
+}
+
+func missingTags(tags, existing []string) []string {
+	var missing []string
+nextTag:
+	for _, tag := range tags {
+		for _, entry := range existing {
+			if strings.EqualFold(entry, tag) {
+				continue nextTag
+			}
+		}
+		missing = append(missing, tag)
+	}
+	return missing
+}
+
+func createPortableProfile(pp *PortableProfile, settings map[string]interface{}) error {
+	profile := New(SourceLocal, "", pp.LinkedPath, settings)
+	pp.applyMetadata(profile, ImportStrategyOverwrite)
+	return profile.Save()
+}
+
+func (profile *Profile) applyPortable(pp *PortableProfile, settings map[string]interface{}, strategy string) error {
+	profile.Lock()
+
+	pp.applyMetadata(profile, strategy)
+	if strategy == ImportStrategyOverwrite {
+		profile.Config = config.Expand(settings)
+	} else {
+		profile.Config = config.Expand(mergePortableSettings(config.Flatten(profile.Config), settings))
+	}
+	profile.LastEdited = time.Now().Unix()
+
+	// Reload the profile config manually in order to apply the new settings.
+	if err := profile.reloadConfig(); err != nil {
+		profile.Unlock()
+		return err
+	}
+
+	profile.Unlock()
+
+	return profile.Save()
+}
@@ -0,0 +1,69 @@
+package profile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPortableDocument(t *testing.T) {
+	doc := &PortableDocument{
+		Version: portableDocumentVersion,
+		Profiles: []*PortableProfile{{
+			Name:       "curl",
+			LinkedPath: "/usr/bin/curl",
+			Tags:       []string{"tools"},
+			Settings: map[string]interface{}{
+				"filter/endpoints": []string{"+ example.com"},
+			},
+		}},
+	}
+
+	for _, format := range []string{PortableFormatJSON, PortableFormatYAML} {
+		data, err := encodePortableDocument(doc, format)
+		if err != nil {
+			t.Fatalf("failed to encode %s: %s", format, err)
+		}
+		decoded, err := decodePortableDocument(data, format)
+		if err != nil {
+			t.Fatalf("failed to decode %s: %s", format, err)
+		}
+		if len(decoded.Profiles) != 1 {
+			t.Fatalf("expected 1 profile in %s, got %d", format, len(decoded.Profiles))
+		}
+		pp := decoded.Profiles[0]
+		if pp.LinkedPath != "/usr/bin/curl" || !reflect.DeepEqual(pp.Tags, []string{"tools"}) {
+			t.Errorf("unexpected profile in %s: %+v", format, pp)
+		}
+		if rules, ok := toStringSlice(pp.Settings["filter/endpoints"]); !ok || !reflect.DeepEqual(rules, []string{"+ example.com"}) {
+			t.Errorf("unexpected rules in %s: %v", format, pp.Settings["filter/endpoints"])
+		}
+	}
+
+	if _, err := decodePortableDocument([]byte(`{"version": 99}`), PortableFormatJSON); err == nil {
+		t.Error("expected newer document versions to be rejected")
+	}
+}
+
+func TestMergePortableSettings(t *testing.T) {
+	merged := mergePortableSettings(
+		map[string]interface{}{
+			"filter/endpoints":     []string{"- example.org", "+ example.com"},
+			"filter/defaultAction": "block",
+		},
+		map[string]interface{}{
+			"filter/endpoints":     []interface{}{"+  example.com", "+ example.net"},
+			"filter/defaultAction": "permit",
+			"filter/blockLAN":      float64(7),
+		},
+	)
+
+	if expected := []string{"+ example.net", "- example.org", "+ example.com"}; !reflect.DeepEqual(merged["filter/endpoints"], expected) {
+		t.Errorf("unexpected merged rules: %v", merged["filter/endpoints"])
+	}
+	if merged["filter/defaultAction"] != "block" {
+		t.Errorf("existing settings must not be changed by a merge, got %v", merged["filter/defaultAction"])
+	}
+	if merged["filter/blockLAN"] != float64(7) {
+		t.Errorf("missing settings must be added by a merge, got %v", merged["filter/blockLAN"])
+	}
+}
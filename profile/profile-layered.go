@@ -16,6 +16,9 @@
 	"github.com/safing/portmaster/profile/endpoints"
 )
 
+// maxLinkedProfileDepth limits how deeply linked profiles may be nested.
+const maxLinkedProfileDepth = 5
+
 // LayeredProfile combines multiple Profiles.
 type LayeredProfile struct {
 	record.Base
@@ -37,23 +40,45 @@ type LayeredProfile struct {
 	// via the API. If we ever switch away from JSON to something else supported
 	// by DSD this WILL BREAK!
 
-	DisableAutoPermit   config.BoolOption `json:"-"`
-	BlockScopeLocal     config.BoolOption `json:"-"`
-	BlockScopeLAN       config.BoolOption `json:"-"`
-	BlockScopeInternet  config.BoolOption `json:"-"`
-	BlockP2P            config.BoolOption `json:"-"`
-	BlockInbound        config.BoolOption `json:"-"`
-	RemoveOutOfScopeDNS config.BoolOption `json:"-"`
-	RemoveBlockedDNS    config.BoolOption `json:"-"`
-	FilterSubDomains    config.BoolOption `json:"-"`
-	FilterCNAMEs        config.BoolOption `json:"-"`
-	PreventBypassing    config.BoolOption `json:"-"`
-	DomainHeuristics    config.BoolOption `json:"-"`
-	UseSPN              config.BoolOption `json:"-"`
+	DisableAutoPermit   config.BoolOption   `json:"-"`
+	BlockScopeLocal     config.BoolOption   `json:"-"`
+	BlockScopeLAN       config.BoolOption   `json:"-"`
+	BlockScopeInternet  config.BoolOption   `json:"-"`
+	BlockScopeOverlay   config.BoolOption   `json:"-"`
+	BlockP2P            config.BoolOption   `json:"-"`
+	BlockInbound        config.BoolOption   `json:"-"`
+	BlockProxies        config.BoolOption   `json:"-"`
+	RemoveOutOfScopeDNS config.BoolOption   `json:"-"`
+	RemoveBlockedDNS    config.BoolOption   `json:"-"`
+	FilterSubDomains    config.BoolOption   `json:"-"`
+	FilterCNAMEs        config.BoolOption   `json:"-"`
+	PreventBypassing    config.BoolOption   `json:"-"`
+	DomainHeuristics    config.BoolOption   `json:"-"`
+	BlockTelemetry      config.BoolOption   `json:"-"`
+	BlockBackground     config.BoolOption   `json:"-"`
+	UseSPN              config.BoolOption   `json:"-"`
+	PortLearning        config.IntOption    `json:"-"`
+	SilencePrompts      config.BoolOption   `json:"-"`
+	BlockNotifications  config.IntOption    `json:"-"`
+	LocalhostHandling   config.StringOption `json:"-"`
+	Proxy               config.StringOption `json:"-"`
+	BandwidthLimit      config.IntOption    `json:"-"`
 }
 
 // NewLayeredProfile returns a new layered profile based on the given local profile.
 func NewLayeredProfile(localProfile *Profile) *LayeredProfile {
+	new := newLayeredProfile(localProfile)
+
+	// Inform database subscribers about the new layered profile.
+	new.Lock()
+	defer new.Unlock()
+
+	pushLayeredProfile(new)
+
+	return new
+}
+
+func newLayeredProfile(localProfile *Profile) *LayeredProfile {
 	var securityLevelVal uint32
 
 	new := &LayeredProfile{
@@ -81,6 +106,10 @@ func NewLayeredProfile(localProfile *Profile) *LayeredProfile {
 		CfgOptionBlockScopeInternetKey,
 		cfgOptionBlockScopeInternet,
 	)
+	new.BlockScopeOverlay = new.wrapSecurityLevelOption(
+		CfgOptionBlockScopeOverlayKey,
+		cfgOptionBlockScopeOverlay,
+	)
 	new.BlockP2P = new.wrapSecurityLevelOption(
 		CfgOptionBlockP2PKey,
 		cfgOptionBlockP2P,
@@ -89,6 +118,10 @@ func NewLayeredProfile(localProfile *Profile) *LayeredProfile {
 		CfgOptionBlockInboundKey,
 		cfgOptionBlockInbound,
 	)
+	new.BlockProxies = new.wrapSecurityLevelOption(
+		CfgOptionBlockProxiesKey,
+		cfgOptionBlockProxies,
+	)
 	new.RemoveOutOfScopeDNS = new.wrapSecurityLevelOption(
 		CfgOptionRemoveOutOfScopeDNSKey,
 		cfgOptionRemoveOutOfScopeDNS,
@@ -113,28 +146,85 @@ func NewLayeredProfile(localProfile *Profile) *LayeredProfile {
 		CfgOptionDomainHeuristicsKey,
 		cfgOptionDomainHeuristics,
 	)
+	new.BlockTelemetry = new.wrapSecurityLevelOption(
+		CfgOptionBlockTelemetryKey,
+		cfgOptionBlockTelemetry,
+	)
+	new.BlockBackground = new.wrapSecurityLevelOption(
+		CfgOptionBlockBackgroundTrafficKey,
+		cfgOptionBlockBackgroundTraffic,
+	)
 	new.UseSPN = new.wrapBoolOption(
 		CfgOptionUseSPNKey,
 		cfgOptionUseSPN,
 	)
+	new.PortLearning = new.wrapIntOption(
+		CfgOptionPortLearningKey,
+		cfgOptionPortLearning,
+	)
+	new.SilencePrompts = new.wrapBoolOption(
+		CfgOptionSilencePromptsKey,
+		cfgOptionSilencePrompts,
+	)
+	new.BlockNotifications = new.wrapIntOption(
+		CfgOptionBlockNotificationsKey,
+		cfgOptionBlockNotifications,
+	)
+	new.LocalhostHandling = new.wrapStringOption(
+		CfgOptionLocalhostHandlingKey,
+		cfgOptionLocalhostHandling,
+	)
+	new.Proxy = new.wrapStringOption(
+		CfgOptionProxyKey,
+		cfgOptionProxy,
+	)
+	new.BandwidthLimit = new.wrapIntOption(
+		CfgOptionBandwidthLimitKey,
+		cfgOptionBandwidthLimit,
+	)
 
 	new.LayerIDs = append(new.LayerIDs, localProfile.ScopedID())
 	new.layers = append(new.layers, localProfile)
-
-	// TODO: Load additional profiles.
+	new.addLinkedProfiles(localProfile, 1)
 
 	new.updateCaches()
 
 	new.CreateMeta()
 	new.SetKey(runtime.DefaultRegistry.DatabaseName() + ":" + revisionProviderPrefix + localProfile.ScopedID())
 
-	// Inform database subscribers about the new layered profile.
-	new.Lock()
-	defer new.Unlock()
+	return new
+}
 
-	pushLayeredProfile(new)
+// addLinkedProfiles adds the linked profiles of the given profile as layers,
+// each directly followed by its own linked profiles. Profiles that are already
+// a layer are skipped in order to break loops.
+func (lp *LayeredProfile) addLinkedProfiles(profile *Profile, depth int) {
+	if depth > maxLinkedProfileDepth {
+		log.Warningf("profiles: not adding linked profiles of %s to %s: too deeply nested", profile.ScopedID(), lp.localProfile.ScopedID())
+		return
+	}
 
-	return new
+	profile.RLock()
+	linkedProfiles := append([]string(nil), profile.LinkedProfiles...)
+	profile.RUnlock()
+
+nextLinked:
+	for _, scopedID := range linkedProfiles {
+		for _, layerID := range lp.LayerIDs {
+			if layerID == scopedID {
+				continue nextLinked
+			}
+		}
+
+		linked, err := getLinkedProfile(scopedID)
+		if err != nil {
+			log.Warningf("profiles: failed to get profile %s linked by %s: %s", scopedID, profile.ScopedID(), err)
+			continue
+		}
+		lp.LayerIDs = append(lp.LayerIDs, scopedID)
+		lp.layers = append(lp.layers, linked)
+		lp.addLinkedProfiles(linked, depth+1)
+	}
 }
 
 // LockForUsage locks the layered profile, including all layers individually.
@@ -217,17 +307,27 @@ func (lp *LayeredProfile) Update() (revisionCounter uint64) {
 	defer lp.Unlock()
 
 	var changed bool
-	for i, layer := range lp.layers {
+	for _, layer := range lp.layers {
 		if layer.outdated.IsSet() {
 			changed = true
-			// update layer
-			newLayer, err := GetProfile(layer.Source, layer.ID, layer.LinkedPath)
+			break
+		}
+	}
+	if changed {
+		// Update the main layer and reload the linked layers, as the links
+		// may have changed too.
+		mainLayer := lp.layers[0]
+		if mainLayer.outdated.IsSet() {
+			newLayer, err := GetProfile(mainLayer.Source, mainLayer.ID, mainLayer.LinkedPath)
 			if err != nil {
-				log.Errorf("profiles: failed to update profile %s", layer.ScopedID())
+				log.Errorf("profiles: failed to update profile %s", mainLayer.ScopedID())
 			} else {
-				lp.layers[i] = newLayer
+				mainLayer = newLayer
 			}
 		}
+		lp.layers = []*Profile{mainLayer}
+		lp.LayerIDs = []string{mainLayer.ScopedID()}
+		lp.addLinkedProfiles(mainLayer, 1)
 	}
 	if !lp.globalValidityFlag.IsValid() {
 		changed = true
@@ -299,6 +399,28 @@ func (lp *LayeredProfile) MatchEndpoint(ctx context.Context, entity *intel.Entit
 	return cfgEndpoints.Match(ctx, entity)
 }
 
+// FiltersEndpoints returns whether any outgoing rules or filter lists apply to
+// the profile. This functions requires the layered profile to be read locked.
+func (lp *LayeredProfile) FiltersEndpoints() bool {
+	filterListsSet := false
+	for _, layer := range lp.layers {
+		if layer.endpoints.IsSet() {
+			return true
+		}
+		// The first layer that has filter lists set overrides the others.
+		if layer.filterListsSet && !filterListsSet {
+			if len(layer.filterListIDs) > 0 {
+				return true
+			}
+			filterListsSet = true
+		}
+	}
+
+	cfgLock.RLock()
+	defer cfgLock.RUnlock()
+	return cfgEndpoints.IsSet() || (!filterListsSet && len(cfgFilterLists) > 0)
+}
+
 // MatchServiceEndpoint checks if the given endpoint of an inbound connection matches an entry in any of the profiles. This functions requires the layered profile to be read locked.
 func (lp *LayeredProfile) MatchServiceEndpoint(ctx context.Context, entity *intel.Entity) (endpoints.EPResult, endpoints.Reason) {
 	entity.EnableReverseResolving()
@@ -328,7 +450,7 @@ func (lp *LayeredProfile) MatchFilterLists(ctx context.Context, entity *intel.En
 		if layer.filterListsSet {
 			entity.LoadLists(ctx)
 
-			if entity.MatchLists(layer.filterListIDs) {
+			if entity.MatchLists(layer.filterListIDs) && !lp.isAllowlisted(ctx, entity) {
 				return endpoints.Denied, entity.ListBlockReason()
 			}
 
@@ -341,7 +463,7 @@ func (lp *LayeredProfile) MatchFilterLists(ctx context.Context, entity *intel.En
 	if len(cfgFilterLists) > 0 {
 		entity.LoadLists(ctx)
 
-		if entity.MatchLists(cfgFilterLists) {
+		if entity.MatchLists(cfgFilterLists) && !lp.isAllowlisted(ctx, entity) {
 			return endpoints.Denied, entity.ListBlockReason()
 		}
 	}
@@ -349,6 +471,24 @@ func (lp *LayeredProfile) MatchFilterLists(ctx context.Context, entity *intel.En
 	return endpoints.NoMatch, nil
 }
 
+// isAllowlisted returns whether the entity is exempt from filter list
+// blocking by an allowlist referenced by any of the layers or the global
+// configuration. This function requires the layered profile to be read locked
+// and cfgLock to be read locked.
+func (lp *LayeredProfile) isAllowlisted(ctx context.Context, entity *intel.Entity) bool {
+	for _, layer := range lp.layers {
+		if al := matchAllowlists(ctx, layer.allowlistIDs, entity); al != nil {
+			log.Tracer(ctx).Debugf("profile: filter list match exempted by allowlist %s", al.Name)
+			return true
+		}
+	}
+	if al := matchAllowlists(ctx, cfgAllowlists, entity); al != nil {
+		log.Tracer(ctx).Debugf("profile: filter list match exempted by allowlist %s", al.Name)
+		return true
+	}
+	return false
+}
+
 func (lp *LayeredProfile) wrapSecurityLevelOption(configKey string, globalConfig config.IntOption) config.BoolOption {
 	activeAtLevels := lp.wrapIntOption(configKey, globalConfig)
 
@@ -438,9 +578,6 @@ func (lp *LayeredProfile) GetProfileSource(configKey string) string {
 	return ""
 }
 
-/*
-For later:
-
 func (lp *LayeredProfile) wrapStringOption(configKey string, globalConfig config.StringOption) config.StringOption {
 	var revCnt uint64 = 0
 	var value string
@@ -472,7 +609,6 @@ func (lp *LayeredProfile) wrapStringOption(configKey string, globalConfig config
 		return value
 	}
 }
-*/
 
 func max(a, b uint8) uint8 {
 	if a > b {
@@ -0,0 +1,47 @@
+package profile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLinkedProfileLayers(t *testing.T) {
+	electron := New(SourceLocal, "electron", "", nil)
+	electron.LinkedProfiles = []string{"local/base", "local/app"}
+	base := New(SourceLocal, "base", "", nil)
+	app := New(SourceLocal, "app", "/usr/bin/app", nil)
+	app.LinkedProfiles = []string{"local/electron", "local/missing"}
+	for _, profile := range []*Profile{electron, base, app} {
+		addActiveProfile(profile)
+	}
+	defer func() {
+		activeProfilesLock.Lock()
+		defer activeProfilesLock.Unlock()
+		for _, profile := range []*Profile{electron, base, app} {
+			delete(activeProfiles, profile.ScopedID())
+		}
+	}()
+
+	lp := newLayeredProfile(app)
+	if expected := []string{"local/app", "local/electron", "local/base"}; !reflect.DeepEqual(lp.LayerIDs, expected) {
+		t.Errorf("unexpected layers: %v", lp.LayerIDs)
+	}
+	if lp.layers[1] != electron || lp.layers[2] != base {
+		t.Error("linked layers must use the active profiles")
+	}
+}
+
+func TestCheckLinkedProfiles(t *testing.T) {
+	profile := New(SourceLocal, "app", "", nil)
+
+	profile.LinkedProfiles = []string{"local/electron"}
+	if err := profile.checkLinkedProfiles(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	for _, invalid := range []string{"electron", "local/", "local/app"} {
+		profile.LinkedProfiles = []string{invalid}
+		if err := profile.checkLinkedProfiles(); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
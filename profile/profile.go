@@ -5,6 +5,7 @@
 	"errors"
 	"fmt"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -17,12 +18,20 @@
 	"github.com/safing/portbase/log"
 	"github.com/safing/portbase/utils"
 	"github.com/safing/portbase/utils/osdetail"
+	"github.com/safing/portmaster/core/migration"
 	"github.com/safing/portmaster/intel/filterlists"
 	"github.com/safing/portmaster/profile/endpoints"
+	"github.com/safing/portmaster/profile/pkgdb"
+	"github.com/safing/portmaster/status"
 )
 
 var (
 	lastUsedUpdateThreshold = 24 * time.Hour
+
+	// packageInfoChecked holds the profiles whose binary was already looked
+	// up in the package databases.
+	packageInfoChecked     = make(map[string]struct{})
+	packageInfoCheckedLock sync.Mutex
 )
 
 // profileSource is the source of the profile.
@@ -45,6 +54,14 @@
 	DefaultActionPermit uint8 = 3
 )
 
+// Localhost Handling Values
+const (
+	LocalhostHandlingDefault = "default"
+	LocalhostHandlingMonitor = "monitor"
+	LocalhostHandlingBlock   = "block"
+	LocalhostHandlingAsk     = "ask"
+)
+
 // iconType describes the type of the Icon property
 // of a profile.
 type iconType string
@@ -80,11 +97,31 @@ type Profile struct {
 	Icon string
 	// IconType describes the type of the Icon property.
 	IconType iconType
+	// PackageInfo holds the metadata of the installed package the binary
+	// belongs to, if any.
+	PackageInfo *pkgdb.Info `json:",omitempty"`
+	// Tags holds short labels of the user, such as "work" or "games". They
+	// are added to the connections of the profile.
+	Tags []string
+	// Notes holds free text of the user about the profile.
+	Notes string
+	// DisableSync excludes the profile from being synchronized with peers.
+	DisableSync bool `json:",omitempty"`
 	// LinkedPath is a filesystem path to the executable this
 	// profile was created for.
 	LinkedPath string // constant
-	// LinkedProfiles is a list of other profiles
+	// Fingerprint identifies the binary this profile was created for. It is
+	// used to detect binaries that were replaced.
+	Fingerprint *Fingerprint `json:",omitempty"`
+	// LinkedProfiles holds the scoped IDs of other profiles whose settings
+	// and rules apply to this profile, in the given order, if this profile
+	// does not define them itself. This allows sharing settings between many
+	// applications.
 	LinkedProfiles []string
+	// NetworkOverrides holds alternative settings that apply while the device
+	// is connected to certain networks. If multiple overrides match, the
+	// first one is applied.
+	NetworkOverrides []*NetworkOverride
 	// SecurityLevel is the mininum security level to apply to
 	// connections made with this profile.
 	// Note(ppacher): we may deprecate this one as it can easily
@@ -97,6 +134,10 @@ type Profile struct {
 	// an object) need to be concatenated for the settings database
 	// path.
 	Config map[string]interface{}
+	// AppliedMigrations holds the IDs of the config migrations that were
+	// applied to Config and the UTC timestamp in seconds when they were
+	// applied. Migrations are only applied once.
+	AppliedMigrations map[string]int64 `json:",omitempty"`
 
 	// ApproxLastUsed holds a UTC timestamp in seconds of
 	// when this Profile was approximately last used.
@@ -122,27 +163,84 @@ type Profile struct {
 	layeredProfile *LayeredProfile
 
 	// Interpreted Data
-	configPerspective *config.Perspective
-	dataParsed        bool
-	defaultAction     uint8
-	endpoints         endpoints.Endpoints
-	serviceEndpoints  endpoints.Endpoints
-	filterListsSet    bool
-	filterListIDs     []string
+	activeScheduleID        string
+	activeNetworkOverrideID string
+	configPerspective       *config.Perspective
+	dataParsed              bool
+	defaultAction           uint8
+	endpoints               endpoints.Endpoints
+	serviceEndpoints        endpoints.Endpoints
+	endpointScheduleState   string
+	filterListsSet          bool
+	filterListIDs           []string
+	allowlistIDs            []string
+
+	allowedInterfacesSet bool
+	allowedInterfaces    []*interfaceMatcher
 
 	// Lifecycle Management
 	outdated   *abool.AtomicBool
 	lastActive *int64
 }
 
+// migrateConfig applies config migrations to the profile config and records
+// them in the profile. It returns whether any migrations were applied, in
+// which case the profile should be saved.
+func (profile *Profile) migrateConfig() (migrated bool) {
+	migratedConfig, applied := migration.MigrateHierarchicalConfig(profile.Config, func(id string) bool {
+		_, ok := profile.AppliedMigrations[id]
+		return ok
+	})
+	if len(applied) > 0 {
+		profile.Config = migratedConfig
+		if profile.AppliedMigrations == nil {
+			profile.AppliedMigrations = make(map[string]int64, len(applied))
+		}
+		now := time.Now().Unix()
+		for _, id := range applied {
+			profile.AppliedMigrations[id] = now
+		}
+		log.Infof("profiles: applied config migrations to profile %s: %v", profile.ScopedID(), applied)
+	}
+	migration.WarnAbandonedKeys(config.Flatten(profile.Config), "profile "+profile.ScopedID())
+	return len(applied) > 0
+}
+
 func (profile *Profile) prepConfig() (err error) {
-	// prepare configuration
-	profile.configPerspective, err = config.NewPerspective(profile.Config)
+	err = profile.prepConfigPerspective()
 	profile.outdated = abool.New()
 	profile.lastActive = new(int64)
 	return
 }
 
+// prepConfigPerspective prepares the configuration, with the settings of an
+// active network override and schedule. These are never written to the
+// stored config.
+func (profile *Profile) prepConfigPerspective() (err error) {
+	cfg, overrideID := profile.applyNetworkOverride(profile.Config)
+	profile.activeNetworkOverrideID = overrideID
+	cfg, scheduleID := profile.applySchedule(cfg)
+	profile.activeScheduleID = scheduleID
+	profile.configPerspective, err = config.NewPerspective(cfg)
+	return
+}
+
+// reloadConfig prepares and parses the configuration again after the stored
+// config was changed, so that the changes apply before the profile is
+// reloaded from the database after saving. The caller must hold the profile
+// lock.
+func (profile *Profile) reloadConfig() error {
+	if err := profile.prepConfigPerspective(); err != nil {
+		return err
+	}
+
+	profile.dataParsed = false
+	if err := profile.parseConfig(); err != nil {
+		log.Errorf("profile: failed to parse %s config after change: %s", profile, err)
+	}
+	return nil
+}
+
 func (profile *Profile) parseConfig() error {
 	if profile.configPerspective == nil {
 		return errors.New("config not prepared")
@@ -190,6 +288,8 @@ func (profile *Profile) parseConfig() error {
 		}
 	}
 
+	profile.endpointScheduleState = profile.getEndpointScheduleState(time.Now())
+
 	list, ok = profile.configPerspective.GetAsStringArray(CfgOptionFilterListsKey)
 	profile.filterListsSet = false
 	if ok {
@@ -201,6 +301,19 @@ func (profile *Profile) parseConfig() error {
 		}
 	}
 
+	profile.allowlistIDs, _ = profile.configPerspective.GetAsStringArray(CfgOptionAllowlistsKey)
+
+	list, ok = profile.configPerspective.GetAsStringArray(CfgOptionAllowedInterfacesKey)
+	profile.allowedInterfacesSet = false
+	if ok {
+		profile.allowedInterfaces, err = parseInterfaceMatchers(list)
+		if err != nil {
+			lastErr = err
+		} else {
+			profile.allowedInterfacesSet = true
+		}
+	}
+
 	return lastErr
 }
 
@@ -267,6 +380,20 @@ func (profile *Profile) Save() error {
 	return profileDB.Put(profile)
 }
 
+// checkLinkedProfiles checks that the linked profiles are valid scoped IDs.
+func (profile *Profile) checkLinkedProfiles() error {
+	for _, scopedID := range profile.LinkedProfiles {
+		parts := strings.SplitN(scopedID, "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("invalid linked profile %q: expected format <source>/<id>", scopedID)
+		}
+		if scopedID == profile.ScopedID() {
+			return errors.New("profile cannot link to itself")
+		}
+	}
+	return nil
+}
+
 // MarkStillActive marks the profile as still active.
 func (profile *Profile) MarkStillActive() {
 	atomic.StoreInt64(profile.lastActive, time.Now().Unix())
@@ -342,8 +469,10 @@ func (profile *Profile) addEndpointyEntry(cfgKey, newEntry string) {
 	profile.Lock()
 	defer profile.Unlock()
 
-	// Get the endpoint list configuration value and add the new entry.
-	endpointList, ok := profile.configPerspective.GetAsStringArray(cfgKey)
+	// Get the endpoint list from the stored config and add the new entry. The
+	// effective config also holds the settings of an active network override
+	// or schedule, which must not be stored.
+	endpointList, ok := toStringSlice(config.Flatten(profile.Config)[cfgKey])
 	if ok {
 		// A list already exists, check for duplicates within the same prefix.
 		newEntryPrefix := strings.Split(newEntry, " ")[0] + " "
@@ -372,11 +501,58 @@ func (profile *Profile) addEndpointyEntry(cfgKey, newEntry string) {
 	changed = true
 
 	// Reload the profile manually in order to parse the newly added entry.
-	profile.dataParsed = false
-	err := profile.parseConfig()
-	if err != nil {
-		log.Errorf("profile: failed to parse %s config after adding endpoint: %s", profile, err)
+	if err := profile.reloadConfig(); err != nil {
+		log.Errorf("profile: failed to reload %s config after adding endpoint: %s", profile, err)
+	}
+}
+
+// EndpointRules returns the outgoing endpoint rules stored in the profile
+// itself.
+func (profile *Profile) EndpointRules() []string {
+	profile.Lock()
+	defer profile.Unlock()
+
+	endpointList, _ := toStringSlice(config.Flatten(profile.Config)[CfgOptionEndpointsKey])
+	return endpointList
+}
+
+// ConsolidateEndpoints replaces the given endpoint rules with a new rule. The
+// new rule takes the place of the first replaced rule, or is added to the top
+// if none of the rules to be replaced are in the list.
+func (profile *Profile) ConsolidateEndpoints(replace []string, newEntry string) error {
+	profile.Lock()
+
+	toReplace := make(map[string]struct{}, len(replace))
+	for _, entry := range replace {
+		toReplace[normalizeRule(entry)] = struct{}{}
 	}
+
+	endpointList, _ := toStringSlice(config.Flatten(profile.Config)[CfgOptionEndpointsKey])
+	newList := make([]string, 0, len(endpointList)+1)
+	added := false
+	for _, entry := range endpointList {
+		if _, ok := toReplace[normalizeRule(entry)]; ok {
+			if !added {
+				newList = append(newList, newEntry)
+				added = true
+			}
+			continue
+		}
+		newList = append(newList, entry)
+	}
+	if !added {
+		newList = append([]string{newEntry}, newList...)
+	}
+
+	// Save new value back to profile and reload it.
+	config.PutValueIntoHierarchicalConfig(profile.Config, CfgOptionEndpointsKey, newList)
+	if err := profile.reloadConfig(); err != nil {
+		profile.Unlock()
+		return err
+	}
+
+	profile.Unlock()
+	return profile.Save()
 }
 
 // LayeredProfile returns the layered profile associated with this profile.
@@ -427,6 +603,26 @@ func (profile *Profile) UpdateMetadata(binaryPath string) (changed bool) {
 		return changed
 	}
 
+	// Packages are named after their package family name, as there is no
+	// binary to get metadata from.
+	if IsPackageLinkedPath(profile.LinkedPath) {
+		if strings.TrimSpace(profile.Name) == "" {
+			profile.Name = generateNameFromPackage(profile.LinkedPath)
+			return true
+		}
+		return false
+	}
+
+	// Separate profiles of changed binaries are named after the binary, but
+	// are marked as such.
+	if IsFingerprintLinkedPath(profile.LinkedPath) {
+		if strings.TrimSpace(profile.Name) == "" {
+			profile.Name = generateNameFromFingerprint(profile.LinkedPath)
+			return true
+		}
+		return false
+	}
+
 	var needsUpdateFromSystem bool
 
 	// Check profile name.
@@ -452,9 +648,66 @@ func (profile *Profile) UpdateMetadata(binaryPath string) (changed bool) {
 		module.StartWorker("get profile metadata", profile.updateMetadataFromSystem)
 	}
 
+	// Look up the package of the binary once per run, as it may have been
+	// installed, updated or removed in the meantime.
+	if markPackageInfoChecked(profile.ScopedID()) {
+		module.StartWorker("get profile package info", profile.updatePackageInfo)
+	}
+
 	return changed
 }
 
+// markPackageInfoChecked marks the package info of the profile as checked and
+// returns whether it was not checked before.
+func markPackageInfoChecked(scopedID string) bool {
+	packageInfoCheckedLock.Lock()
+	defer packageInfoCheckedLock.Unlock()
+
+	if _, ok := packageInfoChecked[scopedID]; ok {
+		return false
+	}
+	packageInfoChecked[scopedID] = struct{}{}
+	return true
+}
+
+// updatePackageInfo updates the package metadata of the profile from the
+// package databases of the system and saves it afterwards, if changed.
+func (profile *Profile) updatePackageInfo(_ context.Context) error {
+	info, err := pkgdb.Lookup(profile.LinkedPath)
+	switch {
+	case errors.Is(err, pkgdb.ErrNotSupported):
+		return nil
+	case errors.Is(err, pkgdb.ErrNotFound):
+		info = nil
+	case err != nil:
+		log.Warningf("profile: failed to look up package of %s: %s", profile.LinkedPath, err)
+		return nil
+	}
+
+	profile.Lock()
+	changed := !reflect.DeepEqual(profile.PackageInfo, info)
+	if changed {
+		profile.PackageInfo = info
+		// Fill in empty fields with the package metadata.
+		if info != nil {
+			if profile.Description == "" {
+				profile.Description = info.Description
+			}
+			if profile.Homepage == "" {
+				profile.Homepage = info.Homepage
+			}
+		}
+	}
+	profile.Unlock()
+
+	if changed {
+		if err := profile.Save(); err != nil {
+			log.Warningf("profile: failed to save %s after package info update: %s", profile.ScopedID(), err)
+		}
+	}
+	return nil
+}
+
 // updateMetadataFromSystem updates the profile metadata with data from the
 // operating system and saves it afterwards.
 func (profile *Profile) updateMetadataFromSystem(ctx context.Context) error {
@@ -515,3 +768,24 @@ func (profile *Profile) updateMetadataFromSystem(ctx context.Context) error {
 
 	return nil
 }
+
+// BlocksInternet returns whether the profile itself is configured to block
+// Internet access on any security level.
+func (profile *Profile) BlocksInternet() bool {
+	if profile.configPerspective == nil {
+		return false
+	}
+
+	level, ok := profile.configPerspective.GetAsInt(CfgOptionBlockScopeInternetKey)
+	return ok && level != int64(status.SecurityLevelOff)
+}
+
+// NewSyntheticProfile returns a new local profile with the given config and
+// a layered profile. It is neither saved nor announced and is used to
+// evaluate configuration changes.
+func NewSyntheticProfile(id, name string, customConfig map[string]interface{}) *Profile {
+	profile := New(SourceLocal, id, "", customConfig)
+	profile.Name = name
+	profile.layeredProfile = newLayeredProfile(profile)
+	return profile
+}
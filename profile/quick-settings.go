@@ -0,0 +1,133 @@
+package profile
+
+import (
+	"strings"
+
+	"github.com/safing/portbase/config"
+)
+
+// QuickSettings are simple toggles for common settings of a profile. They are
+// not stored separately, but are compiled to endpoint rules. This way, they
+// stay in sync with rules that are managed manually.
+type QuickSettings struct {
+	BlockInternet bool
+	BlockLAN      bool
+	BlockP2P      bool
+	BlockIncoming bool
+}
+
+type quickSettingRule struct {
+	cfgKey string
+	rule   string
+	get    func(qs *QuickSettings) *bool
+}
+
+var quickSettingRules = []quickSettingRule{
+	{
+		cfgKey: CfgOptionEndpointsKey,
+		rule:   "- Internet",
+		get:    func(qs *QuickSettings) *bool { return &qs.BlockInternet },
+	},
+	{
+		cfgKey: CfgOptionEndpointsKey,
+		rule:   "- LAN",
+		get:    func(qs *QuickSettings) *bool { return &qs.BlockLAN },
+	},
+	{
+		cfgKey: CfgOptionEndpointsKey,
+		rule:   "- P2P",
+		get:    func(qs *QuickSettings) *bool { return &qs.BlockP2P },
+	},
+	{
+		cfgKey: CfgOptionServiceEndpointsKey,
+		rule:   "- *",
+		get:    func(qs *QuickSettings) *bool { return &qs.BlockIncoming },
+	},
+}
+
+// normalizeRule removes any extra whitespace from the given rule, so that
+// rules can be compared.
+func normalizeRule(rule string) string {
+	return strings.Join(strings.Fields(rule), " ")
+}
+
+// GetQuickSettings returns the quick settings derived from the endpoint rules
+// of the profile.
+func (profile *Profile) GetQuickSettings() *QuickSettings {
+	profile.Lock()
+	defer profile.Unlock()
+
+	qs := &QuickSettings{}
+	for _, qsr := range quickSettingRules {
+		list, ok := profile.configPerspective.GetAsStringArray(qsr.cfgKey)
+		if !ok {
+			continue
+		}
+		for _, entry := range list {
+			if strings.EqualFold(normalizeRule(entry), qsr.rule) {
+				*qsr.get(qs) = true
+				break
+			}
+		}
+	}
+
+	return qs
+}
+
+// SetQuickSettings applies the given quick settings to the endpoint rules of
+// the profile. Rules for enabled settings are added to the top of the list,
+// rules for disabled settings are removed. The profile is saved if anything
+// changed.
+// The rules are read from and written to the stored profile config, so that
+// settings of active network overrides and schedules are not persisted and
+// rules sharing a list do not overwrite each other.
+func (profile *Profile) SetQuickSettings(qs *QuickSettings) (changed bool, err error) {
+	profile.Lock()
+	changed, err = profile.applyQuickSettings(qs)
+	profile.Unlock()
+
+	if changed && err == nil {
+		err = profile.Save()
+	}
+	return changed, err
+}
+
+// applyQuickSettings applies the given quick settings to the profile config.
+// The caller must hold the profile lock.
+func (profile *Profile) applyQuickSettings(qs *QuickSettings) (changed bool, err error) {
+	for _, qsr := range quickSettingRules {
+		list, _ := toStringSlice(config.Flatten(profile.Config)[qsr.cfgKey])
+
+		// Remove existing quick setting rule.
+		newList := make([]string, 0, len(list)+1)
+		var found bool
+		for _, entry := range list {
+			if strings.EqualFold(normalizeRule(entry), qsr.rule) {
+				found = true
+				continue
+			}
+			newList = append(newList, entry)
+		}
+
+		// Add the rule to the top, if enabled.
+		enabled := *qsr.get(qs)
+		if enabled {
+			newList = append([]string{qsr.rule}, newList...)
+		}
+
+		// Apply if changed.
+		if found != enabled {
+			config.PutValueIntoHierarchicalConfig(profile.Config, qsr.cfgKey, newList)
+			changed = true
+		}
+	}
+
+	if changed {
+		// Reload the profile config manually in order to apply the new rules.
+		if err := profile.reloadConfig(); err != nil {
+			return false, err
+		}
+	}
+
+	return changed, nil
+}
@@ -0,0 +1,54 @@
+package profile
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/safing/portbase/config"
+)
+
+func TestApplyQuickSettings(t *testing.T) {
+	profile := &Profile{
+		ID:     "test",
+		Source: SourceLocal,
+		Config: config.Expand(map[string]interface{}{
+			CfgOptionEndpointsKey: []interface{}{"+ example.com"},
+		}),
+	}
+	if err := profile.prepConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Enable multiple toggles sharing the same list at once.
+	changed, err := profile.applyQuickSettings(&QuickSettings{
+		BlockInternet: true,
+		BlockLAN:      true,
+		BlockIncoming: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Error("expected quick settings to change the profile")
+	}
+
+	flat := config.Flatten(profile.Config)
+	if expected := []string{"- LAN", "- Internet", "+ example.com"}; !reflect.DeepEqual(flat[CfgOptionEndpointsKey], expected) {
+		t.Errorf("unexpected endpoint rules: %v", flat[CfgOptionEndpointsKey])
+	}
+	if expected := []string{"- *"}; !reflect.DeepEqual(flat[CfgOptionServiceEndpointsKey], expected) {
+		t.Errorf("unexpected incoming rules: %v", flat[CfgOptionServiceEndpointsKey])
+	}
+
+	// Disable one of them again.
+	if _, err := profile.applyQuickSettings(&QuickSettings{BlockLAN: true}); err != nil {
+		t.Fatal(err)
+	}
+	flat = config.Flatten(profile.Config)
+	if expected := []string{"- LAN", "+ example.com"}; !reflect.DeepEqual(flat[CfgOptionEndpointsKey], expected) {
+		t.Errorf("unexpected endpoint rules: %v", flat[CfgOptionEndpointsKey])
+	}
+	if expected := []string{}; !reflect.DeepEqual(flat[CfgOptionServiceEndpointsKey], expected) {
+		t.Errorf("unexpected incoming rules: %v", flat[CfgOptionServiceEndpointsKey])
+	}
+}
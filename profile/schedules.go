@@ -0,0 +1,378 @@
+package profile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+)
+
+// Schedules activate alternative settings of a profile during certain times,
+// for example stricter filter lists on school nights. They are stored in
+// secret records, so that they cannot be changed through the database API,
+// and schedules that are locked may only be changed with the admin
+// passphrase.
+
+const (
+	schedulesDBPath = "core:profile-schedules/"
+
+	scheduleCheckInterval = 1 * time.Minute
+)
+
+// ErrScheduleLocked is returned when a locked schedule is changed without
+// the admin passphrase.
+var ErrScheduleLocked = errors.New("schedule is locked by an admin")
+
+// Schedule defines alternative settings that are active during a time window.
+type Schedule struct {
+	// ID is a unique identifier of the schedule within the profile.
+	ID string
+	// Name is a human readable name of the schedule.
+	Name string
+	// Days holds the week days on which the schedule is active. An empty
+	// list means every day. The days refer to the start of the time window.
+	Days []time.Weekday
+	// Start is the local time at which the schedule becomes active, in the
+	// format "15:04".
+	Start string
+	// End is the local time at which the schedule becomes inactive, in the
+	// format "15:04". If it is before Start, the window spans midnight.
+	End string
+	// Config holds the settings that are applied while the schedule is
+	// active, in the flat (key=value) form. They take precedence over the
+	// settings of the profile.
+	Config map[string]interface{}
+	// Locked marks the schedule as managed by an admin.
+	Locked bool
+
+	start, end int // minutes of the day
+}
+
+// ProfileSchedules holds the schedules of a profile.
+type ProfileSchedules struct {
+	record.Base
+	sync.Mutex
+
+	// Profile is the scoped ID of the profile.
+	Profile string
+	// Schedules holds the schedules of the profile. If multiple schedules
+	// are active at the same time, the first one is applied.
+	Schedules []*Schedule
+}
+
+var (
+	schedules     = make(map[string]*ProfileSchedules)
+	schedulesLock sync.RWMutex
+)
+
+func parseTimeOfDay(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected format 15:04", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// parse validates the schedule and parses its time window.
+func (s *Schedule) parse() (err error) {
+	if s.ID == "" {
+		return errors.New("schedule needs an ID")
+	}
+	if s.start, err = parseTimeOfDay(s.Start); err != nil {
+		return err
+	}
+	if s.end, err = parseTimeOfDay(s.End); err != nil {
+		return err
+	}
+	if s.start == s.end {
+		return errors.New("schedule start and end must differ")
+	}
+	for _, day := range s.Days {
+		if day < time.Sunday || day > time.Saturday {
+			return fmt.Errorf("invalid week day %d", day)
+		}
+	}
+	if len(s.Config) == 0 {
+		return errors.New("schedule has no settings")
+	}
+	for key := range s.Config {
+		if !isProfileOption(key) {
+			return fmt.Errorf("%s is not a setting of app profiles", key)
+		}
+	}
+	return nil
+}
+
+// activeAt returns whether the schedule is active at the given time.
+func (s *Schedule) activeAt(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+
+	if s.start > s.end {
+		// Window spans midnight.
+		switch {
+		case minute >= s.start:
+			// Before midnight, on the start day.
+		case minute < s.end:
+			// After midnight, the window started on the previous day.
+			day = (day + 6) % 7
+		default:
+			return false
+		}
+	} else if minute < s.start || minute >= s.end {
+		return false
+	}
+
+	if len(s.Days) == 0 {
+		return true
+	}
+	for _, d := range s.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+func isProfileOption(key string) bool {
+	if _, ok := cfgStringOptions[key]; ok {
+		return true
+	}
+	if _, ok := cfgStringArrayOptions[key]; ok {
+		return true
+	}
+	if _, ok := cfgIntOptions[key]; ok {
+		return true
+	}
+	_, ok := cfgBoolOptions[key]
+	return ok
+}
+
+// loadSchedules loads the schedules of all profiles from the database.
+func loadSchedules() error {
+	it, err := profileDB.Query(query.New(schedulesDBPath))
+	if err != nil {
+		return err
+	}
+
+	schedulesLock.Lock()
+	defer schedulesLock.Unlock()
+
+	for r := range it.Next {
+		ps := &ProfileSchedules{}
+		if err := record.Unwrap(r, ps); err != nil {
+			log.Warningf("profile: failed to parse schedules %s: %s", r.Key(), err)
+			continue
+		}
+		for _, s := range ps.Schedules {
+			if err := s.parse(); err != nil {
+				log.Warningf("profile: invalid schedule %s of profile %s: %s", s.ID, ps.Profile, err)
+			}
+		}
+		schedules[ps.Profile] = ps
+	}
+	return it.Err()
+}
+
+// GetSchedules returns the schedules of the profile with the given scoped ID.
+func GetSchedules(scopedID string) []*Schedule {
+	schedulesLock.RLock()
+	defer schedulesLock.RUnlock()
+
+	ps, ok := schedules[scopedID]
+	if !ok {
+		return []*Schedule{}
+	}
+	return append([]*Schedule(nil), ps.Schedules...)
+}
+
+// SetSchedules replaces the schedules of the profile with the given scoped ID.
+// Unless admin is set, locked schedules may neither be changed nor added or
+// removed.
+func SetSchedules(scopedID string, newSchedules []*Schedule, admin bool) error {
+	seen := make(map[string]struct{}, len(newSchedules))
+	for _, s := range newSchedules {
+		if err := s.parse(); err != nil {
+			return fmt.Errorf("invalid schedule %q: %w", s.ID, err)
+		}
+		if _, ok := seen[s.ID]; ok {
+			return fmt.Errorf("duplicate schedule ID %q", s.ID)
+		}
+		seen[s.ID] = struct{}{}
+	}
+
+	schedulesLock.Lock()
+	defer schedulesLock.Unlock()
+
+	if !admin {
+		if err := checkLockedSchedules(schedules[scopedID], newSchedules); err != nil {
+			return err
+		}
+	}
+
+	ps := &ProfileSchedules{
+		Profile:   scopedID,
+		Schedules: newSchedules,
+	}
+	ps.SetKey(schedulesDBPath + scopedID)
+	ps.SetMeta(&record.Meta{})
+	ps.Meta().MakeSecret()
+	if len(newSchedules) == 0 {
+		delete(schedules, scopedID)
+		if err := profileDB.Delete(ps.Key()); err != nil {
+			return err
+		}
+	} else {
+		schedules[scopedID] = ps
+		if err := profileDB.Put(ps); err != nil {
+			return err
+		}
+	}
+
+	markActiveProfileAsOutdated(scopedID)
+	return nil
+}
+
+// checkLockedSchedules returns ErrScheduleLocked if the locked schedules
+// differ between the current and the new schedules.
+func checkLockedSchedules(current *ProfileSchedules, newSchedules []*Schedule) error {
+	locked := make(map[string]*Schedule)
+	if current != nil {
+		for _, s := range current.Schedules {
+			if s.Locked {
+				locked[s.ID] = s
+			}
+		}
+	}
+
+	for _, s := range newSchedules {
+		existing, ok := locked[s.ID]
+		switch {
+		case !ok && s.Locked:
+			return ErrScheduleLocked
+		case !ok:
+			continue
+		case !s.equal(existing):
+			return ErrScheduleLocked
+		}
+		delete(locked, s.ID)
+	}
+
+	if len(locked) > 0 {
+		return ErrScheduleLocked
+	}
+	return nil
+}
+
+func (s *Schedule) equal(other *Schedule) bool {
+	if s.Name != other.Name ||
+		s.start != other.start ||
+		s.end != other.end ||
+		s.Locked != other.Locked ||
+		fmt.Sprint(s.Days) != fmt.Sprint(other.Days) ||
+		len(s.Config) != len(other.Config) {
+		return false
+	}
+	for key, value := range s.Config {
+		otherValue, ok := other.Config[key]
+		if !ok || fmt.Sprint(value) != fmt.Sprint(otherValue) {
+			return false
+		}
+	}
+	return true
+}
+
+// activeSchedule returns the schedule of the profile that is active at the
+// given time, if any.
+func activeSchedule(scopedID string, t time.Time) *Schedule {
+	schedulesLock.RLock()
+	defer schedulesLock.RUnlock()
+
+	ps, ok := schedules[scopedID]
+	if !ok {
+		return nil
+	}
+	for _, s := range ps.Schedules {
+		// Skip schedules that failed to parse.
+		if s.start != s.end && s.activeAt(t) {
+			return s
+		}
+	}
+	return nil
+}
+
+// applySchedule returns the given config with the settings of the active
+// schedule applied and the ID of that schedule.
+func (profile *Profile) applySchedule(cfg map[string]interface{}) (map[string]interface{}, string) {
+	s := activeSchedule(profile.ScopedID(), time.Now())
+	if s == nil {
+		return cfg, ""
+	}
+
+	flat := config.Flatten(cfg)
+	for key, value := range s.Config {
+		flat[key] = value
+	}
+	return config.Expand(flat), s.ID
+}
+
+// checkSchedules marks active profiles as outdated when their active
+// schedule changed or when any of their scheduled endpoints started or stopped
+// applying, so that they are reloaded and connections are re-evaluated.
+func checkSchedules(_ context.Context, _ *modules.Task) error {
+	now := time.Now()
+
+	// Scheduled endpoints of the global config apply to all profiles.
+	cfgLock.Lock()
+	globalState := getGlobalEndpointScheduleState(now)
+	globalChanged := cfgEndpointScheduleState != globalState
+	cfgEndpointScheduleState = globalState
+	cfgLock.Unlock()
+	if globalChanged {
+		log.Info("profile: scheduled global rules changed, re-evaluating connections")
+		markAllActiveProfilesAsOutdated()
+	}
+
+	for _, profile := range getAllActiveProfiles() {
+		var scheduleID string
+		if s := activeSchedule(profile.ScopedID(), now); s != nil {
+			scheduleID = s.ID
+		}
+
+		profile.Lock()
+		changed := profile.activeScheduleID != scheduleID
+		endpointState := profile.getEndpointScheduleState(now)
+		endpointsChanged := profile.endpointScheduleState != endpointState
+		profile.endpointScheduleState = endpointState
+		profile.Unlock()
+
+		switch {
+		case changed:
+			log.Infof("profile: switching schedule of profile %s to %q", profile.ScopedID(), scheduleID)
+			profile.outdated.Set()
+		case endpointsChanged:
+			log.Infof("profile: scheduled rules of profile %s changed", profile.ScopedID())
+			profile.outdated.Set()
+		}
+	}
+	return nil
+}
+
+// getEndpointScheduleState returns which of the scheduled rules of the profile
+// apply at the given time. The caller must hold the profile lock.
+func (profile *Profile) getEndpointScheduleState(t time.Time) string {
+	return profile.endpoints.ScheduleState(t) + "/" + profile.serviceEndpoints.ScheduleState(t)
+}
+
+// getGlobalEndpointScheduleState returns which of the scheduled rules of the
+// global config apply at the given time. The caller must hold the config lock.
+func getGlobalEndpointScheduleState(t time.Time) string {
+	return cfgEndpoints.ScheduleState(t) + "/" + cfgServiceEndpoints.ScheduleState(t)
+}
@@ -0,0 +1,128 @@
+package profile
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/safing/portbase/config"
+)
+
+func TestScheduleActiveAt(t *testing.T) {
+	// School nights: Sunday to Thursday, 21:00 to 07:00.
+	s := &Schedule{
+		Days: []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday},
+	}
+	var err error
+	if s.start, err = parseTimeOfDay("21:00"); err != nil {
+		t.Fatal(err)
+	}
+	if s.end, err = parseTimeOfDay("07:00"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 2021-03-01 is a Monday.
+	for _, test := range []struct {
+		time   string
+		active bool
+	}{
+		{"2021-03-01 20:59", false},
+		{"2021-03-01 21:00", true},
+		{"2021-03-02 06:59", true},
+		{"2021-03-02 07:00", false},
+		{"2021-03-05 22:00", false}, // Friday
+		{"2021-03-06 06:00", false}, // Saturday morning after Friday
+		{"2021-03-01 06:00", true},  // Monday morning after Sunday
+	} {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", test.time, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.activeAt(tm) != test.active {
+			t.Errorf("expected schedule active=%v at %s", test.active, test.time)
+		}
+	}
+
+	if _, err := parseTimeOfDay("25:00"); err == nil {
+		t.Error("expected invalid time of day to fail")
+	}
+}
+
+func TestCheckLockedSchedules(t *testing.T) {
+	locked := &Schedule{ID: "locked", Locked: true, Config: map[string]interface{}{"filter/lists": []string{"TRAC"}}}
+	unlocked := &Schedule{ID: "unlocked", Config: map[string]interface{}{"filter/defaultAction": "block"}}
+	current := &ProfileSchedules{Schedules: []*Schedule{locked, unlocked}}
+
+	changedLocked := *locked
+	changedLocked.Config = map[string]interface{}{"filter/lists": []string{"TRAC", "MAL"}}
+	newLocked := &Schedule{ID: "new", Locked: true}
+
+	for _, test := range []struct {
+		name      string
+		schedules []*Schedule
+		permitted bool
+	}{
+		{"unchanged", []*Schedule{locked, unlocked}, true},
+		{"unlocked removed", []*Schedule{locked}, true},
+		{"locked removed", []*Schedule{unlocked}, false},
+		{"locked changed", []*Schedule{&changedLocked, unlocked}, false},
+		{"locked added", []*Schedule{locked, unlocked, newLocked}, false},
+	} {
+		err := checkLockedSchedules(current, test.schedules)
+		if (err == nil) != test.permitted {
+			t.Errorf("%s: expected permitted=%v, got %v", test.name, test.permitted, err)
+		}
+	}
+}
+
+func TestScheduleNotStored(t *testing.T) {
+	// Activate a schedule for the profile for the next hour.
+	now := time.Now()
+	minute := now.Hour()*60 + now.Minute()
+	s := &Schedule{
+		ID:     "test",
+		Config: map[string]interface{}{CfgOptionEndpointsKey: []string{"- scheduled.example.com"}},
+		start:  (minute + 1440 - 60) % 1440,
+		end:    (minute + 60) % 1440,
+	}
+	schedulesLock.Lock()
+	schedules["local/test"] = &ProfileSchedules{Schedules: []*Schedule{s}}
+	schedulesLock.Unlock()
+	defer func() {
+		schedulesLock.Lock()
+		delete(schedules, "local/test")
+		schedulesLock.Unlock()
+	}()
+
+	profile := &Profile{
+		ID:     "test",
+		Source: SourceLocal,
+		Config: config.Expand(map[string]interface{}{
+			CfgOptionEndpointsKey: []interface{}{"+ example.com"},
+		}),
+	}
+	if err := profile.prepConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if profile.activeScheduleID != "test" {
+		t.Fatalf("schedule was not applied: %q", profile.activeScheduleID)
+	}
+
+	// Edits must be based on the stored rules and keep the schedule applied.
+	if rules := profile.EndpointRules(); !reflect.DeepEqual(rules, []string{"+ example.com"}) {
+		t.Errorf("unexpected stored rules: %v", rules)
+	}
+	profile.Lock()
+	config.PutValueIntoHierarchicalConfig(profile.Config, CfgOptionEndpointsKey, []string{"+ new.example.com", "+ example.com"})
+	err := profile.reloadConfig()
+	profile.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.activeScheduleID != "test" {
+		t.Errorf("schedule was not applied after reload: %q", profile.activeScheduleID)
+	}
+	if rules := profile.EndpointRules(); !reflect.DeepEqual(rules, []string{"+ new.example.com", "+ example.com"}) {
+		t.Errorf("unexpected stored rules after edit: %v", rules)
+	}
+}
@@ -0,0 +1,172 @@
+package profile
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portbase/log"
+)
+
+// TaggedProfile is a short description of a profile with its tags.
+type TaggedProfile struct {
+	ID         string
+	Source     string
+	Name       string
+	LinkedPath string
+	Tags       []string
+}
+
+// BulkEdit describes changes to apply to many profiles at once. Profiles are
+// selected by tag and by their scoped IDs.
+type BulkEdit struct {
+	// Tag selects all profiles with the given tag.
+	Tag string
+	// Profiles selects the profiles with the given scoped IDs.
+	Profiles []string
+
+	// AddTags holds the tags to add to the selected profiles.
+	AddTags []string
+	// RemoveTags holds the tags to remove from the selected profiles.
+	RemoveTags []string
+	// Template holds the ID of a template to apply to the selected profiles.
+	Template string
+	// Strategy defines how the template is applied, see ApplyTemplate.
+	Strategy string
+}
+
+// BulkEditResult holds the result of a bulk edit.
+type BulkEditResult struct {
+	// Updated holds the scoped IDs of the updated profiles.
+	Updated []string
+	// Failed holds the scoped IDs of the profiles that could not be updated
+	// and the reason.
+	Failed []string
+}
+
+// GetProfilesByTag returns all profiles with the given tag.
+func GetProfilesByTag(tag string) ([]*TaggedProfile, error) {
+	tags := normalizeTags([]string{tag})
+	if len(tags) == 0 {
+		return nil, errors.New("missing tag")
+	}
+	tag = tags[0]
+
+	it, err := profileDB.Query(query.New(profilesDBPath))
+	if err != nil {
+		return nil, err
+	}
+
+	var profiles []*TaggedProfile
+	for r := range it.Next {
+		profile, err := EnsureProfile(r)
+		if err != nil {
+			continue
+		}
+
+		profile.Lock()
+		if hasTag(normalizeTags(profile.Tags), tag) {
+			profiles = append(profiles, &TaggedProfile{
+				ID:         profile.ID,
+				Source:     string(profile.Source),
+				Name:       profile.Name,
+				LinkedPath: profile.LinkedPath,
+				Tags:       append([]string(nil), profile.Tags...),
+			})
+		}
+		profile.Unlock()
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Name < profiles[j].Name
+	})
+	return profiles, nil
+}
+
+// BulkEditProfiles applies the given changes to all selected profiles.
+func BulkEditProfiles(edit *BulkEdit) (*BulkEditResult, error) {
+	addTags := normalizeTags(edit.AddTags)
+	removeTags := normalizeTags(edit.RemoveTags)
+	if len(addTags) == 0 && len(removeTags) == 0 && edit.Template == "" {
+		return nil, errors.New("nothing to change")
+	}
+	if edit.Template != "" {
+		if _, err := GetTemplate(edit.Template); err != nil {
+			return nil, err
+		}
+	}
+
+	// Select profiles.
+	scopedIDs := make(map[string]struct{}, len(edit.Profiles))
+	for _, scopedID := range edit.Profiles {
+		scopedIDs[scopedID] = struct{}{}
+	}
+	if edit.Tag != "" {
+		tagged, err := GetProfilesByTag(edit.Tag)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range tagged {
+			scopedIDs[makeScopedID(profileSource(p.Source), p.ID)] = struct{}{}
+		}
+	}
+	if len(scopedIDs) == 0 {
+		return nil, errors.New("no profiles selected")
+	}
+
+	result := &BulkEditResult{}
+	for scopedID := range scopedIDs {
+		if err := editProfile(scopedID, addTags, removeTags, edit.Template, edit.Strategy); err != nil {
+			log.Warningf("profile: failed to bulk edit %s: %s", scopedID, err)
+			result.Failed = append(result.Failed, fmt.Sprintf("%s: %s", scopedID, err))
+			continue
+		}
+		result.Updated = append(result.Updated, scopedID)
+	}
+
+	sort.Strings(result.Updated)
+	sort.Strings(result.Failed)
+	return result, nil
+}
+
+// editProfile applies the changes of a bulk edit to the profile with the
+// given scoped ID.
+func editProfile(scopedID string, addTags, removeTags []string, templateID, strategy string) error {
+	if templateID != "" {
+		if err := ApplyTemplate(scopedID, templateID, strategy); err != nil {
+			return err
+		}
+	}
+	if len(addTags) == 0 && len(removeTags) == 0 {
+		return nil
+	}
+
+	profile, err := getProfile(scopedID)
+	if err != nil {
+		return fmt.Errorf("failed to get profile: %w", err)
+	}
+
+	profile.Lock()
+	profile.Tags = editTags(profile.Tags, addTags, removeTags)
+	profile.LastEdited = time.Now().Unix()
+	profile.Unlock()
+
+	return profile.Save()
+}
+
+// editTags returns the given tags with the tags to add and without the tags
+// to remove. The tags to add and remove must be normalized.
+func editTags(tags, addTags, removeTags []string) []string {
+	edited := make([]string, 0, len(tags)+len(addTags))
+	for _, tag := range normalizeTags(tags) {
+		if !hasTag(removeTags, tag) {
+			edited = append(edited, tag)
+		}
+	}
+	return normalizeTags(append(edited, addTags...))
+}
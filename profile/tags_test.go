@@ -0,0 +1,52 @@
+package profile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEditTags(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		tags       []string
+		addTags    []string
+		removeTags []string
+		expected   []string
+	}{
+		{
+			name:     "add to empty",
+			addTags:  []string{"games"},
+			expected: []string{"games"},
+		},
+		{
+			name:     "add existing",
+			tags:     []string{"Games", "work"},
+			addTags:  []string{"games"},
+			expected: []string{"games", "work"},
+		},
+		{
+			name:       "remove",
+			tags:       []string{"games", "work"},
+			removeTags: []string{"work"},
+			expected:   []string{"games"},
+		},
+		{
+			name:       "add and remove",
+			tags:       []string{"games", " Work "},
+			addTags:    []string{"chat"},
+			removeTags: []string{"games", "unknown"},
+			expected:   []string{"chat", "work"},
+		},
+		{
+			name:       "remove all",
+			tags:       []string{"games"},
+			removeTags: []string{"games"},
+			expected:   []string{},
+		},
+	} {
+		edited := editTags(test.tags, test.addTags, test.removeTags)
+		if !reflect.DeepEqual(edited, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, edited)
+		}
+	}
+}
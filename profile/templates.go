@@ -0,0 +1,334 @@
+package profile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/updater"
+	"github.com/safing/portmaster/updates"
+)
+
+// Templates hold curated settings, such as for browsers or servers, that can
+// be applied to profiles. Templates are shipped via updates or created
+// locally. Templates may list the applications they are meant for, in which
+// case they are applied to new profiles of these applications.
+
+const (
+	templatesDBPath     = "core:profile-templates/"
+	templatesIdentifier = "profile/templates.json"
+)
+
+var (
+	// ErrTemplateShipped is returned when a shipped template is changed.
+	ErrTemplateShipped = errors.New("shipped templates cannot be changed")
+
+	templateIDRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+	shippedTemplates    map[string]*Template
+	shippedTemplateFile *updater.File
+	localTemplates      = make(map[string]*Template)
+	templatesLock       sync.RWMutex
+)
+
+// Template holds curated settings that can be applied to profiles.
+type Template struct {
+	record.Base
+	sync.Mutex
+
+	// ID is a unique identifier of the template, eg. "browser".
+	ID string
+	// Name is a human readable name of the template.
+	Name string
+	// Description describes the purpose of the template.
+	Description string `json:",omitempty"`
+	// Apps optionally holds the names of the binaries, without extension, of
+	// the applications the template is applied to when their profile is
+	// created.
+	Apps []string `json:",omitempty"`
+	// Config holds the settings of the template in the flat (key=value) form.
+	Config map[string]interface{}
+	// Shipped is set for templates delivered via updates.
+	Shipped bool `json:",omitempty"`
+}
+
+// loadTemplates loads the local templates from the database and the shipped
+// templates from the updates.
+func loadTemplates() error {
+	loadShippedTemplates(false)
+
+	it, err := profileDB.Query(query.New(templatesDBPath))
+	if err != nil {
+		return err
+	}
+
+	templatesLock.Lock()
+	defer templatesLock.Unlock()
+
+	for r := range it.Next {
+		t := &Template{}
+		if err := record.Unwrap(r, t); err != nil {
+			log.Warningf("profile: failed to parse template %s: %s", r.Key(), err)
+			continue
+		}
+		localTemplates[t.ID] = t
+	}
+	return it.Err()
+}
+
+func loadShippedTemplates(onlyIfUpgraded bool) {
+	templatesLock.RLock()
+	if onlyIfUpgraded && (shippedTemplateFile == nil || !shippedTemplateFile.UpgradeAvailable()) {
+		templatesLock.RUnlock()
+		return
+	}
+	templatesLock.RUnlock()
+
+	file, err := updates.GetFile(templatesIdentifier)
+	if err != nil {
+		log.Debugf("profile: failed to get shipped templates: %s", err)
+		return
+	}
+	loaded, err := parseShippedTemplates(file.Path())
+	if err != nil {
+		log.Warningf("profile: %s", err)
+		return
+	}
+
+	templatesLock.Lock()
+	shippedTemplates = loaded
+	shippedTemplateFile = file
+	templatesLock.Unlock()
+
+	log.Infof("profile: loaded %d shipped templates", len(loaded))
+}
+
+func parseShippedTemplates(path string) (map[string]*Template, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read shipped templates: %w", err)
+	}
+	var list []*Template
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse shipped templates: %w", err)
+	}
+
+	loaded := make(map[string]*Template, len(list))
+	for _, t := range list {
+		if err := t.check(); err != nil {
+			log.Warningf("profile: ignoring invalid shipped template %q: %s", t.ID, err)
+			continue
+		}
+		t.Shipped = true
+		loaded[t.ID] = t
+	}
+	return loaded, nil
+}
+
+// check validates the template and normalizes its settings.
+func (t *Template) check() error {
+	if !templateIDRegex.MatchString(t.ID) {
+		return fmt.Errorf("invalid template ID %q", t.ID)
+	}
+	if t.Name == "" {
+		return errors.New("missing name")
+	}
+	settings, err := normalizePortableSettings(t.Config)
+	if err != nil {
+		return err
+	}
+	t.Config = settings
+	return nil
+}
+
+// GetTemplates returns all shipped and local templates, sorted by name.
+func GetTemplates() []*Template {
+	templatesLock.RLock()
+	defer templatesLock.RUnlock()
+
+	list := make([]*Template, 0, len(shippedTemplates)+len(localTemplates))
+	for _, t := range shippedTemplates {
+		list = append(list, t)
+	}
+	for id, t := range localTemplates {
+		if _, ok := shippedTemplates[id]; !ok {
+			list = append(list, t)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.ToLower(list[i].Name) < strings.ToLower(list[j].Name)
+	})
+	return list
+}
+
+// GetTemplate returns the template with the given ID. Shipped templates take
+// precedence over local templates.
+func GetTemplate(id string) (*Template, error) {
+	templatesLock.RLock()
+	defer templatesLock.RUnlock()
+
+	if t, ok := shippedTemplates[id]; ok {
+		return t, nil
+	}
+	if t, ok := localTemplates[id]; ok {
+		return t, nil
+	}
+	return nil, fmt.Errorf("template %q: %w", id, database.ErrNotFound)
+}
+
+// SaveTemplate saves a local template.
+func SaveTemplate(t *Template) error {
+	t.Shipped = false
+	if err := t.check(); err != nil {
+		return err
+	}
+
+	templatesLock.Lock()
+	defer templatesLock.Unlock()
+
+	if _, ok := shippedTemplates[t.ID]; ok {
+		return ErrTemplateShipped
+	}
+
+	t.SetKey(templatesDBPath + t.ID)
+	if err := profileDB.Put(t); err != nil {
+		return err
+	}
+	localTemplates[t.ID] = t
+	return nil
+}
+
+// DeleteTemplate deletes the local template with the given ID.
+func DeleteTemplate(id string) error {
+	templatesLock.Lock()
+	defer templatesLock.Unlock()
+
+	if _, ok := shippedTemplates[id]; ok {
+		return ErrTemplateShipped
+	}
+	if _, ok := localTemplates[id]; !ok {
+		return fmt.Errorf("template %q: %w", id, database.ErrNotFound)
+	}
+
+	if err := profileDB.Delete(templatesDBPath + id); err != nil {
+		return err
+	}
+	delete(localTemplates, id)
+	return nil
+}
+
+// ApplyTemplate applies the template with the given ID to the profile with
+// the given scoped ID. With the merge strategy, the rules of the template are
+// added on top of the existing rules and other settings are only set if they
+// are not set yet. With the overwrite strategy, the settings of the template
+// replace the existing values. Settings that are not part of the template are
+// kept with both strategies.
+func ApplyTemplate(scopedID, templateID, strategy string) error {
+	switch strategy {
+	case ImportStrategyMerge, ImportStrategyOverwrite:
+	case "":
+		strategy = ImportStrategyMerge
+	default:
+		return fmt.Errorf("unknown strategy %q", strategy)
+	}
+
+	t, err := GetTemplate(templateID)
+	if err != nil {
+		return err
+	}
+	profile, err := getProfile(scopedID)
+	if err != nil {
+		return fmt.Errorf("failed to get profile: %w", err)
+	}
+	if profile.Source != SourceLocal {
+		return fmt.Errorf("profile %s is not a local profile", scopedID)
+	}
+
+	profile.Lock()
+
+	profile.Config = config.Expand(applyTemplateSettings(config.Flatten(profile.Config), t.Config, strategy))
+	profile.LastEdited = time.Now().Unix()
+
+	// Reload the profile config manually in order to apply the new settings.
+	if err := profile.reloadConfig(); err != nil {
+		profile.Unlock()
+		return err
+	}
+
+	profile.Unlock()
+
+	log.Infof("profile: applied template %s to %s", t.ID, profile)
+	return profile.Save()
+}
+
+func applyTemplateSettings(existing, settings map[string]interface{}, strategy string) map[string]interface{} {
+	if strategy == ImportStrategyMerge {
+		return mergePortableSettings(existing, settings)
+	}
+
+	applied := make(map[string]interface{}, len(existing)+len(settings))
+	for key, value := range existing {
+		applied[key] = value
+	}
+	for key, value := range settings {
+		applied[key] = value
+	}
+	return applied
+}
+
+// templateForApp returns the template for new profiles of the application
+// with the given path, if any. Local templates take precedence over shipped
+// templates.
+func templateForApp(linkedPath string) *Template {
+	app := strings.ToLower(filepath.Base(linkedPath))
+	app = strings.TrimSuffix(app, ".exe")
+
+	templatesLock.RLock()
+	defer templatesLock.RUnlock()
+
+	for _, templates := range []map[string]*Template{localTemplates, shippedTemplates} {
+		// Check in a stable order.
+		ids := make([]string, 0, len(templates))
+		for id := range templates {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		for _, id := range ids {
+			for _, templateApp := range templates[id].Apps {
+				if strings.EqualFold(templateApp, app) {
+					return templates[id]
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// newProfileConfig returns the initial config of a new profile of the
+// application with the given path.
+func newProfileConfig(linkedPath string) map[string]interface{} {
+	t := templateForApp(linkedPath)
+	if t == nil {
+		return nil
+	}
+
+	log.Infof("profile: applying template %s to new profile of %s", t.ID, linkedPath)
+	settings := make(map[string]interface{}, len(t.Config))
+	for key, value := range t.Config {
+		settings[key] = value
+	}
+	return settings
+}
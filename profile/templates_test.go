@@ -0,0 +1,65 @@
+package profile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyTemplateSettings(t *testing.T) {
+	existing := map[string]interface{}{
+		"filter/endpoints":     []string{"- example.org"},
+		"filter/defaultAction": "permit",
+		"filter/blockLAN":      int64(0),
+	}
+	settings := map[string]interface{}{
+		"filter/endpoints":     []string{"+ LAN"},
+		"filter/defaultAction": "block",
+	}
+
+	merged := applyTemplateSettings(existing, settings, ImportStrategyMerge)
+	if expected := []string{"+ LAN", "- example.org"}; !reflect.DeepEqual(merged["filter/endpoints"], expected) {
+		t.Errorf("unexpected merged rules: %v", merged["filter/endpoints"])
+	}
+	if merged["filter/defaultAction"] != "permit" {
+		t.Errorf("merge must keep existing settings, got %v", merged["filter/defaultAction"])
+	}
+
+	overwritten := applyTemplateSettings(existing, settings, ImportStrategyOverwrite)
+	if expected := []string{"+ LAN"}; !reflect.DeepEqual(overwritten["filter/endpoints"], expected) {
+		t.Errorf("unexpected overwritten rules: %v", overwritten["filter/endpoints"])
+	}
+	if overwritten["filter/defaultAction"] != "block" || overwritten["filter/blockLAN"] != int64(0) {
+		t.Errorf("overwrite must replace the template settings and keep others, got %v", overwritten)
+	}
+}
+
+func TestTemplateForApp(t *testing.T) {
+	templatesLock.Lock()
+	shippedTemplates = map[string]*Template{
+		"browser": {ID: "browser", Name: "Browser", Apps: []string{"firefox", "chrome"}},
+	}
+	localTemplates = map[string]*Template{
+		"my-firefox": {ID: "my-firefox", Name: "My Firefox", Apps: []string{"Firefox"}},
+	}
+	templatesLock.Unlock()
+	defer func() {
+		templatesLock.Lock()
+		shippedTemplates = nil
+		localTemplates = make(map[string]*Template)
+		templatesLock.Unlock()
+	}()
+
+	for path, expected := range map[string]string{
+		"/usr/lib/firefox/firefox":      "my-firefox",
+		"/opt/google/chrome/chrome.exe": "browser",
+		"/usr/bin/curl":                 "",
+	} {
+		var id string
+		if tpl := templateForApp(path); tpl != nil {
+			id = tpl.ID
+		}
+		if id != expected {
+			t.Errorf("unexpected template for %s: got %q, expected %q", path, id, expected)
+		}
+	}
+}
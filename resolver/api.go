@@ -35,7 +35,7 @@ func registerAPI() error {
 		Read:      api.PermitUser,
 		BelongsTo: module,
 		RecordFunc: func(r *api.Request) (record.Record, error) {
-			return recordDatabase.Get(nameRecordsKeyPrefix + r.URLVars["query"])
+			return recordDatabase.DB().Get(nameRecordsKeyPrefix + r.URLVars["query"])
 		},
 		Name:        "Get DNS Record from Cache",
 		Description: "Returns cached dns records from the internal cache.",
@@ -55,6 +55,11 @@ func registerAPI() error {
 type resolverExport struct {
 	*Resolver
 	Failing bool
+	// FailureRate holds the share of failed queries within the time window
+	// of the error budget.
+	FailureRate float64
+	// ErrorBudgetExceeded is set if too many queries failed recently.
+	ErrorBudgetExceeded bool
 }
 
 func exportDNSResolvers(*api.Request) (interface{}, error) {
@@ -63,9 +68,12 @@ func exportDNSResolvers(*api.Request) (interface{}, error) {
 
 	export := make([]resolverExport, 0, len(globalResolvers))
 	for _, r := range globalResolvers {
+		failureRate, exceeded := getFailureRate(r.Info.ID())
 		export = append(export, resolverExport{
-			Resolver: r,
-			Failing:  r.Conn.IsFailing(),
+			Resolver:            r,
+			Failing:             r.Conn.IsFailing(),
+			FailureRate:         failureRate,
+			ErrorBudgetExceeded: exceeded,
 		})
 	}
 
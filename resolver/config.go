@@ -76,6 +76,22 @@
 	CfgOptionNameserverRetryRateKey   = "dns/nameserverRetryRate"
 	nameserverRetryRate               config.IntOption
 	cfgOptionNameserverRetryRateOrder = 32
+
+	CfgOptionPrefetchKey   = "dns/prefetch"
+	prefetchEnabled        config.BoolOption
+	cfgOptionPrefetchOrder = 33
+
+	CfgOptionMinCacheTTLKey   = "dns/minCacheTTL"
+	minCacheTTL               config.IntOption
+	cfgOptionMinCacheTTLOrder = 34
+
+	CfgOptionMaxCacheTTLKey   = "dns/maxCacheTTL"
+	maxCacheTTL               config.IntOption
+	cfgOptionMaxCacheTTLOrder = 35
+
+	CfgOptionTTLOverridesKey   = "dns/ttlOverrides"
+	configuredTTLOverrides     config.StringArrayOption
+	cfgOptionTTLOverridesOrder = 36
 )
 
 func prepConfig() error {
@@ -262,6 +278,86 @@ func prepConfig() error {
 	}
 	dontResolveSpecialDomains = status.SecurityLevelOption(CfgOptionDontResolveSpecialDomainsKey)
 
+	err = config.Register(&config.Option{
+		Name:           "Prefetch Frequent Domains",
+		Key:            CfgOptionPrefetchKey,
+		Description:    "Refresh cached DNS records of frequently queried domains shortly before they expire. This reduces delays in interactive apps, but causes some additional DNS queries.",
+		OptType:        config.OptTypeBool,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   false,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionPrefetchOrder,
+			config.CategoryAnnotation:     "Resolving",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	prefetchEnabled = config.Concurrent.GetAsBool(CfgOptionPrefetchKey, false)
+
+	err = config.Register(&config.Option{
+		Name:           "Minimum Cache Time",
+		Key:            CfgOptionMinCacheTTLKey,
+		Description:    "Cache DNS answers for at least this time, even if the DNS server allows a shorter time. Higher values reduce DNS queries and work around services that do not allow caching at all.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   minTTL,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionMinCacheTTLOrder,
+			config.UnitAnnotation:         "seconds",
+			config.CategoryAnnotation:     "Resolving",
+		},
+		ValidationRegex: `^[0-9]{1,6}$`,
+	})
+	if err != nil {
+		return err
+	}
+	minCacheTTL = config.Concurrent.GetAsInt(CfgOptionMinCacheTTLKey, minTTL)
+
+	err = config.Register(&config.Option{
+		Name:           "Maximum Cache Time",
+		Key:            CfgOptionMaxCacheTTLKey,
+		Description:    "Cache DNS answers for at most this time, even if the DNS server allows a longer time. Lower values reduce how long visited domains are retained in the cache.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelExpert,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   maxTTL,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionMaxCacheTTLOrder,
+			config.UnitAnnotation:         "seconds",
+			config.CategoryAnnotation:     "Resolving",
+		},
+		ValidationRegex: `^[0-9]{1,6}$`,
+	})
+	if err != nil {
+		return err
+	}
+	maxCacheTTL = config.Concurrent.GetAsInt(CfgOptionMaxCacheTTLKey, maxTTL)
+
+	err = config.Register(&config.Option{
+		Name:        "Cache Time Overrides",
+		Key:         CfgOptionTTLOverridesKey,
+		Description: "Override the minimum and maximum cache time for certain domains.",
+		Help: strings.ReplaceAll(`Every entry consists of a domain and the allowed cache time range in seconds, eg. "example.com 300-3600". A leading dot also matches all subdomains, eg. ".example.com 0-60". The first matching entry is used.
+
+Answers forwarded to apps are never cached by them for longer than by the Portmaster.`, `"`, "`"),
+		OptType:         config.OptTypeStringArray,
+		ExpertiseLevel:  config.ExpertiseLevelExpert,
+		ReleaseLevel:    config.ReleaseLevelExperimental,
+		DefaultValue:    []string{},
+		ValidationRegex: `^\.?[A-z0-9\-\.]+ [0-9]{1,6}-[0-9]{1,6}$`,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: cfgOptionTTLOverridesOrder,
+			config.CategoryAnnotation:     "Resolving",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	configuredTTLOverrides = config.Concurrent.GetAsStringArray(CfgOptionTTLOverridesKey, []string{})
+
 	return nil
 }
 
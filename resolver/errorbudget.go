@@ -0,0 +1,298 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/portbase/notifications"
+	"github.com/safing/portmaster/netenv"
+	"github.com/safing/portmaster/status"
+)
+
+// Every upstream resolver has an error budget: Failed queries are counted
+// within a sliding time window. If all configured resolvers exceed their
+// budget for some time, the user is notified and offered to fall back to
+// other resolvers, instead of the Portmaster silently falling back to the
+// system resolvers or failing.
+
+const (
+	// errorBudgetBuckets is the amount of one minute buckets that make up the
+	// time window of the error budget.
+	errorBudgetBuckets = 10
+
+	// errorBudgetMinQueries is the minimum amount of queries within the time
+	// window needed to judge a resolver.
+	errorBudgetMinQueries = 10
+
+	// errorBudgetMaxFailureRate is the failure rate above which a resolver
+	// exceeds its error budget.
+	errorBudgetMaxFailureRate = 0.5
+
+	// errorBudgetPersistence is the amount of consecutive checks in which the
+	// error budget must be exceeded before the user is notified.
+	errorBudgetPersistence = 3
+
+	errorBudgetCheckInterval = time.Minute
+
+	errorBudgetNotificationID = "resolver:error-budget-exceeded"
+)
+
+var (
+	upstreamStats     = make(map[string]*upstreamStat)
+	upstreamStatsLock sync.Mutex
+
+	budgetExceededChecks int
+	budgetNotification   *notifications.Notification
+	budgetIgnored        bool
+	budgetLock           sync.Mutex
+)
+
+type budgetBucket struct {
+	minute   int64
+	queries  int
+	failures int
+}
+
+// upstreamStat holds the query results of an upstream resolver in per-minute
+// buckets.
+type upstreamStat struct {
+	buckets [errorBudgetBuckets]budgetBucket
+}
+
+func (us *upstreamStat) add(now time.Time, failed bool) {
+	minute := now.Unix() / 60
+	bucket := &us.buckets[minute%errorBudgetBuckets]
+	if bucket.minute != minute {
+		*bucket = budgetBucket{minute: minute}
+	}
+
+	bucket.queries++
+	if failed {
+		bucket.failures++
+	}
+}
+
+// sum returns the queries and failures within the time window.
+func (us *upstreamStat) sum(now time.Time) (queries, failures int) {
+	minute := now.Unix() / 60
+	for _, bucket := range us.buckets {
+		if bucket.minute > minute-errorBudgetBuckets && bucket.minute <= minute {
+			queries += bucket.queries
+			failures += bucket.failures
+		}
+	}
+	return queries, failures
+}
+
+// exceeded returns whether the error budget is exceeded and the failure rate.
+func (us *upstreamStat) exceeded(now time.Time) (exceeded bool, failureRate float64) {
+	queries, failures := us.sum(now)
+	if queries < errorBudgetMinQueries {
+		return false, 0
+	}
+	failureRate = float64(failures) / float64(queries)
+	return failureRate > errorBudgetMaxFailureRate, failureRate
+}
+
+// recordUpstreamResult records the result of a query to the given resolver.
+func recordUpstreamResult(resolver *Resolver, failed bool) {
+	if failed && !netenv.Online() {
+		// Failures while offline are not the fault of the resolver.
+		return
+	}
+
+	upstreamStatsLock.Lock()
+	defer upstreamStatsLock.Unlock()
+
+	us, ok := upstreamStats[resolver.Info.ID()]
+	if !ok {
+		us = &upstreamStat{}
+		upstreamStats[resolver.Info.ID()] = us
+	}
+	us.add(time.Now(), failed)
+}
+
+// getFailureRate returns the failure rate of the resolver with the given ID
+// within the time window and whether its error budget is exceeded.
+func getFailureRate(resolverID string) (failureRate float64, exceeded bool) {
+	upstreamStatsLock.Lock()
+	defer upstreamStatsLock.Unlock()
+
+	us, ok := upstreamStats[resolverID]
+	if !ok {
+		return 0, false
+	}
+	exceeded, failureRate = us.exceeded(time.Now())
+	return failureRate, exceeded
+}
+
+// configuredResolversFailing returns whether all configured resolvers are
+// failing and at least one of them exceeded its error budget. It also returns
+// the names of the failing resolvers.
+func configuredResolversFailing() (failing bool, names []string) {
+	resolversLock.RLock()
+	defer resolversLock.RUnlock()
+
+	var anyExceeded bool
+	for _, resolver := range globalResolvers {
+		if resolver.Info.Source != ServerSourceConfigured {
+			continue
+		}
+
+		failureRate, exceeded := getFailureRate(resolver.Info.ID())
+		switch {
+		case exceeded:
+			anyExceeded = true
+			names = append(names, fmt.Sprintf("%s (%.0f%% failed)", resolver.Info.DescriptiveName(), failureRate*100))
+		case resolver.Conn.IsFailing():
+			names = append(names, resolver.Info.DescriptiveName())
+		default:
+			return false, nil
+		}
+	}
+
+	return anyExceeded, names
+}
+
+func checkErrorBudget(_ context.Context, _ *modules.Task) error {
+	failing, names := configuredResolversFailing()
+	if !netenv.Online() {
+		failing = false
+	}
+
+	budgetLock.Lock()
+	defer budgetLock.Unlock()
+
+	if !failing {
+		budgetExceededChecks = 0
+		budgetIgnored = false
+		if budgetNotification != nil {
+			budgetNotification.Delete()
+			budgetNotification = nil
+			log.Info("resolver: configured DNS servers recovered")
+		}
+		return nil
+	}
+
+	budgetExceededChecks++
+	if budgetExceededChecks < errorBudgetPersistence || budgetNotification != nil || budgetIgnored {
+		return nil
+	}
+
+	log.Warningf("resolver: configured DNS servers are failing: %s", strings.Join(names, ", "))
+	budgetNotification = notifications.Notify(&notifications.Notification{
+		EventID: errorBudgetNotificationID,
+		Type:    notifications.Warning,
+		Title:   "Configured DNS Servers Are Failing",
+		Message: fmt.Sprintf(
+			"Your configured DNS servers have been failing for the last minutes: %s. DNS requests are currently slow or fail. If the network blocks secure DNS, you can fall back to plain DNS with the same servers or use the DNS servers of your system or network instead.",
+			strings.Join(names, ", "),
+		),
+		ShowOnSystem: true,
+		AvailableActions: []*notifications.Action{
+			{
+				ID:   "plain",
+				Text: "Use Plain DNS",
+			},
+			{
+				ID:   "system",
+				Text: "Use System DNS",
+			},
+			{
+				Text: "Open Settings",
+				Type: notifications.ActionTypeOpenSetting,
+				Payload: &notifications.ActionTypeOpenSettingPayload{
+					Key: CfgOptionNameServersKey,
+				},
+			},
+			{
+				ID:   "ignore",
+				Text: "Ignore",
+			},
+		},
+	})
+	budgetNotification.SetActionFunction(errorBudgetActionHandler)
+	budgetNotification.AttachToModule(module)
+
+	return nil
+}
+
+func errorBudgetActionHandler(_ context.Context, n *notifications.Notification) error {
+	var err error
+	switch n.SelectedActionID {
+	case "plain":
+		log.Info("resolver: user selected to fall back to plain DNS")
+		err = fallbackToPlainDNS()
+	case "system":
+		log.Info("resolver: user selected to fall back to system DNS")
+		err = fallbackToSystemDNS()
+	case "ignore":
+		budgetLock.Lock()
+		budgetIgnored = true
+		budgetLock.Unlock()
+	}
+	if err != nil {
+		log.Warningf("resolver: failed to apply DNS fallback: %s", err)
+		return err
+	}
+
+	budgetLock.Lock()
+	defer budgetLock.Unlock()
+
+	n.Delete()
+	if budgetNotification == n {
+		budgetNotification = nil
+		budgetExceededChecks = 0
+	}
+	return nil
+}
+
+// fallbackToPlainDNS switches the configured DNS-over-TLS servers to plain DNS
+// and allows insecure protocols.
+func fallbackToPlainDNS() error {
+	servers := configuredNameServers()
+	plain := make([]string, 0, len(servers))
+	for _, server := range servers {
+		plain = append(plain, plainServerURL(server))
+	}
+
+	if err := config.SetConfigOption(CfgOptionNoInsecureProtocolsKey, status.SecurityLevelOff); err != nil {
+		return err
+	}
+	return config.SetConfigOption(CfgOptionNameServersKey, plain)
+}
+
+// fallbackToSystemDNS removes the configured DNS servers, so that the DNS
+// servers assigned by the system or network are used.
+func fallbackToSystemDNS() error {
+	if err := config.SetConfigOption(CfgOptionNoAssignedNameserversKey, status.SecurityLevelOff); err != nil {
+		return err
+	}
+	return config.SetConfigOption(CfgOptionNameServersKey, []string{})
+}
+
+// plainServerURL returns the plain DNS equivalent of the given DNS-over-TLS
+// server URL. Other URLs are returned unchanged.
+func plainServerURL(serverURL string) string {
+	u, err := url.Parse(serverURL)
+	if err != nil || u.Scheme != ServerTypeDoT {
+		return serverURL
+	}
+
+	query := u.Query()
+	query.Del("verify")
+
+	u.Scheme = ServerTypeDNS
+	u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(53))
+	u.RawQuery = query.Encode()
+	return u.String()
+}
@@ -0,0 +1,58 @@
+package resolver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUpstreamStatErrorBudget(t *testing.T) {
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	us := &upstreamStat{}
+
+	// Not enough queries to judge.
+	for i := 0; i < errorBudgetMinQueries-1; i++ {
+		us.add(start, true)
+	}
+	if exceeded, _ := us.exceeded(start); exceeded {
+		t.Error("budget should not be exceeded with too few queries")
+	}
+
+	// Enough failed queries.
+	us.add(start.Add(time.Minute), true)
+	exceeded, failureRate := us.exceeded(start.Add(time.Minute))
+	if !exceeded || failureRate != 1 {
+		t.Errorf("budget should be exceeded, failure rate is %f", failureRate)
+	}
+
+	// Successful queries recover the budget.
+	for i := 0; i < errorBudgetMinQueries; i++ {
+		us.add(start.Add(2*time.Minute), false)
+	}
+	if exceeded, failureRate := us.exceeded(start.Add(2 * time.Minute)); exceeded {
+		t.Errorf("budget should not be exceeded, failure rate is %f", failureRate)
+	}
+
+	// Old queries leave the time window.
+	queries, failures := us.sum(start.Add(errorBudgetBuckets * time.Minute))
+	if queries != errorBudgetMinQueries+1 || failures != 1 {
+		t.Errorf("unexpected sum after first minute left the window: %d queries, %d failures", queries, failures)
+	}
+	queries, _ = us.sum(start.Add(time.Hour))
+	if queries != 0 {
+		t.Errorf("all queries should have left the window, got %d", queries)
+	}
+}
+
+func TestPlainServerURL(t *testing.T) {
+	tests := map[string]string{
+		"dot://9.9.9.9:853?verify=dns.quad9.net&name=Quad9&blockedif=empty": "dns://9.9.9.9:53?blockedif=empty&name=Quad9",
+		"dot://[2620:fe::fe]:853?verify=dns.quad9.net":                      "dns://[2620:fe::fe]:53",
+		"dns://1.1.1.1?name=Cloudflare":                                     "dns://1.1.1.1?name=Cloudflare",
+		"tcp://1.1.1.1:53":                                                  "tcp://1.1.1.1:53",
+	}
+	for in, expected := range tests {
+		if out := plainServerURL(in); out != expected {
+			t.Errorf("plain URL of %s should be %s, got %s", in, expected, out)
+		}
+	}
+}
@@ -1,21 +1,34 @@
 package resolver
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/miekg/dns"
 
 	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/query"
 	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/caches"
 )
 
 const (
 	// IPInfoProfileScopeGlobal is the profile scope used for unscoped IPInfo entries.
 	IPInfoProfileScopeGlobal = "global"
+
+	// maxStaleDomainChecks defines how many expired domains of an IPInfo are
+	// checked against the DNS cache at most when looking for the domain of an
+	// IP.
+	maxStaleDomainChecks = 5
 )
 
 var (
-	ipInfoDatabase = database.NewInterface(&database.Options{
+	ipInfoDatabase = caches.NewDatabaseCache("ip info", 1, 512, &database.Options{
 		Local:    true,
 		Internal: true,
 
@@ -63,6 +76,31 @@ func (resolved *ResolvedDomain) String() string {
 	return ret + cnames
 }
 
+// Expired returns whether the DNS answer that resolved to the IP has expired.
+func (resolved *ResolvedDomain) Expired() bool {
+	return resolved.Expires < time.Now().Unix()
+}
+
+// revalidate returns whether the given IP is still part of the most recent
+// cached DNS answer for the domain.
+func (resolved *ResolvedDomain) revalidate(ip net.IP) bool {
+	qType := dns.Type(dns.TypeAAAA)
+	if ip.To4() != nil {
+		qType = dns.Type(dns.TypeA)
+	}
+
+	rrCache, err := GetRRCache(resolved.Domain, qType)
+	if err != nil {
+		return false
+	}
+	for _, answerIP := range rrCache.ExportAllARecords() {
+		if answerIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 // ResolvedDomains is a helper type for operating on a slice
 // of ResolvedDomain
 type ResolvedDomains []ResolvedDomain
@@ -124,13 +162,65 @@ func (info *IPInfo) MostRecentDomain() *ResolvedDomain {
 	return &mostRecent
 }
 
+// GetDomain returns the most recent domain that resolved to the IP and that
+// is still valid. Applications often keep DNS answers longer than their TTL,
+// so domains whose answer expired are still returned if the IP is part of the
+// latest cached DNS answer for that domain. This may have been refreshed by
+// another application in the meantime. If none of the expired domains can be
+// re-validated, for example because the IPs of a CDN rotated, the most recent
+// domain is returned.
+func (info *IPInfo) GetDomain() *ResolvedDomain {
+	info.Lock()
+	ip := net.ParseIP(info.IP)
+	domains := make(ResolvedDomains, len(info.ResolvedDomains))
+	copy(domains, info.ResolvedDomains)
+	info.Unlock()
+
+	// Prefer domains that have not yet expired.
+	for i := len(domains) - 1; i >= 0; i-- {
+		if !domains[i].Expired() {
+			return &domains[i]
+		}
+	}
+
+	// Then re-validate the most recent expired domains.
+	if ip != nil {
+		for i := len(domains) - 1; i >= 0 && i >= len(domains)-maxStaleDomainChecks; i-- {
+			if domains[i].revalidate(ip) {
+				return &domains[i]
+			}
+		}
+	}
+
+	// Fall back to the most recent domain.
+	if len(domains) == 0 {
+		return nil
+	}
+	return &domains[len(domains)-1]
+}
+
 func makeIPInfoKey(profileID, ip string) string {
 	return fmt.Sprintf("cache:intel/ipInfo/%s/%s", profileID, ip)
 }
 
+// ClearIPInfoCache clears all IP infos from the database and returns the
+// amount of cleared entries.
+func ClearIPInfoCache(ctx context.Context) (int, error) {
+	db := ipInfoDatabase.DB()
+	db.FlushCache()
+	db.ClearCache()
+	n, err := db.Purge(ctx, query.New("cache:intel/ipInfo/"))
+	if err != nil {
+		return 0, err
+	}
+
+	log.Debugf("resolver: cleared %d ip infos", n)
+	return n, nil
+}
+
 // GetIPInfo gets an IPInfo record from the database.
 func GetIPInfo(profileID, ip string) (*IPInfo, error) {
-	r, err := ipInfoDatabase.Get(makeIPInfoKey(profileID, ip))
+	r, err := ipInfoDatabase.DB().Get(makeIPInfoKey(profileID, ip))
 	if err != nil {
 		return nil, err
 	}
@@ -169,19 +259,21 @@ func (info *IPInfo) Save() error {
 	}
 
 	// Calculate and set cache expiry.
-	var expires int64 = 86400 // Minimum TTL of one day.
+	var expires int64
 	for _, rd := range info.ResolvedDomains {
 		if rd.Expires > expires {
 			expires = rd.Expires
 		}
 	}
 	info.UpdateMeta()
-	expires += 3600 // Add one hour to expiry as a buffer.
+	// Keep IP infos as long as name records, so that connections to IPs from
+	// expired DNS answers can be re-validated.
+	expires += databaseOvertime
 	info.Meta().SetAbsoluteExpiry(expires)
 
 	info.Unlock()
 
-	return ipInfoDatabase.Put(info)
+	return ipInfoDatabase.DB().Put(info)
 }
 
 // FmtDomains returns a string consisting of the domains that have seen to use this IP, joined by " or "
@@ -1,8 +1,11 @@
 package resolver
 
 import (
+	"net"
 	"testing"
+	"time"
 
+	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -42,3 +45,53 @@ func TestIPInfo(t *testing.T) {
 	info.AddDomain(subOverWrite)
 	assert.Equal(t, ResolvedDomains{example, sub2Example, subOverWrite}, info.ResolvedDomains)
 }
+
+func TestIPInfoGetDomain(t *testing.T) {
+	now := time.Now().Unix()
+	fresh := ResolvedDomain{
+		Domain:  "fresh.example.com.",
+		Expires: now + 60,
+	}
+	stale := ResolvedDomain{
+		Domain:  "stale.example.com.",
+		Expires: now - 60,
+	}
+	info := &IPInfo{
+		IP:              "192.0.2.1",
+		ResolvedDomains: ResolvedDomains{fresh, stale},
+	}
+
+	// Non-expired domains are preferred over more recent expired ones.
+	assert.Equal(t, &fresh, info.GetDomain())
+
+	// Expired domains whose IP is still in the DNS cache are preferred over
+	// more recent expired ones.
+	recent := ResolvedDomain{
+		Domain:  "recent.example.com.",
+		Expires: now - 30,
+	}
+	info.ResolvedDomains = ResolvedDomains{stale, recent}
+	assert.Equal(t, &recent, info.GetDomain())
+
+	rrCache := &RRCache{
+		Domain:   stale.Domain,
+		Question: dns.Type(dns.TypeA),
+		RCode:    dns.RcodeSuccess,
+		Answer: []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{Name: stale.Domain, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP("192.0.2.1"),
+		}},
+		Expires:  now - 60,
+		Resolver: &ResolverInfo{Type: ServerTypeDNS},
+	}
+	assert.NoError(t, rrCache.Save())
+	assert.Equal(t, &stale, info.GetDomain())
+
+	// The IP is not part of the cached answer anymore, fall back to the most
+	// recent domain.
+	info.IP = "192.0.2.2"
+	assert.Equal(t, &recent, info.GetDomain())
+
+	info.ResolvedDomains = nil
+	assert.Nil(t, info.GetDomain())
+}
@@ -9,6 +9,7 @@
 	"github.com/safing/portbase/log"
 	"github.com/safing/portbase/modules"
 	"github.com/safing/portmaster/intel"
+	"github.com/safing/portmaster/netenv"
 
 	// module dependencies
 	_ "github.com/safing/portmaster/core/base"
@@ -29,6 +30,10 @@ func prep() error {
 		return err
 	}
 
+	if err := registerPrefetchAPI(); err != nil {
+		return err
+	}
+
 	if err := prepEnvResolver(); err != nil {
 		return err
 	}
@@ -54,6 +59,33 @@ func(_ context.Context, _ interface{}) error {
 	if err != nil {
 		return err
 	}
+	err = module.RegisterEventHook(
+		"netenv",
+		netenv.NameserversChangedEvent,
+		"update nameservers",
+		func(_ context.Context, _ interface{}) error {
+			loadResolvers()
+			log.Debug("resolver: reloaded nameservers due to change of system nameservers")
+			return nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	// Parse the TTL overrides now and after every config change.
+	if err := loadTTLOverrides(module.Ctx, nil); err != nil {
+		return err
+	}
+	err = module.RegisterEventHook(
+		"config",
+		"config change",
+		"update TTL overrides",
+		loadTTLOverrides,
+	)
+	if err != nil {
+		return err
+	}
 
 	// reload after config change
 	prevNameservers := strings.Join(configuredNameServers(), " ")
@@ -84,6 +116,9 @@ func(_ context.Context, _ interface{}) error {
 
 	module.StartServiceWorker("name record delayed cache writer", 0, recordDatabase.DelayedCacheWriter)
 	module.StartServiceWorker("ip info delayed cache writer", 0, ipInfoDatabase.DelayedCacheWriter)
+	module.NewTask("clean recent answers", cleanRecentAnswers).Repeat(time.Minute)
+	module.NewTask("prefetch", prefetch).Repeat(prefetchInterval)
+	module.NewTask("check resolver error budget", checkErrorBudget).Repeat(errorBudgetCheckInterval)
 
 	return nil
 }
@@ -11,6 +11,7 @@
 	"github.com/safing/portbase/database/query"
 	"github.com/safing/portbase/database/record"
 	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/caches"
 )
 
 const (
@@ -20,7 +21,7 @@
 )
 
 var (
-	recordDatabase = database.NewInterface(&database.Options{
+	recordDatabase = caches.NewDatabaseCache("dns", 2, 1024, &database.Options{
 		Local:    true,
 		Internal: true,
 
@@ -73,7 +74,7 @@ func makeNameRecordKey(domain string, question string) string {
 func GetNameRecord(domain, question string) (*NameRecord, error) {
 	key := makeNameRecordKey(domain, question)
 
-	r, err := recordDatabase.Get(key)
+	r, err := recordDatabase.DB().Get(key)
 	if err != nil {
 		return nil, err
 	}
@@ -110,11 +111,12 @@ func GetNameRecord(domain, question string) (*NameRecord, error) {
 // ResetCachedRecord deletes a NameRecord from the cache database.
 func ResetCachedRecord(domain, question string) error {
 	// In order to properly delete an entry, we must also clear the caches.
-	recordDatabase.FlushCache()
-	recordDatabase.ClearCache()
+	db := recordDatabase.DB()
+	db.FlushCache()
+	db.ClearCache()
 
 	key := makeNameRecordKey(domain, question)
-	return recordDatabase.Delete(key)
+	return db.Delete(key)
 }
 
 // Save saves the NameRecord to the database.
@@ -127,31 +129,43 @@ func (rec *NameRecord) Save() error {
 	rec.UpdateMeta()
 	rec.Meta().SetAbsoluteExpiry(rec.Expires + databaseOvertime)
 
-	return recordDatabase.PutNew(rec)
+	return recordDatabase.DB().PutNew(rec)
 }
 
 // clearNameCache clears all dns caches from the database.
 func clearNameCache(ar *api.Request) (msg string, err error) {
 	log.Info("resolver: user requested dns cache clearing via action")
 
-	recordDatabase.FlushCache()
-	recordDatabase.ClearCache()
-	n, err := recordDatabase.Purge(ar.Context(), query.New(nameRecordsKeyPrefix))
+	n, err := ClearNameCache(ar.Context())
 	if err != nil {
 		return "", err
 	}
+	return fmt.Sprintf("cleared %d dns cache entries", n), nil
+}
+
+// ClearNameCache clears all dns caches from the database and returns the
+// amount of cleared entries.
+func ClearNameCache(ctx context.Context) (int, error) {
+	db := recordDatabase.DB()
+	db.FlushCache()
+	db.ClearCache()
+	n, err := db.Purge(ctx, query.New(nameRecordsKeyPrefix))
+	if err != nil {
+		return 0, err
+	}
 
 	log.Debugf("resolver: cleared %d entries from dns cache", n)
-	return fmt.Sprintf("cleared %d dns cache entries", n), nil
+	return n, nil
 }
 
 // DEPRECATED: remove in v0.7
 func clearNameCacheEventHandler(ctx context.Context, _ interface{}) error {
 	log.Debugf("resolver: dns cache clearing started...")
 
-	recordDatabase.FlushCache()
-	recordDatabase.ClearCache()
-	n, err := recordDatabase.Purge(ctx, query.New(nameRecordsKeyPrefix))
+	db := recordDatabase.DB()
+	db.FlushCache()
+	db.ClearCache()
+	n, err := db.Purge(ctx, query.New(nameRecordsKeyPrefix))
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,219 @@
+package resolver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+)
+
+const (
+	prefetchInterval = 5 * time.Second
+
+	// prefetchAhead defines how long before expiry an entry is prefetched.
+	// This must be larger than refreshTTL so that prefetching happens before
+	// the regular async refresh kicks in.
+	prefetchAhead = 2 * refreshTTL * time.Second
+
+	// minPrefetchQueries is the amount of queries a domain needs within
+	// prefetchWindow in order to be prefetched.
+	minPrefetchQueries = 3
+	prefetchWindow     = 30 * time.Minute
+
+	// maxPrefetchesPerInterval limits the amount of prefetches in order to
+	// not stress the upstream resolvers.
+	maxPrefetchesPerInterval = 10
+
+	// maxPrefetchCandidates limits the amount of tracked queries.
+	maxPrefetchCandidates = 2000
+)
+
+var (
+	prefetchCandidates     = make(map[string]*prefetchCandidate)
+	prefetchCandidatesLock sync.Mutex
+
+	prefetchStats     PrefetchStats
+	prefetchStatsLock sync.Mutex
+)
+
+type prefetchCandidate struct {
+	query       *Query
+	queries     int
+	windowStart time.Time
+	lastQueried time.Time
+	prefetched  bool
+}
+
+// PrefetchStats holds statistics about prefetching.
+type PrefetchStats struct {
+	// Candidates is the amount of queries currently tracked for prefetching.
+	Candidates int
+	// Prefetches is the amount of started prefetches.
+	Prefetches uint64
+	// Failed is the amount of prefetches that failed.
+	Failed uint64
+	// Hits is the amount of queries that were answered with a prefetched
+	// entry.
+	Hits uint64
+	// RateLimited is the amount of prefetches that were postponed because of
+	// the rate limit.
+	RateLimited uint64
+}
+
+// recordPrefetchCandidate records a query for the prefetcher.
+func recordPrefetchCandidate(q *Query) {
+	if q.NoCaching || !prefetchEnabled() {
+		return
+	}
+
+	prefetchCandidatesLock.Lock()
+	defer prefetchCandidatesLock.Unlock()
+
+	now := time.Now()
+	candidate, ok := prefetchCandidates[q.ID()]
+	if !ok {
+		if len(prefetchCandidates) >= maxPrefetchCandidates {
+			return
+		}
+		candidate = &prefetchCandidate{
+			windowStart: now,
+		}
+		prefetchCandidates[q.ID()] = candidate
+	}
+
+	// Start a new window if the current one is over.
+	if now.Sub(candidate.windowStart) > prefetchWindow {
+		candidate.windowStart = now
+		candidate.queries = 0
+	}
+
+	// Always use the latest query parameters.
+	copied := *q
+	candidate.query = &copied
+	candidate.queries++
+	candidate.lastQueried = now
+
+	if candidate.prefetched {
+		candidate.prefetched = false
+		prefetchStatsLock.Lock()
+		prefetchStats.Hits++
+		prefetchStatsLock.Unlock()
+	}
+}
+
+func prefetch(ctx context.Context, _ *modules.Task) error {
+	if !prefetchEnabled() {
+		prefetchCandidatesLock.Lock()
+		prefetchCandidates = make(map[string]*prefetchCandidate)
+		prefetchCandidatesLock.Unlock()
+		return nil
+	}
+
+	// Collect queries that are due.
+	now := time.Now()
+	var due []*Query
+	prefetchCandidatesLock.Lock()
+	for id, candidate := range prefetchCandidates {
+		// Forget queries that are not used anymore.
+		if now.Sub(candidate.lastQueried) > prefetchWindow {
+			delete(prefetchCandidates, id)
+			continue
+		}
+		if candidate.queries < minPrefetchQueries || candidate.prefetched {
+			continue
+		}
+
+		rrCache, err := GetRRCache(candidate.query.FQDN, candidate.query.QType)
+		if err != nil {
+			continue
+		}
+		expires := time.Unix(rrCache.Expires, 0)
+		if now.Before(expires) && now.Add(prefetchAhead).After(expires) {
+			candidate.prefetched = true
+			due = append(due, candidate.query)
+		}
+	}
+	prefetchCandidatesLock.Unlock()
+
+	// Respect the rate limit.
+	var rateLimited int
+	if len(due) > maxPrefetchesPerInterval {
+		rateLimited = len(due) - maxPrefetchesPerInterval
+		for _, q := range due[maxPrefetchesPerInterval:] {
+			markPrefetchPending(q)
+		}
+		due = due[:maxPrefetchesPerInterval]
+	}
+
+	prefetchStatsLock.Lock()
+	prefetchStats.Prefetches += uint64(len(due))
+	prefetchStats.RateLimited += uint64(rateLimited)
+	prefetchStatsLock.Unlock()
+
+	for _, q := range due {
+		prefetchQuery(ctx, q)
+	}
+	return nil
+}
+
+// markPrefetchPending resets the prefetched flag so that the query is
+// considered again in the next run.
+func markPrefetchPending(q *Query) {
+	prefetchCandidatesLock.Lock()
+	defer prefetchCandidatesLock.Unlock()
+
+	if candidate, ok := prefetchCandidates[q.ID()]; ok {
+		candidate.prefetched = false
+	}
+}
+
+func prefetchQuery(ctx context.Context, q *Query) {
+	tracingCtx, tracer := log.AddTracer(ctx)
+	defer tracer.Submit()
+
+	// Skip if the query is already being resolved.
+	markRequestFinished := deduplicateRequest(tracingCtx, q)
+	if markRequestFinished == nil {
+		return
+	}
+	defer markRequestFinished()
+
+	tracer.Tracef("resolver: prefetching %s", q.ID())
+	_, err := resolveAndCache(tracingCtx, q, nil)
+	if err != nil {
+		tracer.Debugf("resolver: failed to prefetch %s: %s", q.ID(), err)
+		markPrefetchPending(q)
+
+		prefetchStatsLock.Lock()
+		prefetchStats.Failed++
+		prefetchStatsLock.Unlock()
+	}
+}
+
+// GetPrefetchStats returns the current prefetch statistics.
+func GetPrefetchStats() PrefetchStats {
+	prefetchCandidatesLock.Lock()
+	candidates := len(prefetchCandidates)
+	prefetchCandidatesLock.Unlock()
+
+	prefetchStatsLock.Lock()
+	defer prefetchStatsLock.Unlock()
+
+	stats := prefetchStats
+	stats.Candidates = candidates
+	return stats
+}
+
+func registerPrefetchAPI() error {
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:        "dns/prefetch",
+		Read:        api.PermitUser,
+		BelongsTo:   module,
+		StructFunc:  func(_ *api.Request) (interface{}, error) { return GetPrefetchStats(), nil },
+		Name:        "Get DNS Prefetch Statistics",
+		Description: "Returns statistics about prefetching of frequently queried domains.",
+	})
+}
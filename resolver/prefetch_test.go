@@ -0,0 +1,34 @@
+package resolver
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefetchCandidates(t *testing.T) {
+	previousPrefetchEnabled := prefetchEnabled
+	prefetchEnabled = func() bool { return true }
+	defer func() { prefetchEnabled = previousPrefetchEnabled }()
+
+	q := &Query{FQDN: "prefetch.example.com.", QType: dns.Type(dns.TypeA)}
+	for i := 0; i < minPrefetchQueries; i++ {
+		recordPrefetchCandidate(q)
+	}
+	recordPrefetchCandidate(&Query{FQDN: "nocache.example.com.", QType: dns.Type(dns.TypeA), NoCaching: true})
+
+	prefetchCandidatesLock.Lock()
+	candidate, ok := prefetchCandidates[q.ID()]
+	assert.True(t, ok)
+	assert.Equal(t, minPrefetchQueries, candidate.queries)
+	assert.Len(t, prefetchCandidates, 1)
+	candidate.prefetched = true
+	prefetchCandidatesLock.Unlock()
+
+	// Using a prefetched entry counts as a hit.
+	hits := GetPrefetchStats().Hits
+	recordPrefetchCandidate(q)
+	assert.Equal(t, hits+1, GetPrefetchStats().Hits)
+	assert.False(t, candidate.prefetched)
+}
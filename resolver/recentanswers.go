@@ -0,0 +1,160 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/modules"
+)
+
+// DomainConfidence describes how certain the attribution of a domain to a
+// connection is.
+type DomainConfidence uint8
+
+// Domain Confidence Levels
+const (
+	// DomainConfidenceNone is used when no domain was attributed.
+	DomainConfidenceNone DomainConfidence = iota
+	// DomainConfidenceLow is used when the domain was recently resolved to the
+	// IP by another application. Such domains must not be matched by rules.
+	DomainConfidenceLow
+	// DomainConfidenceMedium is used when the domain was resolved to the IP via
+	// the system resolver, or when the DNS answer expired and was re-validated.
+	DomainConfidenceMedium
+	// DomainConfidenceHigh is used when the domain was resolved to the IP by
+	// the same application.
+	DomainConfidenceHigh
+)
+
+func (dc DomainConfidence) String() string {
+	switch dc {
+	case DomainConfidenceLow:
+		return "low"
+	case DomainConfidenceMedium:
+		return "medium"
+	case DomainConfidenceHigh:
+		return "high"
+	default:
+		return "none"
+	}
+}
+
+const (
+	// recentAnswersWindow defines how long answers are kept in the recent
+	// answers index after they expired.
+	recentAnswersWindow = 10 * time.Minute
+
+	// maxRecentAnswersPerIP defines how many answers are kept per IP.
+	maxRecentAnswersPerIP = 10
+)
+
+// recentAnswer is a domain that recently resolved to an IP, together with the
+// application that requested it.
+type recentAnswer struct {
+	ResolvedDomain
+	profileID string
+	pid       int
+}
+
+var (
+	recentAnswers     = make(map[string][]*recentAnswer)
+	recentAnswersLock sync.Mutex
+)
+
+// AddRecentAnswer adds a domain that was resolved to the given IP by the
+// given profile and process to the index of recent DNS answers. The index is
+// shared by all profiles and is used to attribute domains to connections of
+// applications that did not resolve the IP themselves.
+func AddRecentAnswer(ip net.IP, resolved ResolvedDomain, profileID string, pid int) {
+	recentAnswersLock.Lock()
+	defer recentAnswersLock.Unlock()
+
+	key := ip.String()
+	answers := recentAnswers[key]
+
+	// Remove previous answer for the same domain and process.
+	for i, answer := range answers {
+		if answer.Domain == resolved.Domain && answer.pid == pid {
+			answers = append(answers[:i], answers[i+1:]...)
+			break
+		}
+	}
+	if len(answers) >= maxRecentAnswersPerIP {
+		answers = answers[1:]
+	}
+
+	recentAnswers[key] = append(answers, &recentAnswer{
+		ResolvedDomain: resolved,
+		profileID:      profileID,
+		pid:            pid,
+	})
+}
+
+// GetRecentAnswer returns the domain that most probably belongs to a
+// connection of the given profile and process to the given IP. Answers
+// requested by the same process are preferred over answers requested by the
+// same profile, which are preferred over all other answers.
+func GetRecentAnswer(ip net.IP, profileID string, pid int) (*ResolvedDomain, DomainConfidence) {
+	recentAnswersLock.Lock()
+	defer recentAnswersLock.Unlock()
+
+	var (
+		best     *recentAnswer
+		bestRank int
+	)
+	earliest := time.Now().Add(-recentAnswersWindow).Unix()
+	for _, answer := range recentAnswers[ip.String()] {
+		if answer.Expires < earliest {
+			continue
+		}
+
+		var rank int
+		switch {
+		case answer.pid == pid:
+			rank = 3
+		case answer.profileID == profileID:
+			rank = 2
+		default:
+			rank = 1
+		}
+		// Later answers are more recent, so they win on equal rank.
+		if rank >= bestRank {
+			best = answer
+			bestRank = rank
+		}
+	}
+
+	if best == nil {
+		return nil, DomainConfidenceNone
+	}
+	resolved := best.ResolvedDomain
+	if bestRank == 1 {
+		return &resolved, DomainConfidenceLow
+	}
+	return &resolved, DomainConfidenceHigh
+}
+
+func cleanRecentAnswers(_ context.Context, _ *modules.Task) error {
+	recentAnswersLock.Lock()
+	defer recentAnswersLock.Unlock()
+
+	earliest := time.Now().Add(-recentAnswersWindow).Unix()
+	for key, answers := range recentAnswers {
+		valid := answers[:0]
+		for _, answer := range answers {
+			if answer.Expires >= earliest {
+				valid = append(valid, answer)
+			}
+		}
+
+		if len(valid) == 0 {
+			delete(recentAnswers, key)
+		} else {
+			recentAnswers[key] = valid
+		}
+	}
+
+	return nil
+}
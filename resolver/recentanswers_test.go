@@ -0,0 +1,47 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecentAnswers(t *testing.T) {
+	ip := net.ParseIP("192.0.2.10")
+	expires := time.Now().Add(time.Minute).Unix()
+
+	resolved, confidence := GetRecentAnswer(ip, "profile-a", 1)
+	assert.Nil(t, resolved)
+	assert.Equal(t, DomainConfidenceNone, confidence)
+
+	AddRecentAnswer(ip, ResolvedDomain{Domain: "a.example.com.", Expires: expires}, "profile-a", 1)
+	AddRecentAnswer(ip, ResolvedDomain{Domain: "b.example.com.", Expires: expires}, "profile-b", 2)
+	AddRecentAnswer(ip, ResolvedDomain{Domain: "c.example.com.", Expires: expires}, "profile-b", 3)
+
+	// The same process is preferred.
+	resolved, confidence = GetRecentAnswer(ip, "profile-b", 2)
+	assert.Equal(t, "b.example.com.", resolved.Domain)
+	assert.Equal(t, DomainConfidenceHigh, confidence)
+
+	// Then the same profile.
+	resolved, confidence = GetRecentAnswer(ip, "profile-a", 4)
+	assert.Equal(t, "a.example.com.", resolved.Domain)
+	assert.Equal(t, DomainConfidenceHigh, confidence)
+
+	// Then the most recent answer.
+	resolved, confidence = GetRecentAnswer(ip, "profile-c", 5)
+	assert.Equal(t, "c.example.com.", resolved.Domain)
+	assert.Equal(t, DomainConfidenceLow, confidence)
+
+	// Answers are removed some time after they expired.
+	AddRecentAnswer(ip, ResolvedDomain{
+		Domain:  "c.example.com.",
+		Expires: time.Now().Add(-2 * recentAnswersWindow).Unix(),
+	}, "profile-b", 3)
+	assert.NoError(t, cleanRecentAnswers(context.Background(), nil))
+	resolved, _ = GetRecentAnswer(ip, "profile-c", 5)
+	assert.Equal(t, "b.example.com.", resolved.Domain)
+}
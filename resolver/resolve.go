@@ -10,9 +10,11 @@
 	"github.com/safing/portmaster/netenv"
 
 	"github.com/miekg/dns"
+	"github.com/tevino/abool"
 
 	"github.com/safing/portbase/database"
 	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/tracing"
 )
 
 var (
@@ -48,15 +50,24 @@
 const (
 	minTTL     = 60 // 1 Minute
 	refreshTTL = minTTL / 2
-	minMDnsTTL = 60           // 1 Minute
 	maxTTL     = 24 * 60 * 60 // 24 hours
 )
 
 var (
 	dupReqMap  = make(map[string]*dedupeStatus)
 	dupReqLock sync.Mutex
+
+	preferCache = abool.New()
 )
 
+// SetPreferCache sets whether expired cache entries should be used instead of
+// waiting for a fresh answer. Expired entries are then refreshed in the
+// background. This is used to reduce load and latency when the Portmaster is
+// overloaded.
+func SetPreferCache(prefer bool) {
+	preferCache.SetTo(prefer)
+}
+
 type dedupeStatus struct {
 	completed  chan struct{}
 	waitUntil  time.Time
@@ -125,6 +136,8 @@ func Resolve(ctx context.Context, q *Query) (rrCache *RRCache, err error) {
 	ctx, tracer := log.AddTracer(ctx)
 	defer tracer.Submit()
 	log.Tracer(ctx).Tracef("resolver: resolving %s%s", q.FQDN, q.QType)
+	ctx, span := tracing.StartSpan(ctx, "resolve")
+	defer span.End()
 
 	// check query compliance
 	if err = q.checkCompliance(); err != nil {
@@ -133,11 +146,21 @@ func Resolve(ctx context.Context, q *Query) (rrCache *RRCache, err error) {
 
 	// check the cache
 	if !q.NoCaching {
+		recordPrefetchCandidate(q)
+
 		rrCache = checkCache(ctx, q)
 		if rrCache != nil && !rrCache.Expired() {
 			return rrCache, nil
 		}
 
+		// Use expired entries while the cache is preferred and refresh them
+		// in the background.
+		if rrCache != nil && preferCache.IsSet() {
+			log.Tracer(ctx).Tracef("resolver: using expired cache for %s, as the cache is preferred", q.ID())
+			resolveAsync(q)
+			return rrCache, nil
+		}
+
 		// dedupe!
 		markRequestFinished := deduplicateRequest(ctx, q)
 		if markRequestFinished == nil {
@@ -223,20 +246,7 @@ func checkCache(ctx context.Context, q *Query) *RRCache {
 			time.Until(time.Unix(rrCache.Expires, 0)).Round(time.Second),
 		)
 
-		// resolve async
-		module.StartWorker("resolve async", func(asyncCtx context.Context) error {
-			tracingCtx, tracer := log.AddTracer(asyncCtx)
-			defer tracer.Submit()
-			tracer.Tracef("resolver: resolving %s async", q.ID())
-			_, err := resolveAndCache(tracingCtx, q, nil)
-			if err != nil {
-				tracer.Warningf("resolver: async query for %s failed: %s", q.ID(), err)
-			} else {
-				tracer.Infof("resolver: async query for %s succeeded", q.ID())
-			}
-			return nil
-		})
-
+		resolveAsync(q)
 		return rrCache
 	}
 
@@ -247,6 +257,31 @@ func checkCache(ctx context.Context, q *Query) *RRCache {
 	return rrCache
 }
 
+// resolveAsync resolves the query in the background in order to refresh the
+// cache.
+func resolveAsync(q *Query) {
+	module.StartWorker("resolve async", func(asyncCtx context.Context) error {
+		tracingCtx, tracer := log.AddTracer(asyncCtx)
+		defer tracer.Submit()
+
+		// Skip if the query is already being resolved.
+		markRequestFinished := deduplicateRequest(tracingCtx, q)
+		if markRequestFinished == nil {
+			return nil
+		}
+		defer markRequestFinished()
+
+		tracer.Tracef("resolver: resolving %s async", q.ID())
+		_, err := resolveAndCache(tracingCtx, q, nil)
+		if err != nil {
+			tracer.Warningf("resolver: async query for %s failed: %s", q.ID(), err)
+		} else {
+			tracer.Infof("resolver: async query for %s succeeded", q.ID())
+		}
+		return nil
+	})
+}
+
 func deduplicateRequest(ctx context.Context, q *Query) (finishRequest func()) {
 	// create identifier key
 	dupKey := q.ID()
@@ -362,10 +397,12 @@ func resolveAndCache(ctx context.Context, q *Query, oldCache *RRCache) (rrCache
 					continue
 				case errors.Is(err, ErrTimeout):
 					resolver.Conn.ReportFailure()
+					recordUpstreamResult(resolver, true)
 					log.Tracer(ctx).Debugf("resolver: query to %s timed out", resolver.Info.ID())
 					continue
 				default:
 					resolver.Conn.ReportFailure()
+					recordUpstreamResult(resolver, true)
 					log.Tracer(ctx).Debugf("resolver: query to %s failed: %s", resolver.Info.ID(), err)
 					continue
 				}
@@ -381,6 +418,7 @@ func resolveAndCache(ctx context.Context, q *Query, oldCache *RRCache) (rrCache
 
 			// Report a successful connection.
 			resolver.Conn.ResetFailure()
+			recordUpstreamResult(resolver, false)
 
 			break resolveLoop
 		}
@@ -418,7 +456,7 @@ func resolveAndCache(ctx context.Context, q *Query, oldCache *RRCache) (rrCache
 	}
 
 	// Adjust TTLs.
-	rrCache.Clean(minTTL)
+	rrCache.Clean()
 
 	// Save the new entry if cache is enabled and the record may be cached.
 	if !q.NoCaching && rrCache.Cacheable() {
@@ -281,7 +281,7 @@ func handleMDNSMessages(ctx context.Context, messages chan *dns.Msg) error {
 
 			var questionID string
 			if saveFullRequest {
-				rrCache.Clean(minMDnsTTL)
+				rrCache.Clean()
 				err := rrCache.Save()
 				if err != nil {
 					log.Warningf("resolver: failed to cache RR %s: %s", rrCache.Domain, err)
@@ -309,7 +309,7 @@ func handleMDNSMessages(ctx context.Context, messages chan *dns.Msg) error {
 					Answer:   []dns.RR{v},
 					Resolver: mDNSResolver.Info.Copy(),
 				}
-				rrCache.Clean(minMDnsTTL)
+				rrCache.Clean()
 				err := rrCache.Save()
 				if err != nil {
 					log.Warningf("resolver: failed to cache RR %s: %s", rrCache.Domain, err)
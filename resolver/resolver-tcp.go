@@ -359,7 +359,7 @@ func (trc *tcpResolverConn) handleQueryResponse(msg *dns.Msg) {
 
 	// Otherwise, we can persist the answer in case the request is repeated.
 	rrCache := tq.MakeCacheRecord(msg, trc.resolverInfo)
-	rrCache.Clean(minTTL)
+	rrCache.Clean()
 	err := rrCache.Save()
 	if err != nil {
 		log.Warningf(
@@ -59,27 +59,26 @@ func (rrCache *RRCache) ExpiresSoon() bool {
 	return rrCache.Expires <= time.Now().Unix()+refreshTTL
 }
 
-// Clean sets all TTLs to 17 and sets cache expiry with specified minimum.
-func (rrCache *RRCache) Clean(minExpires uint32) {
+// Clean sets the cache expiry within the configured TTL limits and sets all
+// TTLs to the TTL of forwarded answers.
+func (rrCache *RRCache) Clean() {
 	var lowestTTL uint32 = 0xFFFFFFFF
-	var header *dns.RR_Header
 
-	// set TTLs to 17
 	// TODO: double append? is there something more elegant?
-	for _, rr := range append(rrCache.Answer, append(rrCache.Ns, rrCache.Extra...)...) {
-		header = rr.Header()
-		if lowestTTL > header.Ttl {
-			lowestTTL = header.Ttl
+	records := append(rrCache.Answer, append(rrCache.Ns, rrCache.Extra...)...)
+	for _, rr := range records {
+		if ttl := rr.Header().Ttl; lowestTTL > ttl {
+			lowestTTL = ttl
 		}
-		header.Ttl = 17
 	}
 
 	// TTL range limits
+	minExpires, maxExpires := getTTLLimits(rrCache.Domain)
 	switch {
 	case lowestTTL < minExpires:
 		lowestTTL = minExpires
-	case lowestTTL > maxTTL:
-		lowestTTL = maxTTL
+	case lowestTTL > maxExpires:
+		lowestTTL = maxExpires
 	}
 
 	// shorten caching
@@ -97,6 +96,18 @@ func (rrCache *RRCache) Clean(minExpires uint32) {
 		// Not being fully online could mean that we get funny responses.
 		lowestTTL = 60
 	}
+	if lowestTTL > maxExpires {
+		lowestTTL = maxExpires
+	}
+
+	// Apps must not cache answers longer than the Portmaster.
+	forwardedTTL := uint32(answerTTL)
+	if forwardedTTL > lowestTTL {
+		forwardedTTL = lowestTTL
+	}
+	for _, rr := range records {
+		rr.Header().Ttl = forwardedTTL
+	}
 
 	// log.Tracef("lowest TTL is %d", lowestTTL)
 	rrCache.Expires = time.Now().Unix() + int64(lowestTTL)
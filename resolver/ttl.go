@@ -0,0 +1,145 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/log"
+)
+
+// The time DNS answers are cached is clamped to the configured minimum and
+// maximum TTL, which may be overridden per domain. Short TTLs reduce how long
+// visited domains are retained, long TTLs reduce DNS queries and work around
+// services that reply with a TTL of zero.
+
+// answerTTL is the TTL of forwarded answers, so that clients query again
+// soon and the Portmaster can attribute connections to DNS requests. It is
+// reduced if answers are cached for a shorter time.
+const answerTTL = 17
+
+// ttlOverride holds the TTL limits for a domain.
+type ttlOverride struct {
+	// domain is the fully qualified domain the override applies to.
+	domain string
+	// subdomains defines whether the override applies to subdomains too.
+	subdomains bool
+
+	min uint32
+	max uint32
+}
+
+var (
+	ttlOverrides     []*ttlOverride
+	ttlOverridesLock sync.RWMutex
+)
+
+// parseTTLOverride parses a TTL override in the form "<domain> <min>-<max>".
+// A leading dot of the domain also matches all its subdomains.
+func parseTTLOverride(entry string) (*ttlOverride, error) {
+	fields := strings.Fields(entry)
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("invalid TTL override %q: expected domain and TTL range", entry)
+	}
+
+	override := &ttlOverride{
+		domain: strings.ToLower(fields[0]),
+	}
+	if strings.HasPrefix(override.domain, ".") {
+		override.subdomains = true
+		override.domain = strings.TrimPrefix(override.domain, ".")
+	}
+	override.domain = dns.Fqdn(override.domain)
+	if _, ok := dns.IsDomainName(override.domain); !ok {
+		return nil, fmt.Errorf("invalid TTL override %q: invalid domain", entry)
+	}
+
+	bounds := strings.SplitN(fields[1], "-", 2)
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("invalid TTL override %q: expected TTL range", entry)
+	}
+	min, err := strconv.ParseUint(bounds[0], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TTL override %q: invalid minimum TTL: %w", entry, err)
+	}
+	max, err := strconv.ParseUint(bounds[1], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TTL override %q: invalid maximum TTL: %w", entry, err)
+	}
+	if max < min {
+		return nil, fmt.Errorf("invalid TTL override %q: maximum TTL is lower than minimum TTL", entry)
+	}
+	override.min = uint32(min)
+	override.max = uint32(max)
+
+	return override, nil
+}
+
+// matches returns whether the override applies to the given fully qualified
+// domain.
+func (override *ttlOverride) matches(domain string) bool {
+	return domain == override.domain ||
+		(override.subdomains && strings.HasSuffix(domain, "."+override.domain))
+}
+
+// loadTTLOverrides parses the configured TTL overrides.
+func loadTTLOverrides(_ context.Context, _ interface{}) error {
+	entries := configuredTTLOverrides()
+	overrides := make([]*ttlOverride, 0, len(entries))
+	for _, entry := range entries {
+		override, err := parseTTLOverride(entry)
+		if err != nil {
+			log.Warningf("resolver: %s", err)
+			continue
+		}
+		overrides = append(overrides, override)
+	}
+
+	ttlOverridesLock.Lock()
+	defer ttlOverridesLock.Unlock()
+
+	ttlOverrides = overrides
+	return nil
+}
+
+// getTTLLimits returns the minimum and maximum TTL for the given fully
+// qualified domain. The first matching override is used, falling back to the
+// global limits.
+func getTTLLimits(domain string) (min, max uint32) {
+	ttlOverridesLock.RLock()
+	defer ttlOverridesLock.RUnlock()
+
+	return ttlLimitsFor(
+		strings.ToLower(domain), ttlOverrides,
+		clampConfigTTL(minCacheTTL()), clampConfigTTL(maxCacheTTL()),
+	)
+}
+
+func ttlLimitsFor(domain string, overrides []*ttlOverride, globalMin, globalMax uint32) (min, max uint32) {
+	for _, override := range overrides {
+		if override.matches(domain) {
+			return override.min, override.max
+		}
+	}
+
+	if globalMax < globalMin {
+		globalMax = globalMin
+	}
+	return globalMin, globalMax
+}
+
+// clampConfigTTL converts a configured TTL to a valid TTL.
+func clampConfigTTL(ttl int64) uint32 {
+	switch {
+	case ttl < 0:
+		return 0
+	case ttl > maxTTL:
+		return maxTTL
+	default:
+		return uint32(ttl)
+	}
+}
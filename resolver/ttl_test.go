@@ -0,0 +1,62 @@
+package resolver
+
+import (
+	"testing"
+)
+
+func TestParseTTLOverride(t *testing.T) {
+	override, err := parseTTLOverride(".Example.com 0-60")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if override.domain != "example.com." || !override.subdomains || override.min != 0 || override.max != 60 {
+		t.Errorf("unexpected override: %+v", override)
+	}
+
+	for _, entry := range []string{
+		"example.com",
+		"example.com 60",
+		"example.com 60-30",
+		"example.com a-60",
+		"example..com 0-60",
+	} {
+		if _, err := parseTTLOverride(entry); err == nil {
+			t.Errorf("expected TTL override %q to be invalid", entry)
+		}
+	}
+}
+
+func TestTTLLimitsFor(t *testing.T) {
+	var overrides []*ttlOverride
+	for _, entry := range []string{
+		"broken.example.com 300-3600",
+		".example.com 0-60",
+	} {
+		override, err := parseTTLOverride(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		overrides = append(overrides, override)
+	}
+
+	for _, test := range []struct {
+		domain   string
+		min, max uint32
+	}{
+		{domain: "broken.example.com.", min: 300, max: 3600},
+		{domain: "www.example.com.", min: 0, max: 60},
+		{domain: "example.com.", min: 0, max: 60},
+		{domain: "notexample.com.", min: 60, max: 86400},
+		{domain: "example.org.", min: 60, max: 86400},
+	} {
+		min, max := ttlLimitsFor(test.domain, overrides, 60, 86400)
+		if min != test.min || max != test.max {
+			t.Errorf("%s: expected TTL limits %d-%d, got %d-%d", test.domain, test.min, test.max, min, max)
+		}
+	}
+
+	// The maximum TTL must not be lower than the minimum TTL.
+	if min, max := ttlLimitsFor("example.org.", nil, 300, 60); min != 300 || max != 300 {
+		t.Errorf("unexpected global TTL limits %d-%d", min, max)
+	}
+}
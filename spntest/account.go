@@ -0,0 +1,121 @@
+package spntest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/portbase/runtime"
+	"github.com/safing/spn/access"
+	"github.com/safing/spn/captain"
+)
+
+// AccountChangedEvent is emitted when the account status changed. The event
+// data is the new *AccountStatus.
+const AccountChangedEvent = "account changed"
+
+const accountCheckInterval = time.Minute
+
+// AccountStatus describes the SPN account state of this device. It is a
+// read-only record exposed via runtime:spn/account.
+//
+// This version of the SPN authenticates devices with access codes only, so
+// there is no information about plans, expiry or device counts. The zone of
+// the access code identifies the access program the device takes part in.
+type AccountStatus struct {
+	record.Base
+	sync.Mutex
+
+	// Enabled reports whether the SPN is enabled in the settings.
+	Enabled bool
+	// HasAccessCode reports whether an access code is available.
+	HasAccessCode bool
+	// Zone is the zone of the access code, eg. "alpha1".
+	Zone string
+	// ClientReady reports whether the SPN client is currently connected.
+	ClientReady bool
+}
+
+var (
+	accountStatus     *AccountStatus
+	accountStatusLock sync.Mutex
+
+	pushAccountStatus runtime.PushFunc
+)
+
+func startAccountStatus() (err error) {
+	pushAccountStatus, err = runtime.Register("spn/account", runtime.SimpleValueGetterFunc(
+		func(_ string) ([]record.Record, error) {
+			return []record.Record{GetAccountStatus()}, nil
+		},
+	))
+	if err != nil {
+		return err
+	}
+
+	module.NewTask("check account status", checkAccountStatus).Repeat(accountCheckInterval)
+	return nil
+}
+
+// GetAccountStatus returns the current account status.
+func GetAccountStatus() *AccountStatus {
+	accountStatusLock.Lock()
+	defer accountStatusLock.Unlock()
+
+	if accountStatus == nil {
+		accountStatus = buildAccountStatus()
+	}
+	return accountStatus
+}
+
+func buildAccountStatus() *AccountStatus {
+	status := &AccountStatus{
+		Enabled:     config.GetAsBool(captain.CfgOptionEnableSPNKey, false)(),
+		ClientReady: captain.ClientReady(),
+	}
+	if code, err := access.Get(); err == nil {
+		status.HasAccessCode = true
+		status.Zone = code.Zone
+	}
+
+	status.CreateMeta()
+	status.SetKey("runtime:spn/account")
+	return status
+}
+
+func (status *AccountStatus) equal(other *AccountStatus) bool {
+	return status.Enabled == other.Enabled &&
+		status.HasAccessCode == other.HasAccessCode &&
+		status.Zone == other.Zone &&
+		status.ClientReady == other.ClientReady
+}
+
+// checkAccountStatus updates the account status and pushes it and emits the
+// AccountChangedEvent if it changed.
+func checkAccountStatus(_ context.Context, _ *modules.Task) error {
+	status := buildAccountStatus()
+
+	accountStatusLock.Lock()
+	changed := accountStatus == nil || !accountStatus.equal(status)
+	if changed {
+		accountStatus = status
+	}
+	accountStatusLock.Unlock()
+
+	if !changed {
+		return nil
+	}
+
+	log.Debugf("spntest: account status changed: access code=%v zone=%q connected=%v", status.HasAccessCode, status.Zone, status.ClientReady)
+	if pushAccountStatus != nil {
+		status.Lock()
+		pushAccountStatus(status)
+		status.Unlock()
+	}
+	module.TriggerEvent(AccountChangedEvent, status)
+	return nil
+}
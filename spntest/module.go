@@ -0,0 +1,30 @@
+package spntest
+
+import (
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/modules"
+)
+
+var module *modules.Module
+
+func init() {
+	module = modules.Register("spntest", prep, start, nil, "captain")
+	module.RegisterEvent(AccountChangedEvent, true)
+}
+
+func prep() error {
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:      "spn/test",
+		Write:     api.PermitUser,
+		BelongsTo: module,
+		StructFunc: func(ar *api.Request) (i interface{}, err error) {
+			return Run(ar.Context()), nil
+		},
+		Name:        "Test SPN Connectivity",
+		Description: "Checks the SPN map, the account status and whether the nearest entry nodes are reachable over each of their transports, and reports the results and detected problems.",
+	})
+}
+
+func start() error {
+	return startAccountStatus()
+}
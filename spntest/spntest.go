@@ -0,0 +1,269 @@
+package spntest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portmaster/netenv"
+	"github.com/safing/spn/access"
+	"github.com/safing/spn/captain"
+	"github.com/safing/spn/docks"
+	"github.com/safing/spn/hub"
+	"github.com/safing/spn/navigator"
+)
+
+const (
+	// maxEntryNodes is the amount of entry node candidates that are tested.
+	maxEntryNodes = 3
+
+	transportTimeout = 10 * time.Second
+)
+
+var db = database.NewInterface(nil)
+
+// Report is the result of an SPN connectivity test.
+type Report struct {
+	Started  time.Time
+	Duration time.Duration
+
+	// Enabled reports whether the SPN is enabled in the settings.
+	Enabled bool
+	// ClientReady reports whether the SPN client is currently connected.
+	ClientReady bool
+
+	Map        *MapCheck
+	Account    *AccountCheck
+	EntryNodes []*EntryNodeCheck
+
+	// Problems holds human readable descriptions of detected problems.
+	Problems []string
+}
+
+// MapCheck reports on the locally available SPN map.
+type MapCheck struct {
+	// Hubs is the amount of known public hubs.
+	Hubs int
+	// HubsWithInfo is the amount of known public hubs with an announcement,
+	// which is required to connect to them.
+	HubsWithInfo int
+	Error        string
+}
+
+// AccountCheck reports on the account status.
+type AccountCheck struct {
+	HasAccessCode bool
+	Zone          string
+	Error         string
+}
+
+// EntryNodeCheck reports on an entry node candidate.
+type EntryNodeCheck struct {
+	HubID      string
+	Name       string
+	Proximity  int
+	Transports []*TransportCheck
+}
+
+// TransportCheck reports on the reachability of a hub via a transport.
+type TransportCheck struct {
+	Transport string
+	IP        net.IP
+	Reachable bool
+	// Latency is the time it took to establish a connection.
+	Latency time.Duration
+	Error   string
+}
+
+// Run runs the SPN connectivity test. Problems are reported in the report.
+func Run(ctx context.Context) *Report {
+	report := &Report{
+		Started:     time.Now(),
+		Enabled:     config.GetAsBool(captain.CfgOptionEnableSPNKey, false)(),
+		ClientReady: captain.ClientReady(),
+	}
+	if !report.Enabled {
+		report.addProblem("The SPN is disabled in the settings.")
+	}
+
+	report.Account = checkAccount()
+	if !report.Account.HasAccessCode {
+		report.addProblem("No access code is available. Please enter your access code in the settings.")
+	}
+
+	var hubs []*hub.Hub
+	report.Map, hubs = checkMap()
+	switch {
+	case report.Map.Error != "":
+		report.addProblem(fmt.Sprintf("Failed to read the SPN map: %s", report.Map.Error))
+	case report.Map.HubsWithInfo == 0:
+		report.addProblem("The SPN map is empty. The map is bootstrapped and updated via connected hubs - check if the bootstrap hubs are reachable.")
+	}
+
+	report.EntryNodes = checkEntryNodes(ctx, hubs)
+	if len(report.EntryNodes) > 0 && !report.anyEntryNodeReachable() {
+		report.addProblem("None of the nearest entry nodes is reachable. The network or a firewall may be blocking connections to the SPN.")
+	}
+
+	report.Duration = time.Since(report.Started)
+	return report
+}
+
+func (report *Report) addProblem(problem string) {
+	report.Problems = append(report.Problems, problem)
+}
+
+func (report *Report) anyEntryNodeReachable() bool {
+	for _, entryNode := range report.EntryNodes {
+		for _, transport := range entryNode.Transports {
+			if transport.Reachable {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func checkAccount() *AccountCheck {
+	check := &AccountCheck{}
+	code, err := access.Get()
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	check.HasAccessCode = true
+	check.Zone = code.Zone
+	return check
+}
+
+// checkMap checks the public hubs in the database and returns the ones that
+// can be connected to.
+func checkMap() (*MapCheck, []*hub.Hub) {
+	check := &MapCheck{}
+
+	iter, err := db.Query(query.New(hub.PublicHubs))
+	if err != nil {
+		check.Error = err.Error()
+		return check, nil
+	}
+
+	var hubs []*hub.Hub
+	for r := range iter.Next {
+		h, err := hub.EnsureHub(r)
+		if err != nil {
+			continue
+		}
+		check.Hubs++
+		if h.Info != nil && len(h.Info.Transports) > 0 {
+			check.HubsWithInfo++
+			hubs = append(hubs, h)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		check.Error = err.Error()
+	}
+
+	return check, hubs
+}
+
+// checkEntryNodes tests the nearest hubs as entry nodes. If the own location
+// is unknown, the first hubs are used.
+func checkEntryNodes(ctx context.Context, hubs []*hub.Hub) []*EntryNodeCheck {
+	var checks []*EntryNodeCheck
+
+	// Find the nearest hubs.
+	if myIP, err := netenv.GetApproximateInternetLocation(); err == nil {
+		if nearest, err := navigator.FindNearestPorts([]net.IP{myIP}); err == nil {
+			for _, result := range nearest.All {
+				if len(checks) >= maxEntryNodes {
+					break
+				}
+				if result.Port == nil || result.Port.Hub == nil || result.Port.Hub.Info == nil {
+					continue
+				}
+				checks = append(checks, &EntryNodeCheck{
+					HubID:     result.Port.Hub.ID,
+					Name:      result.Port.Hub.Info.Name,
+					Proximity: result.Proximity,
+				})
+			}
+		}
+	}
+
+	// Fall back to any hubs.
+	if len(checks) == 0 {
+		for _, h := range hubs {
+			if len(checks) >= maxEntryNodes {
+				break
+			}
+			checks = append(checks, &EntryNodeCheck{
+				HubID: h.ID,
+				Name:  h.Info.Name,
+			})
+		}
+	}
+
+	for _, check := range checks {
+		h, err := hub.GetHub(hub.ScopePublic, check.HubID)
+		if err != nil || h.Info == nil {
+			continue
+		}
+		check.Transports = checkTransports(ctx, h)
+	}
+	return checks
+}
+
+// checkTransports tries to connect to the hub via all of its transports and
+// IP addresses.
+func checkTransports(ctx context.Context, h *hub.Hub) []*TransportCheck {
+	var ips []net.IP
+	if h.Info.IPv4 != nil {
+		ips = append(ips, h.Info.IPv4)
+	}
+	if h.Info.IPv6 != nil {
+		ips = append(ips, h.Info.IPv6)
+	}
+
+	var checks []*TransportCheck
+	for _, definition := range h.Info.Transports {
+		transport, err := hub.ParseTransport(definition)
+		if err != nil {
+			checks = append(checks, &TransportCheck{
+				Transport: definition,
+				Error:     fmt.Sprintf("invalid transport: %s", err),
+			})
+			continue
+		}
+
+		for _, ip := range ips {
+			checks = append(checks, checkTransport(ctx, h, transport, ip))
+		}
+	}
+	return checks
+}
+
+func checkTransport(ctx context.Context, h *hub.Hub, transport *hub.Transport, ip net.IP) *TransportCheck {
+	check := &TransportCheck{
+		Transport: transport.String(),
+		IP:        ip,
+	}
+
+	launchCtx, cancel := context.WithTimeout(ctx, transportTimeout)
+	defer cancel()
+
+	started := time.Now()
+	ship, err := docks.LaunchShip(launchCtx, h, transport, ip)
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	check.Latency = time.Since(started)
+	check.Reachable = true
+	ship.Sink()
+
+	return check
+}
@@ -22,11 +22,11 @@ func autoPilot(ctx context.Context) error {
 		selected := SelectedSecurityLevel()
 		mitigation := getHighestMitigationLevel()
 
-		active := SecurityLevelNormal
-		if selected != SecurityLevelOff {
-			active = selected
-		} else if mitigation != SecurityLevelOff {
-			active = mitigation
+		// Use the selected level or automatically choose the level based on
+		// threats and the category of the network.
+		active := selected
+		if selected == SecurityLevelOff {
+			active = max(mitigation, getNetworkCategoryLevel())
 		}
 
 		setActiveLevel(active)
@@ -0,0 +1,122 @@
+package status
+
+import (
+	"runtime"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portmaster/netenv"
+)
+
+// Configuration Keys
+var (
+	CfgOptionPublicNetworkLevelKey   = "core/networkCategoryPublic"
+	cfgOptionPublicNetworkLevel      config.IntOption
+	cfgOptionPublicNetworkLevelOrder = 160
+
+	CfgOptionPrivateNetworkLevelKey   = "core/networkCategoryPrivate"
+	cfgOptionPrivateNetworkLevel      config.IntOption
+	cfgOptionPrivateNetworkLevelOrder = 161
+
+	CfgOptionDomainNetworkLevelKey   = "core/networkCategoryDomain"
+	cfgOptionDomainNetworkLevel      config.IntOption
+	cfgOptionDomainNetworkLevelOrder = 162
+)
+
+// networkCategoryLevelValues are the security levels that can be assigned to
+// network categories.
+var networkCategoryLevelValues = []config.PossibleValue{
+	{
+		Name:        "Trusted / Home Network",
+		Value:       SecurityLevelNormal,
+		Description: "Treat networks of this category as trusted.",
+	},
+	{
+		Name:        "Untrusted / Public Network",
+		Value:       SecurityLevelHigh,
+		Description: "Treat networks of this category as untrusted.",
+	},
+	{
+		Name:        "Danger / Hacked Network",
+		Value:       SecurityLevelExtreme,
+		Description: "Treat networks of this category as dangerous.",
+	},
+}
+
+func registerConfig() error {
+	// Network categories are only supported on Windows.
+	if runtime.GOOS == "windows" {
+		for _, option := range []struct {
+			name         string
+			key          string
+			description  string
+			defaultValue uint8
+			order        int
+		}{
+			{
+				name:         "Public Networks",
+				key:          CfgOptionPublicNetworkLevelKey,
+				description:  "Select the security level to use automatically in networks that Windows categorizes as public.",
+				defaultValue: SecurityLevelHigh,
+				order:        cfgOptionPublicNetworkLevelOrder,
+			},
+			{
+				name:         "Private Networks",
+				key:          CfgOptionPrivateNetworkLevelKey,
+				description:  "Select the security level to use automatically in networks that Windows categorizes as private.",
+				defaultValue: SecurityLevelNormal,
+				order:        cfgOptionPrivateNetworkLevelOrder,
+			},
+			{
+				name:         "Domain Networks",
+				key:          CfgOptionDomainNetworkLevelKey,
+				description:  "Select the security level to use automatically in networks in which Windows is authenticated with a domain controller.",
+				defaultValue: SecurityLevelNormal,
+				order:        cfgOptionDomainNetworkLevelOrder,
+			},
+		} {
+			err := config.Register(&config.Option{
+				Name:           option.name,
+				Key:            option.key,
+				Description:    option.description + " This is only used if the security level is set to auto-pilot.",
+				OptType:        config.OptTypeInt,
+				ExpertiseLevel: config.ExpertiseLevelExpert,
+				ReleaseLevel:   config.ReleaseLevelBeta,
+				DefaultValue:   option.defaultValue,
+				PossibleValues: networkCategoryLevelValues,
+				Annotations: config.Annotations{
+					config.DisplayHintAnnotation:  config.DisplayHintOneOf,
+					config.DisplayOrderAnnotation: option.order,
+					config.CategoryAnnotation:     "Network Categories",
+				},
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	cfgOptionPublicNetworkLevel = config.Concurrent.GetAsInt(CfgOptionPublicNetworkLevelKey, int64(SecurityLevelHigh))
+	cfgOptionPrivateNetworkLevel = config.Concurrent.GetAsInt(CfgOptionPrivateNetworkLevelKey, int64(SecurityLevelNormal))
+	cfgOptionDomainNetworkLevel = config.Concurrent.GetAsInt(CfgOptionDomainNetworkLevelKey, int64(SecurityLevelNormal))
+
+	return nil
+}
+
+// getNetworkCategoryLevel returns the security level configured for the
+// category of the current network.
+func getNetworkCategoryLevel() uint8 {
+	var level uint8
+	switch netenv.GetNetworkCategory() {
+	case netenv.NetworkCategoryPublic:
+		level = uint8(cfgOptionPublicNetworkLevel())
+	case netenv.NetworkCategoryPrivate:
+		level = uint8(cfgOptionPrivateNetworkLevel())
+	case netenv.NetworkCategoryDomain:
+		level = uint8(cfgOptionDomainNetworkLevel())
+	}
+
+	if level == SecurityLevelOff || !IsValidSecurityLevel(level) {
+		return SecurityLevelNormal
+	}
+	return level
+}
@@ -7,6 +7,7 @@
 	"github.com/safing/portbase/modules"
 	"github.com/safing/portbase/utils/debug"
 	"github.com/safing/portmaster/netenv"
+	"github.com/safing/portmaster/updates"
 )
 
 var (
@@ -14,7 +15,11 @@
 )
 
 func init() {
-	module = modules.Register("status", nil, start, nil, "base")
+	module = modules.Register("status", prep, start, nil, "base")
+}
+
+func prep() error {
+	return registerConfig()
 }
 
 func start() error {
@@ -39,6 +44,32 @@ func(_ context.Context, _ interface{}) error {
 		return err
 	}
 
+	err = module.RegisterEventHook(
+		"netenv",
+		netenv.NetworkCategoryChangedEvent,
+		"update security level for network category",
+		func(_ context.Context, _ interface{}) error {
+			triggerAutopilot()
+			return nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	err = module.RegisterEventHook(
+		"updates",
+		updates.RestartPendingEvent,
+		"update pending restart in system status",
+		func(_ context.Context, _ interface{}) error {
+			pushSystemStatus()
+			return nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -50,6 +81,7 @@ func AddToDebugInfo(di *debug.Info) {
 		fmt.Sprintf("ActiveSecurityLevel:   %s", SecurityLevelString(ActiveSecurityLevel())),
 		fmt.Sprintf("SelectedSecurityLevel: %s", SecurityLevelString(SelectedSecurityLevel())),
 		fmt.Sprintf("ThreatMitigationLevel: %s", SecurityLevelString(getHighestMitigationLevel())),
+		fmt.Sprintf("NetworkCategory:       %q (%s)", netenv.GetNetworkCategory(), SecurityLevelString(getNetworkCategoryLevel())),
 		fmt.Sprintf("CaptivePortal:         %s", netenv.GetCaptivePortal().URL),
 		fmt.Sprintf("OnlineStatus:          %s", netenv.GetOnlineStatus()),
 	)
@@ -6,6 +6,7 @@
 	"github.com/safing/portbase/database/record"
 	"github.com/safing/portbase/runtime"
 	"github.com/safing/portmaster/netenv"
+	"github.com/safing/portmaster/updates"
 )
 
 var (
@@ -72,8 +73,10 @@ func buildSystemStatus() *SystemStatusRecord {
 		ActiveSecurityLevel:   ActiveSecurityLevel(),
 		SelectedSecurityLevel: SelectedSecurityLevel(),
 		ThreatMitigationLevel: getHighestMitigationLevel(),
+		NetworkCategory:       netenv.GetNetworkCategory(),
 		CaptivePortal:         netenv.GetCaptivePortal(),
 		OnlineStatus:          netenv.GetOnlineStatus(),
+		RestartPending:        updates.RestartPending(),
 	}
 
 	status.CreateMeta()
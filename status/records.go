@@ -22,6 +22,9 @@ type SystemStatusRecord struct {
 	// ThreatMitigationLevel holds the security level
 	// as selected by the auto-pilot.
 	ThreatMitigationLevel uint8
+	// NetworkCategory holds the category of the current
+	// network as assigned by the operating system.
+	NetworkCategory netenv.NetworkCategory
 	// OnlineStatus holds the current online status as
 	// seen by the netenv package.
 	OnlineStatus netenv.OnlineStatus
@@ -29,6 +32,9 @@ type SystemStatusRecord struct {
 	// portal of the network the portmaster is currently
 	// connected to, if any.
 	CaptivePortal *netenv.CaptivePortal
+	// RestartPending is set when an update was downloaded that is only
+	// applied when the Portmaster is restarted.
+	RestartPending bool
 }
 
 // SelectedSecurityLevelRecord is used as a dummy record.Record
@@ -0,0 +1,244 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+)
+
+// Stage is a stage of the decision pipeline whose latency is monitored.
+type Stage string
+
+// Monitored Stages
+const (
+	// StageConnection is the total latency added to new connections until the
+	// verdict, excluding time spent waiting for the user to answer a prompt.
+	StageConnection Stage = "connection"
+
+	StageProfileLookup Stage = "profile lookup"
+	StageProfileLock   Stage = "profile lock"
+	StageGeoIP         Stage = "geoip lookup"
+	StageFilterLists   Stage = "filterlist lookup"
+)
+
+// stageNames are the human readable names of the sub-stages that make up
+// the latency of new connections.
+var stageNames = map[Stage]string{
+	StageProfileLookup: "process and profile lookup",
+	StageProfileLock:   "profile lock contention",
+	StageGeoIP:         "geoip",
+	StageFilterLists:   "filterlists",
+}
+
+const (
+	// latencyInterval is the interval in which percentiles are calculated and
+	// the SLO is checked.
+	latencyInterval = 10 * time.Second
+
+	// maxLatencySamples is the maximum amount of samples kept per stage and
+	// interval. Further samples replace earlier ones.
+	maxLatencySamples = 1024
+
+	// minLatencySamples is the minimum amount of samples required in an
+	// interval to check the SLO.
+	minLatencySamples = 10
+
+	// sloViolationThreshold is the amount of consecutive intervals that need
+	// to violate the SLO in order to raise a warning.
+	sloViolationThreshold = 6
+
+	latencySLOFailureID = "tracing:latency-slo"
+)
+
+// StageLatency holds latency percentiles of a stage in the last interval.
+type StageLatency struct {
+	Stage   Stage
+	Samples int
+	// P50, P95 and P99 are the percentiles in milliseconds.
+	P50 float64
+	P95 float64
+	P99 float64
+}
+
+type stageSamples struct {
+	durations []time.Duration
+	next      int
+	observed  int
+}
+
+var (
+	latencySamples = make(map[Stage]*stageSamples)
+	latencyStats   = make(map[Stage]*StageLatency)
+	latencyLock    sync.Mutex
+
+	sloViolations int
+)
+
+// ObserveStage records the duration of a stage that started at the given
+// time. It is cheap enough to be called for every new connection.
+func ObserveStage(stage Stage, started time.Time) {
+	if started.IsZero() {
+		return
+	}
+	duration := time.Since(started)
+
+	latencyLock.Lock()
+	defer latencyLock.Unlock()
+
+	samples, ok := latencySamples[stage]
+	if !ok {
+		samples = &stageSamples{}
+		latencySamples[stage] = samples
+	}
+
+	samples.observed++
+	if len(samples.durations) < maxLatencySamples {
+		samples.durations = append(samples.durations, duration)
+		return
+	}
+	samples.durations[samples.next] = duration
+	samples.next = (samples.next + 1) % maxLatencySamples
+}
+
+// GetLatencyStats returns the latency percentiles of all stages in the last
+// interval.
+func GetLatencyStats() []*StageLatency {
+	latencyLock.Lock()
+	defer latencyLock.Unlock()
+
+	stats := make([]*StageLatency, 0, len(latencyStats))
+	for _, stageStats := range latencyStats {
+		copied := *stageStats
+		stats = append(stats, &copied)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Stage < stats[j].Stage
+	})
+	return stats
+}
+
+// LatencySLOExceeded returns whether the latency added to new connections
+// exceeded the configured SLO in the last interval.
+func LatencySLOExceeded() bool {
+	slo := cfgLatencySLO()
+	if slo <= 0 {
+		return false
+	}
+
+	latencyLock.Lock()
+	defer latencyLock.Unlock()
+
+	total, ok := latencyStats[StageConnection]
+	return ok && total.Samples >= minLatencySamples && total.P95 > float64(slo)
+}
+
+func registerLatencyAPI() error {
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:        "debug/latency",
+		Read:        api.PermitUser,
+		BelongsTo:   module,
+		StructFunc:  func(_ *api.Request) (interface{}, error) { return GetLatencyStats(), nil },
+		Name:        "Get Decision Latency",
+		Description: "Returns the latency percentiles of the stages of the decision pipeline within the last 10 seconds.",
+	})
+}
+
+func checkLatency(_ context.Context, _ *modules.Task) error {
+	latencyLock.Lock()
+	latencyStats = make(map[Stage]*StageLatency, len(latencySamples))
+	for stage, samples := range latencySamples {
+		latencyStats[stage] = calculateLatency(stage, samples)
+	}
+	latencySamples = make(map[Stage]*stageSamples)
+	total := latencyStats[StageConnection]
+	subStages := make([]*StageLatency, 0, len(stageNames))
+	for stage := range stageNames {
+		if stats, ok := latencyStats[stage]; ok {
+			subStages = append(subStages, stats)
+		}
+	}
+	latencyLock.Unlock()
+
+	slo := cfgLatencySLO()
+	switch {
+	case slo <= 0:
+		sloViolations = 0
+		module.Resolve(latencySLOFailureID)
+		return nil
+	case total == nil || total.Samples < minLatencySamples:
+		// Not enough data to judge.
+		return nil
+	case total.P95 <= float64(slo):
+		if sloViolations >= sloViolationThreshold {
+			log.Infof("tracing: decision latency is back within the SLO of %dms", slo)
+		}
+		sloViolations = 0
+		module.Resolve(latencySLOFailureID)
+		return nil
+	}
+
+	sloViolations++
+	if sloViolations < sloViolationThreshold {
+		return nil
+	}
+
+	msg := fmt.Sprintf(
+		"New connections were delayed by %.1fms (95th percentile) for more than %s, exceeding the configured %dms.",
+		total.P95,
+		time.Duration(sloViolationThreshold)*latencyInterval,
+		slo,
+	)
+	if slowest := slowestStage(subStages); slowest != nil {
+		msg += fmt.Sprintf(" The slowest stage is %s with %.1fms.", stageNames[slowest.Stage], slowest.P95)
+	}
+	if sloViolations == sloViolationThreshold {
+		log.Warningf("tracing: %s", msg)
+	}
+	module.Warning(latencySLOFailureID, "Slow Connection Decisions", msg)
+	return nil
+}
+
+func calculateLatency(stage Stage, samples *stageSamples) *StageLatency {
+	sorted := make([]time.Duration, len(samples.durations))
+	copy(sorted, samples.durations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	return &StageLatency{
+		Stage:   stage,
+		Samples: samples.observed,
+		P50:     percentile(sorted, 50),
+		P95:     percentile(sorted, 95),
+		P99:     percentile(sorted, 99),
+	}
+}
+
+// percentile returns the given percentile of the sorted durations in
+// milliseconds.
+func percentile(sorted []time.Duration, p int) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	index := (len(sorted)*p+99)/100 - 1
+	if index < 0 {
+		index = 0
+	}
+	return float64(sorted[index]) / float64(time.Millisecond)
+}
+
+// slowestStage returns the stage with the highest 95th percentile.
+func slowestStage(stats []*StageLatency) (slowest *StageLatency) {
+	for _, stageStats := range stats {
+		if slowest == nil || stageStats.P95 > slowest.P95 {
+			slowest = stageStats
+		}
+	}
+	return slowest
+}
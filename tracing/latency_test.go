@@ -0,0 +1,38 @@
+package tracing
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyPercentiles(t *testing.T) {
+	t.Parallel()
+
+	samples := &stageSamples{}
+	for i := 1; i <= 100; i++ {
+		samples.durations = append(samples.durations, time.Duration(101-i)*time.Millisecond)
+		samples.observed++
+	}
+
+	stats := calculateLatency(StageConnection, samples)
+	if stats.Samples != 100 || stats.P50 != 50 || stats.P95 != 95 || stats.P99 != 99 {
+		t.Errorf("unexpected percentiles: %+v", stats)
+	}
+
+	if p := percentile(nil, 95); p != 0 {
+		t.Errorf("expected 0 for no samples, got %f", p)
+	}
+}
+
+func TestSlowestStage(t *testing.T) {
+	t.Parallel()
+
+	slowest := slowestStage([]*StageLatency{
+		{Stage: StageProfileLookup, P95: 2},
+		{Stage: StageFilterLists, P95: 40},
+		{Stage: StageGeoIP, P95: 1},
+	})
+	if slowest == nil || slowest.Stage != StageFilterLists {
+		t.Errorf("expected filterlists to be the slowest stage, got %+v", slowest)
+	}
+}
@@ -0,0 +1,93 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/tevino/abool"
+
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/modules"
+)
+
+var (
+	module *modules.Module
+
+	enabled = abool.New()
+
+	// CfgCollectorKey is the config key for the trace collector endpoint.
+	CfgCollectorKey = "core/traceCollector"
+	cfgCollector    config.StringOption
+
+	// CfgLatencySLOKey is the config key for the decision latency SLO.
+	CfgLatencySLOKey = "core/decisionLatencySLO"
+	cfgLatencySLO    config.IntOption
+)
+
+func init() {
+	module = modules.Register("tracing", prep, start, stop, "base")
+}
+
+func prep() error {
+	if err := config.Register(&config.Option{
+		Name:            "Trace Collector",
+		Key:             CfgCollectorKey,
+		Description:     "Export timing spans of the decision pipeline, from DNS requests over profile and intel lookups to verdicts, to a local OpenTelemetry collector. Enter the OTLP/HTTP traces endpoint of the collector, eg. http://127.0.0.1:4318/v1/traces. Only collectors on localhost are supported, as spans contain domains and process paths.",
+		OptType:         config.OptTypeString,
+		ExpertiseLevel:  config.ExpertiseLevelDeveloper,
+		ReleaseLevel:    config.ReleaseLevelExperimental,
+		DefaultValue:    "",
+		ValidationRegex: `^(http://(127\.0\.0\.1|localhost|\[::1\])(:[0-9]{1,5})?(/.*)?)?$`,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 518,
+			config.CategoryAnnotation:     "Development",
+		},
+	}); err != nil {
+		return err
+	}
+	cfgCollector = config.Concurrent.GetAsString(CfgCollectorKey, "")
+
+	if err := config.Register(&config.Option{
+		Name:           "Decision Latency SLO",
+		Key:            CfgLatencySLOKey,
+		Description:    "Maximum latency in milliseconds that the decision on new connections may add for 95% of connections. A warning naming the slowest stage is shown if the latency exceeds this value for more than a minute. Time spent waiting for prompts is not counted. Set to 0 to disable.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   100,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 520,
+			config.CategoryAnnotation:     "Development",
+			config.UnitAnnotation:         "ms",
+		},
+	}); err != nil {
+		return err
+	}
+	cfgLatencySLO = config.Concurrent.GetAsInt(CfgLatencySLOKey, 100)
+
+	return nil
+}
+
+func start() error {
+	if err := module.RegisterEventHook(
+		"config",
+		"config change",
+		"enable or disable tracing",
+		func(_ context.Context, _ interface{}) error {
+			enabled.SetTo(cfgCollector() != "")
+			return nil
+		},
+	); err != nil {
+		return err
+	}
+	enabled.SetTo(cfgCollector() != "")
+
+	module.StartServiceWorker("span exporter", 0, exporter)
+	module.NewTask("check decision latency", checkLatency).Repeat(latencyInterval)
+
+	return registerLatencyAPI()
+}
+
+func stop() error {
+	enabled.UnSet()
+	return nil
+}
@@ -0,0 +1,191 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/safing/portbase/info"
+	"github.com/safing/portbase/log"
+)
+
+const (
+	// maxBatchSize is the maximum amount of spans sent in one export.
+	maxBatchSize = 256
+
+	// exportInterval is the interval in which queued spans are exported.
+	exportInterval = 5 * time.Second
+
+	exportTimeout = 5 * time.Second
+)
+
+var (
+	spanQueue = make(chan *Span, 4*maxBatchSize)
+
+	exportClient = &http.Client{
+		Timeout: exportTimeout,
+	}
+)
+
+// queueSpan queues a finished span for export. Spans are dropped if the queue
+// is full, as tracing must never slow down the pipeline.
+func queueSpan(s *Span) {
+	select {
+	case spanQueue <- s:
+	default:
+	}
+}
+
+// exporter collects finished spans and exports them in batches.
+func exporter(ctx context.Context) error {
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, maxBatchSize)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case s := <-spanQueue:
+			batch = append(batch, s)
+			if len(batch) < maxBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+
+		if err := export(ctx, batch); err != nil {
+			log.Debugf("tracing: failed to export %d spans: %s", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+}
+
+func export(ctx context.Context, spans []*Span) error {
+	collector := cfgCollector()
+	if collector == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(encodeOTLP(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, collector, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := exportClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// The following types implement the JSON encoding of the OTLP/HTTP trace
+// export request.
+
+type otlpRequest struct {
+	ResourceSpans []*otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   *otlpResource     `json:"resource"`
+	ScopeSpans []*otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []*otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope *otlpScope  `json:"scope"`
+	Spans []*otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string           `json:"traceId"`
+	SpanID            string           `json:"spanId"`
+	ParentSpanID      string           `json:"parentSpanId,omitempty"`
+	Name              string           `json:"name"`
+	Kind              int              `json:"kind"`
+	StartTimeUnixNano string           `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string           `json:"endTimeUnixNano"`
+	Attributes        []*otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string     `json:"key"`
+	Value *otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// otlpSpanKindInternal is the span kind for internal operations.
+const otlpSpanKindInternal = 1
+
+func encodeOTLP(spans []*Span) *otlpRequest {
+	encoded := make([]*otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		s.Lock()
+		span := &otlpSpan{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parentID != [8]byte{} {
+			span.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		for key, value := range s.attributes {
+			span.Attributes = append(span.Attributes, newOTLPAttribute(key, value))
+		}
+		s.Unlock()
+		encoded = append(encoded, span)
+	}
+
+	return &otlpRequest{
+		ResourceSpans: []*otlpResourceSpans{{
+			Resource: &otlpResource{
+				Attributes: []*otlpAttribute{
+					newOTLPAttribute("service.name", "portmaster"),
+					newOTLPAttribute("service.version", info.Version()),
+				},
+			},
+			ScopeSpans: []*otlpScopeSpans{{
+				Scope: &otlpScope{Name: "github.com/safing/portmaster"},
+				Spans: encoded,
+			}},
+		}},
+	}
+}
+
+func newOTLPAttribute(key, value string) *otlpAttribute {
+	return &otlpAttribute{
+		Key:   key,
+		Value: &otlpValue{StringValue: value},
+	}
+}
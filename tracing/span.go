@@ -0,0 +1,125 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/log"
+)
+
+// Spans record their trace ID and attributes as steps of the log tracer of
+// their context, so that log output can be correlated with exported spans.
+// Steps are prefixed with these markers in order to be recognized again.
+const (
+	LogTraceIDPrefix   = "tracing: trace "
+	LogAttributePrefix = "tracing: attribute "
+)
+
+type spanKey struct{}
+
+// Span is a timed operation of the decision pipeline. All methods may be
+// called on a nil Span, which is returned when tracing is disabled and the
+// context has no log tracer.
+type Span struct {
+	sync.Mutex
+
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+
+	logTracer *log.ContextTracer
+
+	name       string
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+	ended      bool
+}
+
+// StartSpan starts a new span as a child of the span in the given context, or
+// as a new trace if there is none. It returns a context holding the new span.
+// If tracing is disabled and the context has no log tracer, the given context
+// and a nil span are returned. Spans are only exported if tracing is enabled.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	if ctx == nil {
+		return ctx, nil
+	}
+	logTracer := log.Tracer(ctx)
+	if !enabled.IsSet() && logTracer == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		logTracer: logTracer,
+		name:      name,
+		start:     time.Now(),
+	}
+	parent := SpanFromContext(ctx)
+	if parent != nil {
+		span.traceID = parent.traceID
+		span.parentID = parent.spanID
+	} else if _, err := rand.Read(span.traceID[:]); err != nil {
+		return ctx, nil
+	}
+	if _, err := rand.Read(span.spanID[:]); err != nil {
+		return ctx, nil
+	}
+
+	// Record the trace ID once per log tracer.
+	if logTracer != nil && (parent == nil || parent.logTracer != logTracer) {
+		logTracer.Tracef("%s%s", LogTraceIDPrefix, hex.EncodeToString(span.traceID[:]))
+	}
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// SpanFromContext returns the span of the given context, if any.
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// SetAttribute sets an attribute of the span.
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.attributes == nil {
+		s.attributes = make(map[string]string)
+	}
+	s.attributes[key] = value
+
+	if s.logTracer != nil {
+		s.logTracer.Tracef("%s%s=%s", LogAttributePrefix, key, value)
+	}
+}
+
+// End ends the span and queues it for export. Subsequent calls have no effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.Lock()
+	if s.ended {
+		s.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.Unlock()
+
+	if enabled.IsSet() {
+		queueSpan(s)
+	}
+}
@@ -0,0 +1,48 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSpans(t *testing.T) {
+	// Spans are not created while disabled.
+	ctx, span := StartSpan(context.Background(), "disabled")
+	if span != nil || SpanFromContext(ctx) != nil {
+		t.Fatal("span created while tracing is disabled")
+	}
+	span.SetAttribute("key", "value")
+	span.End()
+
+	enabled.Set()
+	defer enabled.UnSet()
+
+	ctx, parent := StartSpan(context.Background(), "parent")
+	_, child := StartSpan(ctx, "child")
+	child.SetAttribute("key", "value")
+	child.End()
+	child.End()
+	parent.End()
+
+	if child.traceID != parent.traceID {
+		t.Error("child span does not share the trace ID of its parent")
+	}
+	if child.parentID != parent.spanID {
+		t.Error("child span does not reference its parent")
+	}
+	if len(spanQueue) != 2 {
+		t.Fatalf("expected 2 queued spans, got %d", len(spanQueue))
+	}
+
+	request := encodeOTLP([]*Span{<-spanQueue, <-spanQueue})
+	spans := request.ResourceSpans[0].ScopeSpans[0].Spans
+	if spans[0].Name != "child" || spans[0].ParentSpanID != spans[1].SpanID {
+		t.Errorf("unexpected encoding of child span: %+v", spans[0])
+	}
+	if spans[1].ParentSpanID != "" || len(spans[1].TraceID) != 32 {
+		t.Errorf("unexpected encoding of parent span: %+v", spans[1])
+	}
+	if len(spans[0].Attributes) != 1 || spans[0].Attributes[0].Value.StringValue != "value" {
+		t.Errorf("unexpected attributes: %+v", spans[0].Attributes)
+	}
+}
@@ -1,15 +1,25 @@
 package updates
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+
 	"github.com/safing/portbase/api"
 )
 
 const (
 	apiPathCheckForUpdates = "updates/check"
+	apiPathAvailable       = "updates/available"
+	apiPathImportBundle    = "updates/import"
+	apiPathRollback        = "updates/rollback"
+	apiPathPin             = "updates/pin"
+	apiPathMirrors         = "updates/mirrors"
 )
 
 func registerAPIEndpoints() error {
-	return api.RegisterEndpoint(api.Endpoint{
+	if err := api.RegisterEndpoint(api.Endpoint{
 		Path:      apiPathCheckForUpdates,
 		Write:     api.PermitUser,
 		BelongsTo: module,
@@ -18,9 +28,184 @@ func registerAPIEndpoints() error {
 			if err := TriggerUpdate(); err != nil {
 				return "", err
 			}
+			if ManagedExternally() {
+				return fmt.Sprintf("triggered update check, binaries are managed externally by %s", PackageManager()), nil
+			}
 			return "triggered update check", nil
 		},
 		Name:        "Check for Updates",
-		Description: "Triggers checking for updates.",
+		Description: "Triggers checking for updates. If the Portmaster was installed with a system package manager, only intelligence data is updated.",
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      apiPathAvailable,
+		Read:      api.PermitUser,
+		BelongsTo: module,
+		StructFunc: func(ar *api.Request) (i interface{}, err error) {
+			return GetAvailableUpdates(ar.Context())
+		},
+		Name:        "Get Available Updates",
+		Description: "Refreshes the update indexes and returns the resources that would be upgraded, with their current and new version and the download size, without downloading anything. Use " + apiPathCheckForUpdates + " to download them.",
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      apiPathRollback,
+		Write:     api.PermitAdmin,
+		BelongsTo: module,
+		StructFunc: func(ar *api.Request) (i interface{}, err error) {
+			identifier := ar.Request.URL.Query().Get("identifier")
+			if identifier == "" {
+				return nil, errors.New("missing identifier")
+			}
+			return RollbackResource(identifier)
+		},
+		Name:        "Roll Back Resource",
+		Description: "Pins a resource to the version before the currently selected one and offers restarting into it. The previous version must be available locally. Call repeatedly to go back further.",
+		Parameters: []api.Parameter{{
+			Method:      http.MethodPost,
+			Field:       "identifier",
+			Value:       "linux_amd64/core/portmaster-core",
+			Description: "Specify the identifier of the resource to roll back.",
+		}},
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      apiPathRollback + "/all",
+		Write:     api.PermitAdmin,
+		BelongsTo: module,
+		StructFunc: func(_ *api.Request) (i interface{}, err error) {
+			return RollbackAll()
+		},
+		Name:        "Roll Back All Resources",
+		Description: "Pins all resources that have a previous version available locally to that version and offers restarting into them.",
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      apiPathRollback + "/reset",
+		Write:     api.PermitAdmin,
+		BelongsTo: module,
+		ActionFunc: func(ar *api.Request) (msg string, err error) {
+			if err := ResetRollbacks(ar.Context()); err != nil {
+				return "", err
+			}
+			return "reset rollbacks, the newest versions are selected again", nil
+		},
+		Name:        "Reset Rollbacks",
+		Description: "Removes all pinned versions of previous rollbacks, so that the newest versions are selected again.",
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      apiPathPin,
+		Read:      api.PermitUser,
+		BelongsTo: module,
+		StructFunc: func(_ *api.Request) (i interface{}, err error) {
+			return PinnedVersions(), nil
+		},
+		Name:        "Get Pinned Versions",
+		Description: "Returns the pinned versions of resources by identifier.",
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      apiPathPin + "/set",
+		Write:     api.PermitAdmin,
+		BelongsTo: module,
+		ActionFunc: func(ar *api.Request) (msg string, err error) {
+			identifier := ar.Request.URL.Query().Get("identifier")
+			version := ar.Request.URL.Query().Get("version")
+			if identifier == "" || version == "" {
+				return "", errors.New("missing identifier or version")
+			}
+			if err := PinResource(identifier, version); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("pinned %s to %s", identifier, version), nil
+		},
+		Name:        "Pin Resource Version",
+		Description: "Pins a resource to a specific version, while all other resources keep updating. The version must be available locally.",
+		Parameters: []api.Parameter{{
+			Method:      http.MethodPost,
+			Field:       "identifier",
+			Value:       "all/intel/geoip/geoipv4.mmdb.gz",
+			Description: "Specify the identifier of the resource to pin.",
+		}, {
+			Method:      http.MethodPost,
+			Field:       "version",
+			Value:       "20210101.0.0",
+			Description: "Specify the version to pin the resource to.",
+		}},
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      apiPathPin + "/remove",
+		Write:     api.PermitAdmin,
+		BelongsTo: module,
+		ActionFunc: func(ar *api.Request) (msg string, err error) {
+			identifier := ar.Request.URL.Query().Get("identifier")
+			if identifier == "" {
+				return "", errors.New("missing identifier")
+			}
+			if err := UnpinResource(ar.Context(), identifier); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("unpinned %s, the newest version is selected again", identifier), nil
+		},
+		Name:        "Unpin Resource Version",
+		Description: "Removes the pinned version of a resource, so that its newest version is selected again.",
+		Parameters: []api.Parameter{{
+			Method:      http.MethodPost,
+			Field:       "identifier",
+			Value:       "all/intel/geoip/geoipv4.mmdb.gz",
+			Description: "Specify the identifier of the resource to unpin.",
+		}},
+	}); err != nil {
+		return err
+	}
+
+	if err := api.RegisterEndpoint(api.Endpoint{
+		Path:      apiPathMirrors,
+		Read:      api.PermitUser,
+		BelongsTo: module,
+		StructFunc: func(_ *api.Request) (i interface{}, err error) {
+			return GetMirrorsInfo(), nil
+		},
+		Name:        "Get Update Mirrors",
+		Description: "Returns the update servers in the order they are tried, their health and which server served the latest download of each resource.",
+	}); err != nil {
+		return err
+	}
+
+	return api.RegisterEndpoint(api.Endpoint{
+		Path:      apiPathImportBundle,
+		Write:     api.PermitAdmin,
+		BelongsTo: module,
+		StructFunc: func(ar *api.Request) (i interface{}, err error) {
+			bundlePath := ar.Request.URL.Query().Get("path")
+			if !filepath.IsAbs(bundlePath) {
+				return nil, errors.New("path to bundle must be absolute")
+			}
+			return ImportBundle(ar.Context(), bundlePath)
+		},
+		Name:        "Import Offline Update Bundle",
+		Description: "Imports a signed offline update bundle from a local path, such as a USB drive, and applies the contained updates like an online update. The bundle is a tarball with the layout of the update server, including the signatures of all files.",
+		Parameters: []api.Parameter{{
+			Method:      http.MethodPost,
+			Field:       "path",
+			Value:       "/media/usb/portmaster-updates.tar.gz",
+			Description: "Specify the absolute path to the bundle on the device running the Portmaster.",
+		}},
 	})
 }
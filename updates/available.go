@@ -0,0 +1,133 @@
+package updates
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/updater"
+	"github.com/safing/portmaster/updates/helper"
+)
+
+// AvailableUpdatesEvent is emitted when the available updates were checked
+// without downloading them. The event data is a []*AvailableUpdate.
+const AvailableUpdatesEvent = "available updates"
+
+const availableUpdateSizeTimeout = 10 * time.Second
+
+// AvailableUpdate describes a resource that would be upgraded by the next
+// update check.
+type AvailableUpdate struct {
+	Identifier string
+	// CurrentVersion is the version in use or selected, if any.
+	CurrentVersion string `json:",omitempty"`
+	NewVersion     string
+	// Size holds the download size in bytes. It is -1 if unknown.
+	Size int64
+}
+
+// GetAvailableUpdates refreshes the indexes and returns the resources that
+// would be upgraded by the next update check, without downloading anything.
+func GetAvailableUpdates(ctx context.Context) ([]*AvailableUpdate, error) {
+	ctx = withUpdateRequest(ctx)
+
+	updateMirrors()
+	if err := registry.UpdateIndexes(ctx); err != nil {
+		return nil, fmt.Errorf("failed to update indexes: %w", err)
+	}
+	applyChannelOverrides()
+	if ManagedExternally() {
+		helper.SkipExternallyManagedUpdates(registry)
+	}
+
+	available := pendingUpdates()
+	client := &http.Client{Timeout: availableUpdateSizeTimeout}
+	for _, update := range available {
+		update.Size = fetchUpdateSize(ctx, client, updater.GetVersionedPath(update.Identifier, update.NewVersion))
+	}
+
+	module.TriggerEvent(AvailableUpdatesEvent, available)
+	return available, nil
+}
+
+// pendingUpdates returns the versions that would be downloaded, using the
+// same rules as the registry.
+func pendingUpdates() []*AvailableUpdate {
+	mandatory := make(map[string]struct{}, len(registry.MandatoryUpdates))
+	for _, identifier := range registry.MandatoryUpdates {
+		mandatory[identifier] = struct{}{}
+	}
+
+	available := make([]*AvailableUpdate, 0)
+	for identifier, res := range registry.Export() {
+		res.Lock()
+
+		// Only resources that are or were used, or are mandatory, are
+		// downloaded.
+		wanted := res.ActiveVersion != nil
+		for _, rv := range res.Versions {
+			if rv.Available {
+				wanted = true
+			}
+		}
+		if _, ok := mandatory[identifier]; ok {
+			wanted = true
+		}
+
+		if wanted {
+			var current string
+			switch {
+			case res.ActiveVersion != nil:
+				current = res.ActiveVersion.VersionNumber
+			case res.SelectedVersion != nil:
+				current = res.SelectedVersion.VersionNumber
+			}
+			for _, rv := range res.Versions {
+				if !rv.Available && rv.CurrentRelease {
+					available = append(available, &AvailableUpdate{
+						Identifier:     identifier,
+						CurrentVersion: current,
+						NewVersion:     rv.VersionNumber,
+						Size:           -1,
+					})
+				}
+			}
+		}
+
+		res.Unlock()
+	}
+
+	sort.Slice(available, func(i, j int) bool {
+		return available[i].Identifier < available[j].Identifier
+	})
+	return available
+}
+
+// fetchUpdateSize returns the size of the update file as reported by the
+// update servers, or -1 if unknown.
+func fetchUpdateSize(ctx context.Context, client *http.Client, versionedPath string) int64 {
+	for _, updateURL := range updateURLs() {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, strings.TrimSuffix(updateURL, "/")+"/"+versionedPath, nil)
+		if err != nil {
+			continue
+		}
+		if registry.UserAgent != "" {
+			req.Header.Set("User-Agent", registry.UserAgent)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			log.Debugf("updates: failed to get size of %s from %s: %s", versionedPath, updateURL, err)
+			continue
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode == http.StatusOK && resp.ContentLength >= 0 {
+			return resp.ContentLength
+		}
+	}
+	return -1
+}
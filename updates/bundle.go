@@ -0,0 +1,336 @@
+package updates
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-version"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/updater"
+	"github.com/safing/portmaster/updates/helper"
+)
+
+// Offline update bundles.
+//
+// An offline bundle is a tarball, optionally gzip compressed, with the same
+// layout as the update server: the index files, such as "stable.json", and
+// the resources at their versioned paths. Every file must be accompanied by
+// its signature with the ".sig" suffix, like on the update server.
+// A bundle is only imported if all files are validly signed and its indexes do
+// not downgrade any resource of the installed indexes, as older bundles stay
+// validly signed forever.
+
+const (
+	// maxBundleFileSize is the maximum size of a single file in a bundle.
+	maxBundleFileSize = 1 << 30 // 1GB
+)
+
+// bundleImportLock ensures that only one bundle is imported at a time.
+var bundleImportLock sync.Mutex
+
+// BundleImportResult describes an imported offline bundle.
+type BundleImportResult struct {
+	Indexes   []string
+	Resources []string
+}
+
+// ImportBundle imports the offline update bundle at the given path and
+// applies the contained updates like an online update.
+func ImportBundle(ctx context.Context, bundlePath string) (*BundleImportResult, error) {
+	if !module.Online() {
+		return nil, errors.New("updates module is not online")
+	}
+	if len(updateSigningKey) != ed25519.PublicKeySize {
+		return nil, errors.New("no update signing key configured, bundles cannot be verified")
+	}
+
+	bundleImportLock.Lock()
+	defer bundleImportLock.Unlock()
+
+	// Extract to the tmp dir, which is ignored by storage scans.
+	if err := registry.TmpDir().Ensure(); err != nil {
+		return nil, fmt.Errorf("failed to prepare tmp directory: %w", err)
+	}
+	extractDir, err := ioutil.TempDir(registry.TmpDir().Path, "bundle-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tmp directory: %w", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(extractDir)
+	}()
+
+	files, err := extractBundle(bundlePath, extractDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract bundle: %w", err)
+	}
+
+	result, err := validateBundle(extractDir, files)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundle: %w", err)
+	}
+
+	// Move all files into place.
+	for _, relPath := range append(result.Resources, result.Indexes...) {
+		if err := installBundleFile(extractDir, relPath); err != nil {
+			return nil, err
+		}
+	}
+
+	// Register the resources like an online update.
+	if err := registry.LoadIndexes(ctx); err != nil {
+		log.Warningf("updates: failed to load indexes after bundle import: %s", err)
+	}
+	if err := registry.ScanStorage(""); err != nil {
+		log.Warningf("updates: error during storage scan after bundle import: %s", err)
+	}
+	verifyUpdateSignatures(ctx)
+	selectVersions()
+	if err := registry.UnpackResources(); err != nil {
+		return nil, fmt.Errorf("failed to unpack updates: %w", err)
+	}
+	purgeUpdates()
+	module.TriggerEvent(ResourceUpdateEvent, nil)
+
+	log.Infof("updates: imported offline bundle %s with %d indexes and %d resources", bundlePath, len(result.Indexes), len(result.Resources))
+	return result, nil
+}
+
+// extractBundle extracts all regular files of the bundle into the given
+// directory and returns their relative paths.
+func extractBundle(bundlePath, dstDir string) (files []string, err error) {
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	// Detect gzip compression.
+	var reader io.Reader = f
+	gzipReader, err := gzip.NewReader(f)
+	if err == nil {
+		defer func() {
+			_ = gzipReader.Close()
+		}()
+		reader = gzipReader
+	} else {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		switch {
+		case errors.Is(err, io.EOF):
+			return files, nil
+		case err != nil:
+			return nil, err
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		relPath := path.Clean(strings.TrimPrefix(header.Name, "./"))
+		if path.IsAbs(relPath) || relPath == ".." || strings.HasPrefix(relPath, "../") {
+			return nil, fmt.Errorf("illegal path %s", header.Name)
+		}
+		if header.Size > maxBundleFileSize {
+			return nil, fmt.Errorf("file %s is too big", header.Name)
+		}
+
+		dstPath := filepath.Join(dstDir, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+			return nil, err
+		}
+		dstFile, err := os.OpenFile(dstPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(dstFile, io.LimitReader(tarReader, maxBundleFileSize))
+		closeErr := dstFile.Close()
+		if err != nil {
+			return nil, err
+		}
+		if closeErr != nil {
+			return nil, closeErr
+		}
+
+		files = append(files, relPath)
+	}
+}
+
+// validateBundle checks that all files of the bundle are validly signed and
+// sorts them into indexes and resources.
+func validateBundle(dir string, files []string) (*BundleImportResult, error) {
+	result := &BundleImportResult{}
+	signatures := make(map[string]struct{})
+	for _, relPath := range files {
+		if strings.HasSuffix(relPath, signatureSuffix) {
+			signatures[strings.TrimSuffix(relPath, signatureSuffix)] = struct{}{}
+		}
+	}
+
+	for _, relPath := range files {
+		if strings.HasSuffix(relPath, signatureSuffix) {
+			continue
+		}
+		if _, ok := signatures[relPath]; !ok {
+			return nil, fmt.Errorf("%s is not signed", relPath)
+		}
+		if err := verifyBundleFile(dir, relPath); err != nil {
+			return nil, fmt.Errorf("%s: %w", relPath, err)
+		}
+
+		// Resources have a version in their file name, indexes do not.
+		if _, _, ok := updater.GetIdentifierAndVersion(relPath); ok {
+			result.Resources = append(result.Resources, relPath)
+			continue
+		}
+		if err := checkBundleIndex(dir, relPath); err != nil {
+			return nil, fmt.Errorf("%s: %w", relPath, err)
+		}
+		if err := checkIndexDowngrade(dir, registry.StorageDir().Path, relPath); err != nil {
+			return nil, fmt.Errorf("%s: %w", relPath, err)
+		}
+		result.Indexes = append(result.Indexes, relPath)
+	}
+
+	if len(result.Indexes) == 0 {
+		return nil, errors.New("no index found")
+	}
+	return result, nil
+}
+
+func verifyBundleFile(dir, relPath string) error {
+	filePath := filepath.Join(dir, filepath.FromSlash(relPath))
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	encoded, err := ioutil.ReadFile(filePath + signatureSuffix)
+	if err != nil {
+		return err
+	}
+	return helper.CheckSignature(updateSigningKey, relPath, data, encoded)
+}
+
+// checkBundleIndex checks that the index is a valid index file and only
+// references resources within its authority, like the updater does.
+func checkBundleIndex(dir, relPath string) error {
+	if path.Ext(relPath) != ".json" {
+		return errors.New("unversioned file is not an index")
+	}
+
+	index, err := loadIndexFile(filepath.Join(dir, filepath.FromSlash(relPath)))
+	if err != nil {
+		return err
+	}
+	if len(index) == 0 {
+		return errors.New("index is empty")
+	}
+
+	authoritativePath := path.Dir(relPath) + "/"
+	if authoritativePath == "./" {
+		return nil
+	}
+	for identifier := range index {
+		if !strings.HasPrefix(identifier, authoritativePath) {
+			return fmt.Errorf("index oversteps its authority by defining version for %s", identifier)
+		}
+	}
+	return nil
+}
+
+// checkIndexDowngrade checks that the index of the bundle does not define an
+// older version than the installed index for any resource.
+func checkIndexDowngrade(bundleDir, installedDir, relPath string) error {
+	installed, err := loadIndexFile(filepath.Join(installedDir, filepath.FromSlash(relPath)))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil
+	case err != nil:
+		return fmt.Errorf("failed to load installed index: %w", err)
+	}
+	index, err := loadIndexFile(filepath.Join(bundleDir, filepath.FromSlash(relPath)))
+	if err != nil {
+		return err
+	}
+
+	for identifier, installedVersion := range installed {
+		bundleVersion, ok := index[identifier]
+		if !ok {
+			continue
+		}
+		installedV, err := version.NewSemver(installedVersion)
+		if err != nil {
+			// The updater ignores invalid versions too.
+			continue
+		}
+		bundleV, err := version.NewSemver(bundleVersion)
+		if err != nil {
+			return fmt.Errorf("invalid version %s for %s", bundleVersion, identifier)
+		}
+		if bundleV.LessThan(installedV) {
+			return fmt.Errorf("index downgrades %s from %s to %s", identifier, installedVersion, bundleVersion)
+		}
+	}
+	return nil
+}
+
+func loadIndexFile(filePath string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string]string)
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse index: %w", err)
+	}
+	return index, nil
+}
+
+// installBundleFile moves the file and its signature from the extracted
+// bundle into the update storage.
+func installBundleFile(extractDir, relPath string) error {
+	srcPath := filepath.Join(extractDir, filepath.FromSlash(relPath))
+
+	dstPath := filepath.Join(registry.StorageDir().Path, filepath.FromSlash(relPath))
+	if err := registry.StorageDir().EnsureAbsPath(filepath.Dir(dstPath)); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", relPath, err)
+	}
+	if err := os.Rename(srcPath, dstPath); err != nil {
+		return fmt.Errorf("failed to install %s: %w", relPath, err)
+	}
+	if !onWindows {
+		// Match the permissions of downloaded files.
+		if err := os.Chmod(dstPath, 0755); err != nil {
+			log.Warningf("updates: failed to set permissions on %s: %s", dstPath, err)
+		}
+	}
+
+	// Keep the signature for verification.
+	sigPath := filepath.Join(signatureDir.Path, filepath.FromSlash(relPath)+signatureSuffix)
+	if err := signatureDir.EnsureAbsPath(filepath.Dir(sigPath)); err != nil {
+		return fmt.Errorf("failed to create signature directory for %s: %w", relPath, err)
+	}
+	if err := os.Rename(srcPath+signatureSuffix, sigPath); err != nil {
+		return fmt.Errorf("failed to install signature of %s: %w", relPath, err)
+	}
+	return nil
+}
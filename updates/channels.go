@@ -0,0 +1,131 @@
+package updates
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/updates/helper"
+)
+
+// Resources may be updated from a different release channel than the
+// selected one. The indexes of the override channels are loaded before the
+// indexes of the selected channel, so that all versions are known, and the
+// current release of overridden resources is then set from the indexes of
+// their channel.
+
+// channelOverrideValidationRegex matches an identifier prefix and a release
+// channel.
+const channelOverrideValidationRegex = `^[^ ]+ (stable|beta|staging|support)$`
+
+// getChannelOverrides returns the configured release channels by identifier
+// prefix.
+func getChannelOverrides() map[string]string {
+	overrides := make(map[string]string)
+	for _, entry := range channelOverrides() {
+		fields := strings.Fields(entry)
+		if len(fields) != 2 {
+			continue
+		}
+		overrides[fields[0]] = fields[1]
+	}
+	return overrides
+}
+
+// setIndexes sets the indexes of the registry for the selected release
+// channel and the override channels.
+func setIndexes(selectedChannel string) {
+	var additional []string
+	for _, channel := range getChannelOverrides() {
+		additional = append(additional, channel)
+	}
+	sort.Strings(additional)
+
+	helper.SetIndexes(registry, selectedChannel, additional...)
+}
+
+// overrideChannel returns the release channel of the longest matching
+// override of the identifier, if any.
+func overrideChannel(overrides map[string]string, identifier string) (channel string, ok bool) {
+	var longest string
+	for prefix, prefixChannel := range overrides {
+		if strings.HasPrefix(identifier, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+			channel = prefixChannel
+		}
+	}
+	return channel, longest != ""
+}
+
+// applyChannelOverrides sets the current release of all overridden resources
+// to the version of their release channel. It must be called after the
+// indexes were loaded.
+func applyChannelOverrides() {
+	overrides := getChannelOverrides()
+	if len(overrides) == 0 {
+		return
+	}
+
+	selectedChannel := releaseChannel()
+	channelVersions := make(map[string]map[string]string)
+	for identifier, res := range registry.Export() {
+		channel, ok := overrideChannel(overrides, identifier)
+		if !ok || channel == selectedChannel {
+			continue
+		}
+
+		versions, ok := channelVersions[channel]
+		if !ok {
+			versions = loadChannelVersions(channel)
+			channelVersions[channel] = versions
+		}
+		version, ok := versions[identifier]
+		if !ok {
+			continue
+		}
+		version = strings.TrimPrefix(version, "v")
+
+		res.Lock()
+		found := false
+		for _, rv := range res.Versions {
+			if rv.VersionNumber == version {
+				found = true
+				break
+			}
+		}
+		if found {
+			for _, rv := range res.Versions {
+				rv.CurrentRelease = rv.VersionNumber == version
+			}
+			log.Debugf("updates: using version %s of %s from the %s channel", version, identifier, channel)
+		}
+		res.Unlock()
+	}
+}
+
+// loadChannelVersions returns the current release versions of the given
+// release channel from the index files in the storage.
+func loadChannelVersions(channel string) map[string]string {
+	versions := make(map[string]string)
+	for _, idx := range helper.ChannelIndexes(channel) {
+		data, err := ioutil.ReadFile(filepath.Join(registry.StorageDir().Path, filepath.FromSlash(idx.Path)))
+		if err != nil {
+			log.Warningf("updates: failed to read index %s for release channel overrides: %s", idx.Path, err)
+			continue
+		}
+
+		indexVersions := make(map[string]string)
+		if err := json.Unmarshal(data, &indexVersions); err != nil {
+			log.Warningf("updates: failed to parse index %s for release channel overrides: %s", idx.Path, err)
+			continue
+		}
+		// Later indexes override earlier ones.
+		for identifier, version := range indexVersions {
+			versions[identifier] = version
+		}
+	}
+	return versions
+}
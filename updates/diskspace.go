@@ -0,0 +1,133 @@
+package updates
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/updater"
+)
+
+// Before downloading updates, the free disk space is checked, so that the
+// downloads do not fill up the disk. Old versions are purged according to the
+// configured policy after every update check, and aggressively, keeping only
+// the versions in use, when disk space is low or the storage is too big.
+
+const (
+	// minFreeDiskSpace is the free disk space required to download updates.
+	minFreeDiskSpace = 200 * 1024 * 1024
+	// lowFreeDiskSpace is the free disk space below which old versions are
+	// purged aggressively.
+	lowFreeDiskSpace = 1024 * 1024 * 1024
+
+	updateLowDiskSpace = "updates:low-disk-space"
+)
+
+// checkDiskSpace checks if there is enough free disk space to download
+// updates, and purges old versions aggressively if disk space is low.
+func checkDiskSpace() error {
+	storagePath := registry.StorageDir().Path
+	free, err := freeDiskSpace(storagePath)
+	if err != nil {
+		// Don't block updates if the free space cannot be determined.
+		log.Debugf("updates: failed to get free disk space: %s", err)
+		return nil
+	}
+
+	if free < lowFreeDiskSpace {
+		log.Warningf("updates: disk space is low (%d MB free), purging old versions", free/1024/1024)
+		purgeAggressively()
+
+		free, err = freeDiskSpace(storagePath)
+		if err != nil {
+			log.Debugf("updates: failed to get free disk space: %s", err)
+			return nil
+		}
+	}
+
+	if free < minFreeDiskSpace {
+		module.Warning(
+			updateLowDiskSpace,
+			"Not Enough Disk Space for Updates",
+			fmt.Sprintf(
+				"The Portmaster needs at least %d MB of free disk space to download updates, but only %d MB are available. Please free up disk space.",
+				minFreeDiskSpace/1024/1024,
+				free/1024/1024,
+			),
+		)
+		return fmt.Errorf("not enough free disk space: %d MB available", free/1024/1024)
+	}
+
+	module.Resolve(updateLowDiskSpace)
+	return nil
+}
+
+// purgeUpdates purges old versions according to the configured purge policy.
+func purgeUpdates() {
+	registry.Purge(int(retainedVersions()))
+
+	// Purge aggressively if the storage is too big.
+	if maxSize := maxStorageSize() * 1024 * 1024; maxSize > 0 {
+		size, err := storageSize()
+		switch {
+		case err != nil:
+			log.Warningf("updates: failed to get size of update storage: %s", err)
+		case size > maxSize:
+			log.Infof("updates: update storage exceeds maximum size (%d MB), purging old versions", size/1024/1024)
+			purgeAggressively()
+		}
+	}
+
+	// Purge aggressively if disk space is low.
+	free, err := freeDiskSpace(registry.StorageDir().Path)
+	if err == nil && free < lowFreeDiskSpace {
+		log.Warningf("updates: disk space is low (%d MB free), purging old versions", free/1024/1024)
+		purgeAggressively()
+	}
+}
+
+// purgeAggressively deletes all available versions that are neither in use
+// nor selected.
+func purgeAggressively() {
+	for _, res := range registry.Export() {
+		for _, rv := range purgeableVersions(res) {
+			versionedPath := updater.GetVersionedPath(res.Identifier, rv.VersionNumber)
+			storagePath := filepath.Join(registry.StorageDir().Path, filepath.FromSlash(versionedPath))
+			if err := os.Remove(storagePath); err != nil && !os.IsNotExist(err) {
+				log.Warningf("updates: failed to purge %s: %s", versionedPath, err)
+				continue
+			}
+			markMissing(res, rv, versionedPath)
+			log.Debugf("updates: purged %s", versionedPath)
+		}
+	}
+}
+
+func purgeableVersions(res *updater.Resource) []*updater.ResourceVersion {
+	res.Lock()
+	defer res.Unlock()
+
+	var versions []*updater.ResourceVersion
+	for _, rv := range res.Versions {
+		if rv.Available && rv != res.ActiveVersion && rv != res.SelectedVersion {
+			versions = append(versions, rv)
+		}
+	}
+	return versions
+}
+
+// storageSize returns the size of all files in the update storage in bytes.
+func storageSize() (int64, error) {
+	var size int64
+	err := filepath.Walk(registry.StorageDir().Path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
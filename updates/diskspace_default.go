@@ -0,0 +1,17 @@
+// +build !windows
+
+package updates
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// freeDiskSpace returns the free disk space available to the Portmaster at
+// the given path in bytes.
+func freeDiskSpace(path string) (uint64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
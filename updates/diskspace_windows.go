@@ -0,0 +1,20 @@
+package updates
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+// freeDiskSpace returns the free disk space available to the Portmaster at
+// the given path in bytes.
+func freeDiskSpace(path string) (uint64, error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var free uint64
+	if err := windows.GetDiskFreeSpaceEx(pathPtr, &free, nil, nil); err != nil {
+		return 0, err
+	}
+	return free, nil
+}
@@ -38,6 +38,9 @@ type versions struct {
 	Channel   string
 	Beta      bool
 	Staging   bool
+	// ManagedBy holds the system package manager that updates the binaries,
+	// if any.
+	ManagedBy string
 
 	internalSave bool
 }
@@ -49,6 +52,7 @@ func initVersionExport() (err error) {
 		Channel:      initialReleaseChannel,
 		Beta:         initialReleaseChannel == helper.ReleaseChannelBeta,
 		Staging:      initialReleaseChannel == helper.ReleaseChannelStaging,
+		ManagedBy:    PackageManager(),
 	}
 	versionExport.SetKey(versionsDBKey)
 
@@ -76,6 +80,7 @@ func export(_ context.Context, _ interface{}) error {
 	versionExport.lock.Lock()
 	versionExport.Core = info.GetInfo()
 	versionExport.Resources = registry.Export()
+	versionExport.ManagedBy = PackageManager()
 	versionExport.lock.Unlock()
 
 	// save
@@ -10,6 +10,9 @@
 // GetPlatformFile returns the latest platform specific file identified by the given identifier.
 func GetPlatformFile(identifier string) (*updater.File, error) {
 	identifier = helper.PlatformIdentifier(identifier)
+	if err := checkSelectedVersion(identifier); err != nil {
+		return nil, err
+	}
 
 	file, err := registry.GetFile(identifier)
 	if err != nil {
@@ -23,6 +26,9 @@ func GetPlatformFile(identifier string) (*updater.File, error) {
 // GetFile returns the latest generic file identified by the given identifier.
 func GetFile(identifier string) (*updater.File, error) {
 	identifier = path.Join("all", identifier)
+	if err := checkSelectedVersion(identifier); err != nil {
+		return nil, err
+	}
 
 	file, err := registry.GetFile(identifier)
 	if err != nil {
@@ -14,54 +14,81 @@
 )
 
 // SetIndexes sets the update registry indexes and also configures the registry
-// to use pre-releases based on the channel.
-func SetIndexes(registry *updater.ResourceRegistry, releaseChannel string) {
-	usePreReleases := false
-
+// to use pre-releases based on the channel. The indexes of additional channels
+// may be given, so that their versions are known for per-resource channel
+// overrides. They are added first, so that they do not change the current
+// releases of the selected channel.
+func SetIndexes(registry *updater.ResourceRegistry, releaseChannel string, additionalChannels ...string) {
 	// Be reminded that the order is important, as indexes added later will
 	// override the current release from earlier indexes.
 
 	// Reset indexes before adding them (again).
 	registry.ResetIndexes()
 
-	// Always add the stable index as a base.
+	// Add the indexes of additional channels that are not part of the selected
+	// channel.
+	selected := make(map[string]struct{})
+	for _, idx := range ChannelIndexes(releaseChannel) {
+		selected[idx.Path] = struct{}{}
+	}
+	for _, channel := range additionalChannels {
+		for _, idx := range ChannelIndexes(channel) {
+			if _, ok := selected[idx.Path]; ok {
+				continue
+			}
+			selected[idx.Path] = struct{}{}
+			registry.AddIndex(idx)
+		}
+	}
+
+	// Add the indexes of the selected channel.
+	for _, idx := range ChannelIndexes(releaseChannel) {
+		registry.AddIndex(idx)
+	}
+
+	// Add the intel index last, as it updates the fastest and should not be
+	// crippled by other faulty indexes. It can only specify versions for its
+	// scope anyway.
 	registry.AddIndex(updater.Index{
-		Path: ReleaseChannelStable + ".json",
+		Path: "all/intel/intel.json",
 	})
 
+	// Set pre-release usage.
+	registry.SetUsePreReleases(releaseChannel == ReleaseChannelBeta ||
+		releaseChannel == ReleaseChannelStaging)
+}
+
+// ChannelIndexes returns the indexes that make up the given release channel,
+// in the order they must be applied. The intel index is not included.
+func ChannelIndexes(releaseChannel string) []updater.Index {
+	// Always add the stable index as a base.
+	indexes := []updater.Index{{
+		Path: ReleaseChannelStable + ".json",
+	}}
+
 	// Add beta index if in beta or staging channel.
 	if releaseChannel == ReleaseChannelBeta ||
 		releaseChannel == ReleaseChannelStaging {
-		registry.AddIndex(updater.Index{
+		indexes = append(indexes, updater.Index{
 			Path:       ReleaseChannelBeta + ".json",
 			PreRelease: true,
 		})
-		usePreReleases = true
 	}
 
 	// Add staging index if in staging channel.
 	if releaseChannel == ReleaseChannelStaging {
-		registry.AddIndex(updater.Index{
+		indexes = append(indexes, updater.Index{
 			Path:       ReleaseChannelStaging + ".json",
 			PreRelease: true,
 		})
-		usePreReleases = true
 	}
 
 	// Add support index if in support channel.
 	if releaseChannel == ReleaseChannelSupport {
-		registry.AddIndex(updater.Index{
+		indexes = append(indexes, updater.Index{
 			Path: ReleaseChannelSupport + ".json",
 		})
 	}
 
-	// Add the intel index last, as it updates the fastest and should not be
-	// crippled by other faulty indexes. It can only specify versions for its
-	// scope anyway.
-	registry.AddIndex(updater.Index{
-		Path: "all/intel/intel.json",
-	})
-
-	// Set pre-release usage.
-	registry.SetUsePreReleases(usePreReleases)
+	return indexes
 }
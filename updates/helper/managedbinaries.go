@@ -0,0 +1,7 @@
+// +build managedbinaries
+
+package helper
+
+// Distribution packages are built with the managedbinaries build tag, so that
+// the binaries are never replaced by the Portmaster.
+const binariesManagedByBuild = true
@@ -0,0 +1,5 @@
+// +build !managedbinaries
+
+package helper
+
+const binariesManagedByBuild = false
@@ -0,0 +1,71 @@
+package helper
+
+import (
+	"strings"
+
+	"github.com/safing/portbase/updater"
+)
+
+// Package managers that the Portmaster can be installed with.
+const (
+	PackageManagerAPT        = "apt"
+	PackageManagerRPM        = "rpm"
+	PackageManagerAUR        = "aur"
+	PackageManagerChocolatey = "chocolatey"
+	PackageManagerWinget     = "winget"
+
+	// PackageManagerExternal is used if the binaries are managed externally
+	// by configuration or build, without a detected package manager.
+	PackageManagerExternal = "external"
+)
+
+// DetectPackageManager returns the system package manager the Portmaster was
+// installed with, or an empty string if it was not installed with one.
+// Builds with the managedbinaries build tag are always managed externally.
+func DetectPackageManager() string {
+	if packageManager := detectPackageManager(); packageManager != "" {
+		return packageManager
+	}
+	if binariesManagedByBuild {
+		return PackageManagerExternal
+	}
+	return ""
+}
+
+// IsExternallyManaged returns whether the resource with the given identifier
+// is updated by the package manager, if the Portmaster was installed with
+// one. Intelligence data is always updated by the Portmaster itself.
+func IsExternallyManaged(identifier string) bool {
+	return !strings.HasPrefix(identifier, "all/intel/")
+}
+
+// WithoutExternallyManaged returns the given identifiers without the ones
+// that are updated by the package manager.
+func WithoutExternallyManaged(identifiers []string) []string {
+	filtered := make([]string, 0, len(identifiers))
+	for _, identifier := range identifiers {
+		if !IsExternallyManaged(identifier) {
+			filtered = append(filtered, identifier)
+		}
+	}
+	return filtered
+}
+
+// SkipExternallyManagedUpdates prevents the registry from downloading new
+// versions of externally managed resources. It must be called after updating
+// the indexes and before downloading updates.
+func SkipExternallyManagedUpdates(reg *updater.ResourceRegistry) {
+	for identifier, res := range reg.Export() {
+		if !IsExternallyManaged(identifier) {
+			continue
+		}
+
+		res.Lock()
+		for _, rv := range res.Versions {
+			if !rv.Available {
+				rv.CurrentRelease = false
+			}
+		}
+		res.Unlock()
+	}
+}
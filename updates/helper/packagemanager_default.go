@@ -0,0 +1,7 @@
+// +build !linux,!windows
+
+package helper
+
+func detectPackageManager() string {
+	return ""
+}
@@ -0,0 +1,28 @@
+package helper
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+func detectPackageManager() string {
+	// Debian based distributions.
+	if _, err := os.Stat("/var/lib/dpkg/info/portmaster.list"); err == nil {
+		return PackageManagerAPT
+	}
+
+	// Arch based distributions.
+	if matches, _ := filepath.Glob("/var/lib/pacman/local/portmaster*"); len(matches) > 0 {
+		return PackageManagerAUR
+	}
+
+	// RPM based distributions.
+	if rpmPath, err := exec.LookPath("rpm"); err == nil {
+		if exec.Command(rpmPath, "--query", "--quiet", "portmaster").Run() == nil {
+			return PackageManagerRPM
+		}
+	}
+
+	return ""
+}
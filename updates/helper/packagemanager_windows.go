@@ -0,0 +1,27 @@
+package helper
+
+import (
+	"os"
+	"path/filepath"
+)
+
+func detectPackageManager() string {
+	// Chocolatey keeps installed packages in its lib directory.
+	chocolateyDir := os.Getenv("ChocolateyInstall")
+	if chocolateyDir == "" {
+		chocolateyDir = filepath.Join(os.Getenv("ProgramData"), "chocolatey")
+	}
+	if _, err := os.Stat(filepath.Join(chocolateyDir, "lib", "portmaster")); err == nil {
+		return PackageManagerChocolatey
+	}
+
+	// Winget keeps the installed packages of the user in the local app data.
+	if localAppData := os.Getenv("LOCALAPPDATA"); localAppData != "" {
+		pattern := filepath.Join(localAppData, "Microsoft", "WinGet", "Packages", "Safing.Portmaster*")
+		if matches, _ := filepath.Glob(pattern); len(matches) > 0 {
+			return PackageManagerWinget
+		}
+	}
+
+	return ""
+}
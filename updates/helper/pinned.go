@@ -0,0 +1,96 @@
+package helper
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/updater"
+	"github.com/safing/portbase/utils/renameio"
+)
+
+// PinnedVersionsFile is the file in the update storage that holds the pinned
+// versions of resources. It maps identifiers to versions.
+const PinnedVersionsFile = "pinned.json"
+
+// LoadPinnedVersions loads the pinned versions of the registry.
+func LoadPinnedVersions(reg *updater.ResourceRegistry) (map[string]string, error) {
+	pins := make(map[string]string)
+
+	data, err := ioutil.ReadFile(filepath.Join(reg.StorageDir().Path, PinnedVersionsFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return pins, nil
+		}
+		return pins, err
+	}
+
+	if err := json.Unmarshal(data, &pins); err != nil {
+		return make(map[string]string), err
+	}
+	return pins, nil
+}
+
+// SavePinnedVersions saves the pinned versions of the registry.
+func SavePinnedVersions(reg *updater.ResourceRegistry, pins map[string]string) error {
+	pinnedPath := filepath.Join(reg.StorageDir().Path, PinnedVersionsFile)
+	if len(pins) == 0 {
+		err := os.Remove(pinnedPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(pins, "", "  ")
+	if err != nil {
+		return err
+	}
+	return renameio.WriteFile(pinnedPath, data, 0644)
+}
+
+// ApplyPinnedVersions blacklists all versions that are newer than the pinned
+// versions, so that the pinned versions are selected by the registry. Pins of
+// versions that are not available locally are ignored. It must be called
+// before selecting versions and returns the versioned paths of the versions
+// it blacklisted.
+func ApplyPinnedVersions(reg *updater.ResourceRegistry, pins map[string]string) (blacklisted []string) {
+	resources := reg.Export()
+	for identifier, version := range pins {
+		res, ok := resources[identifier]
+		if !ok {
+			log.Warningf("updates: ignoring pinned version of unknown resource %s", identifier)
+			continue
+		}
+		blacklisted = append(blacklisted, applyPinnedVersion(res, version)...)
+	}
+	return blacklisted
+}
+
+func applyPinnedVersion(res *updater.Resource, version string) (blacklisted []string) {
+	res.Lock()
+	defer res.Unlock()
+
+	var pinned *updater.ResourceVersion
+	for _, rv := range res.Versions {
+		if rv.Available && rv.EqualsVersion(version) {
+			pinned = rv
+			break
+		}
+	}
+	if pinned == nil {
+		log.Warningf("updates: ignoring pinned version %s of %s, as it is not available", version, res.Identifier)
+		return nil
+	}
+
+	for _, rv := range res.Versions {
+		if !rv.Blacklisted && rv.SemVer().GreaterThan(pinned.SemVer()) {
+			rv.Blacklisted = true
+			blacklisted = append(blacklisted, updater.GetVersionedPath(res.Identifier, rv.VersionNumber))
+		}
+	}
+	return blacklisted
+}
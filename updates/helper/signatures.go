@@ -0,0 +1,241 @@
+package helper
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/updater"
+	"github.com/safing/portbase/utils"
+)
+
+// Every update file is signed with Ed25519. The signature is published next
+// to the file on the update server with the ".sig" suffix and contains the
+// base64 encoded signature of the versioned path of the file, a newline and
+// the complete file (see SignedData). Including the versioned path binds the
+// signature to the identifier and version, so that a validly signed file
+// cannot be served as another resource or as another version.
+//
+// The verification is shared by the Portmaster Core and portmaster-start, so
+// that portmaster-start does not select or execute unverified files either.
+
+const (
+	// SignatureSuffix is the suffix of signature files.
+	SignatureSuffix = ".sig"
+
+	// SignatureDirName is the name of the directory in the data root that
+	// signatures are saved in. It is kept outside of the update storage, so
+	// that signatures are not picked up as resources.
+	SignatureDirName = "update-signatures"
+
+	signatureFetchTimeout = 30 * time.Second
+)
+
+var (
+	// ErrSignatureUnavailable is returned if the signature of a file could
+	// not be fetched from the update servers.
+	ErrSignatureUnavailable = errors.New("signature unavailable")
+
+	// ErrUnsigned is returned if the update server does not have a signature
+	// for a file.
+	ErrUnsigned = errors.New("file is not signed")
+
+	// ErrInvalidSignature is returned if the signature of a file is malformed
+	// or does not match the file.
+	ErrInvalidSignature = errors.New("invalid signature")
+)
+
+// updateSigningKeyData holds the base64 encoded Ed25519 public key of the
+// release signing key that update files must be signed with. It is pinned at
+// build time by the build scripts, which refuse to build releases without it:
+//   -ldflags "-X github.com/safing/portmaster/updates/helper.updateSigningKeyData=<key>"
+var updateSigningKeyData string
+
+// SigningKeyData returns the base64 encoded update signing key that was
+// built in, if any.
+func SigningKeyData() string {
+	return updateSigningKeyData
+}
+
+// DecodeSigningKey decodes a base64 encoded update signing key.
+func DecodeSigningKey(encoded string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid update signing key")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// SignedData returns the data that is signed for the file at the given
+// versioned path.
+func SignedData(versionedPath string, data []byte) []byte {
+	signed := make([]byte, 0, len(versionedPath)+1+len(data))
+	signed = append(signed, versionedPath...)
+	signed = append(signed, '\n')
+	return append(signed, data...)
+}
+
+// CheckSignature checks the base64 encoded signature of the file at the given
+// versioned path with the given content.
+func CheckSignature(key ed25519.PublicKey, versionedPath string, data, encodedSig []byte) error {
+	if len(key) != ed25519.PublicKeySize {
+		return errors.New("no valid signing key")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encodedSig)))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return fmt.Errorf("%w: malformed", ErrInvalidSignature)
+	}
+
+	if !ed25519.Verify(key, SignedData(versionedPath, data), sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// SignatureVerifier verifies the files of a registry with their signatures.
+type SignatureVerifier struct {
+	Registry     *updater.ResourceRegistry
+	Key          ed25519.PublicKey
+	SignatureDir *utils.DirStructure
+
+	// UpdateURLs returns the servers to fetch signatures from. If nil, the
+	// update URLs of the registry are used.
+	UpdateURLs func() []string
+	// Client is used to fetch signatures. If nil, a client with a default
+	// timeout is used.
+	Client *http.Client
+}
+
+// VerifyFile verifies the file at the given versioned path in the update
+// storage against its signature. If fetch is set, the signature is downloaded
+// if it is not yet available locally.
+func (v *SignatureVerifier) VerifyFile(ctx context.Context, versionedPath string, fetch bool) error {
+	data, err := ioutil.ReadFile(filepath.Join(v.Registry.StorageDir().Path, filepath.FromSlash(versionedPath)))
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+
+	encodedSig, err := v.LoadSignature(ctx, versionedPath, fetch)
+	if err != nil {
+		return err
+	}
+
+	return CheckSignature(v.Key, versionedPath, data, encodedSig)
+}
+
+// VerifyAvailable verifies all available versions of the registry and
+// blacklists the ones that fail, so that they are not selected. It returns
+// the versioned paths of the rejected files.
+func (v *SignatureVerifier) VerifyAvailable(ctx context.Context, fetch bool) (rejected []string) {
+	for _, res := range v.Registry.Export() {
+		res.Lock()
+		versions := make([]*updater.ResourceVersion, 0, len(res.Versions))
+		for _, rv := range res.Versions {
+			if rv.Available && !rv.Blacklisted {
+				versions = append(versions, rv)
+			}
+		}
+		res.Unlock()
+
+		for _, rv := range versions {
+			versionedPath := updater.GetVersionedPath(res.Identifier, rv.VersionNumber)
+			if err := v.VerifyFile(ctx, versionedPath, fetch); err != nil {
+				if ctx.Err() != nil {
+					return rejected
+				}
+				log.Warningf("updates: rejecting %s: %s", versionedPath, err)
+
+				res.Lock()
+				rv.Blacklisted = true
+				res.Unlock()
+				rejected = append(rejected, versionedPath)
+			}
+		}
+	}
+	return rejected
+}
+
+// LoadSignature returns the encoded signature of the file at the given
+// versioned path.
+func (v *SignatureVerifier) LoadSignature(ctx context.Context, versionedPath string, fetch bool) ([]byte, error) {
+	sigPath := filepath.Join(v.SignatureDir.Path, filepath.FromSlash(versionedPath)+SignatureSuffix)
+
+	encoded, err := ioutil.ReadFile(sigPath)
+	if err != nil {
+		if !fetch {
+			return nil, fmt.Errorf("%w: not saved locally", ErrSignatureUnavailable)
+		}
+		encoded, err = v.fetchSignature(ctx, versionedPath)
+		if err != nil {
+			return nil, err
+		}
+
+		// Save the signature for verifying the file again later.
+		if err := v.SignatureDir.EnsureAbsPath(filepath.Dir(sigPath)); err != nil {
+			log.Warningf("updates: failed to create signature directory: %s", err)
+		} else if err := ioutil.WriteFile(sigPath, encoded, 0644); err != nil { //nolint:gosec // not secret
+			log.Warningf("updates: failed to save signature %s: %s", sigPath, err)
+		}
+	}
+
+	return encoded, nil
+}
+
+func (v *SignatureVerifier) fetchSignature(ctx context.Context, versionedPath string) (data []byte, err error) {
+	updateURLs := v.Registry.UpdateURLs
+	if v.UpdateURLs != nil {
+		updateURLs = v.UpdateURLs()
+	}
+
+	for _, updateURL := range updateURLs {
+		data, err = v.fetchSignatureFrom(ctx, strings.TrimSuffix(updateURL, "/")+"/"+versionedPath+SignatureSuffix)
+		if err == nil || errors.Is(err, ErrUnsigned) {
+			return data, err
+		}
+	}
+	if err == nil {
+		err = errors.New("no update servers configured")
+	}
+	return nil, fmt.Errorf("%w: %s", ErrSignatureUnavailable, err)
+}
+
+func (v *SignatureVerifier) fetchSignatureFrom(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if v.Registry.UserAgent != "" {
+		req.Header.Set("User-Agent", v.Registry.UserAgent)
+	}
+
+	client := v.Client
+	if client == nil {
+		client = &http.Client{Timeout: signatureFetchTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, ErrUnsigned
+	default:
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	// Signatures are small, limit the read size.
+	return ioutil.ReadAll(&io.LimitedReader{R: resp.Body, N: 1024})
+}
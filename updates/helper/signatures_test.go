@@ -0,0 +1,53 @@
+package helper
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func TestCheckSignature(t *testing.T) {
+	pubKey, privKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versionedPath := "linux_amd64/core/portmaster-core_v0-6-0"
+	data := []byte("update file")
+	sig := []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(privKey, SignedData(versionedPath, data))) + "\n")
+
+	if err := CheckSignature(pubKey, versionedPath, data, sig); err != nil {
+		t.Errorf("valid signature was rejected: %s", err)
+	}
+	if err := CheckSignature(pubKey, versionedPath, []byte("tampered file"), sig); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("signature of tampered file was not rejected as invalid: %v", err)
+	}
+	if err := CheckSignature(pubKey, versionedPath, data, []byte("not base64!")); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("malformed signature was not rejected as invalid: %v", err)
+	}
+
+	// The signature must not be valid for another version or resource.
+	if err := CheckSignature(pubKey, "linux_amd64/core/portmaster-core_v0-5-0", data, sig); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("signature of other version was not rejected as invalid: %v", err)
+	}
+	if err := CheckSignature(pubKey, "linux_amd64/start/portmaster-start_v0-6-0", data, sig); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("signature of other resource was not rejected as invalid: %v", err)
+	}
+	// The signature of the plain file must not be accepted.
+	plainSig := []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(privKey, data)))
+	if err := CheckSignature(pubKey, versionedPath, data, plainSig); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("signature without versioned path was not rejected as invalid: %v", err)
+	}
+
+	otherKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckSignature(otherKey, versionedPath, data, sig); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("signature of other key was not rejected as invalid: %v", err)
+	}
+	// A missing key must be rejected instead of panicking.
+	if err := CheckSignature(nil, versionedPath, data, sig); err == nil {
+		t.Error("signature was accepted without a key")
+	}
+}
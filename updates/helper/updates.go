@@ -56,6 +56,12 @@ func MandatoryUpdates() (identifiers []string) {
 		// Geo IP data
 		"all/intel/geoip/geoipv4.mmdb.gz",
 		"all/intel/geoip/geoipv6.mmdb.gz",
+
+		// Destination classification data
+		"all/intel/classification/domains.json",
+
+		// Profile templates
+		"all/profile/templates.json",
 	)
 
 	return identifiers
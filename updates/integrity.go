@@ -0,0 +1,146 @@
+package updates
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/portbase/updater"
+)
+
+// The update storage is verified regularly, so that corrupted or missing
+// files are downloaded again before they are needed, instead of failing when
+// upgrading. The indexes do not contain checksums, so files are verified
+// against their signature, which covers the complete file.
+
+const (
+	integrityCheckInterval = 24 * time.Hour
+
+	updateIntegrityFailed = "updates:integrity-failed"
+)
+
+var integrityTask *modules.Task
+
+func initIntegrityCheck() {
+	integrityTask = module.NewTask("verify update storage", verifyStorageIntegrity)
+	if !disableTaskSchedule {
+		integrityTask.
+			Repeat(integrityCheckInterval).
+			MaxDelay(1 * time.Hour).
+			Schedule(time.Now().Add(30 * time.Minute))
+	}
+}
+
+func verifyStorageIntegrity(ctx context.Context, _ *modules.Task) error {
+	client := &http.Client{Timeout: signatureFetchTimeout}
+	ctx = withUpdateRequest(ctx)
+
+	var repaired []string
+	for _, res := range registry.Export() {
+		for _, rv := range verifiedAvailableVersions(res) {
+			versionedPath := updater.GetVersionedPath(res.Identifier, rv.VersionNumber)
+			storagePath := filepath.Join(registry.StorageDir().Path, filepath.FromSlash(versionedPath))
+
+			// Check if the file is missing.
+			if _, err := os.Stat(storagePath); os.IsNotExist(err) {
+				log.Warningf("updates: %s is missing from the update storage", versionedPath)
+				markMissing(res, rv, versionedPath)
+				repaired = append(repaired, versionedPath+" (missing)")
+				continue
+			}
+
+			// Check if the file is corrupted.
+			if registry.DevMode {
+				continue
+			}
+			err := verifyUpdateFile(ctx, client, versionedPath, true)
+			switch {
+			case err == nil:
+			case ctx.Err() != nil:
+				return nil
+			case errors.Is(err, errInvalidSignature):
+				log.Warningf("updates: %s is corrupted: %s", versionedPath, err)
+				unmarkVerified(versionedPath)
+				rejectVersion(res, rv, versionedPath, true)
+				repaired = append(repaired, versionedPath+" (corrupted)")
+			default:
+				// Cannot be verified right now, try again next time.
+				log.Debugf("updates: skipping integrity check of %s: %s", versionedPath, err)
+			}
+		}
+	}
+
+	if len(repaired) == 0 {
+		log.Debugf("updates: verified integrity of update storage")
+		module.Resolve(updateIntegrityFailed)
+		return nil
+	}
+
+	selectVersions()
+	module.Warning(
+		updateIntegrityFailed,
+		"Damaged Update Files",
+		fmt.Sprintf(
+			"The following update files were missing or damaged and are downloaded again. If this persists, check the health of your disk.\n\n- %s",
+			strings.Join(repaired, "\n- "),
+		),
+	)
+
+	// Download the files again, regardless of the update schedule.
+	forceUpdate.Set()
+	if err := TriggerUpdate(); err != nil {
+		log.Warningf("updates: failed to trigger download of damaged update files: %s", err)
+	}
+	return nil
+}
+
+// verifiedAvailableVersions returns the versions of the resource that are
+// available locally and were verified. Other versions are verified with the
+// next update check anyway.
+func verifiedAvailableVersions(res *updater.Resource) []*updater.ResourceVersion {
+	res.Lock()
+	defer res.Unlock()
+
+	verifiedVersionsLock.Lock()
+	defer verifiedVersionsLock.Unlock()
+
+	var versions []*updater.ResourceVersion
+	for _, rv := range res.Versions {
+		if !rv.Available {
+			continue
+		}
+		if !registry.DevMode {
+			versionedPath := updater.GetVersionedPath(res.Identifier, rv.VersionNumber)
+			if _, ok := verifiedVersions[versionedPath]; !ok {
+				continue
+			}
+		}
+		versions = append(versions, rv)
+	}
+	return versions
+}
+
+// markMissing marks the version as not available, so that it is downloaded
+// again.
+func markMissing(res *updater.Resource, rv *updater.ResourceVersion, versionedPath string) {
+	unmarkVerified(versionedPath)
+
+	res.Lock()
+	defer res.Unlock()
+	rv.Available = false
+}
+
+func unmarkVerified(versionedPath string) {
+	verifiedVersionsLock.Lock()
+	defer verifiedVersionsLock.Unlock()
+
+	delete(verifiedVersions, versionedPath)
+	saveVerifiedVersions()
+}
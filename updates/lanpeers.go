@@ -0,0 +1,512 @@
+package updates
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/rng"
+	"github.com/safing/portmaster/network/netutils"
+)
+
+// LAN update sharing.
+//
+// If enabled, the Portmaster serves its verified update files to other
+// Portmaster installs in the LAN that share the same secret. Peers are
+// discovered via mDNS and are preferred over the update servers when
+// downloading updates. Indexes and signatures are always fetched from the
+// update servers, so peers cannot influence which versions are selected, and
+// files from peers are verified like any other update file.
+//
+// Requests to peers and their responses are authenticated with the shared
+// secret: the response authentication covers the request token and the hash
+// of the served file, so that only peers with the secret can serve files.
+// Peers are only used while signatures are enforced, which is not the case
+// in dev mode.
+
+const (
+	// LANSharingPort is the TCP port on which update files are served to LAN
+	// peers.
+	LANSharingPort = 8717
+
+	lanServiceName = "_portmaster-updates._tcp.local."
+	lanPeerTTL     = 120
+
+	// lanPeerDiscoveryTime is how long to wait for peers to answer.
+	lanPeerDiscoveryTime = 2 * time.Second
+	// lanPeerMaxAge is how long a discovered peer is used.
+	lanPeerMaxAge = 10 * time.Minute
+	// maxLANPeers limits the amount of peers used for downloading, so that
+	// the last download attempt always goes to the update server.
+	maxLANPeers = 2
+
+	lanAuthScheme         = "PM-LAN"
+	lanAuthMaxAge         = 5 * time.Minute
+	lanResponseAuthHeader = "X-Portmaster-LAN-Auth"
+
+	lanSharingFailed = "updates:lan-sharing-failed"
+)
+
+var (
+	mDNSAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+	lanSharing struct {
+		sync.Mutex
+
+		active   bool
+		instance string
+		secret   []byte
+		server   *http.Server
+		mDNSConn *net.UDPConn
+		// peers maps base URLs of peers to when they were last seen.
+		peers map[string]time.Time
+	}
+)
+
+// LANSharingActive returns whether update files are currently shared with
+// LAN peers.
+func LANSharingActive() bool {
+	lanSharing.Lock()
+	defer lanSharing.Unlock()
+
+	return lanSharing.active
+}
+
+// updateLANSharing starts or stops LAN update sharing according to the
+// configuration.
+func updateLANSharing() {
+	enabled := enableLANSharing()
+	secret := lanSharingSecret()
+
+	lanSharing.Lock()
+	defer lanSharing.Unlock()
+
+	switch {
+	case enabled && secret == "":
+		stopLANSharing()
+		module.Warning(
+			lanSharingFailed,
+			"LAN Update Sharing Disabled",
+			"LAN update sharing is enabled, but no secret is configured. Configure the same secret on all devices that should share updates.",
+		)
+		return
+	case !enabled:
+		stopLANSharing()
+		module.Resolve(lanSharingFailed)
+		return
+	case lanSharing.active && string(lanSharing.secret) == secret:
+		return
+	}
+
+	stopLANSharing()
+	if err := startLANSharing([]byte(secret)); err != nil {
+		stopLANSharing()
+		log.Warningf("updates: failed to start LAN update sharing: %s", err)
+		module.Warning(
+			lanSharingFailed,
+			"LAN Update Sharing Failed",
+			fmt.Sprintf("Failed to start sharing updates with other devices in the LAN: %s", err),
+		)
+		return
+	}
+	module.Resolve(lanSharingFailed)
+}
+
+// startLANSharing starts the file server and the mDNS responder. The caller
+// must hold the lanSharing lock.
+func startLANSharing(secret []byte) error {
+	instanceID, err := rng.Bytes(8)
+	if err != nil {
+		return fmt.Errorf("failed to create instance ID: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(LANSharingPort)))
+	if err != nil {
+		return err
+	}
+	mDNSConn, err := net.ListenMulticastUDP("udp4", nil, mDNSAddr)
+	if err != nil {
+		_ = listener.Close()
+		return err
+	}
+
+	lanSharing.active = true
+	lanSharing.instance = hex.EncodeToString(instanceID)
+	lanSharing.secret = secret
+	lanSharing.peers = make(map[string]time.Time)
+	lanSharing.mDNSConn = mDNSConn
+	lanSharing.server = &http.Server{
+		Handler:      http.HandlerFunc(serveLANRequest),
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Minute,
+	}
+
+	server := lanSharing.server
+	module.StartWorker("lan update sharing server", func(_ context.Context) error {
+		err := server.Serve(listener)
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	})
+	instance := lanSharing.instance
+	module.StartWorker("lan update sharing mdns", func(_ context.Context) error {
+		handleLANMDNS(mDNSConn, instance)
+		return nil
+	})
+
+	log.Infof("updates: sharing updates with LAN peers on port %d", LANSharingPort)
+	return nil
+}
+
+// stopLANSharing stops the file server and the mDNS responder. The caller
+// must hold the lanSharing lock.
+func stopLANSharing() {
+	if lanSharing.server != nil {
+		_ = lanSharing.server.Close()
+		lanSharing.server = nil
+	}
+	if lanSharing.mDNSConn != nil {
+		_ = lanSharing.mDNSConn.Close()
+		lanSharing.mDNSConn = nil
+	}
+	if lanSharing.active {
+		log.Info("updates: stopped sharing updates with LAN peers")
+	}
+	lanSharing.active = false
+	lanSharing.secret = nil
+	lanSharing.peers = nil
+}
+
+func serveLANRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	versionedPath := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+	secret, token, err := checkLANAuth(r, versionedPath)
+	if err != nil {
+		log.Debugf("updates: denied LAN peer request from %s: %s", r.RemoteAddr, err)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Only share files that were verified.
+	verifiedVersionsLock.Lock()
+	_, verified := verifiedVersions[versionedPath]
+	verifiedVersionsLock.Unlock()
+	if !verified {
+		http.NotFound(w, r)
+		return
+	}
+
+	file, err := os.Open(filepath.Join(registry.StorageDir().Path, filepath.FromSlash(versionedPath)))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	// Authenticate the response with the hash of the file.
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(lanResponseAuthHeader, lanResponseToken(secret, token, hash.Sum(nil)))
+
+	log.Debugf("updates: serving %s to LAN peer %s", versionedPath, r.RemoteAddr)
+	http.ServeContent(w, r, "", info.ModTime(), file)
+}
+
+// lanAuthToken returns the authentication token for the given path and
+// time.
+func lanAuthToken(secret []byte, urlPath string, timestamp int64) string {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = fmt.Fprintf(mac, "%d %s", timestamp, strings.TrimPrefix(urlPath, "/"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// lanResponseToken returns the authentication token of the response to the
+// request with the given token, serving a file with the given hash.
+func lanResponseToken(secret []byte, requestToken string, fileHash []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = fmt.Fprintf(mac, "response %s %x", requestToken, fileHash)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkLANAuth checks the authorization of the request and returns the secret
+// and the request token for authenticating the response.
+func checkLANAuth(r *http.Request, versionedPath string) (secret []byte, token string, err error) {
+	lanSharing.Lock()
+	secret = lanSharing.secret
+	lanSharing.Unlock()
+	if secret == nil {
+		return nil, "", errors.New("sharing is disabled")
+	}
+
+	fields := strings.Fields(r.Header.Get("Authorization"))
+	if len(fields) != 2 || fields[0] != lanAuthScheme {
+		return nil, "", errors.New("missing authorization")
+	}
+	parts := strings.SplitN(fields[1], ":", 2)
+	if len(parts) != 2 {
+		return nil, "", errors.New("malformed authorization")
+	}
+	timestamp, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, "", errors.New("malformed authorization")
+	}
+
+	age := time.Since(time.Unix(timestamp, 0))
+	if age > lanAuthMaxAge || age < -lanAuthMaxAge {
+		return nil, "", errors.New("authorization expired")
+	}
+	if !hmac.Equal([]byte(parts[1]), []byte(lanAuthToken(secret, versionedPath, timestamp))) {
+		return nil, "", errors.New("invalid authorization")
+	}
+	return secret, parts[1], nil
+}
+
+// isLANPeerRequest returns whether the request is directed to a LAN peer.
+func isLANPeerRequest(req *http.Request) bool {
+	lanSharing.Lock()
+	defer lanSharing.Unlock()
+
+	if !lanSharing.active {
+		return false
+	}
+	_, ok := lanSharing.peers[req.URL.Scheme+"://"+req.URL.Host]
+	return ok
+}
+
+// authorizeLANRequest adds the authorization to the request, if it is
+// directed to a LAN peer. It returns the token that the response must be
+// authenticated with.
+func authorizeLANRequest(req *http.Request) (token string, toLANPeer bool) {
+	lanSharing.Lock()
+	defer lanSharing.Unlock()
+
+	if !lanSharing.active {
+		return "", false
+	}
+	if _, ok := lanSharing.peers[req.URL.Scheme+"://"+req.URL.Host]; !ok {
+		return "", false
+	}
+
+	timestamp := time.Now().Unix()
+	token = lanAuthToken(lanSharing.secret, req.URL.Path, timestamp)
+	req.Header.Set("Authorization", fmt.Sprintf("%s %d:%s", lanAuthScheme, timestamp, token))
+	return token, true
+}
+
+// checkLANResponse checks that the response to the request with the given
+// token was authenticated by a peer with the shared secret.
+func checkLANResponse(resp *http.Response, token string, fileHash []byte) error {
+	lanSharing.Lock()
+	secret := lanSharing.secret
+	lanSharing.Unlock()
+	if secret == nil {
+		return errors.New("sharing is disabled")
+	}
+
+	expected := lanResponseToken(secret, token, fileHash)
+	if !hmac.Equal([]byte(resp.Header.Get(lanResponseAuthHeader)), []byte(expected)) {
+		return errors.New("invalid response authentication")
+	}
+	return nil
+}
+
+// lanPeersAllowed returns whether files may be downloaded from LAN peers,
+// which requires that all downloaded files are verified with the signing key.
+func lanPeersAllowed() bool {
+	return !registry.DevMode && len(updateSigningKey) == ed25519.PublicKeySize
+}
+
+// handleLANMDNS answers queries for the update sharing service and records
+// the answers of peers.
+func handleLANMDNS(conn *net.UDPConn, instance string) {
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// The connection was closed.
+			return
+		}
+
+		msg := new(dns.Msg)
+		if err := msg.Unpack(buf[:n]); err != nil {
+			continue
+		}
+		if msg.Response {
+			recordLANPeers(msg, src, instance)
+			continue
+		}
+		for _, q := range msg.Question {
+			if q.Qtype == dns.TypePTR && strings.EqualFold(q.Name, lanServiceName) {
+				answerLANQuery(conn, instance)
+				break
+			}
+		}
+	}
+}
+
+func answerLANQuery(conn *net.UDPConn, instance string) {
+	instanceName := instance + "." + lanServiceName
+	msg := new(dns.Msg)
+	msg.Response = true
+	msg.Authoritative = true
+	msg.Answer = []dns.RR{
+		&dns.PTR{
+			Hdr: dns.RR_Header{Name: lanServiceName, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: lanPeerTTL},
+			Ptr: instanceName,
+		},
+		&dns.SRV{
+			Hdr:    dns.RR_Header{Name: instanceName, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: lanPeerTTL},
+			Port:   LANSharingPort,
+			Target: instance + ".local.",
+		},
+	}
+
+	data, err := msg.Pack()
+	if err != nil {
+		log.Warningf("updates: failed to pack mdns answer: %s", err)
+		return
+	}
+	if _, err := conn.WriteToUDP(data, mDNSAddr); err != nil {
+		log.Debugf("updates: failed to send mdns answer: %s", err)
+	}
+}
+
+func recordLANPeers(msg *dns.Msg, src *net.UDPAddr, instance string) {
+	// Only accept peers from the LAN.
+	if !netutils.GetIPScope(src.IP).IsLAN() {
+		return
+	}
+
+	for _, rr := range msg.Answer {
+		srv, ok := rr.(*dns.SRV)
+		if !ok || !strings.HasSuffix(strings.ToLower(srv.Hdr.Name), "."+lanServiceName) {
+			continue
+		}
+		if strings.HasPrefix(srv.Hdr.Name, instance+".") {
+			// Ignore own answers.
+			continue
+		}
+
+		baseURL := fmt.Sprintf("http://%s", net.JoinHostPort(src.IP.String(), strconv.Itoa(int(srv.Port))))
+		lanSharing.Lock()
+		if lanSharing.peers != nil {
+			if _, known := lanSharing.peers[baseURL]; !known {
+				log.Debugf("updates: discovered LAN peer %s", baseURL)
+			}
+			lanSharing.peers[baseURL] = time.Now()
+		}
+		lanSharing.Unlock()
+	}
+}
+
+// discoverLANPeers queries the LAN for peers and returns the base URLs of the
+// most recently seen peers.
+func discoverLANPeers(ctx context.Context) []string {
+	if !lanPeersAllowed() {
+		return nil
+	}
+
+	lanSharing.Lock()
+	conn := lanSharing.mDNSConn
+	lanSharing.Unlock()
+	if conn == nil {
+		return nil
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(lanServiceName, dns.TypePTR)
+	msg.Id = 0
+	data, err := msg.Pack()
+	if err != nil {
+		log.Warningf("updates: failed to pack mdns query: %s", err)
+		return nil
+	}
+	if _, err := conn.WriteToUDP(data, mDNSAddr); err != nil {
+		log.Debugf("updates: failed to send mdns query: %s", err)
+		return nil
+	}
+
+	select {
+	case <-time.After(lanPeerDiscoveryTime):
+	case <-ctx.Done():
+		return nil
+	}
+
+	lanSharing.Lock()
+	defer lanSharing.Unlock()
+
+	type seenPeer struct {
+		url  string
+		seen time.Time
+	}
+	var recent []seenPeer
+	for url, seen := range lanSharing.peers {
+		if time.Since(seen) > lanPeerMaxAge {
+			delete(lanSharing.peers, url)
+			continue
+		}
+		recent = append(recent, seenPeer{url: url, seen: seen})
+	}
+	// Prefer the peers that answered last.
+	sort.Slice(recent, func(i, j int) bool {
+		return recent[i].seen.After(recent[j].seen)
+	})
+
+	var peers []string
+	for i := 0; i < len(recent) && i < maxLANPeers; i++ {
+		peers = append(peers, recent[i].url)
+	}
+	return peers
+}
+
+// downloadUpdates downloads the updates, preferring LAN peers over the update
+// servers.
+func downloadUpdates(ctx context.Context) error {
+	if peers := discoverLANPeers(ctx); len(peers) > 0 {
+		log.Infof("updates: downloading updates from LAN peers %s, falling back to update servers", strings.Join(peers, ", "))
+		concurrency := int(downloadConcurrency())
+		if concurrency < 1 {
+			concurrency = 1
+		}
+		fetchInParallel(ctx, pendingUpdates(), peers, concurrency, int(downloadsPerHost()))
+	}
+
+	return fetchUpdates(ctx)
+}
@@ -0,0 +1,107 @@
+package updates
+
+import (
+	"context"
+	"crypto/ed25519"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/safing/portbase/updater"
+	"github.com/safing/portbase/utils"
+)
+
+func TestLANPeerResponseAuthentication(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "portmaster-lanpeers-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	previousRegistry, previousVerified := registry, verifiedVersions
+	defer func() {
+		registry, verifiedVersions = previousRegistry, previousVerified
+		lanSharing.Lock()
+		lanSharing.active, lanSharing.secret, lanSharing.peers = false, nil, nil
+		lanSharing.Unlock()
+	}()
+
+	registry = &updater.ResourceRegistry{Name: "test"}
+	if err := registry.Initialize(utils.NewDirStructure(filepath.Join(tmpDir, "updates"), 0755)); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(registry.StorageDir().Path, "all", "file_v1-0-0"), "file")
+	verifiedVersions = map[string]*verifiedFile{"all/file_v1-0-0": {}}
+
+	peer := httptest.NewServer(http.HandlerFunc(serveLANRequest))
+	defer peer.Close()
+	// The rogue peer serves files without knowing the secret.
+	rogue := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("evil"))
+	}))
+	defer rogue.Close()
+
+	lanSharing.Lock()
+	lanSharing.active = true
+	lanSharing.secret = []byte("shared secret")
+	lanSharing.peers = map[string]time.Time{
+		peer.URL:  time.Now(),
+		rogue.URL: time.Now(),
+	}
+	lanSharing.Unlock()
+
+	hosts := &hostSlots{perHost: 1, slots: make(map[string]chan struct{})}
+	client := &http.Client{}
+	if err := fetchUpdateFrom(context.Background(), client, hosts, peer.URL, "all/file_v1-0-0"); err != nil {
+		t.Errorf("authenticated response was rejected: %s", err)
+	}
+	if err := fetchUpdateFrom(context.Background(), client, hosts, rogue.URL, "all/file_v1-0-0"); err == nil {
+		t.Error("unauthenticated response was accepted")
+	}
+	data, err := ioutil.ReadFile(filepath.Join(registry.StorageDir().Path, "all", "file_v1-0-0"))
+	if err != nil || string(data) != "file" {
+		t.Errorf("file was replaced by unauthenticated response: %q, %v", data, err)
+	}
+
+	// Requests without authorization are denied.
+	resp, err := http.Get(peer.URL + "/all/file_v1-0-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("unauthorized request was answered with %s", resp.Status)
+	}
+}
+
+func TestLANPeersRequireSignatures(t *testing.T) {
+	previousRegistry, previousKey := registry, updateSigningKey
+	defer func() {
+		registry, updateSigningKey = previousRegistry, previousKey
+	}()
+
+	pubKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updateSigningKey = pubKey
+	registry = &updater.ResourceRegistry{Name: "test"}
+	if !lanPeersAllowed() {
+		t.Error("LAN peers were not allowed with a signing key")
+	}
+
+	registry.DevMode = true
+	if lanPeersAllowed() {
+		t.Error("LAN peers were allowed in dev mode, where signatures are not verified")
+	}
+
+	registry.DevMode = false
+	updateSigningKey = nil
+	if lanPeersAllowed() {
+		t.Error("LAN peers were allowed without a signing key")
+	}
+}
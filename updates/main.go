@@ -64,6 +64,9 @@ func init() {
 	module = modules.Register(ModuleName, prep, start, stop, "base")
 	module.RegisterEvent(VersionUpdateEvent, true)
 	module.RegisterEvent(ResourceUpdateEvent, true)
+	module.RegisterEvent(DownloadProgressEvent, true)
+	module.RegisterEvent(AvailableUpdatesEvent, true)
+	module.RegisterEvent(RestartPendingEvent, true)
 
 	flag.StringVar(&userAgentFromFlag, "update-agent", "", "set the user agent for requests to the update server")
 
@@ -83,6 +86,8 @@ func start() error {
 	initConfig()
 
 	restartTask = module.NewTask("automatic restart", automaticRestart).MaxDelay(10 * time.Minute)
+	restartPolicyTask = module.NewTask("automatic restart after update", restartWithPolicy).
+		Repeat(restartPolicyCheckInterval)
 
 	if err := module.RegisterEventHook(
 		"config",
@@ -96,7 +101,7 @@ func start() error {
 	registry = &updater.ResourceRegistry{
 		Name: ModuleName,
 		UpdateURLs: []string{
-			"https://updates.safing.io",
+			defaultUpdateURL,
 		},
 		UserAgent:        UserAgent,
 		MandatoryUpdates: helper.MandatoryUpdates(),
@@ -114,20 +119,39 @@ func start() error {
 		return err
 	}
 
+	err = initSignatures()
+	if err != nil {
+		return err
+	}
+
+	err = initPartials()
+	if err != nil {
+		return err
+	}
+
 	// Set indexes based on the release channel.
-	helper.SetIndexes(registry, initialReleaseChannel)
+	setIndexes(initialReleaseChannel)
+
+	// Add configured mirrors.
+	updateMirrors()
 
 	err = registry.LoadIndexes(module.Ctx)
 	if err != nil {
 		log.Warningf("updates: failed to load indexes: %s", err)
 	}
+	applyChannelOverrides()
 
 	err = registry.ScanStorage("")
 	if err != nil {
 		log.Warningf("updates: error during storage scan: %s", err)
 	}
 
-	registry.SelectVersions()
+	detectPackageManager()
+	loadPinnedVersions()
+	// Reject files that are not verified or were changed since, before
+	// selecting versions.
+	verifyLocalUpdateSignatures(module.Ctx)
+	selectVersions()
 	module.TriggerEvent(VersionUpdateEvent, nil)
 
 	if !updatesCurrentlyEnabled {
@@ -140,11 +164,48 @@ func start() error {
 		return err
 	}
 
+	// Expose the release notes of the selected release channel.
+	err = registerReleaseNotesProvider()
+	if err != nil {
+		return err
+	}
+
+	// Expose update statistics.
+	err = initUpdateStats()
+	if err != nil {
+		return err
+	}
+
 	// start updater task
 	updateTask = module.NewTask("updater", func(ctx context.Context, task *modules.Task) error {
 		return checkForUpdates(ctx)
 	})
 
+	// Check for updates when the update time window starts.
+	windowTask = module.NewTask("update time window", func(_ context.Context, _ *modules.Task) error {
+		if err := TriggerUpdate(); err != nil {
+			log.Debugf("updates: not checking for updates at start of time window: %s", err)
+		}
+		return nil
+	})
+	installDownloadThrottle()
+
+	// Regularly verify the files in the update storage.
+	initIntegrityCheck()
+
+	// Share updates with LAN peers, if enabled.
+	if err := module.RegisterEventHook(
+		"config",
+		"config change",
+		"update lan update sharing",
+		func(_ context.Context, _ interface{}) error {
+			updateLANSharing()
+			return nil
+		}); err != nil {
+		return err
+	}
+	updateLANSharing()
+
 	if !disableTaskSchedule {
 		updateTask.
 			Repeat(1 * time.Hour).
@@ -204,11 +265,21 @@ func checkForUpdates(ctx context.Context) (err error) {
 		log.Debugf("updates: automatic updates are disabled")
 		return nil
 	}
-	forceUpdate.UnSet()
+	forced := forceUpdate.SetToIf(true, false)
+
+	// Manual checks are always possible, automatic ones only within the
+	// configured time window.
+	if ok, nextStart := inDownloadWindow(time.Now()); !ok && !forced {
+		scheduleForDownloadWindow(nextStart)
+		return nil
+	}
+	ctx = withUpdateRequest(ctx)
 
 	defer log.Debugf("updates: finished checking for updates")
 
 	defer func() {
+		recordCheckResult(err)
+
 		if err == nil {
 			module.Resolve(updateFailed)
 			notifications.Notify(&notifications.Notification{
@@ -242,18 +313,37 @@ func checkForUpdates(ctx context.Context) (err error) {
 		}
 	}()
 
+	// Apply mirror configuration and health.
+	updateMirrors()
+
 	if err = registry.UpdateIndexes(ctx); err != nil {
 		err = fmt.Errorf("failed to update indexes: %s", err)
 		return
 	}
+	applyChannelOverrides()
+
+	// Fetch the release notes of the selected release channel.
+	updateReleaseNotes(ctx)
+
+	if ManagedExternally() {
+		helper.SkipExternallyManagedUpdates(registry)
+	}
+
+	// Check if there is enough disk space for downloading.
+	if err = checkDiskSpace(); err != nil {
+		return
+	}
 
-	err = registry.DownloadUpdates(ctx)
+	err = downloadUpdates(ctx)
 	if err != nil {
 		err = fmt.Errorf("failed to download updates: %w", err)
 		return
 	}
 
-	registry.SelectVersions()
+	// Reject files without a valid signature before selecting versions.
+	verifyUpdateSignatures(ctx)
+
+	selectVersions()
 
 	// Unpack selected resources.
 	err = registry.UnpackResources()
@@ -263,13 +353,17 @@ func checkForUpdates(ctx context.Context) (err error) {
 	}
 
 	// Purge old resources
-	registry.Purge(3)
+	purgeUpdates()
 
 	module.TriggerEvent(ResourceUpdateEvent, nil)
 	return nil
 }
 
 func stop() error {
+	lanSharing.Lock()
+	stopLANSharing()
+	lanSharing.Unlock()
+
 	if registry != nil {
 		return registry.Cleanup()
 	}
@@ -0,0 +1,255 @@
+package updates
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/updater"
+)
+
+// Update mirrors are tried in the order of their priority, with the lowest
+// number first. Mirrors that failed repeatedly are tried after the healthy
+// ones until they recover. All files are verified with their signature, so
+// mirrors do not need to be trusted.
+
+const (
+	defaultUpdateURL = "https://updates.safing.io"
+
+	// defaultUpdateURLPriority is the priority of the official update server.
+	defaultUpdateURLPriority = 500
+	// defaultMirrorPriority is the priority of mirrors without a configured
+	// priority, so that they are preferred over the official update server.
+	defaultMirrorPriority = 100
+
+	// mirrorValidationRegex matches a mirror URL with an optional priority.
+	mirrorValidationRegex = `^https?://[^ ]+( [0-9]{1,3})?$`
+
+	// mirrorFailureThreshold is the amount of consecutive failures after
+	// which a mirror is considered unhealthy.
+	mirrorFailureThreshold = 3
+	// mirrorRecoveryTime is the time after which an unhealthy mirror is
+	// tried first again.
+	mirrorRecoveryTime = 10 * time.Minute
+)
+
+var (
+	mirrors     = make(map[string]*MirrorStatus)
+	mirrorsLock sync.Mutex
+
+	// servedBy holds which mirror served the latest download of resources.
+	servedBy = make(map[string]*ServedResource)
+)
+
+// MirrorStatus describes an update mirror and its health.
+type MirrorStatus struct {
+	URL      string
+	Priority int
+	// Official is set for the official update server.
+	Official bool
+	// Healthy is false if the mirror failed repeatedly.
+	Healthy             bool
+	ConsecutiveFailures int
+	LastSuccess         int64
+	LastFailure         int64
+	LastError           string `json:",omitempty"`
+}
+
+// ServedResource describes from which mirror a resource was downloaded.
+type ServedResource struct {
+	Identifier string
+	Version    string
+	Mirror     string
+	Downloaded int64
+}
+
+// MirrorsInfo holds the status of all mirrors and which mirror served each
+// resource.
+type MirrorsInfo struct {
+	Mirrors   []*MirrorStatus
+	Resources []*ServedResource
+}
+
+// parseMirror parses a configured mirror entry.
+func parseMirror(entry string) (url string, priority int) {
+	fields := strings.Fields(entry)
+	if len(fields) == 0 {
+		return "", 0
+	}
+	url = strings.TrimSuffix(fields[0], "/")
+	priority = defaultMirrorPriority
+	if len(fields) > 1 {
+		if p, err := strconv.Atoi(fields[1]); err == nil {
+			priority = p
+		}
+	}
+	return url, priority
+}
+
+// updateMirrors applies the configured mirrors and orders the update URLs of
+// the registry by health and priority.
+func updateMirrors() {
+	mirrorsLock.Lock()
+	defer mirrorsLock.Unlock()
+
+	configured := map[string]int{
+		defaultUpdateURL: defaultUpdateURLPriority,
+	}
+	for _, entry := range updateMirrorURLs() {
+		if url, priority := parseMirror(entry); url != "" {
+			configured[url] = priority
+		}
+	}
+
+	// Keep the health of mirrors that are still configured.
+	for url := range mirrors {
+		if _, ok := configured[url]; !ok {
+			delete(mirrors, url)
+		}
+	}
+	for url, priority := range configured {
+		status, ok := mirrors[url]
+		if !ok {
+			status = &MirrorStatus{
+				URL:      url,
+				Official: url == defaultUpdateURL,
+				Healthy:  true,
+			}
+			mirrors[url] = status
+		}
+		status.Priority = priority
+	}
+
+	registry.UpdateURLs = orderedMirrorURLs()
+}
+
+// updateURLs returns a copy of the update URLs of the registry.
+func updateURLs() []string {
+	mirrorsLock.Lock()
+	defer mirrorsLock.Unlock()
+
+	return append([]string(nil), registry.UpdateURLs...)
+}
+
+// orderedMirrorURLs returns the mirror URLs, with healthy mirrors first and
+// then by priority. The caller must hold mirrorsLock.
+func orderedMirrorURLs() []string {
+	now := time.Now().Unix()
+	sorted := make([]*MirrorStatus, 0, len(mirrors))
+	for _, status := range mirrors {
+		// Try unhealthy mirrors again after some time.
+		if !status.Healthy && now-status.LastFailure > int64(mirrorRecoveryTime/time.Second) {
+			status.Healthy = true
+			status.ConsecutiveFailures = 0
+		}
+		sorted = append(sorted, status)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		switch {
+		case a.Healthy != b.Healthy:
+			return a.Healthy
+		case a.Priority != b.Priority:
+			return a.Priority < b.Priority
+		default:
+			return a.URL < b.URL
+		}
+	})
+
+	urls := make([]string, 0, len(sorted))
+	for _, status := range sorted {
+		urls = append(urls, status.URL)
+	}
+	return urls
+}
+
+// recordMirrorResult records the result of a request to a mirror. Requests to
+// other hosts, such as LAN peers, are ignored.
+func recordMirrorResult(req *http.Request, resp *http.Response, err error) {
+	baseURL, versionedPath, ok := splitMirrorURL(req)
+	if !ok {
+		return
+	}
+
+	mirrorsLock.Lock()
+	defer mirrorsLock.Unlock()
+
+	status, ok := mirrors[baseURL]
+	if !ok {
+		return
+	}
+
+	now := time.Now().Unix()
+	if err == nil && (resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent) {
+		status.ConsecutiveFailures = 0
+		status.Healthy = true
+		status.LastSuccess = now
+
+		identifier, version, ok := updater.GetIdentifierAndVersion(versionedPath)
+		if ok && !strings.HasSuffix(versionedPath, signatureSuffix) {
+			servedBy[identifier] = &ServedResource{
+				Identifier: identifier,
+				Version:    version,
+				Mirror:     baseURL,
+				Downloaded: now,
+			}
+		}
+		return
+	}
+
+	status.ConsecutiveFailures++
+	status.LastFailure = now
+	if err != nil {
+		status.LastError = err.Error()
+	} else {
+		status.LastError = resp.Status
+	}
+	if status.Healthy && status.ConsecutiveFailures >= mirrorFailureThreshold {
+		status.Healthy = false
+		log.Warningf("updates: mirror %s failed %d times in a row, preferring other mirrors", baseURL, status.ConsecutiveFailures)
+	}
+}
+
+// splitMirrorURL splits the request URL into the base URL of a known mirror
+// and the requested path. The caller must not hold mirrorsLock.
+func splitMirrorURL(req *http.Request) (baseURL, path string, ok bool) {
+	url := req.URL.String()
+
+	mirrorsLock.Lock()
+	defer mirrorsLock.Unlock()
+
+	for mirrorURL := range mirrors {
+		if strings.HasPrefix(url, mirrorURL+"/") {
+			return mirrorURL, strings.TrimPrefix(url, mirrorURL+"/"), true
+		}
+	}
+	return "", "", false
+}
+
+// GetMirrorsInfo returns the status of all update mirrors and which mirror
+// served each resource.
+func GetMirrorsInfo() *MirrorsInfo {
+	mirrorsLock.Lock()
+	defer mirrorsLock.Unlock()
+
+	info := &MirrorsInfo{
+		Mirrors:   make([]*MirrorStatus, 0, len(mirrors)),
+		Resources: make([]*ServedResource, 0, len(servedBy)),
+	}
+	for _, url := range orderedMirrorURLs() {
+		status := *mirrors[url]
+		info.Mirrors = append(info.Mirrors, &status)
+	}
+	for _, served := range servedBy {
+		copied := *served
+		info.Resources = append(info.Resources, &copied)
+	}
+	sort.Slice(info.Resources, func(i, j int) bool {
+		return info.Resources[i].Identifier < info.Resources[j].Identifier
+	})
+	return info
+}
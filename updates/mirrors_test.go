@@ -0,0 +1,112 @@
+package updates
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/safing/portbase/updater"
+)
+
+func TestParseMirror(t *testing.T) {
+	for _, test := range []struct {
+		entry    string
+		url      string
+		priority int
+	}{
+		{"https://mirror.example.com", "https://mirror.example.com", defaultMirrorPriority},
+		{"https://mirror.example.com/ 10", "https://mirror.example.com", 10},
+		{"https://mirror.example.com x", "https://mirror.example.com", defaultMirrorPriority},
+		{"", "", 0},
+	} {
+		url, priority := parseMirror(test.entry)
+		if url != test.url || priority != test.priority {
+			t.Errorf("%q: expected %s with priority %d, got %s with priority %d", test.entry, test.url, test.priority, url, priority)
+		}
+	}
+}
+
+func TestMirrorOrdering(t *testing.T) {
+	previousRegistry, previousMirrorURLs, previousMirrors := registry, updateMirrorURLs, mirrors
+	defer func() {
+		registry, updateMirrorURLs, mirrors = previousRegistry, previousMirrorURLs, previousMirrors
+	}()
+	registry = &updater.ResourceRegistry{}
+	mirrors = make(map[string]*MirrorStatus)
+	updateMirrorURLs = func() []string {
+		return []string{
+			"https://b.example.com",
+			"https://a.example.com",
+			"https://low.example.com 900",
+			"https://high.example.com/ 1",
+		}
+	}
+
+	// Mirrors are ordered by priority, then by URL.
+	updateMirrors()
+	expected := []string{
+		"https://high.example.com",
+		"https://a.example.com",
+		"https://b.example.com",
+		defaultUpdateURL,
+		"https://low.example.com",
+	}
+	if !reflect.DeepEqual(registry.UpdateURLs, expected) {
+		t.Fatalf("unexpected order: %v", registry.UpdateURLs)
+	}
+
+	// Mirrors that failed repeatedly are tried last.
+	req := newMirrorRequest(t, "https://high.example.com/all/file_v1-0-0")
+	for i := 0; i < mirrorFailureThreshold; i++ {
+		recordMirrorResult(req, nil, errors.New("connection refused"))
+	}
+	updateMirrors()
+	expected = []string{
+		"https://a.example.com",
+		"https://b.example.com",
+		defaultUpdateURL,
+		"https://low.example.com",
+		"https://high.example.com",
+	}
+	if !reflect.DeepEqual(registry.UpdateURLs, expected) {
+		t.Fatalf("unexpected order after failures: %v", registry.UpdateURLs)
+	}
+	if status := mirrors["https://high.example.com"]; status.Healthy || status.LastError != "connection refused" {
+		t.Errorf("unexpected status of failed mirror: %+v", status)
+	}
+
+	// Unhealthy mirrors are tried first again after the recovery time.
+	mirrors["https://high.example.com"].LastFailure = time.Now().Add(-mirrorRecoveryTime - time.Minute).Unix()
+	updateMirrors()
+	if registry.UpdateURLs[0] != "https://high.example.com" {
+		t.Errorf("mirror did not recover: %v", registry.UpdateURLs)
+	}
+
+	// Successful downloads are recorded, signatures and other hosts are not.
+	recordMirrorResult(newMirrorRequest(t, "https://a.example.com/all/file_v1-2-3"), &http.Response{StatusCode: http.StatusOK}, nil)
+	recordMirrorResult(newMirrorRequest(t, "https://b.example.com/all/file_v1-2-4.sig"), &http.Response{StatusCode: http.StatusOK}, nil)
+	recordMirrorResult(newMirrorRequest(t, "http://192.168.1.2:8719/all/file_v1-2-5"), &http.Response{StatusCode: http.StatusOK}, nil)
+	info := GetMirrorsInfo()
+	if len(info.Resources) != 1 || info.Resources[0].Mirror != "https://a.example.com" || info.Resources[0].Version != "1.2.3" {
+		t.Errorf("unexpected served resources: %+v", info.Resources)
+	}
+
+	// Removed mirrors are dropped.
+	updateMirrorURLs = func() []string { return nil }
+	updateMirrors()
+	if !reflect.DeepEqual(registry.UpdateURLs, []string{defaultUpdateURL}) {
+		t.Errorf("unexpected mirrors after removal: %v", registry.UpdateURLs)
+	}
+}
+
+func newMirrorRequest(t *testing.T, url string) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
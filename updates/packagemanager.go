@@ -0,0 +1,62 @@
+package updates
+
+import (
+	"github.com/safing/portbase/log"
+	"github.com/safing/portmaster/updates/helper"
+)
+
+// If the Portmaster was installed with a system package manager, binaries are
+// updated by the package manager and only intelligence data is updated by the
+// Portmaster itself. Packagers may also enable this mode by configuration or
+// with the managedbinaries build tag.
+
+var (
+	packageManager string
+
+	// previousManagedExternally holds whether the mandatory updates were last
+	// applied with externally managed binaries.
+	previousManagedExternally bool
+)
+
+// PackageManager returns the system package manager that manages the
+// Portmaster binaries, or an empty string if the Portmaster updates itself.
+func PackageManager() string {
+	switch {
+	case packageManager != "":
+		return packageManager
+	case binariesManagedExternally():
+		return helper.PackageManagerExternal
+	default:
+		return ""
+	}
+}
+
+// ManagedExternally returns whether the Portmaster binaries are updated by a
+// system package manager.
+func ManagedExternally() bool {
+	return PackageManager() != ""
+}
+
+func detectPackageManager() {
+	packageManager = helper.DetectPackageManager()
+	if packageManager != "" {
+		log.Infof("updates: installed with %s, binaries are updated by the package manager", packageManager)
+	}
+	applyPackageManagerMode()
+}
+
+// applyPackageManagerMode removes the externally managed binaries from the
+// mandatory updates, so that they are never downloaded. It returns whether
+// the mode changed.
+func applyPackageManagerMode() (changed bool) {
+	managed := ManagedExternally()
+	changed = managed != previousManagedExternally
+	previousManagedExternally = managed
+
+	if managed {
+		registry.MandatoryUpdates = helper.WithoutExternallyManaged(helper.MandatoryUpdates())
+	} else {
+		registry.MandatoryUpdates = helper.MandatoryUpdates()
+	}
+	return changed
+}
@@ -0,0 +1,202 @@
+package updates
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/updater"
+	"github.com/safing/portbase/utils/renameio"
+)
+
+// The registry downloads updates one after another. In order to speed up
+// downloads on fast links, the pending updates are fetched in parallel into
+// the update storage first. The registry then only downloads what failed,
+// which includes its usual retries.
+
+// fetchUpdates downloads all pending updates.
+func fetchUpdates(ctx context.Context) error {
+	if concurrency := downloadConcurrency(); concurrency > 1 {
+		fetchInParallel(ctx, pendingUpdates(), updateURLs(), int(concurrency), int(downloadsPerHost()))
+	}
+	return registry.DownloadUpdates(ctx)
+}
+
+// fetchInParallel downloads the given updates from the given update URLs with
+// the given amount of workers, using at most perHost connections per update
+// server.
+func fetchInParallel(ctx context.Context, updates []*AvailableUpdate, fromURLs []string, concurrency, perHost int) {
+	if len(updates) == 0 {
+		return
+	}
+	if err := registry.TmpDir().Ensure(); err != nil {
+		log.Warningf("updates: failed to prepare tmp directory for parallel downloads: %s", err)
+		return
+	}
+	if concurrency > len(updates) {
+		concurrency = len(updates)
+	}
+	log.Infof("updates: downloading %d updates with %d parallel downloads", len(updates), concurrency)
+
+	hosts := &hostSlots{
+		perHost: perHost,
+		slots:   make(map[string]chan struct{}),
+	}
+	queue := make(chan *AvailableUpdate)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client := &http.Client{}
+			for update := range queue {
+				fetchUpdate(ctx, client, hosts, fromURLs, update)
+			}
+		}()
+	}
+
+feed:
+	for _, update := range updates {
+		select {
+		case queue <- update:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// fetchUpdate downloads the update from the first of the given update URLs
+// that delivers it and adds it to the registry.
+func fetchUpdate(ctx context.Context, client *http.Client, hosts *hostSlots, fromURLs []string, update *AvailableUpdate) {
+	versionedPath := updater.GetVersionedPath(update.Identifier, update.NewVersion)
+
+	var err error
+	for _, updateURL := range fromURLs {
+		err = fetchUpdateFrom(ctx, client, hosts, updateURL, versionedPath)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+	if err != nil {
+		log.Debugf("updates: failed to download %s in parallel, leaving it to the registry: %s", versionedPath, err)
+		return
+	}
+
+	if err := registry.AddResource(update.Identifier, update.NewVersion, true, false, false); err != nil {
+		log.Warningf("updates: failed to add downloaded %s: %s", versionedPath, err)
+	}
+}
+
+func fetchUpdateFrom(ctx context.Context, client *http.Client, hosts *hostSlots, updateURL, versionedPath string) error {
+	u, err := url.Parse(updateURL)
+	if err != nil {
+		return fmt.Errorf("failed to parse update URL %q: %w", updateURL, err)
+	}
+	u.Path = path.Join(u.Path, versionedPath)
+	downloadURL := u.String()
+
+	if !hosts.acquire(ctx, u.Host) {
+		return ctx.Err()
+	}
+	defer hosts.release(u.Host)
+
+	storagePath := filepath.Join(registry.StorageDir().Path, filepath.FromSlash(versionedPath))
+	if err := registry.StorageDir().EnsureAbsPath(filepath.Dir(storagePath)); err != nil {
+		return fmt.Errorf("failed to create updates folder: %w", err)
+	}
+	atomicFile, err := renameio.TempFile(registry.TmpDir().Path, storagePath)
+	if err != nil {
+		return fmt.Errorf("failed to create temp file for download: %w", err)
+	}
+	defer atomicFile.Cleanup() //nolint:errcheck // The tmp dir is cleaned later anyway.
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadURL, nil)
+	if err != nil {
+		return err
+	}
+	if registry.UserAgent != "" {
+		req.Header.Set("User-Agent", registry.UserAgent)
+	}
+	lanToken, toLANPeer := authorizeLANRequest(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch %q: %s", downloadURL, resp.Status)
+	}
+
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(atomicFile, hash), resp.Body)
+	switch {
+	case err != nil:
+		return fmt.Errorf("failed to download %q: %w", downloadURL, err)
+	case resp.ContentLength != n:
+		return fmt.Errorf("failed to finish download of %q: written %d out of %d bytes", downloadURL, n, resp.ContentLength)
+	}
+	if toLANPeer {
+		if err := checkLANResponse(resp, lanToken, hash.Sum(nil)); err != nil {
+			return fmt.Errorf("failed to authenticate LAN peer response for %q: %w", downloadURL, err)
+		}
+	}
+	if err := atomicFile.CloseAtomicallyReplace(); err != nil {
+		return fmt.Errorf("failed to finalize file %s: %w", storagePath, err)
+	}
+	if runtime.GOOS != "windows" {
+		if err := os.Chmod(storagePath, 0755); err != nil { //nolint:gosec // Same as the registry.
+			log.Warningf("updates: failed to set permissions on downloaded file %s: %s", storagePath, err)
+		}
+	}
+
+	log.Infof("updates: fetched %s (stored to %s)", downloadURL, storagePath)
+	return nil
+}
+
+// hostSlots limits the amount of parallel connections per host.
+type hostSlots struct {
+	sync.Mutex
+	perHost int
+	slots   map[string]chan struct{}
+}
+
+func (hs *hostSlots) get(host string) chan struct{} {
+	hs.Lock()
+	defer hs.Unlock()
+
+	slots, ok := hs.slots[host]
+	if !ok {
+		slots = make(chan struct{}, hs.perHost)
+		hs.slots[host] = slots
+	}
+	return slots
+}
+
+func (hs *hostSlots) acquire(ctx context.Context, host string) bool {
+	select {
+	case hs.get(host) <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (hs *hostSlots) release(host string) {
+	<-hs.get(host)
+}
@@ -0,0 +1,95 @@
+package updates
+
+import (
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/updater"
+)
+
+// DownloadProgressEvent is emitted regularly while an update file is being
+// downloaded. The event data is a *DownloadProgress.
+const DownloadProgressEvent = "download progress"
+
+// progressInterval defines how often progress is reported per download.
+const progressInterval = 500 * time.Millisecond
+
+// DownloadProgress describes the progress of downloading an update file.
+type DownloadProgress struct {
+	Identifier string
+	Version    string
+	// BytesDone holds the amount of bytes downloaded so far.
+	BytesDone int64
+	// BytesTotal holds the size of the file. It is -1 if unknown.
+	BytesTotal int64
+	// ETA holds the estimated remaining seconds. It is -1 if unknown.
+	ETA int64
+	// Done is set when the download is finished.
+	Done bool
+}
+
+// withProgress reports the download progress of the response, if it is an
+// update file.
+func withProgress(req *http.Request, resp *http.Response) {
+	versionedPath := strings.TrimPrefix(path.Clean(req.URL.Path), "/")
+	if resp.StatusCode != http.StatusOK || strings.HasSuffix(versionedPath, signatureSuffix) {
+		return
+	}
+	identifier, version, ok := updater.GetIdentifierAndVersion(versionedPath)
+	if !ok {
+		return
+	}
+
+	resp.Body = &progressReader{
+		parent:  resp.Body,
+		started: time.Now(),
+		progress: DownloadProgress{
+			Identifier: identifier,
+			Version:    version,
+			BytesTotal: resp.ContentLength,
+			ETA:        -1,
+		},
+	}
+}
+
+type progressReader struct {
+	parent io.ReadCloser
+
+	lock       sync.Mutex
+	started    time.Time
+	lastReport time.Time
+	progress   DownloadProgress
+}
+
+func (pr *progressReader) Read(p []byte) (n int, err error) {
+	n, err = pr.parent.Read(p)
+
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+
+	pr.progress.BytesDone += int64(n)
+	switch {
+	case err == io.EOF:
+		pr.progress.Done = true
+		pr.progress.ETA = 0
+	case time.Since(pr.lastReport) < progressInterval:
+		return n, err
+	case pr.progress.BytesTotal > 0 && pr.progress.BytesDone > 0:
+		elapsed := time.Since(pr.started)
+		remaining := pr.progress.BytesTotal - pr.progress.BytesDone
+		pr.progress.ETA = int64(elapsed.Seconds() * float64(remaining) / float64(pr.progress.BytesDone))
+	}
+
+	pr.lastReport = time.Now()
+	progress := pr.progress
+	module.TriggerEvent(DownloadProgressEvent, &progress)
+	return n, err
+}
+
+func (pr *progressReader) Close() error {
+	return pr.parent.Close()
+}
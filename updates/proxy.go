@@ -0,0 +1,59 @@
+package updates
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/safing/portbase/log"
+)
+
+// updateProxyValidationRegex matches HTTP, HTTPS and SOCKS5 proxy URLs with
+// optional authentication, or an empty string.
+const updateProxyValidationRegex = `^((https?|socks5)://([^:@/]+(:[^@/]*)?@)?[^@/:]+(:[0-9]{1,5})?/?)?$`
+
+var (
+	proxyTransport     *http.Transport
+	proxyTransportURL  string
+	proxyTransportLock sync.Mutex
+)
+
+// getProxyTransport returns the transport that sends requests through the
+// configured proxy, or nil if no proxy is configured. If no proxy is
+// configured, the proxy environment variables are honored by the default
+// transport.
+func getProxyTransport(parent http.RoundTripper) http.RoundTripper {
+	proxyURL := updateProxy()
+	if proxyURL == "" {
+		return nil
+	}
+
+	proxyTransportLock.Lock()
+	defer proxyTransportLock.Unlock()
+
+	// Reuse transport, so that connections are kept alive.
+	if proxyTransport != nil && proxyTransportURL == proxyURL {
+		return proxyTransport
+	}
+
+	parsed, err := url.Parse(proxyURL)
+	if err != nil {
+		log.Warningf("updates: ignoring invalid update proxy: %s", err)
+		return nil
+	}
+	parentTransport, ok := parent.(*http.Transport)
+	if !ok {
+		log.Warningf("updates: cannot use update proxy with %T", parent)
+		return nil
+	}
+
+	if proxyTransport != nil {
+		proxyTransport.CloseIdleConnections()
+	}
+	proxyTransport = parentTransport.Clone()
+	proxyTransport.Proxy = http.ProxyURL(parsed)
+	proxyTransportURL = proxyURL
+
+	log.Infof("updates: using proxy %s for updates", parsed.Redacted())
+	return proxyTransport
+}
@@ -0,0 +1,229 @@
+package updates
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/info"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/runtime"
+)
+
+// The release notes of the latest release of the selected release channel are
+// fetched from the update server with every update check. They are exposed
+// via the runtime database, so that the UI and the notifier can show what is
+// new before and after upgrading.
+
+const (
+	releaseNotesProviderKey = "updates/release-notes"
+
+	// releaseNotesMaxSize is the maximum size of a release notes document.
+	releaseNotesMaxSize = 1024 * 1024
+
+	releaseNotesFetchTimeout = 30 * time.Second
+)
+
+var (
+	releaseNotes     *ReleaseNotes
+	releaseNotesLock sync.Mutex
+
+	pushReleaseNotes runtime.PushFunc
+
+	errNoReleaseNotes = errors.New("no release notes available")
+)
+
+// ReleaseNotes holds the release notes of the latest release of a release
+// channel. It's a read-only record exposed via runtime:updates/release-notes.
+type ReleaseNotes struct {
+	record.Base
+	sync.Mutex
+
+	// Channel is the release channel the notes belong to.
+	Channel string
+	// Version is the version of the release.
+	Version string
+	// Title is a short summary of the release.
+	Title string
+	// Published holds when the release was published as a unix timestamp.
+	Published int64
+	// Notes holds the release notes in Markdown.
+	Notes string
+	// URL links to the full changelog.
+	URL string
+
+	// RunningVersion holds the version of the running Portmaster, so that
+	// the notes can be shown before or after upgrading.
+	RunningVersion string
+	// Fetched holds when the notes were fetched as a unix timestamp.
+	Fetched int64
+}
+
+// releaseNotesDocument is the release notes document as served by the update
+// server.
+type releaseNotesDocument struct {
+	Version   string
+	Title     string
+	Published int64
+	Notes     string
+	URL       string
+}
+
+func registerReleaseNotesProvider() (err error) {
+	pushReleaseNotes, err = runtime.Register(
+		releaseNotesProviderKey,
+		runtime.SimpleValueGetterFunc(func(_ string) ([]record.Record, error) {
+			releaseNotesLock.Lock()
+			defer releaseNotesLock.Unlock()
+
+			if releaseNotes == nil {
+				return nil, nil
+			}
+			return []record.Record{releaseNotes}, nil
+		}),
+	)
+	return err
+}
+
+// GetReleaseNotes returns the release notes of the selected release channel,
+// if they were fetched. The returned record must be locked while reading it.
+func GetReleaseNotes() *ReleaseNotes {
+	releaseNotesLock.Lock()
+	defer releaseNotesLock.Unlock()
+
+	return releaseNotes
+}
+
+// updateReleaseNotes fetches the release notes of the selected release
+// channel and pushes them to the runtime database if they changed. Release
+// notes are informational only, so errors are only logged.
+func updateReleaseNotes(ctx context.Context) {
+	channel := releaseChannel()
+	doc, err := fetchReleaseNotes(ctx, channel)
+	switch {
+	case errors.Is(err, errNoReleaseNotes):
+		log.Debugf("updates: no release notes available for channel %s", channel)
+		removeReleaseNotes()
+		return
+	case err != nil:
+		log.Warningf("updates: failed to fetch release notes for channel %s: %s", channel, err)
+		return
+	}
+
+	releaseNotesLock.Lock()
+	defer releaseNotesLock.Unlock()
+
+	if releaseNotes != nil {
+		releaseNotes.Lock()
+		unchanged := releaseNotes.Channel == channel &&
+			releaseNotes.Version == doc.Version &&
+			releaseNotes.Title == doc.Title &&
+			releaseNotes.Notes == doc.Notes &&
+			releaseNotes.URL == doc.URL
+		if unchanged {
+			releaseNotes.Fetched = time.Now().Unix()
+		}
+		releaseNotes.Unlock()
+		if unchanged {
+			return
+		}
+	}
+
+	releaseNotes = &ReleaseNotes{
+		Channel:        channel,
+		Version:        doc.Version,
+		Title:          doc.Title,
+		Published:      doc.Published,
+		Notes:          doc.Notes,
+		URL:            doc.URL,
+		RunningVersion: info.Version(),
+		Fetched:        time.Now().Unix(),
+	}
+	releaseNotes.SetKey(runtime.DefaultRegistry.DatabaseName() + ":" + releaseNotesProviderKey)
+	releaseNotes.UpdateMeta()
+	log.Infof("updates: fetched release notes of %s for channel %s", doc.Version, channel)
+
+	if pushReleaseNotes != nil {
+		releaseNotes.Lock()
+		pushReleaseNotes(releaseNotes)
+		releaseNotes.Unlock()
+	}
+}
+
+func fetchReleaseNotes(ctx context.Context, channel string) (doc *releaseNotesDocument, err error) {
+	client := &http.Client{Timeout: releaseNotesFetchTimeout}
+	for _, updateURL := range updateURLs() {
+		doc, err = fetchReleaseNotesFrom(ctx, client, strings.TrimSuffix(updateURL, "/")+"/release-notes/"+channel+".json")
+		if err == nil || errors.Is(err, errNoReleaseNotes) {
+			return doc, err
+		}
+	}
+	if err == nil {
+		err = errors.New("no update servers configured")
+	}
+	return nil, err
+}
+
+func fetchReleaseNotesFrom(ctx context.Context, client *http.Client, url string) (*releaseNotesDocument, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if registry.UserAgent != "" {
+		req.Header.Set("User-Agent", registry.UserAgent)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, errNoReleaseNotes
+	default:
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	data, err := ioutil.ReadAll(&io.LimitedReader{R: resp.Body, N: releaseNotesMaxSize})
+	if err != nil {
+		return nil, err
+	}
+	doc := &releaseNotesDocument{}
+	if err := json.Unmarshal(data, doc); err != nil {
+		return nil, fmt.Errorf("failed to parse release notes: %w", err)
+	}
+	if doc.Version == "" {
+		return nil, errors.New("release notes are missing the version")
+	}
+	return doc, nil
+}
+
+// removeReleaseNotes removes the release notes, eg. when the selected release
+// channel has none.
+func removeReleaseNotes() {
+	releaseNotesLock.Lock()
+	defer releaseNotesLock.Unlock()
+
+	if releaseNotes == nil {
+		return
+	}
+
+	releaseNotes.Lock()
+	releaseNotes.Meta().Delete()
+	if pushReleaseNotes != nil {
+		pushReleaseNotes(releaseNotes)
+	}
+	releaseNotes.Unlock()
+	releaseNotes = nil
+}
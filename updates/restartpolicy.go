@@ -0,0 +1,110 @@
+package updates
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/tevino/abool"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+)
+
+// When a new version of the Portmaster Core was downloaded, a restart is
+// pending until the Portmaster Core is restarted. If enabled, the restart is
+// done automatically as soon as the restart policy allows it. Automatic
+// restarts only restart the Portmaster Core service. The user interface, ie.
+// the App and the Notifier, is never restarted automatically.
+
+// RestartPendingEvent is emitted when a restart is required to apply an
+// update.
+const RestartPendingEvent = "restart pending"
+
+// restartPolicyCheckInterval defines how often the restart policy is checked
+// while a restart is pending.
+const restartPolicyCheckInterval = 5 * time.Minute
+
+var (
+	updateRestartPending = abool.New()
+
+	restartPolicyTask *modules.Task
+
+	userActiveCheck     func() (active, known bool)
+	userActiveCheckLock sync.Mutex
+)
+
+// RestartPending returns whether an update was downloaded that is applied
+// when the Portmaster Core is restarted.
+func RestartPending() bool {
+	return updateRestartPending.IsSet()
+}
+
+// SetUserActivityCheck sets the function that reports whether a user is
+// currently active and whether user activity is known at all. It is used to
+// postpone automatic restarts while the user is active.
+func SetUserActivityCheck(fn func() (active, known bool)) {
+	userActiveCheckLock.Lock()
+	defer userActiveCheckLock.Unlock()
+
+	userActiveCheck = fn
+}
+
+func userActive() (active, known bool) {
+	userActiveCheckLock.Lock()
+	defer userActiveCheckLock.Unlock()
+
+	if userActiveCheck == nil {
+		return false, false
+	}
+	return userActiveCheck()
+}
+
+// markRestartPending marks that a restart is required to apply an update and
+// restarts automatically if the restart policy allows it.
+func markRestartPending() {
+	if updateRestartPending.SetToIf(false, true) {
+		module.TriggerEvent(RestartPendingEvent, true)
+	}
+	if automaticRestartEnabled() {
+		restartPolicyTask.StartASAP()
+	}
+}
+
+// restartAllowed returns whether the restart policy allows an automatic
+// restart at the given time. If not, it also returns the reason.
+func restartAllowed(now time.Time) (ok bool, reason string) {
+	inWindow, _, err := inTimeWindow(restartWindow(), now)
+	switch {
+	case err != nil:
+		return false, err.Error()
+	case !inWindow:
+		return false, "outside of restart time window"
+	}
+
+	if restartOnlyWhenIdle() {
+		active, known := userActive()
+		switch {
+		case !known:
+			return false, "user activity is unknown"
+		case active:
+			return false, "user is active"
+		}
+	}
+
+	return true, ""
+}
+
+func restartWithPolicy(ctx context.Context, _ *modules.Task) error {
+	if !RestartPending() || !automaticRestartEnabled() {
+		return nil
+	}
+
+	if ok, reason := restartAllowed(time.Now()); !ok {
+		log.Debugf("updates: postponing automatic restart: %s", reason)
+		return nil
+	}
+
+	log.Info("updates: restarting automatically to apply update")
+	return automaticRestart(ctx, nil)
+}
@@ -0,0 +1,290 @@
+package updates
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/dataroot"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/updater"
+	"github.com/safing/portbase/utils"
+)
+
+// Interrupted downloads of update files are resumed with HTTP range requests.
+// While an update file is downloaded, the received data is also written to a
+// partial file outside of the update storage. If the download is interrupted,
+// the next request for the same file only requests the missing rest, which is
+// then combined with the partial file, so that the registry receives the
+// complete file as usual. As all files are verified with their signature, a
+// corrupted partial file is detected like any other corrupted download.
+
+const (
+	partialSuffix = ".partial"
+
+	// partialMaxAge defines after which time unfinished downloads are
+	// discarded.
+	partialMaxAge = 7 * 24 * time.Hour
+)
+
+var (
+	partialDir *utils.DirStructure
+
+	// activePartials holds the versioned paths that are currently being
+	// downloaded, so that a partial file is only used by one download.
+	activePartials     = make(map[string]struct{})
+	activePartialsLock sync.Mutex
+)
+
+// initPartials prepares the directory for storing partial downloads and
+// removes outdated ones. It is kept outside of the update storage, so that
+// partial files are not picked up as resources and survive restarts.
+func initPartials() error {
+	partialDir = dataroot.Root().ChildDir("update-partials", 0700)
+	err := partialDir.Ensure()
+	if err != nil {
+		return err
+	}
+
+	_ = filepath.Walk(partialDir.Path, func(path string, info os.FileInfo, err error) error {
+		// Ignore files that cannot be read.
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		if time.Since(info.ModTime()) > partialMaxAge {
+			if err := os.Remove(path); err != nil {
+				log.Warningf("updates: failed to remove outdated partial download %s: %s", path, err)
+			}
+		}
+		return nil
+	})
+	return nil
+}
+
+type partialDownload struct {
+	versionedPath string
+	path          string
+	// offset holds the size of the existing partial file that is requested
+	// to be resumed.
+	offset int64
+
+	releaseOnce sync.Once
+}
+
+// prepareResume prepares the download of an update file to be persisted and
+// resumed. If a partial file exists, the request is changed to only request
+// the missing data. It returns nil if the request cannot be resumed.
+func prepareResume(req *http.Request) (*http.Request, *partialDownload) {
+	versionedPath := strings.TrimPrefix(path.Clean(req.URL.Path), "/")
+	switch {
+	case partialDir == nil:
+		return req, nil
+	case req.Method != http.MethodGet:
+		return req, nil
+	case strings.HasSuffix(versionedPath, signatureSuffix):
+		return req, nil
+	}
+	if _, _, ok := updater.GetIdentifierAndVersion(versionedPath); !ok {
+		return req, nil
+	}
+
+	activePartialsLock.Lock()
+	defer activePartialsLock.Unlock()
+	if _, active := activePartials[versionedPath]; active {
+		return req, nil
+	}
+	activePartials[versionedPath] = struct{}{}
+
+	pd := &partialDownload{
+		versionedPath: versionedPath,
+		path:          filepath.Join(partialDir.Path, filepath.FromSlash(versionedPath)) + partialSuffix,
+	}
+	if info, err := os.Stat(pd.path); err == nil && info.Size() > 0 {
+		pd.offset = info.Size()
+		req = req.Clone(req.Context())
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", pd.offset))
+	}
+	return req, pd
+}
+
+// release marks the download as finished, so that the partial file may be
+// used again.
+func (pd *partialDownload) release() {
+	if pd == nil {
+		return
+	}
+	pd.releaseOnce.Do(func() {
+		activePartialsLock.Lock()
+		defer activePartialsLock.Unlock()
+		delete(activePartials, pd.versionedPath)
+	})
+}
+
+// apply persists the response body to the partial file. If the response
+// resumes the partial file, it is changed to look like a complete response.
+func (pd *partialDownload) apply(resp *http.Response) {
+	if pd == nil {
+		return
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && pd.offset > 0:
+		total, ok := parseContentRange(resp.Header.Get("Content-Range"), pd.offset)
+		if !ok {
+			log.Warningf("updates: discarding partial download of %s, as the server returned an unexpected range", pd.versionedPath)
+			pd.discard()
+			return
+		}
+		prefix, err := os.Open(pd.path)
+		if err != nil {
+			log.Warningf("updates: failed to open partial download of %s: %s", pd.versionedPath, err)
+			pd.release()
+			return
+		}
+		file, err := os.OpenFile(pd.path, os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			_ = prefix.Close()
+			log.Warningf("updates: failed to open partial download of %s: %s", pd.versionedPath, err)
+			pd.release()
+			return
+		}
+		log.Infof("updates: resuming download of %s at %d of %d bytes", pd.versionedPath, pd.offset, total)
+
+		resp.StatusCode = http.StatusOK
+		resp.Status = "200 OK"
+		resp.ContentLength = total
+		resp.Header.Del("Content-Range")
+		resp.Body = &partialReader{
+			pd:         pd,
+			prefix:     io.LimitReader(prefix, pd.offset),
+			prefixFile: prefix,
+			parent:     resp.Body,
+			file:       file,
+		}
+
+	case resp.StatusCode == http.StatusOK:
+		// The server does not support ranges or there is no partial file, start
+		// from the beginning.
+		err := partialDir.EnsureAbsPath(filepath.Dir(pd.path))
+		if err != nil {
+			log.Warningf("updates: failed to create directory for partial download of %s: %s", pd.versionedPath, err)
+			pd.release()
+			return
+		}
+		file, err := os.OpenFile(pd.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			log.Warningf("updates: failed to create partial download of %s: %s", pd.versionedPath, err)
+			pd.release()
+			return
+		}
+		resp.Body = &partialReader{
+			pd:     pd,
+			parent: resp.Body,
+			file:   file,
+		}
+
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		log.Warningf("updates: discarding partial download of %s, as the server rejected the range", pd.versionedPath)
+		pd.discard()
+
+	default:
+		// Keep the partial file for the next try.
+		pd.release()
+	}
+}
+
+// discard removes the partial file and releases the download.
+func (pd *partialDownload) discard() {
+	if err := os.Remove(pd.path); err != nil && !os.IsNotExist(err) {
+		log.Warningf("updates: failed to remove partial download of %s: %s", pd.versionedPath, err)
+	}
+	pd.release()
+}
+
+// parseContentRange parses the Content-Range header of a response to a range
+// request and returns the complete size of the file. The range must start at
+// the given offset and reach to the end of the file.
+func parseContentRange(contentRange string, offset int64) (total int64, ok bool) {
+	var start, end int64
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &total); err != nil {
+		return 0, false
+	}
+	if start != offset || end != total-1 {
+		return 0, false
+	}
+	return total, true
+}
+
+// partialReader reads the partial file first, if resuming, and then the
+// response, which is written to the partial file.
+type partialReader struct {
+	pd *partialDownload
+
+	prefix     io.Reader
+	prefixFile *os.File
+	parent     io.ReadCloser
+	file       *os.File
+
+	complete  bool
+	closeOnce sync.Once
+}
+
+func (pr *partialReader) Read(p []byte) (n int, err error) {
+	if pr.prefix != nil {
+		n, err = pr.prefix.Read(p)
+		switch {
+		case err == io.EOF:
+			pr.prefix = nil
+			if n > 0 {
+				return n, nil
+			}
+		case err != nil:
+			// Start from the beginning next time.
+			_ = pr.file.Close()
+			pr.file = nil
+			_ = os.Remove(pr.pd.path)
+			return n, fmt.Errorf("failed to read partial download: %w", err)
+		default:
+			return n, nil
+		}
+	}
+
+	n, err = pr.parent.Read(p)
+	if n > 0 && pr.file != nil {
+		if _, writeErr := pr.file.Write(p[:n]); writeErr != nil {
+			log.Warningf("updates: failed to write partial download of %s: %s", pr.pd.versionedPath, writeErr)
+			_ = pr.file.Close()
+			pr.file = nil
+			_ = os.Remove(pr.pd.path)
+		}
+	}
+	if err == io.EOF {
+		pr.complete = true
+	}
+	return n, err
+}
+
+func (pr *partialReader) Close() error {
+	err := pr.parent.Close()
+	pr.closeOnce.Do(func() {
+		if pr.prefixFile != nil {
+			_ = pr.prefixFile.Close()
+		}
+		if pr.file != nil {
+			_ = pr.file.Close()
+		}
+		// The partial file is not needed anymore when the download is
+		// complete. Otherwise, it is kept to resume the download.
+		if pr.complete {
+			_ = os.Remove(pr.pd.path)
+		}
+		pr.pd.release()
+	})
+	return err
+}
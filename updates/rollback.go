@@ -0,0 +1,336 @@
+package updates
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/updater"
+	"github.com/safing/portmaster/updates/helper"
+)
+
+// Resources can be pinned to a specific version, either directly or by
+// rolling them back to the version before the currently selected one. Pins
+// are saved in the update storage, so that portmaster-start also starts the
+// pinned versions. They are applied by blacklisting all newer versions and
+// stay in place until they are removed, while all other resources keep
+// updating.
+
+var (
+	// pinnedVersions maps identifiers to their pinned version.
+	pinnedVersions = make(map[string]string)
+	// pinBlacklisted holds the versioned paths of the versions that were
+	// blacklisted to apply the pins.
+	pinBlacklisted     = make(map[string]struct{})
+	pinnedVersionsLock sync.Mutex
+)
+
+// RollbackResult describes a resource that was rolled back.
+type RollbackResult struct {
+	Identifier string
+	From       string
+	To         string
+}
+
+func loadPinnedVersions() {
+	pins, err := helper.LoadPinnedVersions(registry)
+	if err != nil {
+		log.Warningf("updates: failed to load pinned versions: %s", err)
+	}
+
+	pinnedVersionsLock.Lock()
+	defer pinnedVersionsLock.Unlock()
+	pinnedVersions = pins
+}
+
+// selectVersions applies the pinned versions and selects the versions of all
+// resources. It must be used instead of registry.SelectVersions.
+func selectVersions() {
+	pinnedVersionsLock.Lock()
+	defer pinnedVersionsLock.Unlock()
+
+	// Pins may have changed, start from scratch.
+	resetPinBlacklist()
+	for _, versionedPath := range helper.ApplyPinnedVersions(registry, pinnedVersions) {
+		pinBlacklisted[versionedPath] = struct{}{}
+	}
+
+	registry.SelectVersions()
+	avoidRejectedSelections()
+}
+
+// resetPinBlacklist removes the blacklisting of all versions that were
+// blacklisted to apply the pins. Versions that were also rejected because of
+// their signature stay blacklisted. The caller must hold pinnedVersionsLock.
+func resetPinBlacklist() {
+	if len(pinBlacklisted) == 0 {
+		return
+	}
+
+	for _, res := range registry.Export() {
+		res.Lock()
+		verifiedVersionsLock.Lock()
+		for _, rv := range res.Versions {
+			versionedPath := updater.GetVersionedPath(res.Identifier, rv.VersionNumber)
+			if _, ok := pinBlacklisted[versionedPath]; !ok {
+				continue
+			}
+			if _, rejected := rejectedVersions[versionedPath]; !rejected {
+				rv.Blacklisted = false
+			}
+		}
+		verifiedVersionsLock.Unlock()
+		res.Unlock()
+	}
+
+	pinBlacklisted = make(map[string]struct{})
+}
+
+// RollbackResource pins the resource with the given identifier to the
+// version before the currently selected one. The previous version must be
+// available locally.
+func RollbackResource(identifier string) (*RollbackResult, error) {
+	if !module.Online() {
+		return nil, errors.New("updates module is not online")
+	}
+
+	res, ok := registry.Export()[identifier]
+	if !ok {
+		return nil, fmt.Errorf("unknown resource %s", identifier)
+	}
+
+	pinnedVersionsLock.Lock()
+	previousPins := copyPinnedVersions()
+	result, err := pinPreviousVersion(res)
+	if err == nil {
+		err = savePinnedVersions(previousPins)
+	}
+	pinnedVersionsLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	applyRollback()
+	log.Infof("updates: rolled back %s from %s to %s", identifier, result.From, result.To)
+	return result, nil
+}
+
+// RollbackAll pins all resources that have a previous version available
+// locally to that version.
+func RollbackAll() ([]*RollbackResult, error) {
+	if !module.Online() {
+		return nil, errors.New("updates module is not online")
+	}
+
+	pinnedVersionsLock.Lock()
+	previousPins := copyPinnedVersions()
+	rolledBack := make(map[string]*RollbackResult)
+	for identifier, res := range registry.Export() {
+		result, err := pinPreviousVersion(res)
+		if err != nil {
+			log.Debugf("updates: not rolling back %s: %s", identifier, err)
+			continue
+		}
+		rolledBack[identifier] = result
+	}
+	var err error
+	if len(rolledBack) == 0 {
+		err = errors.New("no previous versions available locally")
+	} else {
+		err = savePinnedVersions(previousPins)
+	}
+	pinnedVersionsLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	applyRollback()
+
+	results := make([]*RollbackResult, 0, len(rolledBack))
+	for _, result := range rolledBack {
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Identifier < results[j].Identifier
+	})
+	log.Infof("updates: rolled back %d resources", len(results))
+	return results, nil
+}
+
+// ResetRollbacks removes all pins, so that the newest versions are selected
+// again.
+func ResetRollbacks(ctx context.Context) error {
+	if !module.Online() {
+		return errors.New("updates module is not online")
+	}
+
+	pinnedVersionsLock.Lock()
+	previousPins := pinnedVersions
+	pinnedVersions = make(map[string]string)
+	if err := savePinnedVersions(previousPins); err != nil {
+		pinnedVersionsLock.Unlock()
+		return err
+	}
+	resetPinBlacklist()
+	pinnedVersionsLock.Unlock()
+
+	// The newer versions were skipped while they were blacklisted.
+	verifyUpdateSignatures(ctx)
+
+	applyRollback()
+	log.Infof("updates: reset %d pinned versions", len(previousPins))
+	return nil
+}
+
+// PinnedVersions returns the pinned versions by identifier.
+func PinnedVersions() map[string]string {
+	pinnedVersionsLock.Lock()
+	defer pinnedVersionsLock.Unlock()
+
+	return copyPinnedVersions()
+}
+
+// PinResource pins the resource with the given identifier to the given
+// version. The version must be available locally.
+func PinResource(identifier, version string) error {
+	if !module.Online() {
+		return errors.New("updates module is not online")
+	}
+
+	res, ok := registry.Export()[identifier]
+	if !ok {
+		return fmt.Errorf("unknown resource %s", identifier)
+	}
+
+	res.Lock()
+	var available bool
+	var versionedPath string
+	for _, rv := range res.Versions {
+		if rv.Available && rv.EqualsVersion(version) {
+			available = true
+			versionedPath = updater.GetVersionedPath(identifier, rv.VersionNumber)
+			break
+		}
+	}
+	res.Unlock()
+	if !available {
+		return fmt.Errorf("version %s of %s is not available locally", version, identifier)
+	}
+	verifiedVersionsLock.Lock()
+	rejected := isRejected(versionedPath)
+	verifiedVersionsLock.Unlock()
+	if rejected {
+		return fmt.Errorf("version %s of %s was rejected because of a missing or invalid signature", version, identifier)
+	}
+
+	pinnedVersionsLock.Lock()
+	previousPins := copyPinnedVersions()
+	pinnedVersions[identifier] = version
+	err := savePinnedVersions(previousPins)
+	pinnedVersionsLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	applyRollback()
+	log.Infof("updates: pinned %s to %s", identifier, version)
+	return nil
+}
+
+// UnpinResource removes the pin of the resource with the given identifier,
+// so that its newest version is selected again.
+func UnpinResource(ctx context.Context, identifier string) error {
+	if !module.Online() {
+		return errors.New("updates module is not online")
+	}
+
+	pinnedVersionsLock.Lock()
+	if _, ok := pinnedVersions[identifier]; !ok {
+		pinnedVersionsLock.Unlock()
+		return fmt.Errorf("%s is not pinned", identifier)
+	}
+	previousPins := copyPinnedVersions()
+	delete(pinnedVersions, identifier)
+	if err := savePinnedVersions(previousPins); err != nil {
+		pinnedVersionsLock.Unlock()
+		return err
+	}
+	resetPinBlacklist()
+	pinnedVersionsLock.Unlock()
+
+	// The newer versions were skipped while they were blacklisted.
+	verifyUpdateSignatures(ctx)
+
+	applyRollback()
+	log.Infof("updates: unpinned %s", identifier)
+	return nil
+}
+
+// pinPreviousVersion pins the newest available version before the selected
+// version. The caller must hold pinnedVersionsLock.
+func pinPreviousVersion(res *updater.Resource) (*RollbackResult, error) {
+	res.Lock()
+	defer res.Unlock()
+
+	current := res.SelectedVersion
+	if current == nil {
+		return nil, errors.New("no version selected")
+	}
+
+	var previous *updater.ResourceVersion
+	for _, rv := range res.Versions {
+		switch {
+		case !rv.Available || rv.Blacklisted:
+		case rv.EqualsVersion("0.0.0"):
+			// Ignore dev versions.
+		case !rv.SemVer().LessThan(current.SemVer()):
+		case previous == nil || rv.SemVer().GreaterThan(previous.SemVer()):
+			previous = rv
+		}
+	}
+	if previous == nil {
+		return nil, errors.New("no previous version available locally")
+	}
+
+	pinnedVersions[res.Identifier] = previous.VersionNumber
+	return &RollbackResult{
+		Identifier: res.Identifier,
+		From:       current.VersionNumber,
+		To:         previous.VersionNumber,
+	}, nil
+}
+
+// savePinnedVersions saves the pinned versions and restores the given
+// previous pins if saving fails. The caller must hold pinnedVersionsLock.
+func savePinnedVersions(previousPins map[string]string) error {
+	if err := helper.SavePinnedVersions(registry, pinnedVersions); err != nil {
+		pinnedVersions = previousPins
+		return fmt.Errorf("failed to save pinned versions: %w", err)
+	}
+	return nil
+}
+
+// copyPinnedVersions returns a copy of the pinned versions. The caller must
+// hold pinnedVersionsLock.
+func copyPinnedVersions() map[string]string {
+	pins := make(map[string]string, len(pinnedVersions))
+	for identifier, version := range pinnedVersions {
+		pins[identifier] = version
+	}
+	return pins
+}
+
+// applyRollback selects the versions with the current pins and notifies the
+// upgrader, which offers restarting into the selected versions.
+func applyRollback() {
+	selectVersions()
+	if err := registry.UnpackResources(); err != nil {
+		log.Warningf("updates: failed to unpack resources after rollback: %s", err)
+	}
+
+	module.TriggerEvent(VersionUpdateEvent, nil)
+	module.TriggerEvent(ResourceUpdateEvent, nil)
+}
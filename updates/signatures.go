@@ -0,0 +1,418 @@
+package updates
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/dataroot"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/updater"
+	"github.com/safing/portbase/utils"
+	"github.com/safing/portbase/utils/renameio"
+	"github.com/safing/portmaster/updates/helper"
+)
+
+// Signature verification of update files.
+//
+// Every update file is signed with Ed25519, see updates/helper for the
+// format. Files with an invalid signature are blacklisted, so that they are not
+// selected, and are deleted, so that they are downloaded again with the next
+// update check. The active version is never blacklisted or deleted, as it is
+// in use. Files that the update server has no signature for, or whose
+// signature cannot be fetched, are blacklisted until they can be verified.
+// Rejected versions are never selected or handed out, even if no other
+// version is available. All rejections are reported with a module warning.
+//
+// Verified files are recorded with their size and modification time, so that
+// they are not verified again on every start. On start, files are verified
+// with the locally saved signatures before versions are selected; missing
+// signatures are fetched with the next update check.
+
+const (
+	signatureSuffix       = helper.SignatureSuffix
+	signatureFetchTimeout = 30 * time.Second
+
+	updateSignatureFailed   = "updates:signature-failed"
+	updateSigningKeyMissing = "updates:signing-key-missing"
+
+	verifiedVersionsFile = "verified.json"
+)
+
+var (
+	errSignatureUnavailable = helper.ErrSignatureUnavailable
+	errUnsigned             = helper.ErrUnsigned
+	errInvalidSignature     = helper.ErrInvalidSignature
+)
+
+// updateSigningKeyData holds the base64 encoded update signing key, which is
+// pinned at build time in updates/helper. Builds without a key do not use any
+// update files outside of dev mode.
+var updateSigningKeyData = helper.SigningKeyData()
+
+var (
+	updateSigningKey ed25519.PublicKey
+	signatureDir     *utils.DirStructure
+
+	// verifiedVersions holds the versioned paths of the update files that
+	// have a valid signature.
+	verifiedVersions = make(map[string]*verifiedFile)
+	// rejectedVersions holds the versioned paths of the update files that
+	// were blacklisted because of a missing or invalid signature.
+	rejectedVersions     = make(map[string]struct{})
+	verifiedVersionsLock sync.Mutex
+)
+
+// verifiedFile describes an update file at the time it was verified.
+type verifiedFile struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// verifiedVersionsRecord is the format of the file verified versions are
+// persisted in.
+type verifiedVersionsRecord struct {
+	// SigningKey holds the key the files were verified with. Files are verified
+	// again if the key changed.
+	SigningKey string
+	Files      map[string]*verifiedFile
+}
+
+// initSignatures loads the signing key, prepares the directory for storing
+// signatures and loads the verified versions. The directory is kept outside
+// of the update storage, so that signatures are not picked up as resources.
+func initSignatures() error {
+	switch {
+	case updateSigningKeyData != "":
+		key, err := helper.DecodeSigningKey(updateSigningKeyData)
+		if err != nil {
+			return err
+		}
+		updateSigningKey = key
+	case registry.DevMode:
+		log.Warning("updates: no update signing key built in, update files are not verified in dev mode")
+	default:
+		log.Error("updates: no update signing key built in, update files are not used")
+		module.Error(
+			updateSigningKeyMissing,
+			"No Update Signing Key",
+			"This build of Portmaster has no update signing key built in. Update files cannot be verified and are not used. Please use an official release or build Portmaster with the build script.",
+		)
+	}
+
+	signatureDir = dataroot.Root().ChildDir(helper.SignatureDirName, 0755)
+	if err := signatureDir.Ensure(); err != nil {
+		return err
+	}
+
+	verifiedVersionsLock.Lock()
+	defer verifiedVersionsLock.Unlock()
+
+	verifiedVersions = loadVerifiedVersions(
+		filepath.Join(signatureDir.Path, verifiedVersionsFile),
+		registry.StorageDir().Path,
+		updateSigningKeyData,
+	)
+	return nil
+}
+
+// loadVerifiedVersions loads the verified versions from the given file. Files
+// that were modified since they were verified or that were verified with
+// another key are omitted.
+func loadVerifiedVersions(recordPath, storageDir, signingKey string) map[string]*verifiedFile {
+	verified := make(map[string]*verifiedFile)
+
+	data, err := ioutil.ReadFile(recordPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warningf("updates: failed to load verified versions: %s", err)
+		}
+		return verified
+	}
+	record := &verifiedVersionsRecord{}
+	if err := json.Unmarshal(data, record); err != nil {
+		log.Warningf("updates: failed to parse verified versions: %s", err)
+		return verified
+	}
+	if record.SigningKey != signingKey {
+		return verified
+	}
+
+	for versionedPath, recorded := range record.Files {
+		current, err := statVerifiedFile(filepath.Join(storageDir, filepath.FromSlash(versionedPath)))
+		if err == nil && current.Size == recorded.Size && current.ModTime.Equal(recorded.ModTime) {
+			verified[versionedPath] = recorded
+		}
+	}
+	return verified
+}
+
+// saveVerifiedVersions persists the verified versions. The caller must hold
+// verifiedVersionsLock.
+func saveVerifiedVersions() {
+	if signatureDir == nil {
+		return
+	}
+
+	data, err := json.MarshalIndent(&verifiedVersionsRecord{
+		SigningKey: updateSigningKeyData,
+		Files:      verifiedVersions,
+	}, "", "  ")
+	if err == nil {
+		err = renameio.WriteFile(filepath.Join(signatureDir.Path, verifiedVersionsFile), data, 0644)
+	}
+	if err != nil {
+		log.Warningf("updates: failed to save verified versions: %s", err)
+	}
+}
+
+func statVerifiedFile(storagePath string) (*verifiedFile, error) {
+	info, err := os.Stat(storagePath)
+	if err != nil {
+		return nil, err
+	}
+	return &verifiedFile{
+		Size:    info.Size(),
+		ModTime: info.ModTime().UTC(),
+	}, nil
+}
+
+// verifyUpdateSignatures verifies the signatures of all available update
+// files that have not yet been verified. It must be called before selecting
+// versions, so that rejected files are never selected.
+func verifyUpdateSignatures(ctx context.Context) {
+	verifySignatures(ctx, true)
+}
+
+// verifyLocalUpdateSignatures is like verifyUpdateSignatures, but only uses
+// the locally saved signatures. Files without one are rejected until the
+// next update check. It is used on start, where the network may not be ready.
+func verifyLocalUpdateSignatures(ctx context.Context) {
+	verifySignatures(ctx, false)
+}
+
+func verifySignatures(ctx context.Context, fetch bool) {
+	if registry.DevMode || len(updateSigningKey) != ed25519.PublicKeySize {
+		// Without a key, all update files are refused by checkSelectedVersion.
+		return
+	}
+
+	ctx = withUpdateRequest(ctx)
+	client := &http.Client{Timeout: signatureFetchTimeout}
+	var rejected []string
+	defer func() {
+		verifiedVersionsLock.Lock()
+		defer verifiedVersionsLock.Unlock()
+		saveVerifiedVersions()
+	}()
+	for _, res := range registry.Export() {
+		for _, rv := range versionsToVerify(res) {
+			versionedPath := updater.GetVersionedPath(res.Identifier, rv.VersionNumber)
+			err := verifyUpdateFile(ctx, client, versionedPath, fetch)
+			switch {
+			case err == nil:
+				markVerified(res, rv, versionedPath)
+				continue
+			case ctx.Err() != nil:
+				return
+			}
+
+			log.Warningf("updates: rejecting %s: %s", versionedPath, err)
+			rejectVersion(res, rv, versionedPath, errors.Is(err, errInvalidSignature))
+			rejected = append(rejected, versionedPath)
+		}
+	}
+
+	if len(rejected) > 0 {
+		module.Warning(
+			updateSignatureFailed,
+			"Update Files Rejected",
+			fmt.Sprintf(
+				"The following update files were rejected, because their signature is missing, invalid or could not be fetched. They will be verified or downloaded again with the next update check. If this persists, the update files may have been tampered with.\n\n- %s",
+				strings.Join(rejected, "\n- "),
+			),
+		)
+	} else {
+		module.Resolve(updateSignatureFailed)
+	}
+}
+
+// versionsToVerify returns the versions of the resource that are available
+// locally, but were not verified yet. Versions that were rejected are
+// verified again once they were downloaded again.
+func versionsToVerify(res *updater.Resource) []*updater.ResourceVersion {
+	res.Lock()
+	defer res.Unlock()
+
+	verifiedVersionsLock.Lock()
+	defer verifiedVersionsLock.Unlock()
+
+	var toVerify []*updater.ResourceVersion
+	for _, rv := range res.Versions {
+		if !rv.Available {
+			continue
+		}
+		versionedPath := updater.GetVersionedPath(res.Identifier, rv.VersionNumber)
+		if _, ok := verifiedVersions[versionedPath]; ok {
+			continue
+		}
+		if _, ok := rejectedVersions[versionedPath]; !ok && rv.Blacklisted {
+			// Blacklisted for another reason.
+			continue
+		}
+		toVerify = append(toVerify, rv)
+	}
+	return toVerify
+}
+
+func markVerified(res *updater.Resource, rv *updater.ResourceVersion, versionedPath string) {
+	verified, err := statVerifiedFile(filepath.Join(registry.StorageDir().Path, filepath.FromSlash(versionedPath)))
+	if err != nil {
+		log.Warningf("updates: failed to record verified file %s: %s", versionedPath, err)
+		return
+	}
+
+	res.Lock()
+	defer res.Unlock()
+	verifiedVersionsLock.Lock()
+	defer verifiedVersionsLock.Unlock()
+
+	verifiedVersions[versionedPath] = verified
+	if _, ok := rejectedVersions[versionedPath]; ok {
+		delete(rejectedVersions, versionedPath)
+		rv.Blacklisted = false
+	}
+}
+
+// rejectVersion records the version as rejected and blacklists it, unless it
+// is the active version. If invalid is set, the signature and, unless it is
+// the active version, the file are deleted, so that they are downloaded again.
+func rejectVersion(res *updater.Resource, rv *updater.ResourceVersion, versionedPath string, invalid bool) {
+	res.Lock()
+	defer res.Unlock()
+	verifiedVersionsLock.Lock()
+	defer verifiedVersionsLock.Unlock()
+
+	rejectedVersions[versionedPath] = struct{}{}
+	inUse := !mayDeleteVersion(res, rv)
+	if !inUse {
+		rv.Blacklisted = true
+	}
+	if !invalid {
+		return
+	}
+
+	sigPath := filepath.Join(signatureDir.Path, filepath.FromSlash(versionedPath)+signatureSuffix)
+	if err := os.Remove(sigPath); err != nil && !os.IsNotExist(err) {
+		log.Warningf("updates: failed to delete signature %s: %s", sigPath, err)
+	}
+	if inUse {
+		log.Warningf("updates: not deleting rejected file %s, as it is in use", versionedPath)
+		return
+	}
+
+	// Mark as unavailable, so that it is downloaded again.
+	rv.Available = false
+	storagePath := filepath.Join(registry.StorageDir().Path, filepath.FromSlash(versionedPath))
+	if err := os.Remove(storagePath); err != nil && !os.IsNotExist(err) {
+		log.Warningf("updates: failed to delete rejected file %s: %s", storagePath, err)
+	}
+}
+
+// isRejected returns whether the version at the given versioned path was
+// rejected. The caller must hold verifiedVersionsLock.
+func isRejected(versionedPath string) bool {
+	_, rejected := rejectedVersions[versionedPath]
+	return rejected
+}
+
+// avoidRejectedSelections replaces selected versions that were rejected with
+// the newest selectable version that was not. If there is none, the selection
+// is kept, but refused by checkSelectedVersion. This is needed, as the
+// registry falls back to a blacklisted version if all versions are
+// blacklisted, and the active version is never blacklisted.
+func avoidRejectedSelections() {
+	for _, res := range registry.Export() {
+		res.Lock()
+		verifiedVersionsLock.Lock()
+
+		selected := res.SelectedVersion
+		if selected != nil && isRejected(updater.GetVersionedPath(res.Identifier, selected.VersionNumber)) {
+			var replacement *updater.ResourceVersion
+			for _, rv := range res.Versions {
+				if rv.Available && !rv.Blacklisted &&
+					!isRejected(updater.GetVersionedPath(res.Identifier, rv.VersionNumber)) {
+					replacement = rv
+					break
+				}
+			}
+			if replacement != nil {
+				res.SelectedVersion = replacement
+				log.Warningf("updates: selected %s instead of rejected version %s for %s", replacement.VersionNumber, selected.VersionNumber, res.Identifier)
+			} else {
+				log.Warningf("updates: no verified version of %s available", res.Identifier)
+			}
+		}
+
+		verifiedVersionsLock.Unlock()
+		res.Unlock()
+	}
+}
+
+// checkSelectedVersion returns an error if the selected version of the
+// resource with the given identifier was rejected, or if there is no signing
+// key to verify it with.
+func checkSelectedVersion(identifier string) error {
+	if !registry.DevMode && len(updateSigningKey) != ed25519.PublicKeySize {
+		return errors.New("no update signing key built in")
+	}
+
+	res, ok := registry.Export()[identifier]
+	if !ok {
+		// Handled by the registry.
+		return nil
+	}
+
+	res.Lock()
+	defer res.Unlock()
+	verifiedVersionsLock.Lock()
+	defer verifiedVersionsLock.Unlock()
+
+	if res.SelectedVersion == nil {
+		return nil
+	}
+	if isRejected(updater.GetVersionedPath(identifier, res.SelectedVersion.VersionNumber)) {
+		return fmt.Errorf("version %s of %s was rejected because of a missing or invalid signature", res.SelectedVersion.VersionNumber, identifier)
+	}
+	return nil
+}
+
+// mayDeleteVersion returns whether the file of the version may be deleted,
+// which is not the case for the active version. The caller must hold the
+// resource lock.
+func mayDeleteVersion(res *updater.Resource, rv *updater.ResourceVersion) bool {
+	return res.ActiveVersion == nil || res.ActiveVersion.VersionNumber != rv.VersionNumber
+}
+
+// verifyUpdateFile verifies the update file at the given versioned path
+// against its signature. If fetch is set, the signature is downloaded if it is
+// not yet available locally.
+func verifyUpdateFile(ctx context.Context, client *http.Client, versionedPath string, fetch bool) error {
+	verifier := &helper.SignatureVerifier{
+		Registry:     registry,
+		Key:          updateSigningKey,
+		SignatureDir: signatureDir,
+		UpdateURLs:   updateURLs,
+		Client:       client,
+	}
+	return verifier.VerifyFile(ctx, versionedPath, fetch)
+}
@@ -0,0 +1,372 @@
+package updates
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/safing/portbase/updater"
+	"github.com/safing/portbase/utils"
+	"github.com/safing/portmaster/updates/helper"
+)
+
+func TestVerifyBundleFile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "portmaster-bundle-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	pubKey, privKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousKey := updateSigningKey
+	defer func() {
+		updateSigningKey = previousKey
+	}()
+
+	writeFile(t, filepath.Join(tmpDir, "all", "file_v1-0-0"), "file")
+	writeFile(t, filepath.Join(tmpDir, "all", "file_v1-0-0"+signatureSuffix), signFile(privKey, "all/file_v1-0-0", "file"))
+
+	updateSigningKey = pubKey
+	if err := verifyBundleFile(tmpDir, "all/file_v1-0-0"); err != nil {
+		t.Errorf("valid bundle file was rejected: %s", err)
+	}
+
+	// A validly signed file must not be accepted as another version.
+	writeFile(t, filepath.Join(tmpDir, "all", "file_v2-0-0"), "file")
+	writeFile(t, filepath.Join(tmpDir, "all", "file_v2-0-0"+signatureSuffix), signFile(privKey, "all/file_v1-0-0", "file"))
+	if err := verifyBundleFile(tmpDir, "all/file_v2-0-0"); err == nil {
+		t.Error("bundle file with the signature of another version was accepted")
+	}
+
+	updateSigningKey = nil
+	if err := verifyBundleFile(tmpDir, "all/file_v1-0-0"); err == nil {
+		t.Error("bundle file was accepted without a key")
+	}
+}
+
+func TestCheckIndexDowngrade(t *testing.T) {
+	bundleDir, err := ioutil.TempDir("", "portmaster-bundle-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(bundleDir)
+	installedDir, err := ioutil.TempDir("", "portmaster-updates-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(installedDir)
+
+	// Nothing is installed yet.
+	writeFile(t, filepath.Join(bundleDir, "stable.json"), `{"all/ui/app.zip": "0.2.0"}`)
+	if err := checkIndexDowngrade(bundleDir, installedDir, "stable.json"); err != nil {
+		t.Errorf("first index was rejected: %s", err)
+	}
+
+	writeFile(t, filepath.Join(installedDir, "stable.json"), `{"all/ui/app.zip": "0.2.0", "all/other.zip": "1.0.0"}`)
+	if err := checkIndexDowngrade(bundleDir, installedDir, "stable.json"); err != nil {
+		t.Errorf("index with same versions was rejected: %s", err)
+	}
+	writeFile(t, filepath.Join(bundleDir, "stable.json"), `{"all/ui/app.zip": "0.10.0"}`)
+	if err := checkIndexDowngrade(bundleDir, installedDir, "stable.json"); err != nil {
+		t.Errorf("newer index was rejected: %s", err)
+	}
+	writeFile(t, filepath.Join(bundleDir, "stable.json"), `{"all/ui/app.zip": "0.1.9"}`)
+	if err := checkIndexDowngrade(bundleDir, installedDir, "stable.json"); err == nil {
+		t.Error("older index was accepted")
+	}
+}
+
+func TestLoadVerifiedVersions(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "portmaster-updates-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeFile(t, filepath.Join(tmpDir, "unchanged_v1-0-0"), "unchanged")
+	writeFile(t, filepath.Join(tmpDir, "modified_v1-0-0"), "original")
+
+	files := make(map[string]*verifiedFile)
+	for _, versionedPath := range []string{"unchanged_v1-0-0", "modified_v1-0-0", "missing_v1-0-0"} {
+		files[versionedPath] = &verifiedFile{Size: 9, ModTime: time.Now().UTC()}
+	}
+	for _, versionedPath := range []string{"unchanged_v1-0-0", "modified_v1-0-0"} {
+		verified, err := statVerifiedFile(filepath.Join(tmpDir, versionedPath))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[versionedPath] = verified
+	}
+	writeFile(t, filepath.Join(tmpDir, "modified_v1-0-0"), "tampered, longer")
+
+	recordPath := filepath.Join(tmpDir, verifiedVersionsFile)
+	previousDir, previousKey, previousVerified := signatureDir, updateSigningKeyData, verifiedVersions
+	defer func() {
+		signatureDir, updateSigningKeyData, verifiedVersions = previousDir, previousKey, previousVerified
+	}()
+	signatureDir = utils.NewDirStructure(tmpDir, 0755)
+	updateSigningKeyData = "key"
+	verifiedVersions = files
+	saveVerifiedVersions()
+
+	loaded := loadVerifiedVersions(recordPath, tmpDir, "key")
+	if len(loaded) != 1 || loaded["unchanged_v1-0-0"] == nil {
+		t.Errorf("expected only the unchanged file to be verified, got %v", loaded)
+	}
+	if loaded := loadVerifiedVersions(recordPath, tmpDir, "other-key"); len(loaded) != 0 {
+		t.Errorf("expected no verified files with another key, got %v", loaded)
+	}
+	if loaded := loadVerifiedVersions(filepath.Join(tmpDir, "missing.json"), tmpDir, "key"); len(loaded) != 0 {
+		t.Errorf("expected no verified files without record, got %v", loaded)
+	}
+}
+
+func TestVerifyUpdateSignatures(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "portmaster-updates-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	pubKey, privKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Serve signatures: "valid" is signed, "invalid" has a bad signature and
+	// "unsigned" has none.
+	signatures := map[string]string{
+		"/all/valid_v1-0-0.sig":   signFile(privKey, "all/valid_v1-0-0", "valid"),
+		"/all/invalid_v1-0-0.sig": signFile(privKey, "all/invalid_v1-0-0", "other"),
+		"/all/active_v1-0-0.sig":  signFile(privKey, "all/active_v1-0-0", "other"),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sig, ok := signatures[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(sig))
+	}))
+	defer server.Close()
+
+	previousRegistry, previousDir, previousKey, previousKeyData := registry, signatureDir, updateSigningKey, updateSigningKeyData
+	previousVerified, previousRejected := verifiedVersions, rejectedVersions
+	defer func() {
+		registry, signatureDir, updateSigningKey, updateSigningKeyData = previousRegistry, previousDir, previousKey, previousKeyData
+		verifiedVersions, rejectedVersions = previousVerified, previousRejected
+	}()
+
+	registry = &updater.ResourceRegistry{
+		Name:       "test",
+		UpdateURLs: []string{server.URL},
+	}
+	storageDir := utils.NewDirStructure(filepath.Join(tmpDir, "updates"), 0755)
+	if err := registry.Initialize(storageDir); err != nil {
+		t.Fatal(err)
+	}
+	signatureDir = utils.NewDirStructure(filepath.Join(tmpDir, "signatures"), 0755)
+	if err := signatureDir.Ensure(); err != nil {
+		t.Fatal(err)
+	}
+	updateSigningKey = pubKey
+	updateSigningKeyData = base64.StdEncoding.EncodeToString(pubKey)
+	verifiedVersions = make(map[string]*verifiedFile)
+	rejectedVersions = make(map[string]struct{})
+
+	for _, name := range []string{"valid", "invalid", "unsigned", "active"} {
+		writeFile(t, filepath.Join(storageDir.Path, "all", name+"_v1-0-0"), name)
+		if err := registry.AddResource("all/"+name, "1.0.0", true, true, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resources := registry.Export()
+	resources["all/active"].ActiveVersion = resources["all/active"].Versions[0]
+
+	verifyUpdateSignatures(context.Background())
+
+	// Valid files are verified and the result is persisted.
+	if verifiedVersions["all/valid_v1-0-0"] == nil {
+		t.Error("valid file was not verified")
+	}
+	persisted := loadVerifiedVersions(filepath.Join(signatureDir.Path, verifiedVersionsFile), storageDir.Path, updateSigningKeyData)
+	if persisted["all/valid_v1-0-0"] == nil {
+		t.Error("verified file was not persisted")
+	}
+
+	// Unsigned files are rejected, but kept until a signature is published.
+	if rv := resources["all/unsigned"].Versions[0]; !rv.Blacklisted || !rv.Available {
+		t.Error("unsigned file was not rejected")
+	}
+	assertFileExists(t, filepath.Join(storageDir.Path, "all", "unsigned_v1-0-0"), true)
+
+	// Files with an invalid signature are rejected and deleted.
+	if rv := resources["all/invalid"].Versions[0]; !rv.Blacklisted || rv.Available {
+		t.Error("file with invalid signature was not rejected")
+	}
+	assertFileExists(t, filepath.Join(storageDir.Path, "all", "invalid_v1-0-0"), false)
+
+	// The active version is rejected, but neither blacklisted nor deleted.
+	if rv := resources["all/active"].Versions[0]; rv.Blacklisted || !rv.Available {
+		t.Error("active file with invalid signature was blacklisted or marked as unavailable")
+	}
+	if _, ok := rejectedVersions["all/active_v1-0-0"]; !ok {
+		t.Error("active file with invalid signature was not rejected")
+	}
+	assertFileExists(t, filepath.Join(storageDir.Path, "all", "active_v1-0-0"), true)
+
+	// Rejected versions are not handed out, even if there is no other version.
+	registry.SelectVersions()
+	avoidRejectedSelections()
+	if err := checkSelectedVersion("all/valid"); err != nil {
+		t.Errorf("verified version was refused: %s", err)
+	}
+	for _, identifier := range []string{"all/unsigned", "all/active"} {
+		if err := checkSelectedVersion(identifier); err == nil {
+			t.Errorf("rejected version of %s was not refused", identifier)
+		}
+	}
+}
+
+func TestAvoidRejectedSelections(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "portmaster-updates-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	pubKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousRegistry, previousKey, previousRejected := registry, updateSigningKey, rejectedVersions
+	defer func() {
+		registry, updateSigningKey, rejectedVersions = previousRegistry, previousKey, previousRejected
+	}()
+
+	updateSigningKey = pubKey
+	registry = &updater.ResourceRegistry{Name: "test"}
+	if err := registry.Initialize(utils.NewDirStructure(tmpDir, 0755)); err != nil {
+		t.Fatal(err)
+	}
+	for _, version := range []string{"1.0.0", "1.1.0"} {
+		if err := registry.AddResource("all/file", version, true, version == "1.1.0", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	res := registry.Export()["all/file"]
+	res.ActiveVersion = res.Versions[0]
+
+	// The active current release was rejected, the previous version is
+	// selected instead.
+	rejectedVersions = map[string]struct{}{"all/file_v1-1-0": {}}
+	registry.SelectVersions()
+	avoidRejectedSelections()
+	if res.SelectedVersion.VersionNumber != "1.0.0" {
+		t.Errorf("expected the previous version to be selected, got %s", res.SelectedVersion.VersionNumber)
+	}
+	if err := checkSelectedVersion("all/file"); err != nil {
+		t.Errorf("previous version was refused: %s", err)
+	}
+
+	// Without a signing key, no update files are used.
+	updateSigningKey = nil
+	if err := checkSelectedVersion("all/file"); err == nil {
+		t.Error("update file was not refused without a signing key")
+	}
+}
+
+func TestVerifyLocalUpdateSignatures(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "portmaster-updates-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	pubKey, privKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Signatures must not be fetched.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("signature %s was fetched", r.URL.Path)
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	previousRegistry, previousDir, previousKey, previousKeyData := registry, signatureDir, updateSigningKey, updateSigningKeyData
+	previousVerified, previousRejected := verifiedVersions, rejectedVersions
+	defer func() {
+		registry, signatureDir, updateSigningKey, updateSigningKeyData = previousRegistry, previousDir, previousKey, previousKeyData
+		verifiedVersions, rejectedVersions = previousVerified, previousRejected
+	}()
+
+	registry = &updater.ResourceRegistry{
+		Name:       "test",
+		UpdateURLs: []string{server.URL},
+	}
+	storageDir := utils.NewDirStructure(filepath.Join(tmpDir, "updates"), 0755)
+	if err := registry.Initialize(storageDir); err != nil {
+		t.Fatal(err)
+	}
+	signatureDir = utils.NewDirStructure(filepath.Join(tmpDir, "signatures"), 0755)
+	updateSigningKey = pubKey
+	updateSigningKeyData = base64.StdEncoding.EncodeToString(pubKey)
+	verifiedVersions = make(map[string]*verifiedFile)
+	rejectedVersions = make(map[string]struct{})
+
+	for _, name := range []string{"saved", "unsaved"} {
+		writeFile(t, filepath.Join(storageDir.Path, "all", name+"_v1-0-0"), name)
+		if err := registry.AddResource("all/"+name, "1.0.0", true, true, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, filepath.Join(signatureDir.Path, "all", "saved_v1-0-0"+signatureSuffix), signFile(privKey, "all/saved_v1-0-0", "saved"))
+
+	verifyLocalUpdateSignatures(context.Background())
+
+	if verifiedVersions["all/saved_v1-0-0"] == nil {
+		t.Error("file with saved signature was not verified")
+	}
+	if _, ok := rejectedVersions["all/unsaved_v1-0-0"]; !ok {
+		t.Error("file without saved signature was not rejected")
+	}
+	assertFileExists(t, filepath.Join(storageDir.Path, "all", "unsaved_v1-0-0"), true)
+}
+
+// signFile returns the encoded signature of the file at the given versioned
+// path with the given content.
+func signFile(privKey ed25519.PrivateKey, versionedPath, content string) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(privKey, helper.SignedData(versionedPath, []byte(content))))
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertFileExists(t *testing.T, path string, expected bool) {
+	t.Helper()
+
+	_, err := os.Stat(path)
+	if exists := err == nil; exists != expected {
+		t.Errorf("expected %s to exist: %v, error: %v", strings.TrimPrefix(path, os.TempDir()), expected, err)
+	}
+}
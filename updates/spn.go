@@ -0,0 +1,119 @@
+package updates
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Updates are routed through the SPN by the firewall, which tunnels the
+// connections of the Portmaster that are marked here while the SPN client is
+// ready. The SPN depends on this module, so it cannot be used directly.
+
+const (
+	// spnDestinationTTL is how long a marked destination is tunneled. It
+	// only needs to cover establishing the connection.
+	spnDestinationTTL = time.Minute
+
+	spnDialTimeout = 30 * time.Second
+)
+
+var (
+	spnTransport     *http.Transport
+	spnTransportLock sync.Mutex
+
+	spnDestinations     = make(map[string]time.Time)
+	spnDestinationsLock sync.Mutex
+)
+
+// TunnelUpdateConnection returns whether a connection of the Portmaster to the
+// given destination is an update request that should be routed through the
+// SPN.
+func TunnelUpdateConnection(ip net.IP, port uint16) bool {
+	spnDestinationsLock.Lock()
+	defer spnDestinationsLock.Unlock()
+
+	key := net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+	expires, ok := spnDestinations[key]
+	if !ok {
+		return false
+	}
+	if time.Now().After(expires) {
+		delete(spnDestinations, key)
+		return false
+	}
+	return true
+}
+
+// markSPNDestination marks the destination to be tunneled through the SPN.
+func markSPNDestination(ip net.IP, port string) {
+	spnDestinationsLock.Lock()
+	defer spnDestinationsLock.Unlock()
+
+	now := time.Now()
+	for key, expires := range spnDestinations {
+		if now.After(expires) {
+			delete(spnDestinations, key)
+		}
+	}
+	spnDestinations[net.JoinHostPort(ip.String(), port)] = now.Add(spnDestinationTTL)
+}
+
+// getSPNTransport returns the transport that marks its connections to be
+// routed through the SPN, or nil if disabled.
+func getSPNTransport(parent http.RoundTripper) http.RoundTripper {
+	if !updatesViaSPN() {
+		return nil
+	}
+
+	spnTransportLock.Lock()
+	defer spnTransportLock.Unlock()
+
+	// Reuse transport, so that connections are kept alive.
+	if spnTransport != nil {
+		return spnTransport
+	}
+
+	parentTransport, ok := parent.(*http.Transport)
+	if !ok {
+		return nil
+	}
+	spnTransport = parentTransport.Clone()
+	spnTransport.DialContext = dialViaSPN
+	return spnTransport
+}
+
+// dialViaSPN resolves the address and marks the resolved destinations before
+// connecting to them, so that the firewall can identify the connections.
+func dialViaSPN(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   spnDialTimeout,
+		KeepAlive: spnDialTimeout,
+	}
+	var lastErr error
+	for _, ip := range ips {
+		markSPNDestination(ip.IP, port)
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.IP.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no addresses found for %s", host)
+	}
+	return nil, lastErr
+}
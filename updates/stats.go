@@ -0,0 +1,227 @@
+package updates
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/runtime"
+)
+
+// Update statistics are exposed via the runtime database, so that dashboards
+// and the UI can monitor the health of updates. The counters are persisted,
+// so that the monthly download volume survives restarts.
+
+const (
+	updateStatsProviderKey = "updates/stats"
+	updateStatsDBKey       = "core:updates/stats"
+
+	// statsMonthFormat is the format of the month the download volume is
+	// counted for.
+	statsMonthFormat = "2006-01"
+)
+
+var (
+	stats     *updateStatsState
+	statsLock sync.Mutex
+
+	// downloadedBytes holds the bytes downloaded since they were last added
+	// to the stats.
+	downloadedBytes uint64
+
+	pushUpdateStats runtime.PushFunc
+)
+
+// UpdateStats holds statistics about updates. It's a read-only record exposed
+// via runtime:updates/stats.
+type UpdateStats struct {
+	record.Base
+	sync.Mutex
+
+	// LastCheck holds when updates were last checked as a unix timestamp.
+	LastCheck int64
+	// LastSuccessfulCheck holds when updates were last checked successfully
+	// as a unix timestamp.
+	LastSuccessfulCheck int64
+	// LastError holds the error of the last check, if it failed.
+	LastError string `json:",omitempty"`
+	// FailedAttempts holds the number of failed checks since the last
+	// successful one.
+	FailedAttempts int
+	// TotalFailedAttempts holds the number of failed checks overall.
+	TotalFailedAttempts int
+
+	// Month is the month the download volume is counted for, in the format
+	// YYYY-MM.
+	Month string
+	// BytesDownloaded holds the bytes downloaded for updates in Month.
+	BytesDownloaded uint64
+
+	// Resources holds the versions of all resources by identifier.
+	Resources map[string]*ResourceStats
+}
+
+// ResourceStats holds the versions of a resource.
+type ResourceStats struct {
+	// Active is the version that is currently in use, if the resource is used.
+	Active string `json:",omitempty"`
+	// Selected is the version that is used next.
+	Selected string `json:",omitempty"`
+	// Available holds the number of versions available locally.
+	Available int
+}
+
+// updateStatsState holds the persisted update statistics.
+type updateStatsState struct {
+	record.Base
+	sync.Mutex
+
+	LastCheck           int64
+	LastSuccessfulCheck int64
+	LastError           string
+	FailedAttempts      int
+	TotalFailedAttempts int
+	Month               string
+	BytesDownloaded     uint64
+}
+
+func initUpdateStats() (err error) {
+	statsLock.Lock()
+	stats = loadUpdateStats()
+	statsLock.Unlock()
+
+	pushUpdateStats, err = runtime.Register(
+		updateStatsProviderKey,
+		runtime.SimpleValueGetterFunc(func(_ string) ([]record.Record, error) {
+			return []record.Record{GetUpdateStats()}, nil
+		}),
+	)
+	if err != nil {
+		return err
+	}
+
+	return module.RegisterEventHook(
+		ModuleName,
+		VersionUpdateEvent,
+		"export update stats",
+		func(_ context.Context, _ interface{}) error {
+			pushUpdateStats(GetUpdateStats())
+			return nil
+		},
+	)
+}
+
+func loadUpdateStats() *updateStatsState {
+	state := &updateStatsState{}
+
+	r, err := versionExportDB.Get(updateStatsDBKey)
+	if err == nil {
+		err = record.Unwrap(r, state)
+	}
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		log.Warningf("updates: failed to load update stats: %s", err)
+	}
+
+	state.SetKey(updateStatsDBKey)
+	return state
+}
+
+// GetUpdateStats returns the current update statistics.
+func GetUpdateStats() *UpdateStats {
+	statsLock.Lock()
+	defer statsLock.Unlock()
+
+	updateStats := &UpdateStats{
+		Resources: make(map[string]*ResourceStats),
+	}
+	updateStats.SetKey(runtime.DefaultRegistry.DatabaseName() + ":" + updateStatsProviderKey)
+
+	if stats != nil {
+		stats.Lock()
+		addDownloadedBytes(stats, time.Now())
+		updateStats.LastCheck = stats.LastCheck
+		updateStats.LastSuccessfulCheck = stats.LastSuccessfulCheck
+		updateStats.LastError = stats.LastError
+		updateStats.FailedAttempts = stats.FailedAttempts
+		updateStats.TotalFailedAttempts = stats.TotalFailedAttempts
+		updateStats.Month = stats.Month
+		updateStats.BytesDownloaded = stats.BytesDownloaded
+		stats.Unlock()
+	}
+
+	if registry != nil {
+		for identifier, res := range registry.Export() {
+			resStats := &ResourceStats{}
+			res.Lock()
+			if res.ActiveVersion != nil {
+				resStats.Active = res.ActiveVersion.VersionNumber
+			}
+			if res.SelectedVersion != nil {
+				resStats.Selected = res.SelectedVersion.VersionNumber
+			}
+			for _, rv := range res.Versions {
+				if rv.Available {
+					resStats.Available++
+				}
+			}
+			res.Unlock()
+			updateStats.Resources[identifier] = resStats
+		}
+	}
+
+	return updateStats
+}
+
+// countDownloadedBytes adds n to the download volume of updates.
+func countDownloadedBytes(n int) {
+	if n > 0 {
+		atomic.AddUint64(&downloadedBytes, uint64(n))
+	}
+}
+
+// addDownloadedBytes adds the counted bytes to the stats and starts counting
+// anew when the month changed. The stats must be locked.
+func addDownloadedBytes(state *updateStatsState, now time.Time) {
+	month := now.Format(statsMonthFormat)
+	if state.Month != month {
+		state.Month = month
+		state.BytesDownloaded = 0
+	}
+	state.BytesDownloaded += atomic.SwapUint64(&downloadedBytes, 0)
+}
+
+// recordCheckResult records the result of an update check, persists the
+// stats and pushes them to the runtime database.
+func recordCheckResult(checkErr error) {
+	statsLock.Lock()
+	state := stats
+	statsLock.Unlock()
+	if state == nil {
+		return
+	}
+
+	now := time.Now()
+	state.Lock()
+	addDownloadedBytes(state, now)
+	state.LastCheck = now.Unix()
+	if checkErr == nil {
+		state.LastSuccessfulCheck = state.LastCheck
+		state.LastError = ""
+		state.FailedAttempts = 0
+	} else {
+		state.LastError = checkErr.Error()
+		state.FailedAttempts++
+		state.TotalFailedAttempts++
+	}
+	state.Unlock()
+
+	if err := versionExportDB.Put(state); err != nil {
+		log.Warningf("updates: failed to save update stats: %s", err)
+	}
+	pushUpdateStats(GetUpdateStats())
+}
@@ -0,0 +1,208 @@
+package updates
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+)
+
+// Downloads of updates are throttled and proxied by wrapping the default HTTP
+// transport, which is used by the registry. Only requests with a context that
+// is marked with withUpdateRequest are affected, so other users of the default
+// transport are not. The transport also authorizes requests to LAN peers,
+// which are never proxied, routes requests through the SPN and resumes
+// interrupted downloads.
+
+const (
+	// downloadWindowValidationRegex matches a daily time window, eg.
+	// "02:00-06:00", or an empty string.
+	downloadWindowValidationRegex = `^(([01][0-9]|2[0-3]):[0-5][0-9]-([01][0-9]|2[0-3]):[0-5][0-9])?$`
+
+	// throttleChunkSize is the maximum amount of bytes read at once from a
+	// throttled response.
+	throttleChunkSize = 16 * 1024
+)
+
+type updateRequestContextKey struct{}
+
+var (
+	installThrottleOnce sync.Once
+	downloadLimiter     = &rateLimiter{}
+
+	windowTask *modules.Task
+)
+
+// withUpdateRequest marks the context, so that requests made with it use the
+// configured proxy and their responses are throttled to the configured
+// maximum download rate.
+func withUpdateRequest(ctx context.Context) context.Context {
+	return context.WithValue(ctx, updateRequestContextKey{}, struct{}{})
+}
+
+// installDownloadThrottle wraps the default HTTP transport with the throttling
+// and proxying transport.
+func installDownloadThrottle() {
+	installThrottleOnce.Do(func() {
+		http.DefaultTransport = &throttledTransport{
+			parent: http.DefaultTransport,
+		}
+	})
+}
+
+type throttledTransport struct {
+	parent http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (tt *throttledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	toLANPeer := isLANPeerRequest(req)
+	if req.Context().Value(updateRequestContextKey{}) == nil {
+		return tt.parent.RoundTrip(req)
+	}
+
+	transport := tt.parent
+	if !toLANPeer {
+		if proxied := getProxyTransport(tt.parent); proxied != nil {
+			transport = proxied
+		} else if tunneled := getSPNTransport(tt.parent); tunneled != nil {
+			transport = tunneled
+		}
+	}
+	// Responses of LAN peers are authenticated over the complete file, so
+	// they cannot be resumed.
+	var partial *partialDownload
+	if !toLANPeer {
+		req, partial = prepareResume(req)
+	}
+	resp, err := transport.RoundTrip(req)
+	recordMirrorResult(req, resp, err)
+	if err != nil {
+		partial.release()
+		return resp, err
+	}
+
+	// Only throttle the data that is actually downloaded.
+	resp.Body = &throttledReader{
+		ctx:    req.Context(),
+		parent: resp.Body,
+	}
+	partial.apply(resp)
+	withProgress(req, resp)
+	return resp, nil
+}
+
+type throttledReader struct {
+	ctx    context.Context
+	parent io.ReadCloser
+}
+
+func (tr *throttledReader) Read(p []byte) (n int, err error) {
+	rate := maxDownloadRate() * 1024
+	if rate <= 0 {
+		n, err = tr.parent.Read(p)
+		countDownloadedBytes(n)
+		return n, err
+	}
+
+	// Read in small chunks to keep the rate steady.
+	if len(p) > throttleChunkSize {
+		p = p[:throttleChunkSize]
+	}
+	n, err = tr.parent.Read(p)
+	countDownloadedBytes(n)
+	if n > 0 {
+		if waitErr := downloadLimiter.wait(tr.ctx, n, rate); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+func (tr *throttledReader) Close() error {
+	return tr.parent.Close()
+}
+
+// rateLimiter spreads reads over time. It is shared by all throttled
+// responses, so that the rate applies to all downloads together.
+type rateLimiter struct {
+	lock sync.Mutex
+	next time.Time
+}
+
+// wait waits until the given amount of bytes may be read at the given rate in
+// bytes per second.
+func (rl *rateLimiter) wait(ctx context.Context, n int, rate int64) error {
+	rl.lock.Lock()
+	now := time.Now()
+	if rl.next.Before(now) {
+		rl.next = now
+	}
+	delay := rl.next.Sub(now)
+	rl.next = rl.next.Add(time.Duration(int64(n) * int64(time.Second) / rate))
+	rl.lock.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// inDownloadWindow returns whether updates may be downloaded at the given
+// time and, if not, when the next window starts.
+func inDownloadWindow(now time.Time) (ok bool, nextStart time.Time) {
+	ok, nextStart, err := inTimeWindow(downloadWindow(), now)
+	if err != nil {
+		log.Warningf("updates: ignoring invalid update time window: %s", err)
+		return true, time.Time{}
+	}
+	return ok, nextStart
+}
+
+// inTimeWindow returns whether the given time is within the given daily time
+// window in the format "15:04-15:04" and, if not, when the next window starts.
+// An empty window includes all times.
+func inTimeWindow(window string, now time.Time) (ok bool, nextStart time.Time, err error) {
+	if window == "" {
+		return true, time.Time{}, nil
+	}
+
+	var startHour, startMinute, endHour, endMinute int
+	if _, err := fmt.Sscanf(window, "%d:%d-%d:%d", &startHour, &startMinute, &endHour, &endMinute); err != nil {
+		return false, time.Time{}, fmt.Errorf("invalid time window %q: %w", window, err)
+	}
+	start := time.Date(now.Year(), now.Month(), now.Day(), startHour, startMinute, 0, 0, now.Location())
+	end := time.Date(now.Year(), now.Month(), now.Day(), endHour, endMinute, 0, 0, now.Location())
+
+	switch {
+	case !end.After(start):
+		// The window spans midnight.
+		if !now.Before(start) || now.Before(end) {
+			return true, time.Time{}, nil
+		}
+	case !now.Before(start) && now.Before(end):
+		return true, time.Time{}, nil
+	}
+
+	if !start.After(now) {
+		start = start.AddDate(0, 0, 1)
+	}
+	return false, start, nil
+}
+
+// scheduleForDownloadWindow checks for updates at the start of the next
+// download window.
+func scheduleForDownloadWindow(nextStart time.Time) {
+	log.Infof("updates: outside of update time window, postponing update check to %s", nextStart.Format(time.RFC822))
+	windowTask.Schedule(nextStart)
+}
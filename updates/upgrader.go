@@ -57,6 +57,11 @@ func upgrader(_ context.Context, _ interface{}) error {
 	}
 	defer upgraderActive.SetTo(false)
 
+	// Binaries are upgraded by the package manager.
+	if ManagedExternally() {
+		return nil
+	}
+
 	// upgrade portmaster-start
 	err := upgradePortmasterStart()
 	if err != nil {
@@ -130,6 +135,7 @@ func upgradeCoreNotify() error {
 		n.SetActionFunction(upgradeCoreNotifyActionHandler)
 
 		log.Debugf("updates: new portmaster version available, sending notification to user")
+		markRestartPending()
 	}
 
 	return nil